@@ -35,6 +35,7 @@ import (
 	"github.com/classzz/go-classzz-v2/core/types"
 	"github.com/classzz/go-classzz-v2/crypto"
 	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/internal/era"
 	"github.com/classzz/go-classzz-v2/log"
 	"github.com/classzz/go-classzz-v2/metrics"
 	"github.com/classzz/go-classzz-v2/node"
@@ -49,6 +50,7 @@ var (
 		ArgsUsage: "<genesisPath>",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.StateSchemeFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
@@ -56,6 +58,9 @@ The init command initializes a new genesis block and definition for the network.
 This is a destructive action and changes the network in which you will be
 participating.
 
+The state storage scheme of a new datadir can be selected with --state.scheme,
+it can't be changed once the datadir is initialised.
+
 It expects the genesis file as argument.`,
 	}
 	dumpGenesisCommand = cli.Command{
@@ -125,6 +130,43 @@ Optional second and third arguments control the first and
 last block to write. In this mode, the file will be appended
 if already existing. If the file ends with .gz, the output will
 be gzipped.`,
+	}
+	exportHistoryCommand = cli.Command{
+		Action:    utils.MigrateFlags(exportHistory),
+		Name:      "export-history",
+		Usage:     "Export blockchain history into Era1 archives",
+		ArgsUsage: "<dir> <blockNumFirst> <blockNumLast>",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The export-history command writes the headers, bodies, receipts and total
+difficulties of the given block range into Era1 archives of 8192 blocks each,
+along with the list of their accumulator roots. The first block must start an
+epoch, a partial last epoch replaces earlier exports of the same epoch.`,
+	}
+	importHistoryCommand = cli.Command{
+		Action:    utils.MigrateFlags(importHistory),
+		Name:      "import-history",
+		Usage:     "Import blockchain history from Era1 archives",
+		ArgsUsage: "<dir>",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+			utils.HistoryAccumulatorsFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The import-history command imports the chain history from the Era1 archives in
+the given directory. Every archive is verified and checked against a list of
+known accumulator roots before import, so the archives can be fetched from an
+untrusted source as long as the list is trusted. The list must be given with
+--history.accumulators, the one shipped with the archives is not trusted. Only blocks and receipts are
+imported, the state needs to be synced afterwards.`,
 	}
 	importPreimagesCommand = cli.Command{
 		Action:    utils.MigrateFlags(importPreimages),
@@ -194,6 +236,10 @@ func initGenesis(ctx *cli.Context) error {
 	if err := json.NewDecoder(file).Decode(genesis); err != nil {
 		utils.Fatalf("invalid genesis file: %v", err)
 	}
+	scheme := ctx.GlobalString(utils.StateSchemeFlag.Name)
+	if scheme != rawdb.HashScheme && scheme != rawdb.PathScheme {
+		utils.Fatalf("Invalid choice for state.scheme '%s', allowed 'hash' or 'path'", scheme)
+	}
 	// Open and initialise both full and light databases
 	stack, _ := makeConfigNode(ctx)
 	defer stack.Close()
@@ -203,6 +249,12 @@ func initGenesis(ctx *cli.Context) error {
 		if err != nil {
 			utils.Fatalf("Failed to open database: %v", err)
 		}
+		// The light client retrieves the state on demand, keyed by hash
+		if name == "chaindata" {
+			if err := initStateScheme(chaindb, scheme); err != nil {
+				utils.Fatalf("Failed to set state scheme: %v", err)
+			}
+		}
 		_, hash, err := core.SetupGenesisBlock(chaindb, genesis)
 		if err != nil {
 			utils.Fatalf("Failed to write genesis block: %v", err)
@@ -213,6 +265,24 @@ func initGenesis(ctx *cli.Context) error {
 	return nil
 }
 
+// initStateScheme records the state storage scheme of a new database, refusing
+// to change the scheme of an already initialised one.
+func initStateScheme(db czzdb.Database, scheme string) error {
+	stored := rawdb.ReadStateScheme(db)
+	if stored == "" {
+		if rawdb.ReadCanonicalHash(db, 0) != (common.Hash{}) {
+			stored = rawdb.HashScheme // Initialised before the scheme was recorded
+		} else {
+			rawdb.WriteStateScheme(db, scheme)
+			return nil
+		}
+	}
+	if stored != scheme {
+		return fmt.Errorf("database already initialised with the %s scheme, can't switch to %s", stored, scheme)
+	}
+	return nil
+}
+
 func dumpGenesis(ctx *cli.Context) error {
 	// TODO(rjl493456442) support loading from the custom datadir
 	genesis := utils.MakeGenesis(ctx)
@@ -342,6 +412,69 @@ func exportChain(ctx *cli.Context) error {
 	return nil
 }
 
+// historyNetwork returns the network name used in the Era1 archive filenames.
+func historyNetwork(ctx *cli.Context) string {
+	if ctx.GlobalBool(utils.TestnetFlag.Name) {
+		return "testnet"
+	}
+	return "mainnet"
+}
+
+func exportHistory(ctx *cli.Context) error {
+	if len(ctx.Args()) != 3 {
+		utils.Fatalf("Arguments required: <dir> <blockNumFirst> <blockNumLast>")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, _ := utils.MakeChain(ctx, stack)
+	start := time.Now()
+
+	first, ferr := strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+	last, lerr := strconv.ParseUint(ctx.Args().Get(2), 10, 64)
+	if ferr != nil || lerr != nil {
+		utils.Fatalf("Export error in parsing parameters: block number not an integer\n")
+	}
+	if first > last {
+		utils.Fatalf("Export error: first block %d larger than last block %d\n", first, last)
+	}
+	if err := utils.ExportHistory(chain, ctx.Args().First(), historyNetwork(ctx), first, last); err != nil {
+		utils.Fatalf("Export error: %v\n", err)
+	}
+	fmt.Printf("Export done in %v\n", time.Since(start))
+	return nil
+}
+
+func importHistory(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	dir := ctx.Args().First()
+
+	// The accumulator list is the root of trust, it can't come with the archives
+	fn := ctx.GlobalString(utils.HistoryAccumulatorsFlag.Name)
+	if fn == "" {
+		utils.Fatalf("The list of trusted accumulator roots is required (--%s)", utils.HistoryAccumulatorsFlag.Name)
+	}
+	accumulators, err := era.ReadAccumulators(fn)
+	if err != nil {
+		utils.Fatalf("Failed to read accumulator list: %v", err)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, db := utils.MakeChain(ctx, stack)
+	defer db.Close()
+
+	start := time.Now()
+	if err := utils.ImportHistory(chain, db, dir, historyNetwork(ctx), accumulators); err != nil {
+		utils.Fatalf("Import error: %v\n", err)
+	}
+	chain.Stop()
+	fmt.Printf("Import done in %v\n", time.Since(start))
+	return nil
+}
+
 // importPreimages imports preimage data from the specified file.
 func importPreimages(ctx *cli.Context) error {
 	if len(ctx.Args()) < 1 {
@@ -382,36 +515,9 @@ func exportPreimages(ctx *cli.Context) error {
 
 func parseDumpConfig(ctx *cli.Context, stack *node.Node) (*state.DumpConfig, czzdb.Database, common.Hash, error) {
 	db := utils.MakeChainDatabase(ctx, stack, true)
-	var header *types.Header
-	if ctx.NArg() > 1 {
-		return nil, nil, common.Hash{}, fmt.Errorf("expected 1 argument (number or hash), got %d", ctx.NArg())
-	}
-	if ctx.NArg() == 1 {
-		arg := ctx.Args().First()
-		if hashish(arg) {
-			hash := common.HexToHash(arg)
-			if number := rawdb.ReadHeaderNumber(db, hash); number != nil {
-				header = rawdb.ReadHeader(db, hash, *number)
-			} else {
-				return nil, nil, common.Hash{}, fmt.Errorf("block %x not found", hash)
-			}
-		} else {
-			number, err := strconv.Atoi(arg)
-			if err != nil {
-				return nil, nil, common.Hash{}, err
-			}
-			if hash := rawdb.ReadCanonicalHash(db, uint64(number)); hash != (common.Hash{}) {
-				header = rawdb.ReadHeader(db, hash, uint64(number))
-			} else {
-				return nil, nil, common.Hash{}, fmt.Errorf("header for block %d not found", number)
-			}
-		}
-	} else {
-		// Use latest
-		header = rawdb.ReadHeadHeader(db)
-	}
-	if header == nil {
-		return nil, nil, common.Hash{}, errors.New("no head block found")
+	header, err := parseHeaderArg(ctx, db)
+	if err != nil {
+		return nil, nil, common.Hash{}, err
 	}
 	startArg := common.FromHex(ctx.String(utils.StartKeyFlag.Name))
 	var start common.Hash
@@ -446,7 +552,7 @@ func dump(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	state, err := state.New(root, state.NewDatabase(db), nil)
+	state, err := state.New(root, state.NewDatabaseWithConfig(db, utils.MakeTrieConfig(db)), nil)
 	if err != nil {
 		return err
 	}
@@ -463,6 +569,43 @@ func dump(ctx *cli.Context) error {
 	return nil
 }
 
+// parseHeaderArg resolves the optional block number or hash argument of a
+// command into a header, defaulting to the head header.
+func parseHeaderArg(ctx *cli.Context, db czzdb.Database) (*types.Header, error) {
+	var header *types.Header
+	if ctx.NArg() > 1 {
+		return nil, fmt.Errorf("expected 1 argument (number or hash), got %d", ctx.NArg())
+	}
+	if ctx.NArg() == 1 {
+		arg := ctx.Args().First()
+		if hashish(arg) {
+			hash := common.HexToHash(arg)
+			if number := rawdb.ReadHeaderNumber(db, hash); number != nil {
+				header = rawdb.ReadHeader(db, hash, *number)
+			} else {
+				return nil, fmt.Errorf("block %x not found", hash)
+			}
+		} else {
+			number, err := strconv.Atoi(arg)
+			if err != nil {
+				return nil, err
+			}
+			if hash := rawdb.ReadCanonicalHash(db, uint64(number)); hash != (common.Hash{}) {
+				header = rawdb.ReadHeader(db, hash, uint64(number))
+			} else {
+				return nil, fmt.Errorf("header for block %d not found", number)
+			}
+		}
+	} else {
+		// Use latest
+		header = rawdb.ReadHeadHeader(db)
+	}
+	if header == nil {
+		return nil, errors.New("no head block found")
+	}
+	return header, nil
+}
+
 // hashish returns true for strings that look like hashes.
 func hashish(x string) bool {
 	_, err := strconv.Atoi(x)
@@ -17,11 +17,17 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/classzz/go-classzz-v2/cmd/utils"
@@ -29,7 +35,9 @@ import (
 	"github.com/classzz/go-classzz-v2/common/hexutil"
 	"github.com/classzz/go-classzz-v2/console/prompt"
 	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/state/snapshot"
 	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/czzdb/remotedb"
 	"github.com/classzz/go-classzz-v2/log"
 	"github.com/classzz/go-classzz-v2/trie"
 	"gopkg.in/urfave/cli.v1"
@@ -62,6 +70,9 @@ Remove blockchain and state databases`,
 			dbPutCmd,
 			dbGetSlotsCmd,
 			dbDumpFreezerIndex,
+			dbPruneHistoryCmd,
+			dbVerifyCmd,
+			dbServeAncientCmd,
 		},
 	}
 	dbInspectCmd = cli.Command{
@@ -171,6 +182,93 @@ WARNING: This is a low-level operation which may cause database corruption!`,
 		},
 		Description: "This command displays information about the freezer index.",
 	}
+	dbPruneHistoryCmd = cli.Command{
+		Action: utils.MigrateFlags(dbPruneHistory),
+		Name:   "prune-history",
+		Usage:  "Prune the bodies and receipts of old blocks from the ancient store",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.SyncModeFlag,
+			utils.MainnetFlag,
+			utils.TestnetFlag,
+			dbPruneKeepFlag,
+		},
+		Description: `This command discards the bodies and receipts of all ancient blocks
+except for the most recent --keep ones, retaining the headers of the entire chain.
+The transaction indices of the pruned blocks are removed too.
+WARNING: The pruned chain history can only be restored by resyncing the node!`,
+	}
+	dbVerifyCmd = cli.Command{
+		Action: utils.MigrateFlags(dbVerify),
+		Name:   "verify",
+		Usage:  "Check the consistency of the chain data, optionally repairing it",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.SyncModeFlag,
+			utils.MainnetFlag,
+			utils.TestnetFlag,
+			dbRepairFlag,
+		},
+		Description: `This command walks the canonical chain and checks the hash mappings, headers,
+total difficulties, bodies, receipts and transaction indices belonging to it, the
+ancient store, the snapshot journal and the TeWaka used item records, reporting
+the missing, dangling and corrupt entries. A JSON summary is printed at the end,
+the command fails if any issue is left unrepaired.
+
+With --repair, the issues which can be derived from the remaining data are fixed
+and dangling entries are deleted. A corrupt snapshot is discarded to be rebuilt
+on the next startup. Missing or corrupt headers, bodies and receipts can only be
+restored by resyncing.`,
+	}
+	dbServeAncientCmd = cli.Command{
+		Action: utils.MigrateFlags(dbServeAncient),
+		Name:   "serve-ancient",
+		Usage:  "Serve the ancient store to other nodes",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.SyncModeFlag,
+			utils.MainnetFlag,
+			utils.TestnetFlag,
+			dbServeAddrFlag,
+			dbServeReadOnlyFlag,
+			dbServeTruncateFlag,
+		},
+		Description: `This command opens the ancient store (--datadir.ancient, by default the one
+inside chaindata) and serves it over HTTP, so that several nodes can share it by
+starting with --datadir.ancient=remote://<addr>. The store must not be opened by
+a node directly while it is served.
+
+Nodes following the same chain can all freeze their blocks into the shared store,
+blocks already stored are accepted again. The nodes never truncate the shared
+store, the ones behind it keep the blocks frozen by the others. Discarding stored
+items over RPC is refused unless --truncate is given. With --readonly, all
+mutations of the store are rejected.`,
+	}
+	dbServeAddrFlag = cli.StringFlag{
+		Name:  "addr",
+		Usage: "Listening address of the ancient store server",
+		Value: "127.0.0.1:8560",
+	}
+	dbServeReadOnlyFlag = cli.BoolFlag{
+		Name:  "readonly",
+		Usage: "Reject all mutations of the served ancient store",
+	}
+	dbServeTruncateFlag = cli.BoolFlag{
+		Name:  "truncate",
+		Usage: "Permit discarding the items of the served ancient store",
+	}
+	dbRepairFlag = cli.BoolFlag{
+		Name:  "repair",
+		Usage: "Fix the issues found instead of only reporting them",
+	}
+	dbPruneKeepFlag = cli.Uint64Flag{
+		Name:  "keep",
+		Usage: "Number of recent blocks to retain bodies and receipts for",
+		Value: 90000,
+	}
 )
 
 func removeDB(ctx *cli.Context) error {
@@ -427,7 +525,7 @@ func dbDumpTrie(ctx *cli.Context) error {
 			return err
 		}
 	}
-	theTrie, err := trie.New(stRoot, trie.NewDatabase(db))
+	theTrie, err := trie.New(stRoot, trie.NewDatabaseWithConfig(db, utils.MakeTrieConfig(db)))
 	if err != nil {
 		return err
 	}
@@ -483,3 +581,139 @@ func freezerInspect(ctx *cli.Context) error {
 	}
 	return nil
 }
+
+// dbPruneHistory discards the bodies and receipts of the ancient blocks which
+// are older than the number of blocks to keep.
+func dbPruneHistory(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, false)
+	defer db.Close()
+
+	head := rawdb.ReadHeadBlockHash(db)
+	number := rawdb.ReadHeaderNumber(db, head)
+	if number == nil {
+		return errors.New("head block missing")
+	}
+	keep := ctx.Uint64(dbPruneKeepFlag.Name)
+	if *number < keep {
+		log.Info("Chain shorter than retained history, nothing to prune", "head", *number, "keep", keep)
+		return nil
+	}
+	target := *number - keep + 1
+
+	frozen, err := db.Ancients()
+	if err != nil {
+		return err
+	}
+	if target > frozen {
+		target = frozen
+	}
+	tail, err := db.AncientTail()
+	if err != nil {
+		return err
+	}
+	if tail >= target {
+		log.Info("Chain history already pruned", "tail", tail)
+		return nil
+	}
+	// Remove the transaction indices of the pruned blocks first, since they
+	// can't be located anymore afterwards
+	if indexTail := rawdb.ReadTxIndexTail(db); indexTail != nil && *indexTail < target {
+		rawdb.UnindexTransactions(db, *indexTail, target, nil)
+	}
+	start := time.Now()
+	if err := db.TruncateAncientTail(target); err != nil {
+		return err
+	}
+	log.Info("Pruned chain history", "from", tail, "tail", target, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// dbVerify checks the consistency of the chain data, repairing it if requested,
+// and prints a summary of the issues found.
+func dbVerify(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	repair := ctx.Bool(dbRepairFlag.Name)
+	db := utils.MakeChainDatabase(ctx, stack, !repair)
+	defer db.Close()
+
+	report, err := rawdb.VerifyDatabase(db, trie.NewStackTrie(nil), repair)
+	if err != nil {
+		return err
+	}
+	// The snapshot journal can only be parsed by the snapshot package
+	report.Check(rawdb.VerifySnapshot)
+	if err := snapshot.CheckJournal(db); err != nil {
+		report.Report(&rawdb.VerifyIssue{
+			Category: rawdb.VerifySnapshot,
+			Kind:     rawdb.IssueCorrupt,
+			Detail:   fmt.Sprintf("Snapshot can't be loaded: %v", err),
+			Repaired: repair,
+		})
+		if repair {
+			// Without a disk layer root, the snapshot is rebuilt on startup
+			rawdb.DeleteSnapshotRoot(db)
+			rawdb.DeleteSnapshotJournal(db)
+		}
+	}
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+
+	if unrepaired := report.Unrepaired(); unrepaired > 0 {
+		return fmt.Errorf("database has %d unrepaired issues", unrepaired)
+	}
+	return nil
+}
+
+// dbServeAncient serves the local ancient store over HTTP until interrupted.
+func dbServeAncient(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	dir := ctx.GlobalString(utils.AncientFlag.Name)
+	switch {
+	case dir == "":
+		dir = filepath.Join(stack.ResolvePath("chaindata"), "ancient")
+	case remotedb.IsRemote(dir):
+		return errors.New("can't serve a remote ancient store")
+	case !filepath.IsAbs(dir):
+		dir = stack.ResolvePath(dir)
+	}
+	store, err := rawdb.NewAncientStore(dir, "", ctx.Bool(dbServeReadOnlyFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	rpcsrv, err := remotedb.NewServer(store, ctx.Bool(dbServeTruncateFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer rpcsrv.Stop()
+
+	listener, err := net.Listen("tcp", ctx.String(dbServeAddrFlag.Name))
+	if err != nil {
+		return err
+	}
+	httpsrv := &http.Server{Handler: rpcsrv}
+	go httpsrv.Serve(listener)
+	defer httpsrv.Close()
+
+	frozen, _ := store.Ancients()
+	log.Info("Serving ancient store", "dir", dir, "addr", listener.Addr(), "items", frozen)
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	<-sigc
+
+	log.Info("Stopping ancient store server")
+	return nil
+}
@@ -20,6 +20,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"time"
 
@@ -29,6 +31,7 @@ import (
 	"github.com/classzz/go-classzz-v2/core/state"
 	"github.com/classzz/go-classzz-v2/core/state/pruner"
 	"github.com/classzz/go-classzz-v2/core/state/snapshot"
+	"github.com/classzz/go-classzz-v2/core/vm"
 	"github.com/classzz/go-classzz-v2/crypto"
 	"github.com/classzz/go-classzz-v2/log"
 	"github.com/classzz/go-classzz-v2/rlp"
@@ -154,10 +157,63 @@ as the backend data source, making this command a lot faster.
 
 The argument is interpreted as block number or hash. If none is provided, the latest
 block is used.
+`,
+			},
+			{
+				Name:      "export",
+				Usage:     "Export a range of the state into a compact binary file",
+				ArgsUsage: "<filename> [<root>]",
+				Action:    utils.MigrateFlags(exportSnapshotState),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.ExcludeCodeFlag,
+					utils.ExcludeStorageFlag,
+					utils.StartKeyFlag,
+					utils.DumpLimitFlag,
+					snapshotEndFlag,
+					snapshotResumeFlag,
+				},
+				Description: `
+gczz snapshot export <filename> [<state-root>]
+will stream the accounts of the given state (HEAD by default) from the snapshot
+into a compact binary file, along with their contract codes and storage slots
+unless excluded. The accounts are exported in hash order, from --start to --end,
+at most --limit of them; the hash to continue the next range from is logged at
+the end. An interrupted export is continued with --resume.
+
+The TeWaka bridge state is not tracked by the snapshot, the storage of the TeWaka
+account is always read from its trie.
+`,
+			},
+			{
+				Name:      "import",
+				Usage:     "Rebuild the state tries from exported state files",
+				ArgsUsage: "<filename> [<filename> ...]",
+				Action:    utils.MigrateFlags(importSnapshotState),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+				},
+				Description: `
+gczz snapshot import <filename> [<filename> ...]
+will rebuild the account and storage tries of a state exported by 'gczz snapshot
+export' into the database and verify the resulting state root. The files must
+cover the entire account range and are imported in the given order.
 `,
 			},
 		},
 	}
+	snapshotEndFlag = cli.StringFlag{
+		Name:  "end",
+		Usage: "Last account to export. Either a hash or address",
+	}
+	snapshotResumeFlag = cli.BoolFlag{
+		Name:  "resume",
+		Usage: "Continue an interrupted export into the same file",
+	}
 )
 
 func pruneState(ctx *cli.Context) error {
@@ -199,7 +255,7 @@ func verifyState(ctx *cli.Context) error {
 		log.Error("Failed to load head block")
 		return errors.New("no head block")
 	}
-	snaptree, err := snapshot.New(chaindb, trie.NewDatabase(chaindb), 256, headBlock.Root(), false, false, false)
+	snaptree, err := snapshot.New(chaindb, trie.NewDatabaseWithConfig(chaindb, utils.MakeTrieConfig(chaindb)), 256, headBlock.Root(), false, false, false)
 	if err != nil {
 		log.Error("Failed to open snapshot tree", "err", err)
 		return err
@@ -256,7 +312,7 @@ func traverseState(ctx *cli.Context) error {
 		root = headBlock.Root()
 		log.Info("Start traversing the state", "root", root, "number", headBlock.NumberU64())
 	}
-	triedb := trie.NewDatabase(chaindb)
+	triedb := trie.NewDatabaseWithConfig(chaindb, utils.MakeTrieConfig(chaindb))
 	t, err := trie.NewSecure(root, triedb)
 	if err != nil {
 		log.Error("Failed to open trie", "root", root, "err", err)
@@ -278,7 +334,7 @@ func traverseState(ctx *cli.Context) error {
 			return err
 		}
 		if acc.Root != emptyRoot {
-			storageTrie, err := trie.NewSecure(acc.Root, triedb)
+			storageTrie, err := trie.NewSecureWithOwner(common.BytesToHash(accIter.Key), acc.Root, triedb)
 			if err != nil {
 				log.Error("Failed to open storage trie", "root", acc.Root, "err", err)
 				return err
@@ -346,7 +402,7 @@ func traverseRawState(ctx *cli.Context) error {
 		root = headBlock.Root()
 		log.Info("Start traversing the state", "root", root, "number", headBlock.NumberU64())
 	}
-	triedb := trie.NewDatabase(chaindb)
+	triedb := trie.NewDatabaseWithConfig(chaindb, utils.MakeTrieConfig(chaindb))
 	t, err := trie.NewSecure(root, triedb)
 	if err != nil {
 		log.Error("Failed to open trie", "root", root, "err", err)
@@ -384,7 +440,7 @@ func traverseRawState(ctx *cli.Context) error {
 				return errors.New("invalid account")
 			}
 			if acc.Root != emptyRoot {
-				storageTrie, err := trie.NewSecure(acc.Root, triedb)
+				storageTrie, err := trie.NewSecureWithOwner(common.BytesToHash(accIter.LeafKey()), acc.Root, triedb)
 				if err != nil {
 					log.Error("Failed to open storage trie", "root", acc.Root, "err", err)
 					return errors.New("missing storage trie")
@@ -451,7 +507,7 @@ func dumpState(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	snaptree, err := snapshot.New(db, trie.NewDatabase(db), 256, root, false, false, false)
+	snaptree, err := snapshot.New(db, trie.NewDatabaseWithConfig(db, utils.MakeTrieConfig(db)), 256, root, false, false, false)
 	if err != nil {
 		return err
 	}
@@ -512,3 +568,147 @@ func dumpState(ctx *cli.Context) error {
 		"elapsed", common.PrettyDuration(time.Since(start)))
 	return nil
 }
+
+// parseAccountKey resolves an account hash or address argument into the hash of
+// the account in the state trie.
+func parseAccountKey(input string) (common.Hash, error) {
+	key := common.FromHex(input)
+	switch len(key) {
+	case 0:
+		return common.Hash{}, nil
+	case common.HashLength:
+		return common.BytesToHash(key), nil
+	case common.AddressLength:
+		return crypto.Keccak256Hash(key), nil
+	default:
+		return common.Hash{}, fmt.Errorf("invalid account: %x. 20 or 32 hex-encoded bytes required", key)
+	}
+}
+
+func exportSnapshotState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	if ctx.NArg() < 1 || ctx.NArg() > 2 {
+		return errors.New("expected export filename and optional state root")
+	}
+	chaindb := utils.MakeChainDatabase(ctx, stack, true)
+	defer chaindb.Close()
+
+	headBlock := rawdb.ReadHeadBlock(chaindb)
+	if headBlock == nil {
+		log.Error("Failed to load head block")
+		return errors.New("no head block")
+	}
+	root := headBlock.Root()
+	if ctx.NArg() == 2 {
+		var err error
+		if root, err = parseRoot(ctx.Args()[1]); err != nil {
+			log.Error("Failed to resolve state root", "err", err)
+			return err
+		}
+	}
+	triedb := trie.NewDatabaseWithConfig(chaindb, utils.MakeTrieConfig(chaindb))
+	snaptree, err := snapshot.New(chaindb, triedb, 256, headBlock.Root(), false, false, false)
+	if err != nil {
+		log.Error("Failed to open snapshot tree", "err", err)
+		return err
+	}
+	config := &snapshot.ExportConfig{
+		Max:     ctx.Uint64(utils.DumpLimitFlag.Name),
+		Code:    !ctx.Bool(utils.ExcludeCodeFlag.Name),
+		Storage: !ctx.Bool(utils.ExcludeStorageFlag.Name),
+	}
+	if config.Start, err = parseAccountKey(ctx.String(utils.StartKeyFlag.Name)); err != nil {
+		return err
+	}
+	if config.Limit, err = parseAccountKey(ctx.String(snapshotEndFlag.Name)); err != nil {
+		return err
+	}
+	if config.Storage {
+		config.TrieStorage = map[common.Hash]bool{crypto.Keccak256Hash(vm.TeWaKaAddress.Bytes()): true}
+	}
+	// Open the export, skipping the part already written by an interrupted run
+	fn := ctx.Args().First()
+	var out *os.File
+	if _, err := os.Stat(fn); err == nil && ctx.Bool(snapshotResumeFlag.Name) {
+		if out, err = os.OpenFile(fn, os.O_RDWR, 0644); err != nil {
+			return err
+		}
+		defer out.Close()
+
+		progress, err := snapshot.ScanExport(out)
+		if err != nil {
+			return fmt.Errorf("can't resume export: %v", err)
+		}
+		header := progress.Header
+		switch {
+		case progress.Complete:
+			log.Info("State export already complete", "next", progress.Next, "done", progress.Done)
+			return nil
+		case header.Root != root:
+			return fmt.Errorf("can't resume export of state %x at %x", header.Root, root)
+		case header.Code != config.Code || header.Storage != config.Storage:
+			return errors.New("can't resume export with different content")
+		}
+		if err := out.Truncate(progress.Offset); err != nil {
+			return err
+		}
+		if _, err := out.Seek(progress.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		config.Start, config.Limit, config.Resume = progress.Next, header.Limit, true
+		log.Info("Resuming state export", "root", root, "at", progress.Next)
+	} else {
+		if out, err = os.Create(fn); err != nil {
+			return err
+		}
+		defer out.Close()
+		log.Info("Exporting state", "root", root, "start", config.Start)
+	}
+	stats, err := snapshot.ExportState(out, snaptree, triedb, root, config)
+	if err != nil {
+		log.Error("Failed to export state", "err", err)
+		return err
+	}
+	if !stats.Done {
+		log.Info("Continue with the next range", "start", stats.Next)
+	}
+	return nil
+}
+
+func importSnapshotState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	if ctx.NArg() < 1 {
+		return errors.New("expected export filenames")
+	}
+	chaindb := utils.MakeChainDatabase(ctx, stack, false)
+	defer chaindb.Close()
+
+	importer, err := snapshot.NewStateImporter(chaindb)
+	if err != nil {
+		return err
+	}
+	for _, fn := range ctx.Args() {
+		in, err := os.Open(fn)
+		if err != nil {
+			return err
+		}
+		log.Info("Importing state export", "file", fn)
+		err = importer.Import(in)
+		in.Close()
+		if err != nil {
+			log.Error("Failed to import state", "file", fn, "err", err)
+			return err
+		}
+	}
+	root, err := importer.Commit()
+	if err != nil {
+		log.Error("Failed to import state", "err", err)
+		return err
+	}
+	log.Info("State imported", "root", root)
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of go-classzz-v2.
+//
+// go-classzz-v2 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-classzz-v2 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-classzz-v2. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/classzz/go-classzz-v2/cmd/utils"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/internal/czzapi"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	tewakaCommand = cli.Command{
+		Name:      "tewaka",
+		Usage:     "Inspect the TeWaka bridge state",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Subcommands: []cli.Command{
+			tewakaReservesCmd,
+		},
+	}
+	tewakaReservesCmd = cli.Command{
+		Action:    utils.MigrateFlags(tewakaReserves),
+		Name:      "reserves",
+		Usage:     "Report whether the TeWaka pools cover the outstanding convert items",
+		ArgsUsage: "[? <blockHash> | <blockNum>]",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+			utils.MainnetFlag,
+			utils.TestnetFlag,
+		},
+		Description: `
+This command reports, for each TeWaka network, the pool balance, the pending
+outgoing amount and fees of its convert items, and the resulting surplus or
+deficit at the given block (or latest, if none provided). The output is the
+same JSON as the tewaka_getReserves RPC and includes the Merkle proofs needed to
+verify it against the block's state root.`,
+	}
+)
+
+func tewakaReserves(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, true)
+	defer db.Close()
+
+	header, err := parseHeaderArg(ctx, db)
+	if err != nil {
+		return err
+	}
+	statedb, err := state.New(header.Root, state.NewDatabaseWithConfig(db, utils.MakeTrieConfig(db)), nil)
+	if err != nil {
+		return err
+	}
+	reserves, err := czzapi.TeWakaReserves(statedb, header)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(reserves)
+}
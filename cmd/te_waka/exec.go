@@ -2,9 +2,9 @@ package main
 
 import (
 	"encoding/hex"
+	"fmt"
 	"github.com/classzz/go-classzz-v2/cmd/utils"
 	"github.com/classzz/go-classzz-v2/common"
-	"github.com/classzz/go-classzz-v2/core/vm"
 	"gopkg.in/urfave/cli.v1"
 	"math/big"
 )
@@ -13,14 +13,18 @@ var MortgageCommand = cli.Command{
 	Name:   "mortgage",
 	Usage:  "mortgage validator deposit staking count",
 	Action: utils.MigrateFlags(Mortgage),
-	Flags:  append(TeWakaFlags),
+	Flags:  TeWakaFlags,
 }
 
 func Mortgage(ctx *cli.Context) error {
-
-	loadPrivate(ctx)
-
-	conn, url := dialConn(ctx)
+	setupOutput(ctx)
+	if err := loadSigner(ctx); err != nil {
+		return err
+	}
+	conn, url, err := dialConn(ctx)
+	if err != nil {
+		return err
+	}
 
 	pubKey := ctx.GlobalString(MortgageFlags[0].GetName())
 	pubKeyb, _ := hex.DecodeString(pubKey)
@@ -30,7 +34,7 @@ func Mortgage(ctx *cli.Context) error {
 	stakingAmount := ctx.GlobalInt64(MortgageFlags[2].GetName())
 	Amount := czzToWei(stakingAmount)
 	if stakingAmount < TeWakaAmount {
-		printError("mortgage value must bigger than ", TeWakaAmount)
+		return fmt.Errorf("mortgage value must bigger than %d", TeWakaAmount)
 	}
 
 	coinBaseAddress := ctx.GlobalStringSlice(MortgageFlags[3].GetName())
@@ -39,33 +43,41 @@ func Mortgage(ctx *cli.Context) error {
 		cbas = append(cbas, common.HexToAddress(v))
 	}
 
-	printBaseInfo(conn, url)
-	PrintBalance(conn, from, common.HexToAddress(toAddress))
-
-	input := packInput("mortgage", pubKeyb, common.HexToAddress(toAddress), Amount, cbas)
-	txHash := sendContractTransaction(conn, from, vm.TeWaKaAddress, nil, priKey, input)
-	getResult(conn, txHash, true, false)
+	if _, err := printBaseInfo(conn, url); err != nil {
+		return err
+	}
+	if err := PrintBalance(conn, from, common.HexToAddress(toAddress)); err != nil {
+		return err
+	}
 
-	return nil
+	input, err := packInput("mortgage", pubKeyb, common.HexToAddress(toAddress), Amount, cbas)
+	if err != nil {
+		return err
+	}
+	return execContract(conn, "mortgage", input)
 }
 
 var UpdateCommand = cli.Command{
 	Name:   "update",
 	Usage:  "update",
 	Action: utils.MigrateFlags(Update),
-	Flags:  append(TeWakaFlags),
+	Flags:  TeWakaFlags,
 }
 
 func Update(ctx *cli.Context) error {
-
-	loadPrivate(ctx)
-
-	conn, _ := dialConn(ctx)
+	setupOutput(ctx)
+	if err := loadSigner(ctx); err != nil {
+		return err
+	}
+	conn, _, err := dialConn(ctx)
+	if err != nil {
+		return err
+	}
 
 	stakingAmount := ctx.GlobalInt64(MortgageFlags[2].GetName())
 	Amount := czzToWei(stakingAmount)
 	if stakingAmount != 0 && stakingAmount < TeWakaAmount {
-		printError("mortgage value must bigger than ", TeWakaAmount)
+		return fmt.Errorf("mortgage value must bigger than %d", TeWakaAmount)
 	}
 
 	coinBaseAddress := ctx.GlobalStringSlice(MortgageFlags[3].GetName())
@@ -74,69 +86,83 @@ func Update(ctx *cli.Context) error {
 		cbas = append(cbas, common.HexToAddress(v))
 	}
 
-	input := packInput("update", Amount, cbas)
-	txHash := sendContractTransaction(conn, from, vm.TeWaKaAddress, nil, priKey, input)
-	getResult(conn, txHash, true, false)
-
-	return nil
+	input, err := packInput("update", Amount, cbas)
+	if err != nil {
+		return err
+	}
+	return execContract(conn, "update", input)
 }
 
 var ConvertCommand = cli.Command{
 	Name:   "convert",
 	Usage:  "convert",
 	Action: utils.MigrateFlags(Convert),
-	Flags:  append(TeWakaFlags),
+	Flags:  TeWakaFlags,
 }
 
 func Convert(ctx *cli.Context) error {
-
-	loadPrivate(ctx)
-	conn, _ := dialConn(ctx)
+	setupOutput(ctx)
+	if err := loadSigner(ctx); err != nil {
+		return err
+	}
+	conn, _, err := dialConn(ctx)
+	if err != nil {
+		return err
+	}
 
 	AssetType := big.NewInt(ctx.GlobalInt64(ConvertFlags[0].GetName()))
 	TxHash := ctx.GlobalString(ConvertFlags[1].GetName())
 
-	input := packInput("convert", AssetType, TxHash)
-	txHash := sendContractTransaction(conn, from, vm.TeWaKaAddress, nil, priKey, input)
-	getResult(conn, txHash, true, false)
-
-	return nil
+	input, err := packInput("convert", AssetType, TxHash)
+	if err != nil {
+		return err
+	}
+	return execContract(conn, "convert", input)
 }
 
 var ConfirmCommand = cli.Command{
 	Name:   "confirm",
 	Usage:  "confirm",
 	Action: utils.MigrateFlags(Confirm),
-	Flags:  append(TeWakaFlags),
+	Flags:  TeWakaFlags,
 }
 
 func Confirm(ctx *cli.Context) error {
-
-	loadPrivate(ctx)
-
-	conn, _ := dialConn(ctx)
+	setupOutput(ctx)
+	if err := loadSigner(ctx); err != nil {
+		return err
+	}
+	conn, _, err := dialConn(ctx)
+	if err != nil {
+		return err
+	}
 
 	ConvertType := ctx.GlobalInt64(ConfirmFlags[0].GetName())
 	TxHash := ctx.GlobalString(ConfirmFlags[1].GetName())
 
-	input := packInput("confirm", big.NewInt(ConvertType), TxHash)
-	txHash := sendContractTransaction(conn, from, vm.TeWaKaAddress, nil, priKey, input)
-	getResult(conn, txHash, true, false)
-
-	return nil
+	input, err := packInput("confirm", big.NewInt(ConvertType), TxHash)
+	if err != nil {
+		return err
+	}
+	return execContract(conn, "confirm", input)
 }
 
 var CastingCommand = cli.Command{
 	Name:   "casting",
 	Usage:  "casting",
 	Action: utils.MigrateFlags(Casting),
-	Flags:  append(TeWakaFlags),
+	Flags:  TeWakaFlags,
 }
 
 func Casting(ctx *cli.Context) error {
-
-	loadPrivate(ctx)
-	conn, _ := dialConn(ctx)
+	setupOutput(ctx)
+	if err := loadSigner(ctx); err != nil {
+		return err
+	}
+	conn, _, err := dialConn(ctx)
+	if err != nil {
+		return err
+	}
 	ConvertType := ctx.GlobalUint64(CastingFlags[0].GetName())
 
 	Amount := ctx.GlobalInt64(CastingFlags[1].GetName())
@@ -157,9 +183,9 @@ func Casting(ctx *cli.Context) error {
 	Slippage := ctx.GlobalUint64(CastingFlags[5].GetName())
 	IsInsurance := ctx.GlobalBool(CastingFlags[6].GetName())
 
-	input := packInput("casting", big.NewInt(int64(ConvertType)), ToAmount, ToPath, ToPubKey, ToRouterAddr, big.NewInt(int64(Slippage)), IsInsurance)
-	txHash := sendContractTransaction(conn, from, vm.TeWaKaAddress, nil, priKey, input)
-	getResult(conn, txHash, true, false)
-
-	return nil
+	input, err := packInput("casting", big.NewInt(int64(ConvertType)), ToAmount, ToPath, ToPubKey, ToRouterAddr, big.NewInt(int64(Slippage)), IsInsurance)
+	if err != nil {
+		return err
+	}
+	return execContract(conn, "casting", input)
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/classzz/go-classzz-v2/accounts"
 	"github.com/classzz/go-classzz-v2/cmd/utils"
 	"github.com/classzz/go-classzz-v2/internal/flags"
 	"gopkg.in/urfave/cli.v1"
@@ -29,6 +30,40 @@ var (
 		Usage: "Staking value units one true",
 		Value: 0,
 	}
+	SignerFlag = cli.StringFlag{
+		Name:  "signer",
+		Usage: "External signer (clef) endpoint used to sign transactions, e.g. ~/.clef/clef.ipc",
+	}
+	USBFlag = cli.BoolFlag{
+		Name:  "usb",
+		Usage: "Sign transactions with a connected Ledger or Trezor hardware wallet",
+	}
+	USBPathFlag = cli.StringFlag{
+		Name:  "usb.path",
+		Usage: "Derivation path of the hardware wallet account",
+		Value: accounts.DefaultBaseDerivationPath.String(),
+	}
+	FromFlag = cli.StringFlag{
+		Name:  "from",
+		Usage: "Account to sign with when using --signer, or to simulate from in --dry-run mode",
+	}
+	DryRunFlag = cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Simulate the transaction with eth_call/eth_estimateGas instead of sending it",
+	}
+	JSONFlag = cli.BoolFlag{
+		Name:  "json",
+		Usage: "Print results as JSON",
+	}
+	BlockFlag = cli.StringFlag{
+		Name:  "block",
+		Usage: "Block number to query the TeWaka state at",
+		Value: "latest",
+	}
+	CoinbaseFlag = cli.StringFlag{
+		Name:  "coinbase",
+		Usage: "Coinbase address to query the staking factor for",
+	}
 
 	MortgageFlags = []cli.Flag{
 		cli.StringFlag{
@@ -115,6 +150,14 @@ var (
 	TeWakaFlags = []cli.Flag{
 		KeyFlag,
 		KeyStoreFlag,
+		SignerFlag,
+		USBFlag,
+		USBPathFlag,
+		FromFlag,
+		DryRunFlag,
+		JSONFlag,
+		BlockFlag,
+		CoinbaseFlag,
 		utils.LegacyRPCListenAddrFlag,
 		utils.LegacyRPCPortFlag,
 		CzzValueFlag,
@@ -155,6 +198,9 @@ func init() {
 		ConvertCommand,
 		ConfirmCommand,
 		CastingCommand,
+		PledgeCommand,
+		ConvertItemsCommand,
+		StakingFactorCommand,
 	}
 	cli.CommandHelpTemplate = flags.CommandHelpTemplate
 	sort.Sort(cli.CommandsByName(app.Commands))
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/classzz/go-classzz-v2/cmd/utils"
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/czzclient"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var PledgeCommand = cli.Command{
+	Name:   "pledge",
+	Usage:  "query the pledge infos",
+	Action: utils.MigrateFlags(Pledge),
+	Flags:  TeWakaFlags,
+}
+
+// rpcPledge is the output form of a pledge.
+type rpcPledge struct {
+	Address         common.Address   `json:"address"`
+	PubKey          hexutil.Bytes    `json:"pub_key"`
+	ToAddress       common.Address   `json:"to_address"`
+	StakingAmount   *hexutil.Big     `json:"staking_amount"`
+	CoinBaseAddress []common.Address `json:"coinbase_address"`
+}
+
+func Pledge(ctx *cli.Context) error {
+	setupOutput(ctx)
+	conn, _, err := dialConn(ctx)
+	if err != nil {
+		return err
+	}
+	number, err := parseBlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	pledges, err := conn.GetPledgeInfo(context.Background(), number)
+	if err != nil {
+		return err
+	}
+
+	result := make([]*rpcPledge, 0, len(pledges))
+	for _, v := range pledges {
+		result = append(result, &rpcPledge{
+			Address:         v.Address,
+			PubKey:          v.PubKey,
+			ToAddress:       v.ToAddress,
+			StakingAmount:   (*hexutil.Big)(v.StakingAmount),
+			CoinBaseAddress: v.CoinBaseAddress,
+		})
+	}
+	printResult(result, func() {
+		for _, v := range pledges {
+			fmt.Println("address", v.Address.Hex(), "toAddress", v.ToAddress.Hex(), "stakingAmount", weiToCzz(v.StakingAmount), "czz", "coinbase", v.CoinBaseAddress)
+		}
+	})
+	return nil
+}
+
+var ConvertItemsCommand = cli.Command{
+	Name:   "convertitems",
+	Usage:  "query the pending convert items",
+	Action: utils.MigrateFlags(ConvertItems),
+	Flags:  TeWakaFlags,
+}
+
+func ConvertItems(ctx *cli.Context) error {
+	setupOutput(ctx)
+	conn, _, err := dialConn(ctx)
+	if err != nil {
+		return err
+	}
+	number, err := parseBlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	items, err := conn.GetConvertItem(context.Background(), number)
+	if err != nil {
+		return err
+	}
+
+	result := make([]*czzclient.RPCConvertItem, 0, len(items))
+	for _, v := range items {
+		result = append(result, &czzclient.RPCConvertItem{
+			ID:          (*hexutil.Big)(v.ID),
+			AssetType:   hexutil.Uint(v.AssetType),
+			ConvertType: hexutil.Uint(v.ConvertType),
+			TxHash:      v.TxHash,
+			PubKey:      v.PubKey,
+			Amount:      (*hexutil.Big)(v.Amount),
+			FeeAmount:   (*hexutil.Big)(v.FeeAmount),
+			Path:        v.Path,
+			RouterAddr:  v.RouterAddr,
+			Slippage:    (*hexutil.Big)(v.Slippage),
+			IsInsurance: v.IsInsurance,
+			Extra:       v.Extra,
+		})
+	}
+	printResult(result, func() {
+		for _, v := range items {
+			fmt.Println("id", v.ID, "assetType", v.AssetType, "convertType", v.ConvertType, "txHash", v.TxHash.Hex(), "amount", v.Amount, "fee", v.FeeAmount)
+		}
+	})
+	return nil
+}
+
+var StakingFactorCommand = cli.Command{
+	Name:   "stakingfactor",
+	Usage:  "query the staking amount and mining factor of a coinbase",
+	Action: utils.MigrateFlags(StakingFactor),
+	Flags:  TeWakaFlags,
+}
+
+func StakingFactor(ctx *cli.Context) error {
+	setupOutput(ctx)
+	coinbase := ctx.GlobalString(CoinbaseFlag.Name)
+	if !common.IsHexAddress(coinbase) {
+		return errors.New("must specify a valid --coinbase")
+	}
+	conn, _, err := dialConn(ctx)
+	if err != nil {
+		return err
+	}
+	number, err := parseBlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	factor, err := conn.GetStakingFactor(context.Background(), common.HexToAddress(coinbase), number)
+	if err != nil {
+		return err
+	}
+	printResult(factor, func() {
+		fmt.Println("coinbase", factor.Coinbase.Hex(), "stakingAmount", weiToCzz(factor.StakingAmount.ToInt()), "czz", "factor", factor.Factor)
+	})
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/classzz/go-classzz-v2/accounts"
+	"github.com/classzz/go-classzz-v2/accounts/external"
+	"github.com/classzz/go-classzz-v2/accounts/usbwallet"
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/console/prompt"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"math/big"
+)
+
+// loadExternalSigner connects to a clef instance and signs with the requested
+// account, or with the first account clef exposes if none was given.
+func loadExternalSigner(endpoint string, account string) error {
+	ext, err := external.NewExternalSigner(endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to connect to external signer: %v", err)
+	}
+	var signAccount accounts.Account
+	if account != "" {
+		if !common.IsHexAddress(account) {
+			return fmt.Errorf("invalid --from address %q", account)
+		}
+		signAccount = accounts.Account{Address: common.HexToAddress(account)}
+		if !ext.Contains(signAccount) {
+			return fmt.Errorf("account %s not managed by external signer", account)
+		}
+	} else {
+		accs := ext.Accounts()
+		if len(accs) == 0 {
+			return errors.New("external signer has no accounts")
+		}
+		signAccount = accs[0]
+	}
+	signer = &txSigner{
+		from: signAccount.Address,
+		sign: func(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+			return ext.SignTx(signAccount, tx, chainID)
+		},
+	}
+	return nil
+}
+
+// loadUSBSigner signs with the account at the given derivation path of the
+// first Ledger or Trezor found.
+func loadUSBSigner(path string) error {
+	derivation, err := accounts.ParseDerivationPath(path)
+	if err != nil {
+		return fmt.Errorf("invalid derivation path %q: %v", path, err)
+	}
+	var hubs []*usbwallet.Hub
+	if hub, err := usbwallet.NewLedgerHub(); err != nil {
+		fmt.Fprintln(infoOut, "Failed to start Ledger hub, disabling:", err)
+	} else {
+		hubs = append(hubs, hub)
+	}
+	if hub, err := usbwallet.NewTrezorHubWithHID(); err != nil {
+		fmt.Fprintln(infoOut, "Failed to start HID Trezor hub, disabling:", err)
+	} else {
+		hubs = append(hubs, hub)
+	}
+	if hub, err := usbwallet.NewTrezorHubWithWebUSB(); err != nil {
+		fmt.Fprintln(infoOut, "Failed to start WebUSB Trezor hub, disabling:", err)
+	} else {
+		hubs = append(hubs, hub)
+	}
+	for _, hub := range hubs {
+		for _, wallet := range hub.Wallets() {
+			if err := openUSBWallet(wallet); err != nil {
+				fmt.Fprintln(infoOut, "Failed to open wallet", wallet.URL(), err)
+				continue
+			}
+			account, err := wallet.Derive(derivation, true)
+			if err != nil {
+				return fmt.Errorf("failed to derive %s: %v", path, err)
+			}
+			fmt.Fprintln(infoOut, "Using hardware wallet", wallet.URL(), "account", account.Address.Hex())
+
+			signWallet := wallet
+			signer = &txSigner{
+				from: account.Address,
+				sign: func(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+					fmt.Fprintln(infoOut, "Please confirm the transaction on your device")
+					return signWallet.SignTx(account, tx, chainID)
+				},
+			}
+			return nil
+		}
+	}
+	return errors.New("no hardware wallet found")
+}
+
+// openUSBWallet opens a hardware wallet, asking for the Trezor PIN or
+// passphrase when the device requests one.
+func openUSBWallet(wallet accounts.Wallet) error {
+	err := wallet.Open("")
+	if err == usbwallet.ErrTrezorPINNeeded {
+		pin, perr := prompt.Stdin.PromptPassword("Please enter the PIN of your Trezor (see the device for the layout): ")
+		if perr != nil {
+			return perr
+		}
+		err = wallet.Open(pin)
+	}
+	if err == usbwallet.ErrTrezorPassphraseNeeded {
+		passphrase, perr := prompt.Stdin.PromptPassword("Please enter the passphrase of your Trezor: ")
+		if perr != nil {
+			return perr
+		}
+		err = wallet.Open(passphrase)
+	}
+	return err
+}
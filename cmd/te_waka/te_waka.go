@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/ecdsa"
+	"encoding/json"
 	"errors"
 	"fmt"
 	classzz "github.com/classzz/go-classzz-v2"
@@ -10,18 +11,21 @@ import (
 	"github.com/classzz/go-classzz-v2/accounts/keystore"
 	"github.com/classzz/go-classzz-v2/cmd/utils"
 	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
 	"github.com/classzz/go-classzz-v2/console/prompt"
 	"github.com/classzz/go-classzz-v2/core/types"
 	"github.com/classzz/go-classzz-v2/core/vm"
 	"github.com/classzz/go-classzz-v2/crypto"
 	"github.com/classzz/go-classzz-v2/czzclient"
+	"github.com/classzz/go-classzz-v2/rpc"
 	"gopkg.in/urfave/cli.v1"
+	"io"
 	"io/ioutil"
-	"log"
 	"math"
 	"math/big"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -34,11 +38,17 @@ var (
 )
 
 var (
-	abiStaking, abcasd = abi.JSON(strings.NewReader(vm.TeWakaABI))
-	priKey             *ecdsa.PrivateKey
-	from               common.Address
-	czzValue           uint64
-	holder             common.Address
+	abiStaking, _ = abi.JSON(strings.NewReader(vm.TeWakaABI))
+	signer        *txSigner
+	from          common.Address
+	czzValue      uint64
+	holder        common.Address
+
+	// infoOut receives the human readable progress output. It is moved to
+	// stderr in --json mode, so that stdout only carries the result.
+	infoOut    io.Writer = os.Stdout
+	jsonOutput bool
+	dryRun     bool
 )
 
 const (
@@ -47,24 +57,60 @@ const (
 	TeWakaAmount           = 1000000
 )
 
-func sendContractTransaction(client *czzclient.Client, from, toAddress common.Address, value *big.Int, privateKey *ecdsa.PrivateKey, input []byte) common.Hash {
+var errDryRunReverted = errors.New("dry run reverted")
+
+// txSigner signs transactions for a single account, wherever its key lives.
+type txSigner struct {
+	from common.Address
+	sign func(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+}
+
+// newKeySigner creates a signer backed by a private key held in memory.
+func newKeySigner(priKey *ecdsa.PrivateKey) *txSigner {
+	return &txSigner{
+		from: crypto.PubkeyToAddress(priKey.PublicKey),
+		sign: func(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+			return types.SignTx(tx, types.NewEIP155Signer(chainID), priKey)
+		},
+	}
+}
+
+// txResult is the outcome of a TeWaka transaction, either sent or simulated.
+type txResult struct {
+	Method      string         `json:"method"`
+	From        common.Address `json:"from"`
+	DryRun      bool           `json:"dryRun"`
+	Gas         uint64         `json:"gas"`
+	GasPrice    *hexutil.Big   `json:"gasPrice,omitempty"`
+	Nonce       uint64         `json:"nonce"`
+	TxHash      *common.Hash   `json:"txHash,omitempty"`
+	Status      string         `json:"status"`
+	BlockNumber uint64         `json:"blockNumber,omitempty"`
+	BlockHash   *common.Hash   `json:"blockHash,omitempty"`
+	Revert      string         `json:"revert,omitempty"`
+}
+
+func sendContractTransaction(client *czzclient.Client, method string, toAddress common.Address, value *big.Int, input []byte) (*txResult, error) {
+	msg := classzz.CallMsg{From: from, To: &toAddress, Value: value, Data: input}
+	if dryRun {
+		return simulateContractTransaction(client, method, msg)
+	}
 	// Ensure a valid value field and resolve the account nonce
 	nonce, err := client.PendingNonceAt(context.Background(), from)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	gasPrice, err := client.SuggestGasPrice(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	gasLimit := uint64(2100000) // in units
 	// If the contract surely has code (or code is not needed), estimate the transaction
-	msg := classzz.CallMsg{From: from, To: &toAddress, GasPrice: gasPrice, Value: value, Data: input}
-	gasLimit, err = client.EstimateGas(context.Background(), msg)
+	msg.GasPrice = gasPrice
+	gasLimit, err := client.EstimateGas(context.Background(), msg)
 	if err != nil {
-		fmt.Println("Contract exec failed", err)
+		fmt.Fprintln(infoOut, "Contract exec failed", decodeRevert(err))
 	}
 	if gasLimit < 1 {
 		gasLimit = 866328
@@ -75,67 +121,119 @@ func sendContractTransaction(client *czzclient.Client, from, toAddress common.Ad
 
 	chainID, err := client.ChainID(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	fmt.Println("TX data nonce ", nonce, " transfer value ", value, " gasLimit ", gasLimit, " gasPrice ", gasPrice, " chainID ", chainID)
+	fmt.Fprintln(infoOut, "TX data nonce ", nonce, " transfer value ", value, " gasLimit ", gasLimit, " gasPrice ", gasPrice, " chainID ", chainID)
 
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), privateKey)
+	signedTx, err := signer.sign(tx, chainID)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	err = client.SendTransaction(context.Background(), signedTx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return signedTx.Hash()
+	txHash := signedTx.Hash()
+	return &txResult{
+		Method:   method,
+		From:     from,
+		Gas:      gasLimit,
+		GasPrice: (*hexutil.Big)(gasPrice),
+		Nonce:    nonce,
+		TxHash:   &txHash,
+		Status:   "pending",
+	}, nil
 }
 
-func loadPrivateKey(path string) common.Address {
-	var err error
+// simulateContractTransaction runs the call against the pending state through
+// eth_call and eth_estimateGas, without signing or sending anything.
+func simulateContractTransaction(client *czzclient.Client, method string, msg classzz.CallMsg) (*txResult, error) {
+	nonce, err := client.PendingNonceAt(context.Background(), msg.From)
+	if err != nil {
+		return nil, err
+	}
+	result := &txResult{
+		Method: method,
+		From:   msg.From,
+		DryRun: true,
+		Nonce:  nonce,
+		Status: "ok",
+	}
+	if _, err := client.PendingCallContract(context.Background(), msg); err != nil {
+		if _, ok := err.(rpc.Error); !ok {
+			return nil, err
+		}
+		result.Status, result.Revert = "reverted", decodeRevert(err)
+		return result, nil
+	}
+	gas, err := client.EstimateGas(context.Background(), msg)
+	if err != nil {
+		if _, ok := err.(rpc.Error); !ok {
+			return nil, err
+		}
+		result.Status, result.Revert = "reverted", decodeRevert(err)
+		return result, nil
+	}
+	result.Gas = gas
+	return result, nil
+}
+
+// decodeRevert extracts the revert reason carried by a JSON-RPC error, falling
+// back to the error message if the node didn't return any revert data.
+func decodeRevert(err error) string {
+	if de, ok := err.(rpc.DataError); ok {
+		if data, ok := de.ErrorData().(string); ok {
+			if raw, derr := hexutil.Decode(data); derr == nil {
+				if reason, uerr := abi.UnpackRevert(raw); uerr == nil {
+					return reason
+				}
+			}
+		}
+	}
+	return err.Error()
+}
+
+func loadPrivateKey(path string) error {
 	if path == "" {
 		file, err := getAllFile(datadirPrivateKey)
 		if err != nil {
-			printError(" getAllFile file name error", err)
+			return fmt.Errorf("getAllFile file name error: %v", err)
 		}
 		kab, _ := filepath.Abs(datadirPrivateKey)
 		path = filepath.Join(kab, file)
 	}
-	priKey, err = crypto.LoadECDSA(path)
+	priKey, err := crypto.LoadECDSA(path)
 	if err != nil {
-		printError("LoadECDSA error", err)
+		return fmt.Errorf("LoadECDSA error: %v", err)
 	}
-	from = crypto.PubkeyToAddress(priKey.PublicKey)
-	return from
+	signer = newKeySigner(priKey)
+	return nil
 }
 
 func getAllFile(path string) (string, error) {
 	rd, err := ioutil.ReadDir(path)
 	if err != nil {
-		printError("path ", err)
+		return "", err
 	}
 	for _, fi := range rd {
 		if fi.IsDir() {
-			fmt.Printf("[%s]\n", path+"\\"+fi.Name())
+			fmt.Fprintf(infoOut, "[%s]\n", path+"\\"+fi.Name())
 			getAllFile(path + fi.Name() + "\\")
 			return "", errors.New("path error")
 		} else {
-			fmt.Println(path, "dir has ", fi.Name(), "file")
+			fmt.Fprintln(infoOut, path, "dir has ", fi.Name(), "file")
 			return fi.Name(), nil
 		}
 	}
 	return "", err
 }
 
-func printError(error ...interface{}) {
-	log.Fatal(error)
-}
-
 func czzToWei(amount int64) *big.Int {
 	baseUnit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
 	value := new(big.Int).Mul(big.NewInt(amount), baseUnit)
-	fmt.Println(value.String())
+	fmt.Fprintln(infoOut, value.String())
 	return value
 }
 
@@ -145,97 +243,157 @@ func weiToCzz(value *big.Int) uint64 {
 	return valueT
 }
 
-func getResult(conn *czzclient.Client, txHash common.Hash, contract bool, delegate bool) {
-	fmt.Println("Please waiting ", " txHash ", txHash.String())
+// getResult waits for a sent transaction to be included and fills in its
+// receipt status. Dry runs are returned as is.
+func getResult(conn *czzclient.Client, result *txResult) error {
+	if result.DryRun {
+		return nil
+	}
+	fmt.Fprintln(infoOut, "Please waiting ", " txHash ", result.TxHash.String())
 
 	count := 0
 	for {
 		time.Sleep(time.Millisecond * 200)
-		_, isPending, err := conn.TransactionByHash(context.Background(), txHash)
+		_, isPending, err := conn.TransactionByHash(context.Background(), *result.TxHash)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		count++
 		if !isPending {
 			break
 		}
 		if count >= 40 {
-			fmt.Println("Please use querytx sub command query later.")
-			os.Exit(0)
+			fmt.Fprintln(infoOut, "Please use querytx sub command query later.")
+			return nil
 		}
 	}
 
-	queryTx(conn, txHash, contract, false, delegate)
+	return queryTx(conn, result)
 }
 
-func queryTx(conn *czzclient.Client, txHash common.Hash, contract bool, pending bool, delegate bool) {
-
-	if pending {
-		_, isPending, err := conn.TransactionByHash(context.Background(), txHash)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if isPending {
-			println("In tx_pool no validator  process this, please query later")
-			os.Exit(0)
-		}
-	}
-
-	receipt, err := conn.TransactionReceipt(context.Background(), txHash)
+func queryTx(conn *czzclient.Client, result *txResult) error {
+	receipt, err := conn.TransactionReceipt(context.Background(), *result.TxHash)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	result.BlockNumber = receipt.BlockNumber.Uint64()
+	result.BlockHash = &receipt.BlockHash
 
 	if receipt.Status == types.ReceiptStatusSuccessful {
 		block, err := conn.BlockByHash(context.Background(), receipt.BlockHash)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-
-		fmt.Println("Transaction Success", " block Number", receipt.BlockNumber.Uint64(), " block txs", len(block.Transactions()), "blockhash", block.Hash().Hex())
+		result.Status = "success"
+		fmt.Fprintln(infoOut, "Transaction Success", " block Number", receipt.BlockNumber.Uint64(), " block txs", len(block.Transactions()), "blockhash", block.Hash().Hex())
 
 	} else if receipt.Status == types.ReceiptStatusFailed {
-		fmt.Println("Transaction Failed ", " Block Number", receipt.BlockNumber.Uint64())
+		result.Status = "failed"
+		fmt.Fprintln(infoOut, "Transaction Failed ", " Block Number", receipt.BlockNumber.Uint64())
+	}
+	return nil
+}
+
+// execContract sends (or simulates) a TeWaka call and reports the result.
+func execContract(conn *czzclient.Client, method string, input []byte) error {
+	result, err := sendContractTransaction(conn, method, vm.TeWaKaAddress, nil, input)
+	if err != nil {
+		return err
+	}
+	if err := getResult(conn, result); err != nil {
+		return err
 	}
+	printResult(result, func() {
+		if result.DryRun {
+			fmt.Println("Dry run", method, "status", result.Status, "gas", result.Gas, "revert", result.Revert)
+		}
+	})
+	if result.Status == "reverted" {
+		return errDryRunReverted
+	}
+	return nil
+}
+
+// printResult writes v as JSON in --json mode, or calls human otherwise.
+func printResult(v interface{}, human func()) {
+	if !jsonOutput {
+		human()
+		return
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to encode result", err)
+		return
+	}
+	fmt.Println(string(out))
 }
 
-func packInput(abiMethod string, params ...interface{}) []byte {
+func packInput(abiMethod string, params ...interface{}) ([]byte, error) {
 	input, err := abiStaking.Pack(abiMethod, params...)
 	if err != nil {
-		printError(abiMethod, " error ", err)
+		return nil, fmt.Errorf("%s error %v", abiMethod, err)
 	}
-	return input
+	return input, nil
 }
 
-func PrintBalance(conn *czzclient.Client, from, toaddress common.Address) {
+func PrintBalance(conn *czzclient.Client, from, toaddress common.Address) error {
 	balance, err := conn.BalanceAt(context.Background(), from, nil)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	fbalance := new(big.Float)
 	fbalance.SetString(balance.String())
 	czzValue := new(big.Float).Quo(fbalance, big.NewFloat(math.Pow10(18)))
 	sbalance, err := conn.BalanceAt(context.Background(), toaddress, nil)
-	fmt.Println("Your wallet valid balance is ", czzValue, "czz", " lock balance is ", common.ToCzz(sbalance), "czz ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(infoOut, "Your wallet valid balance is ", czzValue, "czz", " lock balance is ", common.ToCzz(sbalance), "czz ")
+	return nil
+}
+
+// setupOutput applies the --json and --dry-run flags.
+func setupOutput(ctx *cli.Context) {
+	jsonOutput = ctx.GlobalBool(JSONFlag.Name)
+	dryRun = ctx.GlobalBool(DryRunFlag.Name)
+	if jsonOutput {
+		infoOut = os.Stderr
+	}
 }
 
-func loadPrivate(ctx *cli.Context) {
+// loadSigner resolves the account transactions are sent from. Dry runs only
+// need an address, so --from alone is enough for them.
+func loadSigner(ctx *cli.Context) error {
 	key = ctx.GlobalString(KeyFlag.Name)
 	store = ctx.GlobalString(KeyStoreFlag.Name)
-	if key != "" {
-		loadPrivateKey(key)
-	} else if store != "" {
-		loadSigningKey(store)
-	} else {
-		printError("Must specify --key or --keystore")
-	}
 
-	if priKey == nil {
-		printError("load privateKey failed")
+	var err error
+	switch {
+	case key != "":
+		err = loadPrivateKey(key)
+	case store != "":
+		err = loadSigningKey(store)
+	case ctx.GlobalString(SignerFlag.Name) != "":
+		err = loadExternalSigner(ctx.GlobalString(SignerFlag.Name), ctx.GlobalString(FromFlag.Name))
+	case ctx.GlobalBool(USBFlag.Name):
+		err = loadUSBSigner(ctx.GlobalString(USBPathFlag.Name))
+	case dryRun && common.IsHexAddress(ctx.GlobalString(FromFlag.Name)):
+		from = common.HexToAddress(ctx.GlobalString(FromFlag.Name))
+		return nil
+	default:
+		return errors.New("must specify --key, --keystore, --signer or --usb")
 	}
+	if err != nil {
+		return err
+	}
+	if signer == nil {
+		return errors.New("load signer failed")
+	}
+	from = signer.from
+	return nil
 }
 
-func dialConn(ctx *cli.Context) (*czzclient.Client, string) {
+func dialConn(ctx *cli.Context) (*czzclient.Client, string, error) {
 	ip = ctx.GlobalString(utils.LegacyRPCListenAddrFlag.Name)
 	port = ctx.GlobalInt(utils.LegacyRPCPortFlag.Name)
 
@@ -243,40 +401,54 @@ func dialConn(ctx *cli.Context) (*czzclient.Client, string) {
 	// Create an IPC based RPC connection to a remote node
 	conn, err := czzclient.Dial(url)
 	if err != nil {
-		log.Fatalf("Failed to connect to the Truechain client: %v", err)
+		return nil, "", fmt.Errorf("failed to connect to the Classzz client: %v", err)
+	}
+	return conn, url, nil
+}
+
+// parseBlockNumber converts the --block flag into the block number argument of
+// czzclient, where nil selects the latest block.
+func parseBlockNumber(ctx *cli.Context) (*big.Int, error) {
+	switch block := ctx.GlobalString(BlockFlag.Name); block {
+	case "", "latest":
+		return nil, nil
+	case "pending":
+		return big.NewInt(-1), nil
+	default:
+		number, err := strconv.ParseUint(block, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block number %q: %v", block, err)
+		}
+		return new(big.Int).SetUint64(number), nil
 	}
-	return conn, url
 }
 
-func printBaseInfo(conn *czzclient.Client, url string) *types.Header {
+func printBaseInfo(conn *czzclient.Client, url string) (*types.Header, error) {
 	header, err := conn.HeaderByNumber(context.Background(), nil)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	if common.IsHexAddress(from.Hex()) {
-		fmt.Println("Connect url ", url, " current number ", header.Number.String(), " address ", from.Hex())
+		fmt.Fprintln(infoOut, "Connect url ", url, " current number ", header.Number.String(), " address ", from.Hex())
 	} else {
-		fmt.Println("Connect url ", url, " current number ", header.Number.String())
+		fmt.Fprintln(infoOut, "Connect url ", url, " current number ", header.Number.String())
 	}
 
-	return header
+	return header, nil
 }
 
 // loadSigningKey loads a private key in Ethereum keystore format.
-func loadSigningKey(keyfile string) common.Address {
+func loadSigningKey(keyfile string) error {
 	keyjson, err := ioutil.ReadFile(keyfile)
 	if err != nil {
-		printError(fmt.Errorf("failed to read the keyfile at '%s': %v", keyfile, err))
+		return fmt.Errorf("failed to read the keyfile at '%s': %v", keyfile, err)
 	}
 	password, _ := prompt.Stdin.PromptPassword("Please enter the password for '" + keyfile + "': ")
-	//password := "secret"
 	key, err := keystore.DecryptKey(keyjson, password)
 	if err != nil {
-		printError(fmt.Errorf("error decrypting key: %v", err))
+		return fmt.Errorf("error decrypting key: %v", err)
 	}
-	priKey = key.PrivateKey
-	from = crypto.PubkeyToAddress(priKey.PublicKey)
-	//fmt.Println("address ", from.Hex(), "key", hex.EncodeToString(crypto.FromECDSA(priKey)))
-	return from
+	signer = newKeySigner(key.PrivateKey)
+	return nil
 }
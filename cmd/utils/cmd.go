@@ -21,8 +21,11 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
@@ -36,6 +39,7 @@ import (
 	"github.com/classzz/go-classzz-v2/czz/czzconfig"
 	"github.com/classzz/go-classzz-v2/czzdb"
 	"github.com/classzz/go-classzz-v2/internal/debug"
+	"github.com/classzz/go-classzz-v2/internal/era"
 	"github.com/classzz/go-classzz-v2/log"
 	"github.com/classzz/go-classzz-v2/node"
 	"github.com/classzz/go-classzz-v2/rlp"
@@ -269,6 +273,214 @@ func ExportAppendChain(blockchain *core.BlockChain, fn string, first uint64, las
 	return nil
 }
 
+// ExportHistory exports the blocks in the given range into Era1 archives in the
+// specified directory, one file per epoch, and extends the list of accumulator
+// roots kept alongside them. The first block must start an epoch.
+func ExportHistory(bc *core.BlockChain, dir, network string, first, last uint64) error {
+	log.Info("Exporting blockchain history", "dir", dir)
+
+	if first%era.MaxEra1Size != 0 {
+		return fmt.Errorf("first block %d is not aligned to an epoch of %d blocks", first, era.MaxEra1Size)
+	}
+	if head := bc.CurrentFastBlock().NumberU64(); head < last {
+		return fmt.Errorf("last block %d larger than head block %d", last, head)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	var (
+		start = time.Now()
+		roots []common.Hash
+	)
+	for epoch := first / era.MaxEra1Size; epoch*era.MaxEra1Size <= last; epoch++ {
+		end := (epoch+1)*era.MaxEra1Size - 1
+		if end > last {
+			end = last
+		}
+		root, err := exportEpoch(bc, dir, network, epoch, epoch*era.MaxEra1Size, end)
+		if err != nil {
+			return fmt.Errorf("epoch %d: %v", epoch, err)
+		}
+		roots = append(roots, root)
+		log.Info("Exported history archive", "epoch", epoch, "blocks", end-epoch*era.MaxEra1Size+1, "root", root, "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	// Extend the list of known roots, unless the earlier epochs are missing
+	fn := filepath.Join(dir, era.AccumulatorsFilename)
+	known, err := era.ReadAccumulators(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if epoch := first / era.MaxEra1Size; uint64(len(known)) < epoch {
+		log.Warn("Accumulator list misses earlier epochs, not updating", "file", fn, "have", len(known), "want", epoch)
+	} else if err := era.WriteAccumulators(fn, append(known[:epoch], roots...)); err != nil {
+		return err
+	}
+	log.Info("Exported blockchain history", "dir", dir)
+	return nil
+}
+
+// exportEpoch writes the blocks in the given range into the Era1 archive of an
+// epoch, replacing any previous archive of the same epoch.
+func exportEpoch(bc *core.BlockChain, dir, network string, epoch, first, last uint64) (common.Hash, error) {
+	tmp := filepath.Join(dir, fmt.Sprintf("%s-%05d.era1.tmp", network, epoch))
+	fh, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	defer os.Remove(tmp)
+	defer fh.Close()
+
+	builder := era.NewBuilder(fh)
+	for n := first; n <= last; n++ {
+		block := bc.GetBlockByNumber(n)
+		if block == nil {
+			return common.Hash{}, fmt.Errorf("block #%d not found", n)
+		}
+		receipts := bc.GetReceiptsByHash(block.Hash())
+		if receipts == nil && len(block.Transactions()) > 0 {
+			return common.Hash{}, fmt.Errorf("receipts of block #%d not found", n)
+		}
+		td := bc.GetTd(block.Hash(), n)
+		if td == nil {
+			return common.Hash{}, fmt.Errorf("total difficulty of block #%d not found", n)
+		}
+		if err := builder.Add(block, receipts, td); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	root, err := builder.Finalize()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := fh.Close(); err != nil {
+		return common.Hash{}, err
+	}
+	// Drop the archives of earlier, partial exports of the epoch
+	stale, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("%s-%05d-*.era1", network, epoch)))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	for _, fn := range stale {
+		if err := os.Remove(fn); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	return root, os.Rename(tmp, filepath.Join(dir, era.Filename(network, int(epoch), root)))
+}
+
+// ImportHistory imports the Era1 archives in the specified directory, checking
+// every archive against the given list of known accumulator roots. Only the
+// chain history is imported, the state needs to be synced afterwards.
+func ImportHistory(chain *core.BlockChain, db czzdb.Database, dir, network string, accumulators []common.Hash) error {
+	log.Info("Importing blockchain history", "dir", dir)
+
+	files, err := era.ReadDir(dir, network)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no %s history archives found in %s", network, dir)
+	}
+	if len(files) > len(accumulators) {
+		return fmt.Errorf("no known accumulator for epoch %d", len(accumulators))
+	}
+	start := time.Now()
+	for i, file := range files {
+		if err := importEpoch(chain, db, filepath.Join(dir, file), uint64(i), i == len(files)-1, accumulators[i]); err != nil {
+			return fmt.Errorf("epoch %d: %v", i, err)
+		}
+		log.Info("Imported history archive", "epoch", i, "file", file, "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	log.Info("Imported blockchain history", "dir", dir, "head", chain.CurrentFastBlock().NumberU64())
+	return nil
+}
+
+// importEpoch verifies the given Era1 archive against its known accumulator root
+// and inserts the blocks and receipts missing from the local chain. Only the
+// final archive may hold a partial epoch.
+func importEpoch(chain *core.BlockChain, db czzdb.Database, fn string, epoch uint64, final bool, accumulator common.Hash) error {
+	e, err := era.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+
+	if e.Start() != epoch*era.MaxEra1Size {
+		return fmt.Errorf("archive starts at block %d, want %d", e.Start(), epoch*era.MaxEra1Size)
+	}
+	if !final && e.Count() != era.MaxEra1Size {
+		return fmt.Errorf("partial archive of %d blocks before the final epoch", e.Count())
+	}
+
+	// Check the archive before touching the database, the accumulator makes
+	// sure the file server can't feed a forged history
+	root, err := e.Verify()
+	if err != nil {
+		return fmt.Errorf("invalid archive: %v", err)
+	}
+	if root != accumulator {
+		return fmt.Errorf("accumulator mismatch: have %x, want %x", root, accumulator)
+	}
+	var (
+		headers  []*types.Header
+		blocks   types.Blocks
+		receipts []types.Receipts
+		td       *big.Int
+	)
+	flush := func() error {
+		if len(blocks) == 0 {
+			return nil
+		}
+		// The headers are authenticated by the accumulator, only check the
+		// seals sparsely like fast sync does
+		if n, err := chain.InsertHeaderChain(headers, 100); err != nil {
+			return fmt.Errorf("invalid header #%d: %v", headers[n].Number, err)
+		}
+		last := headers[len(headers)-1]
+		if have := chain.GetTd(last.Hash(), last.Number.Uint64()); have == nil || have.Cmp(td) != 0 {
+			return fmt.Errorf("total difficulty mismatch of block #%d: have %v, archive %v", last.Number, have, td)
+		}
+		// Push the blocks straight into the ancient store if it's contiguous
+		// with them, otherwise leave them to the freezer
+		var limit uint64
+		if frozen, err := db.Ancients(); err == nil && (frozen == blocks[0].NumberU64() || (frozen == 0 && blocks[0].NumberU64() == 1)) {
+			limit = math.MaxUint64
+		}
+		if _, err := chain.InsertReceiptChain(blocks, receipts, limit); err != nil {
+			return err
+		}
+		headers, blocks, receipts = headers[:0], blocks[:0], receipts[:0]
+		return nil
+	}
+	for n := e.Start(); n < e.Start()+e.Count(); n++ {
+		block, err := e.GetBlockByNumber(n)
+		if err != nil {
+			return err
+		}
+		// Skip the blocks already present, making sure they are the same
+		if n <= chain.CurrentFastBlock().NumberU64() {
+			if hash := rawdb.ReadCanonicalHash(db, n); hash != block.Hash() {
+				return fmt.Errorf("block #%d conflicts with local chain: have %x, archive %x", n, hash, block.Hash())
+			}
+			continue
+		}
+		blockReceipts, err := e.GetReceiptsByNumber(n)
+		if err != nil {
+			return err
+		}
+		if td, err = e.GetTotalDifficultyByNumber(n); err != nil {
+			return err
+		}
+		headers, blocks, receipts = append(headers, block.Header()), append(blocks, block), append(receipts, blockReceipts)
+		if len(blocks) == importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
 // ImportPreimages imports a batch of exported hash preimages into the database.
 func ImportPreimages(db czzdb.Database, fn string) error {
 	log.Info("Importing preimages", "file", fn)
@@ -66,6 +66,7 @@ import (
 	"github.com/classzz/go-classzz-v2/p2p/nat"
 	"github.com/classzz/go-classzz-v2/p2p/netutil"
 	"github.com/classzz/go-classzz-v2/params"
+	"github.com/classzz/go-classzz-v2/trie"
 	pcsclite "github.com/gballet/go-libpcsclite"
 	gopsutil "github.com/shirou/gopsutil/mem"
 	"gopkg.in/urfave/cli.v1"
@@ -115,7 +116,12 @@ var (
 	}
 	AncientFlag = DirectoryFlag{
 		Name:  "datadir.ancient",
-		Usage: "Data directory for ancient chain segments (default = inside chaindata)",
+		Usage: "Data directory for ancient chain segments (default = inside chaindata), or remote://<host:port> of a shared ancient store",
+	}
+	DBEngineFlag = cli.StringFlag{
+		Name:  "db.engine",
+		Usage: "Backing database implementation to use for new databases ('leveldb' or 'pebble')",
+		Value: rawdb.DBLeveldb,
 	}
 	MinFreeDiskSpaceFlag = DirectoryFlag{
 		Name:  "datadir.minfreedisk",
@@ -205,6 +211,15 @@ var (
 		Usage: `Blockchain garbage collection mode ("full", "archive")`,
 		Value: "full",
 	}
+	StateSchemeFlag = cli.StringFlag{
+		Name:  "state.scheme",
+		Usage: `Scheme to use for storing the state trie nodes of a new datadir ("hash", "path")`,
+		Value: rawdb.HashScheme,
+	}
+	HistoryAccumulatorsFlag = cli.StringFlag{
+		Name:  "history.accumulators",
+		Usage: "File with the trusted accumulator roots of the history archives (required for import)",
+	}
 	SnapshotFlag = cli.BoolTFlag{
 		Name:  "snapshot",
 		Usage: `Enables snapshot-database mode (default = enable)`,
@@ -214,6 +229,11 @@ var (
 		Usage: "Number of recent blocks to maintain transactions index for (default = about one year, 0 = entire chain)",
 		Value: czzconfig.Defaults.TxLookupLimit,
 	}
+	HistoryBlocksFlag = cli.Uint64Flag{
+		Name:  "history.blocks",
+		Usage: "Number of recent blocks to maintain bodies and receipts for (default = 0 = entire chain)",
+		Value: czzconfig.Defaults.HistoryLimit,
+	}
 	LightKDFFlag = cli.BoolFlag{
 		Name:  "lightkdf",
 		Usage: "Reduce key-derivation RAM & CPU usage at some expense of KDF strength",
@@ -486,6 +506,36 @@ var (
 		Usage: "Sets a cap on transaction fee (in ether) that can be sent via the RPC APIs (0 = no cap)",
 		Value: czzconfig.Defaults.RPCTxFeeCap,
 	}
+	RPCBatchLimitFlag = cli.IntFlag{
+		Name:  "rpc.batchlimit",
+		Usage: "Maximum number of calls in an HTTP/WS-RPC batch request (0 = no limit)",
+		Value: node.DefaultConfig.RPCLimits.BatchItems,
+	}
+	RPCResponseLimitFlag = cli.IntFlag{
+		Name:  "rpc.responselimit",
+		Usage: "Maximum size in bytes of the results returned for an HTTP/WS-RPC request (0 = no limit)",
+		Value: node.DefaultConfig.RPCLimits.ResponseBytes,
+	}
+	RPCRateLimitFlag = cli.Float64Flag{
+		Name:  "rpc.ratelimit",
+		Usage: "Request tokens granted per second to each HTTP/WS-RPC client, by IP or JWT subject (0 = no limit)",
+		Value: node.DefaultConfig.RPCLimits.RateLimit,
+	}
+	RPCRateBurstFlag = cli.IntFlag{
+		Name:  "rpc.rateburst",
+		Usage: "Request tokens an HTTP/WS-RPC client may accumulate",
+		Value: 100,
+	}
+	RPCLogsMaxRangeFlag = cli.Uint64Flag{
+		Name:  "rpc.logs.maxrange",
+		Usage: "Maximum number of blocks a log query may span (0 = no limit)",
+		Value: czzconfig.Defaults.RPCLogsMaxBlockRange,
+	}
+	RPCLogsMaxResultsFlag = cli.IntFlag{
+		Name:  "rpc.logs.maxresults",
+		Usage: "Maximum number of logs a log query may return (0 = no limit)",
+		Value: czzconfig.Defaults.RPCLogsMaxResults,
+	}
 	// Logging and debug settings
 	EthStatsURLFlag = cli.StringFlag{
 		Name:  "czzstats",
@@ -542,6 +592,11 @@ var (
 		Usage: "HTTP path path prefix on which JSON-RPC is served. Use '/' to serve on all paths.",
 		Value: "",
 	}
+	HTTPJWTSecretFlag = cli.StringFlag{
+		Name:  "http.jwtsecret",
+		Usage: "Path to a hex encoded secret authenticating HTTP-RPC requests with HS256 JWT bearer tokens (created if missing)",
+		Value: "",
+	}
 	GraphQLEnabledFlag = cli.BoolFlag{
 		Name:  "graphql",
 		Usage: "Enable GraphQL on the HTTP-RPC server. Note that GraphQL can only be started if an HTTP server is started as well.",
@@ -585,6 +640,11 @@ var (
 		Usage: "HTTP path prefix on which JSON-RPC is served. Use '/' to serve on all paths.",
 		Value: "",
 	}
+	WSJWTSecretFlag = cli.StringFlag{
+		Name:  "ws.jwtsecret",
+		Usage: "Path to a hex encoded secret authenticating WS-RPC connections with HS256 JWT bearer tokens (created if missing)",
+		Value: "",
+	}
 	ExecFlag = cli.StringFlag{
 		Name:  "exec",
 		Usage: "Execute JavaScript statement",
@@ -965,6 +1025,9 @@ func setHTTP(ctx *cli.Context, cfg *node.Config) {
 	if ctx.GlobalIsSet(HTTPPathPrefixFlag.Name) {
 		cfg.HTTPPathPrefix = ctx.GlobalString(HTTPPathPrefixFlag.Name)
 	}
+	if ctx.GlobalIsSet(HTTPJWTSecretFlag.Name) {
+		cfg.HTTPJWTSecret = ctx.GlobalString(HTTPJWTSecretFlag.Name)
+	}
 	if ctx.GlobalIsSet(AllowUnprotectedTxs.Name) {
 		cfg.AllowUnprotectedTxs = ctx.GlobalBool(AllowUnprotectedTxs.Name)
 	}
@@ -1005,6 +1068,27 @@ func setWS(ctx *cli.Context, cfg *node.Config) {
 	if ctx.GlobalIsSet(WSPathPrefixFlag.Name) {
 		cfg.WSPathPrefix = ctx.GlobalString(WSPathPrefixFlag.Name)
 	}
+	if ctx.GlobalIsSet(WSJWTSecretFlag.Name) {
+		cfg.WSJWTSecret = ctx.GlobalString(WSJWTSecretFlag.Name)
+	}
+}
+
+// setRPCLimits applies the limits of the HTTP and WebSocket RPC endpoints from
+// the command line flags.
+func setRPCLimits(ctx *cli.Context, cfg *node.Config) {
+	if ctx.GlobalIsSet(RPCBatchLimitFlag.Name) {
+		cfg.RPCLimits.BatchItems = ctx.GlobalInt(RPCBatchLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCResponseLimitFlag.Name) {
+		cfg.RPCLimits.ResponseBytes = ctx.GlobalInt(RPCResponseLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCRateLimitFlag.Name) {
+		cfg.RPCLimits.RateLimit = ctx.GlobalFloat64(RPCRateLimitFlag.Name)
+		cfg.RPCLimits.RateBurst = ctx.GlobalInt(RPCRateBurstFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCRateBurstFlag.Name) {
+		cfg.RPCLimits.RateBurst = ctx.GlobalInt(RPCRateBurstFlag.Name)
+	}
 }
 
 // setIPC creates an IPC path configuration from the set command line flags,
@@ -1213,10 +1297,22 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	setHTTP(ctx, cfg)
 	setGraphQL(ctx, cfg)
 	setWS(ctx, cfg)
+	setRPCLimits(ctx, cfg)
 	setNodeUserIdent(ctx, cfg)
 	setDataDir(ctx, cfg)
 	setSmartCard(ctx, cfg)
 
+	if ctx.GlobalIsSet(DBEngineFlag.Name) {
+		dbEngine := ctx.GlobalString(DBEngineFlag.Name)
+		if dbEngine != rawdb.DBLeveldb && dbEngine != rawdb.DBPebble {
+			Fatalf("Invalid choice for db.engine '%s', allowed 'leveldb' or 'pebble'", dbEngine)
+		}
+		if dbEngine == rawdb.DBPebble && !rawdb.PebbleEnabled {
+			Fatalf("Database engine 'pebble' is not supported on this platform")
+		}
+		cfg.DBEngine = dbEngine
+	}
+
 	if ctx.GlobalIsSet(ExternalSignerFlag.Name) {
 		cfg.ExternalSigner = ctx.GlobalString(ExternalSignerFlag.Name)
 	}
@@ -1458,6 +1554,9 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *czzconfig.Config) {
 		ctx.GlobalSet(TxLookupLimitFlag.Name, "0")
 		log.Warn("Disable transaction unindexing for archive node")
 	}
+	if ctx.GlobalString(GCModeFlag.Name) == "archive" && ctx.GlobalUint64(HistoryBlocksFlag.Name) != 0 {
+		Fatalf("Chain history cannot be pruned on archive nodes")
+	}
 	if ctx.GlobalIsSet(LightServeFlag.Name) && ctx.GlobalUint64(TxLookupLimitFlag.Name) != 0 {
 		log.Warn("LES server cannot serve old transaction status and cannot connect below les/4 protocol version if transaction lookup index is limited")
 	}
@@ -1525,6 +1624,9 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *czzconfig.Config) {
 	if ctx.GlobalIsSet(TxLookupLimitFlag.Name) {
 		cfg.TxLookupLimit = ctx.GlobalUint64(TxLookupLimitFlag.Name)
 	}
+	if ctx.GlobalIsSet(HistoryBlocksFlag.Name) {
+		cfg.HistoryLimit = ctx.GlobalUint64(HistoryBlocksFlag.Name)
+	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cfg.TrieCleanCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
 	}
@@ -1568,6 +1670,12 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *czzconfig.Config) {
 	if ctx.GlobalIsSet(RPCGlobalTxFeeCapFlag.Name) {
 		cfg.RPCTxFeeCap = ctx.GlobalFloat64(RPCGlobalTxFeeCapFlag.Name)
 	}
+	if ctx.GlobalIsSet(RPCLogsMaxRangeFlag.Name) {
+		cfg.RPCLogsMaxBlockRange = ctx.GlobalUint64(RPCLogsMaxRangeFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCLogsMaxResultsFlag.Name) {
+		cfg.RPCLogsMaxResults = ctx.GlobalInt(RPCLogsMaxResultsFlag.Name)
+	}
 	if ctx.GlobalIsSet(NoDiscoverFlag.Name) {
 		cfg.EthDiscoveryURLs, cfg.SnapDiscoveryURLs = []string{}, []string{}
 	} else if ctx.GlobalIsSet(DNSDiscoveryFlag.Name) {
@@ -1798,6 +1906,12 @@ func MakeChainDatabase(ctx *cli.Context, stack *node.Node, readonly bool) czzdb.
 	return chainDb
 }
 
+// MakeTrieConfig returns the trie database configuration matching the state
+// scheme the given chain database was initialised with.
+func MakeTrieConfig(chainDb czzdb.Database) *trie.Config {
+	return &trie.Config{Scheme: rawdb.ReadStateScheme(chainDb)}
+}
+
 func MakeGenesis(ctx *cli.Context) *core.Genesis {
 	var genesis *core.Genesis
 	switch {
@@ -1848,6 +1962,7 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		TrieTimeLimit:       czzconfig.Defaults.TrieTimeout,
 		SnapshotLimit:       czzconfig.Defaults.SnapshotCache,
 		Preimages:           ctx.GlobalBool(CachePreimagesFlag.Name),
+		StateScheme:         rawdb.ReadStateScheme(chainDb),
 	}
 	if cache.TrieDirtyDisabled && !cache.Preimages {
 		cache.Preimages = true
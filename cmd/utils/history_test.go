@@ -0,0 +1,242 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of go-classzz-v2.
+//
+// go-classzz-v2 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-classzz-v2 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-classzz-v2. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/consensus/ethash"
+	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/internal/era"
+	"github.com/classzz/go-classzz-v2/params"
+)
+
+var (
+	historyKey, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	historyAddr     = crypto.PubkeyToAddress(historyKey.PublicKey)
+	historyReceiver = common.HexToAddress("0x0102030405060708091011121314151617181920")
+)
+
+// newHistoryChain creates a blockchain over a fresh database, initialised with
+// the genesis of the history tests.
+func newHistoryChain(t *testing.T, config *params.ChainConfig) (*core.BlockChain, czzdb.Database, *types.Block) {
+	db := rawdb.NewMemoryDatabase()
+	genesis := (&core.Genesis{
+		Config: config,
+		Alloc:  core.GenesisAlloc{historyAddr: {Balance: big.NewInt(params.Ether)}},
+	}).MustCommit(db)
+
+	chain, err := core.NewBlockChain(db, nil, config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	return chain, db, genesis
+}
+
+// Tests that the history exported from a chain can be imported into an empty
+// one, and that the import is refused against an unknown accumulator root.
+func TestExportImportHistory(t *testing.T) {
+	config := *params.TestChainConfig
+	config.CIP_4, config.CIP_5 = big.NewInt(1000000), big.NewInt(1000000)
+
+	// Generate a chain spanning an epoch and a partial one, with transactions
+	src, _, genesis := newHistoryChain(t, &config)
+	defer src.Stop()
+
+	var (
+		blocks = int(era.MaxEra1Size) + 100
+		signer = types.LatestSigner(&config)
+		gendb  = rawdb.NewMemoryDatabase()
+	)
+	(&core.Genesis{Config: &config, Alloc: core.GenesisAlloc{historyAddr: {Balance: big.NewInt(params.Ether)}}}).MustCommit(gendb)
+	chain, _ := core.GenerateChain(&config, genesis, ethash.NewFaker(), gendb, blocks, func(i int, gen *core.BlockGen) {
+		if i%1000 == 0 || i == blocks-1 {
+			tx, err := types.SignTx(types.NewTransaction(gen.TxNonce(historyAddr), historyReceiver, big.NewInt(1), params.TxGas, gen.BaseFee(), nil), signer, historyKey)
+			if err != nil {
+				t.Fatalf("failed to sign transaction: %v", err)
+			}
+			gen.AddTx(tx)
+		}
+	})
+	if n, err := src.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert block #%d: %v", n, err)
+	}
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ExportHistory(src, dir, "mainnet", 0, uint64(blocks)); err != nil {
+		t.Fatalf("failed to export history: %v", err)
+	}
+	accumulators, err := era.ReadAccumulators(filepath.Join(dir, era.AccumulatorsFilename))
+	if err != nil {
+		t.Fatalf("failed to read accumulators: %v", err)
+	}
+	if len(accumulators) != 2 {
+		t.Fatalf("accumulator count mismatch: have %d, want 2", len(accumulators))
+	}
+	// An unknown accumulator root must be refused before importing anything
+	forged := append([]common.Hash{}, accumulators...)
+	forged[1][0] ^= 0xff
+
+	dst, db, _ := newHistoryChain(t, &config)
+	defer dst.Stop()
+
+	if err := ImportHistory(dst, db, dir, "mainnet", forged[:1]); err == nil {
+		t.Fatalf("import succeeded with a missing accumulator")
+	}
+	if err := ImportHistory(dst, db, dir, "mainnet", forged); err == nil {
+		t.Fatalf("import succeeded with a forged accumulator")
+	}
+	if head := dst.CurrentFastBlock().NumberU64(); head != uint64(era.MaxEra1Size)-1 {
+		t.Fatalf("head mismatch after refused import: have %d, want %d", head, era.MaxEra1Size-1)
+	}
+	// Import the trusted history and compare it with the source chain
+	if err := ImportHistory(dst, db, dir, "mainnet", accumulators); err != nil {
+		t.Fatalf("failed to import history: %v", err)
+	}
+	if head := dst.CurrentFastBlock().NumberU64(); head != uint64(blocks) {
+		t.Fatalf("head mismatch: have %d, want %d", head, blocks)
+	}
+	for _, block := range chain {
+		n := block.NumberU64()
+		if hash := rawdb.ReadCanonicalHash(db, n); hash != block.Hash() {
+			t.Fatalf("block #%d hash mismatch: have %x, want %x", n, hash, block.Hash())
+		}
+		if have := dst.GetBlockByNumber(n); have == nil || have.Transactions().Len() != block.Transactions().Len() {
+			t.Fatalf("block #%d body mismatch", n)
+		}
+		if have, want := dst.GetTd(block.Hash(), n), src.GetTd(block.Hash(), n); have == nil || have.Cmp(want) != 0 {
+			t.Fatalf("block #%d total difficulty mismatch: have %v, want %v", n, have, want)
+		}
+		if block.Transactions().Len() == 0 {
+			continue
+		}
+		have, want := dst.GetReceiptsByHash(block.Hash()), src.GetReceiptsByHash(block.Hash())
+		if len(have) != len(want) || have[0].TxHash != want[0].TxHash || have[0].CumulativeGasUsed != want[0].CumulativeGasUsed {
+			t.Fatalf("block #%d receipts mismatch", n)
+		}
+	}
+}
+
+// Tests that the chain history is pruned while every transaction is indexed,
+// removing the indices of the pruned blocks first.
+func TestPruneHistoryFullIndex(t *testing.T) {
+	config := *params.TestChainConfig
+	config.CIP_4, config.CIP_5 = big.NewInt(1000000), big.NewInt(1000000)
+
+	dir, err := ioutil.TempDir("", "prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	genesis := (&core.Genesis{
+		Config: &config,
+		Alloc:  core.GenesisAlloc{historyAddr: {Balance: big.NewInt(params.Ether)}},
+	}).MustCommit(db)
+
+	// Generate the blocks over the chain database, so their states are available
+	var (
+		blocks = 200
+		signer = types.LatestSigner(&config)
+	)
+	chain, receipts := core.GenerateChain(&config, genesis, ethash.NewFaker(), db, blocks, func(i int, gen *core.BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(gen.TxNonce(historyAddr), historyReceiver, big.NewInt(1), params.TxGas, gen.BaseFee(), nil), signer, historyKey)
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		gen.AddTx(tx)
+	})
+	var (
+		limit   = uint64(0) // Index every transaction
+		history = uint64(50)
+		cache   = &core.CacheConfig{
+			TrieCleanLimit: 256,
+			TrieDirtyLimit: 256,
+			TrieTimeLimit:  5 * time.Minute,
+			SnapshotLimit:  256,
+			SnapshotWait:   true,
+			HistoryLimit:   history,
+		}
+	)
+	bc, err := core.NewBlockChain(db, cache, &config, ethash.NewFaker(), vm.Config{}, nil, &limit)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer bc.Stop()
+
+	// Freeze most of the chain, then import the head to trigger the pruning
+	headers := make([]*types.Header, blocks-1)
+	for i, block := range chain[:blocks-1] {
+		headers[i] = block.Header()
+	}
+	if n, err := bc.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatalf("failed to insert header #%d: %v", n, err)
+	}
+	if n, err := bc.InsertReceiptChain(chain[:blocks-1], receipts[:blocks-1], 140); err != nil {
+		t.Fatalf("failed to insert receipts of block #%d: %v", n, err)
+	}
+	frozen, err := db.Ancients()
+	if err != nil || frozen <= history {
+		t.Fatalf("chain not frozen: %d ancients, %v", frozen, err)
+	}
+	if tail := rawdb.ReadTxIndexTail(db); tail == nil || *tail != 0 {
+		t.Fatalf("transaction index tail mismatch: have %v, want 0", tail)
+	}
+	if n, err := bc.InsertChain(chain[blocks-1:]); err != nil {
+		t.Fatalf("failed to insert block #%d: %v", n, err)
+	}
+	for i := 0; i < 100 && bc.HistoryTail() == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if tail := bc.HistoryTail(); tail != frozen {
+		t.Fatalf("history tail mismatch: have %d, want %d", tail, frozen)
+	}
+	if tail := rawdb.ReadTxIndexTail(db); tail == nil || *tail != frozen {
+		t.Fatalf("transaction index tail mismatch: have %v, want %d", tail, frozen)
+	}
+	for _, block := range chain {
+		n := block.NumberU64()
+		entry := rawdb.ReadTxLookupEntry(db, block.Transactions()[0].Hash())
+		if n < frozen && entry != nil {
+			t.Fatalf("transaction of pruned block #%d still indexed", n)
+		}
+		if n >= frozen && (entry == nil || *entry != n) {
+			t.Fatalf("transaction of block #%d not indexed: %v", n, entry)
+		}
+	}
+}
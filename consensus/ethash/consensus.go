@@ -379,7 +379,7 @@ func (ethash *Ethash) Finalize(chain consensus.ChainHeaderReader, header *types.
 		state.AddBalance(common.HexToAddress("0xa5D17B93f4156afd96be9f5B40888ffb47fA4bc1"), pool)
 	}
 
-	vm.ShiftItems(state, header.Number.Uint64())
+	vm.ShiftItems(chain.Config(), state, header.Number.Uint64())
 	header.Root = state.IntermediateRoot(true)
 }
 
@@ -34,6 +34,7 @@ import (
 	"github.com/classzz/go-classzz-v2/consensus"
 	"github.com/classzz/go-classzz-v2/core/rawdb"
 	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/state/pruner"
 	"github.com/classzz/go-classzz-v2/core/state/snapshot"
 	"github.com/classzz/go-classzz-v2/core/types"
 	"github.com/classzz/go-classzz-v2/core/vm"
@@ -129,6 +130,8 @@ type CacheConfig struct {
 	TrieTimeLimit       time.Duration // Time limit after which to flush the current in-memory trie to disk
 	SnapshotLimit       int           // Memory allowance (MB) to use for caching snapshot entries in memory
 	Preimages           bool          // Whether to store preimage of trie key to the disk
+	HistoryLimit        uint64        // Number of recent blocks to retain bodies and receipts for (0 = entire chain)
+	StateScheme         string        // Scheme the trie nodes are stored with, as recorded in the database
 
 	SnapshotWait bool // Wait for snapshot construction on startup. TODO(karalabe): This is a dirty hack for testing, nuke it
 }
@@ -187,6 +190,7 @@ type BlockChain struct {
 
 	currentBlock     atomic.Value // Current head of the block chain
 	currentFastBlock atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
+	statePruner      atomic.Value // Online state pruner journaling the committed states
 
 	stateCache    state.Database // State database to reuse between imports (contains state cache)
 	bodyCache     *lru.Cache     // Cache for the most recent block bodies
@@ -234,6 +238,7 @@ func NewBlockChain(db czzdb.Database, cacheConfig *CacheConfig, chainConfig *par
 			Cache:     cacheConfig.TrieCleanLimit,
 			Journal:   cacheConfig.TrieCleanJournal,
 			Preimages: cacheConfig.Preimages,
+			Scheme:    cacheConfig.StateScheme,
 		}),
 		quit:           make(chan struct{}),
 		shouldPreserve: shouldPreserve,
@@ -246,6 +251,15 @@ func NewBlockChain(db czzdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		engine:         engine,
 		vmConfig:       vmConfig,
 	}
+	// The trie nodes must be accessed with the scheme they were stored with
+	if stored, scheme := rawdb.ReadStateScheme(db), bc.stateCache.TrieDB().Scheme(); stored != "" && stored != scheme {
+		return nil, fmt.Errorf("state scheme mismatch: database has %s, configured %s", stored, scheme)
+	}
+	// The path-based trie database only keeps the recent states, which is
+	// incompatible with archive mode
+	if cacheConfig.TrieDirtyDisabled && bc.stateCache.TrieDB().Scheme() == rawdb.PathScheme {
+		return nil, errors.New("archive mode is not supported by the path-based state scheme")
+	}
 	bc.validator = NewBlockValidator(chainConfig, bc, engine)
 	bc.prefetcher = newStatePrefetcher(chainConfig, bc, engine)
 	bc.processor = NewStateProcessor(chainConfig, bc, engine)
@@ -289,6 +303,12 @@ func NewBlockChain(db czzdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		if bc.cacheConfig.SnapshotLimit > 0 {
 			diskRoot = rawdb.ReadSnapshotRoot(bc.db)
 		}
+		// The path-based trie database can't rewind beyond its retained reverse
+		// diffs, in which case the snapshot is rebuilt instead of followed.
+		if triedb := bc.stateCache.TrieDB(); diskRoot != (common.Hash{}) && triedb.Scheme() == rawdb.PathScheme && !triedb.Recoverable(diskRoot) {
+			log.Warn("Snapshot disk layer unrecoverable, discarding", "snaproot", diskRoot)
+			diskRoot = common.Hash{}
+		}
 		if diskRoot != (common.Hash{}) {
 			log.Warn("Head state missing, repairing", "number", head.Number(), "hash", head.Hash(), "snaproot", diskRoot)
 
@@ -307,8 +327,12 @@ func NewBlockChain(db czzdb.Database, cacheConfig *CacheConfig, chainConfig *par
 			}
 		}
 	}
-	// Ensure that a previous crash in SetHead doesn't leave extra ancients
-	if frozen, err := bc.db.Ancients(); err == nil && frozen > 0 {
+	if cacheConfig.HistoryLimit > 0 && rawdb.SharedAncients(db) {
+		log.Warn("Chain history not pruned in a shared ancient store", "limit", cacheConfig.HistoryLimit)
+	}
+	// Ensure that a previous crash in SetHead doesn't leave extra ancients. A
+	// shared ancient store is frozen beyond the local head by the other nodes.
+	if frozen, err := bc.db.Ancients(); err == nil && frozen > 0 && !rawdb.SharedAncients(bc.db) {
 		var (
 			needRewind bool
 			low        uint64
@@ -494,6 +518,11 @@ func (bc *BlockChain) SetHeadBeyondRoot(head uint64, root common.Hash) (uint64,
 	bc.chainmu.Lock()
 	defer bc.chainmu.Unlock()
 
+	// The bodies and receipts of the blocks below the history tail are deleted
+	// for good, so the chain can't be rewound onto them
+	if tail := bc.HistoryTail(); head < tail {
+		return 0, fmt.Errorf("cannot rewind to block %d below the pruned history tail %d, resync the chain to go further back", head, tail)
+	}
 	// Track the block number of the requested root hash
 	var rootNumber uint64 // (no root == always 0)
 
@@ -523,6 +552,15 @@ func (bc *BlockChain) SetHeadBeyondRoot(head uint64, root common.Hash) (uint64,
 						beyondRoot, rootNumber = true, newHeadBlock.NumberU64()
 					}
 					if _, err := state.New(newHeadBlock.Root(), bc.stateCache, bc.snaps); err != nil {
+						// The path-based trie database can rewind its persisted
+						// state using the reverse diffs, try that first
+						if triedb := bc.stateCache.TrieDB(); triedb.Recoverable(newHeadBlock.Root()) {
+							if err := triedb.Recover(newHeadBlock.Root()); err != nil {
+								log.Error("Failed to recover block state", "number", newHeadBlock.NumberU64(), "hash", newHeadBlock.Hash(), "err", err)
+							} else {
+								continue
+							}
+						}
 						log.Trace("Block state missing, rewinding further", "number", newHeadBlock.NumberU64(), "hash", newHeadBlock.Hash())
 						if pivot == nil || newHeadBlock.NumberU64() > *pivot {
 							parent := bc.GetBlock(newHeadBlock.ParentHash(), newHeadBlock.NumberU64()-1)
@@ -586,6 +624,11 @@ func (bc *BlockChain) SetHeadBeyondRoot(head uint64, root common.Hash) (uint64,
 		// Ignore the error here since light client won't hit this path
 		frozen, _ := bc.db.Ancients()
 		if num+1 <= frozen {
+			// A shared ancient store keeps the block for the other nodes, the
+			// local chain rewinds only its head
+			if rawdb.SharedAncients(bc.db) {
+				return
+			}
 			// Truncate all relative data(header, total difficulty, body, receipt
 			// and canonical hash) from ancient store.
 			if err := bc.db.TruncateAncients(num); err != nil {
@@ -667,6 +710,58 @@ func (bc *BlockChain) Snapshots() *snapshot.Tree {
 	return bc.snaps
 }
 
+// PruneState starts pruning the stale state with the given online pruner while
+// the chain keeps importing blocks. All the states committed meanwhile are
+// journaled into the pruner.
+func (bc *BlockChain) PruneState(p *pruner.OnlinePruner) error {
+	if bc.cacheConfig.TrieDirtyDisabled {
+		return errors.New("state pruning is not supported in archive mode")
+	}
+	if bc.stateCache.TrieDB().Scheme() == rawdb.PathScheme {
+		return pruner.ErrPathScheme
+	}
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	if cur := bc.StatePruner(); cur != nil && cur != p && cur.Running() {
+		return errors.New("state pruning already in progress")
+	}
+	if err := p.Start(bc.CurrentBlock().Header()); err != nil {
+		return err
+	}
+	bc.statePruner.Store(p)
+	return nil
+}
+
+// RegenerateSnapshot discards the state snapshot and regenerates it from scratch
+// in the background, based on the state of the current head block which must
+// have the given root.
+func (bc *BlockChain) RegenerateSnapshot(root common.Hash) error {
+	if bc.snaps == nil {
+		return errors.New("state snapshots are disabled")
+	}
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	if head := bc.CurrentBlock().Root(); head != root {
+		return fmt.Errorf("snapshot can only be regenerated at the head state %x", head)
+	}
+	if p := bc.StatePruner(); p != nil && p.Running() {
+		return errors.New("state pruning in progress")
+	}
+	bc.snaps.Rebuild(root)
+	return nil
+}
+
+// StatePruner returns the online state pruner most recently started, or nil if
+// none was started yet.
+func (bc *BlockChain) StatePruner() *pruner.OnlinePruner {
+	if p := bc.statePruner.Load(); p != nil {
+		return p.(*pruner.OnlinePruner)
+	}
+	return nil
+}
+
 // CurrentFastBlock retrieves the current fast-sync head block of the canonical
 // chain. The block is retrieved from the blockchain's internal cache.
 func (bc *BlockChain) CurrentFastBlock() *types.Block {
@@ -1000,6 +1095,10 @@ func (bc *BlockChain) Stop() {
 	bc.StopInsert()
 	bc.wg.Wait()
 
+	// Interrupt any online state pruning, it's resumed on the next startup.
+	if p := bc.StatePruner(); p != nil {
+		p.Stop(bc.CurrentBlock().Root())
+	}
 	// Ensure that the entirety of the state snapshot is journalled to disk.
 	var snapBase common.Hash
 	if bc.snaps != nil {
@@ -1013,9 +1112,16 @@ func (bc *BlockChain) Stop() {
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
 	//  - HEAD-1:   So we don't do large reorgs if our HEAD becomes an uncle
 	//  - HEAD-127: So we have a hard limit on the number of blocks reexecuted
-	if !bc.cacheConfig.TrieDirtyDisabled {
-		triedb := bc.stateCache.TrieDB()
+	// The path-based trie database can only persist HEAD, older states being
+	// reachable through its reverse diffs instead.
+	if triedb := bc.stateCache.TrieDB(); triedb.Scheme() == rawdb.PathScheme {
+		recent := bc.CurrentBlock()
 
+		log.Info("Writing cached state to disk", "block", recent.Number(), "hash", recent.Hash(), "root", recent.Root())
+		if err := triedb.Commit(recent.Root(), true, nil); err != nil {
+			log.Error("Failed to commit recent state trie", "err", err)
+		}
+	} else if !bc.cacheConfig.TrieDirtyDisabled {
 		for _, offset := range []uint64{0, 1, TriesInMemory - 1} {
 			if number := bc.CurrentBlock().NumberU64(); number > offset {
 				recent := bc.GetBlockByNumber(number - offset)
@@ -1094,8 +1200,9 @@ func (bc *BlockChain) truncateAncient(head uint64) error {
 	if err != nil {
 		return err
 	}
-	// Short circuit if there is no data to truncate in ancient store.
-	if frozen <= head+1 {
+	// Short circuit if there is no data to truncate in ancient store, or if
+	// the store is shared and the data beyond the head belongs to other nodes.
+	if frozen <= head+1 || rawdb.SharedAncients(bc.db) {
 		return nil
 	}
 	// Truncate all the data in the freezer beyond the specified head
@@ -1403,6 +1510,60 @@ func (bc *BlockChain) TxLookupLimit() uint64 {
 	return bc.txLookupLimit
 }
 
+// HistoryTail returns the number of the first block whose bodies and receipts
+// are available, or 0 if the chain history was never pruned.
+func (bc *BlockChain) HistoryTail() uint64 {
+	tail, err := bc.db.AncientTail()
+	if err != nil {
+		return 0
+	}
+	return tail
+}
+
+// pruneHistory discards the bodies and receipts of the frozen blocks which fell
+// out of the configured history window. The transaction indices of the pruned
+// blocks are removed first, since they can't be located anymore afterwards.
+func (bc *BlockChain) pruneHistory(head uint64) {
+	limit := bc.cacheConfig.HistoryLimit
+	if limit == 0 || head < limit {
+		return
+	}
+	// The history of a shared ancient store is retained for the other nodes
+	if rawdb.SharedAncients(bc.db) {
+		return
+	}
+	target := head - limit + 1
+
+	frozen, err := bc.db.Ancients()
+	if err != nil {
+		return // No freezer, nothing to prune
+	}
+	if frozen < target {
+		target = frozen
+	}
+	if tail := bc.HistoryTail(); tail >= target {
+		return
+	}
+	// Unindex the pruned blocks, whatever the transaction index limit is. If
+	// the unindexing is interrupted, prune only what was unindexed.
+	var from uint64
+	if indexTail := rawdb.ReadTxIndexTail(bc.db); indexTail != nil {
+		from = *indexTail
+	}
+	if from < target {
+		rawdb.UnindexTransactions(bc.db, from, target, bc.quit)
+		if indexTail := rawdb.ReadTxIndexTail(bc.db); indexTail == nil || *indexTail < target {
+			return
+		}
+	}
+	start := time.Now()
+	if err := bc.db.TruncateAncientTail(target); err != nil {
+		log.Error("Failed to prune chain history", "tail", target, "err", err)
+		return
+	}
+	log.Info("Pruned chain history", "tail", target, "elapsed", common.PrettyDuration(time.Since(start)))
+}
+
 var lastWrite uint64
 
 // writeBlockWithoutState writes only the block and its metadata to the database,
@@ -1481,13 +1642,27 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	}
 	triedb := bc.stateCache.TrieDB()
 
+	// If the state is being pruned online, protect the new trie nodes before
+	// any of them can be flushed to disk.
+	if p := bc.StatePruner(); p != nil {
+		var parentRoot common.Hash
+		if parent := bc.GetHeader(block.ParentHash(), block.NumberU64()-1); parent != nil {
+			parentRoot = parent.Root
+		}
+		// A failed journaling aborts the pruning before returning, so the new
+		// state is safe and the block itself is still valid.
+		if err := p.Journal(parentRoot, root); err != nil {
+			log.Error("Failed to protect state from pruning, pruning aborted", "number", block.Number(), "hash", block.Hash(), "err", err)
+		}
+	}
 	// If we're running an archive node, always flush
 	if bc.cacheConfig.TrieDirtyDisabled {
 		if err := triedb.Commit(root, false, nil); err != nil {
 			return NonStatTy, err
 		}
-	} else {
-		// Full but not archive node, do proper garbage collection
+	} else if triedb.Scheme() != rawdb.PathScheme {
+		// Full but not archive node, do proper garbage collection. The path-based
+		// trie database flattens the old states into disk by itself instead.
 		triedb.Reference(root, common.Hash{}) // metadata reference to keep trie alive
 		bc.triegc.Push(root, -int64(block.NumberU64()))
 
@@ -2290,11 +2465,15 @@ func (bc *BlockChain) maintainTxIndex(ancients uint64) {
 		if bc.txLookupLimit != 0 && ancients > bc.txLookupLimit {
 			from = ancients - bc.txLookupLimit
 		}
+		if tail := bc.HistoryTail(); from < tail {
+			from = tail // Pruned blocks can't be indexed
+		}
 		rawdb.IndexTransactions(bc.db, from, ancients, bc.quit)
 	}
 	// indexBlocks reindexes or unindexes transactions depending on user configuration
 	indexBlocks := func(tail *uint64, head uint64, done chan struct{}) {
 		defer func() { done <- struct{}{} }()
+		defer bc.pruneHistory(head)
 
 		// If the user just upgraded Gczz to a new version which supports transaction
 		// index pruning, write the new tail and remove anything older.
@@ -2308,17 +2487,24 @@ func (bc *BlockChain) maintainTxIndex(ancients uint64) {
 			}
 			return
 		}
-		// If a previous indexing existed, make sure that we fill in any missing entries
+		// If a previous indexing existed, make sure that we fill in any missing
+		// entries, apart from the ones of the pruned history
+		historyTail := bc.HistoryTail()
 		if bc.txLookupLimit == 0 || head < bc.txLookupLimit {
-			if *tail > 0 {
-				rawdb.IndexTransactions(bc.db, 0, *tail, bc.quit)
+			if *tail > historyTail {
+				rawdb.IndexTransactions(bc.db, historyTail, *tail, bc.quit)
 			}
 			return
 		}
 		// Update the transaction index to the new chain state
 		if head-bc.txLookupLimit+1 < *tail {
-			// Reindex a part of missing indices and rewind index tail to HEAD-limit
-			rawdb.IndexTransactions(bc.db, head-bc.txLookupLimit+1, *tail, bc.quit)
+			// Reindex a part of missing indices and rewind index tail to HEAD-limit,
+			// or to the history tail if the blocks before were pruned
+			from := head - bc.txLookupLimit + 1
+			if from < historyTail {
+				from = historyTail
+			}
+			rawdb.IndexTransactions(bc.db, from, *tail, bc.quit)
 		} else {
 			// Unindex a part of stale indices and forward index tail to HEAD-limit
 			rawdb.UnindexTransactions(bc.db, *tail, head-bc.txLookupLimit+1, bc.quit)
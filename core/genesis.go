@@ -190,9 +190,12 @@ func SetupGenesisBlockWithOverride(db czzdb.Database, genesis *Genesis, override
 		return genesis.Config, block.Hash(), nil
 	}
 	// We have the genesis block in database(perhaps in ancient database)
-	// but the corresponding state is missing.
+	// but the corresponding state is missing. The path-based scheme only keeps
+	// the recent states, so the genesis state is legitimately gone as soon as
+	// there's any other state persisted.
 	header := rawdb.ReadHeader(db, stored, 0)
-	if _, err := state.New(header.Root, state.NewDatabaseWithConfig(db, nil), nil); err != nil {
+	pathState := rawdb.ReadStateScheme(db) == rawdb.PathScheme && len(rawdb.ReadAccountTrieNode(db, nil)) > 0
+	if _, err := state.New(header.Root, state.NewDatabaseWithConfig(db, nil), nil); err != nil && !pathState {
 		if genesis == nil {
 			genesis = DefaultGenesisBlock()
 		}
@@ -270,7 +273,8 @@ func (g *Genesis) ToBlock(db czzdb.Database) *types.Block {
 	if db == nil {
 		db = rawdb.NewMemoryDatabase()
 	}
-	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	// Commit the state with the scheme the database was initialised with
+	statedb, err := state.New(common.Hash{}, state.NewDatabaseWithConfig(db, &trie.Config{Scheme: rawdb.ReadStateScheme(db)}), nil)
 	if err != nil {
 		panic(err)
 	}
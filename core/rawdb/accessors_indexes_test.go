@@ -99,7 +99,7 @@ func TestLookupStorage(t *testing.T) {
 			tx3 := types.NewTransaction(3, common.BytesToAddress([]byte{0x33}), big.NewInt(333), 3333, big.NewInt(33333), []byte{0x33, 0x33, 0x33})
 			txs := []*types.Transaction{tx1, tx2, tx3}
 
-			block := types.NewBlock(&types.Header{Number: big.NewInt(314)}, txs, nil, nil, newHasher())
+			block := types.NewBlock(&types.Header{Number: big.NewInt(314)}, txs, nil, newHasher())
 
 			// Check that no transactions entries are in a pristine database
 			for i, tx := range txs {
@@ -141,7 +141,7 @@ func TestDeleteBloomBits(t *testing.T) {
 	for i := uint(0); i < 2; i++ {
 		for s := uint64(0); s < 2; s++ {
 			WriteBloomBits(db, i, s, params.MainnetGenesisHash, []byte{0x01, 0x02})
-			WriteBloomBits(db, i, s, params.RinkebyGenesisHash, []byte{0x01, 0x02})
+			WriteBloomBits(db, i, s, params.TestnetGenesisHash, []byte{0x01, 0x02})
 		}
 	}
 	check := func(bit uint, section uint64, head common.Hash, exist bool) {
@@ -155,25 +155,25 @@ func TestDeleteBloomBits(t *testing.T) {
 	}
 	// Check the existence of written data.
 	check(0, 0, params.MainnetGenesisHash, true)
-	check(0, 0, params.RinkebyGenesisHash, true)
+	check(0, 0, params.TestnetGenesisHash, true)
 
 	// Check the existence of deleted data.
 	DeleteBloombits(db, 0, 0, 1)
 	check(0, 0, params.MainnetGenesisHash, false)
-	check(0, 0, params.RinkebyGenesisHash, false)
+	check(0, 0, params.TestnetGenesisHash, false)
 	check(0, 1, params.MainnetGenesisHash, true)
-	check(0, 1, params.RinkebyGenesisHash, true)
+	check(0, 1, params.TestnetGenesisHash, true)
 
 	// Check the existence of deleted data.
 	DeleteBloombits(db, 0, 0, 2)
 	check(0, 0, params.MainnetGenesisHash, false)
-	check(0, 0, params.RinkebyGenesisHash, false)
+	check(0, 0, params.TestnetGenesisHash, false)
 	check(0, 1, params.MainnetGenesisHash, false)
-	check(0, 1, params.RinkebyGenesisHash, false)
+	check(0, 1, params.TestnetGenesisHash, false)
 
 	// Bit1 shouldn't be affect.
 	check(1, 0, params.MainnetGenesisHash, true)
-	check(1, 0, params.RinkebyGenesisHash, true)
+	check(1, 0, params.TestnetGenesisHash, true)
 	check(1, 1, params.MainnetGenesisHash, true)
-	check(1, 1, params.RinkebyGenesisHash, true)
+	check(1, 1, params.TestnetGenesisHash, true)
 }
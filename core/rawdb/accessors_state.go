@@ -17,11 +17,13 @@
 package rawdb
 
 import (
+	"encoding/binary"
+	"math/big"
+
 	"github.com/classzz/go-classzz-v2/common"
 	"github.com/classzz/go-classzz-v2/czzdb"
 	"github.com/classzz/go-classzz-v2/log"
 	"github.com/classzz/go-classzz-v2/rlp"
-	"math/big"
 )
 
 // ReadPreimage retrieves a single preimage of the provided hash.
@@ -97,6 +99,127 @@ func DeleteTrieNode(db czzdb.KeyValueWriter, hash common.Hash) {
 	}
 }
 
+// The list of storage schemes supported by the trie database.
+const (
+	HashScheme = "hash" // Trie nodes keyed by their hash, garbage collected by reference counting
+	PathScheme = "path" // Trie nodes keyed by their owner and path, overwritten in place
+)
+
+// ReadStateScheme retrieves the storage scheme of the trie nodes persisted in
+// the database. An empty string is returned if the scheme was never recorded.
+func ReadStateScheme(db czzdb.KeyValueReader) string {
+	data, _ := db.Get(stateSchemeKey)
+	return string(data)
+}
+
+// WriteStateScheme stores the storage scheme of the trie nodes into the database.
+func WriteStateScheme(db czzdb.KeyValueWriter, scheme string) {
+	if err := db.Put(stateSchemeKey, []byte(scheme)); err != nil {
+		log.Crit("Failed to store state scheme", "err", err)
+	}
+}
+
+// ReadAccountTrieNode retrieves the account trie node stored at the given path.
+func ReadAccountTrieNode(db czzdb.KeyValueReader, path []byte) []byte {
+	data, _ := db.Get(accountTrieNodeKey(path))
+	return data
+}
+
+// WriteAccountTrieNode writes the provided account trie node at the given path.
+func WriteAccountTrieNode(db czzdb.KeyValueWriter, path []byte, node []byte) {
+	if err := db.Put(accountTrieNodeKey(path), node); err != nil {
+		log.Crit("Failed to store account trie node", "err", err)
+	}
+}
+
+// DeleteAccountTrieNode deletes the account trie node stored at the given path.
+func DeleteAccountTrieNode(db czzdb.KeyValueWriter, path []byte) {
+	if err := db.Delete(accountTrieNodeKey(path)); err != nil {
+		log.Crit("Failed to delete account trie node", "err", err)
+	}
+}
+
+// ReadStorageTrieNode retrieves the storage trie node of the given account
+// stored at the given path.
+func ReadStorageTrieNode(db czzdb.KeyValueReader, accountHash common.Hash, path []byte) []byte {
+	data, _ := db.Get(storageTrieNodeKey(accountHash, path))
+	return data
+}
+
+// WriteStorageTrieNode writes the provided storage trie node of the given account
+// at the given path.
+func WriteStorageTrieNode(db czzdb.KeyValueWriter, accountHash common.Hash, path []byte, node []byte) {
+	if err := db.Put(storageTrieNodeKey(accountHash, path), node); err != nil {
+		log.Crit("Failed to store storage trie node", "err", err)
+	}
+}
+
+// DeleteStorageTrieNode deletes the storage trie node of the given account
+// stored at the given path.
+func DeleteStorageTrieNode(db czzdb.KeyValueWriter, accountHash common.Hash, path []byte) {
+	if err := db.Delete(storageTrieNodeKey(accountHash, path)); err != nil {
+		log.Crit("Failed to delete storage trie node", "err", err)
+	}
+}
+
+// IterateStorageTrieNodes returns an iterator over all the storage trie nodes
+// of the given account. The returned keys have the account prefix stripped off,
+// leaving the hex path of the node only.
+func IterateStorageTrieNodes(db czzdb.Iteratee, accountHash common.Hash) czzdb.Iterator {
+	prefix := storageTrieNodeKey(accountHash, nil)
+	return &prefixedIterator{it: db.NewIterator(prefix, nil), skip: len(prefix)}
+}
+
+// prefixedIterator is a wrapper around a database iterator, trimming a fixed
+// length prefix from all the returned keys.
+type prefixedIterator struct {
+	it   czzdb.Iterator
+	skip int
+}
+
+func (it *prefixedIterator) Next() bool    { return it.it.Next() }
+func (it *prefixedIterator) Error() error  { return it.it.Error() }
+func (it *prefixedIterator) Key() []byte   { return it.it.Key()[it.skip:] }
+func (it *prefixedIterator) Value() []byte { return it.it.Value() }
+func (it *prefixedIterator) Release()      { it.it.Release() }
+
+// ReadReverseDiff retrieves the RLP encoded reverse state diff with the given id.
+func ReadReverseDiff(db czzdb.KeyValueReader, id uint64) []byte {
+	data, _ := db.Get(reverseDiffKey(id))
+	return data
+}
+
+// WriteReverseDiff stores the RLP encoded reverse state diff with the given id.
+func WriteReverseDiff(db czzdb.KeyValueWriter, id uint64, blob []byte) {
+	if err := db.Put(reverseDiffKey(id), blob); err != nil {
+		log.Crit("Failed to store reverse diff", "err", err)
+	}
+}
+
+// DeleteReverseDiff deletes the reverse state diff with the given id.
+func DeleteReverseDiff(db czzdb.KeyValueWriter, id uint64) {
+	if err := db.Delete(reverseDiffKey(id)); err != nil {
+		log.Crit("Failed to delete reverse diff", "err", err)
+	}
+}
+
+// ReadReverseDiffHead retrieves the id of the latest stored reverse state diff,
+// or zero if none was ever stored.
+func ReadReverseDiffHead(db czzdb.KeyValueReader) uint64 {
+	data, _ := db.Get(reverseDiffHeadKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// WriteReverseDiffHead stores the id of the latest stored reverse state diff.
+func WriteReverseDiffHead(db czzdb.KeyValueWriter, id uint64) {
+	if err := db.Put(reverseDiffHeadKey, encodeBlockNumber(id)); err != nil {
+		log.Crit("Failed to store reverse diff head", "err", err)
+	}
+}
+
 func HasRecord(db czzdb.KeyValueReader, atype uint64, hash common.Hash) bool {
 	if has, err := db.Has(recordKeyPrefix(atype, hash)); !has || err != nil {
 		return false
@@ -21,6 +21,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -28,19 +30,37 @@ import (
 	"github.com/classzz/go-classzz-v2/czzdb"
 	"github.com/classzz/go-classzz-v2/czzdb/leveldb"
 	"github.com/classzz/go-classzz-v2/czzdb/memorydb"
+	"github.com/classzz/go-classzz-v2/czzdb/remotedb"
 	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/params"
 	"github.com/olekukonko/tablewriter"
 )
 
 // freezerdb is a database wrapper that enabled freezer data retrievals.
 type freezerdb struct {
+	// WARNING: The `threshold` field is accessed atomically. On 32 bit platforms,
+	// only 64-bit aligned fields can be atomic. The struct is guaranteed to be so
+	// aligned, so take advantage of that.
+	threshold uint64 // Number of recent blocks not to freeze (params.FullImmutabilityThreshold apart from tests)
+
 	czzdb.KeyValueStore
 	czzdb.AncientStore
+
+	readonly bool
+	trigger  chan chan struct{} // Manual blocking freeze trigger, test determinism
+
+	quit      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
 }
 
 // Close implements io.Closer, closing both the fast key-value store as well as
 // the slow ancient tables.
 func (frdb *freezerdb) Close() error {
+	// Wait for any background freezing to stop
+	frdb.closeOnce.Do(func() { close(frdb.quit) })
+	frdb.wg.Wait()
+
 	var errs []error
 	if err := frdb.AncientStore.Close(); err != nil {
 		errs = append(errs, err)
@@ -58,18 +78,18 @@ func (frdb *freezerdb) Close() error {
 // a freeze cycle completes, without having to sleep for a minute to trigger the
 // automatic background run.
 func (frdb *freezerdb) Freeze(threshold uint64) error {
-	if frdb.AncientStore.(*freezer).readonly {
+	if frdb.readonly {
 		return errReadOnly
 	}
 	// Set the freezer threshold to a temporary value
 	defer func(old uint64) {
-		atomic.StoreUint64(&frdb.AncientStore.(*freezer).threshold, old)
-	}(atomic.LoadUint64(&frdb.AncientStore.(*freezer).threshold))
-	atomic.StoreUint64(&frdb.AncientStore.(*freezer).threshold, threshold)
+		atomic.StoreUint64(&frdb.threshold, old)
+	}(atomic.LoadUint64(&frdb.threshold))
+	atomic.StoreUint64(&frdb.threshold, threshold)
 
 	// Trigger a freeze cycle and block until it's done
 	trigger := make(chan struct{}, 1)
-	frdb.AncientStore.(*freezer).trigger <- trigger
+	frdb.trigger <- trigger
 	<-trigger
 	return nil
 }
@@ -104,6 +124,11 @@ func (db *nofreezedb) AncientSize(kind string) (uint64, error) {
 	return 0, errNotSupported
 }
 
+// AncientTail returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) AncientTail() (uint64, error) {
+	return 0, errNotSupported
+}
+
 // AppendAncient returns an error as we don't have a backing chain freezer.
 func (db *nofreezedb) AppendAncient(number uint64, hash, header, body, receipts, td []byte) error {
 	return errNotSupported
@@ -114,6 +139,11 @@ func (db *nofreezedb) TruncateAncients(items uint64) error {
 	return errNotSupported
 }
 
+// TruncateAncientTail returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) TruncateAncientTail(tail uint64) error {
+	return errNotSupported
+}
+
 // Sync returns an error as we don't have a backing chain freezer.
 func (db *nofreezedb) Sync() error {
 	return errNotSupported
@@ -130,9 +160,20 @@ func NewDatabase(db czzdb.KeyValueStore) czzdb.Database {
 // NewDatabaseWithFreezer creates a high level database on top of a given key-
 // value data store with a freezer moving immutable chain segments into cold
 // storage.
+//
+// The freezer is either a local directory or, if prefixed with remote://, the
+// address of an ancient store served over the network.
 func NewDatabaseWithFreezer(db czzdb.KeyValueStore, freezer string, namespace string, readonly bool) (czzdb.Database, error) {
 	// Create the idle freezer instance
-	frdb, err := newFreezer(freezer, namespace, readonly)
+	var (
+		frdb czzdb.AncientStore
+		err  error
+	)
+	if remotedb.IsRemote(freezer) {
+		frdb, err = remotedb.Dial(freezer, readonly)
+	} else {
+		frdb, err = newFreezer(freezer, namespace, readonly)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -201,17 +242,42 @@ func NewDatabaseWithFreezer(db czzdb.KeyValueStore, freezer string, namespace st
 		}
 	}
 	// Freezer is consistent with the key-value database, permit combining the two
-	if !frdb.readonly {
-		frdb.wg.Add(1)
+	fdb := &freezerdb{
+		threshold:     params.FullImmutabilityThreshold,
+		KeyValueStore: db,
+		AncientStore:  frdb,
+		readonly:      readonly,
+		trigger:       make(chan chan struct{}),
+		quit:          make(chan struct{}),
+	}
+	if !readonly {
+		fdb.wg.Add(1)
 		go func() {
-			frdb.freeze(db)
-			frdb.wg.Done()
+			fdb.freeze()
+			fdb.wg.Done()
 		}()
 	}
-	return &freezerdb{
-		KeyValueStore: db,
-		AncientStore:  frdb,
-	}, nil
+	return fdb, nil
+}
+
+// SharedAncients reports whether the ancient store of the database is served by
+// another process and shared with other nodes, which may have frozen the chain
+// beyond the local head. A shared store is never truncated by the node.
+func SharedAncients(db czzdb.Reader) bool {
+	switch db := db.(type) {
+	case *freezerdb:
+		_, ok := db.AncientStore.(*remotedb.Database)
+		return ok
+	case *table:
+		return SharedAncients(db.db)
+	}
+	return false
+}
+
+// NewAncientStore opens the append-only immutable chain data files in the given
+// directory without a key-value store, e.g. to serve them to other nodes.
+func NewAncientStore(datadir string, namespace string, readonly bool) (czzdb.AncientStore, error) {
+	return newFreezer(datadir, namespace, readonly)
 }
 
 // NewMemoryDatabase creates an ephemeral in-memory key-value database without a
@@ -252,6 +318,30 @@ func NewLevelDBDatabaseWithFreezer(file string, cache int, handles int, freezer
 	return frdb, nil
 }
 
+const (
+	// DBLeveldb is the database engine name of leveldb backed key-value stores.
+	DBLeveldb = "leveldb"
+
+	// DBPebble is the database engine name of pebble backed key-value stores.
+	DBPebble = "pebble"
+)
+
+// PreexistingDatabase checks the given data directory whether a database is
+// already instantiated at that location, and if so, returns the type of the
+// database (or an empty string).
+func PreexistingDatabase(path string) string {
+	if _, err := os.Stat(filepath.Join(path, "CURRENT")); err != nil {
+		return "" // No pre-existing db
+	}
+	if matches, err := filepath.Glob(filepath.Join(path, "OPTIONS*")); len(matches) > 0 || err != nil {
+		if err != nil {
+			panic(err) // only possible if the pattern is malformed
+		}
+		return DBPebble
+	}
+	return DBLeveldb
+}
+
 type counter uint64
 
 func (c counter) String() string {
@@ -301,6 +391,8 @@ func InspectDatabase(db czzdb.Database, keyPrefix, keyStart []byte) error {
 		numHashPairings stat
 		hashNumPairings stat
 		tries           stat
+		pathTries       stat
+		reverseDiffs    stat
 		codes           stat
 		txLookups       stat
 		accountSnaps    stat
@@ -347,6 +439,13 @@ func InspectDatabase(db czzdb.Database, keyPrefix, keyStart []byte) error {
 			numHashPairings.Add(size)
 		case bytes.HasPrefix(key, headerNumberPrefix) && len(key) == (len(headerNumberPrefix)+common.HashLength):
 			hashNumPairings.Add(size)
+		case bytes.HasPrefix(key, trieNodeAccountPrefix) && isHexPath(key[len(trieNodeAccountPrefix):]):
+			pathTries.Add(size)
+		case bytes.HasPrefix(key, trieNodeStoragePrefix) && len(key) >= len(trieNodeStoragePrefix)+common.HashLength &&
+			isHexPath(key[len(trieNodeStoragePrefix)+common.HashLength:]):
+			pathTries.Add(size)
+		case bytes.HasPrefix(key, reverseDiffPrefix) && len(key) == len(reverseDiffPrefix)+8:
+			reverseDiffs.Add(size)
 		case len(key) == common.HashLength:
 			tries.Add(size)
 		case bytes.HasPrefix(key, CodePrefix) && len(key) == len(CodePrefix)+common.HashLength:
@@ -381,7 +480,7 @@ func InspectDatabase(db czzdb.Database, keyPrefix, keyStart []byte) error {
 				databaseVersionKey, headHeaderKey, headBlockKey, headFastBlockKey, lastPivotKey,
 				fastTrieProgressKey, snapshotDisabledKey, snapshotRootKey, snapshotJournalKey,
 				snapshotGeneratorKey, snapshotRecoveryKey, txIndexTailKey, fastTxLookupLimitKey,
-				uncleanShutdownKey, badBlockKey,
+				uncleanShutdownKey, badBlockKey, stateSchemeKey, reverseDiffHeadKey,
 			} {
 				if bytes.Equal(key, meta) {
 					metadata.Add(size)
@@ -424,6 +523,8 @@ func InspectDatabase(db czzdb.Database, keyPrefix, keyStart []byte) error {
 		{"Key-Value store", "Bloombit index", bloomBits.Size(), bloomBits.Count()},
 		{"Key-Value store", "Contract codes", codes.Size(), codes.Count()},
 		{"Key-Value store", "Trie nodes", tries.Size(), tries.Count()},
+		{"Key-Value store", "Path trie nodes", pathTries.Size(), pathTries.Count()},
+		{"Key-Value store", "Reverse state diffs", reverseDiffs.Size(), reverseDiffs.Count()},
 		{"Key-Value store", "Trie preimages", preimages.Size(), preimages.Count()},
 		{"Key-Value store", "Account snapshot", accountSnaps.Size(), accountSnaps.Count()},
 		{"Key-Value store", "Storage snapshot", storageSnaps.Size(), storageSnaps.Count()},
@@ -449,3 +550,17 @@ func InspectDatabase(db czzdb.Database, keyPrefix, keyStart []byte) error {
 
 	return nil
 }
+
+// isHexPath reports whether the given key suffix is a hex nibble path of a trie
+// node stored under the path scheme.
+func isHexPath(path []byte) bool {
+	if len(path) > 2*common.HashLength {
+		return false
+	}
+	for _, nibble := range path {
+		if nibble >= 16 {
+			return false
+		}
+	}
+	return true
+}
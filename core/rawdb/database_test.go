@@ -15,3 +15,163 @@
 // along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
 
 package rawdb
+
+import (
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/czzdb/memorydb"
+	"github.com/classzz/go-classzz-v2/czzdb/remotedb"
+)
+
+// Tests that the chain data is frozen into and retrieved from an ancient store
+// served by another process.
+func TestRemoteFreezer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote-freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewAncientStore(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to open ancient store: %v", err)
+	}
+	defer store.Close()
+
+	server, err := remotedb.NewServer(store, false)
+	if err != nil {
+		t.Fatalf("failed to create ancient store server: %v", err)
+	}
+	defer server.Stop()
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	db, err := NewDatabaseWithFreezer(memorydb.New(), remotedb.Scheme+strings.TrimPrefix(httpsrv.URL, "http://"), "", false)
+	if err != nil {
+		t.Fatalf("failed to open database with remote freezer: %v", err)
+	}
+	defer db.Close()
+
+	// Write a chain of blocks and freeze all but the recent ones
+	var (
+		blocks []*types.Block
+		parent *types.Block
+	)
+	for i := 0; i < 10; i++ {
+		header := &types.Header{Number: big.NewInt(int64(i)), Difficulty: big.NewInt(1), Extra: []byte("remote freezer")}
+		if parent != nil {
+			header.ParentHash = parent.Hash()
+		}
+		block := types.NewBlockWithHeader(header)
+		WriteBlock(db, block)
+		WriteReceipts(db, block.Hash(), block.NumberU64(), nil)
+		WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		WriteTd(db, block.Hash(), block.NumberU64(), big.NewInt(int64(i+1)))
+		blocks, parent = append(blocks, block), block
+	}
+	WriteHeadBlockHash(db, parent.Hash())
+
+	if err := db.(*freezerdb).Freeze(2); err != nil {
+		t.Fatalf("failed to freeze chain: %v", err)
+	}
+	if frozen, _ := store.Ancients(); frozen != 8 {
+		t.Fatalf("frozen blocks mismatch: have %d, want 8", frozen)
+	}
+	for _, block := range blocks {
+		if hash := ReadCanonicalHash(db, block.NumberU64()); hash != block.Hash() {
+			t.Fatalf("block %d: canonical hash mismatch: have %x, want %x", block.NumberU64(), hash, block.Hash())
+		}
+		if header := ReadHeader(db, block.Hash(), block.NumberU64()); header == nil || header.Hash() != block.Hash() {
+			t.Fatalf("block %d: header mismatch", block.NumberU64())
+		}
+		if td := ReadTd(db, block.Hash(), block.NumberU64()); td == nil || td.Uint64() != block.NumberU64()+1 {
+			t.Fatalf("block %d: total difficulty mismatch: have %v", block.NumberU64(), td)
+		}
+	}
+	// The frozen blocks apart from the genesis should be gone from the key-value store
+	kvdb := &nofreezedb{KeyValueStore: db.(*freezerdb).KeyValueStore}
+	if HasHeader(kvdb, blocks[5].Hash(), 5) {
+		t.Fatalf("frozen block left in key-value store")
+	}
+	if !HasHeader(kvdb, blocks[9].Hash(), 9) {
+		t.Fatalf("recent block missing from key-value store")
+	}
+}
+
+// Tests that a node behind a shared ancient store keeps the blocks frozen by the
+// nodes ahead of it, and never truncates the store.
+func TestSharedRemoteFreezer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote-freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewAncientStore(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to open ancient store: %v", err)
+	}
+	defer store.Close()
+
+	server, err := remotedb.NewServer(store, false)
+	if err != nil {
+		t.Fatalf("failed to create ancient store server: %v", err)
+	}
+	defer server.Stop()
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+	path := remotedb.Scheme + strings.TrimPrefix(httpsrv.URL, "http://")
+
+	// Freeze the chain of the node ahead
+	ahead, err := NewDatabaseWithFreezer(memorydb.New(), path, "", false)
+	if err != nil {
+		t.Fatalf("failed to open database with remote freezer: %v", err)
+	}
+	defer ahead.Close()
+
+	blocks := makeVerifyChain(ahead, 10)
+	if err := ahead.(*freezerdb).Freeze(2); err != nil {
+		t.Fatalf("failed to freeze chain: %v", err)
+	}
+	// Join the store with a node having only the start of the same chain
+	kvdb := memorydb.New()
+	makeVerifyChain(NewDatabase(kvdb), 4)
+
+	behind, err := NewDatabaseWithFreezer(kvdb, path, "", false)
+	if err != nil {
+		t.Fatalf("failed to join shared ancient store: %v", err)
+	}
+	defer behind.Close()
+
+	if !SharedAncients(behind) || !SharedAncients(NewTable(behind, "prefix")) || SharedAncients(NewMemoryDatabase()) {
+		t.Fatalf("shared ancient store not recognised")
+	}
+	if frozen, _ := behind.Ancients(); frozen != 8 {
+		t.Fatalf("frozen blocks mismatch: have %d, want 8", frozen)
+	}
+	if hash := ReadCanonicalHash(behind, 7); hash != blocks[7].Hash() {
+		t.Fatalf("block frozen by other node missing: have %x, want %x", hash, blocks[7].Hash())
+	}
+	if err := behind.TruncateAncients(4); err == nil {
+		t.Fatalf("shared ancient store truncated")
+	}
+	// The blocks frozen beyond the local head are no issue, nor repaired
+	report, err := VerifyDatabase(behind, newHasher(), true)
+	if err != nil {
+		t.Fatalf("failed to verify database: %v", err)
+	}
+	if found := report.Found(); found != 0 {
+		t.Fatalf("issues found in shared database: %v", report.Issues)
+	}
+	if frozen, _ := store.Ancients(); frozen != 8 {
+		t.Fatalf("shared ancient store truncated by repair: have %d, want 8", frozen)
+	}
+}
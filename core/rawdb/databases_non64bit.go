@@ -0,0 +1,43 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !arm64,!amd64
+
+package rawdb
+
+import (
+	"errors"
+
+	"github.com/classzz/go-classzz-v2/czzdb"
+)
+
+// PebbleEnabled indicates whether the pebble database engine is available on
+// the running platform.
+const PebbleEnabled = false
+
+// errPebbleUnsupported is returned when a pebble database is requested on a
+// platform that the engine doesn't support.
+var errPebbleUnsupported = errors.New("pebble is not supported on this platform")
+
+// NewPebbleDBDatabase is unsupported on this platform.
+func NewPebbleDBDatabase(file string, cache int, handles int, namespace string, readonly bool) (czzdb.Database, error) {
+	return nil, errPebbleUnsupported
+}
+
+// NewPebbleDBDatabaseWithFreezer is unsupported on this platform.
+func NewPebbleDBDatabaseWithFreezer(file string, cache int, handles int, freezer string, namespace string, readonly bool) (czzdb.Database, error) {
+	return nil, errPebbleUnsupported
+}
@@ -27,10 +27,8 @@ import (
 	"time"
 
 	"github.com/classzz/go-classzz-v2/common"
-	"github.com/classzz/go-classzz-v2/czzdb"
 	"github.com/classzz/go-classzz-v2/log"
 	"github.com/classzz/go-classzz-v2/metrics"
-	"github.com/classzz/go-classzz-v2/params"
 	"github.com/prometheus/tsdb/fileutil"
 )
 
@@ -50,6 +48,10 @@ var (
 	// errSymlinkDatadir is returned if the ancient directory specified by user
 	// is a symbolic link.
 	errSymlinkDatadir = errors.New("symbolic link datadir is not supported")
+
+	// errTruncateBelowTail is returned if the user attempts to truncate the
+	// freezer below its pruned tail, whose data has already been deleted.
+	errTruncateBelowTail = errors.New("truncating below the pruned tail")
 )
 
 const (
@@ -74,17 +76,12 @@ type freezer struct {
 	// WARNING: The `frozen` field is accessed atomically. On 32 bit platforms, only
 	// 64-bit aligned fields can be atomic. The struct is guaranteed to be so aligned,
 	// so take advantage of that (https://golang.org/pkg/sync/atomic/#pkg-note-BUG).
-	frozen    uint64 // Number of blocks already frozen
-	threshold uint64 // Number of recent blocks not to freeze (params.FullImmutabilityThreshold apart from tests)
+	frozen uint64 // Number of blocks already frozen
 
 	readonly     bool
 	tables       map[string]*freezerTable // Data tables for storing everything
 	instanceLock fileutil.Releaser        // File-system lock to prevent double opens
 
-	trigger chan chan struct{} // Manual blocking freeze trigger, test determinism
-
-	quit      chan struct{}
-	wg        sync.WaitGroup
 	closeOnce sync.Once
 }
 
@@ -113,11 +110,8 @@ func newFreezer(datadir string, namespace string, readonly bool) (*freezer, erro
 	// Open all the supported data tables
 	freezer := &freezer{
 		readonly:     readonly,
-		threshold:    params.FullImmutabilityThreshold,
 		tables:       make(map[string]*freezerTable),
 		instanceLock: lock,
-		trigger:      make(chan chan struct{}),
-		quit:         make(chan struct{}),
 	}
 	for name, disableSnappy := range FreezerNoSnappy {
 		table, err := newTable(datadir, name, readMeter, writeMeter, sizeGauge, disableSnappy)
@@ -145,9 +139,6 @@ func newFreezer(datadir string, namespace string, readonly bool) (*freezer, erro
 func (f *freezer) Close() error {
 	var errs []error
 	f.closeOnce.Do(func() {
-		close(f.quit)
-		// Wait for any background freezing to stop
-		f.wg.Wait()
 		for _, table := range f.tables {
 			if err := table.Close(); err != nil {
 				errs = append(errs, err)
@@ -205,6 +196,12 @@ func (f *freezer) AncientSize(kind string) (uint64, error) {
 	return 0, errUnknownTable
 }
 
+// AncientTail returns the number of the first block whose bodies and receipts
+// are retained in the freezer.
+func (f *freezer) AncientTail() (uint64, error) {
+	return f.tables[freezerBodiesTable].tail(), nil
+}
+
 // AppendAncient injects all binary blobs belong to block at the end of the
 // append-only immutable table files.
 //
@@ -263,6 +260,11 @@ func (f *freezer) TruncateAncients(items uint64) error {
 	if atomic.LoadUint64(&f.frozen) <= items {
 		return nil
 	}
+	// The pruned history can't be restored, refuse rewinding below it before
+	// touching any of the tables
+	if tail := f.tables[freezerBodiesTable].tail(); items < tail {
+		return fmt.Errorf("%w: the bodies and receipts below block %d were pruned, cannot rewind the freezer to %d items", errTruncateBelowTail, tail, items)
+	}
 	for _, table := range f.tables {
 		if err := table.truncate(items); err != nil {
 			return err
@@ -272,6 +274,24 @@ func (f *freezer) TruncateAncients(items uint64) error {
 	return nil
 }
 
+// TruncateAncientTail discards the bodies and receipts of all frozen blocks below
+// the given number. Headers, hashes and difficulties are retained so the chain
+// remains verifiable.
+func (f *freezer) TruncateAncientTail(tail uint64) error {
+	if f.readonly {
+		return errReadOnly
+	}
+	if frozen := atomic.LoadUint64(&f.frozen); tail > frozen {
+		return fmt.Errorf("pruning history beyond frozen blocks: frozen %d, tail %d", frozen, tail)
+	}
+	for _, kind := range freezerHistoryTables {
+		if err := f.tables[kind].TruncateTail(tail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Sync flushes all data tables to disk.
 func (f *freezer) Sync() error {
 	var errs []error
@@ -287,12 +307,16 @@ func (f *freezer) Sync() error {
 }
 
 // freeze is a background thread that periodically checks the blockchain for any
-// import progress and moves ancient data from the fast database into the freezer.
+// import progress and moves ancient data from the fast database into the ancient
+// store.
 //
 // This functionality is deliberately broken off from block importing to avoid
 // incurring additional data shuffling delays on block propagation.
-func (f *freezer) freeze(db czzdb.KeyValueStore) {
-	nfdb := &nofreezedb{KeyValueStore: db}
+func (frdb *freezerdb) freeze() {
+	var (
+		db   = frdb.KeyValueStore
+		nfdb = &nofreezedb{KeyValueStore: db}
+	)
 
 	var (
 		backoff   bool
@@ -300,7 +324,7 @@ func (f *freezer) freeze(db czzdb.KeyValueStore) {
 	)
 	for {
 		select {
-		case <-f.quit:
+		case <-frdb.quit:
 			log.Info("Freezer shutting down")
 			return
 		default:
@@ -314,9 +338,9 @@ func (f *freezer) freeze(db czzdb.KeyValueStore) {
 			select {
 			case <-time.NewTimer(freezerRecheckInterval).C:
 				backoff = false
-			case triggered = <-f.trigger:
+			case triggered = <-frdb.trigger:
 				backoff = false
-			case <-f.quit:
+			case <-frdb.quit:
 				return
 			}
 		}
@@ -328,7 +352,14 @@ func (f *freezer) freeze(db czzdb.KeyValueStore) {
 			continue
 		}
 		number := ReadHeaderNumber(nfdb, hash)
-		threshold := atomic.LoadUint64(&f.threshold)
+		threshold := atomic.LoadUint64(&frdb.threshold)
+
+		frozen, err := frdb.Ancients()
+		if err != nil {
+			log.Error("Ancient item count unavailable", "err", err)
+			backoff = true
+			continue
+		}
 
 		switch {
 		case number == nil:
@@ -341,8 +372,8 @@ func (f *freezer) freeze(db czzdb.KeyValueStore) {
 			backoff = true
 			continue
 
-		case *number-threshold <= f.frozen:
-			log.Debug("Ancient blocks frozen already", "number", *number, "hash", hash, "frozen", f.frozen)
+		case *number-threshold <= frozen:
+			log.Debug("Ancient blocks frozen already", "number", *number, "hash", hash, "frozen", frozen)
 			backoff = true
 			continue
 		}
@@ -354,50 +385,51 @@ func (f *freezer) freeze(db czzdb.KeyValueStore) {
 		}
 		// Seems we have data ready to be frozen, process in usable batches
 		limit := *number - threshold
-		if limit-f.frozen > freezerBatchLimit {
-			limit = f.frozen + freezerBatchLimit
+		if limit-frozen > freezerBatchLimit {
+			limit = frozen + freezerBatchLimit
 		}
 		var (
 			start    = time.Now()
-			first    = f.frozen
-			ancients = make([]common.Hash, 0, limit-f.frozen)
+			first    = frozen
+			ancients = make([]common.Hash, 0, limit-frozen)
 		)
-		for f.frozen <= limit {
+		for frozen <= limit {
 			// Retrieves all the components of the canonical block
-			hash := ReadCanonicalHash(nfdb, f.frozen)
+			hash := ReadCanonicalHash(nfdb, frozen)
 			if hash == (common.Hash{}) {
-				log.Error("Canonical hash missing, can't freeze", "number", f.frozen)
+				log.Error("Canonical hash missing, can't freeze", "number", frozen)
 				break
 			}
-			header := ReadHeaderRLP(nfdb, hash, f.frozen)
+			header := ReadHeaderRLP(nfdb, hash, frozen)
 			if len(header) == 0 {
-				log.Error("Block header missing, can't freeze", "number", f.frozen, "hash", hash)
+				log.Error("Block header missing, can't freeze", "number", frozen, "hash", hash)
 				break
 			}
-			body := ReadBodyRLP(nfdb, hash, f.frozen)
+			body := ReadBodyRLP(nfdb, hash, frozen)
 			if len(body) == 0 {
-				log.Error("Block body missing, can't freeze", "number", f.frozen, "hash", hash)
+				log.Error("Block body missing, can't freeze", "number", frozen, "hash", hash)
 				break
 			}
-			receipts := ReadReceiptsRLP(nfdb, hash, f.frozen)
+			receipts := ReadReceiptsRLP(nfdb, hash, frozen)
 			if len(receipts) == 0 {
-				log.Error("Block receipts missing, can't freeze", "number", f.frozen, "hash", hash)
+				log.Error("Block receipts missing, can't freeze", "number", frozen, "hash", hash)
 				break
 			}
-			td := ReadTdRLP(nfdb, hash, f.frozen)
+			td := ReadTdRLP(nfdb, hash, frozen)
 			if len(td) == 0 {
-				log.Error("Total difficulty missing, can't freeze", "number", f.frozen, "hash", hash)
+				log.Error("Total difficulty missing, can't freeze", "number", frozen, "hash", hash)
 				break
 			}
-			log.Trace("Deep froze ancient block", "number", f.frozen, "hash", hash)
+			log.Trace("Deep froze ancient block", "number", frozen, "hash", hash)
 			// Inject all the components into the relevant data tables
-			if err := f.AppendAncient(f.frozen, hash[:], header, body, receipts, td); err != nil {
+			if err := frdb.AppendAncient(frozen, hash[:], header, body, receipts, td); err != nil {
 				break
 			}
 			ancients = append(ancients, hash)
+			frozen++
 		}
 		// Batch of blocks have been frozen, flush them before wiping from leveldb
-		if err := f.Sync(); err != nil {
+		if err := frdb.Sync(); err != nil {
 			log.Crit("Failed to flush frozen tables", "err", err)
 		}
 		// Wipe out all data from the active database
@@ -416,7 +448,7 @@ func (f *freezer) freeze(db czzdb.KeyValueStore) {
 
 		// Wipe out side chains also and track dangling side chains
 		var dangling []common.Hash
-		for number := first; number < f.frozen; number++ {
+		for number := first; number < frozen; number++ {
 			// Always keep the genesis block in active database
 			if number != 0 {
 				dangling = ReadAllHashes(db, number)
@@ -432,8 +464,8 @@ func (f *freezer) freeze(db czzdb.KeyValueStore) {
 		batch.Reset()
 
 		// Step into the future and delete and dangling side chains
-		if f.frozen > 0 {
-			tip := f.frozen
+		if frozen > 0 {
+			tip := frozen
 			for len(dangling) > 0 {
 				drop := make(map[common.Hash]struct{})
 				for _, hash := range dangling {
@@ -466,7 +498,7 @@ func (f *freezer) freeze(db czzdb.KeyValueStore) {
 		}
 		// Log something friendly for the user
 		context := []interface{}{
-			"blocks", f.frozen - first, "elapsed", common.PrettyDuration(time.Since(start)), "number", f.frozen - 1,
+			"blocks", frozen - first, "elapsed", common.PrettyDuration(time.Since(start)), "number", frozen - 1,
 		}
 		if n := len(ancients); n > 0 {
 			context = append(context, []interface{}{"hash", ancients[n-1]}...)
@@ -474,7 +506,7 @@ func (f *freezer) freeze(db czzdb.KeyValueStore) {
 		log.Info("Deep froze chain segment", context...)
 
 		// Avoid database thrashing with tiny writes
-		if f.frozen-first < freezerBatchLimit {
+		if frozen-first < freezerBatchLimit {
 			backoff = true
 		}
 	}
@@ -102,11 +102,16 @@ type freezerTable struct {
 	headId uint32              // number of the currently active head file
 	tailId uint32              // number of the earliest file
 	index  *os.File            // File descriptor for the indexEntry file of the table
+	meta   *os.File            // File descriptor for the metadata file of the table
 
 	// In the case that old items are deleted (from the tail), we use itemOffset
 	// to count how many historic items have gone missing.
 	itemOffset uint32 // Offset (number of discarded items)
 
+	// Items below itemHidden have been truncated from the tail, but may still be
+	// physically present if the data file containing them holds retained items.
+	itemHidden uint64 // Number of items hidden from the tail (accessed atomically)
+
 	headBytes  uint32        // Number of bytes written to the head file
 	readMeter  metrics.Meter // Meter for measuring the effective amount of data read
 	writeMeter metrics.Meter // Meter for measuring the effective amount of data written
@@ -184,9 +189,15 @@ func newCustomTable(path string, name string, readMeter metrics.Meter, writeMete
 	if err != nil {
 		return nil, err
 	}
+	meta, err := os.OpenFile(filepath.Join(path, fmt.Sprintf("%s.meta", name)), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		offsets.Close()
+		return nil, err
+	}
 	// Create the table and repair any past inconsistency
 	tab := &freezerTable{
 		index:         offsets,
+		meta:          meta,
 		files:         make(map[uint32]*os.File),
 		readMeter:     readMeter,
 		writeMeter:    writeMeter,
@@ -253,6 +264,12 @@ func (t *freezerTable) repair() error {
 	t.tailId = firstIndex.filenum
 	t.itemOffset = firstIndex.offset
 
+	// A crash during a tail truncation may leave data files behind which the
+	// index no longer references, delete them
+	if err := t.removeFilesBefore(t.tailId); err != nil {
+		return err
+	}
+
 	t.index.ReadAt(buffer, offsetsSize-indexEntrySize)
 	lastIndex.unmarshalBinary(buffer)
 	t.head, err = t.openFile(lastIndex.filenum, openFreezerFileForAppend)
@@ -316,14 +333,55 @@ func (t *freezerTable) repair() error {
 	t.headBytes = uint32(contentSize)
 	t.headId = lastIndex.filenum
 
+	// Load the hidden tail, ensuring it's within the items still stored
+	hidden, err := t.readMeta()
+	if err != nil {
+		return err
+	}
+	if hidden < uint64(t.itemOffset) {
+		hidden = uint64(t.itemOffset)
+	}
+	if hidden > t.items {
+		hidden = t.items
+	}
+	t.itemHidden = hidden
+
 	// Close opened files and preopen all files
 	if err := t.preopen(); err != nil {
 		return err
 	}
-	t.logger.Debug("Chain freezer table opened", "items", t.items, "size", common.StorageSize(t.headBytes))
+	t.logger.Debug("Chain freezer table opened", "items", t.items, "tail", t.itemHidden, "size", common.StorageSize(t.headBytes))
 	return nil
 }
 
+// readMeta retrieves the number of items hidden from the tail of the table from
+// the metadata file, or zero if it was never truncated.
+func (t *freezerTable) readMeta() (uint64, error) {
+	stat, err := t.meta.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if stat.Size() < 8 {
+		return 0, nil
+	}
+	buffer := make([]byte, 8)
+	if _, err := t.meta.ReadAt(buffer, 0); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buffer), nil
+}
+
+// writeMeta persists the number of items hidden from the tail of the table into
+// the metadata file.
+func (t *freezerTable) writeMeta(hidden uint64) error {
+	buffer := make([]byte, 8)
+	binary.BigEndian.PutUint64(buffer, hidden)
+	if _, err := t.meta.WriteAt(buffer, 0); err != nil {
+		return err
+	}
+	return t.meta.Sync()
+}
+
 // preopen opens all files that the freezer will need. This method should be called from an init-context,
 // since it assumes that it doesn't have to bother with locking
 // The rationale for doing preopen is to not have to do it from within Retrieve, thus not needing to ever
@@ -363,16 +421,29 @@ func (t *freezerTable) truncate(items uint64) error {
 		log = t.logger.Warn // Only loud warn if we delete multiple items
 	}
 	log("Truncating freezer table", "items", existing, "limit", items)
-	if err := truncateFreezerFile(t.index, int64(items+1)*indexEntrySize); err != nil {
-		return err
+	if items < uint64(t.itemOffset) {
+		return fmt.Errorf("%w: limit %d, tail %d", errTruncateBelowTail, items, t.itemOffset)
 	}
-	// Calculate the new expected size of the data file and truncate it
-	buffer := make([]byte, indexEntrySize)
-	if _, err := t.index.ReadAt(buffer, int64(items*indexEntrySize)); err != nil {
+	if items < atomic.LoadUint64(&t.itemHidden) {
+		if err := t.writeMeta(items); err != nil {
+			return err
+		}
+		atomic.StoreUint64(&t.itemHidden, items)
+	}
+	position := items - uint64(t.itemOffset)
+	if err := truncateFreezerFile(t.index, int64(position+1)*indexEntrySize); err != nil {
 		return err
 	}
-	var expected indexEntry
-	expected.unmarshalBinary(buffer)
+	// Calculate the new expected size of the data file and truncate it. The
+	// first index entry carries the tail file and offset, not a data position.
+	expected := indexEntry{filenum: t.tailId}
+	if position > 0 {
+		buffer := make([]byte, indexEntrySize)
+		if _, err := t.index.ReadAt(buffer, int64(position*indexEntrySize)); err != nil {
+			return err
+		}
+		expected.unmarshalBinary(buffer)
+	}
 
 	// We might need to truncate back to older files
 	if expected.filenum != t.headId {
@@ -406,6 +477,145 @@ func (t *freezerTable) truncate(items uint64) error {
 	return nil
 }
 
+// TruncateTail discards all items below the provided threshold number from the
+// tail of the table. The items are hidden from retrievals right away, whereas
+// data files are only deleted once all of their items are discarded.
+func (t *freezerTable) TruncateTail(items uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Ensure the table is still accessible and the truncation is meaningful
+	if t.index == nil || t.head == nil {
+		return errClosed
+	}
+	if atomic.LoadUint64(&t.itemHidden) >= items {
+		return nil
+	}
+	existing := atomic.LoadUint64(&t.items)
+	if existing < items {
+		return fmt.Errorf("truncating tail beyond head: items %d, limit %d", existing, items)
+	}
+	// Hide the items first, so they are unavailable even if the deletion of the
+	// data files gets interrupted
+	if err := t.writeMeta(items); err != nil {
+		return err
+	}
+	atomic.StoreUint64(&t.itemHidden, items)
+
+	// Find the data file holding the first retained item, all the files before
+	// it can be deleted. Index entry n marks the end of item itemOffset+n-1.
+	readEntry := func(position uint64) (indexEntry, error) {
+		var (
+			entry  indexEntry
+			buffer = make([]byte, indexEntrySize)
+		)
+		if _, err := t.index.ReadAt(buffer, int64(position*indexEntrySize)); err != nil {
+			return entry, err
+		}
+		entry.unmarshalBinary(buffer)
+		return entry, nil
+	}
+	newTail := t.headId
+	if items < existing {
+		entry, err := readEntry(items - uint64(t.itemOffset) + 1)
+		if err != nil {
+			return err
+		}
+		newTail = entry.filenum
+	}
+	if newTail == t.tailId {
+		return nil
+	}
+	// Locate the first index entry of the new tail file. The entries are sorted
+	// by file number, so a binary search suffices.
+	var (
+		entries = existing - uint64(t.itemOffset) // Number of data entries
+		first   = uint64(1)
+		last    = entries + 1
+		err     error
+	)
+	for first < last {
+		mid := first + (last-first)/2
+		entry, rerr := readEntry(mid)
+		if rerr != nil {
+			return rerr
+		}
+		if entry.filenum >= newTail {
+			last = mid
+		} else {
+			first = mid + 1
+		}
+	}
+	newOffset := uint64(t.itemOffset) + first - 1
+
+	// Rewrite the index, starting with the new tail file and item offset
+	oldSize, err := t.sizeNolock()
+	if err != nil {
+		return err
+	}
+	name := t.index.Name()
+	tmp, err := os.OpenFile(name+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	head := indexEntry{filenum: newTail, offset: uint32(newOffset)}
+	if _, err := tmp.Write(head.marshallBinary()); err != nil {
+		tmp.Close()
+		return err
+	}
+	stat, err := t.index.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	start := int64(first * indexEntrySize)
+	if _, err := io.Copy(tmp, io.NewSectionReader(t.index, start, stat.Size()-start)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := t.index.Close(); err != nil {
+		return err
+	}
+	rerr := os.Rename(name+".tmp", name)
+	if t.index, err = openFreezerFileForAppend(name); err != nil {
+		return err
+	}
+	if rerr != nil {
+		return rerr
+	}
+	// The index no longer references the old files, delete them
+	for num := t.tailId; num < newTail; num++ {
+		if f, exist := t.files[num]; exist {
+			delete(t.files, num)
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	t.logger.Debug("Truncated freezer table tail", "items", items, "deleted", newOffset-uint64(t.itemOffset), "files", newTail-t.tailId)
+	t.tailId = newTail
+	t.itemOffset = uint32(newOffset)
+
+	// Retrieve the new size and update the total size counter
+	newSize, err := t.sizeNolock()
+	if err != nil {
+		return err
+	}
+	t.sizeGauge.Dec(int64(oldSize - newSize))
+	return nil
+}
+
+// tail returns the number of the first item retrievable from the table.
+func (t *freezerTable) tail() uint64 {
+	return atomic.LoadUint64(&t.itemHidden)
+}
+
 // Close closes all opened files.
 func (t *freezerTable) Close() error {
 	t.lock.Lock()
@@ -417,6 +627,11 @@ func (t *freezerTable) Close() error {
 	}
 	t.index = nil
 
+	if err := t.meta.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	t.meta = nil
+
 	for _, f := range t.files {
 		if err := f.Close(); err != nil {
 			errs = append(errs, err)
@@ -434,13 +649,7 @@ func (t *freezerTable) Close() error {
 func (t *freezerTable) openFile(num uint32, opener func(string) (*os.File, error)) (f *os.File, err error) {
 	var exist bool
 	if f, exist = t.files[num]; !exist {
-		var name string
-		if t.noCompression {
-			name = fmt.Sprintf("%s.%04d.rdat", t.name, num)
-		} else {
-			name = fmt.Sprintf("%s.%04d.cdat", t.name, num)
-		}
-		f, err = opener(filepath.Join(t.path, name))
+		f, err = opener(filepath.Join(t.path, t.fileName(num)))
 		if err != nil {
 			return nil, err
 		}
@@ -449,6 +658,30 @@ func (t *freezerTable) openFile(num uint32, opener func(string) (*os.File, error
 	return f, err
 }
 
+// fileName returns the name of the data file with the given number.
+func (t *freezerTable) fileName(num uint32) string {
+	if t.noCompression {
+		return fmt.Sprintf("%s.%04d.rdat", t.name, num)
+	}
+	return fmt.Sprintf("%s.%04d.cdat", t.name, num)
+}
+
+// removeFilesBefore deletes all data files numbered below the given one.
+func (t *freezerTable) removeFilesBefore(num uint32) error {
+	for n := num; n > 0; n-- {
+		name := filepath.Join(t.path, t.fileName(n-1))
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			// Files are deleted in ascending order, so no older ones remain
+			break
+		}
+		t.logger.Warn("Removing leftover data file below tail", "file", t.fileName(n-1), "tail", num)
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // releaseFile closes a file, and removes it from the open file cache.
 // Assumes that the caller holds the write lock
 func (t *freezerTable) releaseFile(num uint32) {
@@ -658,7 +891,7 @@ func (t *freezerTable) retrieveItems(start, count, maxBytes uint64) ([]byte, []i
 	itemCount := atomic.LoadUint64(&t.items) // max number
 	// Ensure the start is written, not deleted from the tail, and that the
 	// caller actually wants something
-	if itemCount <= start || uint64(t.itemOffset) > start || count == 0 {
+	if itemCount <= start || atomic.LoadUint64(&t.itemHidden) > start || count == 0 {
 		return nil, nil, errOutOfBounds
 	}
 	if start+count > itemCount {
@@ -741,7 +974,7 @@ func (t *freezerTable) retrieveItems(start, count, maxBytes uint64) ([]byte, []i
 // has returns an indicator whether the specified number data
 // exists in the freezer table.
 func (t *freezerTable) has(number uint64) bool {
-	return atomic.LoadUint64(&t.items) > number
+	return atomic.LoadUint64(&t.items) > number && atomic.LoadUint64(&t.itemHidden) <= number
 }
 
 // size returns the total data size in the freezer table.
@@ -19,6 +19,7 @@ package rawdb
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -692,3 +693,153 @@ func TestAppendTruncateParallel(t *testing.T) {
 		}
 	}
 }
+
+// TestFreezerTruncateTail tests that items can be discarded from the tail of the
+// table, deleting the data files once all their items are gone.
+func TestFreezerTruncateTail(t *testing.T) {
+	t.Parallel()
+	var (
+		fname      = fmt.Sprintf("truncate-tail-%d", rand.Uint64())
+		rm, wm, sg = metrics.NewMeter(), metrics.NewMeter(), metrics.NewGauge()
+	)
+	// Write 30 items of 15 bytes, 3 items per file, results in 10 files
+	f, err := newCustomTable(os.TempDir(), fname, rm, wm, sg, 50, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 30; x++ {
+		f.Append(uint64(x), getChunk(15, x))
+	}
+	// Item 10 resides in the fourth file, so the first three can be deleted
+	if err := f.TruncateTail(10); err != nil {
+		t.Fatal(err)
+	}
+	checkTail := func(f *freezerTable, tail, items uint64) {
+		t.Helper()
+		if f.tail() != tail {
+			t.Fatalf("tail mismatch: have %d, want %d", f.tail(), tail)
+		}
+		if _, err := f.Retrieve(tail - 1); err != errOutOfBounds {
+			t.Fatalf("item %d: expected %v, got %v", tail-1, errOutOfBounds, err)
+		}
+		if f.has(tail - 1) {
+			t.Fatalf("item %d: hidden item reported present", tail-1)
+		}
+		for y := tail; y < items; y++ {
+			got, err := f.Retrieve(y)
+			if err != nil {
+				t.Fatalf("item %d: %v", y, err)
+			}
+			if exp := getChunk(15, int(y)); !bytes.Equal(got, exp) {
+				t.Fatalf("item %d: got %x != %x", y, got, exp)
+			}
+		}
+	}
+	checkTail(f, 10, 30)
+	for num := 0; num < 3; num++ {
+		if _, err := os.Stat(filepath.Join(os.TempDir(), fmt.Sprintf("%s.%04d.rdat", fname, num))); !os.IsNotExist(err) {
+			t.Fatalf("data file %d not deleted: %v", num, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(os.TempDir(), fmt.Sprintf("%s.0003.rdat", fname))); err != nil {
+		t.Fatalf("data file 3 deleted: %v", err)
+	}
+	// Truncating the tail backwards is a noop
+	if err := f.TruncateTail(5); err != nil {
+		t.Fatal(err)
+	}
+	checkTail(f, 10, 30)
+
+	// Reopen the table and check that the tail is retained
+	f.Close()
+	if f, err = newCustomTable(os.TempDir(), fname, rm, wm, sg, 50, true); err != nil {
+		t.Fatal(err)
+	}
+	checkTail(f, 10, 30)
+
+	// Truncate the head into the tail file and append new items on top
+	if err := f.truncate(11); err != nil {
+		t.Fatal(err)
+	}
+	for x := 11; x < 20; x++ {
+		if err := f.Append(uint64(x), getChunk(15, x)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTail(f, 10, 20)
+
+	// Truncating the head below the tail file is rejected
+	if err := f.truncate(8); !errors.Is(err, errTruncateBelowTail) {
+		t.Fatalf("truncating below deleted tail: have %v, want %v", err, errTruncateBelowTail)
+	}
+	// Discard all the items, retaining only the head file
+	if err := f.TruncateTail(20); err != nil {
+		t.Fatal(err)
+	}
+	if f.tail() != 20 || f.has(19) {
+		t.Fatalf("tail mismatch: have %d, want %d", f.tail(), 20)
+	}
+	if err := f.Append(20, getChunk(15, 20)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if f, err = newCustomTable(os.TempDir(), fname, rm, wm, sg, 50, true); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	checkTail(f, 20, 21)
+}
+
+// TestFreezerTruncateTailCrash tests that data files left behind by a tail
+// truncation interrupted after rewriting the index are deleted on open.
+func TestFreezerTruncateTailCrash(t *testing.T) {
+	t.Parallel()
+	var (
+		fname      = fmt.Sprintf("truncate-tail-crash-%d", rand.Uint64())
+		rm, wm, sg = metrics.NewMeter(), metrics.NewMeter(), metrics.NewGauge()
+	)
+	// Write 30 items of 15 bytes, 3 items per file, results in 10 files
+	f, err := newCustomTable(os.TempDir(), fname, rm, wm, sg, 50, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 30; x++ {
+		f.Append(uint64(x), getChunk(15, x))
+	}
+	if err := f.TruncateTail(10); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Recreate the deleted files, as if the process crashed before removing them
+	dataFile := func(num int) string {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("%s.%04d.rdat", fname, num))
+	}
+	for num := 0; num < 3; num++ {
+		if err := ioutil.WriteFile(dataFile(num), getChunk(45, num), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f, err = newCustomTable(os.TempDir(), fname, rm, wm, sg, 50, true); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for num := 0; num < 3; num++ {
+		if _, err := os.Stat(dataFile(num)); !os.IsNotExist(err) {
+			t.Fatalf("leftover data file %d not deleted: %v", num, err)
+		}
+	}
+	if f.tail() != 10 {
+		t.Fatalf("tail mismatch: have %d, want %d", f.tail(), 10)
+	}
+	for y := uint64(10); y < 30; y++ {
+		got, err := f.Retrieve(y)
+		if err != nil {
+			t.Fatalf("item %d: %v", y, err)
+		}
+		if exp := getChunk(15, int(y)); !bytes.Equal(got, exp) {
+			t.Fatalf("item %d: got %x != %x", y, got, exp)
+		}
+	}
+}
@@ -69,6 +69,12 @@ var (
 	// fastTxLookupLimitKey tracks the transaction lookup limit during fast sync.
 	fastTxLookupLimitKey = []byte("FastTransactionLookupLimit")
 
+	// stateSchemeKey tracks the storage scheme of the trie nodes in the database.
+	stateSchemeKey = []byte("StateScheme")
+
+	// reverseDiffHeadKey tracks the id of the latest stored reverse state diff.
+	reverseDiffHeadKey = []byte("ReverseDiffHead")
+
 	// badBlockKey tracks the list of bad blocks seen by local
 	badBlockKey = []byte("InvalidBlock")
 
@@ -90,6 +96,10 @@ var (
 	SnapshotStoragePrefix = []byte("o") // SnapshotStoragePrefix + account hash + storage hash -> storage trie value
 	CodePrefix            = []byte("c") // CodePrefix + code hash -> account code
 
+	trieNodeAccountPrefix = []byte("A") // trieNodeAccountPrefix + hexPath -> trie node (path scheme)
+	trieNodeStoragePrefix = []byte("O") // trieNodeStoragePrefix + account hash + hexPath -> trie node (path scheme)
+	reverseDiffPrefix     = []byte("R") // reverseDiffPrefix + id (uint64 big endian) -> reverse state diff (path scheme)
+
 	preimagePrefix = []byte("secure-key-")     // preimagePrefix + hash -> preimage
 	configPrefix   = []byte("classzz-config-") // config prefix for the db
 	recordPrefix   = []byte("czz-record-")
@@ -128,6 +138,10 @@ var FreezerNoSnappy = map[string]bool{
 	freezerDifficultyTable: true,
 }
 
+// freezerHistoryTables are the freezer tables that can be pruned from the tail,
+// holding the chain history not needed to verify the chain.
+var freezerHistoryTables = []string{freezerBodiesTable, freezerReceiptTable}
+
 // LegacyTxLookupEntry is the legacy TxLookupEntry definition with some unnecessary
 // fields.
 type LegacyTxLookupEntry struct {
@@ -232,6 +246,21 @@ func IsCodeKey(key []byte) (bool, []byte) {
 	return false, nil
 }
 
+// accountTrieNodeKey = trieNodeAccountPrefix + hexPath
+func accountTrieNodeKey(path []byte) []byte {
+	return append(trieNodeAccountPrefix, path...)
+}
+
+// storageTrieNodeKey = trieNodeStoragePrefix + account hash + hexPath
+func storageTrieNodeKey(accountHash common.Hash, path []byte) []byte {
+	return append(append(trieNodeStoragePrefix, accountHash.Bytes()...), path...)
+}
+
+// reverseDiffKey = reverseDiffPrefix + id (uint64 big endian)
+func reverseDiffKey(id uint64) []byte {
+	return append(reverseDiffPrefix, encodeBlockNumber(id)...)
+}
+
 // configKey = configPrefix + hash
 func configKey(hash common.Hash) []byte {
 	return append(configPrefix, hash.Bytes()...)
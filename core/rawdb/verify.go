@@ -0,0 +1,634 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/rlp"
+)
+
+// The categories of data checked by a database verification.
+const (
+	VerifyCanonical = "canonical"
+	VerifyHeaders   = "headers"
+	VerifyBodies    = "bodies"
+	VerifyReceipts  = "receipts"
+	VerifyTxLookups = "txlookups"
+	VerifyFreezer   = "freezer"
+	VerifySnapshot  = "snapshot"
+	VerifyRecords   = "records"
+)
+
+// The kinds of issues found by a database verification.
+const (
+	IssueMissing  = "missing"  // Entry required by the chain is absent
+	IssueDangling = "dangling" // Entry not referenced by anything
+	IssueCorrupt  = "corrupt"  // Entry present with invalid contents
+)
+
+// maxVerifyIssues is the number of issues listed in a verification report, the
+// rest are only counted.
+const maxVerifyIssues = 1000
+
+// VerifyIssue is an inconsistency found in the database.
+type VerifyIssue struct {
+	Category string        `json:"category"`
+	Kind     string        `json:"kind"`
+	Number   *uint64       `json:"number,omitempty"`
+	Key      hexutil.Bytes `json:"key,omitempty"`
+	Detail   string        `json:"detail"`
+	Repaired bool          `json:"repaired"`
+}
+
+// VerifyStats counts the entries checked and the issues found in a category.
+type VerifyStats struct {
+	Checked  uint64 `json:"checked"`
+	Missing  uint64 `json:"missing"`
+	Dangling uint64 `json:"dangling"`
+	Corrupt  uint64 `json:"corrupt"`
+	Repaired uint64 `json:"repaired"`
+}
+
+// VerifyReport is the machine readable result of a database verification.
+type VerifyReport struct {
+	Stats   map[string]*VerifyStats `json:"stats"`
+	Issues  []*VerifyIssue          `json:"issues"`
+	Omitted uint64                  `json:"omitted"` // Issues counted but not listed
+}
+
+// NewVerifyReport creates an empty verification report.
+func NewVerifyReport() *VerifyReport {
+	stats := make(map[string]*VerifyStats)
+	for _, category := range []string{VerifyCanonical, VerifyHeaders, VerifyBodies, VerifyReceipts, VerifyTxLookups, VerifyFreezer, VerifySnapshot, VerifyRecords} {
+		stats[category] = new(VerifyStats)
+	}
+	return &VerifyReport{Stats: stats, Issues: []*VerifyIssue{}}
+}
+
+// Check counts an entry checked in the given category.
+func (r *VerifyReport) Check(category string) {
+	r.Stats[category].Checked++
+}
+
+// Report records an issue found in the database.
+func (r *VerifyReport) Report(issue *VerifyIssue) {
+	stats := r.Stats[issue.Category]
+	switch issue.Kind {
+	case IssueMissing:
+		stats.Missing++
+	case IssueDangling:
+		stats.Dangling++
+	case IssueCorrupt:
+		stats.Corrupt++
+	}
+	if issue.Repaired {
+		stats.Repaired++
+	}
+	if len(r.Issues) < maxVerifyIssues {
+		r.Issues = append(r.Issues, issue)
+	} else {
+		r.Omitted++
+	}
+	ctx := []interface{}{"category", issue.Category, "kind", issue.Kind}
+	if issue.Number != nil {
+		ctx = append(ctx, "number", *issue.Number)
+	}
+	if issue.Key != nil {
+		ctx = append(ctx, "key", issue.Key)
+	}
+	ctx = append(ctx, "repaired", issue.Repaired)
+	log.Warn(issue.Detail, ctx...)
+}
+
+// Found returns the total number of issues found.
+func (r *VerifyReport) Found() uint64 {
+	var found uint64
+	for _, stats := range r.Stats {
+		found += stats.Missing + stats.Dangling + stats.Corrupt
+	}
+	return found
+}
+
+// Unrepaired returns the number of issues left in the database.
+func (r *VerifyReport) Unrepaired() uint64 {
+	var repaired uint64
+	for _, stats := range r.Stats {
+		repaired += stats.Repaired
+	}
+	return r.Found() - repaired
+}
+
+// verifier checks the consistency of the chain data in a database, optionally
+// repairing the issues found.
+type verifier struct {
+	db     czzdb.Database
+	batch  czzdb.Batch
+	hasher types.TrieHasher
+	repair bool
+	report *VerifyReport
+
+	start  time.Time
+	logged time.Time
+}
+
+// VerifyDatabase checks the consistency of the canonical chain, the headers,
+// bodies, receipts and transaction indices belonging to it, the ancient store
+// and the TeWaka used item records. The hasher is used to check the bodies and
+// receipts against their headers. If repair is set, the issues which can be
+// fixed from the remaining data are fixed, dangling entries are deleted.
+func VerifyDatabase(db czzdb.Database, hasher types.TrieHasher, repair bool) (*VerifyReport, error) {
+	v := &verifier{
+		db:     db,
+		batch:  db.NewBatch(),
+		hasher: hasher,
+		repair: repair,
+		report: NewVerifyReport(),
+		start:  time.Now(),
+		logged: time.Now(),
+	}
+	head, err := v.verifyFreezer()
+	if err != nil {
+		return nil, err
+	}
+	if head != nil {
+		if err := v.verifyChain(*head); err != nil {
+			return nil, err
+		}
+	}
+	if err := v.verifyDangling(head); err != nil {
+		return nil, err
+	}
+	if err := v.verifyRecords(); err != nil {
+		return nil, err
+	}
+	if err := v.batch.Write(); err != nil {
+		return nil, err
+	}
+	log.Info("Verified database", "issues", v.report.Found(), "unrepaired", v.report.Unrepaired(), "elapsed", common.PrettyDuration(time.Since(v.start)))
+	return v.report, nil
+}
+
+// issue reports an issue, marking it repaired if repairs are enabled and the
+// issue is repairable.
+func (v *verifier) issue(category, kind string, number *uint64, key []byte, repairable bool, format string, args ...interface{}) bool {
+	v.report.Report(&VerifyIssue{
+		Category: category,
+		Kind:     kind,
+		Number:   number,
+		Key:      common.CopyBytes(key),
+		Detail:   fmt.Sprintf(format, args...),
+		Repaired: v.repair && repairable,
+	})
+	return v.repair && repairable
+}
+
+// flush writes out the pending repairs once the batch grows large enough.
+func (v *verifier) flush() error {
+	if v.batch.ValueSize() < czzdb.IdealBatchSize {
+		return nil
+	}
+	if err := v.batch.Write(); err != nil {
+		return err
+	}
+	v.batch.Reset()
+	return nil
+}
+
+// progress periodically logs the verification progress.
+func (v *verifier) progress(stage string, ctx ...interface{}) {
+	if time.Since(v.logged) < 8*time.Second {
+		return
+	}
+	ctx = append([]interface{}{"stage", stage}, ctx...)
+	ctx = append(ctx, "issues", v.report.Found(), "elapsed", common.PrettyDuration(time.Since(v.start)))
+	log.Info("Verifying database", ctx...)
+	v.logged = time.Now()
+}
+
+// verifyFreezer checks the ancient store against the head header, returning the
+// head header number, or nil if the head header is unknown.
+func (v *verifier) verifyFreezer() (*uint64, error) {
+	hash := ReadHeadHeaderHash(v.db)
+	head := ReadHeaderNumber(v.db, hash)
+	if head == nil {
+		v.issue(VerifyCanonical, IssueMissing, nil, headHeaderKey, false, "Head header missing")
+		return nil, nil
+	}
+	frozen, err := v.db.Ancients()
+	if err != nil {
+		return head, nil // No ancient store
+	}
+	v.report.Check(VerifyFreezer)
+	if frozen > *head+1 && !SharedAncients(v.db) {
+		// The chain truncates the excess ancients on startup too
+		if v.issue(VerifyFreezer, IssueDangling, &frozen, nil, true, "Ancient items above head header: head %d, ancients %d", *head, frozen) {
+			if err := v.db.TruncateAncients(*head + 1); err != nil {
+				return nil, err
+			}
+			frozen = *head + 1
+		}
+	}
+	tail, err := v.db.AncientTail()
+	if err == nil && tail > frozen {
+		v.issue(VerifyFreezer, IssueCorrupt, &tail, nil, false, "Ancient tail above ancient items: tail %d, ancients %d", tail, frozen)
+	}
+	v.verifyFreezerTables(frozen, tail)
+	v.verifyFreezerHeaders(frozen)
+	return head, nil
+}
+
+// verifyFreezerTables checks that every ancient table holds exactly the frozen
+// items, the bodies and receipts only from the ancient tail on.
+func (v *verifier) verifyFreezerTables(frozen, tail uint64) {
+	for _, kind := range []string{freezerHashTable, freezerHeaderTable, freezerDifficultyTable, freezerBodiesTable, freezerReceiptTable} {
+		v.report.Check(VerifyFreezer)
+
+		var first uint64
+		if kind == freezerBodiesTable || kind == freezerReceiptTable {
+			first = tail
+		}
+		if first < frozen {
+			if has, _ := v.db.HasAncient(kind, first); !has {
+				v.issue(VerifyFreezer, IssueMissing, &first, nil, false, "Ancient %s table misses its first item: ancients %d", kind, frozen)
+			}
+			if last := frozen - 1; last > first {
+				if has, _ := v.db.HasAncient(kind, last); !has {
+					v.issue(VerifyFreezer, IssueMissing, &last, nil, false, "Ancient %s table shorter than the ancient items: ancients %d", kind, frozen)
+				}
+			}
+		}
+		if has, _ := v.db.HasAncient(kind, frozen); has {
+			v.issue(VerifyFreezer, IssueDangling, &frozen, nil, false, "Ancient %s table longer than the ancient items: ancients %d", kind, frozen)
+		}
+	}
+}
+
+// verifyFreezerHeaders checks that the frozen headers match their canonical
+// hashes and link up into a chain. The ancient data can't be rewritten, so the
+// issues found are left for a resync.
+func (v *verifier) verifyFreezerHeaders(frozen uint64) {
+	var parent common.Hash
+	for n := uint64(0); n < frozen; n++ {
+		number := n
+		v.report.Check(VerifyFreezer)
+
+		// Missing items are reported by the table checks
+		hash, _ := v.db.Ancient(freezerHashTable, n)
+		blob, _ := v.db.Ancient(freezerHeaderTable, n)
+		if len(hash) != common.HashLength || len(blob) == 0 {
+			return
+		}
+		header := new(types.Header)
+		switch {
+		case crypto.Keccak256Hash(blob) != common.BytesToHash(hash):
+			v.issue(VerifyFreezer, IssueCorrupt, &number, nil, false, "Ancient header doesn't match canonical hash %x", hash)
+		case rlp.DecodeBytes(blob, header) != nil:
+			v.issue(VerifyFreezer, IssueCorrupt, &number, nil, false, "Ancient header undecodable")
+		case header.Number.Uint64() != n:
+			v.issue(VerifyFreezer, IssueCorrupt, &number, nil, false, "Ancient header number mismatch: have %d", header.Number)
+		case n > 0 && header.ParentHash != parent:
+			v.issue(VerifyFreezer, IssueCorrupt, &number, nil, false, "Ancient header doesn't extend its parent %x", parent)
+		}
+		parent = crypto.Keccak256Hash(blob)
+		v.progress("freezer", "number", n, "ancients", frozen)
+	}
+}
+
+// verifyChain walks the canonical chain from the genesis up to the head header,
+// checking every block along with its associated data.
+func (v *verifier) verifyChain(head uint64) error {
+	var (
+		frozen, _ = v.db.Ancients()
+		tail, _   = v.db.AncientTail()
+		indexTail = ReadTxIndexTail(v.db)
+		headHash  = ReadHeadHeaderHash(v.db)
+		bodyHead  = v.bodyHead()
+	)
+	if indexTail == nil {
+		log.Info("Transaction indices not initialised, skipping their verification")
+	}
+	var (
+		parent   common.Hash
+		parentTd *big.Int
+	)
+	for n := uint64(0); n <= head; n++ {
+		number := n
+		v.report.Check(VerifyCanonical)
+
+		// Resolve the canonical block at this height, making sure it extends
+		// the already verified chain
+		hash := ReadCanonicalHash(v.db, n)
+		var header *types.Header
+		if hash != (common.Hash{}) {
+			header = ReadHeader(v.db, hash, n)
+		}
+		if header == nil || (n > 0 && header.ParentHash != parent) || (n == head && hash != headHash) {
+			fixed := v.resolveCanonical(n, head, headHash, parent)
+			if fixed == nil {
+				v.issue(VerifyCanonical, IssueCorrupt, &number, headerHashKey(n), false, "Canonical chain broken: no valid header at height %d", n)
+				return nil
+			}
+			kind := IssueCorrupt
+			if hash == (common.Hash{}) {
+				kind = IssueMissing
+			}
+			if v.issue(VerifyCanonical, kind, &number, headerHashKey(n), n >= frozen, "Canonical hash mismatch: have %x, want %x", hash, fixed.Hash()) {
+				WriteCanonicalHash(v.batch, fixed.Hash(), n)
+			}
+			hash, header = fixed.Hash(), fixed
+		}
+		parentTd = v.verifyHeader(n, hash, header, frozen, parentTd)
+		if n <= bodyHead && n >= tail {
+			v.verifyBody(n, hash, header, indexTail != nil && n >= *indexTail)
+		}
+		parent = hash
+
+		if err := v.flush(); err != nil {
+			return err
+		}
+		v.progress("chain", "number", n, "head", head)
+	}
+	return nil
+}
+
+// bodyHead returns the number of the last block with a body, receipts and the
+// transaction indices, which is the head full or fast block, whichever is higher.
+func (v *verifier) bodyHead() uint64 {
+	var head uint64
+	for _, hash := range []common.Hash{ReadHeadBlockHash(v.db), ReadHeadFastBlockHash(v.db)} {
+		if number := ReadHeaderNumber(v.db, hash); number != nil && *number > head {
+			head = *number
+		}
+	}
+	return head
+}
+
+// resolveCanonical looks for the header at the given height which extends the
+// verified chain. Only the non-ancient headers can be looked up by number.
+func (v *verifier) resolveCanonical(number, head uint64, headHash, parent common.Hash) *types.Header {
+	for _, hash := range ReadAllHashes(v.db, number) {
+		if number == head && hash != headHash {
+			continue
+		}
+		header := ReadHeader(v.db, hash, number)
+		if header != nil && (number == 0 || header.ParentHash == parent) {
+			return header
+		}
+	}
+	return nil
+}
+
+// verifyHeader checks the hash to number mapping and the total difficulty of a
+// canonical header, returning the total difficulty to verify its child with.
+func (v *verifier) verifyHeader(number uint64, hash common.Hash, header *types.Header, frozen uint64, parentTd *big.Int) *big.Int {
+	v.report.Check(VerifyHeaders)
+
+	if n := ReadHeaderNumber(v.db, hash); n == nil || *n != number {
+		if v.issue(VerifyHeaders, IssueMissing, &number, headerNumberKey(hash), true, "Header number mapping missing for %x", hash) {
+			WriteHeaderNumber(v.batch, hash, number)
+		}
+	}
+	// The genesis difficulty is configured independently of the header, so it
+	// seeds the running total instead of being derived.
+	if number == 0 {
+		td := ReadTd(v.db, hash, number)
+		if td == nil {
+			td = header.Difficulty
+			if v.issue(VerifyHeaders, IssueMissing, &number, headerTDKey(number, hash), number >= frozen, "Total difficulty missing for %x", hash) {
+				WriteTd(v.batch, hash, number, td)
+			}
+		}
+		return td
+	}
+	// Without the parent difficulty only the presence can be checked
+	td := ReadTd(v.db, hash, number)
+	if parentTd == nil {
+		if td == nil {
+			v.issue(VerifyHeaders, IssueMissing, &number, headerTDKey(number, hash), false, "Total difficulty missing for %x", hash)
+		}
+		return td
+	}
+	want := new(big.Int).Add(parentTd, header.Difficulty)
+	switch {
+	case td == nil:
+		if v.issue(VerifyHeaders, IssueMissing, &number, headerTDKey(number, hash), number >= frozen, "Total difficulty missing for %x", hash) {
+			WriteTd(v.batch, hash, number, want)
+		}
+	case td.Cmp(want) != 0:
+		if v.issue(VerifyHeaders, IssueCorrupt, &number, headerTDKey(number, hash), number >= frozen, "Total difficulty mismatch: have %v, want %v", td, want) {
+			WriteTd(v.batch, hash, number, want)
+		}
+	}
+	return want
+}
+
+// verifyBody checks the body and receipts of a canonical block against its
+// header, along with the transaction indices if the block should be indexed.
+func (v *verifier) verifyBody(number uint64, hash common.Hash, header *types.Header, indexed bool) {
+	v.report.Check(VerifyBodies)
+	body := ReadBody(v.db, hash, number)
+	switch {
+	case body == nil && header.TxHash != types.EmptyRootHash:
+		v.issue(VerifyBodies, IssueMissing, &number, blockBodyKey(number, hash), false, "Block body missing for %x", hash)
+	case body != nil && v.deriveSha(types.Transactions(body.Transactions)) != header.TxHash:
+		v.issue(VerifyBodies, IssueCorrupt, &number, blockBodyKey(number, hash), false, "Block body doesn't match header %x", hash)
+		body = nil
+	}
+	v.report.Check(VerifyReceipts)
+	receipts := ReadRawReceipts(v.db, hash, number)
+	switch {
+	case receipts == nil && header.ReceiptHash != types.EmptyRootHash:
+		v.issue(VerifyReceipts, IssueMissing, &number, blockReceiptsKey(number, hash), false, "Block receipts missing for %x", hash)
+	case receipts != nil && v.deriveSha(receipts) != header.ReceiptHash:
+		v.issue(VerifyReceipts, IssueCorrupt, &number, blockReceiptsKey(number, hash), false, "Block receipts don't match header %x", hash)
+	}
+	if !indexed || body == nil {
+		return
+	}
+	for _, tx := range body.Transactions {
+		v.report.Check(VerifyTxLookups)
+		switch entry := ReadTxLookupEntry(v.db, tx.Hash()); {
+		case entry == nil:
+			if v.issue(VerifyTxLookups, IssueMissing, &number, txLookupKey(tx.Hash()), true, "Transaction index missing for %x", tx.Hash()) {
+				WriteTxLookupEntries(v.batch, number, []common.Hash{tx.Hash()})
+			}
+		case *entry != number:
+			if v.issue(VerifyTxLookups, IssueCorrupt, &number, txLookupKey(tx.Hash()), true, "Transaction index points to block %d", *entry) {
+				WriteTxLookupEntries(v.batch, number, []common.Hash{tx.Hash()})
+			}
+		}
+	}
+}
+
+// deriveSha returns the root hash of a list, short circuiting empty ones.
+func (v *verifier) deriveSha(list types.DerivableList) common.Hash {
+	if list.Len() == 0 {
+		return types.EmptyRootHash
+	}
+	return types.DeriveSha(list, v.hasher)
+}
+
+// verifyDangling looks for the chain entries not belonging to any header or
+// beyond the chain head.
+func (v *verifier) verifyDangling(head *uint64) error {
+	var (
+		bodyHead  = v.bodyHead()
+		indexTail = ReadTxIndexTail(v.db)
+	)
+	// Only the canonical hashes above the head need to be iterated
+	var canonStart []byte
+	if head != nil {
+		canonStart = encodeBlockNumber(*head + 1)
+	}
+	checks := []struct {
+		prefix []byte
+		start  []byte
+		length int
+		check  func(key, value []byte) bool
+	}{
+		// Bodies and receipts need their header
+		{blockBodyPrefix, nil, len(blockBodyPrefix) + 8 + common.HashLength, func(key, value []byte) bool {
+			number, hash := binary.BigEndian.Uint64(key[1:9]), common.BytesToHash(key[9:])
+			if HasHeader(v.db, hash, number) {
+				return true
+			}
+			if v.issue(VerifyBodies, IssueDangling, &number, key, true, "Block body without header %x", hash) {
+				DeleteBody(v.batch, hash, number)
+			}
+			return false
+		}},
+		{blockReceiptsPrefix, nil, len(blockReceiptsPrefix) + 8 + common.HashLength, func(key, value []byte) bool {
+			number, hash := binary.BigEndian.Uint64(key[1:9]), common.BytesToHash(key[9:])
+			if HasHeader(v.db, hash, number) {
+				return true
+			}
+			if v.issue(VerifyReceipts, IssueDangling, &number, key, true, "Block receipts without header %x", hash) {
+				DeleteReceipts(v.batch, hash, number)
+			}
+			return false
+		}},
+		// Hash to number mappings need their header
+		{headerNumberPrefix, nil, len(headerNumberPrefix) + common.HashLength, func(key, value []byte) bool {
+			hash := common.BytesToHash(key[1:])
+			if len(value) == 8 && HasHeader(v.db, hash, binary.BigEndian.Uint64(value)) {
+				return true
+			}
+			if v.issue(VerifyHeaders, IssueDangling, nil, key, true, "Header number mapping without header %x", hash) {
+				DeleteHeaderNumber(v.batch, hash)
+			}
+			return false
+		}},
+		// Canonical hashes and transaction indices can't be beyond the head
+		{headerPrefix, canonStart, len(headerPrefix) + 8 + len(headerHashSuffix), func(key, value []byte) bool {
+			if !bytes.HasSuffix(key, headerHashSuffix) {
+				return true
+			}
+			number := binary.BigEndian.Uint64(key[1:9])
+			if v.issue(VerifyCanonical, IssueDangling, &number, key, head != nil, "Canonical hash above head header") {
+				DeleteCanonicalHash(v.batch, number)
+			}
+			return false
+		}},
+		{txLookupPrefix, nil, len(txLookupPrefix) + common.HashLength, func(key, value []byte) bool {
+			hash := common.BytesToHash(key[1:])
+			number := ReadTxLookupEntry(v.db, hash)
+			switch {
+			case number == nil:
+				if v.issue(VerifyTxLookups, IssueCorrupt, nil, key, true, "Transaction index undecodable") {
+					DeleteTxLookupEntry(v.batch, hash)
+				}
+			case head != nil && *number > bodyHead:
+				if v.issue(VerifyTxLookups, IssueDangling, number, key, true, "Transaction index above head block") {
+					DeleteTxLookupEntry(v.batch, hash)
+				}
+			case indexTail != nil && *number < *indexTail:
+				if v.issue(VerifyTxLookups, IssueDangling, number, key, true, "Transaction index below index tail %d", *indexTail) {
+					DeleteTxLookupEntry(v.batch, hash)
+				}
+			default:
+				return true
+			}
+			return false
+		}},
+	}
+	for _, check := range checks {
+		it := v.db.NewIterator(check.prefix, check.start)
+		for it.Next() {
+			if len(it.Key()) != check.length {
+				continue
+			}
+			check.check(it.Key(), it.Value())
+			if err := v.flush(); err != nil {
+				it.Release()
+				return err
+			}
+			v.progress("dangling", "key", hexutil.Bytes(it.Key()))
+		}
+		err := it.Error()
+		it.Release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyRecords checks the keys and values of the TeWaka used item records. The
+// records only mark an item used by their presence, so malformed values are
+// rewritten rather than deleted.
+func (v *verifier) verifyRecords() error {
+	it := v.db.NewIterator(recordPrefix, nil)
+	defer it.Release()
+
+	valid := []byte{0x01} // RLP encoding of the big integer 1
+	for it.Next() {
+		key := it.Key()
+		v.report.Check(VerifyRecords)
+		if len(key) != len(recordPrefix)+8+common.HashLength || binary.BigEndian.Uint64(key[len(recordPrefix):]) > 0xff {
+			// The item types are 8 bits, such a record can't ever be looked up
+			if v.issue(VerifyRecords, IssueDangling, nil, key, true, "Malformed used item record key") {
+				if err := v.batch.Delete(key); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !bytes.Equal(it.Value(), valid) {
+			if v.issue(VerifyRecords, IssueCorrupt, nil, key, true, "Malformed used item record value %x", it.Value()) {
+				if err := v.batch.Put(key, valid); err != nil {
+					return err
+				}
+			}
+		}
+		if err := v.flush(); err != nil {
+			return err
+		}
+		v.progress("records", "key", hexutil.Bytes(key))
+	}
+	return it.Error()
+}
@@ -0,0 +1,256 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/rlp"
+)
+
+// makeVerifyChain writes a consistent chain of blocks into the database.
+func makeVerifyChain(db czzdb.Database, n int) []*types.Block {
+	var (
+		blocks []*types.Block
+		parent common.Hash
+		td     = new(big.Int)
+	)
+	for i := 0; i < n; i++ {
+		var (
+			txs      []*types.Transaction
+			receipts []*types.Receipt
+		)
+		if i > 0 {
+			for j := 0; j < 2; j++ {
+				txs = append(txs, types.NewTransaction(uint64(i*2+j), common.Address{byte(i)}, big.NewInt(1), 21000, big.NewInt(1), nil))
+				receipts = append(receipts, &types.Receipt{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: uint64(21000 * (j + 1)), Logs: []*types.Log{}})
+			}
+		}
+		block := types.NewBlock(&types.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(int64(i)),
+			Difficulty: big.NewInt(int64(i + 1)),
+		}, txs, receipts, newHasher())
+		td.Add(td, block.Difficulty())
+
+		WriteBlock(db, block)
+		WriteReceipts(db, block.Hash(), block.NumberU64(), receipts)
+		WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		WriteTd(db, block.Hash(), block.NumberU64(), td)
+		WriteTxLookupEntriesByBlock(db, block)
+
+		blocks, parent = append(blocks, block), block.Hash()
+	}
+	head := blocks[len(blocks)-1].Hash()
+	WriteHeadHeaderHash(db, head)
+	WriteHeadFastBlockHash(db, head)
+	WriteHeadBlockHash(db, head)
+	WriteTxIndexTail(db, 0)
+	WriteRecord(db, 1, common.Hash{0x01})
+	return blocks
+}
+
+// Tests that the database verification finds the inconsistencies in the chain
+// data and repairs the ones which can be derived from the remaining data.
+func TestVerifyDatabase(t *testing.T) {
+	db := NewMemoryDatabase()
+	blocks := makeVerifyChain(db, 10)
+
+	report, err := VerifyDatabase(db, newHasher(), false)
+	if err != nil {
+		t.Fatalf("failed to verify database: %v", err)
+	}
+	if found := report.Found(); found != 0 {
+		t.Fatalf("issues found in consistent database: %d", found)
+	}
+	if checked := report.Stats[VerifyCanonical].Checked; checked != 10 {
+		t.Fatalf("canonical blocks checked mismatch: have %d, want 10", checked)
+	}
+	// Break the database in various repairable ways
+	DeleteCanonicalHash(db, 5)
+	DeleteTd(db, blocks[3].Hash(), 3)
+	DeleteHeaderNumber(db, blocks[7].Hash())
+	DeleteTxLookupEntry(db, blocks[2].Transactions()[0].Hash())
+	WriteTxLookupEntries(db, 100, []common.Hash{{0xaa}})
+	WriteCanonicalHash(db, common.Hash{0xbb}, 11)
+	WriteBody(db, common.Hash{0xcc}, 4, &types.Body{})
+	db.Put(append(recordKeyPrefix(2, common.Hash{0x02}), 0x00), []byte{0x01})
+	db.Put(recordKeyPrefix(3, common.Hash{0x03}), []byte{0x02})
+
+	// And an unrepairable one
+	DeleteReceipts(db, blocks[8].Hash(), 8)
+
+	want := map[string]VerifyStats{
+		VerifyCanonical: {Missing: 1, Dangling: 1},
+		VerifyHeaders:   {Missing: 2},
+		VerifyBodies:    {Dangling: 1},
+		VerifyReceipts:  {Missing: 1},
+		VerifyTxLookups: {Missing: 1, Dangling: 1},
+		VerifyRecords:   {Dangling: 1, Corrupt: 1},
+	}
+	check := func(report *VerifyReport, repaired bool) {
+		t.Helper()
+		for category, stats := range want {
+			have := *report.Stats[category]
+			have.Checked = 0
+			if repaired && category != VerifyReceipts {
+				stats.Repaired = stats.Missing + stats.Dangling + stats.Corrupt
+			}
+			if have != stats {
+				t.Errorf("%s: stats mismatch: have %+v, want %+v", category, have, stats)
+			}
+		}
+	}
+	for i := 0; i < 2; i++ {
+		report, err := VerifyDatabase(db, newHasher(), false)
+		if err != nil {
+			t.Fatalf("failed to verify database: %v", err)
+		}
+		check(report, false)
+		if report.Unrepaired() != report.Found() {
+			t.Fatalf("issues repaired without repair mode")
+		}
+	}
+	report, err = VerifyDatabase(db, newHasher(), true)
+	if err != nil {
+		t.Fatalf("failed to repair database: %v", err)
+	}
+	check(report, true)
+	if report.Unrepaired() != 1 {
+		t.Fatalf("unrepaired issues mismatch: have %d, want 1", report.Unrepaired())
+	}
+	// Only the missing receipts should remain after the repair
+	report, err = VerifyDatabase(db, newHasher(), false)
+	if err != nil {
+		t.Fatalf("failed to verify database: %v", err)
+	}
+	if report.Found() != 1 || report.Stats[VerifyReceipts].Missing != 1 {
+		t.Fatalf("issues left after repair: %v", report.Issues)
+	}
+	if hash := ReadCanonicalHash(db, 5); hash != blocks[5].Hash() {
+		t.Fatalf("canonical hash not restored: have %x, want %x", hash, blocks[5].Hash())
+	}
+	if td := ReadTd(db, blocks[3].Hash(), 3); td == nil || td.Cmp(big.NewInt(10)) != 0 {
+		t.Fatalf("total difficulty not restored: have %v, want 10", td)
+	}
+	if HasRecord(db, 3, common.Hash{0x03}) != true {
+		t.Fatalf("used item record dropped")
+	}
+}
+
+// freezeVerifyChain moves the first blocks of the chain into the ancient store,
+// like the chain freezer does, storing the given hashes instead of the real ones.
+func freezeVerifyChain(t *testing.T, db czzdb.Database, blocks []*types.Block, frozen int, forged map[int]common.Hash) {
+	for i, block := range blocks[:frozen] {
+		hash, number := block.Hash(), block.NumberU64()
+		if forgery, ok := forged[i]; ok {
+			hash = forgery
+		}
+		header, _ := rlp.EncodeToBytes(block.Header())
+		body := ReadBodyRLP(db, block.Hash(), number)
+		receipts := ReadReceiptsRLP(db, block.Hash(), number)
+		td := ReadTdRLP(db, block.Hash(), number)
+		if err := db.AppendAncient(number, hash[:], header, body, receipts, td); err != nil {
+			t.Fatalf("failed to freeze block %d: %v", number, err)
+		}
+	}
+	for _, block := range blocks[:frozen] {
+		DeleteBlockWithoutNumber(db, block.Hash(), block.NumberU64())
+		DeleteCanonicalHash(db, block.NumberU64())
+	}
+}
+
+// newVerifyFreezerDB creates a database with an ancient store in a temporary
+// directory, holding a chain of 10 blocks with the first 6 frozen.
+func newVerifyFreezerDB(t *testing.T, forged map[int]common.Hash) (czzdb.Database, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temp freezer dir: %v", err)
+	}
+	db, err := NewDatabaseWithFreezer(NewMemoryDatabase(), dir, "", false)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to create database with ancient backend: %v", err)
+	}
+	freezeVerifyChain(t, db, makeVerifyChain(db, 10), 6, forged)
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Tests that the database verification cross-checks the ancient tables against
+// each other and the frozen headers against their canonical hashes.
+func TestVerifyFreezer(t *testing.T) {
+	db, release := newVerifyFreezerDB(t, nil)
+	defer release()
+
+	report, err := VerifyDatabase(db, newHasher(), false)
+	if err != nil {
+		t.Fatalf("failed to verify database: %v", err)
+	}
+	if found := report.Found(); found != 0 {
+		t.Fatalf("issues found in consistent database: %v", report.Issues)
+	}
+	// Every frozen item and every table should have been checked
+	if checked := report.Stats[VerifyFreezer].Checked; checked != 1+5+6 {
+		t.Fatalf("ancient items checked mismatch: have %d, want %d", checked, 1+5+6)
+	}
+	// Drop the last frozen receipts, leaving the receipts table short
+	if err := db.(*freezerdb).AncientStore.(*freezer).tables[freezerReceiptTable].truncate(5); err != nil {
+		t.Fatalf("failed to truncate receipts table: %v", err)
+	}
+	if report, err = VerifyDatabase(db, newHasher(), true); err != nil {
+		t.Fatalf("failed to verify database: %v", err)
+	}
+	if stats := *report.Stats[VerifyFreezer]; stats.Missing != 1 || report.Stats[VerifyReceipts].Missing != 1 || report.Found() != 2 {
+		t.Fatalf("short table not detected: %v", report.Issues)
+	}
+	if report.Unrepaired() != 2 {
+		t.Fatalf("unrepaired issues mismatch: have %d, want 2", report.Unrepaired())
+	}
+}
+
+// Tests that the database verification detects frozen headers not matching the
+// canonical hashes stored along with them.
+func TestVerifyFreezerForgedHash(t *testing.T) {
+	db, release := newVerifyFreezerDB(t, map[int]common.Hash{3: {0xee}})
+	defer release()
+
+	report, err := VerifyDatabase(db, newHasher(), false)
+	if err != nil {
+		t.Fatalf("failed to verify database: %v", err)
+	}
+	var found bool
+	for _, issue := range report.Issues {
+		if issue.Category == VerifyFreezer {
+			if found || issue.Kind != IssueCorrupt || issue.Number == nil || *issue.Number != 3 {
+				t.Fatalf("unexpected ancient issue: %+v", issue)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("forged canonical hash not detected: %v", report.Issues)
+	}
+}
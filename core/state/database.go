@@ -36,6 +36,11 @@ const (
 	codeCacheSize = 64 * 1024 * 1024
 )
 
+// ErrRecordsUnwitnessed is returned when the TeWaka item records are accessed
+// through a witnessed state database. The records are kept outside of the state
+// trie, so no state root authenticates them and a witness can't carry them.
+var ErrRecordsUnwitnessed = errors.New("TeWaka item records can't be witnessed")
+
 // Database wraps access to tries and contract code.
 type Database interface {
 	// OpenTrie opens the main account trie.
@@ -138,7 +143,7 @@ func (db *cachingDB) OpenTrie(root common.Hash) (Trie, error) {
 
 // OpenStorageTrie opens the storage trie of an account.
 func (db *cachingDB) OpenStorageTrie(addrHash, root common.Hash) (Trie, error) {
-	tr, err := trie.NewSecure(root, db.db)
+	tr, err := trie.NewSecureWithOwner(addrHash, root, db.db)
 	if err != nil {
 		return nil, err
 	}
@@ -198,3 +203,73 @@ func (db *cachingDB) ContractCodeSize(addrHash, codeHash common.Hash) (int, erro
 func (db *cachingDB) TrieDB() *trie.Database {
 	return db.db
 }
+
+// Recorder collects the trie nodes and contract codes accessed through a state
+// database, forming the witness needed to repeat the accesses without it.
+type Recorder interface {
+	trie.NodeRecorder
+
+	// RecordCode is called with the hash and the contents of an accessed contract
+	// code.
+	RecordCode(hash common.Hash, code []byte)
+}
+
+// witnessedDB is implemented by the state databases recording or serving an
+// execution witness, which the TeWaka item records can't be part of.
+type witnessedDB interface {
+	witnessed()
+}
+
+// NewRecordingDatabase wraps a state database, notifying the recorder of every
+// trie node and contract code accessed through it. Any access to the TeWaka
+// item records fails with ErrRecordsUnwitnessed.
+func NewRecordingDatabase(db Database, recorder Recorder) Database {
+	return &recordingDB{Database: db, recorder: recorder}
+}
+
+type recordingDB struct {
+	Database
+	recorder Recorder
+}
+
+func (db *recordingDB) witnessed() {}
+
+// OpenTrie opens the main account trie at a specific root hash.
+func (db *recordingDB) OpenTrie(root common.Hash) (Trie, error) {
+	return trie.NewSecureWithRecorder(common.Hash{}, root, db.TrieDB(), db.recorder)
+}
+
+// OpenStorageTrie opens the storage trie of an account.
+func (db *recordingDB) OpenStorageTrie(addrHash, root common.Hash) (Trie, error) {
+	return trie.NewSecureWithRecorder(addrHash, root, db.TrieDB(), db.recorder)
+}
+
+// ContractCode retrieves a particular contract's code.
+func (db *recordingDB) ContractCode(addrHash, codeHash common.Hash) ([]byte, error) {
+	code, err := db.Database.ContractCode(addrHash, codeHash)
+	if err != nil {
+		return nil, err
+	}
+	db.recorder.RecordCode(codeHash, code)
+	return code, nil
+}
+
+// ContractCodeSize retrieves a particular contracts code's size. The whole code
+// is recorded, as its size can't be proven otherwise.
+func (db *recordingDB) ContractCodeSize(addrHash, codeHash common.Hash) (int, error) {
+	code, err := db.ContractCode(addrHash, codeHash)
+	return len(code), err
+}
+
+// NewWitnessDatabase wraps a state database only holding the data of an
+// execution witness. Any access to the TeWaka item records fails with
+// ErrRecordsUnwitnessed, instead of silently reporting them missing.
+func NewWitnessDatabase(db Database) Database {
+	return &witnessDB{Database: db}
+}
+
+type witnessDB struct {
+	Database
+}
+
+func (db *witnessDB) witnessed() {}
@@ -0,0 +1,586 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/state/snapshot"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/rlp"
+	"github.com/classzz/go-classzz-v2/trie"
+)
+
+const (
+	// onlinePruneThrottle is the time the online pruner sleeps after writing
+	// out each deletion batch, leaving room for the block import.
+	onlinePruneThrottle = 100 * time.Millisecond
+
+	// onlinePruneHoldLimit is the maximum time the snapshot tree is held while
+	// the target state is regenerated. Beyond it the diff layers are flattened
+	// again to cap their memory use, failing the pruning.
+	onlinePruneHoldLimit = time.Hour
+
+	// maxMarkedRoots is the number of most recent journaled state roots tracked
+	// by the online pruner. Older ones are still in the bloom filter, they are
+	// only marked again if journaled twice.
+	maxMarkedRoots = 256
+)
+
+// Stages of an online pruning run, reported via OnlinePruneProgress.
+const (
+	PruneStageIdle       = "idle"
+	PruneStageMarking    = "marking"
+	PruneStagePruning    = "pruning"
+	PruneStageCompacting = "compacting"
+	PruneStageDone       = "done"
+	PruneStageFailed     = "failed"
+	PruneStageStopped    = "stopped"
+)
+
+var (
+	// errPruneRunning is returned if an online pruning is requested while
+	// another one is still in progress.
+	errPruneRunning = errors.New("state pruning already in progress")
+
+	// errPruneStopped is returned if the online pruning was interrupted.
+	errPruneStopped = errors.New("state pruning stopped")
+)
+
+// OnlinePruneProgress is the progress report of an online pruning run.
+type OnlinePruneProgress struct {
+	Stage     string             `json:"stage"`           // Current stage of the pruning
+	Target    common.Hash        `json:"target"`          // State root the bloom filter was built for
+	Journaled uint64             `json:"journaled"`       // Number of in-flight state roots marked
+	Nodes     uint64             `json:"nodes"`           // Number of state entries deleted
+	Size      common.StorageSize `json:"size"`            // Size of the state entries deleted
+	Marker    hexutil.Bytes      `json:"marker"`          // Last database key visited by the deletion
+	Elapsed   string             `json:"elapsed"`         // Time spent since the pruning started
+	Error     string             `json:"error,omitempty"` // Failure reason if the pruning failed
+}
+
+// OnlinePruner prunes the stale state while the node keeps running. It works
+// the same way as the offline Pruner, with a few twists to cope with the
+// blocks imported meanwhile:
+//
+// - the snapshot tree is held while the target state is regenerated into the
+//   bloom filter, so that the iterated layers don't go stale, up to an hour
+// - the trie nodes and contract codes of every state committed during the
+//   pruning are journaled into the bloom filter by the chain
+// - the deletion runs in throttled batches and leaves the contract codes
+//   alone, as they are written to disk directly and might race with it
+//
+// Once the bloom filter is committed to disk, an interrupted pruning is resumed
+// on the next startup by RecoverPruning, just like an offline one.
+type OnlinePruner struct {
+	db        czzdb.Database
+	snaptree  *snapshot.Tree
+	triedb    *trie.Database
+	datadir   string
+	bloomSize uint64
+
+	stateBloom *stateBloom
+	bloomPath  string                   // Path of the committed bloom filter, empty if not yet committed
+	marked     map[common.Hash]struct{} // Recent state roots completely contained in the bloom filter
+	markedList []common.Hash            // Recent state roots in the order they were marked
+	release    func()                   // Release function of the snapshot hold
+	progress   OnlinePruneProgress
+	start      time.Time
+	running    bool
+
+	quit chan struct{}
+	term chan struct{}
+	lock sync.Mutex
+}
+
+// NewOnlinePruner creates an online pruner working on the given live snapshot
+// tree and trie database.
+func NewOnlinePruner(db czzdb.Database, snaptree *snapshot.Tree, triedb *trie.Database, datadir string, bloomSize uint64) (*OnlinePruner, error) {
+	if triedb.Scheme() == rawdb.PathScheme {
+		return nil, ErrPathScheme
+	}
+	if snaptree == nil {
+		return nil, errors.New("snapshot not available")
+	}
+	// Sanitize the bloom filter size if it's too small.
+	if bloomSize < 256 {
+		log.Warn("Sanitizing bloomfilter size", "provided(MB)", bloomSize, "updated(MB)", 256)
+		bloomSize = 256
+	}
+	return &OnlinePruner{
+		db:        db,
+		snaptree:  snaptree,
+		triedb:    triedb,
+		datadir:   datadir,
+		bloomSize: bloomSize,
+		progress:  OnlinePruneProgress{Stage: PruneStageIdle},
+	}, nil
+}
+
+// Start kicks off the pruning in the background, using the HEAD-127 state as
+// the target. The state roots between the target and the given head are marked
+// before returning, so the caller must make sure that no block is imported
+// while Start is running, and that Journal is invoked for every state committed
+// afterwards.
+func (p *OnlinePruner) Start(head *types.Header) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.term != nil {
+		select {
+		case <-p.term:
+		default:
+			return errPruneRunning
+		}
+	}
+	// A leftover bloom filter means a previous pruning is still unfinished,
+	// it must be resumed by RecoverPruning first.
+	path, _, err := findBloomFilter(p.datadir)
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		return fmt.Errorf("unfinished state pruning found: %s", path)
+	}
+	// Pick the bottom-most diff layer as the target, for the same reason
+	// as the offline pruner: its state is available and very unlikely to be
+	// reorged away.
+	layers := p.snaptree.Snapshots(head.Root, 128, true)
+	if len(layers) != 128 {
+		return fmt.Errorf("snapshot not old enough yet: need %d more blocks", 128-len(layers))
+	}
+	root := layers[len(layers)-1].Root()
+	if blob, _ := p.triedb.Node(root); len(blob) == 0 {
+		return fmt.Errorf("associated state[%x] is not present", root)
+	}
+	stateBloom, err := newStateBloomWithSize(p.bloomSize)
+	if err != nil {
+		return err
+	}
+	p.stateBloom, p.bloomPath = stateBloom, ""
+	p.marked, p.markedList = make(map[common.Hash]struct{}), nil
+	p.markRoot(root)
+
+	// All the states upon the target are still alive, mark whatever they
+	// changed relative to their parents. The target itself is regenerated
+	// from the snapshot later.
+	for i := len(layers) - 2; i >= 0; i-- {
+		if err := markStateDiff(p.triedb, p.stateBloom, layers[i+1].Root(), layers[i].Root()); err != nil {
+			return err
+		}
+		p.markRoot(layers[i].Root())
+	}
+	p.release = p.snaptree.Hold(onlinePruneHoldLimit)
+	p.progress = OnlinePruneProgress{Stage: PruneStageMarking, Target: root, Journaled: uint64(len(layers) - 1)}
+	p.start = time.Now()
+	p.running = true
+	p.quit, p.term = make(chan struct{}), make(chan struct{})
+
+	log.Info("Started online state pruning", "target", root, "number", head.Number.Uint64()-127)
+	go p.run(root, p.quit, p.term)
+	return nil
+}
+
+// Journal marks all the state entries committed by a new block into the bloom
+// filter. It must be invoked before the new trie nodes can reach the disk.
+func (p *OnlinePruner) Journal(parent, root common.Hash) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.running {
+		return nil
+	}
+	if _, ok := p.marked[root]; ok {
+		return nil
+	}
+	if err := markStateDiff(p.triedb, p.stateBloom, parent, root); err != nil {
+		// The new state can't be protected, abort before anything of it
+		// reaches the disk and gets deleted.
+		p.fail(err)
+		p.interrupt()
+
+		term := p.term
+		p.lock.Unlock()
+		<-term
+		p.lock.Lock()
+
+		// The committed bloom filter doesn't cover the new state either, so
+		// it must not be resumed on the next startup.
+		p.dropBloom()
+		return err
+	}
+	p.markRoot(root)
+	p.progress.Journaled++
+	return nil
+}
+
+// markRoot records a state root completely contained in the bloom filter,
+// forgetting the oldest one beyond maxMarkedRoots. The lock is assumed to be
+// held.
+func (p *OnlinePruner) markRoot(root common.Hash) {
+	p.marked[root] = struct{}{}
+	p.markedList = append(p.markedList, root)
+	if len(p.markedList) > maxMarkedRoots {
+		delete(p.marked, p.markedList[0])
+		p.markedList = p.markedList[1:]
+	}
+}
+
+// dropBloom deletes the committed bloom filter of an aborted pruning. The stale
+// state entries not deleted yet are left dangling. The lock is assumed to be
+// held.
+func (p *OnlinePruner) dropBloom() {
+	if p.bloomPath == "" {
+		return
+	}
+	os.RemoveAll(p.bloomPath)
+	log.Warn("Dropped state bloom of aborted pruning", "name", p.bloomPath)
+	p.bloomPath = ""
+}
+
+// Running reports whether a pruning is in progress.
+func (p *OnlinePruner) Running() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.running
+}
+
+// Progress returns the progress report of the current or last pruning.
+func (p *OnlinePruner) Progress() OnlinePruneProgress {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	progress := p.progress
+	if !p.start.IsZero() {
+		progress.Elapsed = common.PrettyDuration(time.Since(p.start)).String()
+	}
+	return progress
+}
+
+// Stop interrupts a running pruning. If the deletion has already begun, the
+// bloom filter is re-committed for the given head state, if it was journaled,
+// so that RecoverPruning resumes the pruning without rewinding the chain.
+func (p *OnlinePruner) Stop(head common.Hash) {
+	p.lock.Lock()
+	if !p.running {
+		p.lock.Unlock()
+		return
+	}
+	p.interrupt()
+	term := p.term
+	p.lock.Unlock()
+
+	<-term
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.bloomPath == "" || p.progress.Stage == PruneStageDone {
+		return
+	}
+	if _, ok := p.marked[head]; !ok {
+		return
+	}
+	filterName := bloomFilterName(p.datadir, head)
+	if filterName == p.bloomPath {
+		return
+	}
+	if err := p.stateBloom.Commit(filterName, filterName+stateBloomFileTempSuffix); err != nil {
+		log.Error("Failed to re-commit state bloom", "err", err)
+		return
+	}
+	os.RemoveAll(p.bloomPath)
+	p.bloomPath = filterName
+	log.Info("State bloom filter re-committed", "name", filterName)
+}
+
+// fail marks the pruning as failed. The lock is assumed to be held.
+func (p *OnlinePruner) fail(err error) {
+	if p.progress.Stage != PruneStageFailed {
+		log.Error("Online state pruning failed", "err", err)
+	}
+	p.progress.Stage, p.progress.Error = PruneStageFailed, err.Error()
+	p.running = false
+}
+
+// interrupt signals the background goroutine to terminate. The lock is assumed
+// to be held.
+func (p *OnlinePruner) interrupt() {
+	select {
+	case <-p.quit:
+	default:
+		close(p.quit)
+	}
+}
+
+// setStage updates the reported stage, unless the pruning already failed.
+func (p *OnlinePruner) setStage(stage string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.running {
+		return false
+	}
+	p.progress.Stage = stage
+	return true
+}
+
+// run is the background goroutine of the pruning.
+func (p *OnlinePruner) run(root common.Hash, quit, term chan struct{}) {
+	defer close(term)
+
+	err := p.mark(root, quit)
+	p.release()
+	if err == nil {
+		err = p.prune(quit)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	switch {
+	case !p.running:
+		// Failed already while journaling, nothing to report
+	case err == errPruneStopped:
+		p.progress.Stage, p.running = PruneStageStopped, false
+		log.Info("Online state pruning stopped", "elapsed", common.PrettyDuration(time.Since(p.start)))
+	case err != nil:
+		p.fail(err)
+	default:
+		p.progress.Stage, p.running = PruneStageDone, false
+	}
+}
+
+// mark regenerates the target state from the held snapshot into the bloom
+// filter, together with the genesis state, and commits the bloom to disk.
+func (p *OnlinePruner) mark(root common.Hash, quit chan struct{}) error {
+	err := snapshot.GenerateTrieWithAbort(p.snaptree, root, p.db, p.stateBloom, quit)
+	if errors.Is(err, snapshot.ErrSnapshotStale) {
+		err = fmt.Errorf("snapshot flattened after holding it for %v: %w", onlinePruneHoldLimit, err)
+	}
+	if err == nil {
+		err = extractGenesis(p.db, p.stateBloom)
+	}
+	select {
+	case <-quit:
+		return errPruneStopped
+	default:
+	}
+	if err != nil {
+		return err
+	}
+	filterName := bloomFilterName(p.datadir, root)
+
+	log.Info("Writing state bloom to disk", "name", filterName)
+	if err := p.stateBloom.Commit(filterName, filterName+stateBloomFileTempSuffix); err != nil {
+		return err
+	}
+	log.Info("State bloom filter committed", "name", filterName)
+
+	p.lock.Lock()
+	p.bloomPath = filterName
+	p.lock.Unlock()
+	return nil
+}
+
+// prune deletes all the trie nodes not contained in the bloom filter in
+// throttled batches, compacts the database and drops the bloom filter.
+func (p *OnlinePruner) prune(quit chan struct{}) error {
+	if !p.setStage(PruneStagePruning) {
+		return nil
+	}
+	var (
+		count  uint64
+		size   common.StorageSize
+		logged = time.Now()
+		batch  = p.db.NewBatch()
+		iter   = p.db.NewIterator(nil, nil)
+	)
+	defer func() { iter.Release() }()
+
+	flush := func(key []byte) error {
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+
+		p.lock.Lock()
+		p.progress.Nodes, p.progress.Size = count, size
+		p.progress.Marker = key
+		p.lock.Unlock()
+		return nil
+	}
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != common.HashLength {
+			continue
+		}
+		if ok, err := p.stateBloom.Contain(key); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+		count += 1
+		size += common.StorageSize(len(key) + len(iter.Value()))
+		batch.Delete(key)
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning state data", "nodes", count, "size", size, "elapsed", common.PrettyDuration(time.Since(p.start)))
+			logged = time.Now()
+		}
+		if batch.ValueSize() >= czzdb.IdealBatchSize {
+			key = common.CopyBytes(key)
+			if err := flush(key); err != nil {
+				return err
+			}
+			// Recreate the iterator after every batch commit in order
+			// to allow the underlying compactor to delete the entries.
+			iter.Release()
+			iter = p.db.NewIterator(nil, key)
+
+			select {
+			case <-quit:
+				return errPruneStopped
+			case <-time.After(onlinePruneThrottle):
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if err := flush(nil); err != nil {
+		return err
+	}
+	log.Info("Pruned state data", "nodes", count, "size", size, "elapsed", common.PrettyDuration(time.Since(p.start)))
+
+	// Delete the state bloom, it marks the entire pruning procedure is
+	// finished. If any crashes happen before this, `RecoverPruning` will
+	// pick it up in the next restart.
+	p.lock.Lock()
+	os.RemoveAll(p.bloomPath)
+	p.bloomPath = ""
+	p.lock.Unlock()
+
+	if count >= rangeCompactionThreshold && p.setStage(PruneStageCompacting) {
+		cstart := time.Now()
+		for b := 0x00; b <= 0xf0; b += 0x10 {
+			var (
+				start = []byte{byte(b)}
+				end   = []byte{byte(b + 0x10)}
+			)
+			if b == 0xf0 {
+				end = nil
+			}
+			select {
+			case <-quit:
+				return errPruneStopped
+			default:
+			}
+			log.Info("Compacting database", "range", fmt.Sprintf("%#x-%#x", start, end), "elapsed", common.PrettyDuration(time.Since(cstart)))
+			if err := p.db.Compact(start, end); err != nil {
+				log.Error("Database compaction failed", "error", err)
+				return err
+			}
+		}
+		log.Info("Database compaction finished", "elapsed", common.PrettyDuration(time.Since(cstart)))
+	}
+	log.Info("State pruning successful", "pruned", size, "elapsed", common.PrettyDuration(time.Since(p.start)))
+	return nil
+}
+
+// markStateDiff adds all the trie nodes and contract codes which are reachable
+// from the given state root but not from its parent into the bloom filter. If
+// the parent state is completely contained in the bloom, so is the new one.
+func markStateDiff(triedb *trie.Database, stateBloom *stateBloom, parent, root common.Hash) error {
+	if parent == root {
+		return nil
+	}
+	oldTrie, err := trie.New(parent, triedb)
+	if err != nil {
+		return err
+	}
+	newTrie, err := trie.New(root, triedb)
+	if err != nil {
+		return err
+	}
+	accIter, _ := trie.NewDifferenceIterator(oldTrie.NodeIterator(nil), newTrie.NodeIterator(nil))
+	for accIter.Next(true) {
+		// Embedded nodes don't have hash.
+		if hash := accIter.Hash(); hash != (common.Hash{}) {
+			stateBloom.Put(hash.Bytes(), nil)
+		}
+		if !accIter.Leaf() {
+			continue
+		}
+		var acc state.Account
+		if err := rlp.DecodeBytes(accIter.LeafBlob(), &acc); err != nil {
+			return err
+		}
+		if !bytes.Equal(acc.CodeHash, emptyCode) {
+			stateBloom.Put(acc.CodeHash, nil)
+		}
+		if acc.Root == emptyRoot {
+			continue
+		}
+		// Dig into the storage trie, only the part changed relative to the
+		// account's storage in the parent state is new.
+		prevRoot := emptyRoot
+		blob, err := oldTrie.TryGet(accIter.LeafKey())
+		if err != nil {
+			return err
+		}
+		if len(blob) > 0 {
+			var prev state.Account
+			if err := rlp.DecodeBytes(blob, &prev); err != nil {
+				return err
+			}
+			prevRoot = prev.Root
+		}
+		if prevRoot == acc.Root {
+			continue
+		}
+		oldStorage, err := trie.New(prevRoot, triedb)
+		if err != nil {
+			return err
+		}
+		newStorage, err := trie.New(acc.Root, triedb)
+		if err != nil {
+			return err
+		}
+		storageIter, _ := trie.NewDifferenceIterator(oldStorage.NodeIterator(nil), newStorage.NodeIterator(nil))
+		for storageIter.Next(true) {
+			if hash := storageIter.Hash(); hash != (common.Hash{}) {
+				stateBloom.Put(hash.Bytes(), nil)
+			}
+		}
+		if storageIter.Error() != nil {
+			return storageIter.Error()
+		}
+	}
+	return accIter.Error()
+}
@@ -0,0 +1,357 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/state/snapshot"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/rlp"
+	"github.com/classzz/go-classzz-v2/trie"
+)
+
+// pruneTestChain is a chain of states committed to disk one after the other,
+// tracked by a live snapshot tree.
+type pruneTestChain struct {
+	db     czzdb.Database
+	sdb    state.Database
+	snaps  *snapshot.Tree
+	roots  []common.Hash // State roots by block number
+	writes int           // Number of accounts modified by each block
+}
+
+// newPruneTestChain creates a genesis state with the given number of accounts,
+// some of them holding code and storage, and commits it as block zero.
+func newPruneTestChain(t *testing.T, accounts int, writes int) *pruneTestChain {
+	db := rawdb.NewMemoryDatabase()
+	sdb := state.NewDatabase(db)
+
+	statedb, _ := state.New(common.Hash{}, sdb, nil)
+	for i := 0; i < accounts; i++ {
+		addr := pruneTestAddress(i)
+		statedb.SetBalance(addr, big.NewInt(int64(i+1)))
+		if i%10 == 0 {
+			statedb.SetCode(addr, []byte{byte(i), 0x60, 0x00})
+			statedb.SetState(addr, common.Hash{}, common.Hash{1})
+		}
+	}
+	root, err := statedb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit genesis state: %v", err)
+	}
+	if err := sdb.TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to flush genesis state: %v", err)
+	}
+	genesis := types.NewBlock(&types.Header{Number: big.NewInt(0), Root: root}, nil, nil, trie.NewStackTrie(nil))
+	rawdb.WriteBlock(db, genesis)
+	rawdb.WriteCanonicalHash(db, genesis.Hash(), 0)
+	rawdb.WriteHeadBlockHash(db, genesis.Hash())
+
+	snaps, err := snapshot.New(db, sdb.TrieDB(), 16, root, false, true, false)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	return &pruneTestChain{db: db, sdb: sdb, snaps: snaps, roots: []common.Hash{root}, writes: writes}
+}
+
+func pruneTestAddress(i int) common.Address {
+	return common.BytesToAddress(crypto.Keccak256([]byte{byte(i >> 8), byte(i)}))
+}
+
+// extend commits the given number of new states, journaling each of them into
+// the pruner if any, before flushing it to disk.
+func (c *pruneTestChain) extend(t *testing.T, blocks int, p *OnlinePruner) {
+	for i := 0; i < blocks; i++ {
+		var (
+			number = len(c.roots)
+			parent = c.roots[number-1]
+		)
+		statedb, err := state.New(parent, c.sdb, c.snaps)
+		if err != nil {
+			t.Fatalf("block %d: failed to open parent state: %v", number, err)
+		}
+		for j := 0; j < c.writes; j++ {
+			addr := pruneTestAddress((number*c.writes + j) % 200)
+			statedb.AddBalance(addr, big.NewInt(1))
+			statedb.SetState(addr, common.BigToHash(big.NewInt(int64(number%7))), common.BigToHash(big.NewInt(int64(number))))
+		}
+		root, err := statedb.Commit(true)
+		if err != nil {
+			t.Fatalf("block %d: failed to commit state: %v", number, err)
+		}
+		if p != nil {
+			if err := p.Journal(parent, root); err != nil {
+				t.Fatalf("block %d: failed to journal state: %v", number, err)
+			}
+		}
+		if err := c.sdb.TrieDB().Commit(root, false, nil); err != nil {
+			t.Fatalf("block %d: failed to flush state: %v", number, err)
+		}
+		c.roots = append(c.roots, root)
+	}
+}
+
+// head returns the header of the most recent block.
+func (c *pruneTestChain) head() *types.Header {
+	return &types.Header{Number: big.NewInt(int64(len(c.roots) - 1)), Root: c.roots[len(c.roots)-1]}
+}
+
+// newPruner creates an online pruner over the chain with a small bloom filter.
+func (c *pruneTestChain) newPruner(t *testing.T, datadir string) *OnlinePruner {
+	p, err := NewOnlinePruner(c.db, c.snaps, c.sdb.TrieDB(), datadir, 0)
+	if err != nil {
+		t.Fatalf("failed to create pruner: %v", err)
+	}
+	p.bloomSize = 1
+	return p
+}
+
+// checkState iterates the entire state with the given root straight from disk,
+// failing if any trie node or contract code is missing.
+func checkState(db czzdb.Database, root common.Hash) error {
+	triedb := trie.NewDatabase(db)
+	accTrie, err := trie.New(root, triedb)
+	if err != nil {
+		return err
+	}
+	accIter := accTrie.NodeIterator(nil)
+	for accIter.Next(true) {
+		if !accIter.Leaf() {
+			continue
+		}
+		var acc state.Account
+		if err := rlp.DecodeBytes(accIter.LeafBlob(), &acc); err != nil {
+			return err
+		}
+		if !bytes.Equal(acc.CodeHash, emptyCode) && len(rawdb.ReadCode(db, common.BytesToHash(acc.CodeHash))) == 0 {
+			return fmt.Errorf("missing code %x", acc.CodeHash)
+		}
+		if acc.Root == emptyRoot {
+			continue
+		}
+		storageTrie, err := trie.New(acc.Root, triedb)
+		if err != nil {
+			return err
+		}
+		storageIter := storageTrie.NodeIterator(nil)
+		for storageIter.Next(true) {
+		}
+		if err := storageIter.Error(); err != nil {
+			return err
+		}
+	}
+	return accIter.Error()
+}
+
+// waitPruning waits until the pruning leaves the running state.
+func waitPruning(t *testing.T, p *OnlinePruner) OnlinePruneProgress {
+	for start := time.Now(); p.Running(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Minute {
+			t.Fatalf("pruning not finished, progress %+v", p.Progress())
+		}
+	}
+	return p.Progress()
+}
+
+// waitSweep waits until the pruning deleted its first batch of state entries.
+func waitSweep(t *testing.T, p *OnlinePruner) {
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		progress := p.Progress()
+		if progress.Stage == PruneStagePruning && progress.Nodes > 0 {
+			return
+		}
+		if !p.Running() || time.Since(start) > time.Minute {
+			t.Fatalf("pruning not sweeping, progress %+v", progress)
+		}
+	}
+}
+
+// checkPruned ensures that all the states from the given block on, and the
+// genesis one, are complete, whilst the first block's state is pruned.
+func checkPruned(t *testing.T, chain *pruneTestChain, from int) {
+	t.Helper()
+
+	if err := checkState(chain.db, chain.roots[0]); err != nil {
+		t.Fatalf("genesis state damaged: %v", err)
+	}
+	for number := from; number < len(chain.roots); number++ {
+		if err := checkState(chain.db, chain.roots[number]); err != nil {
+			t.Fatalf("block %d state damaged: %v", number, err)
+		}
+	}
+	if err := checkState(chain.db, chain.roots[1]); err == nil {
+		t.Fatalf("stale state of block 1 not pruned")
+	}
+}
+
+// Tests that the online pruner marks the target state and all the ones journaled
+// during the pruning, and sweeps everything else.
+func TestOnlinePruning(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary datadir: %v", err)
+	}
+	defer os.RemoveAll(datadir)
+
+	chain := newPruneTestChain(t, 200, 10)
+	chain.extend(t, 130, nil)
+
+	// Too few diff layers, the pruning can't be started yet
+	p := chain.newPruner(t, datadir)
+	if err := p.Start(&types.Header{Number: big.NewInt(100), Root: chain.roots[100]}); err == nil {
+		t.Fatalf("pruning started without enough snapshot layers")
+	}
+	// Prune while extending the chain, the target being HEAD-127
+	if err := p.Start(chain.head()); err != nil {
+		t.Fatalf("failed to start pruning: %v", err)
+	}
+	target := len(chain.roots) - 128
+	if err := p.Start(chain.head()); err != errPruneRunning {
+		t.Fatalf("duplicate pruning error mismatch: have %v, want %v", err, errPruneRunning)
+	}
+	chain.extend(t, 5, p)
+
+	progress := waitPruning(t, p)
+	if progress.Stage != PruneStageDone {
+		t.Fatalf("pruning failed: %+v", progress)
+	}
+	if progress.Target != chain.roots[target] {
+		t.Fatalf("target mismatch: have %x, want %x", progress.Target, chain.roots[target])
+	}
+	if progress.Nodes == 0 {
+		t.Fatalf("no state entries pruned")
+	}
+	checkPruned(t, chain, target)
+
+	if path, _, _ := findBloomFilter(datadir); path != "" {
+		t.Fatalf("state bloom left behind: %s", path)
+	}
+	// The marked state roots are capped, dropping the oldest ones
+	for i := 0; i < maxMarkedRoots; i++ {
+		p.markRoot(common.BigToHash(big.NewInt(int64(i))))
+	}
+	if len(p.marked) != maxMarkedRoots || len(p.markedList) != maxMarkedRoots {
+		t.Fatalf("marked roots not capped: have %d/%d, want %d", len(p.marked), len(p.markedList), maxMarkedRoots)
+	}
+	if _, ok := p.marked[chain.roots[len(chain.roots)-1]]; ok {
+		t.Fatalf("oldest marked root not dropped")
+	}
+}
+
+// Tests that an online pruning stopped in the middle of the sweep re-commits
+// its bloom filter for the new head state, and is resumed from it on restart.
+func TestOnlinePruningRecovery(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary datadir: %v", err)
+	}
+	defer os.RemoveAll(datadir)
+
+	chain := newPruneTestChain(t, 200, 40)
+	chain.extend(t, 300, nil)
+
+	p := chain.newPruner(t, datadir)
+	if err := p.Start(chain.head()); err != nil {
+		t.Fatalf("failed to start pruning: %v", err)
+	}
+	target := len(chain.roots) - 128
+	chain.extend(t, 2, p)
+
+	// Keep importing after the deletion started, then stop the pruning
+	waitSweep(t, p)
+	chain.extend(t, 2, p)
+	p.Stop(chain.head().Root)
+
+	if progress := p.Progress(); progress.Stage != PruneStageStopped {
+		t.Fatalf("stage mismatch: have %s, want %s", progress.Stage, PruneStageStopped)
+	}
+	path, root, err := findBloomFilter(datadir)
+	if err != nil || path == "" {
+		t.Fatalf("state bloom not found: %v", err)
+	}
+	if root != chain.head().Root {
+		t.Fatalf("state bloom root mismatch: have %x, want head %x", root, chain.head().Root)
+	}
+	// A new pruning is refused until the unfinished one is recovered
+	if err := chain.newPruner(t, datadir).Start(chain.head()); err == nil {
+		t.Fatalf("pruning started over unfinished one")
+	}
+	// Restart from the new head and resume the pruning
+	head := types.NewBlock(chain.head(), nil, nil, trie.NewStackTrie(nil))
+	rawdb.WriteBlock(chain.db, head)
+	rawdb.WriteCanonicalHash(chain.db, head.Hash(), head.NumberU64())
+	rawdb.WriteHeadBlockHash(chain.db, head.Hash())
+
+	if err := RecoverPruning(datadir, chain.db, ""); err != nil {
+		t.Fatalf("failed to recover pruning: %v", err)
+	}
+	if path, _, _ := findBloomFilter(datadir); path != "" {
+		t.Fatalf("state bloom left behind: %s", path)
+	}
+	checkPruned(t, chain, target)
+}
+
+// Tests that a state which can't be journaled aborts the pruning, dropping its
+// bloom filter so that it isn't resumed on restart without covering that state.
+func TestOnlinePruningJournalFailure(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary datadir: %v", err)
+	}
+	defer os.RemoveAll(datadir)
+
+	chain := newPruneTestChain(t, 200, 40)
+	chain.extend(t, 300, nil)
+
+	p := chain.newPruner(t, datadir)
+	if err := p.Start(chain.head()); err != nil {
+		t.Fatalf("failed to start pruning: %v", err)
+	}
+	waitSweep(t, p)
+
+	if err := p.Journal(common.Hash{0x01}, common.Hash{0x02}); err == nil {
+		t.Fatalf("journaled state with missing parent")
+	}
+	if p.Running() {
+		t.Fatalf("pruning still running after failed journaling")
+	}
+	if progress := p.Progress(); progress.Stage != PruneStageFailed || progress.Error == "" {
+		t.Fatalf("failure not reported: %+v", progress)
+	}
+	if path, _, _ := findBloomFilter(datadir); path != "" {
+		t.Fatalf("state bloom left behind: %s", path)
+	}
+	// The states committed afterwards are not affected
+	chain.extend(t, 2, p)
+	for number := len(chain.roots) - 2; number < len(chain.roots); number++ {
+		if err := checkState(chain.db, chain.roots[number]); err != nil {
+			t.Fatalf("block %d state damaged: %v", number, err)
+		}
+	}
+}
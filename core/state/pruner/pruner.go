@@ -62,6 +62,10 @@ var (
 
 	// emptyCode is the known hash of the empty EVM bytecode.
 	emptyCode = crypto.Keccak256(nil)
+
+	// ErrPathScheme is returned if pruning is requested on a database using the
+	// path-based state scheme, which never accumulates stale state.
+	ErrPathScheme = errors.New("state pruning is not needed by the path-based state scheme")
 )
 
 // Pruner is an offline tool to prune the stale state with the
@@ -86,6 +90,9 @@ type Pruner struct {
 
 // NewPruner creates the pruner instance.
 func NewPruner(db czzdb.Database, datadir, trieCachePath string, bloomSize uint64) (*Pruner, error) {
+	if rawdb.ReadStateScheme(db) == rawdb.PathScheme {
+		return nil, ErrPathScheme
+	}
 	headBlock := rawdb.ReadHeadBlock(db)
 	if headBlock == nil {
 		return nil, errors.New("Failed to load head block")
@@ -187,17 +194,25 @@ func prune(snaptree *snapshot.Tree, root common.Hash, maindb czzdb.Database, sta
 	log.Info("Pruned state data", "nodes", count, "size", size, "elapsed", common.PrettyDuration(time.Since(pstart)))
 
 	// Pruning is done, now drop the "useless" layers from the snapshot.
-	// Firstly, flushing the target layer into the disk. After that all
-	// diff layers below the target will all be merged into the disk.
-	if err := snaptree.Cap(root, 0); err != nil {
-		return err
-	}
-	// Secondly, flushing the snapshot journal into the disk. All diff
-	// layers upon are dropped silently. Eventually the entire snapshot
-	// tree is converted into a single disk layer with the pruning target
-	// as the root.
-	if _, err := snaptree.Journal(root); err != nil {
-		return err
+	// If the target is not tracked by the snapshot(online pruning resumed
+	// after the chain progressed), drop the snapshot root instead. The chain
+	// is then rewound onto the most recent available state and the snapshot
+	// rebuilt from there.
+	if snaptree == nil {
+		rawdb.DeleteSnapshotRoot(maindb)
+	} else {
+		// Firstly, flushing the target layer into the disk. After that all
+		// diff layers below the target will all be merged into the disk.
+		if err := snaptree.Cap(root, 0); err != nil {
+			return err
+		}
+		// Secondly, flushing the snapshot journal into the disk. All diff
+		// layers upon are dropped silently. Eventually the entire snapshot
+		// tree is converted into a single disk layer with the pruning target
+		// as the root.
+		if _, err := snaptree.Journal(root); err != nil {
+			return err
+		}
 	}
 	// Delete the state bloom, it marks the entire pruning procedure is
 	// finished. If any crashes or manual exit happens before this,
@@ -363,9 +378,14 @@ func RecoverPruning(datadir string, db czzdb.Database, trieCachePath string) err
 	// - The state HEAD is rewound already because of multiple incomplete `prune-state`
 	// In this case, even the state HEAD is not exactly matched with snapshot, it
 	// still feasible to recover the pruning correctly.
+	//
+	// An online pruning might have been interrupted after the chain progressed
+	// well beyond the target, or without the snapshot journal being persisted.
+	// Since the bloom filter is complete, the pruning is resumed anyway.
 	snaptree, err := snapshot.New(db, trie.NewDatabase(db), 256, headBlock.Root(), false, false, true)
 	if err != nil {
-		return err // The relevant snapshot(s) might not exist
+		log.Warn("Snapshot unavailable for pruning recovery", "err", err)
+		snaptree = nil
 	}
 	stateBloom, err := NewStateBloomFromDisk(stateBloomPath)
 	if err != nil {
@@ -383,9 +403,12 @@ func RecoverPruning(datadir string, db czzdb.Database, trieCachePath string) err
 	// otherwise the dangling state will be left.
 	var (
 		found       bool
-		layers      = snaptree.Snapshots(headBlock.Root(), 128, true)
+		layers      []snapshot.Snapshot
 		middleRoots = make(map[common.Hash]struct{})
 	)
+	if snaptree != nil {
+		layers = snaptree.Snapshots(headBlock.Root(), 128, true)
+	}
 	for _, layer := range layers {
 		if layer.Root() == stateBloomRoot {
 			found = true
@@ -394,8 +417,11 @@ func RecoverPruning(datadir string, db czzdb.Database, trieCachePath string) err
 		middleRoots[layer.Root()] = struct{}{}
 	}
 	if !found {
-		log.Error("Pruning target state is not existent")
-		return errors.New("non-existent target state")
+		// The snapshot layers are not below the target, a part of them was
+		// journaled into the bloom filter by the online pruning. Keep them
+		// and let the chain rewind onto the most recent complete state.
+		log.Warn("Pruning target state is not in the snapshot, resuming without it", "root", stateBloomRoot)
+		snaptree, middleRoots = nil, make(map[common.Hash]struct{})
 	}
 	return prune(snaptree, stateBloomRoot, db, stateBloom, stateBloomPath, middleRoots, time.Now())
 }
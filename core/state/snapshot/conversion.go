@@ -64,6 +64,12 @@ func GenerateStorageTrieRoot(account common.Hash, it StorageIterator) (common.Ha
 // accounts as well as the corresponding storages and regenerate the whole state
 // (account trie + all storage tries).
 func GenerateTrie(snaptree *Tree, root common.Hash, src czzdb.Database, dst czzdb.KeyValueWriter) error {
+	return GenerateTrieWithAbort(snaptree, root, src, dst, nil)
+}
+
+// GenerateTrieWithAbort is the interruptible version of GenerateTrie, the
+// generation is aborted as soon as the given channel is closed.
+func GenerateTrieWithAbort(snaptree *Tree, root common.Hash, src czzdb.Database, dst czzdb.KeyValueWriter, abort chan struct{}) error {
 	// Traverse all state by snapshot, re-generate the whole state trie
 	acctIt, err := snaptree.AccountIterator(root, common.Hash{})
 	if err != nil {
@@ -72,6 +78,11 @@ func GenerateTrie(snaptree *Tree, root common.Hash, src czzdb.Database, dst czzd
 	defer acctIt.Release()
 
 	got, err := generateTrieRoot(dst, acctIt, common.Hash{}, stackTrieGenerate, func(dst czzdb.KeyValueWriter, accountHash, codeHash common.Hash, stat *generateStats) (common.Hash, error) {
+		select {
+		case <-abort:
+			return common.Hash{}, errors.New("aborted")
+		default:
+		}
 		// Migrate the code first, commit the contract code into the tmp db.
 		if codeHash != emptyCode {
 			code := rawdb.ReadCode(src, codeHash)
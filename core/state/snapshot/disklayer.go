@@ -41,6 +41,9 @@ type diskLayer struct {
 	genPending chan struct{}             // Notification channel when generation is done (test synchronicity)
 	genAbort   chan chan *generatorStats // Notification channel to abort generating the snapshot in this layer
 
+	genStats    *generatorStats // Copy of the generator statistics, also used to continue a paused generation
+	genPosition []byte          // Last position reported by the generator, ahead of the flushed marker
+
 	lock sync.RWMutex
 }
 
@@ -0,0 +1,632 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/rlp"
+	"github.com/classzz/go-classzz-v2/trie"
+)
+
+// exportVersion is the version number of the state export format.
+const exportVersion = 1
+
+// exportMagic is the prefix of every state export file.
+var exportMagic = []byte("czzstate")
+
+// Entry kinds of a state export. The accounts are exported in hash order, each
+// followed by its code and its storage slots, again in hash order.
+const (
+	exportAccount uint8 = iota // Slim account RLP keyed by the account hash
+	exportCode                 // Contract code keyed by its hash
+	exportSlot                 // Storage slot keyed by the slot hash
+	exportBlob                 // Storage trie value missing from the snapshot
+	exportEnd                  // Terminator, carrying the position to continue from
+)
+
+var (
+	// ErrExportTruncated is returned if a state export ends without its
+	// terminator, e.g. because the export was interrupted.
+	ErrExportTruncated = errors.New("state export truncated")
+
+	// maxHash is the last possible account hash.
+	maxHash = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+)
+
+// ExportHeader is the first entry of a state export, describing its content.
+type ExportHeader struct {
+	Version uint64
+	Root    common.Hash // State root the accounts belong to
+	Start   common.Hash // First account hash of the exported range
+	Limit   common.Hash // Last account hash of the exported range (inclusive)
+	Code    bool        // Whether the contract codes are included
+	Storage bool        // Whether the storage slots are included
+}
+
+// exportEntry is a single account, code or storage item of a state export.
+type exportEntry struct {
+	Kind uint8
+	Hash common.Hash
+	Data []byte
+}
+
+// exportTerminator is the payload of the last entry of a state export.
+type exportTerminator struct {
+	Next common.Hash // First account hash not covered by the export
+	Done bool        // Whether the export covers the state up to the last hash
+}
+
+// ExportConfig selects the part of the state to export.
+type ExportConfig struct {
+	Start   common.Hash // First account hash to export
+	Limit   common.Hash // Last account hash to export, zero for no limit
+	Max     uint64      // Maximum number of accounts to export, zero for no limit
+	Code    bool        // Whether to include the contract codes
+	Storage bool        // Whether to include the storage slots
+
+	// TrieStorage lists the accounts whose storage is read from the storage trie
+	// instead of the snapshot. The snapshot only tracks the regular storage slots,
+	// the values written outside of them (e.g. the TeWaka state) are exported as
+	// blobs. The export fails on any other account holding such values.
+	TrieStorage map[common.Hash]bool
+
+	// Resume continues an interrupted export from Start, without writing the
+	// header again.
+	Resume bool
+}
+
+// ExportStats contains the result of a state export.
+type ExportStats struct {
+	Accounts uint64      `json:"accounts"`
+	Codes    uint64      `json:"codes"`
+	Slots    uint64      `json:"slots"`
+	Blobs    uint64      `json:"blobs"`
+	Next     common.Hash `json:"next"` // First account hash not covered by the export
+	Done     bool        `json:"done"` // Whether the export covers the state up to the last hash
+}
+
+// exportWriter encodes the entries of a state export.
+type exportWriter struct {
+	w *bufio.Writer
+}
+
+// write encodes a single entry into the export.
+func (w *exportWriter) write(kind uint8, hash common.Hash, data []byte) error {
+	blob, err := rlp.EncodeToBytes(&exportEntry{Kind: kind, Hash: hash, Data: data})
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(blob)
+	return err
+}
+
+// ExportState streams the accounts of the given state root, along with their
+// codes and storage if requested, from the snapshot into a compact binary export.
+// The export of a range can be split into multiple files by limiting the number
+// of accounts, the returned stats contain the hash to continue from.
+func ExportState(w io.Writer, snaptree *Tree, triedb *trie.Database, root common.Hash, config *ExportConfig) (*ExportStats, error) {
+	limit := config.Limit
+	if limit == (common.Hash{}) {
+		limit = maxHash
+	}
+	out := &exportWriter{w: bufio.NewWriter(w)}
+	if !config.Resume {
+		header, err := rlp.EncodeToBytes(&ExportHeader{
+			Version: exportVersion,
+			Root:    root,
+			Start:   config.Start,
+			Limit:   limit,
+			Code:    config.Code,
+			Storage: config.Storage,
+		})
+		if err != nil {
+			return nil, err
+		}
+		out.w.Write(exportMagic)
+		out.w.Write(header)
+	}
+	accIt, err := snaptree.AccountIterator(root, config.Start)
+	if err != nil {
+		return nil, err
+	}
+	defer accIt.Release()
+
+	var (
+		stats  = new(ExportStats)
+		start  = time.Now()
+		logged = time.Now()
+		next   []byte
+	)
+	for {
+		if !accIt.Next() {
+			if err := accIt.Error(); err != nil {
+				return nil, err
+			}
+			next = increaseKey(common.CopyBytes(limit[:]))
+			break
+		}
+		hash := accIt.Hash()
+		if bytes.Compare(hash[:], limit[:]) > 0 {
+			next = increaseKey(common.CopyBytes(limit[:]))
+			break
+		}
+		if config.Max > 0 && stats.Accounts >= config.Max {
+			next = hash[:]
+			break
+		}
+		if err := exportAccountData(out, snaptree, triedb, root, hash, accIt.Account(), config, stats); err != nil {
+			return nil, err
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting state", "at", hash, "accounts", stats.Accounts, "slots", stats.Slots,
+				"elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	// Terminate the export with the position the next range starts at
+	if next == nil {
+		stats.Done = true
+	} else {
+		stats.Next = common.BytesToHash(next)
+	}
+	end, err := rlp.EncodeToBytes(&exportTerminator{Next: stats.Next, Done: stats.Done})
+	if err != nil {
+		return nil, err
+	}
+	if err := out.write(exportEnd, common.Hash{}, end); err != nil {
+		return nil, err
+	}
+	if err := out.w.Flush(); err != nil {
+		return nil, err
+	}
+	log.Info("Exported state", "accounts", stats.Accounts, "codes", stats.Codes, "slots", stats.Slots,
+		"blobs", stats.Blobs, "next", stats.Next, "done", stats.Done, "elapsed", common.PrettyDuration(time.Since(start)))
+	return stats, nil
+}
+
+// exportAccountData writes an account along with its code and storage into the
+// export.
+func exportAccountData(out *exportWriter, snaptree *Tree, triedb *trie.Database, root common.Hash, hash common.Hash, data []byte, config *ExportConfig, stats *ExportStats) error {
+	account, err := FullAccount(data)
+	if err != nil {
+		return err
+	}
+	if err := out.write(exportAccount, hash, data); err != nil {
+		return err
+	}
+	stats.Accounts++
+
+	if codeHash := common.BytesToHash(account.CodeHash); config.Code && codeHash != emptyCode {
+		code := rawdb.ReadCode(triedb.DiskDB(), codeHash)
+		if len(code) == 0 {
+			return fmt.Errorf("code %x of account %x missing", codeHash, hash)
+		}
+		if err := out.write(exportCode, codeHash, code); err != nil {
+			return err
+		}
+		stats.Codes++
+	}
+	if !config.Storage || common.BytesToHash(account.Root) == emptyRoot {
+		return nil
+	}
+	stIt, err := snaptree.StorageIterator(root, hash, common.Hash{})
+	if err != nil {
+		return err
+	}
+	defer stIt.Release()
+
+	if !config.TrieStorage[hash] {
+		// Hash the slots along the way, so a storage holding values outside the
+		// snapshot is refused instead of producing an export which can't be
+		// imported
+		stTr := trie.NewStackTrie(nil)
+		for stIt.Next() {
+			if err := out.write(exportSlot, stIt.Hash(), stIt.Slot()); err != nil {
+				return err
+			}
+			if err := stTr.TryUpdate(stIt.Hash().Bytes(), stIt.Slot()); err != nil {
+				return err
+			}
+			stats.Slots++
+		}
+		if err := stIt.Error(); err != nil {
+			return err
+		}
+		if have, want := stTr.Hash(), common.BytesToHash(account.Root); have != want {
+			return fmt.Errorf("storage of account %x not covered by the snapshot (root %x, want %x), it needs to be read from the trie", hash, have, want)
+		}
+		return nil
+	}
+	// Walk the storage trie, marking the values the snapshot doesn't know about
+	tr, err := trie.NewSecureWithOwner(hash, common.BytesToHash(account.Root), triedb)
+	if err != nil {
+		return err
+	}
+	var (
+		it    = trie.NewIterator(tr.NodeIterator(nil))
+		valid = stIt.Next()
+	)
+	for it.Next() {
+		for valid && bytes.Compare(stIt.Hash().Bytes(), it.Key) < 0 {
+			valid = stIt.Next()
+		}
+		kind := exportBlob
+		if valid && bytes.Equal(stIt.Hash().Bytes(), it.Key) && bytes.Equal(stIt.Slot(), it.Value) {
+			kind = exportSlot
+		}
+		if err := out.write(kind, common.BytesToHash(it.Key), it.Value); err != nil {
+			return err
+		}
+		if kind == exportSlot {
+			stats.Slots++
+		} else {
+			stats.Blobs++
+		}
+	}
+	if it.Err != nil {
+		return it.Err
+	}
+	return stIt.Error()
+}
+
+// countingReader tracks the position of the export decoder, without buffering
+// beyond it.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+// exportReader decodes the entries of a state export.
+type exportReader struct {
+	r      *countingReader
+	stream *rlp.Stream
+	header *ExportHeader
+}
+
+// newExportReader checks the magic and decodes the header of a state export.
+func newExportReader(r io.Reader) (*exportReader, error) {
+	cr := &countingReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(cr, magic); err != nil || !bytes.Equal(magic, exportMagic) {
+		return nil, errors.New("not a state export")
+	}
+	stream := rlp.NewStream(cr, 0)
+	header := new(ExportHeader)
+	if err := stream.Decode(header); err != nil {
+		return nil, fmt.Errorf("invalid state export header: %v", err)
+	}
+	if header.Version != exportVersion {
+		return nil, fmt.Errorf("unsupported state export version %d", header.Version)
+	}
+	return &exportReader{r: cr, stream: stream, header: header}, nil
+}
+
+// next decodes the next entry of the export, returning ErrExportTruncated if the
+// data ends before the terminator.
+func (r *exportReader) next() (*exportEntry, error) {
+	entry := new(exportEntry)
+	if err := r.stream.Decode(entry); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == rlp.ErrValueTooLarge {
+			return nil, ErrExportTruncated
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ExportProgress describes how far a possibly interrupted state export got.
+type ExportProgress struct {
+	Header   *ExportHeader
+	Complete bool        // Whether the export has its terminator
+	Next     common.Hash // Account hash to continue an interrupted export from
+	Offset   int64       // Length of the export to keep when continuing
+	Done     bool        // Whether a complete export covers the state up to the last hash
+}
+
+// ScanExport reads through a state export and reports whether it is complete.
+// An interrupted export can be continued by truncating it to the returned offset
+// and exporting again from the returned account, with Resume set.
+func ScanExport(r io.Reader) (*ExportProgress, error) {
+	er, err := newExportReader(r)
+	if err != nil {
+		return nil, err
+	}
+	progress := &ExportProgress{Header: er.header, Next: er.header.Start, Offset: er.r.n}
+	for {
+		offset := er.r.n
+		entry, err := er.next()
+		if err == ErrExportTruncated {
+			return progress, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch entry.Kind {
+		case exportAccount:
+			// Anything after the last account start may be incomplete
+			progress.Next, progress.Offset = entry.Hash, offset
+		case exportEnd:
+			var end exportTerminator
+			if err := rlp.DecodeBytes(entry.Data, &end); err != nil {
+				return nil, err
+			}
+			progress.Complete, progress.Next, progress.Done, progress.Offset = true, end.Next, end.Done, er.r.n
+			return progress, nil
+		}
+	}
+}
+
+// StateImporter rebuilds the state tries from a sequence of state exports which
+// together cover the entire account range.
+type StateImporter struct {
+	db    czzdb.KeyValueStore
+	batch czzdb.Batch
+
+	header *ExportHeader   // Header of the first export, defining the state
+	next   common.Hash     // Account hash the next export must start at
+	done   bool            // Whether the entire account range is imported
+	last   *common.Hash    // Last imported account, to check the ordering
+	accTr  *trie.StackTrie // Account trie being rebuilt
+
+	account   *Account        // Account whose storage is being imported
+	stTr      *trie.StackTrie // Storage trie of the current account
+	lastSlot  *common.Hash    // Last imported slot of the current account
+	codeFound bool            // Whether the code of the current account was imported
+
+	stats ExportStats
+	start time.Time
+}
+
+// NewStateImporter creates a state importer writing the tries and codes into the
+// given database. The tries are persisted in the hash-based scheme.
+func NewStateImporter(db czzdb.KeyValueStore) (*StateImporter, error) {
+	if rawdb.ReadStateScheme(db) == rawdb.PathScheme {
+		return nil, errors.New("state import is not supported by the path-based state scheme")
+	}
+	batch := db.NewBatch()
+	return &StateImporter{
+		db:    db,
+		batch: batch,
+		accTr: trie.NewStackTrie(batch),
+		start: time.Now(),
+	}, nil
+}
+
+// Import rebuilds the state contained in a single export, which has to continue
+// where the previous one left off.
+func (imp *StateImporter) Import(r io.Reader) error {
+	er, err := newExportReader(r)
+	if err != nil {
+		return err
+	}
+	header := er.header
+	if imp.header == nil {
+		if header.Start != (common.Hash{}) {
+			return fmt.Errorf("state export starts at %x, want the first account", header.Start)
+		}
+		imp.header = header
+	} else {
+		switch {
+		case imp.done:
+			return errors.New("state already imported")
+		case header.Root != imp.header.Root:
+			return fmt.Errorf("state root mismatch: have %x, want %x", header.Root, imp.header.Root)
+		case header.Code != imp.header.Code || header.Storage != imp.header.Storage:
+			return errors.New("state export content mismatch")
+		case header.Start != imp.next:
+			return fmt.Errorf("state export starts at %x, want %x", header.Start, imp.next)
+		}
+	}
+	logged := time.Now()
+	for {
+		entry, err := er.next()
+		if err != nil {
+			return err
+		}
+		switch entry.Kind {
+		case exportAccount:
+			if bytes.Compare(entry.Hash[:], header.Start[:]) < 0 || bytes.Compare(entry.Hash[:], header.Limit[:]) > 0 {
+				return fmt.Errorf("account %x outside of exported range", entry.Hash)
+			}
+			if imp.last != nil && bytes.Compare(entry.Hash[:], imp.last[:]) <= 0 {
+				return fmt.Errorf("account %x out of order", entry.Hash)
+			}
+			if err := imp.finishAccount(); err != nil {
+				return err
+			}
+			if err := imp.importAccount(entry.Hash, entry.Data); err != nil {
+				return err
+			}
+
+		case exportCode:
+			if err := imp.importCode(entry.Hash, entry.Data); err != nil {
+				return err
+			}
+
+		case exportSlot, exportBlob:
+			if err := imp.importSlot(entry.Hash, entry.Data); err != nil {
+				return err
+			}
+			if entry.Kind == exportSlot {
+				imp.stats.Slots++
+			} else {
+				imp.stats.Blobs++
+			}
+
+		case exportEnd:
+			var end exportTerminator
+			if err := rlp.DecodeBytes(entry.Data, &end); err != nil {
+				return err
+			}
+			if err := imp.finishAccount(); err != nil {
+				return err
+			}
+			imp.next, imp.done = end.Next, end.Done
+			return imp.flush(true)
+
+		default:
+			return fmt.Errorf("unknown state export entry %d", entry.Kind)
+		}
+		if err := imp.flush(false); err != nil {
+			return err
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Importing state", "at", imp.last, "accounts", imp.stats.Accounts, "slots", imp.stats.Slots,
+				"elapsed", common.PrettyDuration(time.Since(imp.start)))
+			logged = time.Now()
+		}
+	}
+}
+
+// importAccount inserts an account into the account trie.
+func (imp *StateImporter) importAccount(hash common.Hash, data []byte) error {
+	account, err := FullAccount(data)
+	if err != nil {
+		return fmt.Errorf("invalid account %x: %v", hash, err)
+	}
+	full, err := rlp.EncodeToBytes(account)
+	if err != nil {
+		return err
+	}
+	if err := imp.accTr.TryUpdate(hash[:], full); err != nil {
+		return err
+	}
+	imp.last, imp.account, imp.codeFound = &hash, &account, false
+	imp.stats.Accounts++
+	return nil
+}
+
+// importCode stores the code of the current account.
+func (imp *StateImporter) importCode(hash common.Hash, code []byte) error {
+	switch {
+	case imp.account == nil || common.BytesToHash(imp.account.CodeHash) != hash:
+		return fmt.Errorf("code %x doesn't belong to account %x", hash, imp.last)
+	case crypto.Keccak256Hash(code) != hash:
+		return fmt.Errorf("code %x doesn't match its hash", hash)
+	}
+	rawdb.WriteCode(imp.batch, hash, code)
+	imp.codeFound = true
+	imp.stats.Codes++
+	return nil
+}
+
+// importSlot inserts a storage value into the storage trie of the current account.
+func (imp *StateImporter) importSlot(hash common.Hash, value []byte) error {
+	if imp.account == nil || !imp.header.Storage {
+		return fmt.Errorf("unexpected storage slot %x", hash)
+	}
+	if imp.lastSlot != nil && bytes.Compare(hash[:], imp.lastSlot[:]) <= 0 {
+		return fmt.Errorf("slot %x of account %x out of order", hash, imp.last)
+	}
+	if imp.stTr == nil {
+		imp.stTr = trie.NewStackTrie(imp.batch)
+	}
+	imp.lastSlot = &hash
+	return imp.stTr.TryUpdate(hash[:], value)
+}
+
+// finishAccount checks the code and the storage root of the current account.
+func (imp *StateImporter) finishAccount() error {
+	if imp.account == nil {
+		return nil
+	}
+	account, stTr := imp.account, imp.stTr
+	imp.account, imp.stTr, imp.lastSlot = nil, nil, nil
+
+	if imp.header.Code && !imp.codeFound && common.BytesToHash(account.CodeHash) != emptyCode {
+		return fmt.Errorf("code of account %x missing", imp.last)
+	}
+	if !imp.header.Storage {
+		return nil
+	}
+	root := emptyRoot
+	if stTr != nil {
+		var err error
+		if root, err = stTr.Commit(); err != nil {
+			return err
+		}
+	}
+	if want := common.BytesToHash(account.Root); root != want {
+		return fmt.Errorf("storage root mismatch for account %x: have %x, want %x", imp.last, root, want)
+	}
+	return nil
+}
+
+// flush writes the accumulated trie nodes and codes into the database if there
+// are enough of them, or if forced.
+func (imp *StateImporter) flush(force bool) error {
+	if force || imp.batch.ValueSize() > czzdb.IdealBatchSize {
+		if err := imp.batch.Write(); err != nil {
+			return err
+		}
+		imp.batch.Reset()
+	}
+	return nil
+}
+
+// Commit verifies that the entire account range was imported and that the
+// rebuilt account trie matches the exported state root.
+func (imp *StateImporter) Commit() (common.Hash, error) {
+	if imp.header == nil || !imp.done {
+		return common.Hash{}, fmt.Errorf("state import incomplete, continue from %x", imp.next)
+	}
+	root, err := imp.accTr.Commit()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := imp.flush(true); err != nil {
+		return common.Hash{}, err
+	}
+	if root != imp.header.Root {
+		return common.Hash{}, fmt.Errorf("state root mismatch: have %x, want %x", root, imp.header.Root)
+	}
+	log.Info("Imported state", "root", root, "accounts", imp.stats.Accounts, "codes", imp.stats.Codes,
+		"slots", imp.stats.Slots, "blobs", imp.stats.Blobs, "elapsed", common.PrettyDuration(time.Since(imp.start)))
+	return root, nil
+}
+
+// Stats returns the number of items imported so far.
+func (imp *StateImporter) Stats() ExportStats {
+	stats := imp.stats
+	stats.Next, stats.Done = imp.next, imp.done
+	return stats
+}
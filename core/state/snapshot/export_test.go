@@ -0,0 +1,239 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/czzdb/memorydb"
+	"github.com/classzz/go-classzz-v2/rlp"
+	"github.com/classzz/go-classzz-v2/trie"
+)
+
+// makeExportState creates a state of accounts with code and storage, one of
+// which has a storage value the snapshot doesn't know about.
+func makeExportState() (*Tree, *trie.Database, common.Hash, common.Hash) {
+	var (
+		diskdb    = memorydb.New()
+		triedb    = trie.NewDatabase(diskdb)
+		accTrie   = newEmptySecure(triedb)
+		blobOwner common.Hash
+	)
+	for i := 0; i < 20; i++ {
+		var (
+			key = []byte(fmt.Sprintf("acc-%d", i))
+			acc = &Account{Balance: big.NewInt(int64(i)), Root: emptyRoot.Bytes(), CodeHash: emptyCode.Bytes()}
+		)
+		if i%2 == 1 {
+			code := []byte(fmt.Sprintf("code-%d", i))
+			acc.CodeHash = crypto.Keccak256(code)
+			rawdb.WriteCode(diskdb, common.BytesToHash(acc.CodeHash), code)
+		}
+		if i%3 == 0 {
+			stTrie := newEmptySecure(triedb)
+			for j := 0; j < i+1; j++ {
+				slot, _ := rlp.EncodeToBytes([]byte(fmt.Sprintf("val-%d", j)))
+				stTrie.Update([]byte(fmt.Sprintf("key-%d", j)), slot)
+				rawdb.WriteStorageSnapshot(diskdb, hashData(key), hashData([]byte(fmt.Sprintf("key-%d", j))), slot)
+			}
+			if i == 9 {
+				stTrie.Update([]byte("blob"), []byte("blob-value"))
+				blobOwner = hashData(key)
+			}
+			root, _ := stTrie.Commit(nil)
+			triedb.Commit(root, false, nil)
+			acc.Root = root.Bytes()
+		}
+		val, _ := rlp.EncodeToBytes(acc)
+		accTrie.Update(key, val)
+
+		slim, _ := rlp.EncodeToBytes(SlimAccount(acc.Nonce, acc.Balance, common.BytesToHash(acc.Root), acc.CodeHash))
+		rawdb.WriteAccountSnapshot(diskdb, hashData(key), slim)
+	}
+	root, _ := accTrie.Commit(nil)
+	triedb.Commit(root, false, nil)
+	rawdb.WriteSnapshotRoot(diskdb, root)
+
+	snaps := &Tree{
+		layers: map[common.Hash]snapshot{
+			root: &diskLayer{
+				diskdb: diskdb,
+				cache:  fastcache.New(500 * 1024),
+				root:   root,
+			},
+		},
+	}
+	return snaps, triedb, root, blobOwner
+}
+
+// newEmptySecure creates an empty secure trie backed by the given database.
+func newEmptySecure(triedb *trie.Database) *trie.SecureTrie {
+	tr, _ := trie.NewSecure(common.Hash{}, triedb)
+	return tr
+}
+
+// importExports rebuilds the state from the given exports in a fresh database.
+func importExports(exports ...[]byte) (czzdb.KeyValueStore, common.Hash, error) {
+	db := memorydb.New()
+	imp, err := NewStateImporter(db)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	for _, export := range exports {
+		if err := imp.Import(bytes.NewReader(export)); err != nil {
+			return nil, common.Hash{}, err
+		}
+	}
+	root, err := imp.Commit()
+	return db, root, err
+}
+
+// Tests that the state can be exported from the snapshot and rebuilt from the
+// export, including the storage values missing from the snapshot.
+func TestExportImportState(t *testing.T) {
+	snaps, triedb, root, blobOwner := makeExportState()
+
+	config := &ExportConfig{Code: true, Storage: true, TrieStorage: map[common.Hash]bool{blobOwner: true}}
+	var export bytes.Buffer
+	stats, err := ExportState(&export, snaps, triedb, root, config)
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	if stats.Accounts != 20 || stats.Codes != 10 || stats.Blobs != 1 || !stats.Done {
+		t.Fatalf("export stats mismatch: %+v", stats)
+	}
+	db, have, err := importExports(export.Bytes())
+	if err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+	if have != root {
+		t.Fatalf("state root mismatch: have %x, want %x", have, root)
+	}
+	// Check that the rebuilt state is complete
+	accTrie, err := trie.NewSecure(root, trie.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("failed to open imported state: %v", err)
+	}
+	data, _ := accTrie.TryGet([]byte("acc-9"))
+	var account Account
+	if err := rlp.DecodeBytes(data, &account); err != nil {
+		t.Fatalf("failed to decode imported account: %v", err)
+	}
+	stTrie, err := trie.NewSecure(common.BytesToHash(account.Root), trie.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("failed to open imported storage: %v", err)
+	}
+	if blob, _ := stTrie.TryGet([]byte("blob")); string(blob) != "blob-value" {
+		t.Fatalf("storage blob mismatch: have %q, want %q", blob, "blob-value")
+	}
+	if code := rawdb.ReadCode(db, common.BytesToHash(account.CodeHash)); string(code) != "code-9" {
+		t.Fatalf("code mismatch: have %q, want %q", code, "code-9")
+	}
+	// Without reading the storage trie the blob would be lost, the export must
+	// be refused rather than produce a state which can't be imported
+	export.Reset()
+	if _, err := ExportState(&export, snaps, triedb, root, &ExportConfig{Code: true, Storage: true}); err == nil {
+		t.Fatalf("export succeeded without storage blob")
+	}
+	// Exporting only the accounts, the blob doesn't matter
+	export.Reset()
+	if _, err := ExportState(&export, snaps, triedb, root, &ExportConfig{Code: true}); err != nil {
+		t.Fatalf("failed to export accounts: %v", err)
+	}
+}
+
+// Tests that the state can be exported in multiple ranges, which need to be
+// imported in order.
+func TestExportStateRanges(t *testing.T) {
+	snaps, triedb, root, _ := makeExportState()
+
+	var (
+		exports [][]byte
+		config  = &ExportConfig{Max: 7}
+	)
+	for {
+		var export bytes.Buffer
+		stats, err := ExportState(&export, snaps, triedb, root, config)
+		if err != nil {
+			t.Fatalf("failed to export state range: %v", err)
+		}
+		exports = append(exports, export.Bytes())
+		if stats.Done {
+			break
+		}
+		config.Start = stats.Next
+	}
+	if len(exports) != 3 {
+		t.Fatalf("export ranges mismatch: have %d, want 3", len(exports))
+	}
+	if _, _, err := importExports(exports[0], exports[2]); err == nil {
+		t.Fatalf("import succeeded with range gap")
+	}
+	if _, _, err := importExports(exports[:2]...); err == nil {
+		t.Fatalf("import succeeded with missing range")
+	}
+	if _, have, err := importExports(exports...); err != nil || have != root {
+		t.Fatalf("failed to import state ranges: root %x, err %v", have, err)
+	}
+}
+
+// Tests that an interrupted export can be continued.
+func TestExportStateResume(t *testing.T) {
+	snaps, triedb, root, blobOwner := makeExportState()
+
+	config := &ExportConfig{Code: true, Storage: true, TrieStorage: map[common.Hash]bool{blobOwner: true}}
+	var export bytes.Buffer
+	if _, err := ExportState(&export, snaps, triedb, root, config); err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	full := common.CopyBytes(export.Bytes())
+
+	for _, cut := range []int{len(full) / 5, len(full) / 3, len(full) / 2, len(full) - 1} {
+		partial := common.CopyBytes(full[:cut])
+		if _, _, err := importExports(partial); err != ErrExportTruncated {
+			t.Fatalf("cut %d: truncated import error mismatch: have %v, want %v", cut, err, ErrExportTruncated)
+		}
+		progress, err := ScanExport(bytes.NewReader(partial))
+		if err != nil {
+			t.Fatalf("cut %d: failed to scan export: %v", cut, err)
+		}
+		if progress.Complete {
+			t.Fatalf("cut %d: truncated export reported complete", cut)
+		}
+		resumed := bytes.NewBuffer(partial[:progress.Offset])
+		resume := *config
+		resume.Start, resume.Resume = progress.Next, true
+		if _, err := ExportState(resumed, snaps, triedb, root, &resume); err != nil {
+			t.Fatalf("cut %d: failed to resume export: %v", cut, err)
+		}
+		if !bytes.Equal(resumed.Bytes(), full) {
+			t.Fatalf("cut %d: resumed export mismatch", cut)
+		}
+	}
+	progress, err := ScanExport(bytes.NewReader(full))
+	if err != nil || !progress.Complete || !progress.Done {
+		t.Fatalf("complete export scan mismatch: %+v, err %v", progress, err)
+	}
+}
@@ -91,8 +91,21 @@ var (
 	snapStorageSnapReadCounter = metrics.NewRegisteredCounter("state/snapshot/generation/duration/storage/snapread", nil)
 	// snapStorageWriteCounter measures time spent on writing/updating/deleting storages
 	snapStorageWriteCounter = metrics.NewRegisteredCounter("state/snapshot/generation/duration/storage/write", nil)
+
+	// snapProgressAccountGauge tracks the number of accounts indexed by the generator
+	snapProgressAccountGauge = metrics.NewRegisteredGauge("state/snapshot/generation/progress/accounts", nil)
+	// snapProgressStorageGauge tracks the number of storage slots indexed by the generator
+	snapProgressStorageGauge = metrics.NewRegisteredGauge("state/snapshot/generation/progress/slots", nil)
+	// snapProgressDanglingGauge tracks the number of dangling storage slots wiped by the generator
+	snapProgressDanglingGauge = metrics.NewRegisteredGauge("state/snapshot/generation/progress/dangling", nil)
+	// snapProgressETAGauge tracks the estimated seconds left until the generation completes
+	snapProgressETAGauge = metrics.NewRegisteredGauge("state/snapshot/generation/progress/eta", nil)
 )
 
+// generatorReportInterval is the time interval between the updates of the
+// generator progress exposed to the status queries and metrics.
+const generatorReportInterval = time.Second
+
 // generatorStats is a collection of statistics gathered by the snapshot generator
 // for logging purposes.
 type generatorStats struct {
@@ -101,6 +114,7 @@ type generatorStats struct {
 	accounts uint64             // Number of accounts indexed(generated or recovered)
 	slots    uint64             // Number of storage slots indexed(generated or recovered)
 	storage  common.StorageSize // Total account and storage slot size(generation or recovery)
+	dangling uint64             // Number of dangling storage slots wiped(belonging to no account)
 }
 
 // Log creates an contextual log with the given message and the context pulled
@@ -127,20 +141,33 @@ func (gs *generatorStats) Log(msg string, root common.Hash, marker []byte) {
 		"storage", gs.storage,
 		"elapsed", common.PrettyDuration(time.Since(gs.start)),
 	}...)
+	if gs.dangling > 0 {
+		ctx = append(ctx, []interface{}{"dangling", gs.dangling}...)
+	}
 	// Calculate the estimated indexing time based on current stats
-	if len(marker) > 0 {
-		if done := binary.BigEndian.Uint64(marker[:8]) - gs.origin; done > 0 {
-			left := math.MaxUint64 - binary.BigEndian.Uint64(marker[:8])
-
-			speed := done/uint64(time.Since(gs.start)/time.Millisecond+1) + 1 // +1s to avoid division by zero
-			ctx = append(ctx, []interface{}{
-				"eta", common.PrettyDuration(time.Duration(left/speed) * time.Millisecond),
-			}...)
-		}
+	if eta, ok := gs.eta(marker); ok {
+		ctx = append(ctx, []interface{}{"eta", common.PrettyDuration(eta)}...)
 	}
 	log.Info(msg, ctx...)
 }
 
+// eta estimates the time left until the generation completes, based on the speed
+// the marker progressed with through the account space since the generator was
+// (re)started. False is returned if no progress was made yet.
+func (gs *generatorStats) eta(marker []byte) (time.Duration, bool) {
+	if len(marker) == 0 {
+		return 0, false
+	}
+	done := binary.BigEndian.Uint64(marker[:8]) - gs.origin
+	if done == 0 {
+		return 0, false
+	}
+	left := math.MaxUint64 - binary.BigEndian.Uint64(marker[:8])
+
+	speed := done/uint64(time.Since(gs.start)/time.Millisecond+1) + 1 // +1s to avoid division by zero
+	return time.Duration(left/speed) * time.Millisecond, true
+}
+
 // generateSnapshot regenerates a brand new snapshot based on an existing state
 // database and head block asynchronously. The snapshot is returned immediately
 // and generation is continued in the background until done.
@@ -183,6 +210,7 @@ func journalProgress(db czzdb.KeyValueWriter, marker []byte, stats *generatorSta
 		entry.Accounts = stats.accounts
 		entry.Slots = stats.slots
 		entry.Storage = uint64(stats.storage)
+		entry.Dangling = stats.dangling
 	}
 	blob, err := rlp.EncodeToBytes(entry)
 	if err != nil {
@@ -320,7 +348,7 @@ func (dl *diskLayer) proveRange(stats *generatorStats, root common.Hash, prefix
 		return &proofResult{keys: keys, vals: vals}, nil
 	}
 	// Snap state is chunked, generate edge proofs for verification.
-	tr, err := trie.New(root, dl.triedb)
+	tr, err := trie.NewWithOwner(rangeOwner(prefix, kind), root, dl.triedb)
 	if err != nil {
 		stats.Log("Trie missing, state snapshotting paused", dl.root, dl.genMarker)
 		return nil, errMissingTrie
@@ -378,6 +406,15 @@ func (dl *diskLayer) proveRange(stats *generatorStats, root common.Hash, prefix
 // The 'val' is the canonical encoding of the value (not the slim format for accounts)
 type onStateCallback func(key []byte, val []byte, write bool, delete bool) error
 
+// rangeOwner returns the hash of the account owning the trie of a generated
+// range, which is zero for the account trie.
+func rangeOwner(prefix []byte, kind string) common.Hash {
+	if kind != "storage" {
+		return common.Hash{}
+	}
+	return common.BytesToHash(prefix[len(rawdb.SnapshotStoragePrefix):])
+}
+
 // generateRange generates the state segment with particular prefix. Generation can
 // either verify the correctness of existing state through rangeproof and skip
 // generation, or iterate trie to regenerate state on demand.
@@ -441,7 +478,7 @@ func (dl *diskLayer) generateRange(root common.Hash, prefix []byte, kind string,
 	}
 	tr := result.tr
 	if tr == nil {
-		tr, err = trie.New(root, dl.triedb)
+		tr, err = trie.NewWithOwner(rangeOwner(prefix, kind), root, dl.triedb)
 		if err != nil {
 			stats.Log("Trie missing, state snapshotting paused", dl.root, dl.genMarker)
 			return false, nil, errMissingTrie
@@ -532,6 +569,25 @@ func (dl *diskLayer) generateRange(root common.Hash, prefix []byte, kind string,
 	return !trieMore && !result.diskMore, last, nil
 }
 
+// reportProgress publishes a copy of the generator statistics and the position
+// reached, to be served to the status queries, and updates the progress metrics.
+func (dl *diskLayer) reportProgress(stats *generatorStats, marker []byte) {
+	report := *stats
+
+	dl.lock.Lock()
+	dl.genStats, dl.genPosition = &report, common.CopyBytes(marker)
+	dl.lock.Unlock()
+
+	snapProgressAccountGauge.Update(int64(stats.accounts))
+	snapProgressStorageGauge.Update(int64(stats.slots))
+	snapProgressDanglingGauge.Update(int64(stats.dangling))
+	if eta, ok := stats.eta(marker); ok {
+		snapProgressETAGauge.Update(int64(eta / time.Second))
+	} else if marker == nil {
+		snapProgressETAGauge.Update(0)
+	}
+}
+
 // generate is a background thread that iterates over the state and storage tries,
 // constructing the state snapshot. All the arguments are purely for statistics
 // gathering and logging, since the method surfs the blocks as they arrive, often
@@ -549,10 +605,12 @@ func (dl *diskLayer) generate(stats *generatorStats) {
 	var (
 		batch     = dl.diskdb.NewBatch()
 		logged    = time.Now()
+		reported  = time.Now()
 		accOrigin = common.CopyBytes(accMarker)
 		abort     chan *generatorStats
 	)
 	stats.Log("Resuming state snapshot generation", dl.root, dl.genMarker)
+	dl.reportProgress(stats, dl.genMarker)
 
 	checkAndFlush := func(currentLocation []byte) error {
 		select {
@@ -576,6 +634,7 @@ func (dl *diskLayer) generate(stats *generatorStats) {
 
 			if abort != nil {
 				stats.Log("Aborting state snapshot generation", dl.root, currentLocation)
+				dl.reportProgress(stats, currentLocation)
 				return errors.New("aborted")
 			}
 		}
@@ -583,6 +642,10 @@ func (dl *diskLayer) generate(stats *generatorStats) {
 			stats.Log("Generating state snapshot", dl.root, currentLocation)
 			logged = time.Now()
 		}
+		if time.Since(reported) > generatorReportInterval {
+			dl.reportProgress(stats, currentLocation)
+			reported = time.Now()
+		}
 		return nil
 	}
 
@@ -598,9 +661,11 @@ func (dl *diskLayer) generate(stats *generatorStats) {
 			// Ensure that any previous snapshot storage values are cleared
 			prefix := append(rawdb.SnapshotStoragePrefix, accountHash.Bytes()...)
 			keyLen := len(rawdb.SnapshotStoragePrefix) + 2*common.HashLength
-			if err := wipeKeyRange(dl.diskdb, "storage", prefix, nil, nil, keyLen, snapWipedStorageMeter, false); err != nil {
+			wiped, err := wipeKeyRange(dl.diskdb, "storage", prefix, nil, nil, keyLen, snapWipedStorageMeter, false)
+			if err != nil {
 				return err
 			}
+			stats.dangling += uint64(wiped)
 			snapAccountWriteCounter.Inc(time.Since(start).Nanoseconds())
 			return nil
 		}
@@ -648,9 +713,11 @@ func (dl *diskLayer) generate(stats *generatorStats) {
 			//  - Perhaps we can avoid if where codeHash is emptyCode
 			prefix := append(rawdb.SnapshotStoragePrefix, accountHash.Bytes()...)
 			keyLen := len(rawdb.SnapshotStoragePrefix) + 2*common.HashLength
-			if err := wipeKeyRange(dl.diskdb, "storage", prefix, nil, nil, keyLen, snapWipedStorageMeter, false); err != nil {
+			wiped, err := wipeKeyRange(dl.diskdb, "storage", prefix, nil, nil, keyLen, snapWipedStorageMeter, false)
+			if err != nil {
 				return err
 			}
+			stats.dangling += uint64(wiped)
 			snapAccountWriteCounter.Inc(time.Since(start).Nanoseconds())
 		} else {
 			snapAccountWriteCounter.Inc(time.Since(start).Nanoseconds())
@@ -737,7 +804,8 @@ func (dl *diskLayer) generate(stats *generatorStats) {
 	batch.Reset()
 
 	log.Info("Generated state snapshot", "accounts", stats.accounts, "slots", stats.slots,
-		"storage", stats.storage, "elapsed", common.PrettyDuration(time.Since(stats.start)))
+		"storage", stats.storage, "dangling", stats.dangling, "elapsed", common.PrettyDuration(time.Since(stats.start)))
+	dl.reportProgress(stats, nil)
 
 	dl.lock.Lock()
 	dl.genMarker = nil
@@ -831,3 +831,70 @@ func TestGenerateWithIncompleteStorage(t *testing.T) {
 	snap.genAbort <- stop
 	<-stop
 }
+
+// Tests that the snapshot generation can be paused and resumed, carrying over
+// the progress statistics.
+func TestGenerationPauseResume(t *testing.T) {
+	helper := newHelper()
+	stRoot := helper.makeStorageTrie([]string{"key-1", "key-2", "key-3"}, []string{"val-1", "val-2", "val-3"})
+	helper.triedb.Commit(common.BytesToHash(stRoot), false, nil)
+
+	helper.addTrieAccount("acc-1", &Account{Balance: big.NewInt(1), Root: stRoot, CodeHash: emptyCode.Bytes()})
+	helper.addTrieAccount("acc-2", &Account{Balance: big.NewInt(2), Root: emptyRoot.Bytes(), CodeHash: emptyCode.Bytes()})
+	helper.addTrieAccount("acc-3", &Account{Balance: big.NewInt(3), Root: stRoot, CodeHash: emptyCode.Bytes()})
+
+	// An account missing from the trie leaves dangling storage in the snapshot
+	helper.addSnapAccount("acc-4", &Account{Balance: big.NewInt(4), Root: stRoot, CodeHash: emptyCode.Bytes()})
+	helper.addSnapStorage("acc-4", []string{"key-1", "key-2"}, []string{"val-1", "val-2"})
+
+	// Remove the storage trie root, so the generator can't finish until restored
+	blob, _ := helper.diskdb.Get(stRoot)
+	helper.diskdb.Delete(stRoot)
+
+	root, snap := helper.Generate()
+	snaps := &Tree{
+		diskdb: helper.diskdb,
+		triedb: helper.triedb,
+		cache:  16,
+		layers: map[common.Hash]snapshot{root: snap},
+	}
+	if err := snaps.PauseGeneration(); err != nil {
+		t.Fatalf("failed to pause generation: %v", err)
+	}
+	if err := snaps.PauseGeneration(); err != errGeneratorPaused {
+		t.Fatalf("repeated pause error mismatch: have %v, want %v", err, errGeneratorPaused)
+	}
+	status, err := snaps.GeneratorStatus()
+	if err != nil {
+		t.Fatalf("failed to retrieve status: %v", err)
+	}
+	if status.Root != root || status.Done || !status.Paused || status.Marker == nil || status.ETA != "" {
+		t.Fatalf("paused status mismatch: %+v", status)
+	}
+	// Restore the storage trie and resume the generation
+	helper.diskdb.Put(stRoot, blob)
+	if err := snaps.ResumeGeneration(); err != nil {
+		t.Fatalf("failed to resume generation: %v", err)
+	}
+	select {
+	case <-snap.genPending:
+		// Snapshot generation succeeded
+
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Snapshot generation failed")
+	}
+	checkSnapRoot(t, snap, root)
+
+	if status, err = snaps.GeneratorStatus(); err != nil {
+		t.Fatalf("failed to retrieve status: %v", err)
+	}
+	if !status.Done || status.Paused || status.Marker != nil || status.Accounts < 3 || status.Dangling != 2 {
+		t.Fatalf("generated status mismatch: %+v", status)
+	}
+	if err := snaps.ResumeGeneration(); err != errGeneratorDone {
+		t.Fatalf("resume error mismatch: have %v, want %v", err, errGeneratorDone)
+	}
+	if err := snaps.PauseGeneration(); err != errGeneratorDone {
+		t.Fatalf("pause error mismatch: have %v, want %v", err, errGeneratorDone)
+	}
+}
@@ -46,6 +46,7 @@ type journalGenerator struct {
 	Accounts uint64
 	Slots    uint64
 	Storage  uint64
+	Dangling uint64 `rlp:"optional"` // Number of dangling storage slots wiped
 }
 
 // journalDestruct is an account deletion entry in a diffLayer's disk journal.
@@ -125,6 +126,25 @@ func loadAndParseJournal(db czzdb.KeyValueStore, base *diskLayer) (snapshot, jou
 	return snapshot, generator, nil
 }
 
+// CheckJournal verifies that the persisted snapshot can be loaded: the disk layer
+// root and the generator must be present and the diff journal, if it belongs to
+// the disk layer, must be decodable. Databases without a snapshot, or with the
+// snapshot disabled during the initial sync, pass the check.
+func CheckJournal(db czzdb.KeyValueStore) error {
+	if rawdb.ReadSnapshotDisabled(db) {
+		return nil
+	}
+	root := rawdb.ReadSnapshotRoot(db)
+	if root == (common.Hash{}) {
+		if len(rawdb.ReadSnapshotGenerator(db)) > 0 || len(rawdb.ReadSnapshotJournal(db)) > 0 {
+			return errors.New("snapshot journal without disk layer root")
+		}
+		return nil
+	}
+	_, _, err := loadAndParseJournal(db, &diskLayer{diskdb: db, root: root})
+	return err
+}
+
 // loadSnapshot loads a pre-existing state snapshot backed by a key-value store.
 func loadSnapshot(diskdb czzdb.KeyValueStore, triedb *trie.Database, cache int, root common.Hash, recovery bool) (snapshot, bool, error) {
 	// If snapshotting is disabled (initial sync in progress), don't do anything,
@@ -190,6 +210,7 @@ func loadSnapshot(diskdb czzdb.KeyValueStore, triedb *trie.Database, cache int,
 			accounts: generator.Accounts,
 			slots:    generator.Slots,
 			storage:  common.StorageSize(generator.Storage),
+			dangling: generator.Dangling,
 		})
 	}
 	return snapshot, false, nil
@@ -258,6 +279,9 @@ func (dl *diskLayer) Journal(buffer *bytes.Buffer) (common.Hash, error) {
 		if stats = <-abort; stats != nil {
 			stats.Log("Journalling in-progress snapshot", dl.root, dl.genMarker)
 		}
+	} else if dl.genMarker != nil {
+		// The generation is paused, persist the progress it left off with
+		stats = dl.genStats
 	}
 	// Ensure the layer didn't get stale
 	dl.lock.RLock()
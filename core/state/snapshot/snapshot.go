@@ -19,12 +19,15 @@ package snapshot
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
 	"github.com/classzz/go-classzz-v2/core/rawdb"
 	"github.com/classzz/go-classzz-v2/czzdb"
 	"github.com/classzz/go-classzz-v2/log"
@@ -94,6 +97,18 @@ var (
 	// errSnapshotCycle is returned if a snapshot is attempted to be inserted
 	// that forms a cycle in the snapshot tree.
 	errSnapshotCycle = errors.New("snapshot cycle")
+
+	// errGeneratorDone is returned if the generation of an already complete
+	// snapshot is attempted to be paused or resumed.
+	errGeneratorDone = errors.New("snapshot generation complete")
+
+	// errGeneratorPaused is returned if an already paused generation is attempted
+	// to be paused again.
+	errGeneratorPaused = errors.New("snapshot generation already paused")
+
+	// errGeneratorRunning is returned if a generation which isn't paused is
+	// attempted to be resumed.
+	errGeneratorRunning = errors.New("snapshot generation not paused")
 )
 
 // Snapshot represents the functionality supported by a snapshot storage layer.
@@ -162,6 +177,8 @@ type Tree struct {
 	triedb *trie.Database           // In-memory cache to access the trie through
 	cache  int                      // Megabytes permitted to use for read caches
 	layers map[common.Hash]snapshot // Collection of all known layers
+	holds  int32                    // Number of active holds suspending layer flattening (atomic)
+	expiry int64                    // Unix nano time the most recent hold expires at (atomic)
 	lock   sync.RWMutex
 }
 
@@ -371,6 +388,15 @@ func (t *Tree) Cap(root common.Hash, layers int) error {
 	if !ok {
 		return fmt.Errorf("snapshot [%#x] is disk layer", root)
 	}
+	// If the tree is held by a long-running reader, keep every layer intact
+	// until it's released or the hold expires. Explicit full flattening is
+	// still honoured.
+	if layers > 0 && atomic.LoadInt32(&t.holds) > 0 {
+		if time.Now().UnixNano() < atomic.LoadInt64(&t.expiry) {
+			return nil
+		}
+		log.Debug("Snapshot hold expired, flattening layers", "root", root)
+	}
 	// If the generator is still running, use a more aggressive cap
 	diff.origin.lock.RLock()
 	if diff.origin.genMarker != nil && layers > 8 {
@@ -434,6 +460,21 @@ func (t *Tree) Cap(root common.Hash, layers int) error {
 	return nil
 }
 
+// Hold suspends the flattening of diff layers, so that all the layers currently
+// in the tree, and any iterator created upon them, stay valid until the returned
+// release function is called. The memory used by the diff layers keeps growing
+// while the tree is held, so the hold expires after the given limit: the layers
+// are flattened again and the iterators of the holder turn stale.
+func (t *Tree) Hold(limit time.Duration) func() {
+	atomic.StoreInt64(&t.expiry, time.Now().Add(limit).UnixNano())
+	atomic.AddInt32(&t.holds, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { atomic.AddInt32(&t.holds, -1) })
+	}
+}
+
 // cap traverses downwards the diff tree until the number of allowed layers are
 // crossed. All diffs beyond the permitted number are flattened downwards. If the
 // layer limit is reached, memory cap is also enforced (but not before).
@@ -512,6 +553,9 @@ func diffToDisk(bottom *diffLayer) *diskLayer {
 		abort := make(chan *generatorStats)
 		base.genAbort <- abort
 		stats = <-abort
+	} else if base.genMarker != nil {
+		// The generation is paused, persist the progress it left off with
+		stats = base.genStats
 	}
 	// Put the deletion in the batch writer, flush all updates in the final step.
 	rawdb.DeleteSnapshotRoot(batch)
@@ -623,6 +667,9 @@ func diffToDisk(bottom *diffLayer) *diskLayer {
 		triedb:     base.triedb,
 		genMarker:  base.genMarker,
 		genPending: base.genPending,
+
+		genStats:    base.genStats,
+		genPosition: base.genPosition,
 	}
 	// If snapshot generation hasn't finished yet, port over all the starts and
 	// continue where the previous round left off.
@@ -722,6 +769,136 @@ func (t *Tree) Rebuild(root common.Hash) {
 	}
 }
 
+// GeneratorStatus is the progress of the background snapshot generation.
+type GeneratorStatus struct {
+	Root     common.Hash        `json:"root"`              // State root of the disk layer being generated
+	Done     bool               `json:"done"`              // Whether the snapshot is fully generated
+	Paused   bool               `json:"paused"`            // Whether the generation is paused
+	Marker   hexutil.Bytes      `json:"marker"`            // Account (and storage slot) the generation reached
+	Accounts uint64             `json:"accounts"`          // Number of accounts indexed
+	Slots    uint64             `json:"slots"`             // Number of storage slots indexed
+	Storage  common.StorageSize `json:"storage"`           // Total size of the indexed accounts and slots
+	Dangling uint64             `json:"dangling"`          // Number of dangling storage slots wiped
+	Elapsed  string             `json:"elapsed,omitempty"` // Time spent since the generation was (re)started
+	ETA      string             `json:"eta,omitempty"`     // Estimated time left until the generation completes
+}
+
+// GeneratorStatus returns the progress of the background snapshot generation.
+// The statistics are only available if the generator ran since the node started.
+func (t *Tree) GeneratorStatus() (*GeneratorStatus, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	dl := t.disklayer()
+	if dl == nil {
+		return nil, errors.New("disk layer is missing")
+	}
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	status := &GeneratorStatus{
+		Root:   dl.root,
+		Done:   dl.genMarker == nil,
+		Paused: dl.genMarker != nil && dl.genAbort == nil,
+	}
+	position := dl.genPosition
+	if position == nil {
+		position = dl.genMarker
+	}
+	if !status.Done {
+		status.Marker = common.CopyBytes(position)
+	}
+	if stats := dl.genStats; stats != nil {
+		status.Accounts, status.Slots = stats.accounts, stats.slots
+		status.Storage, status.Dangling = stats.storage, stats.dangling
+
+		if !status.Done && !status.Paused {
+			status.Elapsed = common.PrettyDuration(time.Since(stats.start)).String()
+			if eta, ok := stats.eta(position); ok {
+				status.ETA = common.PrettyDuration(eta).String()
+			}
+		}
+	}
+	return status, nil
+}
+
+// PauseGeneration suspends the background snapshot generation, persisting its
+// progress. The generation stays paused as the chain progresses, until it's
+// resumed or the node is restarted. Meanwhile the state not yet covered by the
+// snapshot is read from the tries.
+func (t *Tree) PauseGeneration() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	dl := t.disklayer()
+	if dl == nil {
+		return errors.New("disk layer is missing")
+	}
+	dl.lock.RLock()
+	done := dl.genMarker == nil
+	dl.lock.RUnlock()
+
+	if done {
+		return errGeneratorDone
+	}
+	if dl.genAbort == nil {
+		return errGeneratorPaused
+	}
+	abort := make(chan *generatorStats)
+	dl.genAbort <- abort
+	stats := <-abort
+
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.genAbort = nil
+	if stats == nil {
+		return errGeneratorDone // Finished while we were trying to pause it
+	}
+	dl.genStats, dl.genPosition = stats, common.CopyBytes(dl.genMarker)
+	journalProgress(dl.diskdb, dl.genMarker, stats)
+
+	stats.Log("Paused state snapshot generation", dl.root, dl.genMarker)
+	return nil
+}
+
+// ResumeGeneration continues a paused background snapshot generation from the
+// progress it left off with.
+func (t *Tree) ResumeGeneration() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	dl := t.disklayer()
+	if dl == nil {
+		return errors.New("disk layer is missing")
+	}
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.genMarker == nil {
+		return errGeneratorDone
+	}
+	if dl.genAbort != nil {
+		return errGeneratorRunning
+	}
+	// Continue with the statistics gathered so far, but measure the speed anew
+	stats := new(generatorStats)
+	if dl.genStats != nil {
+		*stats = *dl.genStats
+	}
+	stats.origin, stats.start = 0, time.Now()
+	if len(dl.genMarker) >= 8 {
+		stats.origin = binary.BigEndian.Uint64(dl.genMarker)
+	}
+	if dl.genPending == nil {
+		dl.genPending = make(chan struct{})
+	}
+	dl.genAbort = make(chan chan *generatorStats)
+	go dl.generate(stats)
+
+	return nil
+}
+
 // AccountIterator creates a new account iterator for the specified root hash and
 // seeks to a starting account hash.
 func (t *Tree) AccountIterator(root common.Hash, seek common.Hash) (AccountIterator, error) {
@@ -22,6 +22,7 @@ import (
 	"math/big"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/VictoriaMetrics/fastcache"
 	"github.com/classzz/go-classzz-v2/common"
@@ -423,3 +424,55 @@ func TestSnaphots(t *testing.T) {
 		}
 	}
 }
+
+// Tests that holding the tree suspends the flattening of the diff layers until
+// the hold is released or expires.
+func TestHoldExpiry(t *testing.T) {
+	base := &diskLayer{
+		diskdb: rawdb.NewMemoryDatabase(),
+		root:   common.HexToHash("0x01"),
+		cache:  fastcache.New(1024 * 500),
+	}
+	snaps := &Tree{
+		layers: map[common.Hash]snapshot{
+			base.root: base,
+		},
+	}
+	accounts := map[common.Hash][]byte{
+		common.HexToHash("0xa1"): randomAccount(),
+	}
+	for i := 2; i <= 4; i++ {
+		if err := snaps.Update(common.BigToHash(big.NewInt(int64(i))), common.BigToHash(big.NewInt(int64(i-1))), nil, accounts, nil); err != nil {
+			t.Fatalf("failed to create diff layer %d: %v", i, err)
+		}
+	}
+	defer func(memcap uint64) { aggregatorMemoryLimit = memcap }(aggregatorMemoryLimit)
+	aggregatorMemoryLimit = 0
+
+	// A held tree retains all its layers
+	release := snaps.Hold(time.Hour)
+	ref := snaps.Snapshot(common.HexToHash("0x02"))
+	if err := snaps.Cap(common.HexToHash("0x04"), 1); err != nil {
+		t.Fatalf("failed to cap held tree: %v", err)
+	}
+	if n := len(snaps.layers); n != 4 {
+		t.Fatalf("held layer count mismatch: have %d, want %d", n, 4)
+	}
+	if _, err := ref.Account(common.HexToHash("0xa1")); err != nil {
+		t.Fatalf("held layer turned stale: %v", err)
+	}
+	release()
+
+	// An expired hold doesn't prevent the flattening anymore
+	release = snaps.Hold(0)
+	defer release()
+	if err := snaps.Cap(common.HexToHash("0x04"), 1); err != nil {
+		t.Fatalf("failed to cap expired hold: %v", err)
+	}
+	if n := len(snaps.layers); n != 2 {
+		t.Fatalf("expired hold layer count mismatch: have %d, want %d", n, 2)
+	}
+	if _, err := ref.Account(common.HexToHash("0xa1")); err != ErrSnapshotStale {
+		t.Fatalf("flattened layer not stale: %v", err)
+	}
+}
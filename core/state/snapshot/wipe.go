@@ -54,10 +54,10 @@ func wipeSnapshot(db czzdb.KeyValueStore, full bool) chan struct{} {
 // removed in sync to avoid data races. After all is done, the snapshot range of
 // the database is compacted to free up unused data blocks.
 func wipeContent(db czzdb.KeyValueStore) error {
-	if err := wipeKeyRange(db, "accounts", rawdb.SnapshotAccountPrefix, nil, nil, len(rawdb.SnapshotAccountPrefix)+common.HashLength, snapWipedAccountMeter, true); err != nil {
+	if _, err := wipeKeyRange(db, "accounts", rawdb.SnapshotAccountPrefix, nil, nil, len(rawdb.SnapshotAccountPrefix)+common.HashLength, snapWipedAccountMeter, true); err != nil {
 		return err
 	}
-	if err := wipeKeyRange(db, "storage", rawdb.SnapshotStoragePrefix, nil, nil, len(rawdb.SnapshotStoragePrefix)+2*common.HashLength, snapWipedStorageMeter, true); err != nil {
+	if _, err := wipeKeyRange(db, "storage", rawdb.SnapshotStoragePrefix, nil, nil, len(rawdb.SnapshotStoragePrefix)+2*common.HashLength, snapWipedStorageMeter, true); err != nil {
 		return err
 	}
 	// Compact the snapshot section of the database to get rid of unused space
@@ -87,7 +87,8 @@ func wipeContent(db czzdb.KeyValueStore) error {
 // specifying a particular key range for deletion.
 //
 // Origin is included for wiping and limit is excluded if they are specified.
-func wipeKeyRange(db czzdb.KeyValueStore, kind string, prefix []byte, origin []byte, limit []byte, keylen int, meter metrics.Meter, report bool) error {
+// The number of deleted keys is returned.
+func wipeKeyRange(db czzdb.KeyValueStore, kind string, prefix []byte, origin []byte, limit []byte, keylen int, meter metrics.Meter, report bool) (int, error) {
 	// Batch deletions together to avoid holding an iterator for too long
 	var (
 		batch = db.NewBatch()
@@ -121,7 +122,7 @@ func wipeKeyRange(db czzdb.KeyValueStore, kind string, prefix []byte, origin []b
 			// Batch too large (or iterator too long lived, flush and recreate)
 			it.Release()
 			if err := batch.Write(); err != nil {
-				return err
+				return items, err
 			}
 			batch.Reset()
 			seekPos := key[len(prefix):]
@@ -135,7 +136,7 @@ func wipeKeyRange(db czzdb.KeyValueStore, kind string, prefix []byte, origin []b
 	}
 	it.Release()
 	if err := batch.Write(); err != nil {
-		return err
+		return items, err
 	}
 	if meter != nil {
 		meter.Mark(int64(items))
@@ -143,5 +144,5 @@ func wipeKeyRange(db czzdb.KeyValueStore, kind string, prefix []byte, origin []b
 	if report {
 		log.Info("Deleted state snapshot leftovers", "kind", kind, "wiped", items, "elapsed", common.PrettyDuration(time.Since(start)))
 	}
-	return nil
+	return items, nil
 }
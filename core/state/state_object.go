@@ -158,11 +158,13 @@ func (s *stateObject) EncodeRLP(w io.Writer) error {
 	return rlp.Encode(w, s.data)
 }
 
-// setError remembers the first non-nil error it is called with.
+// setError remembers the first non-nil error it is called with, reporting it
+// to the state database too.
 func (s *stateObject) setError(err error) {
 	if s.dbErr == nil {
 		s.dbErr = err
 	}
+	s.db.setError(err)
 }
 
 func (s *stateObject) markSuicided() {
@@ -187,7 +189,7 @@ func (s *stateObject) getTrie(db Database) Trie {
 		if s.data.Root != emptyRoot && s.db.prefetcher != nil {
 			// When the miner is creating the pending state, there is no
 			// prefetcher
-			s.trie = s.db.prefetcher.trie(s.data.Root)
+			s.trie = s.db.prefetcher.trie(s.addrHash, s.data.Root)
 		}
 		if s.trie == nil {
 			var err error
@@ -297,6 +299,9 @@ func (self *stateObject) GetTeWakaState(db Database, key common.Hash) []byte {
 	// Load from DB in case it is missing.
 	//fmt.Println("addr.Bytes() GetTeWakaState", key.Bytes())
 	value, err := self.getTrie(db).TryGet(key[:])
+	if err != nil {
+		self.setError(err)
+	}
 	if err == nil && len(value) != 0 {
 		self.originTeWakaStorage[key] = value
 	}
@@ -371,7 +376,7 @@ func (s *stateObject) finalise(prefetch bool) {
 		}
 	}
 	if s.db.prefetcher != nil && prefetch && len(slotsToPrefetch) > 0 && s.data.Root != emptyRoot {
-		s.db.prefetcher.prefetch(s.data.Root, slotsToPrefetch)
+		s.db.prefetcher.prefetch(s.addrHash, s.data.Root, slotsToPrefetch)
 	}
 	if len(s.dirtyStorage) > 0 {
 		s.dirtyStorage = make(Storage)
@@ -426,7 +431,7 @@ func (s *stateObject) updateTrie(db Database) Trie {
 		usedStorage = append(usedStorage, common.CopyBytes(key[:])) // Copy needed for closure
 	}
 	if s.db.prefetcher != nil {
-		s.db.prefetcher.used(s.data.Root, usedStorage)
+		s.db.prefetcher.used(s.addrHash, s.data.Root, usedStorage)
 	}
 	if len(s.pendingStorage) > 0 {
 		s.pendingStorage = make(Storage)
@@ -20,7 +20,6 @@ package state
 import (
 	"errors"
 	"fmt"
-	"github.com/classzz/go-classzz-v2/core/vm"
 	"math/big"
 	"sort"
 	"time"
@@ -60,6 +59,10 @@ func (n *proofList) Delete(key []byte) error {
 	panic("not supported")
 }
 
+// teWakaAddress is the address of the TeWaka precompile, vm.TeWaKaAddress. It
+// is duplicated as the vm package depends on this one.
+var teWakaAddress = common.BytesToAddress([]byte("tewaka"))
+
 func lockedKey(addr common.Address) (h common.Hash) {
 	base := append(common.BytesToHash(addr[:]).Bytes(), lockedPosition.Bytes()...)
 	return crypto.Keccak256Hash(base)
@@ -84,9 +87,10 @@ type StateDB struct {
 	snapStorage   map[common.Hash]map[common.Hash][]byte
 
 	// This map holds 'live' objects, which will get modified while processing a state transition.
-	stateObjects        map[common.Address]*stateObject
-	stateObjectsPending map[common.Address]struct{} // State objects finalized but not yet written to the trie
-	stateObjectsDirty   map[common.Address]struct{} // State objects modified in the current execution
+	stateObjects         map[common.Address]*stateObject
+	stateObjectsPending  map[common.Address]struct{} // State objects finalized but not yet written to the trie
+	stateObjectsDirty    map[common.Address]struct{} // State objects modified in the current execution
+	stateObjectsDestruct map[common.Hash]struct{}    // Hashes of accounts destructed, whose storage needs wiping
 
 	// DB error.
 	// State objects are used by the consensus core and VM which are
@@ -135,18 +139,19 @@ func New(root common.Hash, db Database, snaps *snapshot.Tree) (*StateDB, error)
 		return nil, err
 	}
 	sdb := &StateDB{
-		db:                  db,
-		trie:                tr,
-		originalRoot:        root,
-		snaps:               snaps,
-		stateObjects:        make(map[common.Address]*stateObject),
-		stateObjectsPending: make(map[common.Address]struct{}),
-		stateObjectsDirty:   make(map[common.Address]struct{}),
-		logs:                make(map[common.Hash][]*types.Log),
-		preimages:           make(map[common.Hash][]byte),
-		journal:             newJournal(),
-		accessList:          newAccessList(),
-		hasher:              crypto.NewKeccakState(),
+		db:                   db,
+		trie:                 tr,
+		originalRoot:         root,
+		snaps:                snaps,
+		stateObjects:         make(map[common.Address]*stateObject),
+		stateObjectsPending:  make(map[common.Address]struct{}),
+		stateObjectsDirty:    make(map[common.Address]struct{}),
+		stateObjectsDestruct: make(map[common.Hash]struct{}),
+		logs:                 make(map[common.Hash][]*types.Log),
+		preimages:            make(map[common.Hash][]byte),
+		journal:              newJournal(),
+		accessList:           newAccessList(),
+		hasher:               crypto.NewKeccakState(),
 	}
 	if sdb.snaps != nil {
 		if sdb.snap = sdb.snaps.Snapshot(root); sdb.snap != nil {
@@ -327,7 +332,7 @@ func (s *StateDB) GetTeWakaState(addr common.Address, hash common.Hash) []byte {
 
 func (self *StateDB) GetTeWakaStateLocked(addr common.Address) *big.Int {
 	key := lockedKey(addr)
-	return self.GetState(vm.TeWaKaAddress, key).Big()
+	return self.GetState(teWakaAddress, key).Big()
 }
 
 // GetProof returns the Merkle proof for a given account.
@@ -605,18 +610,24 @@ func (s *StateDB) GetOrNewStateObject(addr common.Address) *stateObject {
 func (s *StateDB) createObject(addr common.Address) (newobj, prev *stateObject) {
 	prev = s.getDeletedStateObject(addr) // Note, prev might have been deleted, we need that!
 
-	var prevdestruct bool
+	var prevdestruct, prevwipe bool
 	if s.snap != nil && prev != nil {
 		_, prevdestruct = s.snapDestructs[prev.addrHash]
 		if !prevdestruct {
 			s.snapDestructs[prev.addrHash] = struct{}{}
 		}
 	}
+	if prev != nil {
+		_, prevwipe = s.stateObjectsDestruct[prev.addrHash]
+		if !prevwipe {
+			s.stateObjectsDestruct[prev.addrHash] = struct{}{}
+		}
+	}
 	newobj = newObject(s, addr, Account{})
 	if prev == nil {
 		s.journal.append(createObjectChange{account: &addr})
 	} else {
-		s.journal.append(resetObjectChange{prev: prev, prevdestruct: prevdestruct})
+		s.journal.append(resetObjectChange{prev: prev, prevdestruct: prevdestruct, prevwipe: prevwipe})
 	}
 	s.setStateObject(newobj)
 	if prev != nil && !prev.deleted {
@@ -691,6 +702,9 @@ func (self *StateDB) ForEachTeWakaStorage(addr common.Address, cb func(key commo
 			return
 		}
 	}
+	if it.Err != nil {
+		stateObject.setError(it.Err)
+	}
 }
 
 // Copy creates a deep, independent copy of the state.
@@ -698,17 +712,18 @@ func (self *StateDB) ForEachTeWakaStorage(addr common.Address, cb func(key commo
 func (s *StateDB) Copy() *StateDB {
 	// Copy all the basic fields, initialize the memory ones
 	state := &StateDB{
-		db:                  s.db,
-		trie:                s.db.CopyTrie(s.trie),
-		stateObjects:        make(map[common.Address]*stateObject, len(s.journal.dirties)),
-		stateObjectsPending: make(map[common.Address]struct{}, len(s.stateObjectsPending)),
-		stateObjectsDirty:   make(map[common.Address]struct{}, len(s.journal.dirties)),
-		refund:              s.refund,
-		logs:                make(map[common.Hash][]*types.Log, len(s.logs)),
-		logSize:             s.logSize,
-		preimages:           make(map[common.Hash][]byte, len(s.preimages)),
-		journal:             newJournal(),
-		hasher:              crypto.NewKeccakState(),
+		db:                   s.db,
+		trie:                 s.db.CopyTrie(s.trie),
+		stateObjects:         make(map[common.Address]*stateObject, len(s.journal.dirties)),
+		stateObjectsPending:  make(map[common.Address]struct{}, len(s.stateObjectsPending)),
+		stateObjectsDirty:    make(map[common.Address]struct{}, len(s.journal.dirties)),
+		stateObjectsDestruct: make(map[common.Hash]struct{}, len(s.stateObjectsDestruct)),
+		refund:               s.refund,
+		logs:                 make(map[common.Hash][]*types.Log, len(s.logs)),
+		logSize:              s.logSize,
+		preimages:            make(map[common.Hash][]byte, len(s.preimages)),
+		journal:              newJournal(),
+		hasher:               crypto.NewKeccakState(),
 	}
 	// Copy the dirty states, logs, and preimages
 	for addr := range s.journal.dirties {
@@ -741,6 +756,9 @@ func (s *StateDB) Copy() *StateDB {
 		}
 		state.stateObjectsDirty[addr] = struct{}{}
 	}
+	for addrHash := range s.stateObjectsDestruct {
+		state.stateObjectsDestruct[addrHash] = struct{}{}
+	}
 	for hash, logs := range s.logs {
 		cpy := make([]*types.Log, len(logs))
 		for i, l := range logs {
@@ -840,6 +858,7 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 		}
 		if obj.suicided || (deleteEmptyObjects && obj.empty()) {
 			obj.deleted = true
+			s.stateObjectsDestruct[obj.addrHash] = struct{}{}
 
 			// If state snapshotting is active, also mark the destruction there.
 			// Note, we can't do this only at the end of a block because multiple
@@ -862,7 +881,7 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 		addressesToPrefetch = append(addressesToPrefetch, common.CopyBytes(addr[:])) // Copy needed for closure
 	}
 	if s.prefetcher != nil && len(addressesToPrefetch) > 0 {
-		s.prefetcher.prefetch(s.originalRoot, addressesToPrefetch)
+		s.prefetcher.prefetch(common.Hash{}, s.originalRoot, addressesToPrefetch)
 	}
 	// Invalidate journal because reverting across transactions is not allowed.
 	s.clearJournalAndRefund()
@@ -903,7 +922,7 @@ func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
 	// _untouched_. We can check with the prefetcher, if it can give us a trie
 	// which has the same root, but also has some content loaded into it.
 	if prefetcher != nil {
-		if trie := prefetcher.trie(s.originalRoot); trie != nil {
+		if trie := prefetcher.trie(common.Hash{}, s.originalRoot); trie != nil {
 			s.trie = trie
 		}
 	}
@@ -917,7 +936,7 @@ func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
 		usedAddrs = append(usedAddrs, common.CopyBytes(addr[:])) // Copy needed for closure
 	}
 	if prefetcher != nil {
-		prefetcher.used(s.originalRoot, usedAddrs)
+		prefetcher.used(common.Hash{}, s.originalRoot, usedAddrs)
 	}
 	if len(s.stateObjectsPending) > 0 {
 		s.stateObjectsPending = make(map[common.Address]struct{})
@@ -953,6 +972,19 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 	// Finalize any pending changes and merge everything into the tries
 	s.IntermediateRoot(deleteEmptyObjects)
 
+	// The path-based trie database gathers the changes of all the tries until
+	// the account trie is committed, so only one state is committed at a time
+	release := s.db.TrieDB().LockStateCommit()
+	defer release()
+
+	// Drop the storage of all destructed accounts before committing the new
+	// storage tries, needed by the path-based trie database only
+	for addrHash := range s.stateObjectsDestruct {
+		s.db.TrieDB().WipeStorage(addrHash)
+	}
+	if len(s.stateObjectsDestruct) > 0 {
+		s.stateObjectsDestruct = make(map[common.Hash]struct{})
+	}
 	// Commit objects to the trie, measuring the elapsed time
 	codeWriter := s.db.TrieDB().DiskDB().NewBatch()
 	for addr := range s.stateObjectsDirty {
@@ -1080,12 +1112,32 @@ func (s *StateDB) SlotInAccessList(addr common.Address, slot common.Hash) (addre
 	return s.accessList.Contains(addr, slot)
 }
 
+// recordsWitnessed reports whether the TeWaka item records are out of reach,
+// as the state is accessed through an execution witness. The access is then
+// flagged as a database error, failing the execution.
+func (s *StateDB) recordsWitnessed() bool {
+	if _, ok := s.db.(witnessedDB); ok {
+		s.setError(ErrRecordsUnwitnessed)
+		return true
+	}
+	return false
+}
+
 func (s *StateDB) HasRecord(atype uint64, hash common.Hash) bool {
+	if s.recordsWitnessed() {
+		return false
+	}
 	return rawdb.HasRecord(s.db.TrieDB().DiskDB(), atype, hash)
 }
 func (s *StateDB) WriteRecord(atype uint64, hash common.Hash) {
+	if s.recordsWitnessed() {
+		return
+	}
 	rawdb.WriteRecord(s.db.TrieDB().DiskDB(), atype, hash)
 }
 func (s *StateDB) DeleteRecord(atype uint64, hash common.Hash) {
+	if s.recordsWitnessed() {
+		return
+	}
 	rawdb.DeleteRecord(s.db.TrieDB().DiskDB(), atype, hash)
 }
@@ -32,8 +32,19 @@ import (
 	"github.com/classzz/go-classzz-v2/common"
 	"github.com/classzz/go-classzz-v2/core/rawdb"
 	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/trie"
 )
 
+// testSchemes is the list of trie node storage schemes the state tests run with.
+var testSchemes = []string{rawdb.HashScheme, rawdb.PathScheme}
+
+// newTestDatabase creates a state database storing the trie nodes in the given
+// scheme.
+func newTestDatabase(diskdb czzdb.Database, scheme string) Database {
+	return NewDatabaseWithConfig(diskdb, &trie.Config{Scheme: scheme})
+}
+
 // Tests that updating a state trie does not leak any database writes prior to
 // actually committing the state.
 func TestUpdateLeaks(t *testing.T) {
@@ -70,11 +81,17 @@ func TestUpdateLeaks(t *testing.T) {
 // Tests that no intermediate state of an object is stored into the database,
 // only the one right before the commit.
 func TestIntermediateLeaks(t *testing.T) {
+	for _, scheme := range testSchemes {
+		testIntermediateLeaks(t, scheme)
+	}
+}
+
+func testIntermediateLeaks(t *testing.T, scheme string) {
 	// Create two state databases, one transitioning to the final state, the other final from the beginning
 	transDb := rawdb.NewMemoryDatabase()
 	finalDb := rawdb.NewMemoryDatabase()
-	transState, _ := New(common.Hash{}, NewDatabase(transDb), nil)
-	finalState, _ := New(common.Hash{}, NewDatabase(finalDb), nil)
+	transState, _ := New(common.Hash{}, newTestDatabase(transDb, scheme), nil)
+	finalState, _ := New(common.Hash{}, newTestDatabase(finalDb, scheme), nil)
 
 	modify := func(state *StateDB, addr common.Address, i, tweak byte) {
 		state.SetBalance(addr, big.NewInt(int64(11*i)+int64(tweak)))
@@ -508,7 +525,13 @@ func TestCopyOfCopy(t *testing.T) {
 //
 // See https://github.com/classzz/go-classzz-v2/issues/20106.
 func TestCopyCommitCopy(t *testing.T) {
-	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	for _, scheme := range testSchemes {
+		testCopyCommitCopy(t, scheme)
+	}
+}
+
+func testCopyCommitCopy(t *testing.T, scheme string) {
+	state, _ := New(common.Hash{}, newTestDatabase(rawdb.NewMemoryDatabase(), scheme), nil)
 
 	// Create an account and check if the retrieved balance is correct
 	addr := common.HexToAddress("0xaffeaffeaffeaffeaffeaffeaffeaffeaffeaffe")
@@ -669,8 +692,14 @@ func TestCopyCopyCommitCopy(t *testing.T) {
 // each transaction, so this works ok. The rework accumulated writes in memory
 // first, but the journal wiped the entire state object on create-revert.
 func TestDeleteCreateRevert(t *testing.T) {
+	for _, scheme := range testSchemes {
+		testDeleteCreateRevert(t, scheme)
+	}
+}
+
+func testDeleteCreateRevert(t *testing.T, scheme string) {
 	// Create an initial state with a single contract
-	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	state, _ := New(common.Hash{}, newTestDatabase(rawdb.NewMemoryDatabase(), scheme), nil)
 
 	addr := common.BytesToAddress([]byte("so"))
 	state.SetBalance(addr, big.NewInt(1))
@@ -695,6 +724,88 @@ func TestDeleteCreateRevert(t *testing.T) {
 	}
 }
 
+// Tests that the storage of a recreated account is dropped, unless the recreation
+// is reverted, and that no stale storage is left behind in the database.
+func TestRecreateStorage(t *testing.T) {
+	for _, scheme := range testSchemes {
+		testRecreateStorage(t, scheme, false)
+		testRecreateStorage(t, scheme, true)
+	}
+}
+
+func testRecreateStorage(t *testing.T, scheme string, revert bool) {
+	var (
+		diskdb = rawdb.NewMemoryDatabase()
+		addr   = common.BytesToAddress([]byte("so"))
+		value  = common.HexToHash("0xff")
+	)
+	// Create an initial state with a contract holding a sizeable storage
+	state, _ := New(common.Hash{}, newTestDatabase(diskdb, scheme), nil)
+	state.SetBalance(addr, big.NewInt(1))
+	for i := byte(0); i < 32; i++ {
+		state.SetState(addr, common.Hash{i}, value)
+	}
+	root, _ := state.Commit(false)
+	if err := state.Database().TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("%s: failed to persist state: %v", scheme, err)
+	}
+	// Recreate the contract, optionally reverting it, and store a new slot
+	state, _ = New(root, state.db, nil)
+	id := state.Snapshot()
+	state.CreateAccount(addr)
+	if revert {
+		state.RevertToSnapshot(id)
+	}
+	state.SetState(addr, common.Hash{0xff}, value)
+
+	root, _ = state.Commit(true)
+	if err := state.Database().TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("%s: failed to persist state: %v", scheme, err)
+	}
+	// Reopen the persisted state and check the storage contents
+	state, _ = New(root, newTestDatabase(diskdb, scheme), nil)
+	for i := byte(0); i < 32; i++ {
+		want := common.Hash{}
+		if revert {
+			want = value
+		}
+		if have := state.GetState(addr, common.Hash{i}); have != want {
+			t.Fatalf("%s, revert %v: slot %d mismatch: have %x, want %x", scheme, revert, i, have, want)
+		}
+	}
+	if have := state.GetState(addr, common.Hash{0xff}); have != value {
+		t.Fatalf("%s, revert %v: new slot mismatch: have %x, want %x", scheme, revert, have, value)
+	}
+	if err := state.Error(); err != nil {
+		t.Fatalf("%s, revert %v: failed to read state: %v", scheme, revert, err)
+	}
+	// The path scheme keeps a single version of the storage, ensure nothing
+	// of the old one is left behind
+	if scheme != rawdb.PathScheme {
+		return
+	}
+	obj := state.getStateObject(addr)
+	tr, err := state.Database().OpenStorageTrie(obj.addrHash, obj.data.Root)
+	if err != nil {
+		t.Fatalf("failed to open storage trie: %v", err)
+	}
+	var want int
+	for it := tr.NodeIterator(nil); it.Next(true); {
+		if it.Hash() != (common.Hash{}) {
+			want++
+		}
+	}
+	var have int
+	it := rawdb.IterateStorageTrieNodes(diskdb, obj.addrHash)
+	for it.Next() {
+		have++
+	}
+	it.Release()
+	if have != want {
+		t.Fatalf("revert %v: storage node count mismatch: have %d, want %d", revert, have, want)
+	}
+}
+
 // TestMissingTrieNodes tests that if the StateDB fails to load parts of the trie,
 // the Commit operation fails with an error
 // If we are missing trie nodes, we should not continue writing to the trie
@@ -35,10 +35,17 @@ import (
 // StateProcessor implements Processor.
 type StateProcessor struct {
 	config *params.ChainConfig // Chain configuration options
-	bc     *BlockChain         // Canonical block chain
+	bc     processorChain      // Canonical block chain
 	engine consensus.Engine    // Consensus engine used for block rewards
 }
 
+// processorChain is the chain access needed to process blocks, satisfied by the
+// canonical block chain as well as by the headers of an execution witness.
+type processorChain interface {
+	ChainContext
+	consensus.ChainHeaderReader
+}
+
 // NewStateProcessor initialises a new StateProcessor.
 func NewStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consensus.Engine) *StateProcessor {
 	return &StateProcessor{
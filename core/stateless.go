@@ -0,0 +1,205 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/consensus"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/stateless"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/classzz/go-classzz-v2/params"
+	"github.com/classzz/go-classzz-v2/trie"
+)
+
+// ExecutionWitness executes a block on top of its parent state, recording every
+// trie node, contract code and ancestor header accessed, TeWaka state included.
+// The returned witness is enough to verify the block with ExecuteStateless.
+//
+// The TeWaka item records, checked against replays by mint and convert and
+// filled at the end of every dump epoch, are kept outside of the state trie and
+// can't be witnessed. Blocks accessing them fail with state.ErrRecordsUnwitnessed.
+func (bc *BlockChain) ExecutionWitness(block *types.Block) (*stateless.Witness, error) {
+	parent := bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	witness := stateless.NewWitness(parent)
+
+	// The snapshot is bypassed, as its reads would skip recording the trie nodes
+	statedb, err := state.New(parent.Root, state.NewRecordingDatabase(bc.stateCache, witness), nil)
+	if err != nil {
+		return nil, err
+	}
+	processor := &StateProcessor{
+		config: bc.chainConfig,
+		bc:     &recordingChain{BlockChain: bc, witness: witness},
+		engine: bc.engine,
+	}
+	receipts, _, usedGas, err := processor.Process(block, statedb, vm.Config{})
+	if err == nil {
+		err = bc.validator.ValidateState(block, statedb, receipts, usedGas)
+	}
+	if dbErr := statedb.Error(); dbErr != nil {
+		return nil, dbErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return witness, nil
+}
+
+// ExecuteStateless executes a block on top of the state held by its witness and
+// validates the resulting state root, receipts and gas usage. The witness is
+// authenticated by the parent hash of the block, the header itself is trusted:
+// verifying its seal and its position in the chain is left to the caller. Like
+// for ExecutionWitness, blocks accessing the TeWaka item records are refused.
+func ExecuteStateless(config *params.ChainConfig, engine consensus.Engine, block *types.Block, witness *stateless.Witness) error {
+	if len(witness.Headers) == 0 {
+		return errors.New("witness without parent header")
+	}
+	parent := witness.Parent()
+	if parent.Hash() != block.ParentHash() || parent.Number.Uint64()+1 != block.NumberU64() {
+		return fmt.Errorf("witness parent mismatch: have #%d [%x], want #%d [%x]",
+			parent.Number, parent.Hash(), block.NumberU64()-1, block.ParentHash())
+	}
+	if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != block.TxHash() {
+		return fmt.Errorf("transaction root hash mismatch: have %x, want %x", hash, block.TxHash())
+	}
+	statedb, err := state.New(parent.Root, witness.StateDatabase(), nil)
+	if err != nil {
+		return fmt.Errorf("incomplete witness: %v", err)
+	}
+	processor := &StateProcessor{
+		config: config,
+		bc:     newWitnessChain(config, engine, witness),
+		engine: engine,
+	}
+	receipts, _, usedGas, err := processor.Process(block, statedb, vm.Config{})
+	if err == nil {
+		err = NewBlockValidator(config, nil, engine).ValidateState(block, statedb, receipts, usedGas)
+	}
+	// Missing trie nodes are swallowed during the execution and make any result
+	// meaningless, so report them first
+	if dbErr := statedb.Error(); dbErr == state.ErrRecordsUnwitnessed {
+		return dbErr
+	} else if dbErr != nil {
+		return fmt.Errorf("incomplete witness: %v", dbErr)
+	}
+	return err
+}
+
+// recordingChain is a block chain adding the headers retrieved through it to an
+// execution witness.
+type recordingChain struct {
+	*BlockChain
+	witness *stateless.Witness
+}
+
+func (c *recordingChain) record(header *types.Header) *types.Header {
+	if header != nil {
+		c.witness.AddHeader(header)
+	}
+	return header
+}
+
+// GetHeader retrieves a block header by hash and number, recording it.
+func (c *recordingChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return c.record(c.BlockChain.GetHeader(hash, number))
+}
+
+// GetHeaderByHash retrieves a block header by hash, recording it.
+func (c *recordingChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	return c.record(c.BlockChain.GetHeaderByHash(hash))
+}
+
+// GetHeaderByNumber retrieves a canonical block header by number, recording it.
+func (c *recordingChain) GetHeaderByNumber(number uint64) *types.Header {
+	return c.record(c.BlockChain.GetHeaderByNumber(number))
+}
+
+// witnessChain serves the headers of an execution witness. Only the headers
+// linked to the parent one through their hashes are served, so they are all
+// authenticated by the executed block.
+type witnessChain struct {
+	config  *params.ChainConfig
+	engine  consensus.Engine
+	parent  *types.Header
+	headers map[common.Hash]*types.Header
+}
+
+func newWitnessChain(config *params.ChainConfig, engine consensus.Engine, witness *stateless.Witness) *witnessChain {
+	chain := &witnessChain{
+		config:  config,
+		engine:  engine,
+		parent:  witness.Parent(),
+		headers: make(map[common.Hash]*types.Header),
+	}
+	// Only keep the ancestors of the parent, linked to it by their hashes
+	for header := chain.parent; header != nil; {
+		chain.headers[header.Hash()] = header
+
+		var next *types.Header
+		for _, ancestor := range witness.Headers {
+			if ancestor.Hash() == header.ParentHash && ancestor.Number.Uint64()+1 == header.Number.Uint64() {
+				next = ancestor
+				break
+			}
+		}
+		header = next
+	}
+	return chain
+}
+
+// Config retrieves the chain configuration.
+func (c *witnessChain) Config() *params.ChainConfig { return c.config }
+
+// Engine retrieves the consensus engine.
+func (c *witnessChain) Engine() consensus.Engine { return c.engine }
+
+// CurrentHeader retrieves the parent header of the executed block.
+func (c *witnessChain) CurrentHeader() *types.Header { return c.parent }
+
+// GetHeader retrieves a witnessed header by hash and number.
+func (c *witnessChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c.headers[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+// GetHeaderByHash retrieves a witnessed header by hash.
+func (c *witnessChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	return c.headers[hash]
+}
+
+// GetHeaderByNumber retrieves a witnessed ancestor of the executed block by
+// number.
+func (c *witnessChain) GetHeaderByNumber(number uint64) *types.Header {
+	for header := c.parent; header != nil; header = c.headers[header.ParentHash] {
+		if n := header.Number.Uint64(); n == number {
+			return header
+		} else if n < number {
+			break
+		}
+	}
+	return nil
+}
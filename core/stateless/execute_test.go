@@ -0,0 +1,112 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package stateless_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/consensus/ethash"
+	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/stateless"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/params"
+	"github.com/classzz/go-classzz-v2/rlp"
+)
+
+var (
+	testKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddr   = crypto.PubkeyToAddress(testKey.PublicKey)
+)
+
+// Tests that the witness of a chain block, round tripped through its encoding,
+// is enough to execute the block statelessly, and that tampered witnesses are
+// refused.
+func TestExecuteStateless(t *testing.T) {
+	config := *params.TestChainConfig
+	config.CIP_4, config.CIP_5 = big.NewInt(1000000), big.NewInt(1000000)
+
+	var (
+		signer  = types.LatestSigner(&config)
+		alloc   = core.GenesisAlloc{testAddr: {Balance: big.NewInt(params.Ether)}}
+		db      = rawdb.NewMemoryDatabase()
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = (&core.Genesis{Config: &config, Alloc: alloc}).MustCommit(db)
+	)
+	(&core.Genesis{Config: &config, Alloc: alloc}).MustCommit(gendb)
+	blocks, _ := core.GenerateChain(&config, genesis, ethash.NewFaker(), gendb, 4, func(i int, gen *core.BlockGen) {
+		for j := 0; j <= i; j++ {
+			tx, err := types.SignTx(types.NewTransaction(gen.TxNonce(testAddr), common.Address{byte(j)}, big.NewInt(1), params.TxGas, gen.BaseFee(), nil), signer, testKey)
+			if err != nil {
+				t.Fatalf("failed to sign transaction: %v", err)
+			}
+			gen.AddTx(tx)
+		}
+	})
+	chain, err := core.NewBlockChain(db, nil, &config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block #%d: %v", n, err)
+	}
+	block := blocks[len(blocks)-1]
+	witness, err := chain.ExecutionWitness(block)
+	if err != nil {
+		t.Fatalf("failed to create witness: %v", err)
+	}
+	enc, err := rlp.EncodeToBytes(witness)
+	if err != nil {
+		t.Fatalf("failed to encode witness: %v", err)
+	}
+	decode := func() *stateless.Witness {
+		dec := new(stateless.Witness)
+		if err := rlp.DecodeBytes(enc, dec); err != nil {
+			t.Fatalf("failed to decode witness: %v", err)
+		}
+		return dec
+	}
+	if err := core.ExecuteStateless(&config, ethash.NewFaker(), block, decode()); err != nil {
+		t.Fatalf("failed to execute block statelessly: %v", err)
+	}
+	// A witness missing any trie node must be refused
+	dec := decode()
+	for hash, node := range dec.State {
+		delete(dec.State, hash)
+		if err := core.ExecuteStateless(&config, ethash.NewFaker(), block, dec); err == nil {
+			t.Fatalf("witness without node %x accepted", hash)
+		}
+		dec.State[hash] = node
+	}
+	// A witness with a forged parent must be refused
+	dec = decode()
+	dec.Headers[0].Root = blocks[0].Root()
+	if err := core.ExecuteStateless(&config, ethash.NewFaker(), block, dec); err == nil {
+		t.Fatalf("witness with forged parent accepted")
+	}
+	// A block with a forged transaction set must be refused
+	forged := types.NewBlockWithHeader(block.Header()).WithBody(blocks[2].Transactions())
+	if err := core.ExecuteStateless(&config, ethash.NewFaker(), forged, decode()); err == nil {
+		t.Fatalf("block with forged transactions accepted")
+	}
+}
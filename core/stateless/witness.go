@@ -0,0 +1,179 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package stateless implements the execution witness of a block, holding all the
+// data needed to execute the block on top of its parent without the chain state.
+package stateless
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/rlp"
+	"github.com/classzz/go-classzz-v2/trie"
+)
+
+// Witness holds the data accessed while executing a block: the headers of the
+// ancestors, the contract codes and the state trie nodes, covering the account
+// and storage tries alike. All of them are authenticated by the parent header,
+// either through the header hash chain or through the state root.
+type Witness struct {
+	Headers []*types.Header        // Parent header first, followed by the accessed ancestors
+	Codes   map[common.Hash][]byte // Accessed contract codes, keyed by hash
+	State   map[common.Hash][]byte // Accessed trie nodes, keyed by hash
+
+	lock sync.Mutex
+}
+
+// NewWitness creates an empty witness for executing a block on top of parent.
+func NewWitness(parent *types.Header) *Witness {
+	return &Witness{
+		Headers: []*types.Header{parent},
+		Codes:   make(map[common.Hash][]byte),
+		State:   make(map[common.Hash][]byte),
+	}
+}
+
+// Parent returns the header of the block the witnessed block is executed on.
+func (w *Witness) Parent() *types.Header {
+	return w.Headers[0]
+}
+
+// AddHeader adds an ancestor header accessed during the execution, e.g. by the
+// BLOCKHASH opcode.
+func (w *Witness) AddHeader(header *types.Header) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	hash := header.Hash()
+	for _, have := range w.Headers {
+		if have.Hash() == hash {
+			return
+		}
+	}
+	w.Headers = append(w.Headers, header)
+}
+
+// RecordNode implements trie.NodeRecorder, adding an accessed trie node.
+func (w *Witness) RecordNode(hash common.Hash, blob []byte) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.State[hash] = common.CopyBytes(blob)
+}
+
+// RecordCode implements state.Recorder, adding an accessed contract code.
+func (w *Witness) RecordCode(hash common.Hash, code []byte) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.Codes[hash] = common.CopyBytes(code)
+}
+
+// StateDatabase creates an in-memory state database holding the witnessed trie
+// nodes and codes, which the block can be executed against. The TeWaka item
+// records are not witnessed, accessing them fails the execution.
+func (w *Witness) StateDatabase() state.Database {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	db := rawdb.NewMemoryDatabase()
+	for hash, blob := range w.State {
+		rawdb.WriteTrieNode(db, hash, blob)
+	}
+	for hash, code := range w.Codes {
+		rawdb.WriteCode(db, hash, code)
+	}
+	return state.NewWitnessDatabase(state.NewDatabaseWithConfig(db, &trie.Config{Scheme: rawdb.HashScheme}))
+}
+
+// extWitness is the compact RLP encoding of a witness. The codes and trie nodes
+// are encoded without their hashes, the trie nodes as a multiproof.
+type extWitness struct {
+	Headers []*types.Header
+	Codes   [][]byte
+	Proof   []byte
+}
+
+// EncodeRLP implements rlp.Encoder.
+func (w *Witness) EncodeRLP(out io.Writer) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	ext := &extWitness{Headers: make([]*types.Header, len(w.Headers))}
+	copy(ext.Headers, w.Headers)
+
+	// Order the ancestors from the newest one, so the encoding is deterministic
+	sort.SliceStable(ext.Headers[1:], func(i, j int) bool {
+		return ext.Headers[1+i].Number.Cmp(ext.Headers[1+j].Number) > 0
+	})
+	ext.Codes = sortedValues(w.Codes)
+
+	proof, err := trie.EncodeMultiProof(sortedValues(w.State))
+	if err != nil {
+		return err
+	}
+	ext.Proof = proof
+	return rlp.Encode(out, ext)
+}
+
+// DecodeRLP implements rlp.Decoder.
+func (w *Witness) DecodeRLP(s *rlp.Stream) error {
+	var ext extWitness
+	if err := s.Decode(&ext); err != nil {
+		return err
+	}
+	if len(ext.Headers) == 0 {
+		return errors.New("witness without parent header")
+	}
+	nodes, err := trie.DecodeMultiProof(ext.Proof)
+	if err != nil {
+		return err
+	}
+	w.Headers = ext.Headers
+	w.Codes = make(map[common.Hash][]byte, len(ext.Codes))
+	for _, code := range ext.Codes {
+		w.Codes[crypto.Keccak256Hash(code)] = code
+	}
+	w.State = make(map[common.Hash][]byte, len(nodes))
+	for _, node := range nodes {
+		w.State[crypto.Keccak256Hash(node)] = node
+	}
+	return nil
+}
+
+// sortedValues returns the values of a hash keyed set, ordered by their keys.
+func sortedValues(set map[common.Hash][]byte) [][]byte {
+	hashes := make([]common.Hash, 0, len(set))
+	for hash := range set {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+
+	values := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		values[i] = set[hash]
+	}
+	return values
+}
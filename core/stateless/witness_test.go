@@ -0,0 +1,195 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package stateless
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/rlp"
+)
+
+// teWaka is the account holding the TeWaka state in the tests.
+var teWaka = common.BytesToAddress([]byte{0xff})
+
+// makeTestState creates a state with a number of accounts, some of them holding
+// code and storage, and a TeWaka account, returning its database and root.
+func makeTestState(t *testing.T) (state.Database, common.Hash) {
+	db := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, _ := state.New(common.Hash{}, db, nil)
+	for i := byte(0); i < 100; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		statedb.SetBalance(addr, big.NewInt(int64(i)))
+		if i%10 == 0 {
+			statedb.SetCode(addr, []byte{i, 0x60, 0x00})
+			for j := byte(0); j < 20; j++ {
+				statedb.SetState(addr, common.Hash{j}, common.Hash{i, j})
+			}
+		}
+	}
+	statedb.SetBalance(teWaka, big.NewInt(1))
+	for j := byte(0); j < 20; j++ {
+		statedb.SetTeWakaState(teWaka, common.Hash{j}, []byte{0xff, j})
+	}
+	root, err := statedb.Commit(true)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if err := db.TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to commit trie: %v", err)
+	}
+	return db, root
+}
+
+// accessState reads and modifies a few accounts, code, storage slots and TeWaka
+// entries, and returns the resulting state root.
+func accessState(db state.Database, root common.Hash) (common.Hash, error) {
+	statedb, err := state.New(root, db, nil)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	for i := byte(0); i < 100; i += 7 {
+		addr := common.BytesToAddress([]byte{i})
+		statedb.AddBalance(addr, big.NewInt(1))
+		statedb.GetCodeSize(addr)
+	}
+	contract := common.BytesToAddress([]byte{20})
+	if code := statedb.GetCode(contract); !bytes.Equal(code, []byte{20, 0x60, 0x00}) {
+		return common.Hash{}, fmt.Errorf("code mismatch: have %x", code)
+	}
+	if value := statedb.GetState(contract, common.Hash{3}); value != (common.Hash{20, 3}) {
+		return common.Hash{}, fmt.Errorf("storage mismatch: have %x", value)
+	}
+	if value := statedb.GetTeWakaState(teWaka, common.Hash{5}); !bytes.Equal(value, []byte{0xff, 5}) {
+		return common.Hash{}, fmt.Errorf("TeWaka state mismatch: have %x", value)
+	}
+	statedb.SetTeWakaState(teWaka, common.Hash{6}, []byte{0xee})
+	statedb.SetState(contract, common.Hash{4}, common.Hash{})
+	statedb.SetState(contract, common.Hash{30}, common.Hash{1})
+	statedb.Suicide(common.BytesToAddress([]byte{50}))
+
+	root = statedb.IntermediateRoot(true)
+	return root, statedb.Error()
+}
+
+// Tests that the witness recorded while accessing a state is enough to repeat
+// the accesses without it, both directly and after an encoding round trip.
+func TestWitnessRecording(t *testing.T) {
+	db, root := makeTestState(t)
+
+	parent := &types.Header{Number: big.NewInt(1), Root: root, Difficulty: big.NewInt(1)}
+	ancestor := &types.Header{Number: big.NewInt(0), Difficulty: big.NewInt(1)}
+
+	witness := NewWitness(parent)
+	witness.AddHeader(ancestor)
+	witness.AddHeader(ancestor)
+	want, err := accessState(state.NewRecordingDatabase(db, witness), root)
+	if err != nil {
+		t.Fatalf("failed to access state: %v", err)
+	}
+
+	if len(witness.Headers) != 2 {
+		t.Fatalf("header count mismatch: have %d, want 2", len(witness.Headers))
+	}
+	if len(witness.Codes) == 0 || len(witness.State) == 0 {
+		t.Fatalf("nothing recorded: %d codes, %d nodes", len(witness.Codes), len(witness.State))
+	}
+	if have, err := accessState(witness.StateDatabase(), root); err != nil || have != want {
+		t.Fatalf("root mismatch: have %x, %v, want %x", have, err, want)
+	}
+	// Round trip the witness through its compact encoding
+	enc, err := rlp.EncodeToBytes(witness)
+	if err != nil {
+		t.Fatalf("failed to encode witness: %v", err)
+	}
+	var dec Witness
+	if err := rlp.DecodeBytes(enc, &dec); err != nil {
+		t.Fatalf("failed to decode witness: %v", err)
+	}
+	if dec.Parent().Hash() != parent.Hash() || len(dec.Headers) != 2 || dec.Headers[1].Hash() != ancestor.Hash() {
+		t.Fatalf("headers mismatch")
+	}
+	if len(dec.Codes) != len(witness.Codes) || len(dec.State) != len(witness.State) {
+		t.Fatalf("witness size mismatch: have %d codes, %d nodes, want %d, %d",
+			len(dec.Codes), len(dec.State), len(witness.Codes), len(witness.State))
+	}
+	for hash, node := range witness.State {
+		if !bytes.Equal(dec.State[hash], node) {
+			t.Fatalf("node %x mismatch", hash)
+		}
+	}
+	if have, err := accessState(dec.StateDatabase(), root); err != nil || have != want {
+		t.Fatalf("decoded root mismatch: have %x, %v, want %x", have, err, want)
+	}
+	// Re-encoding the decoded witness must be deterministic
+	if reenc, _ := rlp.EncodeToBytes(&dec); !bytes.Equal(reenc, enc) {
+		t.Fatalf("re-encoded witness mismatch")
+	}
+}
+
+// Tests that a witness missing any of the accessed trie nodes is detected.
+func TestWitnessIncomplete(t *testing.T) {
+	db, root := makeTestState(t)
+
+	witness := NewWitness(&types.Header{Number: big.NewInt(1), Root: root})
+	if _, err := accessState(state.NewRecordingDatabase(db, witness), root); err != nil {
+		t.Fatalf("failed to access state: %v", err)
+	}
+	for hash, node := range witness.State {
+		delete(witness.State, hash)
+		if _, err := accessState(witness.StateDatabase(), root); err == nil {
+			t.Fatalf("missing node %x not detected", hash)
+		}
+		witness.State[hash] = node
+	}
+}
+
+// Tests that the TeWaka item records, not covered by any state root, can't be
+// accessed while recording a witness nor while executing against one.
+func TestWitnessRecords(t *testing.T) {
+	db, root := makeTestState(t)
+	witness := NewWitness(&types.Header{Number: big.NewInt(1), Root: root})
+	if _, err := accessState(state.NewRecordingDatabase(db, witness), root); err != nil {
+		t.Fatalf("failed to access state: %v", err)
+	}
+	for _, db := range []state.Database{state.NewRecordingDatabase(db, witness), witness.StateDatabase()} {
+		statedb, err := state.New(root, db, nil)
+		if err != nil {
+			t.Fatalf("failed to open state: %v", err)
+		}
+		if statedb.HasRecord(1, common.Hash{1}) {
+			t.Fatalf("unwitnessed record reported")
+		}
+		if err := statedb.Error(); err != state.ErrRecordsUnwitnessed {
+			t.Fatalf("record lookup error mismatch: have %v, want %v", err, state.ErrRecordsUnwitnessed)
+		}
+		statedb, _ = state.New(root, db, nil)
+		statedb.WriteRecord(1, common.Hash{1})
+		if err := statedb.Error(); err != state.ErrRecordsUnwitnessed {
+			t.Fatalf("record write error mismatch: have %v, want %v", err, state.ErrRecordsUnwitnessed)
+		}
+	}
+	if rawdb.HasRecord(db.TrieDB().DiskDB(), 1, common.Hash{1}) {
+		t.Fatalf("record written through the recording database")
+	}
+}
@@ -109,6 +109,7 @@ var (
 	knownTxMeter       = metrics.NewRegisteredMeter("txpool/known", nil)
 	validTxMeter       = metrics.NewRegisteredMeter("txpool/valid", nil)
 	invalidTxMeter     = metrics.NewRegisteredMeter("txpool/invalid", nil)
+	rejectedTxMeter    = metrics.NewRegisteredMeter("txpool/rejected", nil) // Dropped by a TxValidator
 	underpricedTxMeter = metrics.NewRegisteredMeter("txpool/underpriced", nil)
 	overflowedTxMeter  = metrics.NewRegisteredMeter("txpool/overflowed", nil)
 
@@ -237,6 +238,9 @@ type TxPool struct {
 	cip1 bool
 	cip4 bool
 
+	pendingNumber *big.Int      // Number of the block pending transactions are validated for
+	validators    []TxValidator // Additional admission checks, see AddValidator
+
 	currentState  *state.StateDB // Current state in the blockchain head
 	pendingNonces *txNoncer      // Pending state tracking virtual nonces
 	currentMaxGas uint64         // Current gas limit for transaction caps
@@ -426,6 +430,15 @@ func (pool *TxPool) GasPrice() *big.Int {
 	return new(big.Int).Set(pool.gasPrice)
 }
 
+// AddValidator registers an additional check run on every transaction entering
+// the pool. Transactions already in the pool are not revalidated.
+func (pool *TxPool) AddValidator(validator TxValidator) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.validators = append(pool.validators, validator)
+}
+
 // SetGasPrice updates the minimum price required by the transaction pool for a
 // new transaction, and drops all transactions below this threshold.
 func (pool *TxPool) SetGasPrice(price *big.Int) {
@@ -633,6 +646,13 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	if tx.Gas() < intrGas {
 		return ErrIntrinsicGas
 	}
+	// Run the pluggable checks last, they may be more expensive
+	for _, validator := range pool.validators {
+		if err := validator.ValidateTx(tx, from, pool.currentState, pool.pendingNumber); err != nil {
+			rejectedTxMeter.Mark(1)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -1173,6 +1193,12 @@ func (pool *TxPool) runReorg(done chan struct{}, reset *txpoolResetRequest, dirt
 		highestPending := list.LastElement()
 		pool.pendingNonces.set(addr, highestPending.Nonce()+1)
 	}
+	// Drop the views of the chain cached by the validators during this cycle
+	for _, validator := range pool.validators {
+		if resetter, ok := validator.(TxValidatorResetter); ok {
+			resetter.Reset()
+		}
+	}
 	pool.mu.Unlock()
 
 	// Notify subsystems for newly added transactions
@@ -1280,6 +1306,7 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	next := new(big.Int).Add(newHead.Number, big.NewInt(1))
 	pool.cip1 = pool.chainconfig.IsCIP1(next)
 	pool.cip4 = pool.chainconfig.IsCIP4(next)
+	pool.pendingNumber = next
 	//pool.eip2718 = pool.chainconfig.IsBerlin(next)
 	//pool.eip1559 = pool.chainconfig.IsLondon(next)
 }
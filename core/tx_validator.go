@@ -0,0 +1,127 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/metrics"
+	"github.com/classzz/go-classzz-v2/params"
+)
+
+// TxValidator is an additional admission check of the transaction pool. It is
+// run after the built-in validation with the sender of the transaction and the
+// pool's view of the chain: the state at the current head and the number of
+// the block pending transactions are validated for. The state must be treated
+// as read-only.
+type TxValidator interface {
+	ValidateTx(tx *types.Transaction, from common.Address, state *state.StateDB, number *big.Int) error
+}
+
+// TxValidatorResetter is implemented by the validators caching a view of the
+// chain between admissions. The pool resets them at the end of every
+// reorganisation, after the reset to a new head and the promotion of the
+// queued transactions.
+type TxValidatorResetter interface {
+	TxValidator
+	Reset()
+}
+
+// PendingStateFn returns the pending block and a copy of the state after it.
+type PendingStateFn func() (*types.Block, *state.StateDB)
+
+// TeWakaValidator rejects calls to the TeWaka precompile that are bound to
+// revert, so that spam can't fill blocks with failing conversions.
+type TeWakaValidator struct {
+	config  *params.ChainConfig
+	pending PendingStateFn // Source of the pending state, nil to use the head state
+
+	snapshot *state.StateDB // Pending state shared by the calls of a pool cycle
+	number   *big.Int       // Number of the block the snapshot is pending for
+	taken    bool           // Whether the snapshot was taken in this pool cycle
+	lock     sync.Mutex
+}
+
+// NewTeWakaValidator creates a TeWaka pool validator for the given chain. The
+// calls are validated against the pending state if a source is given, falling
+// back to the head state of the pool if the pending block is not built on it.
+// The pending state is retrieved once per pool cycle, see Reset.
+func NewTeWakaValidator(config *params.ChainConfig, pending PendingStateFn) *TeWakaValidator {
+	return &TeWakaValidator{config: config, pending: pending}
+}
+
+// ValidateTx implements TxValidator, running vm.ValidateTeWaka on every
+// transaction sent to the TeWaka precompile.
+func (v *TeWakaValidator) ValidateTx(tx *types.Transaction, from common.Address, state *state.StateDB, number *big.Int) error {
+	if to := tx.To(); to == nil || *to != vm.TeWaKaAddress {
+		return nil
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// Prefer the state after the pending block, so the calls conflicting with
+	// the transactions already included in it are caught and the ones enabled
+	// by them are admitted
+	if pending := v.pendingState(number); pending != nil {
+		state = pending
+	}
+	method, err := vm.ValidateTeWaka(state, v.config, number, from, tx.Data())
+	if err != nil {
+		if method == "" {
+			method = "unknown"
+		}
+		metrics.GetOrRegisterMeter("txpool/tewaka/"+method, nil).Mark(1)
+		log.Trace("Rejected TeWaka transaction", "hash", tx.Hash(), "method", method, "err", err)
+		return fmt.Errorf("tewaka %s: %w", method, err)
+	}
+	return nil
+}
+
+// Reset implements TxValidatorResetter, dropping the pending state snapshot so
+// the next call is validated against a fresh one.
+func (v *TeWakaValidator) Reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.snapshot, v.number, v.taken = nil, nil, false
+}
+
+// pendingState returns the pending state snapshot of the current pool cycle,
+// taking it on first use, or nil if the pending block is not the one the calls
+// are validated for. The lock must be held.
+func (v *TeWakaValidator) pendingState(number *big.Int) *state.StateDB {
+	if v.pending == nil {
+		return nil
+	}
+	if !v.taken {
+		v.taken = true
+		if block, pending := v.pending(); block != nil && pending != nil {
+			v.snapshot, v.number = pending, block.Number()
+		}
+	}
+	if v.snapshot == nil || v.number.Cmp(number) != 0 {
+		return nil
+	}
+	return v.snapshot
+}
@@ -1003,6 +1003,15 @@ func (c *tewaka) RequiredGas(evm *EVM, input []byte) uint64 {
 	if err != nil {
 		return baseGas
 	}
+	if isGuardianMethod(method.Name) && !evm.chainConfig.IsTeWakaGuardians(evm.Context.BlockNumber) {
+		return baseGas
+	}
+	if isGovernanceMethod(method.Name) && !evm.chainConfig.IsTeWakaGovernance(evm.Context.BlockNumber) {
+		return baseGas
+	}
+	if key, ok := gasParams[method.Name]; ok {
+		return tewakaParams(evm.chainConfig, evm.StateDB, evm.Context.BlockNumber).Uint64(key)
+	}
 	if gas, ok := TeWaKaGas[method.Name]; ok {
 		return gas
 	} else {
@@ -38,6 +38,19 @@ var (
 	ErrStakingInvalidInput        = errors.New("invalid input for staking")
 	ErrTxhashAlreadyInput         = errors.New("verifyConvertEthereumTypeTx txid has already convert")
 	ErrStakingInsufficientBalance = errors.New("insufficient balance for staking transfer")
+	ErrTeWakaPaused               = errors.New("tewaka: network paused")
+	ErrTeWakaNetworkLimit         = errors.New("tewaka: network volume limit exceeded")
+	ErrTeWakaBlockLimit           = errors.New("tewaka: block volume limit exceeded")
+	ErrNoGuardians                = errors.New("tewaka: no guardians configured")
+	ErrNotGuardian                = errors.New("tewaka: caller is not a guardian")
+	ErrGuardianApproved           = errors.New("tewaka: guardian already approved")
+	ErrNoGovernors                = errors.New("tewaka: no governors configured")
+	ErrNotGovernor                = errors.New("tewaka: caller is not a governor")
+	ErrGovernorApproved           = errors.New("tewaka: governor already approved")
+	ErrUnknownParam               = errors.New("tewaka: unknown parameter")
+	ErrInvalidParam               = errors.New("tewaka: invalid parameter value")
+	ErrParamNotScheduled          = errors.New("tewaka: no parameter change scheduled")
+	ErrParamNotDue                = errors.New("tewaka: parameter change not due")
 )
 
 // ErrStackUnderflow wraps an evm error when the items on the stack less
@@ -93,6 +93,13 @@ var TeWaKaGas = map[string]uint64{
 	"convert":  2400000,
 	"confirm":  2400000,
 	"casting":  2400000,
+
+	"pause":     360000,
+	"unpause":   360000,
+	"setLimits": 360000,
+
+	"proposeParam": 360000,
+	"enactParam":   360000,
 }
 
 // Staking contract ABI
@@ -160,6 +167,10 @@ func RunStaking(evm *EVM, contract *Contract, input []byte) (ret []byte, err err
 		ret, err = crossToMainChainMap(evm, contract, data)
 	case "betweenSideChainCrossMap":
 		ret, err = betweenSideChainCrossMap(evm, contract, data)
+	case "pause", "unpause", "setLimits":
+		ret, err = guardianAction(evm, contract, method.Name, data)
+	case "proposeParam", "enactParam":
+		ret, err = governanceAction(evm, contract, method.Name, data)
 	default:
 		log.Debug("Staking call fallback function")
 		err = ErrStakingInvalidInput
@@ -167,6 +178,9 @@ func RunStaking(evm *EVM, contract *Contract, input []byte) (ret []byte, err err
 
 	if err != nil {
 		log.Debug("Staking error code", "method.Name", method.Name, "err", err)
+		if isBreakerError(err) || isParamError(err) {
+			ret = packRevert(err.Error())
+		}
 		err = ErrExecutionReverted
 	}
 
@@ -218,8 +232,10 @@ func mortgage(evm *EVM, contract *Contract, input []byte) (ret []byte, err error
 		return nil, err
 	}
 
+	limits := tewakaParams(evm.chainConfig, evm.StateDB, evm.Context.BlockNumber)
+
 	//
-	if args.StakingAmount.Cmp(mimStakingAmount) < 0 {
+	if args.StakingAmount.Cmp(limits.Get(ParamMinStakingAmount)) < 0 {
 		return nil, fmt.Errorf("mortgage StakingAmount %s", "StakingAmount <  emimState")
 	}
 
@@ -229,9 +245,8 @@ func mortgage(evm *EVM, contract *Contract, input []byte) (ret []byte, err error
 	}
 
 	//
-	ToAddressNum := new(big.Int).SetBytes(args.ToAddress.Bytes())
-	if ToAddressNum.Cmp(MortgageToMin) < 0 && ToAddressNum.Cmp(MortgageToMax) > 1 {
-		return nil, fmt.Errorf("mortgage ToAddressNum %s", "MortgageToMax > ToAddressNum > MortgageToMin")
+	if err := checkMortgageTo(evm.chainConfig, evm.Context.BlockNumber, limits, args.ToAddress); err != nil {
+		return nil, err
 	}
 
 	//
@@ -245,8 +260,8 @@ func mortgage(evm *EVM, contract *Contract, input []byte) (ret []byte, err error
 	}
 
 	//
-	if len(args.CoinBaseAddress) > 16 {
-		return nil, fmt.Errorf("mortgage CoinBaseAddress %s", "len(CoinBaseAddress) > 16")
+	if uint64(len(args.CoinBaseAddress)) > limits.Uint64(ParamMaxCoinbases) {
+		return nil, fmt.Errorf("mortgage CoinBaseAddress len(CoinBaseAddress) > %d", limits.Uint64(ParamMaxCoinbases))
 	}
 
 	t2 := time.Now()
@@ -319,9 +334,11 @@ func update(evm *EVM, contract *Contract, input []byte) (ret []byte, err error)
 		return nil, fmt.Errorf("update GetStakeUser %s", "from is nil")
 	}
 
+	limits := tewakaParams(evm.chainConfig, evm.StateDB, evm.Context.BlockNumber)
+
 	if args.StakingAmount.Cmp(big.NewInt(0)) > 0 {
 		//
-		if args.StakingAmount.Cmp(mimStakingAmount) < 0 {
+		if args.StakingAmount.Cmp(limits.Get(ParamMinStakingAmount)) < 0 {
 			return nil, fmt.Errorf("update StakingAmount %s", "StakingAmount <  emimState")
 		}
 
@@ -334,8 +351,8 @@ func update(evm *EVM, contract *Contract, input []byte) (ret []byte, err error)
 	}
 
 	//
-	if len(args.CoinBaseAddress) > 16 {
-		log.Error("CoinBaseAddress > 16 ", "error", err)
+	if uint64(len(args.CoinBaseAddress)) > limits.Uint64(ParamMaxCoinbases) {
+		log.Error("Too many coinbase addresses", "have", len(args.CoinBaseAddress), "max", limits.Uint64(ParamMaxCoinbases))
 		return nil, err
 	}
 
@@ -405,7 +422,7 @@ func convert(evm *EVM, contract *Contract, input []byte) (ret []byte, err error)
 	var item *types.ConvertItem
 	AssetType := uint8(args.AssetType.Uint64())
 
-	if exit := tewaka.HasItem(&types.UsedItem{AssetType, TxHash}, evm.StateDB); exit {
+	if exit := tewaka.HasItem(&types.UsedItem{Atype: AssetType, TxHash: TxHash}, evm.StateDB); exit {
 		return nil, ErrTxhashAlreadyInput
 	}
 
@@ -418,6 +435,14 @@ func convert(evm *EVM, contract *Contract, input []byte) (ret []byte, err error)
 	item.FeeAmount = big.NewInt(0).Div(item.Amount, big.NewInt(1000))
 	IDHash := item.Hash()
 	item.ID = new(big.Int).SetBytes(IDHash[:10])
+
+	breaker, err := loadBreaker(evm, item.AssetType, item.ConvertType)
+	if err != nil {
+		return nil, err
+	}
+	if err := breaker.trackVolume(evm, Amount, item.AssetType, item.ConvertType); err != nil {
+		return nil, err
+	}
 	t2 := time.Now()
 
 	if item.ConvertType == ExpandedTxConvert_Czz {
@@ -438,7 +463,7 @@ func convert(evm *EVM, contract *Contract, input []byte) (ret []byte, err error)
 		tewaka.Convert(item)
 	}
 
-	tewaka.SetItem(&types.UsedItem{AssetType, TxHash})
+	tewaka.SetItem(&types.UsedItem{Atype: AssetType, TxHash: TxHash})
 
 	t3 := time.Now()
 	err = tewaka.Save(evm.StateDB, TeWaKaAddress)
@@ -499,10 +524,14 @@ func confirm(evm *EVM, contract *Contract, input []byte) (ret []byte, err error)
 	var item *types.ConvertItem
 	ConvertType := uint8(args.ConvertType.Uint64())
 
-	if exit := tewaka.HasItem(&types.UsedItem{ConvertType, TxHash}, evm.StateDB); exit {
+	if exit := tewaka.HasItem(&types.UsedItem{Atype: ConvertType, TxHash: TxHash}, evm.StateDB); exit {
 		return nil, ErrTxhashAlreadyInput
 	}
 
+	if _, err := loadBreaker(evm, ConvertType); err != nil {
+		return nil, err
+	}
+
 	isCip2 := evm.chainConfig.IsCIP2(evm.Context.BlockNumber)
 
 	if item, err = verifyConfirmEthereumTypeTx("Side", tewaka, ConvertType, TxHash, isCip2); err != nil {
@@ -512,7 +541,7 @@ func confirm(evm *EVM, contract *Contract, input []byte) (ret []byte, err error)
 	t2 := time.Now()
 
 	tewaka.Confirm(item)
-	tewaka.SetItem(&types.UsedItem{ConvertType, TxHash})
+	tewaka.SetItem(&types.UsedItem{Atype: ConvertType, TxHash: TxHash})
 
 	t3 := time.Now()
 	err = tewaka.Save(evm.StateDB, TeWaKaAddress)
@@ -618,6 +647,14 @@ func casting(evm *EVM, contract *Contract, input []byte) (ret []byte, err error)
 		return nil, fmt.Errorf("%w: address %v have %v want %v", errors.New("insufficient funds for gas * price + value"), from, have, want)
 	}
 
+	breaker, err := loadBreaker(evm, ConvertType)
+	if err != nil {
+		return nil, err
+	}
+	if err := breaker.trackVolume(evm, item.Amount, ConvertType); err != nil {
+		return nil, err
+	}
+
 	evm.StateDB.SubBalance(from, args.Amount)
 	evm.StateDB.AddBalance(CoinPools[ConvertType], new(big.Int).Sub(item.Amount, item.FeeAmount))
 	evm.StateDB.AddBalance(Address0, item.FeeAmount)
@@ -1005,7 +1042,7 @@ func verifyCrossToMainTxV3(evm *EVM, amounts *big.Int, burnHash common.Hash, fro
 	var extTx *types.Transaction
 	// Get the current block count.
 	if err := client.Call(&extTx, "eth_getTransactionByHash", burnHash); err != nil {
-		return fmt.Errorf("verifyConvertEthereumTypeTx (%s) getTransactionByHash [txid:%s] err: %s", fromNetworkType, extTx, err)
+		return fmt.Errorf("verifyConvertEthereumTypeTx (%s) getTransactionByHash [txid:%s] err: %s", fromNetworkType, burnHash, err)
 	}
 
 	if err := CheckToAddress(uint8(fromNetworkType.Uint64()), fromNetworkType.String(), extTx); err != nil {
@@ -1550,7 +1587,203 @@ const TeWakaABI = `
 		"outputs": [],
 		"stateMutability": "payable",
 		"type": "function"
-	}
+	},
+    {
+        "name":"guardianApprove",
+        "inputs":[
+            {
+                "type":"bytes32",
+                "name":"proposal"
+            },
+            {
+                "type":"uint256",
+                "name":"approvals"
+            }
+        ],
+        "anonymous":false,
+        "type":"event"
+    },
+    {
+        "name":"pause",
+        "inputs":[
+            {
+                "type":"uint256",
+                "name":"convertType"
+            }
+        ],
+        "anonymous":false,
+        "type":"event"
+    },
+    {
+        "name":"pause",
+        "outputs":[
+
+        ],
+        "inputs":[
+            {
+                "type":"uint256",
+                "name":"convertType"
+            }
+        ],
+        "constant":false,
+        "payable":false,
+        "type":"function"
+    },
+    {
+        "name":"unpause",
+        "inputs":[
+            {
+                "type":"uint256",
+                "name":"convertType"
+            }
+        ],
+        "anonymous":false,
+        "type":"event"
+    },
+    {
+        "name":"unpause",
+        "outputs":[
+
+        ],
+        "inputs":[
+            {
+                "type":"uint256",
+                "name":"convertType"
+            }
+        ],
+        "constant":false,
+        "payable":false,
+        "type":"function"
+    },
+    {
+        "name":"setLimits",
+        "inputs":[
+            {
+                "type":"uint256",
+                "name":"convertType"
+            },
+            {
+                "type":"uint256",
+                "name":"networkLimit"
+            },
+            {
+                "type":"uint256",
+                "name":"blockLimit"
+            }
+        ],
+        "anonymous":false,
+        "type":"event"
+    },
+    {
+        "name":"setLimits",
+        "outputs":[
+
+        ],
+        "inputs":[
+            {
+                "type":"uint256",
+                "name":"convertType"
+            },
+            {
+                "type":"uint256",
+                "name":"networkLimit"
+            },
+            {
+                "type":"uint256",
+                "name":"blockLimit"
+            }
+        ],
+        "constant":false,
+        "payable":false,
+        "type":"function"
+    },
+    {
+        "name":"governorApprove",
+        "inputs":[
+            {
+                "type":"bytes32",
+                "name":"proposal"
+            },
+            {
+                "type":"uint256",
+                "name":"approvals"
+            }
+        ],
+        "anonymous":false,
+        "type":"event"
+    },
+    {
+        "name":"paramScheduled",
+        "inputs":[
+            {
+                "type":"uint256",
+                "name":"key"
+            },
+            {
+                "type":"uint256",
+                "name":"value"
+            },
+            {
+                "type":"uint256",
+                "name":"enactAt"
+            }
+        ],
+        "anonymous":false,
+        "type":"event"
+    },
+    {
+        "name":"paramChanged",
+        "inputs":[
+            {
+                "type":"uint256",
+                "name":"key"
+            },
+            {
+                "type":"uint256",
+                "name":"oldValue"
+            },
+            {
+                "type":"uint256",
+                "name":"newValue"
+            }
+        ],
+        "anonymous":false,
+        "type":"event"
+    },
+    {
+        "name":"proposeParam",
+        "outputs":[
+
+        ],
+        "inputs":[
+            {
+                "type":"uint256",
+                "name":"key"
+            },
+            {
+                "type":"uint256",
+                "name":"value"
+            }
+        ],
+        "constant":false,
+        "payable":false,
+        "type":"function"
+    },
+    {
+        "name":"enactParam",
+        "outputs":[
+
+        ],
+        "inputs":[
+            {
+                "type":"uint256",
+                "name":"key"
+            }
+        ],
+        "constant":false,
+        "payable":false,
+        "type":"function"
+    }
 ]
 `
 
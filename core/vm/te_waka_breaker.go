@@ -0,0 +1,356 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/classzz/go-classzz-v2/accounts/abi"
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/params"
+	"github.com/classzz/go-classzz-v2/rlp"
+)
+
+var (
+	// circuitBreakerKey is the TeWaka storage slot holding the breaker state. It
+	// lives next to the TeWakaImpl blob, so chains without guardian activity
+	// keep their state root untouched.
+	circuitBreakerKey = common.BytesToHash([]byte("circuitbreaker"))
+
+	// revertSelector is the selector of Error(string), used to give reverted
+	// breaker calls a readable reason.
+	revertSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+	// errAlreadyApproved is returned by approve for duplicate approvals and
+	// mapped to the guardian or governor error by the callers.
+	errAlreadyApproved = errors.New("already approved")
+
+	// proposalLifetime is the number of blocks the approvals of a proposal stay
+	// valid, about three days at 15 second blocks. Approvals reaching the
+	// threshold later have to be given again.
+	proposalLifetime uint64 = 17280
+)
+
+// NetworkBreaker is the circuit breaker state of a single ExpandedTxConvert_*
+// network. Zero limits mean unlimited.
+type NetworkBreaker struct {
+	ConvertType  uint8
+	Paused       bool
+	NetworkLimit *big.Int // Total volume allowed until the guardians set new limits
+	NetworkUsed  *big.Int // Volume moved since the limits were last set
+	BlockLimit   *big.Int // Volume allowed within a single block
+	BlockNumber  uint64   // Block BlockUsed was accumulated in
+	BlockUsed    *big.Int // Volume moved in BlockNumber
+}
+
+// GuardianProposal collects the guardian approvals of a pending action. The
+// proposal hash commits to the full call input, so guardians have to agree on
+// the exact same parameters. The approvals lapse after block Expiry.
+type GuardianProposal struct {
+	Hash      common.Hash
+	Approvals []common.Address
+	Expiry    uint64 `rlp:"optional"`
+}
+
+// CircuitBreaker is the TeWaka circuit breaker state.
+type CircuitBreaker struct {
+	Networks  []*NetworkBreaker
+	Proposals []*GuardianProposal
+}
+
+// Load reads the breaker state from the TeWaka account, leaving an empty
+// breaker if it was never written.
+func (cb *CircuitBreaker) Load(state StateDB, preAddress common.Address) error {
+	data := state.GetTeWakaState(preAddress, circuitBreakerKey)
+	if len(data) == 0 {
+		cb.Networks, cb.Proposals = nil, nil
+		return nil
+	}
+	if err := rlp.DecodeBytes(data, cb); err != nil {
+		log.Error("Invalid CircuitBreaker entry RLP", "err", err)
+		return fmt.Errorf("Invalid CircuitBreaker entry RLP %s", err.Error())
+	}
+	return nil
+}
+
+// Save writes the breaker state to the TeWaka account.
+func (cb *CircuitBreaker) Save(state StateDB, preAddress common.Address) error {
+	data, err := rlp.EncodeToBytes(cb)
+	if err != nil {
+		log.Crit("Failed to RLP encode CircuitBreaker", "err", err)
+	}
+	state.SetTeWakaState(preAddress, circuitBreakerKey, data)
+	return err
+}
+
+// Network returns the breaker of a network, or nil if none was configured.
+func (cb *CircuitBreaker) Network(convertType uint8) *NetworkBreaker {
+	for _, v := range cb.Networks {
+		if v.ConvertType == convertType {
+			return v
+		}
+	}
+	return nil
+}
+
+func (cb *CircuitBreaker) network(convertType uint8) *NetworkBreaker {
+	if nb := cb.Network(convertType); nb != nil {
+		return nb
+	}
+	nb := &NetworkBreaker{
+		ConvertType:  convertType,
+		NetworkLimit: new(big.Int),
+		NetworkUsed:  new(big.Int),
+		BlockLimit:   new(big.Int),
+		BlockUsed:    new(big.Int),
+	}
+	cb.Networks = append(cb.Networks, nb)
+	return nb
+}
+
+// CheckPaused returns an error if any of the given networks is paused.
+func (cb *CircuitBreaker) CheckPaused(convertTypes ...uint8) error {
+	for _, convertType := range convertTypes {
+		if nb := cb.Network(convertType); nb != nil && nb.Paused {
+			return fmt.Errorf("%w: network %d", ErrTeWakaPaused, convertType)
+		}
+	}
+	return nil
+}
+
+// AddVolume accounts amount against the limits of a network, failing if
+// either the network or the per-block limit would be exceeded.
+func (cb *CircuitBreaker) AddVolume(convertType uint8, number uint64, amount *big.Int) error {
+	nb := cb.Network(convertType)
+	if nb == nil {
+		return nil
+	}
+	blockUsed := new(big.Int)
+	if nb.BlockNumber == number {
+		blockUsed.Set(nb.BlockUsed)
+	}
+	blockUsed.Add(blockUsed, amount)
+	if nb.BlockLimit.Sign() > 0 && blockUsed.Cmp(nb.BlockLimit) > 0 {
+		return fmt.Errorf("%w: network %d block volume %v limit %v", ErrTeWakaBlockLimit, convertType, blockUsed, nb.BlockLimit)
+	}
+	networkUsed := new(big.Int).Add(nb.NetworkUsed, amount)
+	if nb.NetworkLimit.Sign() > 0 && networkUsed.Cmp(nb.NetworkLimit) > 0 {
+		return fmt.Errorf("%w: network %d volume %v limit %v", ErrTeWakaNetworkLimit, convertType, networkUsed, nb.NetworkLimit)
+	}
+	nb.BlockNumber, nb.BlockUsed, nb.NetworkUsed = number, blockUsed, networkUsed
+	return nil
+}
+
+// trackVolume accounts a conversion against the limits of the networks it moves
+// funds in and out of, and persists the result. Networks without breaker state
+// are left untouched.
+func (cb *CircuitBreaker) trackVolume(evm *EVM, amount *big.Int, convertTypes ...uint8) error {
+	tracked := false
+	for i, convertType := range convertTypes {
+		if cb.Network(convertType) == nil || (i > 0 && convertType == convertTypes[i-1]) {
+			continue
+		}
+		if err := cb.AddVolume(convertType, evm.Context.BlockNumber.Uint64(), amount); err != nil {
+			return err
+		}
+		tracked = true
+	}
+	if !tracked {
+		return nil
+	}
+	return cb.Save(evm.StateDB, TeWaKaAddress)
+}
+
+// Approve records the approval of a guardian for a proposal at block number and
+// returns the number of approvals from the current guardian set. Once the
+// threshold is met the proposal is dropped and the caller should enact it.
+func (cb *CircuitBreaker) Approve(config *params.GuardianConfig, hash common.Hash, guardian common.Address, number uint64) (int, bool, error) {
+	proposals, approvals, enact, err := approve(cb.Proposals, hash, guardian, config.IsGuardian, config.Threshold, number)
+	if err != nil {
+		return 0, false, ErrGuardianApproved
+	}
+	cb.Proposals = proposals
+	return approvals, enact, nil
+}
+
+// approve adds the approval of member at block number to the proposal hash,
+// counting only the approvals of addresses isMember still accepts. Proposals
+// past their expiry are dropped first, so their approvals have to be given
+// again. It returns the updated proposal list, without the proposal if
+// threshold was met, consuming its approvals.
+func approve(proposals []*GuardianProposal, hash common.Hash, member common.Address, isMember func(common.Address) bool, threshold uint64, number uint64) ([]*GuardianProposal, int, bool, error) {
+	var (
+		live     = make([]*GuardianProposal, 0, len(proposals)+1)
+		proposal *GuardianProposal
+	)
+	for _, v := range proposals {
+		if v.Expiry < number {
+			continue
+		}
+		live = append(live, v)
+		if v.Hash == hash {
+			proposal = v
+		}
+	}
+	proposals = live
+	if proposal == nil {
+		proposal = &GuardianProposal{Hash: hash, Expiry: number + proposalLifetime}
+		proposals = append(proposals, proposal)
+	}
+	approvals := 0
+	for _, v := range proposal.Approvals {
+		if v == member {
+			return proposals, 0, false, errAlreadyApproved
+		}
+		if isMember(v) {
+			approvals++
+		}
+	}
+	proposal.Approvals = append(proposal.Approvals, member)
+	approvals++
+
+	if uint64(approvals) < threshold {
+		return proposals, approvals, false, nil
+	}
+	for i, v := range proposals {
+		if v == proposal {
+			proposals = append(proposals[:i], proposals[i+1:]...)
+			break
+		}
+	}
+	return proposals, approvals, true, nil
+}
+
+// isGuardianMethod reports whether name is one of the guardian only methods,
+// which are only priced from the circuit breaker fork on.
+func isGuardianMethod(name string) bool {
+	return name == "pause" || name == "unpause" || name == "setLimits"
+}
+
+// isBreakerError reports whether err should be surfaced as a revert reason.
+func isBreakerError(err error) bool {
+	for _, target := range []error{ErrTeWakaPaused, ErrTeWakaNetworkLimit, ErrTeWakaBlockLimit, ErrNotGuardian, ErrGuardianApproved, ErrNoGuardians} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// packRevert encodes reason as Error(string) revert data.
+func packRevert(reason string) []byte {
+	typ, _ := abi.NewType("string", "", nil)
+	data, err := (abi.Arguments{{Type: typ}}).Pack(reason)
+	if err != nil {
+		return nil
+	}
+	return append(common.CopyBytes(revertSelector), data...)
+}
+
+// loadBreaker loads the breaker state and checks that none of the networks
+// involved in a conversion is paused. Before the circuit breaker fork the
+// state isn't read, leaving the networks unpaused and unlimited.
+func loadBreaker(evm *EVM, convertTypes ...uint8) (*CircuitBreaker, error) {
+	return breakerAt(evm.chainConfig, evm.StateDB, evm.Context.BlockNumber, convertTypes...)
+}
+
+// breakerAt loads the breaker state in effect at block number and checks that
+// none of the given networks is paused.
+func breakerAt(config *params.ChainConfig, state StateDB, number *big.Int, convertTypes ...uint8) (*CircuitBreaker, error) {
+	cb := new(CircuitBreaker)
+	if !config.IsTeWakaGuardians(number) {
+		return cb, nil
+	}
+	if err := cb.Load(state, TeWaKaAddress); err != nil {
+		return nil, err
+	}
+	return cb, cb.CheckPaused(convertTypes...)
+}
+
+// guardianAction runs pause, unpause and setLimits. Every call counts as the
+// approval of the calling guardian; the action is applied when the configured
+// threshold is reached.
+func guardianAction(evm *EVM, contract *Contract, name string, input []byte) (ret []byte, err error) {
+	config := evm.chainConfig.TeWakaGuardians
+	if !evm.chainConfig.IsTeWakaGuardians(evm.Context.BlockNumber) || config.Threshold == 0 || config.Threshold > uint64(len(config.Guardians)) {
+		return nil, ErrNoGuardians
+	}
+	from := contract.caller.Address()
+	if !config.IsGuardian(from) {
+		return nil, fmt.Errorf("%w: %v", ErrNotGuardian, from)
+	}
+
+	method := AbiTeWaKa.Methods[name]
+	args, err := method.Inputs.Unpack(input)
+	if err != nil {
+		log.Error("Unpack guardian input error", "err", err)
+		return nil, ErrStakingInvalidInput
+	}
+	convertType := args[0].(*big.Int)
+	if !convertType.IsUint64() || convertType.Uint64() > uint64(ExpandedTxConvert_GCzz) {
+		return nil, ErrStakingInvalidInput
+	}
+
+	cb := new(CircuitBreaker)
+	if err := cb.Load(evm.StateDB, TeWaKaAddress); err != nil {
+		return nil, err
+	}
+	proposal := crypto.Keccak256Hash(method.ID, input)
+	approvals, enact, err := cb.Approve(config, proposal, from, evm.Context.BlockNumber.Uint64())
+	if err != nil {
+		return nil, err
+	}
+
+	event := AbiTeWaKa.Events["guardianApprove"]
+	logData, err := event.Inputs.Pack(proposal, big.NewInt(int64(approvals)))
+	if err != nil {
+		log.Error("Pack guardian log error", "error", err)
+		return nil, err
+	}
+	logN(evm, contract, []common.Hash{event.ID, common.BytesToHash(from[:])}, logData)
+
+	if enact {
+		nb := cb.network(uint8(convertType.Uint64()))
+		switch name {
+		case "pause":
+			nb.Paused = true
+		case "unpause":
+			nb.Paused = false
+		case "setLimits":
+			nb.NetworkLimit = new(big.Int).Set(args[1].(*big.Int))
+			nb.BlockLimit = new(big.Int).Set(args[2].(*big.Int))
+			nb.NetworkUsed = new(big.Int)
+		}
+		event := AbiTeWaKa.Events[name]
+		logData, err := event.Inputs.Pack(args...)
+		if err != nil {
+			log.Error("Pack guardian log error", "error", err)
+			return nil, err
+		}
+		logN(evm, contract, []common.Hash{event.ID, common.BytesToHash(from[:])}, logData)
+		log.Info("TeWaka circuit breaker updated", "action", name, "network", nb.ConvertType, "number", evm.Context.BlockNumber)
+	}
+	if err := cb.Save(evm.StateDB, TeWaKaAddress); err != nil {
+		log.Error("Circuit breaker save state error", "error", err)
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/accounts/abi"
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/params"
+	"github.com/classzz/go-classzz-v2/rlp"
+)
+
+func TestCircuitBreakerApprove(t *testing.T) {
+	var (
+		g1, g2, g3 = common.Address{1}, common.Address{2}, common.Address{3}
+		config     = &params.GuardianConfig{Guardians: []common.Address{g1, g2, g3}, Threshold: 2}
+		proposal   = common.Hash{0xaa}
+		cb         = new(CircuitBreaker)
+	)
+	if n, enact, err := cb.Approve(config, proposal, g1, 1); err != nil || enact || n != 1 {
+		t.Fatalf("first approval: have %d %v %v, want 1 false nil", n, enact, err)
+	}
+	if _, _, err := cb.Approve(config, proposal, g1, 1); err != ErrGuardianApproved {
+		t.Fatalf("duplicate approval: have %v, want %v", err, ErrGuardianApproved)
+	}
+	if n, enact, err := cb.Approve(config, proposal, g2, 2); err != nil || !enact || n != 2 {
+		t.Fatalf("second approval: have %d %v %v, want 2 true nil", n, enact, err)
+	}
+	if len(cb.Proposals) != 0 {
+		t.Fatalf("enacted proposal not dropped: %d left", len(cb.Proposals))
+	}
+	// Consumed approvals don't count towards the next action
+	if n, enact, err := cb.Approve(config, proposal, g2, 3); err != nil || enact || n != 1 {
+		t.Fatalf("approval after enactment: have %d %v %v, want 1 false nil", n, enact, err)
+	}
+	// Approvals of removed guardians don't count towards the threshold
+	cb.Proposals = nil
+	cb.Approve(config, proposal, g1, 3)
+	config.Guardians = []common.Address{g2, g3}
+	if n, enact, _ := cb.Approve(config, proposal, g2, 3); enact || n != 1 {
+		t.Fatalf("stale approval counted: have %d %v, want 1 false", n, enact)
+	}
+}
+
+func TestCircuitBreakerApproveExpiry(t *testing.T) {
+	var (
+		g1, g2   = common.Address{1}, common.Address{2}
+		config   = &params.GuardianConfig{Guardians: []common.Address{g1, g2}, Threshold: 2}
+		proposal = common.Hash{0xaa}
+		cb       = new(CircuitBreaker)
+	)
+	cb.Approve(config, proposal, g1, 10)
+	cb.Approve(config, common.Hash{0xbb}, g1, 10)
+
+	// An approval combined with one given after the expiry must not enact
+	if n, enact, err := cb.Approve(config, proposal, g2, 11+proposalLifetime); err != nil || enact || n != 1 {
+		t.Fatalf("expired approval counted: have %d %v %v, want 1 false nil", n, enact, err)
+	}
+	if len(cb.Proposals) != 1 || cb.Proposals[0].Expiry != 11+2*proposalLifetime {
+		t.Fatalf("expired proposals not dropped: %+v", cb.Proposals)
+	}
+	if n, enact, err := cb.Approve(config, proposal, g1, 11+2*proposalLifetime); err != nil || !enact || n != 2 {
+		t.Fatalf("approval within lifetime: have %d %v %v, want 2 true nil", n, enact, err)
+	}
+}
+
+// Tests that a conversion is accounted against both the network it leaves and
+// the one it is paid out on.
+func TestCircuitBreakerTrackVolume(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	evm := NewEVM(BlockContext{BlockNumber: big.NewInt(1)}, TxContext{}, statedb, &params.ChainConfig{}, Config{})
+
+	cb := new(CircuitBreaker)
+	cb.network(ExpandedTxConvert_ECzz).BlockLimit = big.NewInt(100)
+	cb.network(ExpandedTxConvert_HCzz).BlockLimit = big.NewInt(50)
+
+	if err := cb.trackVolume(evm, big.NewInt(40), ExpandedTxConvert_ECzz, ExpandedTxConvert_HCzz); err != nil {
+		t.Fatalf("volume within limits rejected: %v", err)
+	}
+	if used := cb.Network(ExpandedTxConvert_HCzz).BlockUsed; used.Int64() != 40 {
+		t.Fatalf("destination volume mismatch: have %v, want 40", used)
+	}
+	if err := cb.trackVolume(evm, big.NewInt(20), ExpandedTxConvert_ECzz, ExpandedTxConvert_HCzz); !errors.Is(err, ErrTeWakaBlockLimit) {
+		t.Fatalf("destination limit: have %v, want %v", err, ErrTeWakaBlockLimit)
+	}
+	saved := new(CircuitBreaker)
+	if err := saved.Load(statedb, TeWaKaAddress); err != nil {
+		t.Fatalf("failed to load breaker: %v", err)
+	}
+	if used := saved.Network(ExpandedTxConvert_HCzz).BlockUsed; used.Int64() != 40 {
+		t.Fatalf("saved destination volume mismatch: have %v, want 40", used)
+	}
+}
+
+// Tests that the breaker state is only enforced from the circuit breaker fork
+// on, so replaying older blocks isn't affected by guardians configured later.
+func TestCircuitBreakerFork(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	cb := new(CircuitBreaker)
+	cb.network(ExpandedTxConvert_ECzz).Paused = true
+	if err := cb.Save(statedb, TeWaKaAddress); err != nil {
+		t.Fatalf("failed to save breaker: %v", err)
+	}
+	config := &params.ChainConfig{
+		TeWakaGuardians:     &params.GuardianConfig{Guardians: []common.Address{{1}}, Threshold: 1},
+		TeWakaGuardianBlock: big.NewInt(10),
+	}
+	if _, err := breakerAt(config, statedb, big.NewInt(9), ExpandedTxConvert_ECzz); err != nil {
+		t.Fatalf("network paused before the fork: %v", err)
+	}
+	if _, err := breakerAt(config, statedb, big.NewInt(10), ExpandedTxConvert_ECzz); !errors.Is(err, ErrTeWakaPaused) {
+		t.Fatalf("paused network after the fork: have %v, want %v", err, ErrTeWakaPaused)
+	}
+	config.TeWakaGuardianBlock = nil
+	if _, err := breakerAt(config, statedb, big.NewInt(10), ExpandedTxConvert_ECzz); err != nil {
+		t.Fatalf("network paused without the fork: %v", err)
+	}
+}
+
+func TestCircuitBreakerLimits(t *testing.T) {
+	cb := new(CircuitBreaker)
+	if err := cb.AddVolume(ExpandedTxConvert_ECzz, 1, big.NewInt(1000)); err != nil {
+		t.Fatalf("untracked network limited: %v", err)
+	}
+	nb := cb.network(ExpandedTxConvert_ECzz)
+	nb.NetworkLimit, nb.BlockLimit = big.NewInt(100), big.NewInt(60)
+
+	if err := cb.AddVolume(ExpandedTxConvert_ECzz, 1, big.NewInt(50)); err != nil {
+		t.Fatalf("volume within limits rejected: %v", err)
+	}
+	if err := cb.AddVolume(ExpandedTxConvert_ECzz, 1, big.NewInt(20)); !errors.Is(err, ErrTeWakaBlockLimit) {
+		t.Fatalf("block limit: have %v, want %v", err, ErrTeWakaBlockLimit)
+	}
+	if err := cb.AddVolume(ExpandedTxConvert_ECzz, 2, big.NewInt(40)); err != nil {
+		t.Fatalf("block volume not reset: %v", err)
+	}
+	if err := cb.AddVolume(ExpandedTxConvert_ECzz, 3, big.NewInt(20)); !errors.Is(err, ErrTeWakaNetworkLimit) {
+		t.Fatalf("network limit: have %v, want %v", err, ErrTeWakaNetworkLimit)
+	}
+	if nb.NetworkUsed.Int64() != 90 {
+		t.Fatalf("network volume mismatch: have %v, want 90", nb.NetworkUsed)
+	}
+
+	nb.Paused = true
+	if err := cb.CheckPaused(ExpandedTxConvert_Czz, ExpandedTxConvert_ECzz); !errors.Is(err, ErrTeWakaPaused) {
+		t.Fatalf("paused network: have %v, want %v", err, ErrTeWakaPaused)
+	}
+	if err := cb.CheckPaused(ExpandedTxConvert_HCzz); err != nil {
+		t.Fatalf("unpaused network: %v", err)
+	}
+}
+
+func TestCircuitBreakerRLP(t *testing.T) {
+	cb := new(CircuitBreaker)
+	nb := cb.network(ExpandedTxConvert_BCzz)
+	nb.Paused, nb.BlockLimit = true, big.NewInt(7)
+	cb.Proposals = []*GuardianProposal{{Hash: common.Hash{1}, Approvals: []common.Address{{2}}, Expiry: 3}}
+
+	data, err := rlp.EncodeToBytes(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := new(CircuitBreaker)
+	if err := rlp.DecodeBytes(data, dec); err != nil {
+		t.Fatal(err)
+	}
+	if have := dec.Network(ExpandedTxConvert_BCzz); have == nil || !have.Paused || have.BlockLimit.Int64() != 7 {
+		t.Fatalf("network mismatch: %+v", have)
+	}
+	if len(dec.Proposals) != 1 || dec.Proposals[0].Approvals[0] != (common.Address{2}) || dec.Proposals[0].Expiry != 3 {
+		t.Fatalf("proposal mismatch: %+v", dec.Proposals)
+	}
+}
+
+func TestPackRevert(t *testing.T) {
+	reason, err := abi.UnpackRevert(packRevert(ErrTeWakaPaused.Error()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != ErrTeWakaPaused.Error() {
+		t.Fatalf("reason mismatch: have %q, want %q", reason, ErrTeWakaPaused.Error())
+	}
+}
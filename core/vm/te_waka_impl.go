@@ -8,6 +8,7 @@ import (
 	"github.com/classzz/go-classzz-v2/core/types"
 	"github.com/classzz/go-classzz-v2/crypto"
 	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/params"
 	"github.com/classzz/go-classzz-v2/rlp"
 	lru "github.com/hashicorp/golang-lru"
 	"io"
@@ -265,8 +266,11 @@ func (twi *TeWakaImpl) isItemFromDB(item *types.UsedItem, state StateDB) bool {
 	return state.HasRecord(uint64(item.Atype), item.TxHash)
 }
 
-func ShiftItems(state StateDB, height uint64) error {
-	if height%DumpHeight == 0 {
+// ShiftItems moves the used items of the TeWaka state into the item records at
+// the end of every dump epoch, whose length is governed from the governance
+// fork on.
+func ShiftItems(config *params.ChainConfig, state StateDB, height uint64) error {
+	if height%tewakaParams(config, state, new(big.Int).SetUint64(height)).Uint64(ParamDumpHeight) == 0 {
 		twi := NewTeWakaImpl()
 		twi.Load(state, TeWaKaAddress)
 		defer twi.Save(state, TeWaKaAddress)
@@ -275,3 +279,48 @@ func ShiftItems(state StateDB, height uint64) error {
 	}
 	return nil
 }
+
+// Reserve is the backing of a single TeWaka network pool. All amounts are in
+// wei; Surplus turns negative when the pool can't cover its convert items.
+type Reserve struct {
+	ConvertType uint8
+	Pool        common.Address
+	Balance     *big.Int
+	Pending     *big.Int
+	Fees        *big.Int
+	Surplus     *big.Int
+}
+
+// Reserves compares the balance of every CoinPools entry with the convert
+// items still waiting to be paid out on its network. The pool is credited
+// with the item amount minus the fee, so that's what it has to cover. Items
+// created by convert carry side chain amounts, which are scaled like convert
+// does when crediting the pool.
+func (twi *TeWakaImpl) Reserves(state StateDB) []*Reserve {
+	reserves := make([]*Reserve, 0, len(CoinPools))
+	for convertType := ExpandedTxConvert_ECzz; convertType <= ExpandedTxConvert_GCzz; convertType++ {
+		pool := CoinPools[convertType]
+		reserve := &Reserve{
+			ConvertType: convertType,
+			Pool:        pool,
+			Balance:     new(big.Int).Set(state.GetBalance(pool)),
+			Pending:     new(big.Int),
+			Fees:        new(big.Int),
+		}
+		for _, item := range twi.ConvertItems {
+			if item.ConvertType != convertType {
+				continue
+			}
+			amount, fee := item.Amount, item.FeeAmount
+			if item.AssetType != ExpandedTxConvert_Czz {
+				amount, fee = new(big.Int).Mul(amount, Int10), new(big.Int).Mul(fee, Int10)
+			}
+			reserve.Pending.Add(reserve.Pending, amount)
+			reserve.Fees.Add(reserve.Fees, fee)
+		}
+		owed := new(big.Int).Sub(reserve.Pending, reserve.Fees)
+		reserve.Surplus = new(big.Int).Sub(reserve.Balance, owed)
+		reserves = append(reserves, reserve)
+	}
+	return reserves
+}
@@ -0,0 +1,382 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/params"
+	"github.com/classzz/go-classzz-v2/rlp"
+)
+
+// ParamKey identifies a governed TeWaka parameter.
+type ParamKey uint64
+
+const (
+	ParamMinStakingAmount ParamKey = iota + 1 // Minimum amount a mortgage has to stake, in wei
+	ParamMortgageToMin                        // Lowest mortgage target address number
+	ParamMortgageToMax                        // Highest mortgage target address number
+	ParamMaxCoinbases                         // Maximum number of coinbases of a pledge
+	ParamDumpHeight                           // Interval in blocks of dumping the used items
+	ParamGasMortgage                          // Gas charged for mortgage
+	ParamGasUpdate                            // Gas charged for update
+	ParamGasConvert                           // Gas charged for convert
+	ParamGasConfirm                           // Gas charged for confirm
+	ParamGasCasting                           // Gas charged for casting
+)
+
+// ParamKeys lists all governed parameters.
+var ParamKeys = []ParamKey{
+	ParamMinStakingAmount, ParamMortgageToMin, ParamMortgageToMax, ParamMaxCoinbases, ParamDumpHeight,
+	ParamGasMortgage, ParamGasUpdate, ParamGasConvert, ParamGasConfirm, ParamGasCasting,
+}
+
+var (
+	// paramStoreKey is the TeWaka storage slot holding the parameter store.
+	// Like the circuit breaker it is only written once governors act.
+	paramStoreKey = common.BytesToHash([]byte("paramstore"))
+
+	paramNames = map[ParamKey]string{
+		ParamMinStakingAmount: "minStakingAmount",
+		ParamMortgageToMin:    "mortgageToMin",
+		ParamMortgageToMax:    "mortgageToMax",
+		ParamMaxCoinbases:     "maxCoinbases",
+		ParamDumpHeight:       "dumpHeight",
+		ParamGasMortgage:      "gasMortgage",
+		ParamGasUpdate:        "gasUpdate",
+		ParamGasConvert:       "gasConvert",
+		ParamGasConfirm:       "gasConfirm",
+		ParamGasCasting:       "gasCasting",
+	}
+
+	// gasParams maps the methods with governed gas to their parameter.
+	gasParams = map[string]ParamKey{
+		"mortgage": ParamGasMortgage,
+		"update":   ParamGasUpdate,
+		"convert":  ParamGasConvert,
+		"confirm":  ParamGasConfirm,
+		"casting":  ParamGasCasting,
+	}
+
+	// maxCoinbases is the number of coinbases a pledge may list by default.
+	maxCoinbases uint64 = 16
+)
+
+// String implements fmt.Stringer.
+func (k ParamKey) String() string {
+	if name, ok := paramNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("param(%d)", uint64(k))
+}
+
+// Default returns the value of the parameter before governors changed it.
+func (k ParamKey) Default() *big.Int {
+	switch k {
+	case ParamMinStakingAmount:
+		return new(big.Int).Set(mimStakingAmount)
+	case ParamMortgageToMin:
+		return new(big.Int).Set(MortgageToMin)
+	case ParamMortgageToMax:
+		return new(big.Int).Set(MortgageToMax)
+	case ParamMaxCoinbases:
+		return new(big.Int).SetUint64(maxCoinbases)
+	case ParamDumpHeight:
+		return new(big.Int).SetUint64(DumpHeight)
+	}
+	for method, key := range gasParams {
+		if key == k {
+			return new(big.Int).SetUint64(TeWaKaGas[method])
+		}
+	}
+	return nil
+}
+
+// validate checks that value can be assigned to the parameter. Everything but
+// the staking amount is used as a uint64, and a zero dump height or gas would
+// break block processing.
+func (k ParamKey) validate(value *big.Int) error {
+	if _, ok := paramNames[k]; !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownParam, uint64(k))
+	}
+	if value.Sign() < 0 || (k != ParamMinStakingAmount && !value.IsUint64()) {
+		return fmt.Errorf("%w: %v %v", ErrInvalidParam, k, value)
+	}
+	if value.Sign() == 0 && k != ParamMortgageToMin && k != ParamMaxCoinbases {
+		return fmt.Errorf("%w: %v %v", ErrInvalidParam, k, value)
+	}
+	return nil
+}
+
+// ParamValue is the enacted value of a parameter.
+type ParamValue struct {
+	Key   uint64
+	Value *big.Int
+}
+
+// ParamChange is a parameter change approved by the governors, which can be
+// enacted from block EnactAt on.
+type ParamChange struct {
+	Key     uint64
+	Value   *big.Int
+	EnactAt uint64
+}
+
+// ParamStore holds the governed TeWaka parameters. Parameters without an
+// enacted value keep their default.
+type ParamStore struct {
+	Values    []*ParamValue
+	Scheduled []*ParamChange
+	Proposals []*GuardianProposal
+}
+
+// Load reads the parameter store from the TeWaka account, leaving an empty
+// store if it was never written.
+func (ps *ParamStore) Load(state StateDB, preAddress common.Address) error {
+	data := state.GetTeWakaState(preAddress, paramStoreKey)
+	if len(data) == 0 {
+		ps.Values, ps.Scheduled, ps.Proposals = nil, nil, nil
+		return nil
+	}
+	if err := rlp.DecodeBytes(data, ps); err != nil {
+		log.Error("Invalid ParamStore entry RLP", "err", err)
+		return fmt.Errorf("Invalid ParamStore entry RLP %s", err.Error())
+	}
+	return nil
+}
+
+// Save writes the parameter store to the TeWaka account.
+func (ps *ParamStore) Save(state StateDB, preAddress common.Address) error {
+	data, err := rlp.EncodeToBytes(ps)
+	if err != nil {
+		log.Crit("Failed to RLP encode ParamStore", "err", err)
+	}
+	state.SetTeWakaState(preAddress, paramStoreKey, data)
+	return err
+}
+
+// Get returns the current value of a parameter.
+func (ps *ParamStore) Get(key ParamKey) *big.Int {
+	for _, v := range ps.Values {
+		if v.Key == uint64(key) {
+			return new(big.Int).Set(v.Value)
+		}
+	}
+	return key.Default()
+}
+
+// Uint64 returns the current value of a parameter validated to fit a uint64.
+func (ps *ParamStore) Uint64(key ParamKey) uint64 {
+	return ps.Get(key).Uint64()
+}
+
+// Change returns the scheduled change of a parameter, or nil if none is.
+func (ps *ParamStore) Change(key ParamKey) *ParamChange {
+	for _, v := range ps.Scheduled {
+		if v.Key == uint64(key) {
+			return v
+		}
+	}
+	return nil
+}
+
+// Schedule replaces the scheduled change of a parameter.
+func (ps *ParamStore) Schedule(key ParamKey, value *big.Int, enactAt uint64) {
+	change := &ParamChange{Key: uint64(key), Value: new(big.Int).Set(value), EnactAt: enactAt}
+	for i, v := range ps.Scheduled {
+		if v.Key == uint64(key) {
+			ps.Scheduled[i] = change
+			return
+		}
+	}
+	ps.Scheduled = append(ps.Scheduled, change)
+}
+
+// Enact applies the scheduled change of a parameter if it is due at number,
+// returning the previous value.
+func (ps *ParamStore) Enact(key ParamKey, number uint64) (*big.Int, error) {
+	change := ps.Change(key)
+	if change == nil {
+		return nil, fmt.Errorf("%w: %v", ErrParamNotScheduled, key)
+	}
+	if number < change.EnactAt {
+		return nil, fmt.Errorf("%w: %v enactable at %d", ErrParamNotDue, key, change.EnactAt)
+	}
+	old := ps.Get(key)
+	for i, v := range ps.Scheduled {
+		if v == change {
+			ps.Scheduled = append(ps.Scheduled[:i], ps.Scheduled[i+1:]...)
+			break
+		}
+	}
+	for _, v := range ps.Values {
+		if v.Key == uint64(key) {
+			v.Value = change.Value
+			return old, nil
+		}
+	}
+	ps.Values = append(ps.Values, &ParamValue{Key: change.Key, Value: change.Value})
+	return old, nil
+}
+
+// LoadParams returns the parameter store of state. A corrupt store is logged
+// and treated as empty, falling back to the defaults.
+func LoadParams(state StateDB) *ParamStore {
+	ps := new(ParamStore)
+	if err := ps.Load(state, TeWaKaAddress); err != nil {
+		log.Error("Failed to load TeWaka parameters", "err", err)
+		return new(ParamStore)
+	}
+	return ps
+}
+
+// tewakaParams returns the parameter store in effect at block number. Before
+// the governance fork the store isn't read, so the original values apply even
+// to blocks replayed after governors were configured.
+func tewakaParams(config *params.ChainConfig, state StateDB, number *big.Int) *ParamStore {
+	if !config.IsTeWakaGovernance(number) {
+		return new(ParamStore)
+	}
+	return LoadParams(state)
+}
+
+// checkMortgageTo checks that the mortgage target address lies within the
+// governed range. The original check could never fail, and blocks before the
+// governance fork keep it that way, as changing it would break consensus on
+// them.
+func checkMortgageTo(config *params.ChainConfig, number *big.Int, limits *ParamStore, to common.Address) error {
+	if !config.IsTeWakaGovernance(number) {
+		return nil
+	}
+	num := new(big.Int).SetBytes(to.Bytes())
+	if min, max := limits.Get(ParamMortgageToMin), limits.Get(ParamMortgageToMax); num.Cmp(min) < 0 || num.Cmp(max) > 0 {
+		return fmt.Errorf("mortgage ToAddressNum %v outside [%v, %v]", num, min, max)
+	}
+	return nil
+}
+
+// isGovernanceMethod reports whether name is one of the governance methods,
+// which are only priced from the governance fork on.
+func isGovernanceMethod(name string) bool {
+	return name == "proposeParam" || name == "enactParam"
+}
+
+// isParamError reports whether err should be surfaced as a revert reason.
+func isParamError(err error) bool {
+	for _, target := range []error{ErrNoGovernors, ErrNotGovernor, ErrGovernorApproved, ErrUnknownParam, ErrInvalidParam, ErrParamNotScheduled, ErrParamNotDue} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// governanceAction runs proposeParam and enactParam. A proposal counts as the
+// approval of the calling governor and is scheduled once the threshold is
+// reached. Scheduled changes can be enacted by any governor after the
+// configured delay.
+func governanceAction(evm *EVM, contract *Contract, name string, input []byte) (ret []byte, err error) {
+	config := evm.chainConfig.TeWakaGovernors
+	if !evm.chainConfig.IsTeWakaGovernance(evm.Context.BlockNumber) || config.Threshold == 0 || config.Threshold > uint64(len(config.Governors)) {
+		return nil, ErrNoGovernors
+	}
+	from := contract.caller.Address()
+	if !config.IsGovernor(from) {
+		return nil, fmt.Errorf("%w: %v", ErrNotGovernor, from)
+	}
+
+	method := AbiTeWaKa.Methods[name]
+	args, err := method.Inputs.Unpack(input)
+	if err != nil {
+		log.Error("Unpack governance input error", "err", err)
+		return nil, ErrStakingInvalidInput
+	}
+	keyArg := args[0].(*big.Int)
+	if !keyArg.IsUint64() {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownParam, keyArg)
+	}
+	key := ParamKey(keyArg.Uint64())
+
+	ps := new(ParamStore)
+	if err := ps.Load(evm.StateDB, TeWaKaAddress); err != nil {
+		return nil, err
+	}
+	number := evm.Context.BlockNumber.Uint64()
+
+	switch name {
+	case "proposeParam":
+		value := args[1].(*big.Int)
+		if err := key.validate(value); err != nil {
+			return nil, err
+		}
+		proposal := crypto.Keccak256Hash(method.ID, input)
+		proposals, approvals, enact, err := approve(ps.Proposals, proposal, from, config.IsGovernor, config.Threshold, number)
+		if err != nil {
+			return nil, ErrGovernorApproved
+		}
+		ps.Proposals = proposals
+
+		event := AbiTeWaKa.Events["governorApprove"]
+		logData, err := event.Inputs.Pack(proposal, big.NewInt(int64(approvals)))
+		if err != nil {
+			log.Error("Pack governance log error", "error", err)
+			return nil, err
+		}
+		logN(evm, contract, []common.Hash{event.ID, common.BytesToHash(from[:])}, logData)
+
+		if enact {
+			enactAt := number + config.Delay
+			ps.Schedule(key, value, enactAt)
+
+			event := AbiTeWaKa.Events["paramScheduled"]
+			logData, err := event.Inputs.Pack(keyArg, value, new(big.Int).SetUint64(enactAt))
+			if err != nil {
+				log.Error("Pack governance log error", "error", err)
+				return nil, err
+			}
+			logN(evm, contract, []common.Hash{event.ID, common.BytesToHash(from[:])}, logData)
+			log.Info("TeWaka parameter change scheduled", "param", key, "value", value, "enactAt", enactAt)
+		}
+
+	case "enactParam":
+		if _, ok := paramNames[key]; !ok {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownParam, uint64(key))
+		}
+		old, err := ps.Enact(key, number)
+		if err != nil {
+			return nil, err
+		}
+		event := AbiTeWaKa.Events["paramChanged"]
+		logData, err := event.Inputs.Pack(keyArg, old, ps.Get(key))
+		if err != nil {
+			log.Error("Pack governance log error", "error", err)
+			return nil, err
+		}
+		logN(evm, contract, []common.Hash{event.ID, common.BytesToHash(from[:])}, logData)
+		log.Info("TeWaka parameter changed", "param", key, "old", old, "new", ps.Get(key), "number", number)
+	}
+	if err := ps.Save(evm.StateDB, TeWaKaAddress); err != nil {
+		log.Error("Parameter store save state error", "error", err)
+		return nil, err
+	}
+	return nil, nil
+}
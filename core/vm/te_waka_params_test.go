@@ -0,0 +1,175 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/params"
+	"github.com/classzz/go-classzz-v2/rlp"
+)
+
+func TestParamStoreDefaults(t *testing.T) {
+	ps := new(ParamStore)
+	if have := ps.Get(ParamMinStakingAmount); have.Cmp(mimStakingAmount) != 0 {
+		t.Errorf("min staking amount mismatch: have %v, want %v", have, mimStakingAmount)
+	}
+	if have := ps.Uint64(ParamMaxCoinbases); have != 16 {
+		t.Errorf("max coinbases mismatch: have %d, want 16", have)
+	}
+	if have := ps.Uint64(ParamGasConvert); have != TeWaKaGas["convert"] {
+		t.Errorf("convert gas mismatch: have %d, want %d", have, TeWaKaGas["convert"])
+	}
+	for _, key := range ParamKeys {
+		if key.Default() == nil {
+			t.Errorf("%v: missing default", key)
+		}
+		if err := key.validate(key.Default()); err != nil {
+			t.Errorf("%v: default rejected: %v", key, err)
+		}
+	}
+}
+
+func TestParamStoreEnact(t *testing.T) {
+	ps := new(ParamStore)
+	if _, err := ps.Enact(ParamDumpHeight, 10); !errors.Is(err, ErrParamNotScheduled) {
+		t.Fatalf("unscheduled enact: have %v, want %v", err, ErrParamNotScheduled)
+	}
+	ps.Schedule(ParamDumpHeight, big.NewInt(100), 20)
+	ps.Schedule(ParamDumpHeight, big.NewInt(200), 30)
+	if len(ps.Scheduled) != 1 {
+		t.Fatalf("schedule not replaced: %d changes", len(ps.Scheduled))
+	}
+	if _, err := ps.Enact(ParamDumpHeight, 29); !errors.Is(err, ErrParamNotDue) {
+		t.Fatalf("early enact: have %v, want %v", err, ErrParamNotDue)
+	}
+	old, err := ps.Enact(ParamDumpHeight, 30)
+	if err != nil {
+		t.Fatalf("enact failed: %v", err)
+	}
+	if old.Uint64() != DumpHeight || ps.Uint64(ParamDumpHeight) != 200 {
+		t.Fatalf("value mismatch: have %v -> %d, want %d -> 200", old, ps.Uint64(ParamDumpHeight), DumpHeight)
+	}
+	if ps.Change(ParamDumpHeight) != nil {
+		t.Fatalf("enacted change still scheduled")
+	}
+
+	data, err := rlp.EncodeToBytes(ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := new(ParamStore)
+	if err := rlp.DecodeBytes(data, dec); err != nil {
+		t.Fatal(err)
+	}
+	if dec.Uint64(ParamDumpHeight) != 200 {
+		t.Fatalf("decoded value mismatch: have %d, want 200", dec.Uint64(ParamDumpHeight))
+	}
+}
+
+func TestParamValidate(t *testing.T) {
+	tests := []struct {
+		key   ParamKey
+		value *big.Int
+		err   error
+	}{
+		{ParamKey(0), big.NewInt(1), ErrUnknownParam},
+		{ParamKey(100), big.NewInt(1), ErrUnknownParam},
+		{ParamDumpHeight, big.NewInt(0), ErrInvalidParam},
+		{ParamGasMortgage, new(big.Int).Lsh(big.NewInt(1), 64), ErrInvalidParam},
+		{ParamMinStakingAmount, new(big.Int).Lsh(big.NewInt(1), 64), nil},
+		{ParamMortgageToMin, big.NewInt(0), nil},
+	}
+	for i, tt := range tests {
+		if err := tt.key.validate(tt.value); !errors.Is(err, tt.err) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that the mortgage target range is only enforced from the governance
+// fork on, and that it follows the governed bounds.
+func TestCheckMortgageTo(t *testing.T) {
+	var (
+		legacy   = &params.ChainConfig{}
+		governed = &params.ChainConfig{
+			TeWakaGovernors:       &params.GovernorConfig{Threshold: 1, Governors: []common.Address{{1}}},
+			TeWakaGovernanceBlock: big.NewInt(10),
+		}
+		before  = big.NewInt(9)
+		after   = big.NewInt(10)
+		inside  = common.BigToAddress(big.NewInt(300))
+		outside = common.BigToAddress(big.NewInt(1000))
+	)
+	ps := new(ParamStore)
+	if err := checkMortgageTo(legacy, after, ps, outside); err != nil {
+		t.Fatalf("legacy chain rejected target: %v", err)
+	}
+	if err := checkMortgageTo(governed, before, ps, outside); err != nil {
+		t.Fatalf("target rejected before the governance fork: %v", err)
+	}
+	if err := checkMortgageTo(governed, after, ps, inside); err != nil {
+		t.Fatalf("target inside range rejected: %v", err)
+	}
+	if err := checkMortgageTo(governed, after, ps, outside); err == nil {
+		t.Fatalf("target above range accepted")
+	}
+	if err := checkMortgageTo(governed, after, ps, common.BigToAddress(big.NewInt(1))); err == nil {
+		t.Fatalf("target below range accepted")
+	}
+	ps.Schedule(ParamMortgageToMax, big.NewInt(2000), 1)
+	if _, err := ps.Enact(ParamMortgageToMax, 1); err != nil {
+		t.Fatalf("failed to enact bound: %v", err)
+	}
+	if err := checkMortgageTo(governed, after, ps, outside); err != nil {
+		t.Fatalf("target inside governed range rejected: %v", err)
+	}
+}
+
+// Tests that the governed parameters only apply from the governance fork on,
+// falling back to the original values for the blocks before it.
+func TestTeWakaParamsFork(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	ps := new(ParamStore)
+	ps.Schedule(ParamDumpHeight, big.NewInt(7), 0)
+	if _, err := ps.Enact(ParamDumpHeight, 0); err != nil {
+		t.Fatalf("failed to enact dump height: %v", err)
+	}
+	if err := ps.Save(statedb, TeWaKaAddress); err != nil {
+		t.Fatalf("failed to save parameters: %v", err)
+	}
+	config := &params.ChainConfig{
+		TeWakaGovernors:       &params.GovernorConfig{Threshold: 1, Governors: []common.Address{{1}}},
+		TeWakaGovernanceBlock: big.NewInt(10),
+	}
+	if have := tewakaParams(config, statedb, big.NewInt(9)).Uint64(ParamDumpHeight); have != DumpHeight {
+		t.Fatalf("dump height before the fork mismatch: have %d, want %d", have, DumpHeight)
+	}
+	if have := tewakaParams(config, statedb, big.NewInt(10)).Uint64(ParamDumpHeight); have != 7 {
+		t.Fatalf("dump height after the fork mismatch: have %d, want 7", have)
+	}
+	config.TeWakaGovernanceBlock = nil
+	if have := tewakaParams(config, statedb, big.NewInt(10)).Uint64(ParamDumpHeight); have != DumpHeight {
+		t.Fatalf("dump height without the fork mismatch: have %d, want %d", have, DumpHeight)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/params"
+)
+
+// ErrTeWakaClosed is returned by ValidateTeWaka for the conversion methods
+// that were closed by CIP4.
+var ErrTeWakaClosed = errors.New("tewaka method closed since CIP4")
+
+// ValidateTeWaka runs the cheap checks of a TeWaka call against state without
+// executing it, so the transaction pool can drop calls that are bound to
+// revert. It returns the name of the called method, if the input could be
+// decoded, along with the reason the call would fail.
+//
+// The state should be the pending one. The outcome is only a prediction: the
+// transactions executed in front of the call may still change the governed
+// limits, pause or resume networks, or use the same items, so the execution
+// remains the authority on the validity of the call.
+func ValidateTeWaka(state StateDB, config *params.ChainConfig, number *big.Int, from common.Address, input []byte) (string, error) {
+	method, err := AbiTeWaKa.MethodById(input)
+	if err != nil {
+		return "", ErrStakingInvalidInput
+	}
+	data := input[4:]
+
+	switch method.Name {
+	case "convert", "confirm", "casting":
+		if config.IsCIP4(number) {
+			return method.Name, ErrTeWakaClosed
+		}
+	case "pause", "unpause", "setLimits":
+		guardians := config.TeWakaGuardians
+		if !config.IsTeWakaGuardians(number) || guardians.Threshold == 0 || guardians.Threshold > uint64(len(guardians.Guardians)) {
+			return method.Name, ErrNoGuardians
+		}
+		if !guardians.IsGuardian(from) {
+			return method.Name, fmt.Errorf("%w: %v", ErrNotGuardian, from)
+		}
+	case "proposeParam", "enactParam":
+		governors := config.TeWakaGovernors
+		if !config.IsTeWakaGovernance(number) || governors.Threshold == 0 || governors.Threshold > uint64(len(governors.Governors)) {
+			return method.Name, ErrNoGovernors
+		}
+		if !governors.IsGovernor(from) {
+			return method.Name, fmt.Errorf("%w: %v", ErrNotGovernor, from)
+		}
+	}
+
+	switch method.Name {
+	case "mortgage":
+		args := struct {
+			PubKey          []byte
+			ToAddress       common.Address
+			StakingAmount   *big.Int
+			CoinBaseAddress []common.Address
+		}{}
+		if err := method.Inputs.UnpackAtomic(&args, data); err != nil {
+			return method.Name, ErrStakingInvalidInput
+		}
+		limits := tewakaParams(config, state, number)
+		if args.StakingAmount.Cmp(limits.Get(ParamMinStakingAmount)) < 0 {
+			return method.Name, fmt.Errorf("mortgage StakingAmount %s", "StakingAmount <  emimState")
+		}
+		if ValidPubkey(args.PubKey) != nil {
+			return method.Name, fmt.Errorf("mortgage PubKey %s", "PubKey err")
+		}
+		if uint64(len(args.CoinBaseAddress)) > limits.Uint64(ParamMaxCoinbases) {
+			return method.Name, fmt.Errorf("mortgage CoinBaseAddress len(CoinBaseAddress) > %d", limits.Uint64(ParamMaxCoinbases))
+		}
+		if err := checkMortgageTo(config, number, limits, args.ToAddress); err != nil {
+			return method.Name, err
+		}
+		tewaka := NewTeWakaImpl()
+		if err := tewaka.Load(state, TeWaKaAddress); err != nil {
+			return method.Name, nil
+		}
+		if tewaka.GetStakeUser(from) != nil {
+			return method.Name, fmt.Errorf("mortgage HasStakeUser %s", "from already exist")
+		}
+		if tewaka.GetStakeToAddress(args.ToAddress) != nil {
+			return method.Name, fmt.Errorf("mortgage HasStakeToAddress %s", "ToAddress already exist")
+		}
+
+	case "update":
+		args := struct {
+			StakingAmount   *big.Int
+			CoinBaseAddress []common.Address
+		}{}
+		if err := method.Inputs.UnpackAtomic(&args, data); err != nil {
+			return method.Name, ErrStakingInvalidInput
+		}
+		limits := tewakaParams(config, state, number)
+		if args.StakingAmount.Sign() > 0 && args.StakingAmount.Cmp(limits.Get(ParamMinStakingAmount)) < 0 {
+			return method.Name, fmt.Errorf("update StakingAmount %s", "StakingAmount <  emimState")
+		}
+		if uint64(len(args.CoinBaseAddress)) > limits.Uint64(ParamMaxCoinbases) {
+			return method.Name, fmt.Errorf("update CoinBaseAddress len(CoinBaseAddress) > %d", limits.Uint64(ParamMaxCoinbases))
+		}
+
+	case "convert", "confirm":
+		// Both methods take the network and the side chain transaction hash,
+		// only the name of the first argument differs.
+		args, err := method.Inputs.Unpack(data)
+		if err != nil || len(args) != 2 {
+			return method.Name, ErrStakingInvalidInput
+		}
+		netType := uint8(args[0].(*big.Int).Uint64())
+		txHash := common.HexToHash(args[1].(string))
+
+		tewaka := NewTeWakaImpl()
+		if err := tewaka.Load(state, TeWaKaAddress); err != nil {
+			return method.Name, nil
+		}
+		if tewaka.HasItem(&types.UsedItem{Atype: netType, TxHash: txHash}, state) {
+			return method.Name, ErrTxhashAlreadyInput
+		}
+		return method.Name, checkPaused(config, state, number, netType)
+
+	case "casting":
+		args, err := method.Inputs.Unpack(data)
+		if err != nil || len(args) == 0 {
+			return method.Name, ErrStakingInvalidInput
+		}
+		return method.Name, checkPaused(config, state, number, uint8(args[0].(*big.Int).Uint64()))
+	}
+	return method.Name, nil
+}
+
+// checkPaused loads the breaker state in effect at block number and checks
+// that none of the networks is paused.
+func checkPaused(config *params.ChainConfig, state StateDB, number *big.Int, convertTypes ...uint8) error {
+	_, err := breakerAt(config, state, number, convertTypes...)
+	return err
+}
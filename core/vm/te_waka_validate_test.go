@@ -0,0 +1,71 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/params"
+)
+
+// Tests the stateless part of ValidateTeWaka. None of the cases gets as far as
+// loading the TeWaka state, so no state is needed.
+func TestValidateTeWakaStateless(t *testing.T) {
+	var (
+		config = &params.ChainConfig{CIP_4: big.NewInt(10)}
+		from   = common.Address{1}
+	)
+	pack := func(method string, args ...interface{}) []byte {
+		input, err := AbiTeWaKa.Pack(method, args...)
+		if err != nil {
+			t.Fatalf("failed to pack %s: %v", method, err)
+		}
+		return input
+	}
+	tests := []struct {
+		input  []byte
+		number int64
+		method string
+		fail   bool
+		err    error // Expected error, if a specific one is known
+	}{
+		{[]byte{1, 2, 3, 4}, 1, "", true, ErrStakingInvalidInput},
+		{pack("convert", big.NewInt(1), "0x01"), 10, "convert", true, ErrTeWakaClosed},
+		{pack("casting", big.NewInt(1), big.NewInt(1), []common.Address{}, []byte{}, common.Address{}, big.NewInt(0), false), 11, "casting", true, ErrTeWakaClosed},
+		{pack("pause", big.NewInt(1)), 1, "pause", true, ErrNoGuardians},
+		{pack("update", big.NewInt(1), []common.Address{}), 1, "update", true, nil},
+		{pack("update", big.NewInt(0), []common.Address{}), 1, "update", false, nil},
+		{pack("update", big.NewInt(0), make([]common.Address, 16)), 1, "update", false, nil},
+		{pack("update", big.NewInt(0), make([]common.Address, 17)), 1, "update", true, nil},
+		{pack("mortgage", []byte{}, common.Address{}, big.NewInt(1), []common.Address{}), 1, "mortgage", true, nil},
+	}
+	for i, tt := range tests {
+		method, err := ValidateTeWaka(nil, config, big.NewInt(tt.number), from, tt.input)
+		if method != tt.method {
+			t.Errorf("test %d: method mismatch: have %q, want %q", i, method, tt.method)
+		}
+		if (err != nil) != tt.fail {
+			t.Errorf("test %d: failure mismatch: have %v, want failure %v", i, err, tt.fail)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package czz
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
 	"errors"
@@ -25,6 +26,7 @@ import (
 	"math/big"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -33,6 +35,9 @@ import (
 	"github.com/classzz/go-classzz-v2/core"
 	"github.com/classzz/go-classzz-v2/core/rawdb"
 	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/state/pruner"
+	"github.com/classzz/go-classzz-v2/core/state/snapshot"
+	"github.com/classzz/go-classzz-v2/core/stateless"
 	"github.com/classzz/go-classzz-v2/core/types"
 	"github.com/classzz/go-classzz-v2/internal/czzapi"
 	"github.com/classzz/go-classzz-v2/rlp"
@@ -545,3 +550,135 @@ func (api *PrivateDebugAPI) getModifiedAccounts(startBlock, endBlock *types.Bloc
 	}
 	return dirty, nil
 }
+
+// defaultPruneBloomSize is the bloom filter size in megabytes used for online
+// state pruning if none is requested.
+const defaultPruneBloomSize = 2048
+
+// PruneState starts pruning the stale state in the background while the node
+// keeps importing blocks. The optional bloom filter size is in megabytes.
+func (api *PrivateDebugAPI) PruneState(bloomSize *uint64) error {
+	size := uint64(defaultPruneBloomSize)
+	if bloomSize != nil {
+		size = *bloomSize
+	}
+	chain := api.czz.BlockChain()
+	p, err := pruner.NewOnlinePruner(api.czz.ChainDb(), chain.Snapshots(), chain.StateCache().TrieDB(), api.czz.datadir, size)
+	if err != nil {
+		return err
+	}
+	return chain.PruneState(p)
+}
+
+// PruneStateProgress returns the progress of the current or last online state
+// pruning.
+func (api *PrivateDebugAPI) PruneStateProgress() pruner.OnlinePruneProgress {
+	if p := api.czz.BlockChain().StatePruner(); p != nil {
+		return p.Progress()
+	}
+	return pruner.OnlinePruneProgress{Stage: pruner.PruneStageIdle}
+}
+
+// errSnapshotDisabled is returned if the snapshot generation is controlled on a
+// node running without state snapshots.
+var errSnapshotDisabled = errors.New("state snapshots are disabled")
+
+// SnapshotStatus returns the progress of the background state snapshot
+// generation.
+func (api *PrivateDebugAPI) SnapshotStatus() (*snapshot.GeneratorStatus, error) {
+	snaps := api.czz.BlockChain().Snapshots()
+	if snaps == nil {
+		return nil, errSnapshotDisabled
+	}
+	return snaps.GeneratorStatus()
+}
+
+// SnapshotPause suspends the background state snapshot generation until it's
+// resumed or the node is restarted.
+func (api *PrivateDebugAPI) SnapshotPause() error {
+	snaps := api.czz.BlockChain().Snapshots()
+	if snaps == nil {
+		return errSnapshotDisabled
+	}
+	return snaps.PauseGeneration()
+}
+
+// SnapshotResume continues a paused background state snapshot generation.
+func (api *PrivateDebugAPI) SnapshotResume() error {
+	snaps := api.czz.BlockChain().Snapshots()
+	if snaps == nil {
+		return errSnapshotDisabled
+	}
+	return snaps.ResumeGeneration()
+}
+
+// SnapshotRegenerate discards the state snapshot and regenerates it from scratch
+// in the background. The root defaults to the state root of the head block, the
+// only state the snapshot can be regenerated at.
+func (api *PrivateDebugAPI) SnapshotRegenerate(root *common.Hash) error {
+	chain := api.czz.BlockChain()
+	if root == nil {
+		head := chain.CurrentBlock().Root()
+		root = &head
+	}
+	return chain.RegenerateSnapshot(*root)
+}
+
+// ExecutionWitness is the JSON representation of the data accessed while
+// executing a block, enough to verify it without the chain state.
+type ExecutionWitness struct {
+	Headers []*types.Header `json:"headers"` // Parent header first, followed by the accessed ancestors
+	Codes   []hexutil.Bytes `json:"codes"`   // Accessed contract codes
+	State   []hexutil.Bytes `json:"state"`   // Accessed account and storage trie nodes
+}
+
+// executionWitness executes a canonical block, recording its witness.
+func (api *PrivateDebugAPI) executionWitness(blockNr rpc.BlockNumber) (*stateless.Witness, error) {
+	var block *types.Block
+	switch blockNr {
+	case rpc.PendingBlockNumber:
+		return nil, errors.New("pending block witness not supported")
+	case rpc.LatestBlockNumber:
+		block = api.czz.blockchain.CurrentBlock()
+	default:
+		block = api.czz.blockchain.GetBlockByNumber(uint64(blockNr))
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", blockNr)
+	}
+	return api.czz.blockchain.ExecutionWitness(block)
+}
+
+// ExecutionWitness executes a block and returns every ancestor header, contract
+// code and trie node accessed, TeWaka state included. Blocks accessing the
+// TeWaka item records can't be witnessed.
+func (api *PrivateDebugAPI) ExecutionWitness(blockNr rpc.BlockNumber) (*ExecutionWitness, error) {
+	witness, err := api.executionWitness(blockNr)
+	if err != nil {
+		return nil, err
+	}
+	result := &ExecutionWitness{
+		Headers: witness.Headers,
+		Codes:   make([]hexutil.Bytes, 0, len(witness.Codes)),
+		State:   make([]hexutil.Bytes, 0, len(witness.State)),
+	}
+	for _, code := range witness.Codes {
+		result.Codes = append(result.Codes, code)
+	}
+	for _, node := range witness.State {
+		result.State = append(result.State, node)
+	}
+	sort.Slice(result.Codes, func(i, j int) bool { return bytes.Compare(result.Codes[i], result.Codes[j]) < 0 })
+	sort.Slice(result.State, func(i, j int) bool { return bytes.Compare(result.State[i], result.State[j]) < 0 })
+	return result, nil
+}
+
+// ExecutionMultiproof executes a block and returns its witness in the compact
+// RLP encoding, with the trie nodes packed into a multiproof.
+func (api *PrivateDebugAPI) ExecutionMultiproof(blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
+	witness, err := api.executionWitness(blockNr)
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(witness)
+}
@@ -19,6 +19,7 @@ package czz
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/big"
 
 	"github.com/classzz/go-classzz-v2/accounts"
@@ -47,6 +48,24 @@ type EthAPIBackend struct {
 	gpo                 *gasprice.Oracle
 }
 
+// prunedHistoryError is returned when the body or receipts of a block are
+// requested which have been pruned from the ancient store.
+type prunedHistoryError struct {
+	tail uint64
+}
+
+func (e *prunedHistoryError) Error() string {
+	return fmt.Sprintf("pruned history unavailable: bodies and receipts below block #%d have been pruned", e.tail)
+}
+
+// prunedHistory returns an error if the history of the given block was pruned.
+func (b *EthAPIBackend) prunedHistory(number uint64) error {
+	if tail := b.czz.blockchain.HistoryTail(); number < tail {
+		return &prunedHistoryError{tail: tail}
+	}
+	return nil
+}
+
 // ChainConfig returns the active chain configuration.
 func (b *EthAPIBackend) ChainConfig() *params.ChainConfig {
 	return b.czz.blockchain.Config()
@@ -105,11 +124,21 @@ func (b *EthAPIBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumbe
 	if number == rpc.LatestBlockNumber {
 		return b.czz.blockchain.CurrentBlock(), nil
 	}
-	return b.czz.blockchain.GetBlockByNumber(uint64(number)), nil
+	block := b.czz.blockchain.GetBlockByNumber(uint64(number))
+	if block == nil {
+		return nil, b.prunedHistory(uint64(number))
+	}
+	return block, nil
 }
 
 func (b *EthAPIBackend) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
-	return b.czz.blockchain.GetBlockByHash(hash), nil
+	block := b.czz.blockchain.GetBlockByHash(hash)
+	if block == nil {
+		if header := b.czz.blockchain.GetHeaderByHash(hash); header != nil {
+			return nil, b.prunedHistory(header.Number.Uint64())
+		}
+	}
+	return block, nil
 }
 
 func (b *EthAPIBackend) BlockByNumberOrHash(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*types.Block, error) {
@@ -126,6 +155,9 @@ func (b *EthAPIBackend) BlockByNumberOrHash(ctx context.Context, blockNrOrHash r
 		}
 		block := b.czz.blockchain.GetBlock(hash, header.Number.Uint64())
 		if block == nil {
+			if err := b.prunedHistory(header.Number.Uint64()); err != nil {
+				return nil, err
+			}
 			return nil, errors.New("header found, but block body is missing")
 		}
 		return block, nil
@@ -177,13 +209,19 @@ func (b *EthAPIBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockN
 }
 
 func (b *EthAPIBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
-	return b.czz.blockchain.GetReceiptsByHash(hash), nil
+	receipts := b.czz.blockchain.GetReceiptsByHash(hash)
+	if receipts == nil {
+		if header := b.czz.blockchain.GetHeaderByHash(hash); header != nil {
+			return nil, b.prunedHistory(header.Number.Uint64())
+		}
+	}
+	return receipts, nil
 }
 
 func (b *EthAPIBackend) GetLogs(ctx context.Context, hash common.Hash) ([][]*types.Log, error) {
-	receipts := b.czz.blockchain.GetReceiptsByHash(hash)
+	receipts, err := b.GetReceipts(ctx, hash)
 	if receipts == nil {
-		return nil, nil
+		return nil, err
 	}
 	logs := make([][]*types.Log, len(receipts))
 	for i, receipt := range receipts {
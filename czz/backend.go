@@ -34,6 +34,7 @@ import (
 	"github.com/classzz/go-classzz-v2/core"
 	"github.com/classzz/go-classzz-v2/core/bloombits"
 	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/state"
 	"github.com/classzz/go-classzz-v2/core/state/pruner"
 	"github.com/classzz/go-classzz-v2/core/types"
 	"github.com/classzz/go-classzz-v2/core/vm"
@@ -74,6 +75,7 @@ type Classzz struct {
 
 	// DB interfaces
 	chainDb czzdb.Database // Block chain database
+	datadir string         // Data directory hosting the state pruning bloom filter
 
 	eventMux       *event.TypeMux
 	engine         consensus.Engine
@@ -148,6 +150,7 @@ func New(stack *node.Node, config *czzconfig.Config) (*Classzz, error) {
 	czz := &Classzz{
 		config:            config,
 		chainDb:           chainDb,
+		datadir:           stack.ResolvePath(""),
 		eventMux:          stack.EventMux(),
 		accountManager:    stack.AccountManager(),
 		engine:            czzconfig.CreateConsensusEngine(stack, chainConfig, &ethashConfig, config.Miner.Notify, config.Miner.Noverify, chainDb),
@@ -191,8 +194,16 @@ func New(stack *node.Node, config *czzconfig.Config) (*Classzz, error) {
 			TrieTimeLimit:       config.TrieTimeout,
 			SnapshotLimit:       config.SnapshotCache,
 			Preimages:           config.Preimages,
+			HistoryLimit:        config.HistoryLimit,
+			StateScheme:         rawdb.ReadStateScheme(chainDb),
 		}
 	)
+	// Chain history is only pruned behind the transaction index, so make sure
+	// the index doesn't reach further back than the retained bodies.
+	if config.HistoryLimit != 0 && (config.TxLookupLimit == 0 || config.TxLookupLimit > config.HistoryLimit) {
+		log.Warn("Limiting transaction index to retained chain history", "txlookuplimit", config.TxLookupLimit, "history", config.HistoryLimit)
+		config.TxLookupLimit = config.HistoryLimit
+	}
 	czz.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, chainConfig, czz.engine, vmConfig, czz.shouldPreserve, &config.TxLookupLimit)
 	if err != nil {
 		return nil, err
@@ -209,6 +220,12 @@ func New(stack *node.Node, config *czzconfig.Config) (*Classzz, error) {
 		config.TxPool.Journal = stack.ResolvePath(config.TxPool.Journal)
 	}
 	czz.txPool = core.NewTxPool(config.TxPool, chainConfig, czz.blockchain)
+	czz.txPool.AddValidator(core.NewTeWakaValidator(chainConfig, func() (*types.Block, *state.StateDB) {
+		if czz.miner == nil {
+			return nil, nil
+		}
+		return czz.miner.Pending()
+	}))
 
 	// Permit the downloader to use the trie cache allowance during fast sync
 	cacheLimit := cacheConfig.TrieCleanLimit + cacheConfig.TrieDirtyLimit + cacheConfig.SnapshotLimit
@@ -302,6 +319,10 @@ func (s *Classzz) APIs() []rpc.API {
 	// Append any APIs exposed explicitly by the consensus engine
 	apis = append(apis, s.engine.APIs(s.BlockChain())...)
 
+	logLimits := filters.Limits{
+		MaxBlockRange: s.config.RPCLogsMaxBlockRange,
+		MaxResults:    s.config.RPCLogsMaxResults,
+	}
 	// Append all the local APIs and return
 	return append(apis, []rpc.API{
 		{
@@ -327,7 +348,12 @@ func (s *Classzz) APIs() []rpc.API {
 		}, {
 			Namespace: "eth",
 			Version:   "1.0",
-			Service:   filters.NewPublicFilterAPI(s.APIBackend, false, 5*time.Minute),
+			Service:   filters.NewPublicFilterAPI(s.APIBackend, false, 5*time.Minute, logLimits),
+			Public:    true,
+		}, {
+			Namespace: "czz",
+			Version:   "1.0",
+			Service:   filters.NewPublicLogsAPI(s.APIBackend, logLimits),
 			Public:    true,
 		}, {
 			Namespace: "admin",
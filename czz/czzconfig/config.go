@@ -135,6 +135,7 @@ type Config struct {
 	NoPrefetch bool // Whether to disable prefetching and only load state on demand
 
 	TxLookupLimit uint64 `toml:",omitempty"` // The maximum number of blocks from head whose tx indices are reserved.
+	HistoryLimit  uint64 `toml:",omitempty"` // The maximum number of blocks from head whose bodies and receipts are reserved.
 
 	// Whitelist of required block number -> hash values to accept
 	Whitelist map[uint64]common.Hash `toml:"-"`
@@ -195,6 +196,14 @@ type Config struct {
 	// send-transction variants. The unit is czz.
 	RPCTxFeeCap float64
 
+	// RPCLogsMaxBlockRange is the maximum number of blocks a log query may
+	// span, 0 for no limit.
+	RPCLogsMaxBlockRange uint64
+
+	// RPCLogsMaxResults is the maximum number of logs a log query may return,
+	// 0 for no limit.
+	RPCLogsMaxResults int
+
 	// Checkpoint is a hardcoded checkpoint which can be nil.
 	Checkpoint *params.TrustedCheckpoint `toml:",omitempty"`
 
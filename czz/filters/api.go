@@ -56,16 +56,19 @@ type PublicFilterAPI struct {
 	filtersMu sync.Mutex
 	filters   map[rpc.ID]*filter
 	timeout   time.Duration
+	limits    Limits
 }
 
-// NewPublicFilterAPI returns a new PublicFilterAPI instance.
-func NewPublicFilterAPI(backend Backend, lightMode bool, timeout time.Duration) *PublicFilterAPI {
+// NewPublicFilterAPI returns a new PublicFilterAPI instance, restricting the
+// log queries to the given limits.
+func NewPublicFilterAPI(backend Backend, lightMode bool, timeout time.Duration, limits Limits) *PublicFilterAPI {
 	api := &PublicFilterAPI{
 		backend: backend,
 		chainDb: backend.ChainDb(),
 		events:  NewEventSystem(backend, lightMode),
 		filters: make(map[rpc.ID]*filter),
 		timeout: timeout,
+		limits:  limits,
 	}
 	go api.timeoutLoop(timeout)
 
@@ -349,6 +352,7 @@ func (api *PublicFilterAPI) GetLogs(ctx context.Context, crit FilterCriteria) ([
 		filter = NewRangeFilter(api.backend, begin, end, crit.Addresses, crit.Topics)
 	}
 	// Run the filter and return all the logs
+	filter.SetLimits(api.limits)
 	logs, err := filter.Logs(ctx)
 	if err != nil {
 		return nil, err
@@ -404,6 +408,7 @@ func (api *PublicFilterAPI) GetFilterLogs(ctx context.Context, id rpc.ID) ([]*ty
 		filter = NewRangeFilter(api.backend, begin, end, f.crit.Addresses, f.crit.Topics)
 	}
 	// Run the filter and return all the logs
+	filter.SetLimits(api.limits)
 	logs, err := filter.Logs(ctx)
 	if err != nil {
 		return nil, err
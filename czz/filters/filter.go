@@ -19,9 +19,11 @@ package filters
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/big"
 
 	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
 	"github.com/classzz/go-classzz-v2/core"
 	"github.com/classzz/go-classzz-v2/core/bloombits"
 	"github.com/classzz/go-classzz-v2/core/types"
@@ -47,6 +49,37 @@ type Backend interface {
 	ServiceFilter(ctx context.Context, session *bloombits.MatcherSession)
 }
 
+// Limits restricts the resources a single log query may consume. Zero values
+// disable the respective limit.
+type Limits struct {
+	MaxBlockRange uint64 // Maximum number of blocks a range query may span
+	MaxResults    int    // Maximum number of logs a query may return
+}
+
+// LimitError is returned when a log query exceeds its limits. LastBlock is the
+// last block the query can be answered up to: for result limits the last block
+// whose logs were fully processed, for range limits the last block within the
+// allowed range. Clients may retry up to it, and resume from the block after.
+// If not even the logs of the genesis block fit, LastBlock is clamped to 0.
+type LimitError struct {
+	Reason    string
+	LastBlock uint64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s, retry with a range ending at block %d", e.Reason, e.LastBlock)
+}
+
+// ErrorCode returns the JSON error code of a limit violation.
+func (e *LimitError) ErrorCode() int {
+	return -32005
+}
+
+// ErrorData returns the last block the query can be answered up to.
+func (e *LimitError) ErrorData() interface{} {
+	return map[string]interface{}{"lastBlock": hexutil.Uint64(e.LastBlock)}
+}
+
 // Filter can be used to retrieve and filter logs.
 type Filter struct {
 	backend Backend
@@ -58,6 +91,9 @@ type Filter struct {
 	block      common.Hash // Block hash if filtering a single block
 	begin, end int64       // Range interval if filtering multiple blocks
 
+	limits  Limits
+	results int // Number of logs gathered so far, checked against the limits
+
 	matcher *bloombits.Matcher
 }
 
@@ -114,8 +150,16 @@ func newFilter(backend Backend, addresses []common.Address, topics [][]common.Ha
 	}
 }
 
+// SetLimits restricts the block range and the number of results of the filter.
+func (f *Filter) SetLimits(limits Limits) {
+	f.limits = limits
+}
+
 // Logs searches the blockchain for matching log entries, returning all from the
 // first block that contains matches, updating the start of the filter accordingly.
+//
+// If the query exceeds the limits of the filter, a *LimitError is returned along
+// with the logs of the blocks fully processed until then.
 func (f *Filter) Logs(ctx context.Context) ([]*types.Log, error) {
 	// If we're doing singleton block filtering, execute and return
 	if f.block != (common.Hash{}) {
@@ -126,7 +170,11 @@ func (f *Filter) Logs(ctx context.Context) ([]*types.Log, error) {
 		if header == nil {
 			return nil, errors.New("unknown block")
 		}
-		return f.blockLogs(ctx, header)
+		found, err := f.blockLogs(ctx, header)
+		if err != nil {
+			return nil, err
+		}
+		return f.appendLogs(nil, found, header.Number.Uint64())
 	}
 	// Figure out the limits of the filter range
 	header, _ := f.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
@@ -142,6 +190,12 @@ func (f *Filter) Logs(ctx context.Context) ([]*types.Log, error) {
 	if f.end == -1 {
 		end = head
 	}
+	if limit := f.limits.MaxBlockRange; limit > 0 && end >= uint64(f.begin) && end-uint64(f.begin) >= limit {
+		return nil, &LimitError{
+			Reason:    fmt.Sprintf("block range too large, max %d blocks", limit),
+			LastBlock: uint64(f.begin) + limit - 1,
+		}
+	}
 	// Gather all indexed logs, and finish with non indexed ones
 	var (
 		logs []*types.Log
@@ -202,7 +256,10 @@ func (f *Filter) indexedLogs(ctx context.Context, end uint64) ([]*types.Log, err
 			if err != nil {
 				return logs, err
 			}
-			logs = append(logs, found...)
+			if logs, err = f.appendLogs(logs, found, number); err != nil {
+				f.begin = int64(number)
+				return logs, err
+			}
 
 		case <-ctx.Done():
 			return logs, ctx.Err()
@@ -224,11 +281,31 @@ func (f *Filter) unindexedLogs(ctx context.Context, end uint64) ([]*types.Log, e
 		if err != nil {
 			return logs, err
 		}
-		logs = append(logs, found...)
+		if logs, err = f.appendLogs(logs, found, uint64(f.begin)); err != nil {
+			return logs, err
+		}
 	}
 	return logs, nil
 }
 
+// appendLogs adds the logs found in the given block to the results, failing
+// with a *LimitError if they don't fit within the result limit. The logs of a
+// block are never split, so the results end at the block before.
+func (f *Filter) appendLogs(logs []*types.Log, found []*types.Log, number uint64) ([]*types.Log, error) {
+	if limit := f.limits.MaxResults; limit > 0 && f.results+len(found) > limit {
+		last := number
+		if last > 0 {
+			last--
+		}
+		return logs, &LimitError{
+			Reason:    fmt.Sprintf("query returned more than %d results", limit),
+			LastBlock: last,
+		}
+	}
+	f.results += len(found)
+	return append(logs, found...), nil
+}
+
 // blockLogs returns the logs matching the filter criteria within a single block.
 func (f *Filter) blockLogs(ctx context.Context, header *types.Header) (logs []*types.Log, err error) {
 	if bloomFilter(header.Bloom, f.addresses, f.topics) {
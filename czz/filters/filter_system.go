@@ -102,6 +102,7 @@ type EventSystem struct {
 	pendingLogsCh chan []*types.Log          // Channel to receive new log event
 	rmLogsCh      chan core.RemovedLogsEvent // Channel to receive removed log event
 	chainCh       chan core.ChainEvent       // Channel to receive new chain event
+	done          chan struct{}              // Closed when the event loop exits
 }
 
 // NewEventSystem creates a new manager that listens for event on the given mux,
@@ -121,6 +122,7 @@ func NewEventSystem(backend Backend, lightMode bool) *EventSystem {
 		rmLogsCh:      make(chan core.RemovedLogsEvent, rmLogsChanSize),
 		pendingLogsCh: make(chan []*types.Log, logsChanSize),
 		chainCh:       make(chan core.ChainEvent, chainEvChanSize),
+		done:          make(chan struct{}),
 	}
 
 	// Subscribe events
@@ -167,6 +169,9 @@ func (sub *Subscription) Unsubscribe() {
 			case <-sub.f.logs:
 			case <-sub.f.hashes:
 			case <-sub.f.headers:
+			case <-sub.es.done:
+				// The event loop is gone and ended the subscription already
+				return
 			}
 		}
 
@@ -179,11 +184,23 @@ func (sub *Subscription) Unsubscribe() {
 
 // subscribe installs the subscription in the event broadcast loop.
 func (es *EventSystem) subscribe(sub *subscription) *Subscription {
-	es.install <- sub
-	<-sub.installed
+	select {
+	case es.install <- sub:
+		<-sub.installed
+	case <-es.done:
+		// The event loop is gone, end the subscription right away
+		close(sub.installed)
+		close(sub.err)
+	}
 	return &Subscription{ID: sub.id, f: sub, es: es}
 }
 
+// Stop stops the event loop, ending all the subscriptions.
+func (es *EventSystem) Stop() {
+	es.chainSub.Unsubscribe()
+	<-es.done
+}
+
 // SubscribeLogs creates a subscription that will write all logs matching the
 // given criteria to the given logs channel. Default value for the from and to
 // block is "latest". If the fromBlock > toBlock an error is returned.
@@ -441,6 +458,8 @@ func (es *EventSystem) lightFilterLogs(header *types.Header, addresses []common.
 
 // eventLoop (un)installs filters and processes mux events.
 func (es *EventSystem) eventLoop() {
+	index := make(filterIndex)
+
 	// Ensure all subscriptions get cleaned up
 	defer func() {
 		es.txsSub.Unsubscribe()
@@ -448,9 +467,20 @@ func (es *EventSystem) eventLoop() {
 		es.rmLogsSub.Unsubscribe()
 		es.pendingLogsSub.Unsubscribe()
 		es.chainSub.Unsubscribe()
+
+		// End the installed filters, the combined ones are indexed twice
+		ended := make(map[rpc.ID]bool)
+		for _, filters := range index {
+			for id, f := range filters {
+				if !ended[id] {
+					ended[id] = true
+					close(f.err)
+				}
+			}
+		}
+		close(es.done)
 	}()
 
-	index := make(filterIndex)
 	for i := UnknownSubscription; i < LastIndexSubscription; i++ {
 		index[i] = make(map[rpc.ID]*subscription)
 	}
@@ -41,12 +41,21 @@ import (
 
 var (
 	deadline = 5 * time.Minute
+
+	// testChainConfig is the chain configuration of the tests. The CIP4 and CIP5
+	// transitions are scheduled, as the consensus engine expects them to be.
+	testChainConfig = func() *params.ChainConfig {
+		config := *params.TestChainConfig
+		config.CIP_4, config.CIP_5 = big.NewInt(1000000), big.NewInt(1000000)
+		return &config
+	}()
 )
 
 type testBackend struct {
 	mux             *event.TypeMux
 	db              czzdb.Database
 	sections        uint64
+	sectionSize     uint64 // Blocks per bloom bits section, params.BloomBitsBlocks if 0
 	txFeed          event.Feed
 	logsFeed        event.Feed
 	rmLogsFeed      event.Feed
@@ -87,7 +96,7 @@ func (b *testBackend) HeaderByHash(ctx context.Context, hash common.Hash) (*type
 
 func (b *testBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
 	if number := rawdb.ReadHeaderNumber(b.db, hash); number != nil {
-		return rawdb.ReadReceipts(b.db, hash, *number, params.TestChainConfig), nil
+		return rawdb.ReadReceipts(b.db, hash, *number, testChainConfig), nil
 	}
 	return nil, nil
 }
@@ -97,7 +106,7 @@ func (b *testBackend) GetLogs(ctx context.Context, hash common.Hash) ([][]*types
 	if number == nil {
 		return nil, nil
 	}
-	receipts := rawdb.ReadReceipts(b.db, hash, *number, params.TestChainConfig)
+	receipts := rawdb.ReadReceipts(b.db, hash, *number, testChainConfig)
 
 	logs := make([][]*types.Log, len(receipts))
 	for i, receipt := range receipts {
@@ -127,6 +136,9 @@ func (b *testBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subsc
 }
 
 func (b *testBackend) BloomStatus() (uint64, uint64) {
+	if b.sectionSize != 0 {
+		return b.sectionSize, b.sections
+	}
 	return params.BloomBitsBlocks, b.sections
 }
 
@@ -147,7 +159,8 @@ func (b *testBackend) ServiceFilter(ctx context.Context, session *bloombits.Matc
 				task.Bitsets = make([][]byte, len(task.Sections))
 				for i, section := range task.Sections {
 					if rand.Int()%4 != 0 { // Handle occasional missing deliveries
-						head := rawdb.ReadCanonicalHash(b.db, (section+1)*params.BloomBitsBlocks-1)
+						size, _ := b.BloomStatus()
+						head := rawdb.ReadCanonicalHash(b.db, (section+1)*size-1)
 						task.Bitsets[i], _ = rawdb.ReadBloomBits(b.db, task.Bit, section, head)
 					}
 				}
@@ -168,9 +181,9 @@ func TestBlockSubscription(t *testing.T) {
 	var (
 		db          = rawdb.NewMemoryDatabase()
 		backend     = &testBackend{db: db}
-		api         = NewPublicFilterAPI(backend, false, deadline)
+		api         = NewPublicFilterAPI(backend, false, deadline, Limits{})
 		genesis     = (&core.Genesis{BaseFee: big.NewInt(params.InitialBaseFee)}).MustCommit(db)
-		chain, _    = core.GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 10, func(i int, gen *core.BlockGen) {})
+		chain, _    = core.GenerateChain(testChainConfig, genesis, ethash.NewFaker(), db, 10, func(i int, gen *core.BlockGen) {})
 		chainEvents = []core.ChainEvent{}
 	)
 
@@ -213,6 +226,40 @@ func TestBlockSubscription(t *testing.T) {
 	<-sub1.Err()
 }
 
+// Tests that stopping the event system ends the live subscriptions, and that the
+// subscriptions created or removed afterwards don't block.
+func TestEventSystemStop(t *testing.T) {
+	t.Parallel()
+
+	var (
+		backend = &testBackend{db: rawdb.NewMemoryDatabase()}
+		es      = NewEventSystem(backend, false)
+		headers = make(chan *types.Header)
+		sub     = es.SubscribeNewHeads(headers)
+		done    = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+
+		es.Stop()
+		select {
+		case <-sub.Err():
+		default:
+			t.Error("subscription not ended by stop")
+		}
+		sub.Unsubscribe()
+
+		late := es.SubscribeNewHeads(make(chan *types.Header))
+		<-late.Err()
+		late.Unsubscribe()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event system stop blocked")
+	}
+}
+
 // TestPendingTxFilter tests whether pending tx filters retrieve all pending transactions that are posted to the event mux.
 func TestPendingTxFilter(t *testing.T) {
 	t.Parallel()
@@ -220,7 +267,7 @@ func TestPendingTxFilter(t *testing.T) {
 	var (
 		db      = rawdb.NewMemoryDatabase()
 		backend = &testBackend{db: db}
-		api     = NewPublicFilterAPI(backend, false, deadline)
+		api     = NewPublicFilterAPI(backend, false, deadline, Limits{})
 
 		transactions = []*types.Transaction{
 			types.NewTransaction(0, common.HexToAddress("0xb794f5ea0ba39494ce83a213fffba74279579268"), new(big.Int), 0, new(big.Int), nil),
@@ -275,7 +322,7 @@ func TestLogFilterCreation(t *testing.T) {
 	var (
 		db      = rawdb.NewMemoryDatabase()
 		backend = &testBackend{db: db}
-		api     = NewPublicFilterAPI(backend, false, deadline)
+		api     = NewPublicFilterAPI(backend, false, deadline, Limits{})
 
 		testCases = []struct {
 			crit    FilterCriteria
@@ -319,7 +366,7 @@ func TestInvalidLogFilterCreation(t *testing.T) {
 	var (
 		db      = rawdb.NewMemoryDatabase()
 		backend = &testBackend{db: db}
-		api     = NewPublicFilterAPI(backend, false, deadline)
+		api     = NewPublicFilterAPI(backend, false, deadline, Limits{})
 	)
 
 	// different situations where log filter creation should fail.
@@ -341,7 +388,7 @@ func TestInvalidGetLogsRequest(t *testing.T) {
 	var (
 		db        = rawdb.NewMemoryDatabase()
 		backend   = &testBackend{db: db}
-		api       = NewPublicFilterAPI(backend, false, deadline)
+		api       = NewPublicFilterAPI(backend, false, deadline, Limits{})
 		blockHash = common.HexToHash("0x1111111111111111111111111111111111111111111111111111111111111111")
 	)
 
@@ -366,7 +413,7 @@ func TestLogFilter(t *testing.T) {
 	var (
 		db      = rawdb.NewMemoryDatabase()
 		backend = &testBackend{db: db}
-		api     = NewPublicFilterAPI(backend, false, deadline)
+		api     = NewPublicFilterAPI(backend, false, deadline, Limits{})
 
 		firstAddr      = common.HexToAddress("0x1111111111111111111111111111111111111111")
 		secondAddr     = common.HexToAddress("0x2222222222222222222222222222222222222222")
@@ -480,7 +527,7 @@ func TestPendingLogsSubscription(t *testing.T) {
 	var (
 		db      = rawdb.NewMemoryDatabase()
 		backend = &testBackend{db: db}
-		api     = NewPublicFilterAPI(backend, false, deadline)
+		api     = NewPublicFilterAPI(backend, false, deadline, Limits{})
 
 		firstAddr      = common.HexToAddress("0x1111111111111111111111111111111111111111")
 		secondAddr     = common.HexToAddress("0x2222222222222222222222222222222222222222")
@@ -616,7 +663,7 @@ func TestPendingTxFilterDeadlock(t *testing.T) {
 	var (
 		db      = rawdb.NewMemoryDatabase()
 		backend = &testBackend{db: db}
-		api     = NewPublicFilterAPI(backend, false, timeout)
+		api     = NewPublicFilterAPI(backend, false, timeout, Limits{})
 		done    = make(chan struct{})
 	)
 
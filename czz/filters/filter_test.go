@@ -24,12 +24,14 @@ import (
 	"testing"
 
 	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/bitutil"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
 	"github.com/classzz/go-classzz-v2/consensus/ethash"
 	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/bloombits"
 	"github.com/classzz/go-classzz-v2/core/rawdb"
 	"github.com/classzz/go-classzz-v2/core/types"
 	"github.com/classzz/go-classzz-v2/crypto"
-	"github.com/classzz/go-classzz-v2/params"
 )
 
 func makeReceipt(addr common.Address) *types.Receipt {
@@ -60,7 +62,7 @@ func BenchmarkFilters(b *testing.B) {
 	defer db.Close()
 
 	genesis := core.GenesisBlockForTesting(db, addr1, big.NewInt(1000000))
-	chain, receipts := core.GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 100010, func(i int, gen *core.BlockGen) {
+	chain, receipts := core.GenerateChain(testChainConfig, genesis, ethash.NewFaker(), db, 100010, func(i int, gen *core.BlockGen) {
 		switch i {
 		case 2403:
 			receipt := makeReceipt(addr1)
@@ -116,7 +118,7 @@ func TestFilters(t *testing.T) {
 	defer db.Close()
 
 	genesis := core.GenesisBlockForTesting(db, addr, big.NewInt(1000000))
-	chain, receipts := core.GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 1000, func(i int, gen *core.BlockGen) {
+	chain, receipts := core.GenerateChain(testChainConfig, genesis, ethash.NewFaker(), db, 1000, func(i int, gen *core.BlockGen) {
 		switch i {
 		case 1:
 			receipt := types.NewReceipt(nil, false, 0)
@@ -223,3 +225,153 @@ func TestFilters(t *testing.T) {
 		t.Error("expected 0 log, got", len(logs))
 	}
 }
+
+// Tests that the limits of a filter are enforced over the unindexed blocks, and
+// over the bloombits indexed ones: sections of 8 blocks, the first one indexed.
+func TestFilterLimits(t *testing.T) {
+	t.Run("unindexed", func(t *testing.T) { testFilterLimits(t, 0) })
+	t.Run("indexed", func(t *testing.T) { testFilterLimits(t, 1) })
+}
+
+func testFilterLimits(t *testing.T, sections uint64) {
+	dir, err := ioutil.TempDir("", "filtertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		db, _   = rawdb.NewLevelDBDatabase(dir, 0, 0, "", false)
+		backend = &testBackend{db: db, sections: sections, sectionSize: 8}
+		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr    = crypto.PubkeyToAddress(key1.PublicKey)
+		hash1   = common.BytesToHash([]byte("topic1"))
+	)
+	defer db.Close()
+
+	// Blocks 3, 5 and 7 hold a single log each, block 9 holds three of them
+	genesis := core.GenesisBlockForTesting(db, addr, big.NewInt(1000000))
+	chain, receipts := core.GenerateChain(testChainConfig, genesis, ethash.NewFaker(), db, 10, func(i int, gen *core.BlockGen) {
+		var count int
+		switch i {
+		case 2, 4, 6:
+			count = 1
+		case 8:
+			count = 3
+		}
+		for j := 0; j < count; j++ {
+			receipt := types.NewReceipt(nil, false, 0)
+			receipt.Logs = []*types.Log{{Address: addr, Topics: []common.Hash{hash1}}}
+			gen.AddUncheckedReceipt(receipt)
+			gen.AddUncheckedTx(types.NewTransaction(uint64(j), common.HexToAddress("0x1"), big.NewInt(1), 1, gen.BaseFee(), nil))
+		}
+	})
+	for i, block := range chain {
+		rawdb.WriteBlock(db, block)
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		rawdb.WriteHeadBlockHash(db, block.Hash())
+		rawdb.WriteReceipts(db, block.Hash(), block.NumberU64(), receipts[i])
+	}
+	for section := uint64(0); section < sections; section++ {
+		gen, err := bloombits.NewGenerator(uint(backend.sectionSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var head common.Hash
+		for i := uint64(0); i < backend.sectionSize; i++ {
+			number := section*backend.sectionSize + i
+			head = rawdb.ReadCanonicalHash(db, number)
+			gen.AddBloom(uint(i), rawdb.ReadHeader(db, head, number).Bloom)
+		}
+		for bit := 0; bit < types.BloomBitLength; bit++ {
+			bits, err := gen.Bitset(uint(bit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rawdb.WriteBloomBits(db, uint(bit), section, head, bitutil.CompressBytes(bits))
+		}
+	}
+	topics := [][]common.Hash{{hash1}}
+
+	// Check that the block range limit is enforced before searching
+	filter := NewRangeFilter(backend, 0, 9, nil, topics)
+	filter.SetLimits(Limits{MaxBlockRange: 5})
+	_, err = filter.Logs(context.Background())
+	limitErr, ok := err.(*LimitError)
+	if !ok {
+		t.Fatalf("range limit: expected limit error, got %v", err)
+	}
+	if limitErr.LastBlock != 4 {
+		t.Errorf("range limit: last block mismatch: have %d, want %d", limitErr.LastBlock, 4)
+	}
+	filter = NewRangeFilter(backend, 5, 9, nil, topics)
+	filter.SetLimits(Limits{MaxBlockRange: 5})
+	if logs, err := filter.Logs(context.Background()); err != nil || len(logs) != 5 {
+		t.Errorf("range limit: expected 5 logs, got %d (err %v)", len(logs), err)
+	}
+
+	// Check that the result limit returns the logs of all fully processed blocks
+	filter = NewRangeFilter(backend, 0, -1, nil, topics)
+	filter.SetLimits(Limits{MaxResults: 4})
+	logs, err := filter.Logs(context.Background())
+	if limitErr, ok = err.(*LimitError); !ok {
+		t.Fatalf("result limit: expected limit error, got %v", err)
+	}
+	if limitErr.LastBlock != 8 {
+		t.Errorf("result limit: last block mismatch: have %d, want %d", limitErr.LastBlock, 8)
+	}
+	if len(logs) != 3 {
+		t.Errorf("result limit: expected 3 logs, got %d", len(logs))
+	}
+
+	// Check that the result limit stops at the block before the one not fitting
+	filter = NewRangeFilter(backend, 0, -1, nil, topics)
+	filter.SetLimits(Limits{MaxResults: 2})
+	logs, err = filter.Logs(context.Background())
+	if limitErr, ok = err.(*LimitError); !ok {
+		t.Fatalf("result limit: expected limit error, got %v", err)
+	}
+	if limitErr.LastBlock != 6 || len(logs) != 2 {
+		t.Errorf("result limit: have %d logs up to block %d, want 2 up to 6", len(logs), limitErr.LastBlock)
+	}
+	// Check that the last block doesn't underflow if the genesis logs don't fit
+	filter = NewRangeFilter(backend, 0, -1, nil, topics)
+	filter.SetLimits(Limits{MaxResults: 1})
+	if _, err := filter.appendLogs(nil, make([]*types.Log, 2), 0); err == nil || err.(*LimitError).LastBlock != 0 {
+		t.Errorf("genesis result limit: have %v", err)
+	}
+
+	// Walk the chain page by page, splitting the last log block across pages
+	api := NewPublicLogsAPI(backend, Limits{MaxBlockRange: 4, MaxResults: 2})
+	var (
+		crit   = FilterCriteria{FromBlock: big.NewInt(0), Topics: topics}
+		cursor *hexutil.Bytes
+		found  []*types.Log
+		pages  int
+	)
+	for {
+		page, err := api.GetLogsPaged(context.Background(), crit, cursor)
+		if err != nil {
+			t.Fatalf("page %d: %v", pages, err)
+		}
+		if len(page.Logs) > 2 {
+			t.Fatalf("page %d: too many logs: %d", pages, len(page.Logs))
+		}
+		found = append(found, page.Logs...)
+		if pages++; page.Cursor == nil || pages > 10 {
+			break
+		}
+		cursor = page.Cursor
+	}
+	if len(found) != 6 {
+		t.Fatalf("paged: expected 6 logs, got %d", len(found))
+	}
+	for i, log := range found {
+		if want := []uint64{3, 5, 7, 9, 9, 9}[i]; log.BlockNumber != want {
+			t.Errorf("paged: log %d: block mismatch: have %d, want %d", i, log.BlockNumber, want)
+		}
+	}
+	if _, err := api.GetLogsPaged(context.Background(), crit, &hexutil.Bytes{0x01}); err != errInvalidCursor {
+		t.Errorf("invalid cursor: expected %v, got %v", errInvalidCursor, err)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package filters
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/rpc"
+)
+
+// defaultPageSize is the number of logs in a page if the results of the log
+// queries are not limited.
+const defaultPageSize = 1000
+
+var (
+	errPagedBlockHash = errors.New("paged log queries take a block range, not a block hash")
+	errInvalidCursor  = errors.New("invalid cursor")
+)
+
+// LogsPage is a page of the logs matching a query, along with the cursor to
+// retrieve the next page with. The cursor is nil once all logs are returned.
+type LogsPage struct {
+	Logs   []*types.Log   `json:"logs"`
+	Cursor *hexutil.Bytes `json:"cursor"`
+}
+
+// logsCursor is the position of a paged log query: the next block to search,
+// the number of its matching logs already returned and the end of the range.
+type logsCursor struct {
+	block uint64
+	skip  uint64
+	end   uint64
+}
+
+func (c *logsCursor) encode() *hexutil.Bytes {
+	blob := make(hexutil.Bytes, 24)
+	binary.BigEndian.PutUint64(blob[0:], c.block)
+	binary.BigEndian.PutUint64(blob[8:], c.skip)
+	binary.BigEndian.PutUint64(blob[16:], c.end)
+	return &blob
+}
+
+func decodeLogsCursor(blob hexutil.Bytes) (*logsCursor, error) {
+	if len(blob) != 24 {
+		return nil, errInvalidCursor
+	}
+	return &logsCursor{
+		block: binary.BigEndian.Uint64(blob[0:]),
+		skip:  binary.BigEndian.Uint64(blob[8:]),
+		end:   binary.BigEndian.Uint64(blob[16:]),
+	}, nil
+}
+
+// next returns the cursor following the given block, or nil if it was the last
+// one of the range.
+func (c *logsCursor) next(block uint64) *hexutil.Bytes {
+	if block >= c.end {
+		return nil
+	}
+	return (&logsCursor{block: block + 1, end: c.end}).encode()
+}
+
+// PublicLogsAPI offers cursor based log retrieval, allowing clients to walk
+// arbitrarily large block ranges within the log query limits of the node.
+type PublicLogsAPI struct {
+	backend Backend
+	limits  Limits
+}
+
+// NewPublicLogsAPI returns a new PublicLogsAPI instance, sizing the pages after
+// the given limits.
+func NewPublicLogsAPI(backend Backend, limits Limits) *PublicLogsAPI {
+	return &PublicLogsAPI{backend: backend, limits: limits}
+}
+
+// GetLogsPaged returns a page of the logs matching the given criteria. The first
+// page is retrieved without a cursor, the following ones by passing the cursor
+// of the previous page along with the same criteria.
+//
+// Pages hold at most the maximum number of results of a log query and span at
+// most the maximum block range, so a page may be empty even if more logs follow.
+func (api *PublicLogsAPI) GetLogsPaged(ctx context.Context, crit FilterCriteria, cursor *hexutil.Bytes) (*LogsPage, error) {
+	if crit.BlockHash != nil {
+		return nil, errPagedBlockHash
+	}
+	var (
+		pos *logsCursor
+		err error
+	)
+	if cursor != nil {
+		if pos, err = decodeLogsCursor(*cursor); err != nil {
+			return nil, err
+		}
+	} else if pos, err = api.firstCursor(ctx, crit); err != nil {
+		return nil, err
+	}
+	if pos == nil || pos.block > pos.end {
+		return &LogsPage{Logs: []*types.Log{}}, nil
+	}
+	size := api.limits.MaxResults
+	if size == 0 {
+		size = defaultPageSize
+	}
+	// Continue a block whose logs didn't fit into a single page
+	if pos.skip > 0 {
+		return api.blockPage(ctx, crit, pos, size)
+	}
+	last := pos.end
+	if limit := api.limits.MaxBlockRange; limit > 0 && last-pos.block >= limit {
+		last = pos.block + limit - 1
+	}
+	filter := NewRangeFilter(api.backend, int64(pos.block), int64(last), crit.Addresses, crit.Topics)
+	filter.SetLimits(Limits{MaxResults: size})
+
+	logs, err := filter.Logs(ctx)
+	if err != nil {
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) {
+			return nil, err
+		}
+		// If not even the first matching block fit, split it across pages. The
+		// filter stopped at that block, which LastBlock can't tell at genesis.
+		if len(logs) == 0 {
+			pos.block = uint64(filter.begin)
+			return api.blockPage(ctx, crit, pos, size)
+		}
+		last = limitErr.LastBlock
+	}
+	return &LogsPage{Logs: returnLogs(logs), Cursor: pos.next(last)}, nil
+}
+
+// firstCursor resolves the block range of the criteria into the cursor of the
+// first page, or nil if the chain is empty.
+func (api *PublicLogsAPI) firstCursor(ctx context.Context, crit FilterCriteria) (*logsCursor, error) {
+	header, err := api.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if header == nil || err != nil {
+		return nil, err
+	}
+	head := header.Number.Uint64()
+
+	resolve := func(number *big.Int) uint64 {
+		if number == nil || number.Sign() < 0 {
+			return head
+		}
+		return number.Uint64()
+	}
+	return &logsCursor{block: resolve(crit.FromBlock), end: resolve(crit.ToBlock)}, nil
+}
+
+// blockPage returns a page of the logs of the block the cursor points to,
+// starting after the ones already returned.
+func (api *PublicLogsAPI) blockPage(ctx context.Context, crit FilterCriteria, pos *logsCursor, size int) (*LogsPage, error) {
+	header, err := api.backend.HeaderByNumber(ctx, rpc.BlockNumber(pos.block))
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, errors.New("unknown block")
+	}
+	logs, err := NewBlockFilter(api.backend, header.Hash(), crit.Addresses, crit.Topics).Logs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pos.skip > uint64(len(logs)) {
+		return nil, errInvalidCursor
+	}
+	logs = logs[pos.skip:]
+	if len(logs) > size {
+		next := &logsCursor{block: pos.block, skip: pos.skip + uint64(size), end: pos.end}
+		return &LogsPage{Logs: logs[:size], Cursor: next.encode()}, nil
+	}
+	return &LogsPage{Logs: returnLogs(logs), Cursor: pos.next(pos.block)}, nil
+}
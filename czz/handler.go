@@ -28,6 +28,7 @@ import (
 	"github.com/classzz/go-classzz-v2/common"
 	"github.com/classzz/go-classzz-v2/core"
 	"github.com/classzz/go-classzz-v2/core/forkid"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
 	"github.com/classzz/go-classzz-v2/core/types"
 	"github.com/classzz/go-classzz-v2/czz/downloader"
 	"github.com/classzz/go-classzz-v2/czz/fetcher"
@@ -144,7 +145,11 @@ func newHandler(config *handlerConfig) (*handler, error) {
 		txsyncCh:   make(chan *txsync),
 		quitSync:   make(chan struct{}),
 	}
-	if config.Sync == downloader.FullSync {
+	if config.Sync != downloader.FullSync && h.chain.StateCache().TrieDB().Scheme() == rawdb.PathScheme {
+		// Fast and snap sync download the state by hash, which the path-based
+		// state scheme can't store.
+		log.Warn("Switch sync mode to full sync, unsupported by the path-based state scheme", "mode", config.Sync)
+	} else if config.Sync == downloader.FullSync {
 		// The database seems empty as the current block is the genesis. Yet the fast
 		// block is ahead, so fast sync was enabled for this node at a certain point.
 		// The scenarios where this can happen is
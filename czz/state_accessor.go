@@ -23,6 +23,7 @@ import (
 
 	"github.com/classzz/go-classzz-v2/common"
 	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
 	"github.com/classzz/go-classzz-v2/core/state"
 	"github.com/classzz/go-classzz-v2/core/types"
 	"github.com/classzz/go-classzz-v2/core/vm"
@@ -49,6 +50,15 @@ func (czz *Classzz) stateAtBlock(block *types.Block, reexec uint64, base *state.
 			return statedb, nil
 		}
 	}
+	// The path-based trie database only keeps the recent states and can't be
+	// isolated in an ephemeral instance, so no historical state is regenerated
+	pathScheme := czz.blockchain.StateCache().TrieDB().Scheme() == rawdb.PathScheme
+	if pathScheme && base == nil {
+		if statedb, err = czz.blockchain.StateAt(block.Root()); err != nil {
+			return nil, fmt.Errorf("required historical state unavailable under the path scheme: %v", err)
+		}
+		return statedb, nil
+	}
 	if base != nil {
 		// The optional base statedb is given, mark the start point as parent block
 		statedb, database, report = base, base.Database(), false
@@ -116,6 +126,12 @@ func (czz *Classzz) stateAtBlock(block *types.Block, reexec uint64, base *state.
 		if err != nil {
 			return nil, fmt.Errorf("processing block %d failed: %v", current.NumberU64(), err)
 		}
+		// Don't commit into the live path-based trie database, keep the state
+		// changes in memory instead
+		if pathScheme {
+			statedb.IntermediateRoot(true)
+			continue
+		}
 		// Finalize the state so any modifications are written to the trie
 		root, err := statedb.Commit(true)
 		if err != nil {
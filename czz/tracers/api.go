@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -156,19 +157,22 @@ func (api *API) blockByNumberAndHash(ctx context.Context, number rpc.BlockNumber
 // TraceConfig holds extra parameters to trace functions.
 type TraceConfig struct {
 	*vm.LogConfig
-	Tracer  *string
-	Timeout *string
-	Reexec  *uint64
+	Tracer       *string
+	TracerConfig json.RawMessage
+	Timeout      *string
+	Reexec       *uint64
 }
 
-// TraceCallConfig is the config for traceCall API. It holds one more
-// field to override the state for tracing.
+// TraceCallConfig is the config for traceCall API. It holds extra fields to
+// override the state and the block context for tracing.
 type TraceCallConfig struct {
 	*vm.LogConfig
 	Tracer         *string
+	TracerConfig   json.RawMessage
 	Timeout        *string
 	Reexec         *uint64
 	StateOverrides *czzapi.StateOverride
+	BlockOverrides *czzapi.BlockOverrides
 }
 
 // StdTraceConfig holds extra parameters to standard-json trace functions.
@@ -480,6 +484,22 @@ func (api *API) StandardTraceBadBlockToFile(ctx context.Context, hash common.Has
 // executes all the transactions contained within. The return value will be one item
 // per transaction, dependent on the requestd tracer.
 func (api *API) traceBlock(ctx context.Context, block *types.Block, config *TraceConfig) ([]*txTraceResult, error) {
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	return api.traceBlockWith(ctx, block, reexec, func(msg core.Message, txctx *Context, blockCtx vm.BlockContext, statedb *state.StateDB) (interface{}, error) {
+		return api.traceTx(ctx, msg, txctx, blockCtx, statedb, config)
+	})
+}
+
+// txTraceFunc traces a single transaction in the given environment.
+type txTraceFunc func(msg core.Message, txctx *Context, blockCtx vm.BlockContext, statedb *state.StateDB) (interface{}, error)
+
+// traceBlockWith executes all the transactions contained within a block, tracing
+// each of them with the given function. The return value will be one item per
+// transaction.
+func (api *API) traceBlockWith(ctx context.Context, block *types.Block, reexec uint64, trace txTraceFunc) ([]*txTraceResult, error) {
 	if block.NumberU64() == 0 {
 		return nil, errors.New("genesis is not traceable")
 	}
@@ -487,10 +507,6 @@ func (api *API) traceBlock(ctx context.Context, block *types.Block, config *Trac
 	if err != nil {
 		return nil, err
 	}
-	reexec := defaultTraceReexec
-	if config != nil && config.Reexec != nil {
-		reexec = *config.Reexec
-	}
 	statedb, err := api.backend.StateAtBlock(ctx, parent, reexec, nil, true)
 	if err != nil {
 		return nil, err
@@ -522,7 +538,7 @@ func (api *API) traceBlock(ctx context.Context, block *types.Block, config *Trac
 					TxIndex:   task.index,
 					TxHash:    txs[task.index].Hash(),
 				}
-				res, err := api.traceTx(ctx, msg, txctx, blockCtx, task.statedb, config)
+				res, err := trace(msg, txctx, blockCtx, task.statedb)
 				if err != nil {
 					results[task.index] = &txTraceResult{Error: err.Error()}
 					continue
@@ -725,6 +741,47 @@ func (api *API) TraceTransaction(ctx context.Context, hash common.Hash, config *
 // top of the provided block and returns them as a JSON object.
 // You can provide -2 as a block number to trace on top of the pending block.
 func (api *API) TraceCall(ctx context.Context, args czzapi.TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (interface{}, error) {
+	statedb, vmctx, traceConfig, err := api.traceCallEnv(ctx, blockNrOrHash, config)
+	if err != nil {
+		return nil, err
+	}
+	// Execute the trace
+	msg, err := args.ToMessage(api.backend.RPCGasCap(), vmctx.BaseFee)
+	if err != nil {
+		return nil, err
+	}
+	return api.traceTx(ctx, msg, new(Context), vmctx, statedb, traceConfig)
+}
+
+// TraceCallMany lets you trace a sequence of eth_calls, each one executed on
+// top of the state left behind by the previous ones. It returns the traces in
+// the order of the calls, and fails if any of the calls cannot be executed.
+func (api *API) TraceCallMany(ctx context.Context, args []czzapi.TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) ([]interface{}, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no calls specified")
+	}
+	statedb, vmctx, traceConfig, err := api.traceCallEnv(ctx, blockNrOrHash, config)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, len(args))
+	for i, call := range args {
+		msg, err := call.ToMessage(api.backend.RPCGasCap(), vmctx.BaseFee)
+		if err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+		if results[i], err = api.traceTx(ctx, msg, &Context{TxIndex: i}, vmctx, statedb, traceConfig); err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+		// Make the changes of the call visible to the next one
+		statedb.Finalise(true)
+	}
+	return results, nil
+}
+
+// traceCallEnv assembles the state and block context to trace calls with on top
+// of the requested block, applying the state and block overrides of the config.
+func (api *API) traceCallEnv(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (*state.StateDB, vm.BlockContext, *TraceConfig, error) {
 	// Try to retrieve the specified block
 	var (
 		err   error
@@ -735,10 +792,10 @@ func (api *API) TraceCall(ctx context.Context, args czzapi.TransactionArgs, bloc
 	} else if number, ok := blockNrOrHash.Number(); ok {
 		block, err = api.blockByNumber(ctx, number)
 	} else {
-		return nil, errors.New("invalid arguments; neither block nor hash specified")
+		return nil, vm.BlockContext{}, nil, errors.New("invalid arguments; neither block nor hash specified")
 	}
 	if err != nil {
-		return nil, err
+		return nil, vm.BlockContext{}, nil, err
 	}
 	// try to recompute the state
 	reexec := defaultTraceReexec
@@ -747,38 +804,34 @@ func (api *API) TraceCall(ctx context.Context, args czzapi.TransactionArgs, bloc
 	}
 	statedb, err := api.backend.StateAtBlock(ctx, block, reexec, nil, true)
 	if err != nil {
-		return nil, err
-	}
-	// Apply the customized state rules if required.
-	if config != nil {
-		if err := config.StateOverrides.Apply(statedb); err != nil {
-			return nil, err
-		}
-	}
-	// Execute the trace
-	msg, err := args.ToMessage(api.backend.RPCGasCap(), block.BaseFee())
-	if err != nil {
-		return nil, err
+		return nil, vm.BlockContext{}, nil, err
 	}
 	vmctx := core.NewEVMBlockContext(block.Header(), api.chainContext(ctx), nil)
 
+	// Apply the customized state and block rules if required.
 	var traceConfig *TraceConfig
 	if config != nil {
+		if err := config.StateOverrides.Apply(statedb); err != nil {
+			return nil, vm.BlockContext{}, nil, err
+		}
+		config.BlockOverrides.Apply(&vmctx)
+
 		traceConfig = &TraceConfig{
-			LogConfig: config.LogConfig,
-			Tracer:    config.Tracer,
-			Timeout:   config.Timeout,
-			Reexec:    config.Reexec,
+			LogConfig:    config.LogConfig,
+			Tracer:       config.Tracer,
+			TracerConfig: config.TracerConfig,
+			Timeout:      config.Timeout,
+			Reexec:       config.Reexec,
 		}
 	}
-	return api.traceTx(ctx, msg, new(Context), vmctx, statedb, traceConfig)
+	return statedb, vmctx, traceConfig, nil
 }
 
 // traceTx configures a new tracer according to the provided configuration, and
 // executes the given message in the provided environment. The return value will
 // be tracer dependent.
 func (api *API) traceTx(ctx context.Context, message core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, config *TraceConfig) (interface{}, error) {
-	// Assemble the structured logger or the JavaScript tracer
+	// Assemble the structured logger, the native or the JavaScript tracer
 	var (
 		tracer    vm.Tracer
 		err       error
@@ -793,16 +846,18 @@ func (api *API) traceTx(ctx context.Context, message core.Message, txctx *Contex
 				return nil, err
 			}
 		}
-		// Constuct the JavaScript tracer to execute with
-		if tracer, err = New(*config.Tracer, txctx); err != nil {
+		// Constuct the native or JavaScript tracer to execute with
+		var jsonTracer JSONTracer
+		if jsonTracer, err = NewTracer(*config.Tracer, txctx, config.TracerConfig); err != nil {
 			return nil, err
 		}
+		tracer = jsonTracer
 		// Handle timeouts and RPC cancellations
 		deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
 		go func() {
 			<-deadlineCtx.Done()
 			if deadlineCtx.Err() == context.DeadlineExceeded {
-				tracer.(*Tracer).Stop(errors.New("execution timeout"))
+				jsonTracer.Stop(errors.New("execution timeout"))
 			}
 		}()
 		defer cancel()
@@ -839,7 +894,7 @@ func (api *API) traceTx(ctx context.Context, message core.Message, txctx *Contex
 			StructLogs:  czzapi.FormatLogs(tracer.StructLogs()),
 		}, nil
 
-	case *Tracer:
+	case JSONTracer:
 		return tracer.GetResult()
 
 	default:
@@ -850,6 +905,7 @@ func (api *API) traceTx(ctx context.Context, message core.Message, txctx *Contex
 // APIs return the collection of RPC services the tracer package offers.
 func APIs(backend Backend) []rpc.API {
 	// Append all the local APIs and return
+	traceAPI := NewTraceAPI(backend)
 	return []rpc.API{
 		{
 			Namespace: "debug",
@@ -857,5 +913,17 @@ func APIs(backend Backend) []rpc.API {
 			Service:   NewAPI(backend),
 			Public:    false,
 		},
+		{
+			Namespace: "trace",
+			Version:   "1.0",
+			Service:   traceAPI,
+			Public:    false,
+		},
+		{
+			Namespace: "trace",
+			Version:   "1.0",
+			Service:   &traceSubscriptionAPI{api: traceAPI},
+			Public:    false,
+		},
 	}
 }
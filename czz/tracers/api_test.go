@@ -59,8 +59,12 @@ type testBackend struct {
 }
 
 func newTestBackend(t *testing.T, n int, gspec *core.Genesis, generator func(i int, b *core.BlockGen)) *testBackend {
+	// The test config leaves the CIP forks unset, which the engine can't handle
+	config := *params.TestChainConfig
+	config.CIP_4, config.CIP_5 = big.NewInt(1000000), big.NewInt(1000000)
+
 	backend := &testBackend{
-		chainConfig: params.TestChainConfig,
+		chainConfig: &config,
 		engine:      ethash.NewFaker(),
 		chaindb:     rawdb.NewMemoryDatabase(),
 	}
@@ -171,7 +175,7 @@ func (b *testBackend) StateAtTransaction(ctx context.Context, block *types.Block
 		if _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(tx.Gas())); err != nil {
 			return nil, vm.BlockContext{}, nil, fmt.Errorf("transaction %#x failed: %v", tx.Hash(), err)
 		}
-		statedb.Finalise(vmenv.ChainConfig().IsEIP158(block.Number()))
+		statedb.Finalise(true)
 	}
 	return nil, vm.BlockContext{}, nil, fmt.Errorf("transaction index %d out of range for block %#x", txIndex, block.Hash())
 }
@@ -447,6 +451,76 @@ func TestOverriddenTraceCall(t *testing.T) {
 	}
 }
 
+func TestTraceCallMany(t *testing.T) {
+	t.Parallel()
+
+	// Initialize test accounts
+	accounts := newAccounts(1)
+	genesis := &core.Genesis{Alloc: core.GenesisAlloc{
+		accounts[0].addr: {Balance: big.NewInt(params.Ether)},
+	}}
+	api := NewAPI(newTestBackend(t, 1, genesis, func(i int, b *core.BlockGen) {}))
+	randomAccounts, tracer := newAccounts(3), "callTracer"
+
+	// Contract returning the current block number
+	overrides := &czzapi.StateOverride{
+		randomAccounts[0].addr: czzapi.OverrideAccount{Balance: newRPCBalance(big.NewInt(1000))},
+		randomAccounts[2].addr: czzapi.OverrideAccount{Code: newRPCBytes(common.Hex2Bytes("4360005260206000f3"))},
+	}
+	number := rpc.LatestBlockNumber
+
+	// The block overrides should be visible to the executed code
+	result, err := api.TraceCall(context.Background(), czzapi.TransactionArgs{
+		From: &randomAccounts[0].addr,
+		To:   &randomAccounts[2].addr,
+	}, rpc.BlockNumberOrHash{BlockNumber: &number}, &TraceCallConfig{
+		Tracer:         &tracer,
+		StateOverrides: overrides,
+		BlockOverrides: &czzapi.BlockOverrides{Number: (*hexutil.Big)(big.NewInt(1234))},
+	})
+	if err != nil {
+		t.Fatalf("failed to trace call: %v", err)
+	}
+	ret := new(callTrace)
+	if err := json.Unmarshal(result.(json.RawMessage), ret); err != nil {
+		t.Fatalf("failed to unmarshal trace result: %v", err)
+	}
+	if want := common.BigToHash(big.NewInt(1234)).Bytes(); !bytes.Equal(ret.Output, want) {
+		t.Errorf("block number mismatch: have %x, want %x", []byte(ret.Output), want)
+	}
+	// The second call can only succeed if it runs on top of the first one
+	calls := []czzapi.TransactionArgs{
+		{
+			From:  &randomAccounts[0].addr,
+			To:    &randomAccounts[1].addr,
+			Value: (*hexutil.Big)(big.NewInt(1000)),
+		},
+		{
+			From:  &randomAccounts[1].addr,
+			To:    &randomAccounts[0].addr,
+			Value: (*hexutil.Big)(big.NewInt(1000)),
+		},
+	}
+	results, err := api.TraceCallMany(context.Background(), calls, rpc.BlockNumberOrHash{BlockNumber: &number}, &TraceCallConfig{
+		Tracer:         &tracer,
+		StateOverrides: overrides,
+	})
+	if err != nil {
+		t.Fatalf("failed to trace calls: %v", err)
+	}
+	if len(results) != len(calls) {
+		t.Fatalf("result count mismatch: have %d, want %d", len(results), len(calls))
+	}
+	// Without the first call the second one runs out of funds
+	_, err = api.TraceCallMany(context.Background(), calls[1:], rpc.BlockNumberOrHash{BlockNumber: &number}, &TraceCallConfig{
+		Tracer:         &tracer,
+		StateOverrides: overrides,
+	})
+	if !errors.Is(err, core.ErrInsufficientFunds) {
+		t.Errorf("error mismatch: have %v, want %v", err, core.ErrInsufficientFunds)
+	}
+}
+
 func TestTraceTransaction(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,125 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/holiman/uint256"
+)
+
+// JSONTracer is a tracer producing its result as JSON, which can be aborted
+// midway. Both the JavaScript and the native tracers implement it.
+type JSONTracer interface {
+	vm.Tracer
+
+	// GetResult returns the result of the trace.
+	GetResult() (json.RawMessage, error)
+
+	// Stop aborts the trace, making GetResult return err.
+	Stop(err error)
+}
+
+// NativeConstructor creates a native tracer from its optional JSON config.
+type NativeConstructor func(ctx *Context, config json.RawMessage) (JSONTracer, error)
+
+// natives contains the native tracers by name. They take precedence over the
+// JavaScript tracers of the same name.
+var natives = make(map[string]NativeConstructor)
+
+// RegisterNativeTracer makes a native tracer available by name. A built in
+// JavaScript tracer shadowed by it remains available with a "Js" suffix.
+func RegisterNativeTracer(name string, ctor NativeConstructor) {
+	natives[name] = ctor
+}
+
+// NewTracer creates the tracer with the given name, or a JavaScript tracer if
+// code isn't the name of a native tracer.
+func NewTracer(code string, ctx *Context, config json.RawMessage) (JSONTracer, error) {
+	if ctor, ok := natives[code]; ok {
+		return ctor(ctx, config)
+	}
+	return New(code, ctx)
+}
+
+// nativeTracer contains the interruption logic shared by the native tracers.
+type nativeTracer struct {
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *nativeTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// interrupted reports whether the trace was stopped, aborting the EVM if so.
+func (t *nativeTracer) interrupted(env *vm.EVM) bool {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		env.Cancel()
+		return true
+	}
+	return false
+}
+
+// stopped returns the reason the trace was stopped for, if it was.
+func (t *nativeTracer) stopped() error {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return t.reason
+	}
+	return nil
+}
+
+// result returns the marshalled result unless the trace was stopped.
+func (t *nativeTracer) result(v interface{}) (json.RawMessage, error) {
+	if err := t.stopped(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// isPrecompiled reports whether addr is one of the active precompiles.
+func isPrecompiled(precompiles []common.Address, addr common.Address) bool {
+	for _, p := range precompiles {
+		if p == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// stackUint64 returns the stack item as an integer, saturating on overflow
+// like the out of bound memory checks of the JavaScript tracers expect.
+func stackUint64(v *uint256.Int) uint64 {
+	if !v.IsUint64() {
+		return ^uint64(0)
+	}
+	return v.Uint64()
+}
+
+// memorySlice returns a copy of memory[begin:end], or nothing if the range is
+// out of bounds.
+func memorySlice(mem *vm.Memory, begin, end uint64) []byte {
+	if end <= begin || end > uint64(mem.Len()) {
+		return []byte{}
+	}
+	return mem.GetCopy(int64(begin), int64(end-begin))
+}
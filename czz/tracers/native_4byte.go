@@ -0,0 +1,97 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/core/vm"
+)
+
+func init() {
+	RegisterNativeTracer("4byteTracer", newFourByteTracer)
+}
+
+// fourByteTracer is the native implementation of 4byte_tracer.js, counting the
+// 4 byte method identifiers and call data sizes of all calls made by a
+// transaction, keyed as "0x<id>-<size>".
+type fourByteTracer struct {
+	nativeTracer
+
+	ids         map[string]int
+	precompiles []common.Address
+}
+
+func newFourByteTracer(ctx *Context, config json.RawMessage) (JSONTracer, error) {
+	return &fourByteTracer{ids: make(map[string]int)}, nil
+}
+
+// store records an occurrence of the method id with the given call data size.
+func (t *fourByteTracer) store(id []byte, size uint64) {
+	t.ids[hexutil.Encode(id)+"-"+strconv.FormatUint(size, 10)]++
+}
+
+// CaptureStart implements the vm.Tracer interface to initialize the tracing operation.
+func (t *fourByteTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.precompiles = vm.ActivePrecompiles(env.ChainConfig().Rules(env.Context.BlockNumber))
+	if len(input) >= 4 {
+		t.store(input[:4], uint64(len(input)-4))
+	}
+}
+
+// CaptureState implements the vm.Tracer interface to trace a single step of VM execution.
+func (t *fourByteTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.interrupted(env) {
+		return
+	}
+	// Locate the call data on the stack, its position depends on the opcode
+	var pos int
+	switch op {
+	case vm.CALL, vm.CALLCODE:
+		pos = 3
+	case vm.DELEGATECALL, vm.STATICCALL:
+		pos = 2
+	default:
+		return
+	}
+	stack := scope.Stack
+	if isPrecompiled(t.precompiles, common.BytesToAddress(stack.Back(1).Bytes())) {
+		return
+	}
+	if size := stackUint64(stack.Back(pos + 1)); size >= 4 {
+		off := stackUint64(stack.Back(pos))
+		t.store(memorySlice(scope.Memory, off, addSaturated(off, 4)), size-4)
+	}
+}
+
+// CaptureFault implements the vm.Tracer interface to trace an execution fault.
+func (t *fourByteTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *fourByteTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {
+}
+
+// GetResult returns the method identifier counts.
+func (t *fourByteTracer) GetResult() (json.RawMessage, error) {
+	return t.result(t.ids)
+}
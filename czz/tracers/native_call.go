@@ -0,0 +1,288 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/core/vm"
+)
+
+func init() {
+	RegisterNativeTracer("callTracer", newCallTracer)
+}
+
+// callFrame is a single call of the call tracer, serialized in the same field
+// order as the JavaScript tracer does.
+type callFrame struct {
+	Type    string      `json:"type"`
+	From    string      `json:"from"`
+	To      string      `json:"to,omitempty"`
+	Value   string      `json:"value,omitempty"`
+	Gas     string      `json:"gas,omitempty"`
+	GasUsed string      `json:"gasUsed,omitempty"`
+	Input   string      `json:"input,omitempty"`
+	Output  string      `json:"output,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Time    string      `json:"time,omitempty"`
+	Calls   []callFrame `json:"calls,omitempty"`
+
+	// Bookkeeping of calls still in flight
+	gasIn   uint64
+	gasCost uint64
+	gas     *uint64
+	outOff  uint64
+	outLen  uint64
+}
+
+// callTracer is the native implementation of call_tracer.js, reporting all
+// the internal calls made by a transaction.
+type callTracer struct {
+	nativeTracer
+
+	callstack   []callFrame
+	descended   bool
+	precompiles []common.Address
+
+	root callFrame
+}
+
+func newCallTracer(ctx *Context, config json.RawMessage) (JSONTracer, error) {
+	return &callTracer{callstack: make([]callFrame, 1)}, nil
+}
+
+// CaptureStart implements the vm.Tracer interface to initialize the tracing operation.
+func (t *callTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.root = callFrame{
+		Type:  "CALL",
+		From:  hexutil.Encode(from.Bytes()),
+		To:    hexutil.Encode(to.Bytes()),
+		Value: hexutil.EncodeBig(bigOrZero(value)),
+		Gas:   hexutil.EncodeUint64(gas),
+		Input: hexutil.Encode(input),
+	}
+	if create {
+		t.root.Type = "CREATE"
+	}
+	t.precompiles = vm.ActivePrecompiles(env.ChainConfig().Rules(env.Context.BlockNumber))
+}
+
+// CaptureState implements the vm.Tracer interface to trace a single step of VM execution.
+func (t *callTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.interrupted(env) {
+		return
+	}
+	// Capture any errors immediately
+	if err != nil {
+		t.fault(err)
+		return
+	}
+	stack, mem := scope.Stack, scope.Memory
+	switch op {
+	case vm.CREATE, vm.CREATE2:
+		// A new contract is being created, add to the call stack
+		inOff := stackUint64(stack.Back(1))
+		t.callstack = append(t.callstack, callFrame{
+			Type:    op.String(),
+			From:    hexutil.Encode(scope.Contract.Address().Bytes()),
+			Input:   hexutil.Encode(memorySlice(mem, inOff, addSaturated(inOff, stackUint64(stack.Back(2))))),
+			Value:   hexutil.EncodeBig(stack.Back(0).ToBig()),
+			gasIn:   gas,
+			gasCost: cost,
+		})
+		t.descended = true
+		return
+
+	case vm.SELFDESTRUCT:
+		// A contract is being self destructed, gather that as a subcall too
+		parent := &t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, callFrame{
+			Type:    op.String(),
+			From:    hexutil.Encode(scope.Contract.Address().Bytes()),
+			To:      hexutil.Encode(common.BytesToAddress(stack.Back(0).Bytes()).Bytes()),
+			Value:   hexutil.EncodeBig(env.StateDB.GetBalance(scope.Contract.Address())),
+			gasIn:   gas,
+			gasCost: cost,
+		})
+		return
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		// Skip any pre-compile invocations, those are just fancy opcodes
+		to := common.BytesToAddress(stack.Back(1).Bytes())
+		if isPrecompiled(t.precompiles, to) {
+			return
+		}
+		off := 1
+		if op == vm.DELEGATECALL || op == vm.STATICCALL {
+			off = 0
+		}
+		inOff := stackUint64(stack.Back(2 + off))
+		call := callFrame{
+			Type:    op.String(),
+			From:    hexutil.Encode(scope.Contract.Address().Bytes()),
+			To:      hexutil.Encode(to.Bytes()),
+			Input:   hexutil.Encode(memorySlice(mem, inOff, addSaturated(inOff, stackUint64(stack.Back(3+off))))),
+			gasIn:   gas,
+			gasCost: cost,
+			outOff:  stackUint64(stack.Back(4 + off)),
+			outLen:  stackUint64(stack.Back(5 + off)),
+		}
+		if off == 1 {
+			call.Value = hexutil.EncodeBig(stack.Back(2).ToBig())
+		}
+		t.callstack = append(t.callstack, call)
+		t.descended = true
+		return
+	}
+	// If we've just descended into an inner call, retrieve its true allowance. It
+	// is extracted from within the call as there may be funky gas dynamics with
+	// regard to requested and actually given gas (2300 stipend, 63/64 rule). For
+	// calls to plain accounts the true gas amount isn't available, so it's skipped.
+	if t.descended {
+		if depth >= len(t.callstack) {
+			gas := gas
+			t.callstack[len(t.callstack)-1].gas = &gas
+		}
+		t.descended = false
+	}
+	// If an existing call is returning, pop off the call stack
+	if op == vm.REVERT {
+		t.callstack[len(t.callstack)-1].Error = "execution reverted"
+		return
+	}
+	if depth != len(t.callstack)-1 {
+		return
+	}
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	ret := stack.Back(0)
+	if call.Type == vm.CREATE.String() || call.Type == vm.CREATE2.String() {
+		// If the call was a CREATE, retrieve the contract address and output code
+		call.GasUsed = hexInt(int64(call.gasIn - call.gasCost - gas))
+		if !ret.IsZero() {
+			addr := common.BytesToAddress(ret.Bytes())
+			call.To = hexutil.Encode(addr.Bytes())
+			call.Output = hexutil.Encode(env.StateDB.GetCode(addr))
+		} else if call.Error == "" {
+			call.Error = "internal failure"
+		}
+	} else {
+		// If the call was a contract call, retrieve the gas usage and output
+		if call.gas != nil {
+			call.GasUsed = hexInt(int64(call.gasIn - call.gasCost + *call.gas - gas))
+		}
+		if !ret.IsZero() {
+			call.Output = hexutil.Encode(memorySlice(mem, call.outOff, addSaturated(call.outOff, call.outLen)))
+		} else if call.Error == "" {
+			call.Error = "internal failure"
+		}
+	}
+	if call.gas != nil {
+		call.Gas = hexutil.EncodeUint64(*call.gas)
+	}
+	parent := &t.callstack[len(t.callstack)-1]
+	parent.Calls = append(parent.Calls, call)
+}
+
+// CaptureFault implements the vm.Tracer interface to trace an execution fault.
+func (t *callTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	if t.interrupted(env) {
+		return
+	}
+	t.fault(err)
+}
+
+// fault handles the failure of the topmost call.
+func (t *callTracer) fault(err error) {
+	// If the topmost call already reverted, don't handle the additional fault again
+	if t.callstack[len(t.callstack)-1].Error != "" {
+		return
+	}
+	// Pop off the just failed call, consuming all available gas
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	call.Error = err.Error()
+	if call.gas != nil {
+		call.Gas = hexutil.EncodeUint64(*call.gas)
+		call.GasUsed = call.Gas
+	}
+	// Flatten the failed call into its parent, or leave it in the stack if it
+	// was the last one
+	if len(t.callstack) == 0 {
+		t.callstack = append(t.callstack, call)
+		return
+	}
+	parent := &t.callstack[len(t.callstack)-1]
+	parent.Calls = append(parent.Calls, call)
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *callTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {
+	t.root.GasUsed = hexutil.EncodeUint64(gasUsed)
+	t.root.Output = hexutil.Encode(output)
+	t.root.Time = d.String()
+	if err != nil {
+		t.root.Error = err.Error()
+	}
+}
+
+// GetResult returns the call tree of the transaction.
+func (t *callTracer) GetResult() (json.RawMessage, error) {
+	return t.result(t.frame())
+}
+
+// frame assembles the call tree of the transaction.
+func (t *callTracer) frame() callFrame {
+	result := t.root
+	result.Calls = t.callstack[0].Calls
+	if t.callstack[0].Error != "" {
+		result.Error = t.callstack[0].Error
+	}
+	if result.Error != "" && (result.Error != "execution reverted" || result.Output == "0x") {
+		result.Output = ""
+	}
+	return result
+}
+
+// addSaturated returns a+b, capped at the maximum uint64.
+func addSaturated(a, b uint64) uint64 {
+	if a+b < a {
+		return ^uint64(0)
+	}
+	return a + b
+}
+
+// hexInt formats a possibly negative gas difference the way the JavaScript
+// tracers do.
+func hexInt(v int64) string {
+	return "0x" + strconv.FormatInt(v, 16)
+}
+
+// bigOrZero returns v, or zero if it is nil.
+func bigOrZero(v *big.Int) *big.Int {
+	if v == nil {
+		return new(big.Int)
+	}
+	return v
+}
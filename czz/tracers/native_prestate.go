@@ -0,0 +1,290 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/classzz/go-classzz-v2/crypto"
+)
+
+func init() {
+	RegisterNativeTracer("prestateTracer", newPrestateTracer)
+}
+
+// prestateAccount is the state of an account touched by a transaction.
+type prestateAccount struct {
+	Balance string            `json:"balance"`
+	Nonce   uint64            `json:"nonce"`
+	Code    string            `json:"code"`
+	Storage map[string]string `json:"storage"`
+}
+
+// poststateAccount contains the fields of an account changed by a transaction.
+type poststateAccount struct {
+	Balance string            `json:"balance,omitempty"`
+	Nonce   *uint64           `json:"nonce,omitempty"`
+	Code    string            `json:"code,omitempty"`
+	Storage map[string]string `json:"storage,omitempty"`
+}
+
+// prestateTracerConfig are the options of the prestate tracer.
+type prestateTracerConfig struct {
+	DiffMode bool `json:"diffMode"` // Report the state changes instead of the touched state
+}
+
+// prestateTracer is the native implementation of prestate_tracer.js, reporting
+// the state of all accounts and storage slots touched by a transaction. In diff
+// mode it reports the modified state before and after the transaction instead.
+type prestateTracer struct {
+	nativeTracer
+	config prestateTracerConfig
+
+	env          *vm.EVM
+	prestate     map[common.Address]*prestateAccount
+	created      map[common.Address]bool
+	from, to     common.Address
+	create       bool
+	value        *big.Int
+	intrinsicGas uint64
+	gasUsed      uint64
+}
+
+func newPrestateTracer(ctx *Context, config json.RawMessage) (JSONTracer, error) {
+	t := &prestateTracer{
+		prestate: make(map[common.Address]*prestateAccount),
+		created:  make(map[common.Address]bool),
+	}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &t.config); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// CaptureStart implements the vm.Tracer interface to initialize the tracing operation.
+func (t *prestateTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+	t.from, t.to, t.create = from, to, create
+	t.value = bigOrZero(value)
+
+	iscip1 := env.ChainConfig().IsCIP1(env.Context.BlockNumber)
+	t.intrinsicGas, _ = core.IntrinsicGas(input, nil, create, iscip1)
+
+	// Track the recipient, which isn't otherwise seen if it has no code
+	t.lookupAccount(to)
+	if create {
+		t.created[to] = true
+	}
+	if t.config.DiffMode {
+		// The gas was already bought and the nonce and value transferred, roll
+		// them back to get the exact state before the transaction. The coinbase
+		// is only paid after the execution.
+		t.lookupAccount(from)
+		t.lookupAccount(env.Context.Coinbase)
+
+		toAcc := t.prestate[to]
+		toBal := hexutil.MustDecodeBig(toAcc.Balance)
+		toAcc.Balance = hexutil.EncodeBig(toBal.Sub(toBal, t.value))
+
+		fee := new(big.Int).SetUint64(gas + t.intrinsicGas)
+		fee.Mul(fee, env.TxContext.GasPrice)
+		fromAcc := t.prestate[from]
+		fromBal := hexutil.MustDecodeBig(fromAcc.Balance)
+		fromAcc.Balance = hexutil.EncodeBig(fromBal.Add(fromBal, t.value).Add(fromBal, fee))
+		fromAcc.Nonce--
+	}
+}
+
+// CaptureState implements the vm.Tracer interface to trace a single step of VM execution.
+func (t *prestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.interrupted(env) {
+		return
+	}
+	stack, caller := scope.Stack, scope.Contract.Address()
+	switch op {
+	case vm.EXTCODECOPY, vm.EXTCODESIZE, vm.BALANCE:
+		t.lookupAccount(common.BytesToAddress(stack.Back(0).Bytes()))
+	case vm.CREATE:
+		addr := crypto.CreateAddress(caller, env.StateDB.GetNonce(caller))
+		t.lookupAccount(addr)
+	case vm.CREATE2:
+		offset := stackUint64(stack.Back(1))
+		code := memorySlice(scope.Memory, offset, addSaturated(offset, stackUint64(stack.Back(2))))
+		addr := crypto.CreateAddress2(caller, common.Hash(stack.Back(3).Bytes32()), crypto.Keccak256(code))
+		t.lookupAccount(addr)
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.lookupAccount(common.BytesToAddress(stack.Back(1).Bytes()))
+	case vm.SSTORE, vm.SLOAD:
+		t.lookupStorage(caller, common.Hash(stack.Back(0).Bytes32()))
+	case vm.SELFDESTRUCT:
+		// The beneficiary isn't part of the touched state, but it's modified
+		if t.config.DiffMode {
+			t.lookupAccount(common.BytesToAddress(stack.Back(0).Bytes()))
+		}
+	}
+}
+
+// CaptureFault implements the vm.Tracer interface to trace an execution fault.
+func (t *prestateTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *prestateTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {
+	t.gasUsed = gasUsed
+}
+
+// GetResult returns the touched state, or the state diff in diff mode.
+func (t *prestateTracer) GetResult() (json.RawMessage, error) {
+	if t.env == nil {
+		return t.result(map[string]*prestateAccount{})
+	}
+	if t.config.DiffMode {
+		pre, post := t.diff()
+
+		encoded := make(map[string]*poststateAccount, len(post))
+		for addr, acc := range post {
+			encoded[hexutil.Encode(addr.Bytes())] = acc
+		}
+		return t.result(map[string]interface{}{
+			"pre":  t.encode(pre),
+			"post": encoded,
+		})
+	}
+	t.lookupAccount(t.from)
+
+	// The accounts were looked up mid-transaction, roll back the changes made
+	// before the execution started
+	from, to := t.prestate[t.from], t.prestate[t.to]
+
+	toBal := hexutil.MustDecodeBig(to.Balance)
+	to.Balance = hexutil.EncodeBig(toBal.Sub(toBal, t.value))
+
+	fee := new(big.Int).SetUint64(t.gasUsed + t.intrinsicGas)
+	fee.Mul(fee, t.env.TxContext.GasPrice)
+	fromBal := hexutil.MustDecodeBig(from.Balance)
+	from.Balance = hexutil.EncodeBig(fromBal.Add(fromBal, t.value).Add(fromBal, fee))
+	from.Nonce--
+
+	if t.create {
+		delete(t.prestate, t.to)
+	}
+	return t.result(t.encode(t.prestate))
+}
+
+// diff assembles the pre and post state of the accounts and storage slots
+// modified by the transaction. Created accounts have no pre state, destructed
+// ones no post state.
+func (t *prestateTracer) diff() (map[common.Address]*prestateAccount, map[common.Address]*poststateAccount) {
+	var (
+		db   = t.env.StateDB
+		pre  = make(map[common.Address]*prestateAccount)
+		post = make(map[common.Address]*poststateAccount)
+	)
+	for addr, prev := range t.prestate {
+		if db.HasSuicided(addr) {
+			if !t.created[addr] {
+				pre[addr] = prev
+			}
+			continue
+		}
+		// Accounts created by the transaction have no pre state to report
+		if t.created[addr] {
+			prev = &prestateAccount{Balance: "0x0", Code: "0x", Storage: prev.Storage}
+		}
+		var (
+			changed bool
+			cur     = new(poststateAccount)
+		)
+		if balance := hexutil.EncodeBig(db.GetBalance(addr)); balance != prev.Balance {
+			cur.Balance, changed = balance, true
+		}
+		if nonce := db.GetNonce(addr); nonce != prev.Nonce {
+			cur.Nonce, changed = &nonce, true
+		}
+		if code := hexutil.Encode(db.GetCode(addr)); code != prev.Code {
+			cur.Code, changed = code, true
+		}
+		storage := make(map[string]string)
+		for key, val := range prev.Storage {
+			if now := db.GetState(addr, common.HexToHash(key)).Hex(); now != val {
+				cur.Storage = storageOrNew(cur.Storage)
+				cur.Storage[key] = now
+				storage[key] = val
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		post[addr] = cur
+		if !t.created[addr] {
+			pre[addr] = &prestateAccount{Balance: prev.Balance, Nonce: prev.Nonce, Code: prev.Code, Storage: storage}
+		}
+	}
+	return pre, post
+}
+
+// encode keys the accounts by their hex encoded addresses.
+func (t *prestateTracer) encode(accounts map[common.Address]*prestateAccount) map[string]*prestateAccount {
+	res := make(map[string]*prestateAccount, len(accounts))
+	for addr, acc := range accounts {
+		res[hexutil.Encode(addr.Bytes())] = acc
+	}
+	return res
+}
+
+// lookupAccount records the state of an account the first time it's touched.
+func (t *prestateTracer) lookupAccount(addr common.Address) {
+	if _, ok := t.prestate[addr]; ok {
+		return
+	}
+	db := t.env.StateDB
+	if !db.Exist(addr) {
+		t.created[addr] = true
+	}
+	t.prestate[addr] = &prestateAccount{
+		Balance: hexutil.EncodeBig(db.GetBalance(addr)),
+		Nonce:   db.GetNonce(addr),
+		Code:    hexutil.Encode(db.GetCode(addr)),
+		Storage: make(map[string]string),
+	}
+}
+
+// lookupStorage records the value of a storage slot the first time it's touched.
+func (t *prestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	t.lookupAccount(addr)
+	storage := t.prestate[addr].Storage
+	if _, ok := storage[key.Hex()]; !ok {
+		storage[key.Hex()] = t.env.StateDB.GetState(addr, key).Hex()
+	}
+}
+
+// storageOrNew returns storage, allocating it if it's nil.
+func storageOrNew(storage map[string]string) map[string]string {
+	if storage == nil {
+		return make(map[string]string)
+	}
+	return storage
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/classzz/go-classzz-v2/rlp"
+	"github.com/classzz/go-classzz-v2/tests"
+)
+
+// loadCallTracerTests reads the call tracer test suite from disk.
+func loadCallTracerTests(t *testing.T) map[string]*callTracerTest {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	suite := make(map[string]*callTracerTest)
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "call_tracer_") {
+			continue
+		}
+		blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+		if err != nil {
+			t.Fatalf("failed to read testcase: %v", err)
+		}
+		test := new(callTracerTest)
+		if err := json.Unmarshal(blob, test); err != nil {
+			t.Fatalf("failed to parse testcase: %v", err)
+		}
+		suite[camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json"))] = test
+	}
+	return suite
+}
+
+// runTracerTest executes the transaction of a call tracer test with the named
+// tracer, returning the trace result.
+func runTracerTest(t *testing.T, test *callTracerTest, name string, config json.RawMessage) json.RawMessage {
+	tracer, err := NewTracer(name, new(Context), config)
+	if err != nil {
+		t.Fatalf("failed to create tracer %s: %v", name, err)
+	}
+	applyTracerTest(t, test, tracer)
+
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	return res
+}
+
+// applyTracerTest executes the transaction of a call tracer test with the given
+// tracer attached.
+func applyTracerTest(t *testing.T, test *callTracerTest, tracer vm.Tracer) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		t.Fatalf("failed to parse testcase input: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	origin, _ := signer.Sender(tx)
+	txContext := vm.TxContext{
+		Origin:   origin,
+		GasPrice: tx.GasPrice(),
+	}
+	context := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+		BaseFee:     new(big.Int),
+	}
+	_, statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), test.Genesis.Alloc, false)
+
+	evm := vm.NewEVM(context, txContext, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+
+	msg, err := tx.AsMessage(signer, nil)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+}
+
+// Tests that the native tracers produce the same output as their JavaScript
+// counterparts on every transaction of the test suite, gas included. Only the
+// execution time reported by the call tracers differs between runs.
+func TestNativeTracersMatchJS(t *testing.T) {
+	for name, test := range loadCallTracerTests(t) {
+		test := test // capture range variable
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, tracer := range []string{"callTracer", "prestateTracer", "4byteTracer"} {
+				var native, js interface{}
+				if err := json.Unmarshal(runTracerTest(t, test, tracer, nil), &native); err != nil {
+					t.Fatalf("%s: failed to unmarshal native result: %v", tracer, err)
+				}
+				if err := json.Unmarshal(runTracerTest(t, test, tracer+"Js", nil), &js); err != nil {
+					t.Fatalf("%s: failed to unmarshal JavaScript result: %v", tracer, err)
+				}
+				if tracer == "callTracer" {
+					delete(native.(map[string]interface{}), "time")
+					delete(js.(map[string]interface{}), "time")
+				}
+				if !reflect.DeepEqual(native, js) {
+					t.Errorf("%s: result mismatch:\nnative     %v\njavascript %v", tracer, native, js)
+				}
+			}
+		})
+	}
+}
+
+// Tests that the prestate tracer in diff mode only reports modified state.
+func TestPrestateTracerDiffMode(t *testing.T) {
+	test := loadCallTracerTests(t)["simple"]
+
+	var prestate map[string]prestateAccount
+	if err := json.Unmarshal(runTracerTest(t, test, "prestateTracer", nil), &prestate); err != nil {
+		t.Fatalf("failed to unmarshal prestate: %v", err)
+	}
+	var diff struct {
+		Pre  map[string]prestateAccount  `json:"pre"`
+		Post map[string]poststateAccount `json:"post"`
+	}
+	if err := json.Unmarshal(runTracerTest(t, test, "prestateTracer", json.RawMessage(`{"diffMode":true}`)), &diff); err != nil {
+		t.Fatalf("failed to unmarshal state diff: %v", err)
+	}
+	if len(diff.Post) == 0 {
+		t.Fatal("no modified accounts reported")
+	}
+	for addr, post := range diff.Post {
+		pre, ok := diff.Pre[addr]
+		if !ok {
+			// Only accounts created by the transaction lack a pre state
+			if _, existed := test.Genesis.Alloc[common.HexToAddress(addr)]; existed {
+				t.Errorf("account %s: modified without pre state", addr)
+			}
+			continue
+		}
+		if want, ok := prestate[addr]; ok && (pre.Nonce != want.Nonce || pre.Code != want.Code) {
+			t.Errorf("account %s: pre state mismatch: have %+v, want %+v", addr, pre, want)
+		}
+		if want := test.Genesis.Alloc[common.HexToAddress(addr)].Balance; pre.Balance != hexutil.EncodeBig(want) {
+			t.Errorf("account %s: pre balance mismatch: have %s, want %s", addr, pre.Balance, hexutil.EncodeBig(want))
+		}
+		if post.Balance == pre.Balance || post.Nonce != nil && *post.Nonce == pre.Nonce {
+			t.Errorf("account %s: unmodified field reported: pre %+v, post %+v", addr, pre, post)
+		}
+		for key, val := range post.Storage {
+			if val == pre.Storage[key] {
+				t.Errorf("account %s: unmodified slot %s reported", addr, key)
+			}
+		}
+	}
+	for addr := range diff.Pre {
+		if _, ok := diff.Post[addr]; !ok {
+			t.Errorf("account %s: pre state of unmodified account reported", addr)
+		}
+	}
+}
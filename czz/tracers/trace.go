@@ -0,0 +1,707 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/rpc"
+)
+
+// filterLookahead is the number of blocks trace_filter traces ahead of the
+// results being delivered.
+const filterLookahead = 4
+
+// parityErrors maps EVM errors to the messages reported by Parity style traces.
+var parityErrors = map[string]string{
+	vm.ErrExecutionReverted.Error(): "Reverted",
+	vm.ErrOutOfGas.Error():          "Out of gas",
+	vm.ErrInvalidJump.Error():       "Bad jump destination",
+	vm.ErrWriteProtection.Error():   "Mutable Call In Static Context",
+}
+
+// ParityTrace is a single call of a transaction in the flat trace format of
+// Parity's trace module. The position of the call in the call tree is given by
+// its trace address.
+type ParityTrace struct {
+	Action              interface{}  `json:"action"`
+	BlockHash           *common.Hash `json:"blockHash,omitempty"`
+	BlockNumber         *uint64      `json:"blockNumber,omitempty"`
+	Error               string       `json:"error,omitempty"`
+	Result              interface{}  `json:"result"`
+	Subtraces           int          `json:"subtraces"`
+	TraceAddress        []int        `json:"traceAddress"`
+	TransactionHash     *common.Hash `json:"transactionHash,omitempty"`
+	TransactionPosition *uint64      `json:"transactionPosition,omitempty"`
+	Type                string       `json:"type"`
+
+	from, to common.Address // Addresses matched by trace_filter
+}
+
+type traceCallAction struct {
+	CallType string         `json:"callType"`
+	From     common.Address `json:"from"`
+	Gas      hexutil.Uint64 `json:"gas"`
+	Input    hexutil.Bytes  `json:"input"`
+	To       common.Address `json:"to"`
+	Value    *hexutil.Big   `json:"value"`
+}
+
+type traceCallResult struct {
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	Output  hexutil.Bytes  `json:"output"`
+}
+
+type traceCreateAction struct {
+	From  common.Address `json:"from"`
+	Gas   hexutil.Uint64 `json:"gas"`
+	Init  hexutil.Bytes  `json:"init"`
+	Value *hexutil.Big   `json:"value"`
+}
+
+type traceCreateResult struct {
+	Address common.Address `json:"address"`
+	Code    hexutil.Bytes  `json:"code"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+}
+
+type traceSuicideAction struct {
+	Address       common.Address `json:"address"`
+	Balance       *hexutil.Big   `json:"balance"`
+	RefundAddress common.Address `json:"refundAddress"`
+}
+
+// StateDiff is the Parity style diff of the accounts modified by a transaction.
+type StateDiff map[common.Address]*AccountDiff
+
+// AccountDiff contains the changes of a single account. Unchanged fields are
+// reported as "=", others as an object keyed by "+" for created, "-" for
+// destructed or "*" for modified accounts.
+type AccountDiff struct {
+	Balance interface{}                 `json:"balance"`
+	Code    interface{}                 `json:"code"`
+	Nonce   interface{}                 `json:"nonce"`
+	Storage map[common.Hash]interface{} `json:"storage"`
+}
+
+// TraceResults are the requested traces of a replayed transaction.
+type TraceResults struct {
+	Output          hexutil.Bytes  `json:"output"`
+	StateDiff       StateDiff      `json:"stateDiff"`
+	Trace           []*ParityTrace `json:"trace"`
+	VMTrace         *VMTrace       `json:"vmTrace"`
+	TransactionHash *common.Hash   `json:"transactionHash,omitempty"`
+}
+
+// TraceFilterArgs are the criteria of trace_filter. A trace matches if its
+// sender is in FromAddress and its recipient in ToAddress, empty lists matching
+// any address. The first After matches are skipped and at most Count returned.
+type TraceFilterArgs struct {
+	FromBlock   *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock     *rpc.BlockNumber `json:"toBlock"`
+	FromAddress []common.Address `json:"fromAddress"`
+	ToAddress   []common.Address `json:"toAddress"`
+	After       *uint64          `json:"after"`
+	Count       *uint64          `json:"count"`
+}
+
+// TraceFilterResult are the matching traces of a single block streamed by the
+// trace filter subscription.
+type TraceFilterResult struct {
+	BlockNumber uint64         `json:"blockNumber"`
+	Traces      []*ParityTrace `json:"traces"`
+}
+
+// TraceAPI is the collection of Parity style tracing APIs exposed over the
+// trace namespace.
+type TraceAPI struct {
+	api *API
+}
+
+// NewTraceAPI creates a new API definition for the Parity style tracing methods
+// of the Classzz service.
+func NewTraceAPI(backend Backend) *TraceAPI {
+	return &TraceAPI{api: NewAPI(backend)}
+}
+
+// traceKinds are the kinds of traces requested for a replayed transaction.
+type traceKinds struct {
+	trace, stateDiff, vmTrace bool
+}
+
+func parseTraceTypes(names []string) (traceKinds, error) {
+	var kinds traceKinds
+	for _, name := range names {
+		switch name {
+		case "trace":
+			kinds.trace = true
+		case "stateDiff":
+			kinds.stateDiff = true
+		case "vmTrace":
+			kinds.vmTrace = true
+		default:
+			return kinds, fmt.Errorf("unknown trace type %q", name)
+		}
+	}
+	return kinds, nil
+}
+
+// Block returns the traces of all the transactions in a block.
+func (api *TraceAPI) Block(ctx context.Context, number rpc.BlockNumber) ([]*ParityTrace, error) {
+	block, err := api.api.blockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return api.blockTraces(ctx, block)
+}
+
+// Transaction returns the traces of a transaction.
+func (api *TraceAPI) Transaction(ctx context.Context, hash common.Hash) ([]*ParityTrace, error) {
+	tx, blockHash, blockNumber, index, err := api.api.backend.GetTransaction(ctx, hash)
+	if err != nil || tx == nil {
+		return nil, err
+	}
+	res, err := api.replayTransaction(ctx, hash, blockHash, blockNumber, index, traceKinds{trace: true})
+	if err != nil {
+		return nil, err
+	}
+	annotateTraces(res.Trace, blockHash, blockNumber, hash, index)
+	return res.Trace, nil
+}
+
+// ReplayTransaction replays a transaction, returning the requested kinds of
+// traces: "trace", "stateDiff" and "vmTrace".
+func (api *TraceAPI) ReplayTransaction(ctx context.Context, hash common.Hash, traceTypes []string) (*TraceResults, error) {
+	kinds, err := parseTraceTypes(traceTypes)
+	if err != nil {
+		return nil, err
+	}
+	tx, blockHash, blockNumber, index, err := api.api.backend.GetTransaction(ctx, hash)
+	if err != nil || tx == nil {
+		return nil, err
+	}
+	return api.replayTransaction(ctx, hash, blockHash, blockNumber, index, kinds)
+}
+
+// ReplayBlockTransactions replays all the transactions in a block, returning the
+// requested kinds of traces: "trace", "stateDiff" and "vmTrace".
+func (api *TraceAPI) ReplayBlockTransactions(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash, traceTypes []string) ([]*TraceResults, error) {
+	kinds, err := parseTraceTypes(traceTypes)
+	if err != nil {
+		return nil, err
+	}
+	var block *types.Block
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		block, err = api.api.blockByHash(ctx, hash)
+	} else if number, ok := blockNrOrHash.Number(); ok {
+		block, err = api.api.blockByNumber(ctx, number)
+	} else {
+		return nil, errors.New("invalid arguments; neither block nor hash specified")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if block.NumberU64() == 0 {
+		return []*TraceResults{}, nil
+	}
+	results, err := api.api.traceBlockWith(ctx, block, defaultTraceReexec, func(msg core.Message, txctx *Context, blockCtx vm.BlockContext, statedb *state.StateDB) (interface{}, error) {
+		return api.replay(ctx, msg, txctx, blockCtx, statedb, kinds)
+	})
+	if err != nil {
+		return nil, err
+	}
+	replays := make([]*TraceResults, len(results))
+	for i, tx := range block.Transactions() {
+		if results[i].Error != "" {
+			return nil, fmt.Errorf("tracing transaction %s failed: %s", tx.Hash().Hex(), results[i].Error)
+		}
+		hash := tx.Hash()
+		replays[i] = results[i].Result.(*TraceResults)
+		replays[i].TransactionHash = &hash
+	}
+	return replays, nil
+}
+
+// Filter returns the traces matching the given criteria. Large block ranges are
+// better served by the streaming filter subscription.
+func (api *TraceAPI) Filter(ctx context.Context, args TraceFilterArgs) ([]*ParityTrace, error) {
+	traces := []*ParityTrace{}
+	err := api.filter(ctx, args, func(number uint64, matches []*ParityTrace) error {
+		traces = append(traces, matches...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return traces, nil
+}
+
+// replayTransaction replays a transaction included in the chain.
+func (api *TraceAPI) replayTransaction(ctx context.Context, hash common.Hash, blockHash common.Hash, blockNumber uint64, index uint64, kinds traceKinds) (*TraceResults, error) {
+	// It shouldn't happen in practice.
+	if blockNumber == 0 {
+		return nil, errors.New("genesis is not traceable")
+	}
+	block, err := api.api.blockByNumberAndHash(ctx, rpc.BlockNumber(blockNumber), blockHash)
+	if err != nil {
+		return nil, err
+	}
+	msg, vmctx, statedb, err := api.api.backend.StateAtTransaction(ctx, block, int(index), defaultTraceReexec)
+	if err != nil {
+		return nil, err
+	}
+	txctx := &Context{
+		BlockHash: blockHash,
+		TxIndex:   int(index),
+		TxHash:    hash,
+	}
+	return api.replay(ctx, msg, txctx, vmctx, statedb, kinds)
+}
+
+// replay executes the given message in the provided environment, collecting
+// the requested kinds of traces.
+func (api *TraceAPI) replay(ctx context.Context, message core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, kinds traceKinds) (*TraceResults, error) {
+	var (
+		calls    *callTracer
+		prestate *prestateTracer
+		vmtrace  *vmTracer
+		tracers  multiTracer
+	)
+	if kinds.trace {
+		tracer, _ := newCallTracer(txctx, nil)
+		calls = tracer.(*callTracer)
+		tracers = append(tracers, calls)
+	}
+	if kinds.stateDiff {
+		tracer, _ := newPrestateTracer(txctx, json.RawMessage(`{"diffMode":true}`))
+		prestate = tracer.(*prestateTracer)
+		tracers = append(tracers, prestate)
+	}
+	if kinds.vmTrace {
+		vmtrace = newVMTracer()
+		tracers = append(tracers, vmtrace)
+	}
+	// Handle timeouts and RPC cancellations
+	deadlineCtx, cancel := context.WithTimeout(ctx, defaultTraceTimeout)
+	go func() {
+		<-deadlineCtx.Done()
+		if deadlineCtx.Err() == context.DeadlineExceeded {
+			tracers.Stop(errors.New("execution timeout"))
+		}
+	}()
+	defer cancel()
+
+	// Run the transaction with tracing enabled.
+	vmenv := vm.NewEVM(vmctx, core.NewEVMTxContext(message), statedb, api.api.backend.ChainConfig(), vm.Config{Debug: true, Tracer: tracers, NoBaseFee: true})
+
+	// Call Prepare to clear out the statedb access list
+	statedb.Prepare(txctx.TxHash, txctx.TxIndex)
+
+	result, err := core.ApplyMessage(vmenv, message, new(core.GasPool).AddGas(message.Gas()))
+	if err != nil {
+		return nil, fmt.Errorf("tracing failed: %w", err)
+	}
+	if err := tracers.stopped(); err != nil {
+		return nil, err
+	}
+	res := &TraceResults{Output: result.ReturnData}
+	if calls != nil {
+		res.Trace = flattenCalls(calls.frame(), []int{}, nil)
+	}
+	if prestate != nil {
+		res.StateDiff = newStateDiff(prestate.diff())
+	}
+	if vmtrace != nil {
+		res.VMTrace = vmtrace.root
+	}
+	return res, nil
+}
+
+// blockTraces returns the traces of all the transactions in a block.
+func (api *TraceAPI) blockTraces(ctx context.Context, block *types.Block) ([]*ParityTrace, error) {
+	if block.NumberU64() == 0 {
+		return []*ParityTrace{}, nil
+	}
+	results, err := api.api.traceBlockWith(ctx, block, defaultTraceReexec, func(msg core.Message, txctx *Context, blockCtx vm.BlockContext, statedb *state.StateDB) (interface{}, error) {
+		res, err := api.replay(ctx, msg, txctx, blockCtx, statedb, traceKinds{trace: true})
+		if err != nil {
+			return nil, err
+		}
+		return res.Trace, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	traces := []*ParityTrace{}
+	for i, tx := range block.Transactions() {
+		if results[i].Error != "" {
+			return nil, fmt.Errorf("tracing transaction %s failed: %s", tx.Hash().Hex(), results[i].Error)
+		}
+		txTraces := results[i].Result.([]*ParityTrace)
+		annotateTraces(txTraces, block.Hash(), block.NumberU64(), tx.Hash(), uint64(i))
+		traces = append(traces, txTraces...)
+	}
+	return traces, nil
+}
+
+// filter traces the block range of the criteria, delivering the matching
+// traces block by block. Blocks are traced ahead of the delivery, which stops
+// early once enough traces were delivered.
+func (api *TraceAPI) filter(ctx context.Context, args TraceFilterArgs, deliver func(number uint64, traces []*ParityTrace) error) error {
+	start, err := api.resolveBlock(ctx, args.FromBlock, rpc.EarliestBlockNumber)
+	if err != nil {
+		return err
+	}
+	end, err := api.resolveBlock(ctx, args.ToBlock, rpc.LatestBlockNumber)
+	if err != nil {
+		return err
+	}
+	if start > end {
+		return fmt.Errorf("end block (#%d) needs to come after start block (#%d)", end, start)
+	}
+	var after, count uint64 = 0, math.MaxUint64
+	if args.After != nil {
+		after = *args.After
+	}
+	if args.Count != nil {
+		count = *args.Count
+	}
+	// Trace the blocks in the background, stopping on early return
+	type blockResult struct {
+		number uint64
+		traces []*ParityTrace
+		err    error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan *blockResult, filterLookahead)
+	go func() {
+		defer close(results)
+		for number := start; number <= end; number++ {
+			res := &blockResult{number: number}
+			block, err := api.api.blockByNumber(ctx, rpc.BlockNumber(number))
+			if err == nil {
+				res.traces, err = api.blockTraces(ctx, block)
+			}
+			res.err = err
+
+			select {
+			case results <- res:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for res := range results {
+		if res.err != nil {
+			return res.err
+		}
+		matches := []*ParityTrace{}
+		for _, trace := range res.traces {
+			if count == 0 {
+				break
+			}
+			if !args.matches(trace) {
+				continue
+			}
+			if after > 0 {
+				after--
+				continue
+			}
+			matches = append(matches, trace)
+			count--
+		}
+		if err := deliver(res.number, matches); err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// resolveBlock returns the number of the given block, or of def if unset.
+func (api *TraceAPI) resolveBlock(ctx context.Context, number *rpc.BlockNumber, def rpc.BlockNumber) (uint64, error) {
+	if number == nil {
+		number = &def
+	}
+	header, err := api.api.backend.HeaderByNumber(ctx, *number)
+	if err != nil {
+		return 0, err
+	}
+	if header == nil {
+		return 0, fmt.Errorf("block #%d not found", *number)
+	}
+	return header.Number.Uint64(), nil
+}
+
+// matches reports whether a trace satisfies the address criteria.
+func (args *TraceFilterArgs) matches(trace *ParityTrace) bool {
+	return containsAddress(args.FromAddress, trace.from) && containsAddress(args.ToAddress, trace.to)
+}
+
+// containsAddress reports whether addr is in the list, an empty list
+// containing every address.
+func containsAddress(list []common.Address, addr common.Address) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// traceSubscriptionAPI provides the streaming variant of trace_filter, exposed
+// as the "filter" subscription of the trace namespace.
+type traceSubscriptionAPI struct {
+	api *TraceAPI
+}
+
+// Filter streams the traces matching the given criteria, one notification per
+// block of the range. Tracing stops when the subscription is cancelled.
+func (api *traceSubscriptionAPI) Filter(ctx context.Context, args TraceFilterArgs) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		localctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-sub.Err():
+			case <-notifier.Closed():
+			case <-localctx.Done():
+			}
+			cancel()
+		}()
+		begin := time.Now()
+		err := api.api.filter(localctx, args, func(number uint64, traces []*ParityTrace) error {
+			return notifier.Notify(sub.ID, &TraceFilterResult{BlockNumber: number, Traces: traces})
+		})
+		if err != nil && err != context.Canceled {
+			log.Warn("Trace filter failed", "elapsed", time.Since(begin), "err", err)
+		}
+	}()
+	return sub, nil
+}
+
+// multiTracer fans the tracing events out to several native tracers.
+type multiTracer []JSONTracer
+
+func (t multiTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	for _, tracer := range t {
+		tracer.CaptureStart(env, from, to, create, input, gas, value)
+	}
+}
+
+func (t multiTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	for _, tracer := range t {
+		tracer.CaptureState(env, pc, op, gas, cost, scope, rData, depth, err)
+	}
+}
+
+func (t multiTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	for _, tracer := range t {
+		tracer.CaptureFault(env, pc, op, gas, cost, scope, depth, err)
+	}
+}
+
+func (t multiTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {
+	for _, tracer := range t {
+		tracer.CaptureEnd(output, gasUsed, d, err)
+	}
+}
+
+// Stop aborts all the tracers.
+func (t multiTracer) Stop(err error) {
+	for _, tracer := range t {
+		tracer.Stop(err)
+	}
+}
+
+// stopped returns the reason the tracers were stopped for, if they were.
+func (t multiTracer) stopped() error {
+	for _, tracer := range t {
+		if native, ok := tracer.(interface{ stopped() error }); ok {
+			if err := native.stopped(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// annotateTraces sets the block and transaction the traces belong to.
+func annotateTraces(traces []*ParityTrace, blockHash common.Hash, blockNumber uint64, txHash common.Hash, txIndex uint64) {
+	for _, trace := range traces {
+		trace.BlockHash, trace.BlockNumber = &blockHash, &blockNumber
+		trace.TransactionHash, trace.TransactionPosition = &txHash, &txIndex
+	}
+}
+
+// flattenCalls appends the call tree of the call tracer in depth first order to
+// traces, in the format of Parity's trace module.
+func flattenCalls(frame callFrame, address []int, traces []*ParityTrace) []*ParityTrace {
+	var (
+		from    = common.HexToAddress(frame.From)
+		to      = common.HexToAddress(frame.To)
+		gas     = hexutil.Uint64(decodeFrameUint64(frame.Gas))
+		gasUsed = hexutil.Uint64(decodeFrameUint64(frame.GasUsed))
+	)
+	trace := &ParityTrace{
+		Subtraces:    len(frame.Calls),
+		TraceAddress: address,
+		from:         from,
+		to:           to,
+	}
+	switch frame.Type {
+	case vm.CREATE.String(), vm.CREATE2.String():
+		trace.Type = "create"
+		trace.Action = &traceCreateAction{From: from, Gas: gas, Init: common.FromHex(frame.Input), Value: decodeFrameBig(frame.Value)}
+		trace.Result = &traceCreateResult{Address: to, Code: common.FromHex(frame.Output), GasUsed: gasUsed}
+
+	case vm.SELFDESTRUCT.String():
+		trace.Type = "suicide"
+		trace.Action = &traceSuicideAction{Address: from, Balance: decodeFrameBig(frame.Value), RefundAddress: to}
+
+	default:
+		trace.Type = "call"
+		trace.Action = &traceCallAction{CallType: strings.ToLower(frame.Type), From: from, Gas: gas, Input: common.FromHex(frame.Input), To: to, Value: decodeFrameBig(frame.Value)}
+		trace.Result = &traceCallResult{GasUsed: gasUsed, Output: common.FromHex(frame.Output)}
+	}
+	if frame.Error != "" {
+		trace.Error, trace.Result = frame.Error, nil
+		if msg, ok := parityErrors[frame.Error]; ok {
+			trace.Error = msg
+		}
+	}
+	traces = append(traces, trace)
+	for i, call := range frame.Calls {
+		traces = flattenCalls(call, append(address[:len(address):len(address)], i), traces)
+	}
+	return traces
+}
+
+// decodeFrameUint64 decodes a quantity of the call tracer, which may be unset.
+func decodeFrameUint64(s string) uint64 {
+	v, _ := hexutil.DecodeUint64(s)
+	return v
+}
+
+// decodeFrameBig decodes a value of the call tracer, which may be unset.
+func decodeFrameBig(s string) *hexutil.Big {
+	v, err := hexutil.DecodeBig(s)
+	if err != nil {
+		v = new(big.Int)
+	}
+	return (*hexutil.Big)(v)
+}
+
+// newStateDiff converts the state diff of the prestate tracer into the Parity
+// format.
+func newStateDiff(pre map[common.Address]*prestateAccount, post map[common.Address]*poststateAccount) StateDiff {
+	diff := make(StateDiff)
+	for addr, acc := range post {
+		prev, existed := pre[addr]
+		if !existed {
+			// The account was created, report all its fields as born
+			born := &AccountDiff{
+				Balance: map[string]interface{}{"+": orDefault(acc.Balance, "0x0")},
+				Code:    map[string]interface{}{"+": orDefault(acc.Code, "0x")},
+				Nonce:   map[string]interface{}{"+": hexutil.Uint64(0)},
+				Storage: make(map[common.Hash]interface{}),
+			}
+			if acc.Nonce != nil {
+				born.Nonce = map[string]interface{}{"+": hexutil.Uint64(*acc.Nonce)}
+			}
+			for key, val := range acc.Storage {
+				born.Storage[common.HexToHash(key)] = map[string]interface{}{"+": val}
+			}
+			diff[addr] = born
+			continue
+		}
+		changed := &AccountDiff{Balance: "=", Code: "=", Nonce: "=", Storage: make(map[common.Hash]interface{})}
+		if acc.Balance != "" {
+			changed.Balance = diffChange(prev.Balance, acc.Balance)
+		}
+		if acc.Code != "" {
+			changed.Code = diffChange(prev.Code, acc.Code)
+		}
+		if acc.Nonce != nil {
+			changed.Nonce = diffChange(hexutil.Uint64(prev.Nonce), hexutil.Uint64(*acc.Nonce))
+		}
+		for key, val := range acc.Storage {
+			changed.Storage[common.HexToHash(key)] = diffChange(prev.Storage[key], val)
+		}
+		diff[addr] = changed
+	}
+	for addr, prev := range pre {
+		if _, ok := post[addr]; ok {
+			continue
+		}
+		// The account was destructed, report all its fields as died
+		died := &AccountDiff{
+			Balance: map[string]interface{}{"-": prev.Balance},
+			Code:    map[string]interface{}{"-": prev.Code},
+			Nonce:   map[string]interface{}{"-": hexutil.Uint64(prev.Nonce)},
+			Storage: make(map[common.Hash]interface{}),
+		}
+		for key, val := range prev.Storage {
+			died.Storage[common.HexToHash(key)] = map[string]interface{}{"-": val}
+		}
+		diff[addr] = died
+	}
+	return diff
+}
+
+// diffChange reports a modified field.
+func diffChange(from, to interface{}) interface{} {
+	return map[string]interface{}{"*": map[string]interface{}{"from": from, "to": to}}
+}
+
+// orDefault returns s, or def if s is empty.
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,243 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/classzz/go-classzz-v2/params"
+	"github.com/classzz/go-classzz-v2/rpc"
+)
+
+// countCalls returns the number of calls in a call tree.
+func countCalls(trace *callTrace) int {
+	count := 1
+	for i := range trace.Calls {
+		count += countCalls(&trace.Calls[i])
+	}
+	return count
+}
+
+// Tests that call trees are flattened in depth first order, with consistent
+// trace addresses and subtrace counts.
+func TestFlattenCalls(t *testing.T) {
+	for name, test := range loadCallTracerTests(t) {
+		tracer, _ := newCallTracer(new(Context), nil)
+		applyTracerTest(t, test, tracer)
+
+		frame := tracer.(*callTracer).frame()
+		traces := flattenCalls(frame, []int{}, nil)
+
+		blob, _ := json.Marshal(frame)
+		tree := new(callTrace)
+		if err := json.Unmarshal(blob, tree); err != nil {
+			t.Fatalf("%s: failed to unmarshal call tree: %v", name, err)
+		}
+		if len(traces) != countCalls(tree) {
+			t.Errorf("%s: trace count mismatch: have %d, want %d", name, len(traces), countCalls(tree))
+		}
+		if len(traces[0].TraceAddress) != 0 {
+			t.Errorf("%s: top level trace address %v", name, traces[0].TraceAddress)
+		}
+		children := make(map[string]int)
+		for _, trace := range traces[1:] {
+			parent := trace.TraceAddress[:len(trace.TraceAddress)-1]
+			if want := children[fmt.Sprint(parent)]; trace.TraceAddress[len(parent)] != want {
+				t.Errorf("%s: trace %v out of order, want index %d", name, trace.TraceAddress, want)
+			}
+			children[fmt.Sprint(parent)]++
+		}
+		for _, trace := range traces {
+			if have := children[fmt.Sprint(trace.TraceAddress)]; have != trace.Subtraces {
+				t.Errorf("%s: trace %v subtraces mismatch: have %d, want %d", name, trace.TraceAddress, trace.Subtraces, have)
+			}
+			if trace.Error != "" && trace.Result != nil {
+				t.Errorf("%s: trace %v has both error and result", name, trace.TraceAddress)
+			}
+		}
+		if tree.Error == vm.ErrExecutionReverted.Error() && traces[0].Error != "Reverted" {
+			t.Errorf("%s: revert reported as %q", name, traces[0].Error)
+		}
+	}
+}
+
+// Tests that the state diff of a contract creation reports the new contract as
+// born and the sender as modified.
+func TestStateDiffCreate(t *testing.T) {
+	test := loadCallTracerTests(t)["create"]
+
+	tracer, _ := newPrestateTracer(new(Context), json.RawMessage(`{"diffMode":true}`))
+	applyTracerTest(t, test, tracer)
+	diff := newStateDiff(tracer.(*prestateTracer).diff())
+
+	contract := diff[test.Result.To]
+	if contract == nil {
+		t.Fatalf("created contract %x missing from diff", test.Result.To)
+	}
+	code, ok := contract.Code.(map[string]interface{})
+	if !ok || code["+"] == nil {
+		t.Errorf("created contract code not born: %v", contract.Code)
+	}
+	sender := diff[test.Result.From]
+	if sender == nil {
+		t.Fatalf("sender %x missing from diff", test.Result.From)
+	}
+	for field, change := range map[string]interface{}{"balance": sender.Balance, "nonce": sender.Nonce} {
+		if _, ok := change.(map[string]interface{})["*"]; !ok {
+			t.Errorf("sender %s not modified: %v", field, change)
+		}
+	}
+	if sender.Code != "=" {
+		t.Errorf("sender code modified: %v", sender.Code)
+	}
+}
+
+// Tests that the vm tracer records the executed instructions of all the call
+// frames along with their effects.
+func TestVMTracer(t *testing.T) {
+	test := loadCallTracerTests(t)["deepCalls"]
+
+	tracer := newVMTracer()
+	applyTracerTest(t, test, tracer)
+
+	root := tracer.root
+	if root == nil || len(root.Ops) == 0 {
+		t.Fatal("no instructions traced")
+	}
+	if want := test.Genesis.Alloc[common.Address(test.Result.To)].Code; !reflect.DeepEqual([]byte(root.Code), want) {
+		t.Errorf("code mismatch: have %x, want %x", root.Code, want)
+	}
+	// The contract starts by storing the free memory pointer
+	if push := root.Ops[0].Ex.Push; !reflect.DeepEqual(push, []string{"0x60"}) {
+		t.Errorf("first push mismatch: have %v, want [0x60]", push)
+	}
+	if mem := root.Ops[2].Ex.Mem; mem == nil || mem.Off != 0x40 || len(mem.Data) != 32 {
+		t.Errorf("memory write mismatch: have %+v", mem)
+	}
+	var subs func(trace *VMTrace) int
+	subs = func(trace *VMTrace) int {
+		count := 0
+		for _, op := range trace.Ops {
+			if op.Sub != nil {
+				count += 1 + subs(op.Sub)
+			}
+		}
+		return count
+	}
+	if subs(root) == 0 {
+		t.Error("no sub call frames traced")
+	}
+}
+
+// Tests the trace namespace against the transactions of a generated chain.
+func TestTraceAPI(t *testing.T) {
+	t.Parallel()
+
+	// Initialize test accounts
+	accounts := newAccounts(3)
+	genesis := &core.Genesis{Alloc: core.GenesisAlloc{
+		accounts[0].addr: {Balance: big.NewInt(params.Ether)},
+		accounts[1].addr: {Balance: big.NewInt(params.Ether)},
+	}}
+	var (
+		hashes []common.Hash
+		signer = types.HomesteadSigner{}
+	)
+	api := NewTraceAPI(newTestBackend(t, 2, genesis, func(i int, b *core.BlockGen) {
+		// Transfer from account[0] to account[1]
+		//    value: 1000 wei
+		//    fee:   0 wei
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i), accounts[1].addr, big.NewInt(1000), params.TxGas, b.BaseFee(), nil), signer, accounts[0].key)
+		b.AddTx(tx)
+		hashes = append(hashes, tx.Hash())
+	}))
+	// Every transfer is a single top level call, annotated with its position
+	traces, err := api.Block(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("failed to trace block: %v", err)
+	}
+	if len(traces) != 1 {
+		t.Fatalf("trace count mismatch: have %d, want 1", len(traces))
+	}
+	trace := traces[0]
+	if trace.Type != "call" || trace.Subtraces != 0 || len(trace.TraceAddress) != 0 {
+		t.Errorf("trace mismatch: have type %s, %d subtraces, address %v", trace.Type, trace.Subtraces, trace.TraceAddress)
+	}
+	if trace.TransactionHash == nil || *trace.TransactionHash != hashes[1] || trace.BlockNumber == nil || *trace.BlockNumber != 2 {
+		t.Errorf("trace annotation mismatch: have tx %v in block %v", trace.TransactionHash, trace.BlockNumber)
+	}
+	action := trace.Action.(*traceCallAction)
+	if action.From != accounts[0].addr || action.To != accounts[1].addr || action.Value.ToInt().Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("call mismatch: have %x -> %x, value %v", action.From, action.To, action.Value)
+	}
+	txTraces, err := api.Transaction(context.Background(), hashes[1])
+	if err != nil {
+		t.Fatalf("failed to trace transaction: %v", err)
+	}
+	if !reflect.DeepEqual(txTraces, traces) {
+		t.Error("transaction traces differ from the block traces")
+	}
+	// The state diff reports the transfer on top of the previous block
+	res, err := api.ReplayTransaction(context.Background(), hashes[1], []string{"stateDiff"})
+	if err != nil {
+		t.Fatalf("failed to replay transaction: %v", err)
+	}
+	if res.Trace != nil || res.VMTrace != nil {
+		t.Error("unrequested traces returned")
+	}
+	recipient := res.StateDiff[accounts[1].addr]
+	if recipient == nil {
+		t.Fatalf("recipient %x missing from diff", accounts[1].addr)
+	}
+	want := diffChange(
+		hexutil.EncodeBig(new(big.Int).Add(big.NewInt(params.Ether), big.NewInt(1000))),
+		hexutil.EncodeBig(new(big.Int).Add(big.NewInt(params.Ether), big.NewInt(2000))),
+	)
+	if !reflect.DeepEqual(recipient.Balance, want) {
+		t.Errorf("recipient balance mismatch: have %v, want %v", recipient.Balance, want)
+	}
+	if _, err := api.ReplayTransaction(context.Background(), hashes[1], []string{"bogus"}); err == nil {
+		t.Error("unknown trace type accepted")
+	}
+	// The filter only returns the transfers to the given recipients
+	from, to := rpc.BlockNumber(0), rpc.LatestBlockNumber
+	for _, test := range []struct {
+		recipient common.Address
+		want      int
+	}{
+		{accounts[1].addr, 2},
+		{accounts[2].addr, 0},
+	} {
+		traces, err := api.Filter(context.Background(), TraceFilterArgs{FromBlock: &from, ToBlock: &to, ToAddress: []common.Address{test.recipient}})
+		if err != nil {
+			t.Fatalf("failed to filter traces: %v", err)
+		}
+		if len(traces) != test.want {
+			t.Errorf("filtered trace count mismatch for %x: have %d, want %d", test.recipient, len(traces), test.want)
+		}
+	}
+}
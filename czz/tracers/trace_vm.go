@@ -0,0 +1,217 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/core/vm"
+)
+
+// VMTrace is the Parity style trace of the instructions executed by a call
+// frame.
+type VMTrace struct {
+	Code hexutil.Bytes  `json:"code"`
+	Ops  []*VMOperation `json:"ops"`
+}
+
+// VMOperation is a single executed instruction, along with the trace of the
+// call frame it entered, if any.
+type VMOperation struct {
+	Cost uint64               `json:"cost"`
+	Ex   *VMExecutedOperation `json:"ex"`
+	PC   uint64               `json:"pc"`
+	Sub  *VMTrace             `json:"sub"`
+}
+
+// VMExecutedOperation contains the effects of an executed instruction.
+type VMExecutedOperation struct {
+	Mem   *VMMemoryDiff  `json:"mem"`
+	Push  []string       `json:"push"`
+	Store *VMStorageDiff `json:"store"`
+	Used  uint64         `json:"used"`
+}
+
+// VMMemoryDiff is the memory region written by an instruction.
+type VMMemoryDiff struct {
+	Data hexutil.Bytes `json:"data"`
+	Off  uint64        `json:"off"`
+}
+
+// VMStorageDiff is the storage slot written by an instruction.
+type VMStorageDiff struct {
+	Key string `json:"key"`
+	Val string `json:"val"`
+}
+
+// vmFrame is a call frame being traced by the vmTracer.
+type vmFrame struct {
+	trace *VMTrace
+
+	pending        *VMOperation // Last instruction, awaiting its effects
+	pendingOp      vm.OpCode
+	memOff, memLen uint64
+}
+
+// vmTracer collects the Parity style trace of all the instructions executed
+// by a transaction. The effects of an instruction are only known once the next
+// one of the same call frame is about to run.
+type vmTracer struct {
+	nativeTracer
+
+	root   *VMTrace
+	frames []*vmFrame
+}
+
+func newVMTracer() *vmTracer {
+	return new(vmTracer)
+}
+
+// CaptureStart implements the vm.Tracer interface to initialize the tracing operation.
+func (t *vmTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+// CaptureState implements the vm.Tracer interface to trace a single step of VM execution.
+func (t *vmTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.interrupted(env) {
+		return
+	}
+	// Track the call frame the instruction belongs to
+	for len(t.frames) > depth {
+		t.frames = t.frames[:len(t.frames)-1]
+	}
+	if len(t.frames) < depth {
+		trace := &VMTrace{Code: common.CopyBytes(scope.Contract.Code), Ops: []*VMOperation{}}
+		if len(t.frames) == 0 {
+			t.root = trace
+		} else if parent := t.frames[len(t.frames)-1]; parent.pending != nil {
+			parent.pending.Sub = trace
+		}
+		t.frames = append(t.frames, &vmFrame{trace: trace})
+	}
+	frame := t.frames[depth-1]
+	frame.complete(gas, scope)
+
+	// Record the instruction, its effects are filled in on completion
+	operation := &VMOperation{
+		Cost: cost,
+		PC:   pc,
+		Ex:   &VMExecutedOperation{Push: []string{}},
+	}
+	if gas >= cost {
+		operation.Ex.Used = gas - cost
+	}
+	frame.trace.Ops = append(frame.trace.Ops, operation)
+
+	// Instructions failing before they run have no effects
+	if err != nil {
+		operation.Ex = nil
+		return
+	}
+	frame.pending, frame.pendingOp = operation, op
+
+	stack := scope.Stack
+	frame.memOff, frame.memLen = 0, 0
+	switch op {
+	case vm.MSTORE:
+		frame.memOff, frame.memLen = stackUint64(stack.Back(0)), 32
+	case vm.MSTORE8:
+		frame.memOff, frame.memLen = stackUint64(stack.Back(0)), 1
+	case vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY:
+		frame.memOff, frame.memLen = stackUint64(stack.Back(0)), stackUint64(stack.Back(2))
+	case vm.EXTCODECOPY:
+		frame.memOff, frame.memLen = stackUint64(stack.Back(1)), stackUint64(stack.Back(3))
+	case vm.CALL, vm.CALLCODE:
+		frame.memOff, frame.memLen = stackUint64(stack.Back(5)), stackUint64(stack.Back(6))
+	case vm.DELEGATECALL, vm.STATICCALL:
+		frame.memOff, frame.memLen = stackUint64(stack.Back(4)), stackUint64(stack.Back(5))
+	case vm.SSTORE:
+		operation.Ex.Store = &VMStorageDiff{Key: stack.Back(0).Hex(), Val: stack.Back(1).Hex()}
+	}
+}
+
+// complete fills in the effects of the pending instruction of the frame, given
+// the state of the frame before the next instruction runs.
+func (f *vmFrame) complete(gas uint64, scope *vm.ScopeContext) {
+	if f.pending == nil {
+		return
+	}
+	ex := f.pending.Ex
+	ex.Used = gas
+
+	stack := scope.Stack.Data()
+	pushes := vmPushes(f.pendingOp)
+	if pushes > len(stack) {
+		pushes = len(stack)
+	}
+	for i := len(stack) - pushes; i < len(stack); i++ {
+		ex.Push = append(ex.Push, stack[i].Hex())
+	}
+	if f.memLen > 0 && addSaturated(f.memOff, f.memLen) <= uint64(scope.Memory.Len()) {
+		ex.Mem = &VMMemoryDiff{
+			Data: scope.Memory.GetCopy(int64(f.memOff), int64(f.memLen)),
+			Off:  f.memOff,
+		}
+	}
+	f.pending = nil
+}
+
+// vmPushes returns the number of stack items an instruction leaves behind,
+// counting all the items touched by DUP and SWAP.
+func vmPushes(op vm.OpCode) int {
+	switch {
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		return int(op-vm.DUP1) + 2
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		return int(op-vm.SWAP1) + 2
+	case op >= vm.LOG0 && op <= vm.LOG4:
+		return 0
+	}
+	switch op {
+	case vm.STOP, vm.POP, vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.JUMP, vm.JUMPI, vm.JUMPDEST,
+		vm.CALLDATACOPY, vm.CODECOPY, vm.EXTCODECOPY, vm.RETURNDATACOPY,
+		vm.RETURN, vm.REVERT, vm.SELFDESTRUCT:
+		return 0
+	}
+	return 1
+}
+
+// CaptureFault implements the vm.Tracer interface to trace an execution fault.
+func (t *vmTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	if err == vm.ErrExecutionReverted || len(t.frames) < depth {
+		return
+	}
+	// The failed instruction had no effects
+	frame := t.frames[depth-1]
+	if frame.pending != nil {
+		frame.pending.Ex = nil
+		frame.pending = nil
+	}
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *vmTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {
+}
+
+// GetResult returns the instruction trace of the transaction.
+func (t *vmTracer) GetResult() (json.RawMessage, error) {
+	return t.result(t.root)
+}
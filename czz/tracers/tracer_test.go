@@ -207,32 +207,29 @@ func TestNoStepExec(t *testing.T) {
 }
 
 func TestIsPrecompile(t *testing.T) {
-	chaincfg := &params.ChainConfig{ChainID: big.NewInt(1), HomesteadBlock: big.NewInt(0), DAOForkBlock: nil, DAOForkSupport: false, EIP150Block: big.NewInt(0), EIP150Hash: common.Hash{}, EIP155Block: big.NewInt(0), EIP158Block: big.NewInt(0), ByzantiumBlock: big.NewInt(100), ConstantinopleBlock: big.NewInt(0), PetersburgBlock: big.NewInt(0), IstanbulBlock: big.NewInt(200), MuirGlacierBlock: big.NewInt(0), BerlinBlock: big.NewInt(300), LondonBlock: big.NewInt(0), CatalystBlock: nil, Ethash: new(params.EthashConfig), Clique: nil}
-	chaincfg.ByzantiumBlock = big.NewInt(100)
-	chaincfg.IstanbulBlock = big.NewInt(200)
-	chaincfg.BerlinBlock = big.NewInt(300)
+	chaincfg := &params.ChainConfig{ChainID: big.NewInt(1), Ethash: new(params.EthashConfig)}
 	txCtx := vm.TxContext{GasPrice: big.NewInt(100000)}
+
+	// The precompiled contracts are active from the genesis on
 	tracer, err := New("{addr: toAddress('0000000000000000000000000000000000000009'), res: null, step: function() { this.res = isPrecompiled(this.addr); }, fault: function() {}, result: function() { return this.res; }}", new(Context))
 	if err != nil {
 		t.Fatal(err)
 	}
-
 	blockCtx := vm.BlockContext{BlockNumber: big.NewInt(150)}
 	res, err := runTrace(tracer, &vmContext{blockCtx, txCtx}, chaincfg)
 	if err != nil {
 		t.Error(err)
 	}
-	if string(res) != "false" {
-		t.Errorf("Tracer should not consider blake2f as precompile in byzantium")
+	if string(res) != "true" {
+		t.Errorf("Tracer should consider blake2f as precompile")
 	}
 
-	tracer, _ = New("{addr: toAddress('0000000000000000000000000000000000000009'), res: null, step: function() { this.res = isPrecompiled(this.addr); }, fault: function() {}, result: function() { return this.res; }}", new(Context))
-	blockCtx = vm.BlockContext{BlockNumber: big.NewInt(250)}
+	tracer, _ = New("{addr: toAddress('000000000000000000000000000000000000000a'), res: null, step: function() { this.res = isPrecompiled(this.addr); }, fault: function() {}, result: function() { return this.res; }}", new(Context))
 	res, err = runTrace(tracer, &vmContext{blockCtx, txCtx}, chaincfg)
 	if err != nil {
 		t.Error(err)
 	}
-	if string(res) != "true" {
-		t.Errorf("Tracer should consider blake2f as precompile in istanbul")
+	if string(res) != "false" {
+		t.Errorf("Tracer should not consider the BLS precompiles as precompile")
 	}
 }
@@ -14,7 +14,7 @@
 // You should have received a copy of the GNU Lesser General Public License
 // along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
 
-// Package tracers is a collection of JavaScript transaction tracers.
+// Package tracers is a collection of JavaScript and native transaction tracers.
 package tracers
 
 import (
@@ -44,10 +44,14 @@ func init() {
 	}
 }
 
-// tracer retrieves a specific JavaScript tracer by name.
+// tracer retrieves a specific JavaScript tracer by name. Tracers shadowed by a
+// native implementation are also available with a "Js" suffix.
 func tracer(name string) (string, bool) {
 	if tracer, ok := all[name]; ok {
 		return tracer, true
 	}
+	if tracer, ok := all[strings.TrimSuffix(name, "Js")]; ok && strings.HasSuffix(name, "Js") {
+		return tracer, true
+	}
 	return "", false
 }
@@ -155,6 +155,7 @@ func TestPrestateTracerCreate2(t *testing.T) {
 		Time:        new(big.Int).SetUint64(5),
 		Difficulty:  big.NewInt(0x30000),
 		GasLimit:    uint64(6000000),
+		BaseFee:     new(big.Int),
 	}
 	alloc := core.GenesisAlloc{}
 
@@ -244,6 +245,7 @@ func TestCallTracer(t *testing.T) {
 				Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
 				Difficulty:  (*big.Int)(test.Context.Difficulty),
 				GasLimit:    uint64(test.Context.GasLimit),
+				BaseFee:     new(big.Int),
 			}
 			_, statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), test.Genesis.Alloc, false)
 
@@ -255,6 +255,16 @@ func (ec *Client) TransactionReceipt(ctx context.Context, txHash common.Hash) (*
 	return r, err
 }
 
+// BlockReceipts returns the receipts of all the transactions in the given block.
+func (ec *Client) BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error) {
+	var r []*types.Receipt
+	err := ec.c.CallContext(ctx, &r, "eth_getBlockReceipts", toBlockNumberOrHashArg(blockNrOrHash))
+	if err == nil && r == nil {
+		return nil, classzz.NotFound
+	}
+	return r, err
+}
+
 type rpcProgress struct {
 	StartingBlock hexutil.Uint64
 	CurrentBlock  hexutil.Uint64
@@ -495,6 +505,23 @@ func (ec *Client) SendTransaction(ctx context.Context, tx *types.Transaction) er
 	return ec.c.CallContext(ctx, nil, "eth_sendRawTransaction", hexutil.Encode(data))
 }
 
+func toBlockNumberOrHashArg(blockNrOrHash rpc.BlockNumberOrHash) interface{} {
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		if blockNrOrHash.RequireCanonical {
+			return map[string]interface{}{"blockHash": hash, "requireCanonical": true}
+		}
+		return hash
+	}
+	number, _ := blockNrOrHash.Number()
+	switch number {
+	case rpc.LatestBlockNumber:
+		return "latest"
+	case rpc.PendingBlockNumber:
+		return "pending"
+	}
+	return hexutil.EncodeUint64(uint64(number.Int64()))
+}
+
 func toBlockNumArg(number *big.Int) string {
 	if number == nil {
 		return "latest"
@@ -537,15 +564,31 @@ func (ec *Client) Pubkey(ctx context.Context) (string, error) {
 }
 
 //tewaka_getPledgeInfo
-func (ec *Client) GetPledgeInfo(ctx context.Context, account common.Address, number *big.Int) ([]*types.Pledge, error) {
+func (ec *Client) GetPledgeInfo(ctx context.Context, number *big.Int) ([]*types.Pledge, error) {
 	var result []*types.Pledge
-	err := ec.c.CallContext(ctx, &result, "tewaka_getPledgeInfo", account, toBlockNumArg(number))
+	err := ec.c.CallContext(ctx, &result, "tewaka_getPledgeInfo", toBlockNumArg(number))
 	if err != nil {
 		return result, err
 	}
 	return result, nil
 }
 
+type RPCStakingFactor struct {
+	Coinbase      common.Address `json:"coinbase"`
+	StakingAmount *hexutil.Big   `json:"staking_amount"`
+	Factor        *hexutil.Big   `json:"factor"`
+}
+
+//tewaka_getStakingFactor
+func (ec *Client) GetStakingFactor(ctx context.Context, coinbase common.Address, number *big.Int) (*RPCStakingFactor, error) {
+	var result *RPCStakingFactor
+	err := ec.c.CallContext(ctx, &result, "tewaka_getStakingFactor", coinbase, toBlockNumArg(number))
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 type RPCConvertItem struct {
 	ID          *hexutil.Big     `json:"id"`
 	AssetType   hexutil.Uint     `json:"asset_type"`
@@ -561,10 +604,10 @@ type RPCConvertItem struct {
 	Extra       hexutil.Bytes    `json:"extra"`
 }
 
-//tewaka_getConvertItem
-func (ec *Client) GetConvertItem(ctx context.Context) ([]*types.ConvertItem, error) {
+//tewaka_getConvertItems
+func (ec *Client) GetConvertItem(ctx context.Context, number *big.Int) ([]*types.ConvertItem, error) {
 	var result []*RPCConvertItem
-	err := ec.c.CallContext(ctx, &result, "tewaka_getConvertItems")
+	err := ec.c.CallContext(ctx, &result, "tewaka_getConvertItems", toBlockNumArg(number))
 
 	var result1 []*types.ConvertItem
 	for _, v := range result {
@@ -257,6 +257,9 @@ func TestEthClient(t *testing.T) {
 		"TestGetBlock": {
 			func(t *testing.T) { testGetBlock(t, client) },
 		},
+		"TestBlockReceipts": {
+			func(t *testing.T) { testBlockReceipts(t, chain, client) },
+		},
 		"TestStatusFunctions": {
 			func(t *testing.T) { testStatusFunctions(t, client) },
 		},
@@ -434,6 +437,28 @@ func testGetBlock(t *testing.T, client *rpc.Client) {
 	}
 }
 
+func testBlockReceipts(t *testing.T, chain []*types.Block, client *rpc.Client) {
+	ec := NewClient(client)
+
+	// Receipts of an existing block, by number and by hash
+	for _, arg := range []rpc.BlockNumberOrHash{
+		rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(1)),
+		rpc.BlockNumberOrHashWithHash(chain[1].Hash(), true),
+	} {
+		receipts, err := ec.BlockReceipts(context.Background(), arg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(receipts) != len(chain[1].Transactions()) {
+			t.Fatalf("BlockReceipts returned wrong receipt count: want %d got %d", len(chain[1].Transactions()), len(receipts))
+		}
+	}
+	// Receipts of a missing block
+	if _, err := ec.BlockReceipts(context.Background(), rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(2))); err != classzz.NotFound {
+		t.Fatalf("BlockReceipts returned wrong error: want %v got %v", classzz.NotFound, err)
+	}
+}
+
 func testStatusFunctions(t *testing.T, client *rpc.Client) {
 	ec := NewClient(client)
 
@@ -88,6 +88,10 @@ type AncientReader interface {
 
 	// AncientSize returns the ancient size of the specified category.
 	AncientSize(kind string) (uint64, error)
+
+	// AncientTail returns the number of the first block whose bodies and receipts
+	// are retained in the ancient store.
+	AncientTail() (uint64, error)
 }
 
 // AncientWriter contains the methods required to write to immutable ancient data.
@@ -99,6 +103,10 @@ type AncientWriter interface {
 	// TruncateAncients discards all but the first n ancient data from the ancient store.
 	TruncateAncients(n uint64) error
 
+	// TruncateAncientTail discards the bodies and receipts of all ancient blocks
+	// below the given number, retaining the headers, hashes and difficulties.
+	TruncateAncientTail(tail uint64) error
+
 	// Sync flushes all in-memory ancient store data to disk.
 	Sync() error
 }
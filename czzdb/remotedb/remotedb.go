@@ -0,0 +1,269 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package remotedb implements an ancient store served over RPC, allowing several
+// nodes to share one set of immutable chain data.
+package remotedb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// Scheme is the prefix of the ancient directories referring to a remote store.
+const Scheme = "remote://"
+
+const (
+	// cacheItems is the number of ancient items to cache. The items are immutable,
+	// so they only need to be dropped if the store is truncated.
+	cacheItems = 4096
+
+	// statusRefresh is the period after which the number of items and the
+	// truncations of the store are refreshed from the server.
+	statusRefresh = 3 * time.Second
+)
+
+var (
+	// errReadOnly is returned if a mutation is attempted on a read only store.
+	errReadOnly = errors.New("read only")
+
+	// errOutOfBounds is returned if an item beyond the stored ones is requested.
+	errOutOfBounds = errors.New("out of bounds")
+
+	// errShared is returned if the store is truncated by a node. The store is
+	// shared with the other nodes, which may depend on the discarded items.
+	errShared = errors.New("can't truncate an ancient store shared with other nodes")
+)
+
+// IsRemote reports whether the ancient directory refers to a remote store.
+func IsRemote(path string) bool {
+	return strings.HasPrefix(path, Scheme)
+}
+
+// cacheKey identifies an ancient item in the cache.
+type cacheKey struct {
+	kind   string
+	number uint64
+}
+
+// Database is an ancient store backed by a remote server. The store is shared
+// with other nodes, which may freeze the chain beyond the local head, so it's
+// never truncated by the node.
+type Database struct {
+	client   *rpc.Client
+	readonly bool
+	cache    *lru.Cache // Cache of the retrieved items
+
+	lock        sync.Mutex
+	frozen      uint64    // Last known number of items in the store
+	truncations uint64    // Last known number of truncations of the store
+	updated     time.Time // Time the status of the store was last retrieved
+}
+
+// Dial connects to the ancient store served at the given remote:// address.
+func Dial(path string, readonly bool) (*Database, error) {
+	if !IsRemote(path) {
+		return nil, fmt.Errorf("invalid remote ancient store %q", path)
+	}
+	client, err := rpc.DialHTTP("http://" + strings.TrimPrefix(path, Scheme))
+	if err != nil {
+		return nil, err
+	}
+	db := New(client, readonly)
+	if _, err := db.Ancients(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("remote ancient store unavailable: %v", err)
+	}
+	return db, nil
+}
+
+// New creates an ancient store on top of an RPC client connected to a server.
+func New(client *rpc.Client, readonly bool) *Database {
+	cache, _ := lru.New(cacheItems)
+	return &Database{
+		client:   client,
+		readonly: readonly,
+		cache:    cache,
+	}
+}
+
+// refresh retrieves the status of the store from the server if the known one
+// is stale, so the items discarded by another node are dropped from the cache.
+func (db *Database) refresh() error {
+	db.lock.Lock()
+	stale := time.Since(db.updated) >= statusRefresh
+	db.lock.Unlock()
+
+	if !stale {
+		return nil
+	}
+	_, err := db.Ancients()
+	return err
+}
+
+// stored reports whether an item with the given number may exist in the store.
+func (db *Database) stored(number uint64) (bool, error) {
+	if err := db.refresh(); err != nil {
+		return false, err
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return number < db.frozen, nil
+}
+
+// HasAncient returns an indicator whether the specified data exists in the
+// ancient store.
+func (db *Database) HasAncient(kind string, number uint64) (bool, error) {
+	if ok, err := db.stored(number); !ok || err != nil {
+		return false, err
+	}
+	if db.cache.Contains(cacheKey{kind, number}) {
+		return true, nil
+	}
+	var has bool
+	if err := db.client.Call(&has, Namespace+"_hasAncient", kind, hexutil.Uint64(number)); err != nil {
+		return false, err
+	}
+	return has, nil
+}
+
+// Ancient retrieves an ancient binary blob from the store.
+func (db *Database) Ancient(kind string, number uint64) ([]byte, error) {
+	if ok, err := db.stored(number); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errOutOfBounds
+	}
+	if blob, ok := db.cache.Get(cacheKey{kind, number}); ok {
+		return blob.([]byte), nil
+	}
+	var blob hexutil.Bytes
+	if err := db.client.Call(&blob, Namespace+"_ancient", kind, hexutil.Uint64(number)); err != nil {
+		return nil, err
+	}
+	db.cache.Add(cacheKey{kind, number}, []byte(blob))
+	return blob, nil
+}
+
+// ReadAncients retrieves multiple items in sequence, starting from the index
+// 'start', at most 'count' of them and as many as fit into maxBytes.
+func (db *Database) ReadAncients(kind string, start, count, maxBytes uint64) ([][]byte, error) {
+	if err := db.refresh(); err != nil {
+		return nil, err
+	}
+	var blobs []hexutil.Bytes
+	if err := db.client.Call(&blobs, Namespace+"_readAncients", kind, hexutil.Uint64(start), hexutil.Uint64(count), hexutil.Uint64(maxBytes)); err != nil {
+		return nil, err
+	}
+	items := make([][]byte, len(blobs))
+	for i, blob := range blobs {
+		items[i] = blob
+		db.cache.Add(cacheKey{kind, start + uint64(i)}, items[i])
+	}
+	return items, nil
+}
+
+// Ancients returns the number of items in the ancient store. If the store was
+// truncated since the last call, the cached items are dropped.
+func (db *Database) Ancients() (uint64, error) {
+	var status Status
+	if err := db.client.Call(&status, Namespace+"_status"); err != nil {
+		return 0, err
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if uint64(status.Truncations) != db.truncations || uint64(status.Items) < db.frozen {
+		db.cache.Purge()
+	}
+	db.frozen, db.truncations, db.updated = uint64(status.Items), uint64(status.Truncations), time.Now()
+	return db.frozen, nil
+}
+
+// AncientSize returns the ancient size of the specified category.
+func (db *Database) AncientSize(kind string) (uint64, error) {
+	var size hexutil.Uint64
+	if err := db.client.Call(&size, Namespace+"_ancientSize", kind); err != nil {
+		return 0, err
+	}
+	return uint64(size), nil
+}
+
+// AncientTail returns the number of the first block whose bodies and receipts
+// are retained in the ancient store.
+func (db *Database) AncientTail() (uint64, error) {
+	var tail hexutil.Uint64
+	if err := db.client.Call(&tail, Namespace+"_ancientTail"); err != nil {
+		return 0, err
+	}
+	return uint64(tail), nil
+}
+
+// AppendAncient injects all binary blobs belong to block at the end of the store.
+func (db *Database) AppendAncient(number uint64, hash, header, body, receipts, td []byte) error {
+	if db.readonly {
+		return errReadOnly
+	}
+	err := db.client.Call(nil, Namespace+"_appendAncient", hexutil.Uint64(number), hexutil.Bytes(hash),
+		hexutil.Bytes(header), hexutil.Bytes(body), hexutil.Bytes(receipts), hexutil.Bytes(td))
+	if err != nil {
+		return err
+	}
+	db.lock.Lock()
+	if db.frozen <= number {
+		db.frozen = number + 1
+	}
+	db.lock.Unlock()
+	return nil
+}
+
+// TruncateAncients is rejected, the store is shared with other nodes.
+func (db *Database) TruncateAncients(n uint64) error {
+	if db.readonly {
+		return errReadOnly
+	}
+	return errShared
+}
+
+// TruncateAncientTail is rejected, the store is shared with other nodes.
+func (db *Database) TruncateAncientTail(tail uint64) error {
+	if db.readonly {
+		return errReadOnly
+	}
+	return errShared
+}
+
+// Sync flushes all in-memory ancient store data to disk.
+func (db *Database) Sync() error {
+	if db.readonly {
+		return nil
+	}
+	return db.client.Call(nil, Namespace+"_sync")
+}
+
+// Close disconnects from the server.
+func (db *Database) Close() error {
+	db.client.Close()
+	return nil
+}
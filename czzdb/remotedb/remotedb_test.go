@@ -0,0 +1,322 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package remotedb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/rpc"
+)
+
+// testTables are the tables of the in-memory test ancient store.
+var testTables = []string{hashTable, "headers", "bodies", "receipts", "diffs"}
+
+// testStore is an in-memory ancient store counting the item retrievals.
+type testStore struct {
+	tables map[string][][]byte
+	reads  int
+	lock   sync.Mutex
+}
+
+func newTestStore() *testStore {
+	store := &testStore{tables: make(map[string][][]byte)}
+	for _, kind := range testTables {
+		store.tables[kind] = nil
+	}
+	return store
+}
+
+func (s *testStore) HasAncient(kind string, number uint64) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return number < uint64(len(s.tables[kind])), nil
+}
+
+func (s *testStore) Ancient(kind string, number uint64) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reads++
+	if number >= uint64(len(s.tables[kind])) {
+		return nil, errOutOfBounds
+	}
+	return s.tables[kind][number], nil
+}
+
+func (s *testStore) ReadAncients(kind string, start, count, maxBytes uint64) ([][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reads++
+	var (
+		items [][]byte
+		size  uint64
+	)
+	for i := start; i < start+count && i < uint64(len(s.tables[kind])); i++ {
+		item := s.tables[kind][i]
+		if len(items) > 0 && size+uint64(len(item)) > maxBytes {
+			break
+		}
+		items, size = append(items, item), size+uint64(len(item))
+	}
+	if len(items) == 0 {
+		return nil, errOutOfBounds
+	}
+	return items, nil
+}
+
+func (s *testStore) Ancients() (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return uint64(len(s.tables[hashTable])), nil
+}
+
+func (s *testStore) AncientSize(kind string) (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var size uint64
+	for _, item := range s.tables[kind] {
+		size += uint64(len(item))
+	}
+	return size, nil
+}
+
+func (s *testStore) AncientTail() (uint64, error) { return 0, nil }
+
+func (s *testStore) AppendAncient(number uint64, hash, header, body, receipts, td []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if number != uint64(len(s.tables[hashTable])) {
+		return errors.New("the append operation is out-order")
+	}
+	for i, item := range [][]byte{hash, header, body, receipts, td} {
+		s.tables[testTables[i]] = append(s.tables[testTables[i]], item)
+	}
+	return nil
+}
+
+func (s *testStore) TruncateAncients(n uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for kind, items := range s.tables {
+		if uint64(len(items)) > n {
+			s.tables[kind] = items[:n]
+		}
+	}
+	return nil
+}
+
+func (s *testStore) TruncateAncientTail(tail uint64) error { return nil }
+func (s *testStore) Sync() error                           { return nil }
+func (s *testStore) Close() error                          { return nil }
+
+// appendTestBlock appends the items of a test block to the store.
+func appendTestBlock(db *Database, number uint64, hash byte) error {
+	item := func(kind string) []byte { return []byte(fmt.Sprintf("%s-%d", kind, number)) }
+	return db.AppendAncient(number, []byte{hash, byte(number)}, item("header"), item("body"), item("receipts"), item("td"))
+}
+
+// Tests the ancient store operations against an in-process server.
+func TestRemoteAncientStore(t *testing.T) {
+	store := newTestStore()
+	server, err := NewServer(store, false)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer server.Stop()
+
+	db := New(rpc.DialInProc(server), false)
+	defer db.Close()
+
+	for i := uint64(0); i < 10; i++ {
+		if err := appendTestBlock(db, i, 0xaa); err != nil {
+			t.Fatalf("failed to append block %d: %v", i, err)
+		}
+	}
+	if n, err := db.Ancients(); err != nil || n != 10 {
+		t.Fatalf("ancients mismatch: have %d, %v, want 10", n, err)
+	}
+	// Re-appending the same block is accepted, a different or gapped one isn't
+	if err := appendTestBlock(db, 5, 0xaa); err != nil {
+		t.Fatalf("failed to re-append stored block: %v", err)
+	}
+	if err := appendTestBlock(db, 5, 0xbb); err == nil {
+		t.Fatalf("conflicting block appended")
+	}
+	if err := appendTestBlock(db, 12, 0xaa); err == nil {
+		t.Fatalf("out of order block appended")
+	}
+	// Retrieve the items, checking that they are served from the cache
+	for i := uint64(0); i < 10; i++ {
+		blob, err := db.Ancient("bodies", i)
+		if err != nil {
+			t.Fatalf("failed to retrieve body %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("body-%d", i); string(blob) != want {
+			t.Fatalf("body %d mismatch: have %q, want %q", i, blob, want)
+		}
+	}
+	reads := store.reads
+	for i := uint64(0); i < 10; i++ {
+		if ok, err := db.HasAncient("bodies", i); !ok || err != nil {
+			t.Fatalf("body %d missing: %v", i, err)
+		}
+		if _, err := db.Ancient("bodies", i); err != nil {
+			t.Fatalf("failed to retrieve body %d: %v", i, err)
+		}
+	}
+	if _, err := db.Ancient("bodies", 10); err == nil {
+		t.Fatalf("retrieved body beyond the store")
+	}
+	if store.reads != reads {
+		t.Fatalf("cached items retrieved from server: %d reads", store.reads-reads)
+	}
+	items, err := db.ReadAncients("headers", 2, 5, 20)
+	if err != nil {
+		t.Fatalf("failed to read headers: %v", err)
+	}
+	if len(items) != 2 || string(items[0]) != "header-2" || string(items[1]) != "header-3" {
+		t.Fatalf("headers mismatch: %q", items)
+	}
+	if size, err := db.AncientSize("headers"); err != nil || size != 80 {
+		t.Fatalf("headers size mismatch: have %d, %v, want 80", size, err)
+	}
+	// The shared store can't be truncated by the nodes, nor by default over RPC
+	if err := db.TruncateAncients(5); err != errShared {
+		t.Fatalf("truncation error mismatch: have %v, want %v", err, errShared)
+	}
+	if err := db.TruncateAncientTail(5); err != errShared {
+		t.Fatalf("tail truncation error mismatch: have %v, want %v", err, errShared)
+	}
+	if err := db.client.Call(nil, Namespace+"_truncateAncients", "0x5"); err == nil {
+		t.Fatalf("store truncated with truncations disabled")
+	}
+	if n, err := store.Ancients(); err != nil || n != 10 {
+		t.Fatalf("ancients mismatch after refused truncation: have %d, %v, want 10", n, err)
+	}
+	// Read only stores reject mutations
+	ro := New(rpc.DialInProc(server), true)
+	defer ro.Close()
+
+	if err := appendTestBlock(ro, 6, 0xaa); err != errReadOnly {
+		t.Fatalf("read only append error mismatch: have %v, want %v", err, errReadOnly)
+	}
+	if err := ro.TruncateAncients(0); err != errReadOnly {
+		t.Fatalf("read only truncation error mismatch: have %v, want %v", err, errReadOnly)
+	}
+}
+
+// Tests that two nodes can share a store with one of them behind the other, and
+// that the nodes drop their cached items once the store is truncated.
+func TestSharedAncientStore(t *testing.T) {
+	store := newTestStore()
+	server, err := NewServer(store, true)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer server.Stop()
+
+	ahead := New(rpc.DialInProc(server), false)
+	defer ahead.Close()
+	behind := New(rpc.DialInProc(server), false)
+	defer behind.Close()
+
+	for i := uint64(0); i < 10; i++ {
+		if err := appendTestBlock(ahead, i, 0xaa); err != nil {
+			t.Fatalf("failed to append block %d: %v", i, err)
+		}
+	}
+	// The node behind freezes the blocks it has, the store being ahead of it
+	for i := uint64(0); i < 5; i++ {
+		if err := appendTestBlock(behind, i, 0xaa); err != nil {
+			t.Fatalf("failed to append stored block %d: %v", i, err)
+		}
+	}
+	if n, err := behind.Ancients(); err != nil || n != 10 {
+		t.Fatalf("ancients mismatch: have %d, %v, want 10", n, err)
+	}
+	if err := behind.TruncateAncients(5); err != errShared {
+		t.Fatalf("truncation error mismatch: have %v, want %v", err, errShared)
+	}
+	for i := uint64(0); i < 10; i++ {
+		if _, err := behind.Ancient(hashTable, i); err != nil {
+			t.Fatalf("failed to retrieve hash %d: %v", i, err)
+		}
+	}
+	// Truncate the store over RPC and replace the discarded blocks
+	if err := ahead.client.Call(nil, Namespace+"_truncateAncients", "0x5"); err != nil {
+		t.Fatalf("failed to truncate store: %v", err)
+	}
+	for i := uint64(5); i < 8; i++ {
+		if err := appendTestBlock(ahead, i, 0xbb); err != nil {
+			t.Fatalf("failed to append block %d: %v", i, err)
+		}
+	}
+	// Once its status is stale, the node behind picks up the new blocks instead
+	// of the cached ones
+	behind.lock.Lock()
+	behind.updated = time.Time{}
+	behind.lock.Unlock()
+
+	if blob, err := behind.Ancient(hashTable, 5); err != nil || !bytes.Equal(blob, []byte{0xbb, 5}) {
+		t.Fatalf("hash mismatch after truncation: have %x, %v", blob, err)
+	}
+	if _, err := behind.Ancient(hashTable, 9); err == nil {
+		t.Fatalf("retrieved truncated hash")
+	}
+	if ok, _ := behind.HasAncient(hashTable, 8); ok {
+		t.Fatalf("truncated hash reported present")
+	}
+}
+
+// Tests that a remote store can be dialled over HTTP.
+func TestDialRemoteAncientStore(t *testing.T) {
+	store := newTestStore()
+	server, err := NewServer(store, false)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer server.Stop()
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	path := Scheme + strings.TrimPrefix(httpsrv.URL, "http://")
+	if !IsRemote(path) {
+		t.Fatalf("remote path not recognised: %s", path)
+	}
+	db, err := Dial(path, false)
+	if err != nil {
+		t.Fatalf("failed to dial remote store: %v", err)
+	}
+	defer db.Close()
+
+	if err := appendTestBlock(db, 0, 0xaa); err != nil {
+		t.Fatalf("failed to append block: %v", err)
+	}
+	if blob, err := db.Ancient("headers", 0); err != nil || string(blob) != "header-0" {
+		t.Fatalf("header mismatch: have %q, %v", blob, err)
+	}
+	if _, err := Dial(Scheme+"127.0.0.1:1", false); err == nil {
+		t.Fatalf("dialled unavailable store")
+	}
+}
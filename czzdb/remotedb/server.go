@@ -0,0 +1,200 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package remotedb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/rpc"
+)
+
+// Namespace is the RPC namespace the ancient store operations are served in.
+const Namespace = "ancient"
+
+// hashTable is the ancient table holding the block hashes, used to recognise
+// blocks appended by another node sharing the store.
+const hashTable = "hashes"
+
+// errTruncateDisabled is returned if the served store would be truncated while
+// truncations are not enabled.
+var errTruncateDisabled = errors.New("truncating the served ancient store is disabled")
+
+// Status is the number of items in a served store, along with the number of
+// truncations since the server started, letting the clients drop their caches
+// when the stored items change.
+type Status struct {
+	Items       hexutil.Uint64 `json:"items"`
+	Truncations hexutil.Uint64 `json:"truncations"`
+}
+
+// Service exposes an ancient store over RPC.
+type Service struct {
+	store    czzdb.AncientStore
+	truncate bool // Whether the stored items may be discarded
+
+	truncations uint64     // Number of truncations since the server started
+	lock        sync.Mutex // Serializes the mutations of the store
+}
+
+// NewService creates an RPC service serving the given ancient store. As the
+// store is shared by several nodes, it can't be truncated unless enabled.
+func NewService(store czzdb.AncientStore, truncate bool) *Service {
+	return &Service{store: store, truncate: truncate}
+}
+
+// NewServer creates an RPC server serving the given ancient store in the ancient
+// namespace.
+func NewServer(store czzdb.AncientStore, truncate bool) (*rpc.Server, error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName(Namespace, NewService(store, truncate)); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// HasAncient returns an indicator whether the specified data exists in the
+// ancient store.
+func (s *Service) HasAncient(kind string, number hexutil.Uint64) (bool, error) {
+	return s.store.HasAncient(kind, uint64(number))
+}
+
+// Ancient retrieves an ancient binary blob from the store.
+func (s *Service) Ancient(kind string, number hexutil.Uint64) (hexutil.Bytes, error) {
+	return s.store.Ancient(kind, uint64(number))
+}
+
+// ReadAncients retrieves multiple items in sequence, starting from the index
+// 'start', at most 'count' of them and as many as fit into maxBytes.
+func (s *Service) ReadAncients(kind string, start, count, maxBytes hexutil.Uint64) ([]hexutil.Bytes, error) {
+	items, err := s.store.ReadAncients(kind, uint64(start), uint64(count), uint64(maxBytes))
+	if err != nil {
+		return nil, err
+	}
+	blobs := make([]hexutil.Bytes, len(items))
+	for i, item := range items {
+		blobs[i] = item
+	}
+	return blobs, nil
+}
+
+// Ancients returns the number of items in the ancient store.
+func (s *Service) Ancients() (hexutil.Uint64, error) {
+	n, err := s.store.Ancients()
+	return hexutil.Uint64(n), err
+}
+
+// Status returns the number of items in the ancient store and the number of
+// truncations served.
+func (s *Service) Status() (*Status, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	n, err := s.store.Ancients()
+	if err != nil {
+		return nil, err
+	}
+	return &Status{Items: hexutil.Uint64(n), Truncations: hexutil.Uint64(s.truncations)}, nil
+}
+
+// AncientSize returns the ancient size of the specified category.
+func (s *Service) AncientSize(kind string) (hexutil.Uint64, error) {
+	size, err := s.store.AncientSize(kind)
+	return hexutil.Uint64(size), err
+}
+
+// AncientTail returns the number of the first block whose bodies and receipts
+// are retained in the ancient store.
+func (s *Service) AncientTail() (hexutil.Uint64, error) {
+	tail, err := s.store.AncientTail()
+	return hexutil.Uint64(tail), err
+}
+
+// AppendAncient injects all binary blobs belong to block at the end of the store.
+// As several nodes following the same chain may share the store, re-appending an
+// already stored block is accepted.
+func (s *Service) AppendAncient(number hexutil.Uint64, hash, header, body, receipts, td hexutil.Bytes) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	frozen, err := s.store.Ancients()
+	if err != nil {
+		return err
+	}
+	if uint64(number) < frozen {
+		stored, err := s.store.Ancient(hashTable, uint64(number))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(stored, hash) {
+			return fmt.Errorf("ancient block #%d mismatch: have %x, stored %x", number, []byte(hash), stored)
+		}
+		return nil
+	}
+	return s.store.AppendAncient(uint64(number), hash, header, body, receipts, td)
+}
+
+// TruncateAncients discards all but the first n ancient data from the store.
+func (s *Service) TruncateAncients(n hexutil.Uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	frozen, err := s.store.Ancients()
+	if err != nil {
+		return err
+	}
+	if uint64(n) >= frozen {
+		return nil
+	}
+	if !s.truncate {
+		return fmt.Errorf("%w: %d items, truncating to %d", errTruncateDisabled, frozen, n)
+	}
+	s.truncations++
+	return s.store.TruncateAncients(uint64(n))
+}
+
+// TruncateAncientTail discards the bodies and receipts of all ancient blocks
+// below the given number.
+func (s *Service) TruncateAncientTail(tail hexutil.Uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current, err := s.store.AncientTail()
+	if err != nil {
+		return err
+	}
+	if uint64(tail) <= current {
+		return nil
+	}
+	if !s.truncate {
+		return fmt.Errorf("%w: tail %d, pruning to %d", errTruncateDisabled, current, tail)
+	}
+	s.truncations++
+	return s.store.TruncateAncientTail(uint64(tail))
+}
+
+// Sync flushes all in-memory ancient store data to disk.
+func (s *Service) Sync() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.Sync()
+}
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"math/big"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/classzz/go-classzz-v2"
@@ -37,7 +38,8 @@ import (
 )
 
 var (
-	errBlockInvariant = errors.New("block objects must be instantiated with at least one of num or hash")
+	errBlockInvariant    = errors.New("block objects must be instantiated with at least one of num or hash")
+	errStateNotAvailable = errors.New("state not available")
 )
 
 type Long int64
@@ -477,6 +479,52 @@ func (t *Transaction) V(ctx context.Context) (hexutil.Big, error) {
 
 type BlockType int
 
+// Receipt represents the receipt of a mined transaction.
+type Receipt struct {
+	tx      *Transaction
+	receipt *types.Receipt
+}
+
+func (r *Receipt) Transaction(ctx context.Context) *Transaction {
+	return r.tx
+}
+
+func (r *Receipt) Status(ctx context.Context) Long {
+	return Long(r.receipt.Status)
+}
+
+func (r *Receipt) GasUsed(ctx context.Context) Long {
+	return Long(r.receipt.GasUsed)
+}
+
+func (r *Receipt) CumulativeGasUsed(ctx context.Context) Long {
+	return Long(r.receipt.CumulativeGasUsed)
+}
+
+func (r *Receipt) EffectiveGasPrice(ctx context.Context) (*hexutil.Big, error) {
+	return r.tx.EffectiveGasPrice(ctx)
+}
+
+func (r *Receipt) CreatedContract(ctx context.Context, args BlockNumberArgs) (*Account, error) {
+	return r.tx.CreatedContract(ctx, args)
+}
+
+func (r *Receipt) Logs(ctx context.Context) []*Log {
+	ret := make([]*Log, 0, len(r.receipt.Logs))
+	for _, log := range r.receipt.Logs {
+		ret = append(ret, &Log{
+			backend:     r.tx.backend,
+			transaction: r.tx,
+			log:         log,
+		})
+	}
+	return ret
+}
+
+func (r *Receipt) LogsBloom(ctx context.Context) hexutil.Bytes {
+	return r.receipt.Bloom.Bytes()
+}
+
 // Block represents an Classzz block.
 // backend, and numberOrHash are mandatory. All other fields are lazily fetched
 // when required.
@@ -487,6 +535,7 @@ type Block struct {
 	header       *types.Header
 	block        *types.Block
 	receipts     []*types.Receipt
+	tewaka       *TeWaka
 }
 
 // resolve returns the internal Block object representing this block, fetching
@@ -799,6 +848,38 @@ func (b *Block) Transactions(ctx context.Context) (*[]*Transaction, error) {
 	return &ret, nil
 }
 
+func (b *Block) Receipts(ctx context.Context) (*[]*Receipt, error) {
+	block, err := b.resolve(ctx)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	receipts, err := b.resolveReceipts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	txs := block.Transactions()
+	if receipts == nil && len(txs) > 0 {
+		return nil, nil
+	}
+	if len(receipts) != len(txs) {
+		return nil, fmt.Errorf("receipts length mismatch: %d vs %d", len(txs), len(receipts))
+	}
+	ret := make([]*Receipt, 0, len(receipts))
+	for i, receipt := range receipts {
+		ret = append(ret, &Receipt{
+			tx: &Transaction{
+				backend: b.backend,
+				hash:    txs[i].Hash(),
+				tx:      txs[i],
+				block:   b,
+				index:   uint64(i),
+			},
+			receipt: receipt,
+		})
+	}
+	return &ret, nil
+}
+
 func (b *Block) TransactionAt(ctx context.Context, args struct{ Index int32 }) (*Transaction, error) {
 	block, err := b.resolve(ctx)
 	if err != nil || block == nil {
@@ -1051,6 +1132,39 @@ func (p *Pending) EstimateGas(ctx context.Context, args struct {
 // Resolver is the top-level object in the GraphQL hierarchy.
 type Resolver struct {
 	backend czzapi.Backend
+
+	events     *filters.EventSystem // Event system of the subscriptions, created on first use
+	eventsLock sync.Mutex
+}
+
+// eventSystem returns the event system feeding the subscriptions.
+func (r *Resolver) eventSystem() *filters.EventSystem {
+	r.eventsLock.Lock()
+	defer r.eventsLock.Unlock()
+
+	if r.events == nil {
+		r.events = filters.NewEventSystem(r.backend, false)
+	}
+	return r.events
+}
+
+// Start implements node.Lifecycle, the event system is only created on demand.
+func (r *Resolver) Start() error {
+	return nil
+}
+
+// Stop implements node.Lifecycle, stopping the event system of the subscriptions.
+// The node stops serving requests before the lifecycles, so no subscription can
+// be created afterwards.
+func (r *Resolver) Stop() error {
+	r.eventsLock.Lock()
+	defer r.eventsLock.Unlock()
+
+	if r.events != nil {
+		r.events.Stop()
+		r.events = nil
+	}
+	return nil
 }
 
 func (r *Resolver) Block(ctx context.Context, args struct {
@@ -197,6 +197,42 @@ func TestGraphQLBlockSerializationEIP2718(t *testing.T) {
 	}
 }
 
+func TestGraphQLBlockReceipts(t *testing.T) {
+	stack := createNode(t, false, false)
+	defer stack.Close()
+	backend := createGQLServiceWithTransactions(t, stack)
+	// start node
+	if err := stack.Start(); err != nil {
+		t.Fatalf("could not start node: %v", err)
+	}
+	head := backend.BlockChain().CurrentBlock()
+	receipts := backend.BlockChain().GetReceiptsByHash(head.Hash())
+	if len(receipts) != len(head.Transactions()) || len(receipts) == 0 {
+		t.Fatalf("receipt count mismatch: have %d, want %d", len(receipts), len(head.Transactions()))
+	}
+	var items []string
+	for i, tx := range head.Transactions() {
+		items = append(items, fmt.Sprintf(`{"transaction":{"hash":"%s","index":%d},"status":%d,"logs":[]}`, tx.Hash().Hex(), i, receipts[i].Status))
+	}
+	want := `{"data":{"block":{"receipts":[` + strings.Join(items, ",") + `]}}}`
+	body := `{"query": "{block {receipts { transaction { hash index } status logs { index } }}}"}`
+
+	resp, err := http.Post(fmt.Sprintf("%s/graphql", stack.HTTPEndpoint()), "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("could not post: %v", err)
+	}
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("could not read from response body: %v", err)
+	}
+	if have := string(bodyBytes); have != want {
+		t.Errorf("have:\n%v\nwant:\n%v", have, want)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("wrong statuscode, have: %v, want: 200", resp.StatusCode)
+	}
+}
+
 // Tests that a graphQL request is not handled successfully when graphql is not enabled on the specified endpoint
 func TestGraphQLHTTPOnSamePort_GQLRequest_Unsuccessful(t *testing.T) {
 	stack := createNode(t, false, false)
@@ -213,6 +249,14 @@ func TestGraphQLHTTPOnSamePort_GQLRequest_Unsuccessful(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 }
 
+// testChainConfig is the chain configuration of the test nodes. The CIP4 and
+// CIP5 transitions are scheduled, as the consensus engine expects them to be.
+var testChainConfig = &params.ChainConfig{
+	ChainID: big.NewInt(1337),
+	CIP_4:   big.NewInt(1000000),
+	CIP_5:   big.NewInt(1000000),
+}
+
 func createNode(t *testing.T, gqlEnabled bool, txEnabled bool) *node.Node {
 	stack, err := node.New(&node.Config{
 		HTTPHost: "127.0.0.1",
@@ -238,7 +282,7 @@ func createGQLService(t *testing.T, stack *node.Node) {
 	// create backend
 	ethConf := &czzconfig.Config{
 		Genesis: &core.Genesis{
-			Config:     params.AllEthashProtocolChanges,
+			Config:     testChainConfig,
 			GasLimit:   11500000,
 			Difficulty: big.NewInt(1048576),
 		},
@@ -258,7 +302,7 @@ func createGQLService(t *testing.T, stack *node.Node) {
 		t.Fatalf("could not create czz backend: %v", err)
 	}
 	// Create some blocks and import them
-	chain, _ := core.GenerateChain(params.AllEthashProtocolChanges, ethBackend.BlockChain().Genesis(),
+	chain, _ := core.GenerateChain(testChainConfig, ethBackend.BlockChain().Genesis(),
 		ethash.NewFaker(), ethBackend.ChainDb(), 10, func(i int, gen *core.BlockGen) {})
 	_, err = ethBackend.BlockChain().InsertChain(chain)
 	if err != nil {
@@ -271,7 +315,7 @@ func createGQLService(t *testing.T, stack *node.Node) {
 	}
 }
 
-func createGQLServiceWithTransactions(t *testing.T, stack *node.Node) {
+func createGQLServiceWithTransactions(t *testing.T, stack *node.Node) *czz.Classzz {
 	// create backend
 	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
 	address := crypto.PubkeyToAddress(key.PublicKey)
@@ -280,7 +324,7 @@ func createGQLServiceWithTransactions(t *testing.T, stack *node.Node) {
 
 	ethConf := &czzconfig.Config{
 		Genesis: &core.Genesis{
-			Config:     params.AllEthashProtocolChanges,
+			Config:     testChainConfig,
 			GasLimit:   11500000,
 			Difficulty: big.NewInt(1048576),
 			Alloc: core.GenesisAlloc{
@@ -338,7 +382,7 @@ func createGQLServiceWithTransactions(t *testing.T, stack *node.Node) {
 	})
 
 	// Create some blocks and import them
-	chain, _ := core.GenerateChain(params.AllEthashProtocolChanges, ethBackend.BlockChain().Genesis(),
+	chain, _ := core.GenerateChain(testChainConfig, ethBackend.BlockChain().Genesis(),
 		ethash.NewFaker(), ethBackend.ChainDb(), 1, func(i int, b *core.BlockGen) {
 			b.SetCoinbase(common.Address{1})
 			b.AddTx(legacyTx)
@@ -354,4 +398,5 @@ func createGQLServiceWithTransactions(t *testing.T, stack *node.Node) {
 	if err != nil {
 		t.Fatalf("could not create graphql service: %v", err)
 	}
+	return ethBackend
 }
@@ -34,6 +34,7 @@ const schema string = `
     schema {
         query: Query
         mutation: Mutation
+        subscription: Subscription
     }
 
     # Account is an Classzz account at a particular block.
@@ -140,6 +141,32 @@ const schema string = `
         accessList: [AccessTuple!]
     }
 
+    # Receipt is the outcome of executing a transaction that has been mined.
+    type Receipt {
+        # Transaction is the transaction the receipt belongs to.
+        transaction: Transaction!
+        # Status is the return status of the transaction. This will be 1 if the
+        # transaction succeeded, or 0 if it failed (due to a revert, or due to
+        # running out of gas).
+        status: Long!
+        # GasUsed is the amount of gas that was used processing the transaction.
+        gasUsed: Long!
+        # CumulativeGasUsed is the total gas used in the block up to and including
+        # the transaction.
+        cumulativeGasUsed: Long!
+        # EffectiveGasPrice is actual value per gas deducted from the sender's
+        # account.
+        effectiveGasPrice: BigInt
+        # CreatedContract is the account that was created by a contract creation
+        # transaction. If the transaction was not a contract creation transaction,
+        # this field will be null.
+        createdContract(block: Long): Account
+        # Logs is a list of log entries emitted by the transaction.
+        logs: [Log!]!
+        # LogsBloom is a bloom filter of the log entries emitted by the transaction.
+        logsBloom: Bytes!
+    }
+
     # BlockFilterCriteria encapsulates log filter criteria for a filter applied
     # to a single block.
     input BlockFilterCriteria {
@@ -206,6 +233,10 @@ const schema string = `
         # transactions are unavailable for this block, or if the index is out of
         # bounds, this field will be null.
         transactionAt(index: Int!): Transaction
+        # Receipts is a list of the receipts of the transactions in this block,
+        # in the same order. If receipts are unavailable for this block, this
+        # field will be null.
+        receipts: [Receipt!]
         # Logs returns a filtered set of logs from this block.
         logs(filter: BlockFilterCriteria!): [Log!]!
         # Account fetches an Classzz account at the current block's state.
@@ -215,6 +246,92 @@ const schema string = `
         # EstimateGas estimates the amount of gas that will be required for
         # successful execution of a transaction at the current block's state.
         estimateGas(data: CallData!): Long!
+        # TeWaka is the TeWaka state after this block was processed.
+        tewaka: TeWaka!
+        # Pledges is the list of TeWaka staking pledges at this block.
+        pledges: [Pledge!]!
+        # ConvertItems is the list of TeWaka conversions waiting to be confirmed
+        # at this block, optionally filtered by asset and convert type.
+        convertItems(filter: ConvertItemFilter): [ConvertItem!]!
+        # StakingFactor is the amount staked for the miner of this block and the
+        # resulting mining factor.
+        stakingFactor: StakingFactor!
+    }
+
+    # Pledge is a TeWaka staking pledge.
+    type Pledge {
+        # Address is the account that made the pledge.
+        address: Address!
+        # PubKey is the public key registered with the pledge.
+        pubKey: Bytes!
+        # ToAddress is the account holding the staked amount.
+        toAddress: Address!
+        # StakingAmount is the staked amount, in wei.
+        stakingAmount: BigInt!
+        # Coinbases is the list of coinbases the stake counts towards.
+        coinbases: [Address!]!
+    }
+
+    # ConvertItem is a TeWaka conversion waiting to be confirmed.
+    type ConvertItem {
+        # ID is the sequence number of the conversion.
+        id: BigInt!
+        # AssetType is the network the converted asset comes from.
+        assetType: Int!
+        # ConvertType is the network the asset is converted to.
+        convertType: Int!
+        # TxHash is the hash of the originating transaction.
+        txHash: Bytes32!
+        # PubKey is the public key of the receiver.
+        pubKey: Bytes!
+        # Amount is the converted amount.
+        amount: BigInt!
+        # FeeAmount is the fee taken from the amount.
+        feeAmount: BigInt!
+        # Path is the swap path on the target network.
+        path: [Address!]!
+        # RouterAddress is the swap router on the target network.
+        routerAddress: Address!
+        # Slippage is the accepted swap slippage.
+        slippage: BigInt
+        # IsInsurance is whether the conversion is insured.
+        isInsurance: Boolean!
+        # Extra is additional conversion data.
+        extra: Bytes!
+    }
+
+    # ConvertItemFilter restricts convert items to the given types. All fields
+    # are optional.
+    input ConvertItemFilter {
+        # AssetType is the network the converted asset comes from.
+        assetType: Int
+        # ConvertType is the network the asset is converted to.
+        convertType: Int
+    }
+
+    # StakingFactor is the amount staked for a coinbase and the resulting
+    # mining factor.
+    type StakingFactor {
+        # Coinbase is the coinbase the stake counts towards.
+        coinbase: Address!
+        # StakingAmount is the total amount staked for the coinbase, in wei.
+        stakingAmount: BigInt!
+        # Factor is the mining factor resulting from the staked amount.
+        factor: BigInt!
+    }
+
+    # TeWaka is the TeWaka bridge state at a particular block.
+    type TeWaka {
+        # Block is the block the state belongs to.
+        block: Block!
+        # Pledges is the list of staking pledges.
+        pledges: [Pledge!]!
+        # ConvertItems is the list of conversions waiting to be confirmed,
+        # optionally filtered by asset and convert type.
+        convertItems(filter: ConvertItemFilter): [ConvertItem!]!
+        # StakingFactor is the amount staked for a coinbase and the resulting
+        # mining factor.
+        stakingFactor(coinbase: Address!): StakingFactor!
     }
 
     # CallData represents the data associated with a local contract call.
@@ -327,10 +444,24 @@ const schema string = `
         syncing: SyncState
         # ChainID returns the current chain ID for transaction replay protection.
         chainID: BigInt!
+        # TeWaka returns the TeWaka state at a block, or at the most recent
+        # known block if none is supplied.
+        tewaka(block: Long): TeWaka
     }
 
     type Mutation {
         # SendRawTransaction sends an RLP-encoded transaction to the network.
         sendRawTransaction(data: Bytes!): Bytes32!
     }
+
+    # Subscriptions are served over WebSocket using the graphql-ws protocol.
+    type Subscription {
+        # NewBlocks returns every block added to the canonical chain.
+        newBlocks: Block!
+        # PendingTransactions returns every transaction entering the pool.
+        pendingTransactions: Transaction!
+        # NewLogs returns the log entries of new canonical blocks matching
+        # the provided filter.
+        newLogs(filter: BlockFilterCriteria): Log!
+    }
 `
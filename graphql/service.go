@@ -19,12 +19,17 @@ package graphql
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/classzz/go-classzz-v2/internal/czzapi"
 	"github.com/classzz/go-classzz-v2/node"
 	"github.com/graph-gophers/graphql-go"
 )
 
+// subscribeResolverTimeout is the time allowed to resolve a single event of a
+// subscription.
+const subscribeResolverTimeout = 5 * time.Second
+
 type handler struct {
 	Schema *graphql.Schema
 }
@@ -67,9 +72,9 @@ func New(stack *node.Node, backend czzapi.Backend, cors, vhosts []string) error
 // newHandler returns a new `http.Handler` that will answer GraphQL queries.
 // It additionally exports an interactive query browser on the / endpoint.
 func newHandler(stack *node.Node, backend czzapi.Backend, cors, vhosts []string) error {
-	q := Resolver{backend}
+	q := Resolver{backend: backend}
 
-	s, err := graphql.ParseSchema(schema, &q)
+	s, err := graphql.ParseSchema(schema, &q, graphql.SubscribeResolverTimeout(subscribeResolverTimeout))
 	if err != nil {
 		return err
 	}
@@ -80,5 +85,11 @@ func newHandler(stack *node.Node, backend czzapi.Backend, cors, vhosts []string)
 	stack.RegisterHandler("GraphQL", "/graphql", handler)
 	stack.RegisterHandler("GraphQL", "/graphql/", handler)
 
+	// Subscriptions are served on the WebSocket endpoint
+	ws := wsHandler{Schema: s}
+	stack.RegisterWSHandler("GraphQL subscriptions", "/graphql", ws)
+	stack.RegisterWSHandler("GraphQL subscriptions", "/graphql/", ws)
+
+	stack.RegisterLifecycle(&q)
 	return nil
 }
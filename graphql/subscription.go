@@ -0,0 +1,143 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"context"
+
+	"github.com/classzz/go-classzz-v2"
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/rpc"
+)
+
+// subscriptionBuffer is the number of events queued for a subscriber. Events are
+// resolved by the subscriber itself, so the queue decouples the event system from
+// the resolution. A subscriber falling behind by a full queue is dropped, rather
+// than stalling the event delivery of all the others.
+const subscriptionBuffer = 128
+
+// NewBlocks streams the blocks added to the canonical chain.
+func (r *Resolver) NewBlocks(ctx context.Context) (<-chan *Block, error) {
+	headers := make(chan *types.Header, subscriptionBuffer)
+	sub := r.eventSystem().SubscribeNewHeads(headers)
+
+	blocks := make(chan *Block, subscriptionBuffer)
+	go func() {
+		defer close(blocks)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case header := <-headers:
+				numberOrHash := rpc.BlockNumberOrHashWithHash(header.Hash(), true)
+				block := &Block{
+					backend:      r.backend,
+					numberOrHash: &numberOrHash,
+					hash:         header.Hash(),
+					header:       header,
+				}
+				select {
+				case blocks <- block:
+				default:
+					log.Warn("Dropping slow GraphQL subscriber", "subscription", "newBlocks")
+					return
+				}
+			case <-sub.Err():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return blocks, nil
+}
+
+// PendingTransactions streams the transactions entering the transaction pool.
+func (r *Resolver) PendingTransactions(ctx context.Context) (<-chan *Transaction, error) {
+	hashes := make(chan []common.Hash, subscriptionBuffer)
+	sub := r.eventSystem().SubscribePendingTxs(hashes)
+
+	txs := make(chan *Transaction, subscriptionBuffer)
+	go func() {
+		defer close(txs)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case batch := <-hashes:
+				for _, hash := range batch {
+					select {
+					case txs <- &Transaction{backend: r.backend, hash: hash}:
+					default:
+						log.Warn("Dropping slow GraphQL subscriber", "subscription", "pendingTransactions")
+						return
+					}
+				}
+			case <-sub.Err():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return txs, nil
+}
+
+// NewLogs streams the logs of new canonical blocks matching the filter.
+func (r *Resolver) NewLogs(ctx context.Context, args struct{ Filter *BlockFilterCriteria }) (<-chan *Log, error) {
+	var crit classzz.FilterQuery
+	if args.Filter != nil {
+		if args.Filter.Addresses != nil {
+			crit.Addresses = *args.Filter.Addresses
+		}
+		if args.Filter.Topics != nil {
+			crit.Topics = *args.Filter.Topics
+		}
+	}
+	matches := make(chan []*types.Log, subscriptionBuffer)
+	sub, err := r.eventSystem().SubscribeLogs(crit, matches)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make(chan *Log, subscriptionBuffer)
+	go func() {
+		defer close(logs)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case batch := <-matches:
+				for _, matched := range batch {
+					select {
+					case logs <- &Log{backend: r.backend, transaction: &Transaction{backend: r.backend, hash: matched.TxHash}, log: matched}:
+					default:
+						log.Warn("Dropping slow GraphQL subscriber", "subscription", "newLogs")
+						return
+					}
+				}
+			case <-sub.Err():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return logs, nil
+}
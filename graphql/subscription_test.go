@@ -0,0 +1,111 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/consensus/ethash"
+	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/czz"
+	"github.com/classzz/go-classzz-v2/czz/czzconfig"
+)
+
+// Tests that a subscriber not keeping up with the new blocks is dropped once its
+// queue is full, instead of stalling the event delivery, and that stopping the
+// resolver ends the remaining subscriptions.
+func TestNewBlocksSlowSubscriber(t *testing.T) {
+	stack := createNode(t, false, false)
+	defer stack.Close()
+
+	ethConf := &czzconfig.Config{
+		Genesis: &core.Genesis{
+			Config:     testChainConfig,
+			GasLimit:   11500000,
+			Difficulty: big.NewInt(1048576),
+		},
+		Ethash:    ethash.Config{PowMode: ethash.ModeFake},
+		NetworkId: 1337,
+	}
+	ethBackend, err := czz.New(stack, ethConf)
+	if err != nil {
+		t.Fatalf("could not create czz backend: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatalf("could not start node: %v", err)
+	}
+	resolver := &Resolver{backend: ethBackend.APIBackend}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	slow, err := resolver.NewBlocks(ctx)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	idle, err := resolver.NewBlocks(ctx)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	gendb := rawdb.NewMemoryDatabase()
+	blocks := 3 * subscriptionBuffer
+	chain, _ := core.GenerateChain(testChainConfig, ethConf.Genesis.MustCommit(gendb), ethash.NewFaker(), gendb, blocks, nil)
+
+	// Importing must not be held up by the subscriber not reading its blocks
+	imported := make(chan error, 1)
+	go func() {
+		_, err := ethBackend.BlockChain().InsertChain(chain)
+		imported <- err
+	}()
+	select {
+	case err := <-imported:
+		if err != nil {
+			t.Fatalf("failed to import blocks: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("block import stalled by a slow subscriber")
+	}
+	// The queued blocks are delivered in order, followed by the end of the stream
+	count := 0
+	for block := range slow {
+		count++
+		if number := block.header.Number.Uint64(); number != uint64(count) {
+			t.Fatalf("block %d mismatch: have #%d", count, number)
+		}
+	}
+	if count == 0 || count >= blocks {
+		t.Fatalf("slow subscriber not dropped: received %d of %d blocks", count, blocks)
+	}
+	// Stopping the resolver ends every subscription, drain the idle one to see it
+	if err := resolver.Stop(); err != nil {
+		t.Fatalf("failed to stop resolver: %v", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-idle:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("subscription not ended by stop")
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/consensus"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/classzz/go-classzz-v2/rpc"
+)
+
+// Pledge represents a TeWaka staking pledge.
+type Pledge struct {
+	pledge *types.Pledge
+}
+
+func (p *Pledge) Address(ctx context.Context) common.Address {
+	return p.pledge.Address
+}
+
+func (p *Pledge) PubKey(ctx context.Context) hexutil.Bytes {
+	return p.pledge.PubKey
+}
+
+func (p *Pledge) ToAddress(ctx context.Context) common.Address {
+	return p.pledge.ToAddress
+}
+
+func (p *Pledge) StakingAmount(ctx context.Context) hexutil.Big {
+	return hexutil.Big(*p.pledge.StakingAmount)
+}
+
+func (p *Pledge) Coinbases(ctx context.Context) []common.Address {
+	return p.pledge.CoinBaseAddress
+}
+
+// ConvertItem represents a TeWaka conversion waiting to be confirmed.
+type ConvertItem struct {
+	item *types.ConvertItem
+}
+
+func (c *ConvertItem) ID(ctx context.Context) hexutil.Big {
+	return hexutil.Big(*c.item.ID)
+}
+
+func (c *ConvertItem) AssetType(ctx context.Context) int32 {
+	return int32(c.item.AssetType)
+}
+
+func (c *ConvertItem) ConvertType(ctx context.Context) int32 {
+	return int32(c.item.ConvertType)
+}
+
+func (c *ConvertItem) TxHash(ctx context.Context) common.Hash {
+	return c.item.TxHash
+}
+
+func (c *ConvertItem) PubKey(ctx context.Context) hexutil.Bytes {
+	return c.item.PubKey
+}
+
+func (c *ConvertItem) Amount(ctx context.Context) hexutil.Big {
+	return hexutil.Big(*c.item.Amount)
+}
+
+func (c *ConvertItem) FeeAmount(ctx context.Context) hexutil.Big {
+	return hexutil.Big(*c.item.FeeAmount)
+}
+
+func (c *ConvertItem) Path(ctx context.Context) []common.Address {
+	return c.item.Path
+}
+
+func (c *ConvertItem) RouterAddress(ctx context.Context) common.Address {
+	return c.item.RouterAddr
+}
+
+func (c *ConvertItem) Slippage(ctx context.Context) *hexutil.Big {
+	return (*hexutil.Big)(c.item.Slippage)
+}
+
+func (c *ConvertItem) IsInsurance(ctx context.Context) bool {
+	return c.item.IsInsurance
+}
+
+func (c *ConvertItem) Extra(ctx context.Context) hexutil.Bytes {
+	return c.item.Extra
+}
+
+// StakingFactor is the amount staked for a coinbase and the resulting mining
+// factor.
+type StakingFactor struct {
+	coinbase common.Address
+	amount   hexutil.Big
+	factor   hexutil.Big
+}
+
+func (s *StakingFactor) Coinbase(ctx context.Context) common.Address {
+	return s.coinbase
+}
+
+func (s *StakingFactor) StakingAmount(ctx context.Context) hexutil.Big {
+	return s.amount
+}
+
+func (s *StakingFactor) Factor(ctx context.Context) hexutil.Big {
+	return s.factor
+}
+
+// ConvertItemFilter restricts convert items to an asset and/or convert type.
+type ConvertItemFilter struct {
+	AssetType   *int32
+	ConvertType *int32
+}
+
+// TeWaka represents the TeWaka state after a block was processed.
+type TeWaka struct {
+	block  *Block
+	tewaka *vm.TeWakaImpl
+}
+
+// resolve returns the TeWaka state of the block, loading it if necessary.
+func (t *TeWaka) resolve(ctx context.Context) (*vm.TeWakaImpl, error) {
+	if t.tewaka != nil {
+		return t.tewaka, nil
+	}
+	numberOrHash := t.block.numberOrHash
+	if numberOrHash == nil {
+		header, err := t.block.resolveHeader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		byHash := rpc.BlockNumberOrHashWithHash(header.Hash(), false)
+		numberOrHash = &byHash
+	}
+	state, _, err := t.block.backend.StateAndHeaderByNumberOrHash(ctx, *numberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, errStateNotAvailable
+	}
+	tewaka := vm.NewTeWakaImpl()
+	if err := tewaka.Load(state, vm.TeWaKaAddress); err != nil {
+		return nil, err
+	}
+	t.tewaka = tewaka
+	return tewaka, nil
+}
+
+func (t *TeWaka) Block(ctx context.Context) *Block {
+	return t.block
+}
+
+func (t *TeWaka) Pledges(ctx context.Context) ([]*Pledge, error) {
+	tewaka, err := t.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Pledge, 0, len(tewaka.PledgeInfos))
+	for _, pledge := range tewaka.PledgeInfos {
+		ret = append(ret, &Pledge{pledge})
+	}
+	return ret, nil
+}
+
+func (t *TeWaka) ConvertItems(ctx context.Context, args struct{ Filter *ConvertItemFilter }) ([]*ConvertItem, error) {
+	tewaka, err := t.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*ConvertItem, 0, len(tewaka.ConvertItems))
+	for _, item := range tewaka.ConvertItems {
+		if filter := args.Filter; filter != nil {
+			if filter.AssetType != nil && *filter.AssetType != int32(item.AssetType) {
+				continue
+			}
+			if filter.ConvertType != nil && *filter.ConvertType != int32(item.ConvertType) {
+				continue
+			}
+		}
+		ret = append(ret, &ConvertItem{item})
+	}
+	return ret, nil
+}
+
+func (t *TeWaka) StakingFactor(ctx context.Context, args struct{ Coinbase common.Address }) (*StakingFactor, error) {
+	tewaka, err := t.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	amount := tewaka.GetStakingByUser(args.Coinbase)
+
+	// Stakes below the mining threshold yield no factor
+	factor := consensus.MakeFactorForMine(amount)
+	if factor == nil {
+		factor = new(big.Int)
+	}
+	return &StakingFactor{
+		coinbase: args.Coinbase,
+		amount:   hexutil.Big(*amount),
+		factor:   hexutil.Big(*factor),
+	}, nil
+}
+
+// TeWaka returns the TeWaka state of the block. It is shared by the Block
+// resolvers, so the state is only loaded once per block.
+func (b *Block) TeWaka(ctx context.Context) *TeWaka {
+	if b.tewaka == nil {
+		b.tewaka = &TeWaka{block: b}
+	}
+	return b.tewaka
+}
+
+func (b *Block) Pledges(ctx context.Context) ([]*Pledge, error) {
+	return b.TeWaka(ctx).Pledges(ctx)
+}
+
+func (b *Block) ConvertItems(ctx context.Context, args struct{ Filter *ConvertItemFilter }) ([]*ConvertItem, error) {
+	return b.TeWaka(ctx).ConvertItems(ctx, args)
+}
+
+func (b *Block) StakingFactor(ctx context.Context) (*StakingFactor, error) {
+	header, err := b.resolveHeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return b.TeWaka(ctx).StakingFactor(ctx, struct{ Coinbase common.Address }{header.Coinbase})
+}
+
+func (r *Resolver) TeWaka(ctx context.Context, args BlockNumberArgs) (*TeWaka, error) {
+	numberOrHash := args.NumberOrLatest()
+	block := &Block{
+		backend:      r.backend,
+		numberOrHash: &numberOrHash,
+	}
+	// Resolve the header, return nil if it doesn't exist.
+	h, err := block.resolveHeader(ctx)
+	if err != nil {
+		return nil, err
+	} else if h == nil {
+		return nil, nil
+	}
+	return block.TeWaka(ctx), nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/consensus/ethash"
+	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/czz"
+	"github.com/classzz/go-classzz-v2/czz/czzconfig"
+	"github.com/classzz/go-classzz-v2/node"
+)
+
+var (
+	tewakaStaker  = common.HexToAddress("0x5CEED59b15E4566A01020989Ca848DdA69B92b9F")
+	tewakaMiner   = common.HexToAddress("0x2faeb19FABC6aB7C174B2E698A2f21dCe5Bd595A")
+	tewakaStake   = common.BytesToAddress([]byte{1, 1})
+	tewakaPubkey  = common.Hex2Bytes("03fe89d1c232d3e1e39790803f3998ec9abb8af2175221347c12c151e5e7f932f0")
+	tewakaStaking = new(big.Int).Mul(big.NewInt(2000000), big.NewInt(1e18))
+)
+
+// createGQLServiceWithPledges creates a chain of the given length, mined by a
+// coinbase staked for in the genesis committee.
+func createGQLServiceWithPledges(t *testing.T, blocks int) *node.Node {
+	stack := createNode(t, false, false)
+	t.Cleanup(func() { stack.Close() })
+
+	ethConf := &czzconfig.Config{
+		Genesis: &core.Genesis{
+			Config:     testChainConfig,
+			GasLimit:   11500000,
+			Difficulty: big.NewInt(1048576),
+			Alloc: core.GenesisAlloc{
+				tewakaStaker: {Balance: new(big.Int).Mul(tewakaStaking, big.NewInt(2))},
+			},
+			Committee: []*core.StakeMember{{
+				Coinbase:        tewakaStaker,
+				StakeBase:       tewakaStake,
+				Pubkey:          tewakaPubkey,
+				Amount:          tewakaStaking,
+				CoinBaseAddress: []common.Address{tewakaStaker, tewakaMiner},
+			}},
+		},
+		Ethash: ethash.Config{
+			PowMode: ethash.ModeFake,
+		},
+		NetworkId:      1337,
+		TrieCleanCache: 5,
+		TrieDirtyCache: 5,
+		TrieTimeout:    60 * time.Minute,
+		SnapshotCache:  5,
+	}
+	ethBackend, err := czz.New(stack, ethConf)
+	if err != nil {
+		t.Fatalf("could not create czz backend: %v", err)
+	}
+	// Generate the blocks on a separate database, so the states of the old ones
+	// are only available to the node while they are kept in memory
+	gendb := rawdb.NewMemoryDatabase()
+	genesis := ethConf.Genesis.MustCommit(gendb)
+	chain, _ := core.GenerateChain(testChainConfig, genesis, ethash.NewFaker(), gendb, blocks, func(i int, gen *core.BlockGen) {
+		gen.SetCoinbase(tewakaMiner)
+	})
+	if _, err := ethBackend.BlockChain().InsertChain(chain); err != nil {
+		t.Fatalf("could not import blocks: %v", err)
+	}
+	if err := New(stack, ethBackend.APIBackend, []string{}, []string{}); err != nil {
+		t.Fatalf("could not create graphql service: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatalf("could not start node: %v", err)
+	}
+	return stack
+}
+
+// queryGQL posts a query to a graphql endpoint, returning the response body.
+func queryGQL(t *testing.T, endpoint string, query string) string {
+	body := fmt.Sprintf(`{"query": %q}`, query)
+	resp, err := http.Post(fmt.Sprintf("%s/graphql", endpoint), "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("could not post: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("could not read from response body: %v", err)
+	}
+	return string(data)
+}
+
+// Tests that the TeWaka state of a block is served, both through the block and
+// through the top level query.
+func TestGraphQLTeWaka(t *testing.T) {
+	stack := createGQLServiceWithPledges(t, 2)
+
+	pledge := fmt.Sprintf(`{"address":"%s","pubKey":"0x%x","toAddress":"%s","stakingAmount":"0x%x","coinbases":["%s","%s"]}`,
+		strings.ToLower(tewakaStaker.Hex()), tewakaPubkey, strings.ToLower(tewakaStake.Hex()), tewakaStaking,
+		strings.ToLower(tewakaStaker.Hex()), strings.ToLower(tewakaMiner.Hex()))
+	factor := fmt.Sprintf(`{"coinbase":"%s","stakingAmount":"0x%x","factor":"0x8"}`, strings.ToLower(tewakaMiner.Hex()), tewakaStaking)
+
+	for i, tt := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `{block{number pledges{address pubKey toAddress stakingAmount coinbases} stakingFactor{coinbase stakingAmount factor}}}`,
+			want:  `{"data":{"block":{"number":2,"pledges":[` + pledge + `],"stakingFactor":` + factor + `}}}`,
+		},
+		{
+			query: `{block(number:1){tewaka{block{number} pledges{address}}}}`,
+			want:  fmt.Sprintf(`{"data":{"block":{"tewaka":{"block":{"number":1},"pledges":[{"address":"%s"}]}}}}`, strings.ToLower(tewakaStaker.Hex())),
+		},
+		{
+			query: `{block{convertItems{id} tewaka{convertItems(filter:{assetType:1,convertType:2}){id}}}}`,
+			want:  `{"data":{"block":{"convertItems":[],"tewaka":{"convertItems":[]}}}}`,
+		},
+		{
+			query: fmt.Sprintf(`{tewaka(block:0){block{number} stakingFactor(coinbase:"%s"){stakingAmount factor}}}`, tewakaStake.Hex()),
+			want:  `{"data":{"tewaka":{"block":{"number":0},"stakingFactor":{"stakingAmount":"0x0","factor":"0x0"}}}}`,
+		},
+		{
+			query: `{tewaka(block:5){block{number}}}`,
+			want:  `{"data":{"tewaka":null}}`,
+		},
+	} {
+		if have := queryGQL(t, stack.HTTPEndpoint(), tt.query); have != tt.want {
+			t.Errorf("testcase %d:\nhave: %v\nwant: %v", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that querying the TeWaka state of a block whose state was pruned fails
+// instead of returning an empty state.
+func TestGraphQLTeWakaPrunedState(t *testing.T) {
+	stack := createGQLServiceWithPledges(t, int(core.TriesInMemory)+10)
+
+	have := queryGQL(t, stack.HTTPEndpoint(), `{block(number:1){number pledges{address}}}`)
+	if !strings.Contains(have, `"errors"`) || strings.Contains(have, `"pledges":[]`) {
+		t.Fatalf("pruned state not reported: %v", have)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/gorilla/websocket"
+	"github.com/graph-gophers/graphql-go"
+)
+
+// The graphql-ws protocol, as defined by subscriptions-transport-ws.
+const (
+	wsProtocol = "graphql-ws"
+
+	gqlConnectionInit      = "connection_init"      // Client -> Server
+	gqlConnectionAck       = "connection_ack"       // Server -> Client
+	gqlConnectionError     = "connection_error"     // Server -> Client
+	gqlConnectionKeepAlive = "ka"                   // Server -> Client
+	gqlConnectionTerminate = "connection_terminate" // Client -> Server
+	gqlStart               = "start"                // Client -> Server
+	gqlData                = "data"                 // Server -> Client
+	gqlError               = "error"                // Server -> Client
+	gqlComplete            = "complete"             // Server -> Client
+	gqlStop                = "stop"                 // Client -> Server
+)
+
+const (
+	wsMaxSubscriptions = 32               // Active operations allowed per connection
+	wsSendQueue        = 256              // Messages queued for a connection before it's dropped
+	wsReadLimit        = 128 * 1024       // Maximum size of a client message
+	wsWriteTimeout     = 10 * time.Second // Time allowed to write a message to the client
+	wsKeepAlive        = 30 * time.Second // Interval of keep-alive messages
+)
+
+// wsMessage is a graphql-ws protocol message.
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// wsStartPayload is the payload of a start message.
+type wsStartPayload struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// wsHandler serves GraphQL operations, subscriptions in particular, over
+// WebSocket. Origins are checked by the node before the request gets here.
+type wsHandler struct {
+	Schema *graphql.Schema
+}
+
+func (h wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{wsProtocol},
+		CheckOrigin:  func(*http.Request) bool { return true },
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debug("GraphQL WebSocket upgrade failed", "err", err)
+		return
+	}
+	if conn.Subprotocol() != wsProtocol {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, "unsupported subprotocol"), time.Now().Add(wsWriteTimeout))
+		conn.Close()
+		return
+	}
+	newWSConn(h.Schema, conn).run()
+}
+
+// wsConn is a single graphql-ws client connection. Messages to the client are
+// queued; a client that doesn't keep up with its subscriptions is dropped.
+type wsConn struct {
+	schema *graphql.Schema
+	conn   *websocket.Conn
+	send   chan *wsMessage
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	subs map[string]context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func newWSConn(schema *graphql.Schema, conn *websocket.Conn) *wsConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &wsConn{
+		schema: schema,
+		conn:   conn,
+		send:   make(chan *wsMessage, wsSendQueue),
+		ctx:    ctx,
+		cancel: cancel,
+		subs:   make(map[string]context.CancelFunc),
+	}
+}
+
+// run serves the connection until either side closes it.
+func (c *wsConn) run() {
+	go c.writeLoop()
+	c.readLoop()
+
+	c.cancel()
+	c.wg.Wait()
+	c.conn.Close()
+}
+
+// readLoop dispatches client messages until the connection fails or the client
+// terminates it.
+func (c *wsConn) readLoop() {
+	c.conn.SetReadLimit(wsReadLimit)
+	for {
+		var msg wsMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			log.Debug("GraphQL WebSocket read failed", "err", err)
+			return
+		}
+		switch msg.Type {
+		case gqlConnectionInit:
+			c.queue(&wsMessage{Type: gqlConnectionAck})
+			c.queue(&wsMessage{Type: gqlConnectionKeepAlive})
+		case gqlStart:
+			c.start(msg.ID, msg.Payload)
+		case gqlStop:
+			c.stop(msg.ID)
+		case gqlConnectionTerminate:
+			return
+		default:
+			c.queue(&wsMessage{Type: gqlConnectionError, Payload: errorPayload("unknown message type " + msg.Type)})
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// writeLoop sends the queued messages and keep-alives to the client.
+func (c *wsConn) writeLoop() {
+	keepAlive := time.NewTicker(wsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var msg *wsMessage
+		select {
+		case msg = <-c.send:
+		case <-keepAlive.C:
+			msg = &wsMessage{Type: gqlConnectionKeepAlive}
+		case <-c.ctx.Done():
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteTimeout))
+			c.conn.Close()
+			return
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := c.conn.WriteJSON(msg); err != nil {
+			log.Debug("GraphQL WebSocket write failed", "err", err)
+			c.cancel()
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// queue schedules a message for sending. If the client fell too far behind,
+// the connection is closed instead of buffering without bound.
+func (c *wsConn) queue(msg *wsMessage) {
+	select {
+	case c.send <- msg:
+	case <-c.ctx.Done():
+	default:
+		log.Debug("Dropping slow GraphQL WebSocket client", "queued", len(c.send))
+		c.cancel()
+	}
+}
+
+// start runs an operation and streams its results under the given id.
+func (c *wsConn) start(id string, payload json.RawMessage) {
+	var params wsStartPayload
+	if err := json.Unmarshal(payload, &params); err != nil {
+		c.queue(&wsMessage{ID: id, Type: gqlError, Payload: errorPayload(err.Error())})
+		return
+	}
+	c.mu.Lock()
+	if _, ok := c.subs[id]; ok {
+		c.mu.Unlock()
+		c.queue(&wsMessage{ID: id, Type: gqlError, Payload: errorPayload("duplicate operation id")})
+		return
+	}
+	if len(c.subs) >= wsMaxSubscriptions {
+		c.mu.Unlock()
+		c.queue(&wsMessage{ID: id, Type: gqlError, Payload: errorPayload("too many active operations")})
+		return
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.subs[id] = cancel
+	c.mu.Unlock()
+
+	results, err := c.schema.Subscribe(ctx, params.Query, params.OperationName, params.Variables)
+	if err != nil {
+		c.stop(id)
+		c.queue(&wsMessage{ID: id, Type: gqlError, Payload: errorPayload(err.Error())})
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		// Results have to be drained until the channel is closed, otherwise
+		// the executor leaks.
+		for result := range results {
+			if ctx.Err() != nil {
+				continue
+			}
+			data, err := json.Marshal(result)
+			if err != nil {
+				c.queue(&wsMessage{ID: id, Type: gqlError, Payload: errorPayload(err.Error())})
+				c.stop(id)
+				continue
+			}
+			c.queue(&wsMessage{ID: id, Type: gqlData, Payload: data})
+		}
+		if c.stop(id) {
+			c.queue(&wsMessage{ID: id, Type: gqlComplete})
+		}
+	}()
+}
+
+// stop cancels an operation, returning whether it was still active.
+func (c *wsConn) stop(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cancel, ok := c.subs[id]
+	if ok {
+		cancel()
+		delete(c.subs, id)
+	}
+	return ok
+}
+
+// errorPayload encodes an error message as a graphql-ws error payload.
+func errorPayload(message string) json.RawMessage {
+	payload, _ := json.Marshal(map[string]string{"message": message})
+	return payload
+}
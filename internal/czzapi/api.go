@@ -656,7 +656,12 @@ func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Addre
 	if state == nil || err != nil {
 		return nil, err
 	}
+	return proveAccount(state, address, storageKeys)
+}
 
+// proveAccount creates the Merkle-proof of an account and some of its storage
+// keys against the state root.
+func proveAccount(state *state.StateDB, address common.Address, storageKeys []string) (*AccountResult, error) {
 	storageTrie := state.StorageTrie(address)
 	storageHash := types.EmptyRootHash
 	codeHash := state.GetCodeHash(address)
@@ -757,6 +762,31 @@ func (s *PublicBlockChainAPI) GetBlockByHash(ctx context.Context, hash common.Ha
 	return nil, err
 }
 
+// GetBlockReceipts returns the receipts of all the transactions in the given
+// block, or nil if the block is not found.
+func (s *PublicBlockChainAPI) GetBlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]map[string]interface{}, error) {
+	block, err := s.b.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	receipts, err := s.b.GetReceipts(ctx, block.Hash())
+	if err != nil {
+		return nil, err
+	}
+	txs := block.Transactions()
+	if len(txs) != len(receipts) {
+		return nil, fmt.Errorf("receipts length mismatch: %d vs %d", len(txs), len(receipts))
+	}
+	var (
+		signer = types.MakeSigner(s.b.ChainConfig(), block.Number())
+		result = make([]map[string]interface{}, len(receipts))
+	)
+	for i, receipt := range receipts {
+		result[i] = marshalReceipt(receipt, block.Hash(), block.NumberU64(), signer, txs[i], uint64(i), block.BaseFee())
+	}
+	return result, nil
+}
+
 // GetUncleByBlockNumberAndIndex returns the uncle block for the given block hash and index. When fullTx is true
 // all transactions in the block are returned in full detail, otherwise only the transaction hash is returned.
 //func (s *PublicBlockChainAPI) GetUncleByBlockNumberAndIndex(ctx context.Context, blockNr rpc.BlockNumber, index hexutil.Uint) (map[string]interface{}, error) {
@@ -881,6 +911,70 @@ func (diff *StateOverride) Apply(state *state.StateDB) error {
 	return nil
 }
 
+// BlockOverrides is a set of header fields to override during the execution
+// of a message call.
+type BlockOverrides struct {
+	Number     *hexutil.Big    `json:"number"`
+	Difficulty *hexutil.Big    `json:"difficulty"`
+	Time       *hexutil.Big    `json:"time"`
+	GasLimit   *hexutil.Uint64 `json:"gasLimit"`
+	Coinbase   *common.Address `json:"coinbase"`
+	BaseFee    *hexutil.Big    `json:"baseFee"`
+}
+
+// Apply overrides the given header fields into the given block context.
+func (diff *BlockOverrides) Apply(blockCtx *vm.BlockContext) {
+	if diff == nil {
+		return
+	}
+	if diff.Number != nil {
+		blockCtx.BlockNumber = diff.Number.ToInt()
+	}
+	if diff.Difficulty != nil {
+		blockCtx.Difficulty = diff.Difficulty.ToInt()
+	}
+	if diff.Time != nil {
+		blockCtx.Time = diff.Time.ToInt()
+	}
+	if diff.GasLimit != nil {
+		blockCtx.GasLimit = uint64(*diff.GasLimit)
+	}
+	if diff.Coinbase != nil {
+		blockCtx.Coinbase = *diff.Coinbase
+	}
+	if diff.BaseFee != nil {
+		blockCtx.BaseFee = diff.BaseFee.ToInt()
+	}
+}
+
+// MakeHeader returns a copy of the given header with the overridden fields
+// applied.
+func (diff *BlockOverrides) MakeHeader(header *types.Header) *types.Header {
+	if diff == nil {
+		return header
+	}
+	h := types.CopyHeader(header)
+	if diff.Number != nil {
+		h.Number = diff.Number.ToInt()
+	}
+	if diff.Difficulty != nil {
+		h.Difficulty = diff.Difficulty.ToInt()
+	}
+	if diff.Time != nil {
+		h.Time = diff.Time.ToInt().Uint64()
+	}
+	if diff.GasLimit != nil {
+		h.GasLimit = uint64(*diff.GasLimit)
+	}
+	if diff.Coinbase != nil {
+		h.Coinbase = *diff.Coinbase
+	}
+	if diff.BaseFee != nil {
+		h.BaseFee = diff.BaseFee.ToInt()
+	}
+	return h
+}
+
 func DoCall(ctx context.Context, b Backend, args TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *StateOverride, timeout time.Duration, globalGasCap uint64) (*core.ExecutionResult, error) {
 	defer func(start time.Time) { log.Debug("Executing EVM call finished", "runtime", time.Since(start)) }(time.Now())
 
@@ -1616,17 +1710,23 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, ha
 	if len(receipts) <= int(index) {
 		return nil, nil
 	}
-	receipt := receipts[index]
+	header, err := s.b.HeaderByHash(ctx, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	signer := types.MakeSigner(s.b.ChainConfig(), new(big.Int).SetUint64(blockNumber))
+	return marshalReceipt(receipts[index], blockHash, blockNumber, signer, tx, index, header.BaseFee), nil
+}
 
+// marshalReceipt marshals a transaction receipt into a JSON object.
+func marshalReceipt(receipt *types.Receipt, blockHash common.Hash, blockNumber uint64, signer types.Signer, tx *types.Transaction, index uint64, baseFee *big.Int) map[string]interface{} {
 	// Derive the sender.
-	bigblock := new(big.Int).SetUint64(blockNumber)
-	signer := types.MakeSigner(s.b.ChainConfig(), bigblock)
 	from, _ := types.Sender(signer, tx)
 
 	fields := map[string]interface{}{
 		"blockHash":         blockHash,
 		"blockNumber":       hexutil.Uint64(blockNumber),
-		"transactionHash":   hash,
+		"transactionHash":   tx.Hash(),
 		"transactionIndex":  hexutil.Uint64(index),
 		"from":              from,
 		"to":                tx.To(),
@@ -1638,16 +1738,12 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, ha
 		"type":              hexutil.Uint(tx.Type()),
 	}
 	// Assign the effective gas price paid
-	//if !s.b.ChainConfig().IsLondon(bigblock) {
-	//	fields["effectiveGasPrice"] = hexutil.Uint64(tx.GasPrice().Uint64())
-	//} else {
-	header, err := s.b.HeaderByHash(ctx, blockHash)
-	if err != nil {
-		return nil, err
+	if baseFee == nil {
+		fields["effectiveGasPrice"] = hexutil.Uint64(tx.GasPrice().Uint64())
+	} else {
+		gasPrice := new(big.Int).Add(baseFee, tx.EffectiveGasTipValue(baseFee))
+		fields["effectiveGasPrice"] = hexutil.Uint64(gasPrice.Uint64())
 	}
-	gasPrice := new(big.Int).Add(header.BaseFee, tx.EffectiveGasTipValue(header.BaseFee))
-	fields["effectiveGasPrice"] = hexutil.Uint64(gasPrice.Uint64())
-	//}
 	// Assign receipt status or post state.
 	if len(receipt.PostState) > 0 {
 		fields["root"] = hexutil.Bytes(receipt.PostState)
@@ -1661,7 +1757,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, ha
 	if receipt.ContractAddress != (common.Address{}) {
 		fields["contractAddress"] = receipt.ContractAddress
 	}
-	return fields, nil
+	return fields
 }
 
 // sign is a helper function that signs a transaction with the private key of the given address.
@@ -2081,29 +2177,77 @@ type PublicTeWaKaAPI struct {
 	b Backend
 }
 
+// errStateNotAvailable is returned if the state of the requested block is missing.
+var errStateNotAvailable = errors.New("state not available")
+
 // NewPublicTeWaKaAPI
 func NewPublicTeWaKaAPI(b Backend) *PublicTeWaKaAPI {
 	return &PublicTeWaKaAPI{b}
 }
 
-func (api *PublicTeWaKaAPI) GetPledgeInfo(ctx context.Context) ([]*types.Pledge, error) {
-
-	tewaka := vm.NewTeWakaImpl()
-	stateDb, _, err := api.b.StateAndHeaderByNumber(ctx, -1)
+// stateAt returns the state at the given block. A nil block selector falls
+// back to the latest state, which keeps the old parameterless calls working.
+func (api *PublicTeWaKaAPI) stateAt(ctx context.Context, blockNrOrHash *rpc.BlockNumberOrHash) (*state.StateDB, error) {
+	selector := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	if blockNrOrHash != nil {
+		selector = *blockNrOrHash
+	}
+	stateDb, _, err := api.b.StateAndHeaderByNumberOrHash(ctx, selector)
 	if err != nil {
 		log.Error("Staking load error", "error", err)
 		return nil, err
 	}
+	if stateDb == nil {
+		return nil, errStateNotAvailable
+	}
+	return stateDb, nil
+}
 
-	err = tewaka.Load(stateDb, vm.TeWaKaAddress)
+// loadTeWaka loads the TeWaka state at the given block.
+func (api *PublicTeWaKaAPI) loadTeWaka(ctx context.Context, blockNrOrHash *rpc.BlockNumberOrHash) (*vm.TeWakaImpl, error) {
+	stateDb, err := api.stateAt(ctx, blockNrOrHash)
 	if err != nil {
+		return nil, err
+	}
+	tewaka := vm.NewTeWakaImpl()
+	if err := tewaka.Load(stateDb, vm.TeWaKaAddress); err != nil {
 		log.Error("Staking load error", "error", err)
 		return nil, err
 	}
+	return tewaka, nil
+}
 
+func (api *PublicTeWaKaAPI) GetPledgeInfo(ctx context.Context, blockNrOrHash *rpc.BlockNumberOrHash) ([]*types.Pledge, error) {
+	tewaka, err := api.loadTeWaka(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
 	return tewaka.PledgeInfos, nil
 }
 
+// RPCStakingFactor is the staking weight a coinbase mines with.
+type RPCStakingFactor struct {
+	Coinbase      common.Address `json:"coinbase"`
+	StakingAmount *hexutil.Big   `json:"staking_amount"`
+	Factor        *hexutil.Big   `json:"factor"`
+}
+
+// GetStakingFactor returns the total amount staked towards a coinbase and the
+// mining factor the consensus engine derives from it. A nil factor means the
+// coinbase is below the staking threshold and mines without a bonus.
+func (api *PublicTeWaKaAPI) GetStakingFactor(ctx context.Context, coinbase common.Address, blockNrOrHash *rpc.BlockNumberOrHash) (*RPCStakingFactor, error) {
+	tewaka, err := api.loadTeWaka(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	amount := tewaka.GetStakingByUser(coinbase)
+	return &RPCStakingFactor{
+		Coinbase:      coinbase,
+		StakingAmount: (*hexutil.Big)(amount),
+		Factor:        (*hexutil.Big)(consensus.MakeFactorForMine(amount)),
+	}, nil
+}
+
 // RPCTransaction represents a transaction that will serialize to the RPC representation of a transaction
 type RPCConvertItem struct {
 	ID          *hexutil.Big     `json:"id"`
@@ -2140,25 +2284,242 @@ func NewRPCConvertItems(item *types.ConvertItem) *RPCConvertItem {
 	return it
 }
 
-func (api *PublicTeWaKaAPI) GetConvertItems(ctx context.Context) ([]*RPCConvertItem, error) {
+func (api *PublicTeWaKaAPI) GetConvertItems(ctx context.Context, blockNrOrHash *rpc.BlockNumberOrHash) ([]*RPCConvertItem, error) {
+	tewaka, err := api.loadTeWaka(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
 
-	tewaka := vm.NewTeWakaImpl()
-	stateDb, _, err := api.b.StateAndHeaderByNumber(ctx, -1)
+	ritem := make([]*RPCConvertItem, 0, 0)
+	for _, v := range tewaka.ConvertItems {
+		ritem = append(ritem, NewRPCConvertItems(v))
+	}
+
+	return ritem, nil
+}
+
+// RPCNetworkBreaker is the circuit breaker state of a TeWaka network.
+type RPCNetworkBreaker struct {
+	ConvertType  hexutil.Uint   `json:"convert_type"`
+	Paused       bool           `json:"paused"`
+	NetworkLimit *hexutil.Big   `json:"network_limit"`
+	NetworkUsed  *hexutil.Big   `json:"network_used"`
+	BlockLimit   *hexutil.Big   `json:"block_limit"`
+	BlockNumber  hexutil.Uint64 `json:"block_number"`
+	BlockUsed    *hexutil.Big   `json:"block_used"`
+}
+
+// RPCGuardianProposal is a guardian action waiting for more approvals, which
+// lapse after block Expiry.
+type RPCGuardianProposal struct {
+	Hash      common.Hash      `json:"hash"`
+	Approvals []common.Address `json:"approvals"`
+	Expiry    hexutil.Uint64   `json:"expiry"`
+}
+
+// RPCCircuitBreaker is the TeWaka circuit breaker state together with the
+// guardian set allowed to change it.
+type RPCCircuitBreaker struct {
+	Guardians []common.Address       `json:"guardians"`
+	Threshold hexutil.Uint64         `json:"threshold"`
+	Networks  []*RPCNetworkBreaker   `json:"networks"`
+	Proposals []*RPCGuardianProposal `json:"proposals"`
+}
+
+// GetCircuitBreaker returns the pause state and volume limits of every TeWaka
+// network guardians acted on, along with the pending guardian proposals.
+func (api *PublicTeWaKaAPI) GetCircuitBreaker(ctx context.Context, blockNrOrHash *rpc.BlockNumberOrHash) (*RPCCircuitBreaker, error) {
+	stateDb, err := api.stateAt(ctx, blockNrOrHash)
 	if err != nil {
-		log.Error("Staking load error", "error", err)
 		return nil, err
 	}
+	breaker := new(vm.CircuitBreaker)
+	if err := breaker.Load(stateDb, vm.TeWaKaAddress); err != nil {
+		return nil, err
+	}
+
+	result := &RPCCircuitBreaker{
+		Networks:  make([]*RPCNetworkBreaker, 0, len(breaker.Networks)),
+		Proposals: make([]*RPCGuardianProposal, 0, len(breaker.Proposals)),
+	}
+	if config := api.b.ChainConfig().TeWakaGuardians; config != nil {
+		result.Guardians, result.Threshold = config.Guardians, hexutil.Uint64(config.Threshold)
+	}
+	for _, v := range breaker.Networks {
+		result.Networks = append(result.Networks, &RPCNetworkBreaker{
+			ConvertType:  hexutil.Uint(v.ConvertType),
+			Paused:       v.Paused,
+			NetworkLimit: (*hexutil.Big)(v.NetworkLimit),
+			NetworkUsed:  (*hexutil.Big)(v.NetworkUsed),
+			BlockLimit:   (*hexutil.Big)(v.BlockLimit),
+			BlockNumber:  hexutil.Uint64(v.BlockNumber),
+			BlockUsed:    (*hexutil.Big)(v.BlockUsed),
+		})
+	}
+	for _, v := range breaker.Proposals {
+		result.Proposals = append(result.Proposals, &RPCGuardianProposal{Hash: v.Hash, Approvals: v.Approvals, Expiry: hexutil.Uint64(v.Expiry)})
+	}
+	return result, nil
+}
+
+// RPCParamChange is a scheduled TeWaka parameter change.
+type RPCParamChange struct {
+	Value   *hexutil.Big   `json:"value"`
+	EnactAt hexutil.Uint64 `json:"enact_at"`
+}
+
+// RPCParam is the current and scheduled value of a TeWaka parameter.
+type RPCParam struct {
+	Key       hexutil.Uint64  `json:"key"`
+	Name      string          `json:"name"`
+	Value     *hexutil.Big    `json:"value"`
+	Default   bool            `json:"default"`
+	Scheduled *RPCParamChange `json:"scheduled"`
+}
 
-	err = tewaka.Load(stateDb, vm.TeWaKaAddress)
+// RPCParams is the TeWaka parameter store together with the governor set
+// allowed to change it.
+type RPCParams struct {
+	Governors []common.Address       `json:"governors"`
+	Threshold hexutil.Uint64         `json:"threshold"`
+	Delay     hexutil.Uint64         `json:"delay"`
+	Params    []*RPCParam            `json:"params"`
+	Proposals []*RPCGuardianProposal `json:"proposals"`
+}
+
+// GetParams returns the value of every governed TeWaka parameter, the changes
+// scheduled by the governors and the proposals still waiting for approvals.
+func (api *PublicTeWaKaAPI) GetParams(ctx context.Context, blockNrOrHash *rpc.BlockNumberOrHash) (*RPCParams, error) {
+	stateDb, err := api.stateAt(ctx, blockNrOrHash)
 	if err != nil {
-		log.Error("Staking load error", "error", err)
+		return nil, err
+	}
+	store := new(vm.ParamStore)
+	if err := store.Load(stateDb, vm.TeWaKaAddress); err != nil {
 		return nil, err
 	}
 
-	ritem := make([]*RPCConvertItem, 0, 0)
-	for _, v := range tewaka.ConvertItems {
-		ritem = append(ritem, NewRPCConvertItems(v))
+	result := &RPCParams{
+		Params:    make([]*RPCParam, 0, len(vm.ParamKeys)),
+		Proposals: make([]*RPCGuardianProposal, 0, len(store.Proposals)),
+	}
+	if config := api.b.ChainConfig().TeWakaGovernors; config != nil {
+		result.Governors, result.Threshold, result.Delay = config.Governors, hexutil.Uint64(config.Threshold), hexutil.Uint64(config.Delay)
 	}
+	for _, key := range vm.ParamKeys {
+		value := store.Get(key)
+		param := &RPCParam{
+			Key:     hexutil.Uint64(key),
+			Name:    key.String(),
+			Value:   (*hexutil.Big)(value),
+			Default: value.Cmp(key.Default()) == 0,
+		}
+		if change := store.Change(key); change != nil {
+			param.Scheduled = &RPCParamChange{Value: (*hexutil.Big)(change.Value), EnactAt: hexutil.Uint64(change.EnactAt)}
+		}
+		result.Params = append(result.Params, param)
+	}
+	for _, v := range store.Proposals {
+		result.Proposals = append(result.Proposals, &RPCGuardianProposal{Hash: v.Hash, Approvals: v.Approvals, Expiry: hexutil.Uint64(v.Expiry)})
+	}
+	return result, nil
+}
 
-	return ritem, nil
+// RPCReserve is the reserve report of a single TeWaka network pool, along
+// with the proof of the pool account.
+type RPCReserve struct {
+	ConvertType hexutil.Uint   `json:"convert_type"`
+	Pool        common.Address `json:"pool"`
+	Balance     *hexutil.Big   `json:"balance"`
+	Pending     *hexutil.Big   `json:"pending"`
+	Fees        *hexutil.Big   `json:"fees"`
+	Surplus     *hexutil.Big   `json:"surplus"` // Balance exceeding what the pool owes
+	Deficit     *hexutil.Big   `json:"deficit"` // Amount the pool is short of
+	PoolProof   *AccountResult `json:"pool_proof"`
+}
+
+// RPCTeWakaStateProof proves the raw TeWaka state the convert items were
+// decoded from.
+type RPCTeWakaStateProof struct {
+	Account *AccountResult `json:"account"`
+	Key     common.Hash    `json:"key"`
+	Value   hexutil.Bytes  `json:"value"`
+	Proof   []string       `json:"proof"`
+}
+
+// RPCReserves is the proof-of-reserves report of a block.
+type RPCReserves struct {
+	BlockNumber hexutil.Uint64       `json:"block_number"`
+	BlockHash   common.Hash          `json:"block_hash"`
+	StateRoot   common.Hash          `json:"state_root"`
+	TeWaka      *RPCTeWakaStateProof `json:"tewaka"`
+	Reserves    []*RPCReserve        `json:"reserves"`
+}
+
+// TeWakaReserves reports, for every TeWaka network, whether the pool balance
+// covers the outstanding convert items. Every number is backed by a Merkle
+// proof against the state root of the header, so the report can be verified
+// without trusting the node.
+func TeWakaReserves(statedb *state.StateDB, header *types.Header) (*RPCReserves, error) {
+	tewaka := vm.NewTeWakaImpl()
+	if err := tewaka.Load(statedb, vm.TeWaKaAddress); err != nil {
+		return nil, err
+	}
+	// The TeWaka state lives under the key TeWakaImpl.Save derives from the
+	// precompile address.
+	key := common.BytesToHash(vm.TeWaKaAddress[:])
+	account, err := proveAccount(statedb, vm.TeWaKaAddress, nil)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := statedb.GetStorageProof(vm.TeWaKaAddress, key)
+	if err != nil {
+		return nil, err
+	}
+	result := &RPCReserves{
+		BlockNumber: hexutil.Uint64(header.Number.Uint64()),
+		BlockHash:   header.Hash(),
+		StateRoot:   header.Root,
+		TeWaka: &RPCTeWakaStateProof{
+			Account: account,
+			Key:     key,
+			Value:   statedb.GetTeWakaState(vm.TeWaKaAddress, key),
+			Proof:   toHexSlice(proof),
+		},
+	}
+	for _, v := range tewaka.Reserves(statedb) {
+		poolProof, err := proveAccount(statedb, v.Pool, nil)
+		if err != nil {
+			return nil, err
+		}
+		surplus, deficit := new(big.Int), new(big.Int)
+		if v.Surplus.Sign() >= 0 {
+			surplus.Set(v.Surplus)
+		} else {
+			deficit.Neg(v.Surplus)
+		}
+		result.Reserves = append(result.Reserves, &RPCReserve{
+			ConvertType: hexutil.Uint(v.ConvertType),
+			Pool:        v.Pool,
+			Balance:     (*hexutil.Big)(v.Balance),
+			Pending:     (*hexutil.Big)(v.Pending),
+			Fees:        (*hexutil.Big)(v.Fees),
+			Surplus:     (*hexutil.Big)(surplus),
+			Deficit:     (*hexutil.Big)(deficit),
+			PoolProof:   poolProof,
+		})
+	}
+	return result, statedb.Error()
+}
+
+// GetReserves returns the proof-of-reserves report of the TeWaka pools.
+func (api *PublicTeWaKaAPI) GetReserves(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*RPCReserves, error) {
+	stateDb, header, err := api.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if stateDb == nil {
+		return nil, errStateNotAvailable
+	}
+	return TeWakaReserves(stateDb, header)
 }
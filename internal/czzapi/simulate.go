@@ -0,0 +1,388 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package czzapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/consensus"
+	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/core/vm"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/rpc"
+)
+
+const (
+	// maxSimulateBlocks is the maximum number of blocks a single simulation may
+	// span, including the empty blocks filling gaps between block numbers.
+	maxSimulateBlocks = 256
+
+	// simulateTimestampIncrement is the default time between simulated blocks.
+	simulateTimestampIncrement = 12
+
+	// simulateTimeout is the time allowed for running a whole simulation.
+	simulateTimeout = 5 * time.Second
+)
+
+var (
+	// transferAddress is the pseudo contract emitting the synthetic logs of
+	// czz transfers.
+	transferAddress = common.HexToAddress("0xEeeeeEeeeEeEeeEeEeEeeEEEeeeeEeeeeeeeEEeE")
+
+	// transferTopic is the signature of the ERC20 Transfer(address,address,uint256)
+	// event, which the synthetic transfer logs mimic.
+	transferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+)
+
+// SimBlock is a block to simulate, made of the calls to execute in order after
+// the overrides are applied.
+type SimBlock struct {
+	BlockOverrides *BlockOverrides   `json:"blockOverrides"`
+	StateOverrides *StateOverride    `json:"stateOverrides"`
+	Calls          []TransactionArgs `json:"calls"`
+}
+
+// SimOpts are the inputs of a simulation.
+type SimOpts struct {
+	BlockStateCalls []SimBlock `json:"blockStateCalls"`
+	TraceTransfers  bool       `json:"traceTransfers"`
+}
+
+// SimCallError is the failure of a simulated call, carrying the same error
+// codes as eth_call does.
+type SimCallError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+	Data    string `json:"data,omitempty"`
+}
+
+// SimCallResult is the outcome of a simulated call.
+type SimCallResult struct {
+	ReturnValue hexutil.Bytes  `json:"returnData"`
+	Logs        []*types.Log   `json:"logs"`
+	GasUsed     hexutil.Uint64 `json:"gasUsed"`
+	Status      hexutil.Uint64 `json:"status"`
+	Error       *SimCallError  `json:"error,omitempty"`
+}
+
+// SimBlockResult is a simulated block along with the outcome of its calls.
+type SimBlockResult struct {
+	Number     hexutil.Uint64  `json:"number"`
+	Hash       common.Hash     `json:"hash"`
+	ParentHash common.Hash     `json:"parentHash"`
+	StateRoot  common.Hash     `json:"stateRoot"`
+	Timestamp  hexutil.Uint64  `json:"timestamp"`
+	GasLimit   hexutil.Uint64  `json:"gasLimit"`
+	GasUsed    hexutil.Uint64  `json:"gasUsed"`
+	Miner      common.Address  `json:"miner"`
+	BaseFee    *hexutil.Big    `json:"baseFeePerGas,omitempty"`
+	Calls      []SimCallResult `json:"calls"`
+}
+
+// SimulateV1 executes a sequence of blocks of calls on top of the given block,
+// each block building on the state left behind by the previous ones. Nothing
+// is written to the chain.
+//
+// The results contain the return data, logs and gas of every call. When
+// transfer tracing is enabled, every czz transfer is also reported as an
+// ERC20 style Transfer log emitted by 0xEeeeeEeeeEeEeeEeEeEeeEEEeeeeEeeeeeeeEEeE.
+func (s *PublicBlockChainAPI) SimulateV1(ctx context.Context, opts SimOpts, blockNrOrHash *rpc.BlockNumberOrHash) ([]*SimBlockResult, error) {
+	if len(opts.BlockStateCalls) == 0 {
+		return nil, errors.New("empty input")
+	}
+	if len(opts.BlockStateCalls) > maxSimulateBlocks {
+		return nil, fmt.Errorf("too many blocks: %d > %d", len(opts.BlockStateCalls), maxSimulateBlocks)
+	}
+	bNrOrHash := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	if blockNrOrHash != nil {
+		bNrOrHash = *blockNrOrHash
+	}
+	defer func(start time.Time) { log.Debug("Executing simulation finished", "runtime", time.Since(start)) }(time.Now())
+
+	state, base, err := s.b.StateAndHeaderByNumberOrHash(ctx, bNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, errStateNotAvailable
+	}
+	ctx, cancel := context.WithTimeout(ctx, simulateTimeout)
+	defer cancel()
+
+	sim := &simulator{
+		b:              s.b,
+		state:          state,
+		traceTransfers: opts.TraceTransfers,
+		gasCap:         s.b.RPCGasCap(),
+		chain: &simChainContext{
+			ctx:     ctx,
+			b:       s.b,
+			headers: make(map[common.Hash]*types.Header),
+		},
+	}
+	headers, err := makeSimHeaders(base, opts.BlockStateCalls)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		results = make([]*SimBlockResult, 0, len(headers))
+		parent  = base
+	)
+	for i, block := range opts.BlockStateCalls {
+		// Fill any gap before the block with empty blocks
+		for new(big.Int).Sub(headers[i].Number, parent.Number).Cmp(common.Big1) > 0 {
+			header := nextSimHeader(parent)
+			result, err := sim.processBlock(ctx, header, parent, nil)
+			if err != nil {
+				return nil, err
+			}
+			results, parent = append(results, result), header
+		}
+		if err := block.StateOverrides.Apply(state); err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		result, err := sim.processBlock(ctx, headers[i], parent, block.Calls)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		results, parent = append(results, result), headers[i]
+	}
+	return results, nil
+}
+
+// simulator executes the blocks of a simulation on a shared state.
+type simulator struct {
+	b              Backend
+	state          *state.StateDB
+	chain          *simChainContext
+	traceTransfers bool
+	gasCap         uint64 // Gas left to all the calls of the simulation, 0 if unlimited
+}
+
+// makeSimHeaders assembles the headers of the requested simulated blocks,
+// without their execution results.
+func makeSimHeaders(base *types.Header, blocks []SimBlock) ([]*types.Header, error) {
+	var (
+		headers = make([]*types.Header, 0, len(blocks))
+		prev    = base
+	)
+	for i, block := range blocks {
+		header := nextSimHeader(prev)
+		if block.BlockOverrides != nil && block.BlockOverrides.Number != nil {
+			number := block.BlockOverrides.Number.ToInt()
+			if number.Cmp(prev.Number) <= 0 {
+				return nil, fmt.Errorf("block %d: block numbers must be in order: %d <= %d", i, number, prev.Number)
+			}
+			// Keep the default time of the block consistent with the skipped ones
+			skipped := new(big.Int).Sub(number, prev.Number).Uint64() - 1
+			header.Number = new(big.Int).Set(number)
+			header.Time += skipped * simulateTimestampIncrement
+		}
+		if gap := new(big.Int).Sub(header.Number, base.Number); gap.Cmp(big.NewInt(maxSimulateBlocks)) > 0 {
+			return nil, fmt.Errorf("block %d: too many blocks: %d > %d", i, gap, maxSimulateBlocks)
+		}
+		header = block.BlockOverrides.MakeHeader(header)
+
+		// The empty blocks filling the gap must fit between the requested ones
+		minTime := prev.Time + (header.Number.Uint64()-prev.Number.Uint64()-1)*simulateTimestampIncrement
+		if header.Time <= minTime {
+			return nil, fmt.Errorf("block %d: block timestamps must be in order: %d <= %d", i, header.Time, minTime)
+		}
+		headers = append(headers, header)
+		prev = header
+	}
+	return headers, nil
+}
+
+// nextSimHeader returns the default header of the simulated block following
+// the given one.
+func nextSimHeader(parent *types.Header) *types.Header {
+	header := &types.Header{
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		Time:       parent.Time + simulateTimestampIncrement,
+		GasLimit:   parent.GasLimit,
+		Coinbase:   parent.Coinbase,
+		Difficulty: new(big.Int).Set(parent.Difficulty),
+	}
+	if parent.BaseFee != nil {
+		header.BaseFee = new(big.Int).Set(parent.BaseFee)
+	}
+	return header
+}
+
+// processBlock executes the calls of a simulated block on top of its parent,
+// finalizing the header of the block.
+func (sim *simulator) processBlock(ctx context.Context, header, parent *types.Header, calls []TransactionArgs) (*SimBlockResult, error) {
+	header.ParentHash = parent.Hash()
+
+	blockCtx := core.NewEVMBlockContext(header, sim.chain, &header.Coinbase)
+	if sim.traceTransfers {
+		blockCtx.Transfer = transferWithLog(header.Number.Uint64())
+	}
+	var (
+		gp      = new(core.GasPool).AddGas(header.GasLimit)
+		results = make([]SimCallResult, 0, len(calls))
+		logs    []*types.Log
+	)
+	for i, call := range calls {
+		// Default the gas of the call to all the gas left, up to the cap
+		if call.Gas == nil {
+			gas := hexutil.Uint64(gp.Gas())
+			if sim.gasCap != 0 && sim.gasCap < gp.Gas() {
+				gas = hexutil.Uint64(sim.gasCap)
+			}
+			call.Gas = &gas
+		}
+		if sim.gasCap != 0 && uint64(*call.Gas) > sim.gasCap {
+			return nil, fmt.Errorf("call %d: gas cap exceeded: %d > %d", i, uint64(*call.Gas), sim.gasCap)
+		}
+		msg, err := call.ToMessage(0, header.BaseFee)
+		if err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+		// Transactions are never assembled, identify the call by a stand-in
+		tx := types.NewTx(&types.LegacyTx{
+			Nonce:    sim.state.GetNonce(msg.From()),
+			To:       msg.To(),
+			Gas:      msg.Gas(),
+			GasPrice: msg.GasPrice(),
+			Value:    msg.Value(),
+			Data:     msg.Data(),
+		})
+		sim.state.Prepare(tx.Hash(), i)
+
+		evm := vm.NewEVM(blockCtx, core.NewEVMTxContext(msg), sim.state, sim.b.ChainConfig(), vm.Config{NoBaseFee: true})
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				evm.Cancel()
+			case <-done:
+			}
+		}()
+		result, err := core.ApplyMessage(evm, msg, gp)
+		close(done)
+
+		if evm.Cancelled() {
+			return nil, fmt.Errorf("execution aborted (timeout = %v)", simulateTimeout)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("call %d: %w (supplied gas %d)", i, err, msg.Gas())
+		}
+		sim.state.Finalise(true)
+		if sim.gasCap != 0 {
+			sim.gasCap -= result.UsedGas
+		}
+		res := SimCallResult{
+			ReturnValue: result.Return(),
+			Logs:        sim.state.GetLogs(tx.Hash(), common.Hash{}),
+			GasUsed:     hexutil.Uint64(result.UsedGas),
+			Status:      hexutil.Uint64(types.ReceiptStatusSuccessful),
+		}
+		if res.Logs == nil {
+			res.Logs = []*types.Log{}
+		}
+		if result.Failed() {
+			res.Status = hexutil.Uint64(types.ReceiptStatusFailed)
+			if len(result.Revert()) > 0 {
+				revert := newRevertError(result)
+				res.Error = &SimCallError{Message: revert.Error(), Code: revert.ErrorCode(), Data: revert.reason}
+			} else {
+				res.Error = &SimCallError{Message: result.Err.Error(), Code: errCodeVMError}
+			}
+		}
+		results = append(results, res)
+		logs = append(logs, res.Logs...)
+	}
+	header.GasUsed = header.GasLimit - gp.Gas()
+	header.Root = sim.state.IntermediateRoot(true)
+
+	// The block hash is only known now, fix up the logs of the block
+	hash := header.Hash()
+	for i, l := range logs {
+		l.BlockHash, l.Index = hash, uint(i)
+	}
+	sim.chain.headers[hash] = header
+
+	result := &SimBlockResult{
+		Number:     hexutil.Uint64(header.Number.Uint64()),
+		Hash:       hash,
+		ParentHash: header.ParentHash,
+		StateRoot:  header.Root,
+		Timestamp:  hexutil.Uint64(header.Time),
+		GasLimit:   hexutil.Uint64(header.GasLimit),
+		GasUsed:    hexutil.Uint64(header.GasUsed),
+		Miner:      header.Coinbase,
+		Calls:      results,
+	}
+	if header.BaseFee != nil {
+		result.BaseFee = (*hexutil.Big)(header.BaseFee)
+	}
+	return result, nil
+}
+
+// errCodeVMError is the error code of simulated calls failing for reasons
+// other than a revert.
+const errCodeVMError = -32015
+
+// transferWithLog returns a transfer function that also emits a synthetic
+// Transfer log for every czz transfer. The logs are added to the state, so
+// they are discarded along with the transfer if the call frame reverts.
+func transferWithLog(number uint64) vm.TransferFunc {
+	return func(db vm.StateDB, sender, recipient common.Address, amount *big.Int) {
+		core.Transfer(db, sender, recipient, amount)
+		if amount.Sign() == 0 {
+			return
+		}
+		db.AddLog(&types.Log{
+			Address:     transferAddress,
+			Topics:      []common.Hash{transferTopic, common.BytesToHash(sender.Bytes()), common.BytesToHash(recipient.Bytes())},
+			Data:        common.BigToHash(amount).Bytes(),
+			BlockNumber: number,
+		})
+	}
+}
+
+// simChainContext serves the headers of the simulated blocks along with the
+// ones of the chain, so BLOCKHASH resolves the simulated ancestors too.
+type simChainContext struct {
+	ctx     context.Context
+	b       Backend
+	headers map[common.Hash]*types.Header
+}
+
+// Engine retrieves the chain's consensus engine.
+func (c *simChainContext) Engine() consensus.Engine {
+	return c.b.Engine()
+}
+
+// GetHeader returns the simulated or chain header with the given hash.
+func (c *simChainContext) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header, ok := c.headers[hash]; ok {
+		return header
+	}
+	header, _ := c.b.HeaderByHash(c.ctx, hash)
+	return header
+}
@@ -0,0 +1,357 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package czzapi
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/consensus"
+	"github.com/classzz/go-classzz-v2/consensus/ethash"
+	"github.com/classzz/go-classzz-v2/core"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/core/state"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/params"
+	"github.com/classzz/go-classzz-v2/rpc"
+)
+
+var (
+	simSender    = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	simRecipient = common.HexToAddress("0x1000000000000000000000000000000000000002")
+	simOther     = common.HexToAddress("0x1000000000000000000000000000000000000003")
+	simContract  = common.HexToAddress("0x2000000000000000000000000000000000000001")
+	simForwarder = common.HexToAddress("0x2000000000000000000000000000000000000002")
+	simReverter  = common.HexToAddress("0x2000000000000000000000000000000000000003")
+	simInvalid   = common.HexToAddress("0x2000000000000000000000000000000000000004")
+)
+
+// simReturn returns the code of a contract pushing a word with the given code
+// and returning it.
+func simReturn(push ...byte) []byte {
+	return append(push, 0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xf3) // MSTORE(0), RETURN(0, 32)
+}
+
+// simForward returns the code of a contract sending 5 wei to the recipient,
+// ending with the given opcodes.
+func simForward(end ...byte) []byte {
+	code := []byte{0x60, 0x00, 0x60, 0x00, 0x60, 0x00, 0x60, 0x00, 0x60, 0x05, 0x73} // retSize, retOffset, argsSize, argsOffset, value, PUSH20
+	code = append(code, simRecipient.Bytes()...)
+	code = append(code, 0x5a, 0xf1, 0x50) // GAS, CALL, POP
+	return append(code, end...)
+}
+
+// simBackend serves a single state to simulate on, the genesis one.
+type simBackend struct {
+	Backend
+
+	db      czzdb.Database
+	config  *params.ChainConfig
+	genesis *types.Block
+	gasCap  uint64
+	noState bool
+}
+
+func newSimBackend(gasCap uint64) *simBackend {
+	// Plain transfers only cost gas from CIP1 on
+	config := *params.AllEthashProtocolChanges
+	config.CIP_1 = big.NewInt(0)
+	config.CIP_4, config.CIP_5 = big.NewInt(1000000), big.NewInt(1000000)
+
+	db := rawdb.NewMemoryDatabase()
+	genesis := (&core.Genesis{
+		Config:   &config,
+		GasLimit: 10000000,
+		Alloc: core.GenesisAlloc{
+			simSender:    {Balance: big.NewInt(1000000)},
+			simForwarder: {Balance: big.NewInt(100), Code: simForward(0x00)},                   // STOP
+			simReverter:  {Balance: big.NewInt(100), Code: simForward(0x60, 0x00, 0x80, 0xfd)}, // REVERT(0, 0)
+			simInvalid:   {Balance: new(big.Int), Code: []byte{0xfe}},
+		},
+	}).MustCommit(db)
+
+	return &simBackend{db: db, config: &config, genesis: genesis, gasCap: gasCap}
+}
+
+func (b *simBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*state.StateDB, *types.Header, error) {
+	if b.noState {
+		return nil, b.genesis.Header(), nil
+	}
+	statedb, err := state.New(b.genesis.Root(), state.NewDatabase(b.db), nil)
+	return statedb, b.genesis.Header(), err
+}
+
+func (b *simBackend) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	if hash == b.genesis.Hash() {
+		return b.genesis.Header(), nil
+	}
+	return nil, nil
+}
+
+func (b *simBackend) RPCGasCap() uint64                { return b.gasCap }
+func (b *simBackend) ChainConfig() *params.ChainConfig { return b.config }
+func (b *simBackend) Engine() consensus.Engine         { return ethash.NewFaker() }
+
+func simulate(b *simBackend, opts SimOpts) ([]*SimBlockResult, error) {
+	return NewPublicBlockChainAPI(b).SimulateV1(context.Background(), opts, nil)
+}
+
+func simTransfer(from, to common.Address, value int64) TransactionArgs {
+	return TransactionArgs{From: &from, To: &to, Value: (*hexutil.Big)(big.NewInt(value))}
+}
+
+func simCall(to common.Address) TransactionArgs {
+	return TransactionArgs{From: &simSender, To: &to}
+}
+
+// Tests that every simulated block builds on the state and the header of the
+// previous one.
+func TestSimulateChainedBlocks(t *testing.T) {
+	b := newSimBackend(0)
+	code := hexutil.Bytes(simReturn(append(append([]byte{0x73}, simOther.Bytes()...), 0x31)...)) // BALANCE(other)
+	blockhash := hexutil.Bytes(simReturn(0x60, 0x01, 0x43, 0x03, 0x40))                          // BLOCKHASH(NUMBER - 1)
+
+	results, err := simulate(b, SimOpts{BlockStateCalls: []SimBlock{
+		{Calls: []TransactionArgs{simTransfer(simSender, simRecipient, 1000)}},
+		{Calls: []TransactionArgs{simTransfer(simRecipient, simOther, 600)}},
+		{
+			StateOverrides: &StateOverride{simContract: {Code: &code}},
+			Calls:          []TransactionArgs{simCall(simContract)},
+		},
+		{
+			StateOverrides: &StateOverride{simContract: {Code: &blockhash}},
+			Calls:          []TransactionArgs{simCall(simContract)},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("simulation failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("block count mismatch: have %d, want 4", len(results))
+	}
+	parent := b.genesis.Header()
+	for i, result := range results {
+		if uint64(result.Number) != uint64(i+1) {
+			t.Errorf("block %d: number mismatch: have %d", i, result.Number)
+		}
+		if result.ParentHash != parent.Hash() && (i == 0 || result.ParentHash != results[i-1].Hash) {
+			t.Errorf("block %d: parent mismatch: have %x", i, result.ParentHash)
+		}
+		if want := parent.Time + uint64(i+1)*simulateTimestampIncrement; uint64(result.Timestamp) != want {
+			t.Errorf("block %d: time mismatch: have %d, want %d", i, result.Timestamp, want)
+		}
+		for j, call := range result.Calls {
+			if call.Status != hexutil.Uint64(types.ReceiptStatusSuccessful) || call.Error != nil {
+				t.Errorf("block %d, call %d failed: %v", i, j, call.Error)
+			}
+		}
+	}
+	if have := new(big.Int).SetBytes(results[2].Calls[0].ReturnValue); have.Int64() != 600 {
+		t.Errorf("balance mismatch: have %v, want 600", have)
+	}
+	if have := common.BytesToHash(results[3].Calls[0].ReturnValue); have != results[2].Hash {
+		t.Errorf("simulated block hash mismatch: have %x, want %x", have, results[2].Hash)
+	}
+}
+
+// Tests that block overrides are applied, and that the gaps between block
+// numbers are filled with empty blocks.
+func TestSimulateBlockOverrides(t *testing.T) {
+	b := newSimBackend(0)
+	var (
+		coinbase = common.HexToAddress("0xc0ffee")
+		number   = (*hexutil.Big)(big.NewInt(4))
+		time     = (*hexutil.Big)(big.NewInt(1000))
+		time2    = (*hexutil.Big)(big.NewInt(1000 + 3*simulateTimestampIncrement))
+		code     = hexutil.Bytes(simReturn(0x41, 0x42, 0x01)) // COINBASE + TIMESTAMP
+	)
+	results, err := simulate(b, SimOpts{BlockStateCalls: []SimBlock{
+		{
+			BlockOverrides: &BlockOverrides{Number: number, Time: time, Coinbase: &coinbase},
+			StateOverrides: &StateOverride{simContract: {Code: &code}},
+			Calls:          []TransactionArgs{simCall(simContract)},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("simulation failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("block count mismatch: have %d, want 4", len(results))
+	}
+	for i, result := range results[:3] {
+		if uint64(result.Number) != uint64(i+1) || len(result.Calls) != 0 {
+			t.Errorf("filler block %d mismatch: number %d, %d calls", i, result.Number, len(result.Calls))
+		}
+	}
+	last := results[3]
+	if last.Miner != coinbase || uint64(last.Timestamp) != 1000 || last.ParentHash != results[2].Hash {
+		t.Errorf("overrides not applied: miner %x, time %d, parent %x", last.Miner, last.Timestamp, last.ParentHash)
+	}
+	want := new(big.Int).Add(new(big.Int).SetBytes(coinbase.Bytes()), big.NewInt(1000))
+	if have := new(big.Int).SetBytes(last.Calls[0].ReturnValue); have.Cmp(want) != 0 {
+		t.Errorf("call result mismatch: have %v, want %v", have, want)
+	}
+	// The skipped blocks must fit before the overridden timestamp
+	_, err = simulate(b, SimOpts{BlockStateCalls: []SimBlock{
+		{BlockOverrides: &BlockOverrides{Number: number, Time: time2}},
+		{BlockOverrides: &BlockOverrides{Number: (*hexutil.Big)(big.NewInt(6)), Time: time2}},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "block timestamps must be in order") {
+		t.Errorf("unordered timestamps: have %v", err)
+	}
+}
+
+// Tests that czz transfers are reported as logs if requested, unless the frame
+// making them reverts.
+func TestSimulateTransferLogs(t *testing.T) {
+	b := newSimBackend(0)
+	call := func(to common.Address) TransactionArgs {
+		args := simTransfer(simSender, to, 10)
+		return args
+	}
+	results, err := simulate(b, SimOpts{
+		TraceTransfers:  true,
+		BlockStateCalls: []SimBlock{{Calls: []TransactionArgs{call(simForwarder), call(simReverter), simTransfer(simSender, simRecipient, 0)}}},
+	})
+	if err != nil {
+		t.Fatalf("simulation failed: %v", err)
+	}
+	calls := results[0].Calls
+
+	transfer := func(from, to common.Address) []common.Hash {
+		return []common.Hash{transferTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())}
+	}
+	if len(calls[0].Logs) != 2 {
+		t.Fatalf("forwarded transfer log count mismatch: have %d, want 2", len(calls[0].Logs))
+	}
+	for i, want := range []struct {
+		topics []common.Hash
+		amount int64
+	}{
+		{transfer(simSender, simForwarder), 10},
+		{transfer(simForwarder, simRecipient), 5},
+	} {
+		l := calls[0].Logs[i]
+		if l.Address != transferAddress || len(l.Topics) != 3 || l.Topics[1] != want.topics[1] || l.Topics[2] != want.topics[2] || l.Topics[0] != transferTopic {
+			t.Errorf("log %d mismatch: %v", i, l)
+		}
+		if new(big.Int).SetBytes(l.Data).Int64() != want.amount || l.BlockHash != results[0].Hash || l.Index != uint(i) {
+			t.Errorf("log %d mismatch: amount %x, block %x, index %d", i, l.Data, l.BlockHash, l.Index)
+		}
+	}
+	if calls[1].Status != hexutil.Uint64(types.ReceiptStatusFailed) || len(calls[1].Logs) != 0 {
+		t.Errorf("reverted transfers logged: status %d, %d logs", calls[1].Status, len(calls[1].Logs))
+	}
+	if len(calls[2].Logs) != 0 {
+		t.Errorf("empty transfer logged")
+	}
+	// Without tracing, plain transfers don't log
+	results, err = simulate(b, SimOpts{BlockStateCalls: []SimBlock{{Calls: []TransactionArgs{call(simForwarder)}}}})
+	if err != nil {
+		t.Fatalf("simulation failed: %v", err)
+	}
+	if logs := results[0].Calls[0].Logs; logs == nil || len(logs) != 0 {
+		t.Errorf("untraced transfers logged: %v", logs)
+	}
+}
+
+// Tests that the gas cap bounds the gas of all the calls of a simulation.
+func TestSimulateGasCap(t *testing.T) {
+	b := newSimBackend(50000)
+
+	results, err := simulate(b, SimOpts{BlockStateCalls: []SimBlock{
+		{Calls: []TransactionArgs{simTransfer(simSender, simRecipient, 1)}},
+		{Calls: []TransactionArgs{simTransfer(simSender, simRecipient, 1)}},
+	}})
+	if err != nil {
+		t.Fatalf("simulation failed: %v", err)
+	}
+	if results[1].GasUsed != 21000 {
+		t.Errorf("gas used mismatch: have %d, want 21000", results[1].GasUsed)
+	}
+	// The third transfer only has 8000 gas left
+	_, err = simulate(b, SimOpts{BlockStateCalls: []SimBlock{
+		{Calls: []TransactionArgs{simTransfer(simSender, simRecipient, 1), simTransfer(simSender, simRecipient, 1)}},
+		{Calls: []TransactionArgs{simTransfer(simSender, simRecipient, 1)}},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "block 1: call 0: intrinsic gas too low") {
+		t.Errorf("exhausted gas cap: have %v", err)
+	}
+	gas := hexutil.Uint64(40000)
+	args := simTransfer(simSender, simRecipient, 1)
+	args.Gas = &gas
+	_, err = simulate(b, SimOpts{BlockStateCalls: []SimBlock{
+		{Calls: []TransactionArgs{simTransfer(simSender, simRecipient, 1), args}},
+	}})
+	if err == nil || err.Error() != "block 0: call 1: gas cap exceeded: 40000 > 29000" {
+		t.Errorf("gas above cap: have %v", err)
+	}
+}
+
+// Tests the errors of failing calls and invalid simulations.
+func TestSimulateErrors(t *testing.T) {
+	b := newSimBackend(0)
+	revert := hexutil.Bytes([]byte{0x60, 0x2a, 0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xfd}) // REVERT(0, 32) with 42
+
+	results, err := simulate(b, SimOpts{BlockStateCalls: []SimBlock{{
+		StateOverrides: &StateOverride{simContract: {Code: &revert}},
+		Calls:          []TransactionArgs{simCall(simContract), simCall(simInvalid)},
+	}}})
+	if err != nil {
+		t.Fatalf("simulation failed: %v", err)
+	}
+	calls := results[0].Calls
+	if e := calls[0].Error; calls[0].Status != 0 || e == nil || e.Code != 3 || e.Message != "execution reverted" ||
+		e.Data != hexutil.Encode(common.BigToHash(big.NewInt(42)).Bytes()) {
+		t.Errorf("revert mismatch: status %d, error %+v", calls[0].Status, e)
+	}
+	if e := calls[1].Error; calls[1].Status != 0 || e == nil || e.Code != errCodeVMError || !strings.Contains(e.Message, "invalid opcode") {
+		t.Errorf("invalid opcode mismatch: status %d, error %+v", calls[1].Status, e)
+	}
+	for i, tt := range []struct {
+		opts SimOpts
+		want string
+	}{
+		{SimOpts{}, "empty input"},
+		{SimOpts{BlockStateCalls: make([]SimBlock, maxSimulateBlocks+1)}, "too many blocks: 257 > 256"},
+		{
+			SimOpts{BlockStateCalls: []SimBlock{{BlockOverrides: &BlockOverrides{Number: (*hexutil.Big)(big.NewInt(maxSimulateBlocks + 1))}}}},
+			"block 0: too many blocks: 257 > 256",
+		},
+		{
+			SimOpts{BlockStateCalls: []SimBlock{{}, {BlockOverrides: &BlockOverrides{Number: (*hexutil.Big)(big.NewInt(1))}}}},
+			"block 1: block numbers must be in order: 1 <= 1",
+		},
+		{
+			SimOpts{BlockStateCalls: []SimBlock{{Calls: []TransactionArgs{simTransfer(simRecipient, simOther, 1)}}}},
+			"block 0: call 0: insufficient funds for gas * price + value",
+		},
+	} {
+		if _, err := simulate(b, tt.opts); err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("test %d: error mismatch: have %v, want %q", i, err, tt.want)
+		}
+	}
+	b.noState = true
+	if _, err := simulate(b, SimOpts{BlockStateCalls: []SimBlock{{}}}); err != errStateNotAvailable {
+		t.Errorf("missing state: have %v, want %v", err, errStateNotAvailable)
+	}
+}
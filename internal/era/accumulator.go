@@ -0,0 +1,107 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/classzz/go-classzz-v2/common"
+)
+
+// accumulatorDepth is the depth of the merkle tree holding the header records
+// of an epoch, fitting exactly MaxEra1Size leaves.
+const accumulatorDepth = 13
+
+// zeroHashes are the roots of the empty subtrees at each depth of the tree.
+var zeroHashes = func() [accumulatorDepth + 1]common.Hash {
+	var hashes [accumulatorDepth + 1]common.Hash
+	for i := 1; i <= accumulatorDepth; i++ {
+		hashes[i] = sha256Pair(hashes[i-1], hashes[i-1])
+	}
+	return hashes
+}()
+
+// ComputeAccumulator calculates the root of the accumulator of an epoch, which
+// is the SSZ hash tree root of its list of (block hash, total difficulty) header
+// records.
+func ComputeAccumulator(hashes []common.Hash, tds []*big.Int) (common.Hash, error) {
+	if len(hashes) != len(tds) {
+		return common.Hash{}, fmt.Errorf("length mismatch: %d hashes, %d difficulties", len(hashes), len(tds))
+	}
+	if len(hashes) > MaxEra1Size {
+		return common.Hash{}, fmt.Errorf("too many records: %d > %d", len(hashes), MaxEra1Size)
+	}
+	level := make([]common.Hash, len(hashes))
+	for i, hash := range hashes {
+		td, err := encodeTD(tds[i])
+		if err != nil {
+			return common.Hash{}, err
+		}
+		level[i] = sha256Pair(hash, common.BytesToHash(td))
+	}
+	// Merkleize the records, padding every level with the empty subtree roots
+	for depth := 0; depth < accumulatorDepth; depth++ {
+		if len(level)%2 == 1 {
+			level = append(level, zeroHashes[depth])
+		}
+		next := make([]common.Hash, len(level)/2)
+		for i := range next {
+			next[i] = sha256Pair(level[2*i], level[2*i+1])
+		}
+		level = next
+	}
+	root := zeroHashes[accumulatorDepth]
+	if len(level) > 0 {
+		root = level[0]
+	}
+	// Mix in the length of the list
+	var length common.Hash
+	binary.LittleEndian.PutUint64(length[:], uint64(len(hashes)))
+	return sha256Pair(root, length), nil
+}
+
+// encodeTD encodes a total difficulty as a 32 byte little endian integer.
+func encodeTD(td *big.Int) ([]byte, error) {
+	if td.Sign() < 0 || td.BitLen() > 256 {
+		return nil, fmt.Errorf("total difficulty out of range: %v", td)
+	}
+	enc := make([]byte, 32)
+	for i, b := range td.Bytes() {
+		enc[len(td.Bytes())-1-i] = b
+	}
+	return enc, nil
+}
+
+// decodeTD decodes a total difficulty from a 32 byte little endian integer.
+func decodeTD(enc []byte) *big.Int {
+	be := make([]byte, len(enc))
+	for i, b := range enc {
+		be[len(enc)-1-i] = b
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// sha256Pair returns the sha256 hash of the concatenation of a and b.
+func sha256Pair(a, b common.Hash) common.Hash {
+	h := sha256.New()
+	h.Write(a[:])
+	h.Write(b[:])
+	return common.BytesToHash(h.Sum(nil))
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package e2store implements the e2store container format, a simple sequence of
+// type-length-value records.
+//
+// Every record starts with an 8 byte header, made of a little endian 2 byte type,
+// a little endian 4 byte value length and 2 reserved zero bytes:
+//
+//	entry := header | value
+//	header := type | length | reserved
+package e2store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	headerSize     = 8
+	valueSizeLimit = 1024 * 1024 * 50
+)
+
+// Entry is a single record of an e2store stream.
+type Entry struct {
+	Type  uint16
+	Value []byte
+}
+
+// Writer writes e2store entries into an underlying stream.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a new Writer writing into w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes a single entry with the given type and value, returning the
+// number of bytes written including the header.
+func (w *Writer) Write(typ uint16, b []byte) (int, error) {
+	if len(b) > valueSizeLimit {
+		return 0, fmt.Errorf("value too large: %d > %d", len(b), valueSizeLimit)
+	}
+	buf := make([]byte, headerSize)
+	binary.LittleEndian.PutUint16(buf, typ)
+	binary.LittleEndian.PutUint32(buf[2:], uint32(len(b)))
+
+	return w.w.Write(append(buf, b...))
+}
+
+// Reader reads e2store entries from an underlying random access source.
+type Reader struct {
+	r      io.ReaderAt
+	offset int64
+}
+
+// NewReader returns a new Reader reading from r.
+func NewReader(r io.ReaderAt) *Reader {
+	return &Reader{r: r}
+}
+
+// Read reads the next entry of the stream, returning io.EOF once exhausted.
+func (r *Reader) Read() (*Entry, error) {
+	var e Entry
+	n, err := r.ReadAt(&e, r.offset)
+	if err != nil {
+		return nil, err
+	}
+	r.offset += int64(n)
+	return &e, nil
+}
+
+// ReadAt reads the entry at the given offset into e, returning the number of
+// bytes read including the header.
+func (r *Reader) ReadAt(e *Entry, off int64) (int, error) {
+	typ, length, err := r.readHeader(off)
+	if err != nil {
+		return 0, err
+	}
+	e.Type = typ
+	if length == 0 {
+		e.Value = nil
+		return headerSize, nil
+	}
+	e.Value = make([]byte, length)
+	if n, err := r.r.ReadAt(e.Value, off+headerSize); err != nil {
+		if err == io.EOF && n == int(length) {
+			return headerSize + int(length), nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	return headerSize + int(length), nil
+}
+
+// ReaderAt returns a reader over the value of the entry at the given offset,
+// checking that it has the expected type. The total length of the entry is
+// returned too.
+func (r *Reader) ReaderAt(expected uint16, off int64) (io.Reader, int, error) {
+	typ, length, err := r.readHeader(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ != expected {
+		return nil, 0, fmt.Errorf("wrong type, want %d have %d", expected, typ)
+	}
+	return io.NewSectionReader(r.r, off+headerSize, int64(length)), headerSize + int(length), nil
+}
+
+// LengthAt returns the total length of the entry at the given offset, including
+// the header.
+func (r *Reader) LengthAt(off int64) (int64, error) {
+	_, length, err := r.readHeader(off)
+	if err != nil {
+		return 0, err
+	}
+	return headerSize + int64(length), nil
+}
+
+// Find returns the first entry of the given type in the stream.
+func (r *Reader) Find(want uint16) (*Entry, error) {
+	var (
+		off int64
+		e   Entry
+	)
+	for {
+		n, err := r.ReadAt(&e, off)
+		if err != nil {
+			return nil, err
+		}
+		if e.Type == want {
+			return &e, nil
+		}
+		off += int64(n)
+	}
+}
+
+// readHeader reads and validates the entry header at the given offset.
+func (r *Reader) readHeader(off int64) (uint16, uint32, error) {
+	buf := make([]byte, headerSize)
+	if n, err := r.r.ReadAt(buf, off); err != nil {
+		if err == io.EOF && n == 0 {
+			return 0, 0, io.EOF
+		}
+		if err != io.EOF || n != headerSize {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, 0, err
+		}
+	}
+	if buf[6] != 0 || buf[7] != 0 {
+		return 0, 0, errors.New("reserved bytes are non-zero")
+	}
+	length := binary.LittleEndian.Uint32(buf[2:])
+	if length > valueSizeLimit {
+		return 0, 0, fmt.Errorf("value too large: %d > %d", length, valueSizeLimit)
+	}
+	return binary.LittleEndian.Uint16(buf), length, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package e2store
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+)
+
+// Tests that entries written into a stream can be read back.
+func TestEncodeDecode(t *testing.T) {
+	entries := []Entry{
+		{Type: 0xffff, Value: nil},
+		{Type: 0x2a, Value: common.FromHex("beef")},
+		{Type: 0x3265, Value: bytes.Repeat([]byte{0x1}, 300)},
+	}
+	var (
+		buf bytes.Buffer
+		w   = NewWriter(&buf)
+	)
+	for i, entry := range entries {
+		n, err := w.Write(entry.Type, entry.Value)
+		if err != nil {
+			t.Fatalf("entry %d: failed to write: %v", i, err)
+		}
+		if n != headerSize+len(entry.Value) {
+			t.Fatalf("entry %d: written length mismatch: have %d, want %d", i, n, headerSize+len(entry.Value))
+		}
+	}
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	for i, want := range entries {
+		have, err := r.Read()
+		if err != nil {
+			t.Fatalf("entry %d: failed to read: %v", i, err)
+		}
+		if have.Type != want.Type || !bytes.Equal(have.Value, want.Value) {
+			t.Fatalf("entry %d: mismatch: have %x/%x, want %x/%x", i, have.Type, have.Value, want.Type, want.Value)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	// Check the random access methods too
+	entry, err := r.Find(0x3265)
+	if err != nil {
+		t.Fatalf("failed to find entry: %v", err)
+	}
+	if !bytes.Equal(entry.Value, entries[2].Value) {
+		t.Fatalf("found entry mismatch")
+	}
+	if _, err := r.Find(0x1); err != io.EOF {
+		t.Fatalf("expected EOF for missing entry, got %v", err)
+	}
+	reader, n, err := r.ReaderAt(0x2a, headerSize)
+	if err != nil {
+		t.Fatalf("failed to open entry reader: %v", err)
+	}
+	if value, _ := ioutil.ReadAll(reader); n != headerSize+2 || !bytes.Equal(value, entries[1].Value) {
+		t.Fatalf("entry reader mismatch: have %x (%d), want %x", value, n, entries[1].Value)
+	}
+	if _, _, err := r.ReaderAt(0x3265, headerSize); err == nil {
+		t.Fatalf("entry with wrong type opened")
+	}
+}
+
+// Tests that malformed streams are rejected.
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		blob string
+		err  error
+	}{
+		{"", io.EOF},
+		{"2a00", io.ErrUnexpectedEOF},
+		{"2a00020000000000be", io.ErrUnexpectedEOF},
+		{"2a00000000000100", nil},
+	}
+	for i, tt := range tests {
+		r := NewReader(bytes.NewReader(common.FromHex(tt.blob)))
+		_, err := r.Read()
+		if err == nil {
+			t.Errorf("test %d: expected error", i)
+			continue
+		}
+		if tt.err != nil && err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package era implements the Era1 archive format, storing fixed size epochs of
+// the chain history in self-verifying files.
+//
+// An Era1 file is an e2store stream with the following layout:
+//
+//	era1 := Version | block-tuple* | Accumulator | BlockIndex
+//	block-tuple := CompressedHeader | CompressedBody | CompressedReceipts | TotalDifficulty
+//	block-index := starting-number | offset* | count
+//
+// Headers, bodies and receipts are RLP encoded and snappy framed, the total
+// difficulties are 32 byte little endian integers. The accumulator is the root
+// of the (block hash, total difficulty) records of the epoch, which can be
+// checked against a list of known roots to verify an archive without trusting
+// its source.
+package era
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/internal/era/e2store"
+	"github.com/classzz/go-classzz-v2/rlp"
+	"github.com/classzz/go-classzz-v2/trie"
+	"github.com/golang/snappy"
+)
+
+// The entry types of an Era1 file.
+const (
+	TypeVersion            uint16 = 0x3265
+	TypeCompressedHeader   uint16 = 0x03
+	TypeCompressedBody     uint16 = 0x04
+	TypeCompressedReceipts uint16 = 0x05
+	TypeTotalDifficulty    uint16 = 0x06
+	TypeAccumulator        uint16 = 0x07
+	TypeBlockIndex         uint16 = 0x3266
+)
+
+// MaxEra1Size is the number of blocks in a full epoch.
+const MaxEra1Size = 8192
+
+// AccumulatorsFilename is the name of the file listing the accumulator roots of
+// the archives in a directory.
+const AccumulatorsFilename = "accumulators.txt"
+
+// Filename returns the name of the Era1 file of the given network and epoch,
+// tagged with the first bytes of its accumulator root.
+func Filename(network string, epoch int, root common.Hash) string {
+	return fmt.Sprintf("%s-%05d-%s.era1", network, epoch, root.Hex()[2:10])
+}
+
+// ReadDir returns the Era1 files of the given network in the directory, sorted
+// by epoch. The epochs must be contiguous, starting from zero.
+func ReadDir(dir, network string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %v", dir, err)
+	}
+	var (
+		next  uint64
+		files []string
+	)
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".era1" {
+			continue
+		}
+		parts := strings.Split(entry.Name(), "-")
+		if len(parts) != 3 || parts[0] != network {
+			continue
+		}
+		var epoch uint64
+		if _, err := fmt.Sscanf(parts[1], "%d", &epoch); err != nil {
+			return nil, fmt.Errorf("malformed era1 filename: %s", entry.Name())
+		}
+		if epoch != next {
+			return nil, fmt.Errorf("missing epoch %d", next)
+		}
+		next++
+		files = append(files, entry.Name())
+	}
+	return files, nil
+}
+
+// Builder assembles an Era1 file, block by block.
+type Builder struct {
+	w        *e2store.Writer
+	startNum *uint64
+	indexes  []uint64
+	hashes   []common.Hash
+	tds      []*big.Int
+	written  int
+
+	buf    *bytes.Buffer
+	snappy *snappy.Writer
+}
+
+// NewBuilder returns a new Builder writing an Era1 file into w.
+func NewBuilder(w io.Writer) *Builder {
+	buf := new(bytes.Buffer)
+	return &Builder{
+		w:      e2store.NewWriter(w),
+		buf:    buf,
+		snappy: snappy.NewBufferedWriter(buf),
+	}
+}
+
+// Add appends a block along with its receipts and total difficulty.
+func (b *Builder) Add(block *types.Block, receipts types.Receipts, td *big.Int) error {
+	eh, err := rlp.EncodeToBytes(block.Header())
+	if err != nil {
+		return err
+	}
+	eb, err := rlp.EncodeToBytes(block.Body())
+	if err != nil {
+		return err
+	}
+	er, err := rlp.EncodeToBytes(receipts)
+	if err != nil {
+		return err
+	}
+	return b.AddRLP(eh, eb, er, block.NumberU64(), block.Hash(), td)
+}
+
+// AddRLP appends an already RLP encoded block along with its receipts and total
+// difficulty.
+func (b *Builder) AddRLP(header, body, receipts []byte, number uint64, hash common.Hash, td *big.Int) error {
+	// Write the version entry before the first block
+	if b.startNum == nil {
+		n, err := b.w.Write(TypeVersion, nil)
+		if err != nil {
+			return err
+		}
+		b.startNum, b.written = &number, n
+	}
+	if len(b.indexes) >= MaxEra1Size {
+		return fmt.Errorf("exceeds maximum epoch size of %d", MaxEra1Size)
+	}
+	if want := *b.startNum + uint64(len(b.indexes)); number != want {
+		return fmt.Errorf("non contiguous block #%d, want #%d", number, want)
+	}
+	b.indexes = append(b.indexes, uint64(b.written))
+	b.hashes = append(b.hashes, hash)
+	b.tds = append(b.tds, td)
+
+	for _, item := range []struct {
+		typ  uint16
+		blob []byte
+	}{
+		{TypeCompressedHeader, header},
+		{TypeCompressedBody, body},
+		{TypeCompressedReceipts, receipts},
+	} {
+		if err := b.snappyWrite(item.typ, item.blob); err != nil {
+			return err
+		}
+	}
+	enc, err := encodeTD(td)
+	if err != nil {
+		return err
+	}
+	n, err := b.w.Write(TypeTotalDifficulty, enc)
+	b.written += n
+	return err
+}
+
+// Finalize writes the accumulator and the block index, returning the root of
+// the accumulator.
+func (b *Builder) Finalize() (common.Hash, error) {
+	if b.startNum == nil {
+		return common.Hash{}, errors.New("finalize called on empty builder")
+	}
+	root, err := ComputeAccumulator(b.hashes, b.tds)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to compute accumulator: %v", err)
+	}
+	n, err := b.w.Write(TypeAccumulator, root[:])
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to write accumulator: %v", err)
+	}
+	b.written += n
+
+	// The index offsets are relative to the start of the index entry
+	var (
+		base  = int64(b.written)
+		index = make([]byte, 16+len(b.indexes)*8)
+	)
+	binary.LittleEndian.PutUint64(index, *b.startNum)
+	for i, offset := range b.indexes {
+		binary.LittleEndian.PutUint64(index[8+i*8:], uint64(int64(offset)-base))
+	}
+	binary.LittleEndian.PutUint64(index[8+len(b.indexes)*8:], uint64(len(b.indexes)))
+	if _, err := b.w.Write(TypeBlockIndex, index); err != nil {
+		return common.Hash{}, fmt.Errorf("failed to write block index: %v", err)
+	}
+	return root, nil
+}
+
+// snappyWrite writes a snappy framed entry of the given type.
+func (b *Builder) snappyWrite(typ uint16, in []byte) error {
+	b.buf.Reset()
+	b.snappy.Reset(b.buf)
+	if _, err := b.snappy.Write(in); err != nil {
+		return fmt.Errorf("failed to compress entry: %v", err)
+	}
+	if err := b.snappy.Flush(); err != nil {
+		return fmt.Errorf("failed to flush compressed entry: %v", err)
+	}
+	n, err := b.w.Write(typ, b.buf.Bytes())
+	b.written += n
+	return err
+}
+
+// Era is a reader of an Era1 file.
+type Era struct {
+	f      *os.File
+	s      *e2store.Reader
+	start  uint64
+	count  uint64
+	length int64
+}
+
+// Open opens the Era1 file with the given name.
+func Open(filename string) (*Era, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	e, err := From(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// From returns an Era1 reader over the given file.
+func From(f *os.File) (*Era, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	length := info.Size()
+	if length < 8 {
+		return nil, errors.New("file too short")
+	}
+	// The block count is the last field of the index, the starting number
+	// precedes the offsets
+	buf := make([]byte, 8)
+	if _, err := f.ReadAt(buf, length-8); err != nil {
+		return nil, err
+	}
+	count := binary.LittleEndian.Uint64(buf)
+	if count > MaxEra1Size || int64(count)*8+24 > length {
+		return nil, fmt.Errorf("invalid block count %d", count)
+	}
+	if _, err := f.ReadAt(buf, length-8*int64(count)-16); err != nil {
+		return nil, err
+	}
+	return &Era{
+		f:      f,
+		s:      e2store.NewReader(f),
+		start:  binary.LittleEndian.Uint64(buf),
+		count:  count,
+		length: length,
+	}, nil
+}
+
+// Close closes the underlying file.
+func (e *Era) Close() error {
+	return e.f.Close()
+}
+
+// Start returns the number of the first block in the file.
+func (e *Era) Start() uint64 {
+	return e.start
+}
+
+// Count returns the number of blocks in the file.
+func (e *Era) Count() uint64 {
+	return e.count
+}
+
+// Accumulator returns the accumulator root stored in the file.
+func (e *Era) Accumulator() (common.Hash, error) {
+	entry, err := e.s.Find(TypeAccumulator)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if len(entry.Value) != common.HashLength {
+		return common.Hash{}, fmt.Errorf("invalid accumulator length %d", len(entry.Value))
+	}
+	return common.BytesToHash(entry.Value), nil
+}
+
+// GetBlockByNumber returns the block with the given number.
+func (e *Era) GetBlockByNumber(num uint64) (*types.Block, error) {
+	off, err := e.readOffset(num)
+	if err != nil {
+		return nil, err
+	}
+	var header types.Header
+	n, err := e.snappyDecode(TypeCompressedHeader, off, &header)
+	if err != nil {
+		return nil, err
+	}
+	var body types.Body
+	if _, err := e.snappyDecode(TypeCompressedBody, off+n, &body); err != nil {
+		return nil, err
+	}
+	return types.NewBlockWithHeader(&header).WithBody(body.Transactions), nil
+}
+
+// GetReceiptsByNumber returns the receipts of the block with the given number.
+func (e *Era) GetReceiptsByNumber(num uint64) (types.Receipts, error) {
+	off, err := e.readOffset(num)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < 2; i++ {
+		n, err := e.s.LengthAt(off)
+		if err != nil {
+			return nil, err
+		}
+		off += n
+	}
+	var receipts types.Receipts
+	if _, err := e.snappyDecode(TypeCompressedReceipts, off, &receipts); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+// GetTotalDifficultyByNumber returns the total difficulty of the chain up to and
+// including the block with the given number.
+func (e *Era) GetTotalDifficultyByNumber(num uint64) (*big.Int, error) {
+	off, err := e.readOffset(num)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < 3; i++ {
+		n, err := e.s.LengthAt(off)
+		if err != nil {
+			return nil, err
+		}
+		off += n
+	}
+	var entry e2store.Entry
+	if _, err := e.s.ReadAt(&entry, off); err != nil {
+		return nil, err
+	}
+	if entry.Type != TypeTotalDifficulty || len(entry.Value) != 32 {
+		return nil, fmt.Errorf("invalid total difficulty entry of block #%d", num)
+	}
+	return decodeTD(entry.Value), nil
+}
+
+// readOffset returns the offset of the block tuple with the given number.
+func (e *Era) readOffset(num uint64) (int64, error) {
+	if num < e.start || num >= e.start+e.count {
+		return 0, fmt.Errorf("block #%d out of range [%d, %d)", num, e.start, e.start+e.count)
+	}
+	var (
+		base = e.length - 8*int64(e.count) - 24 // Start of the index entry
+		pos  = base + 16 + 8*int64(num-e.start)
+		buf  = make([]byte, 8)
+	)
+	if _, err := e.f.ReadAt(buf, pos); err != nil {
+		return 0, err
+	}
+	return base + int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// snappyDecode decodes the snappy framed RLP entry of the given type at the
+// given offset into val, returning the length of the entry.
+func (e *Era) snappyDecode(typ uint16, off int64, val interface{}) (int64, error) {
+	r, n, err := e.s.ReaderAt(typ, off)
+	if err != nil {
+		return 0, err
+	}
+	if err := rlp.Decode(snappy.NewReader(r), val); err != nil {
+		return 0, err
+	}
+	return int64(n), nil
+}
+
+// Verify checks the internal consistency of the file: the blocks must be linked,
+// their bodies and receipts must match the headers, the total difficulties must
+// add up and the accumulator must match the contents. The accumulator root is
+// returned, to be checked against a known list by the caller.
+func (e *Era) Verify() (common.Hash, error) {
+	var (
+		hashes = make([]common.Hash, 0, e.count)
+		tds    = make([]*big.Int, 0, e.count)
+		parent *types.Block
+	)
+	for num := e.start; num < e.start+e.count; num++ {
+		block, err := e.GetBlockByNumber(num)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("failed to read block #%d: %v", num, err)
+		}
+		receipts, err := e.GetReceiptsByNumber(num)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("failed to read receipts #%d: %v", num, err)
+		}
+		td, err := e.GetTotalDifficultyByNumber(num)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if block.NumberU64() != num {
+			return common.Hash{}, fmt.Errorf("block number mismatch: have %d, want %d", block.NumberU64(), num)
+		}
+		if parent != nil {
+			if block.ParentHash() != parent.Hash() {
+				return common.Hash{}, fmt.Errorf("block #%d not linked to its parent", num)
+			}
+			if want := new(big.Int).Add(tds[len(tds)-1], block.Difficulty()); td.Cmp(want) != 0 {
+				return common.Hash{}, fmt.Errorf("total difficulty mismatch of block #%d: have %v, want %v", num, td, want)
+			}
+		}
+		if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != block.TxHash() {
+			return common.Hash{}, fmt.Errorf("transaction root mismatch of block #%d", num)
+		}
+		if hash := types.DeriveSha(receipts, trie.NewStackTrie(nil)); hash != block.ReceiptHash() {
+			return common.Hash{}, fmt.Errorf("receipt root mismatch of block #%d", num)
+		}
+		hashes, tds, parent = append(hashes, block.Hash()), append(tds, td), block
+	}
+	root, err := ComputeAccumulator(hashes, tds)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	stored, err := e.Accumulator()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if root != stored {
+		return common.Hash{}, fmt.Errorf("accumulator mismatch: have %x, stored %x", root, stored)
+	}
+	return root, nil
+}
+
+// ReadAccumulators reads a list of known accumulator roots, one hex encoded
+// root per line in epoch order.
+func ReadAccumulators(filename string) ([]common.Hash, error) {
+	blob, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var roots []common.Hash
+	for i, line := range strings.Split(string(blob), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		root := common.FromHex(line)
+		if len(root) != common.HashLength {
+			return nil, fmt.Errorf("invalid accumulator root on line %d: %q", i+1, line)
+		}
+		roots = append(roots, common.BytesToHash(root))
+	}
+	return roots, nil
+}
+
+// WriteAccumulators writes a list of accumulator roots in the format read by
+// ReadAccumulators.
+func WriteAccumulators(filename string, roots []common.Hash) error {
+	var buf bytes.Buffer
+	for _, root := range roots {
+		fmt.Fprintln(&buf, root.Hex())
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/types"
+	"github.com/classzz/go-classzz-v2/trie"
+)
+
+// makeChain creates a chain of linked blocks with transactions and receipts,
+// starting at the given number.
+func makeChain(start uint64, n int) ([]*types.Block, []types.Receipts, []*big.Int) {
+	var (
+		blocks   []*types.Block
+		receipts []types.Receipts
+		tds      []*big.Int
+		parent   common.Hash
+		td       = big.NewInt(1000)
+	)
+	for i := 0; i < n; i++ {
+		var (
+			txs  []*types.Transaction
+			recs types.Receipts
+		)
+		for j := 0; j < i%3; j++ {
+			tx := types.NewTransaction(uint64(j), common.Address{byte(i)}, big.NewInt(int64(j)), 21000, big.NewInt(1), nil)
+			txs = append(txs, tx)
+			recs = append(recs, &types.Receipt{
+				Status:            types.ReceiptStatusSuccessful,
+				CumulativeGasUsed: uint64(21000 * (j + 1)),
+				Logs:              []*types.Log{{Address: common.Address{byte(j)}, Data: []byte{byte(i)}}},
+			})
+		}
+		for _, r := range recs {
+			r.Bloom = types.CreateBloom(types.Receipts{r})
+		}
+		header := &types.Header{
+			ParentHash: parent,
+			Number:     new(big.Int).SetUint64(start + uint64(i)),
+			Difficulty: big.NewInt(int64(100 + i)),
+			GasLimit:   8000000,
+			Time:       uint64(i),
+		}
+		block := types.NewBlock(header, txs, recs, trie.NewStackTrie(nil))
+		td = new(big.Int).Add(td, block.Difficulty())
+
+		blocks, receipts, tds = append(blocks, block), append(receipts, recs), append(tds, td)
+		parent = block.Hash()
+	}
+	return blocks, receipts, tds
+}
+
+// buildEra writes the given blocks into an Era1 file in a temporary directory.
+func buildEra(t *testing.T, blocks []*types.Block, receipts []types.Receipts, tds []*big.Int) (string, common.Hash) {
+	dir, err := ioutil.TempDir("", "era-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	f, err := os.Create(filepath.Join(dir, "test.era1"))
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer f.Close()
+
+	builder := NewBuilder(f)
+	for i, block := range blocks {
+		if err := builder.Add(block, receipts[i], tds[i]); err != nil {
+			t.Fatalf("failed to add block %d: %v", i, err)
+		}
+	}
+	root, err := builder.Finalize()
+	if err != nil {
+		t.Fatalf("failed to finalize: %v", err)
+	}
+	return f.Name(), root
+}
+
+// Tests that the contents of an Era1 file can be read back and verified.
+func TestEraRoundtrip(t *testing.T) {
+	blocks, receipts, tds := makeChain(MaxEra1Size, 128)
+	name, root := buildEra(t, blocks, receipts, tds)
+	defer os.RemoveAll(filepath.Dir(name))
+
+	e, err := Open(name)
+	if err != nil {
+		t.Fatalf("failed to open era: %v", err)
+	}
+	defer e.Close()
+
+	if e.Start() != MaxEra1Size || e.Count() != 128 {
+		t.Fatalf("range mismatch: have [%d, +%d], want [%d, +%d]", e.Start(), e.Count(), MaxEra1Size, 128)
+	}
+	if stored, err := e.Accumulator(); err != nil || stored != root {
+		t.Fatalf("accumulator mismatch: have %x (%v), want %x", stored, err, root)
+	}
+	for i, want := range blocks {
+		num := want.NumberU64()
+		block, err := e.GetBlockByNumber(num)
+		if err != nil {
+			t.Fatalf("block %d: failed to read: %v", num, err)
+		}
+		if block.Hash() != want.Hash() || len(block.Transactions()) != len(want.Transactions()) {
+			t.Fatalf("block %d: mismatch", num)
+		}
+		recs, err := e.GetReceiptsByNumber(num)
+		if err != nil {
+			t.Fatalf("block %d: failed to read receipts: %v", num, err)
+		}
+		if types.DeriveSha(recs, trie.NewStackTrie(nil)) != want.ReceiptHash() {
+			t.Fatalf("block %d: receipts mismatch", num)
+		}
+		td, err := e.GetTotalDifficultyByNumber(num)
+		if err != nil || td.Cmp(tds[i]) != 0 {
+			t.Fatalf("block %d: total difficulty mismatch: have %v (%v), want %v", num, td, err, tds[i])
+		}
+	}
+	if _, err := e.GetBlockByNumber(MaxEra1Size + 128); err == nil {
+		t.Fatalf("out of range block retrieved")
+	}
+	if verified, err := e.Verify(); err != nil || verified != root {
+		t.Fatalf("verification failed: have %x (%v), want %x", verified, err, root)
+	}
+}
+
+// Tests that the accumulator commits to both the block hashes and the total
+// difficulties, and that tampered files fail verification.
+func TestEraTampering(t *testing.T) {
+	blocks, receipts, tds := makeChain(0, 16)
+	_, root := buildEra(t, blocks, receipts, tds)
+
+	// Any change in the records must change the accumulator
+	hashes := make([]common.Hash, len(blocks))
+	for i, block := range blocks {
+		hashes[i] = block.Hash()
+	}
+	if have, _ := ComputeAccumulator(hashes, tds); have != root {
+		t.Fatalf("accumulator mismatch: have %x, want %x", have, root)
+	}
+	forged := append([]*big.Int{}, tds...)
+	forged[5] = new(big.Int).Add(forged[5], common.Big1)
+	if have, _ := ComputeAccumulator(hashes, forged); have == root {
+		t.Fatalf("accumulator ignores total difficulty")
+	}
+	if have, _ := ComputeAccumulator(hashes[:15], tds[:15]); have == root {
+		t.Fatalf("accumulator ignores list length")
+	}
+	// Forged total difficulties must be rejected even with a matching accumulator
+	name, _ := buildEra(t, blocks, receipts, forged)
+	defer os.RemoveAll(filepath.Dir(name))
+
+	e, err := Open(name)
+	if err != nil {
+		t.Fatalf("failed to open era: %v", err)
+	}
+	defer e.Close()
+	if _, err := e.Verify(); err == nil {
+		t.Fatalf("forged total difficulty verified")
+	}
+	// Mismatching receipts must be rejected too
+	receipts[2], receipts[4] = receipts[4], receipts[2]
+	name, _ = buildEra(t, blocks, receipts, tds)
+	defer os.RemoveAll(filepath.Dir(name))
+
+	e, err = Open(name)
+	if err != nil {
+		t.Fatalf("failed to open era: %v", err)
+	}
+	defer e.Close()
+	if _, err := e.Verify(); err == nil {
+		t.Fatalf("mismatching receipts verified")
+	}
+}
+
+// Tests that the Era1 files of a network are listed in epoch order, rejecting
+// gaps between them.
+func TestReadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "era-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{
+		Filename("mainnet", 1, common.Hash{0x2}),
+		Filename("mainnet", 0, common.Hash{0x1}),
+		Filename("testnet", 0, common.Hash{0x3}),
+		AccumulatorsFilename,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	files, err := ReadDir(dir, "mainnet")
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	if len(files) != 2 || files[0] != Filename("mainnet", 0, common.Hash{0x1}) || files[1] != Filename("mainnet", 1, common.Hash{0x2}) {
+		t.Fatalf("file list mismatch: %v", files)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, Filename("mainnet", 3, common.Hash{})), nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if _, err := ReadDir(dir, "mainnet"); err == nil {
+		t.Fatalf("gap between epochs accepted")
+	}
+}
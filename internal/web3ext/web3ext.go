@@ -420,6 +420,12 @@ web3._extend({
 			params: 3,
 			inputFormatter: [null, null, null]
 		}),
+		new web3._extend.Method({
+			name: 'traceCallMany',
+			call: 'debug_traceCallMany',
+			params: 3,
+			inputFormatter: [null, null, null]
+		}),
 		new web3._extend.Method({
 			name: 'preimage',
 			call: 'debug_preimage',
@@ -453,6 +459,50 @@ web3._extend({
 			call: 'debug_freezeClient',
 			params: 1,
 		}),
+		new web3._extend.Method({
+			name: 'pruneState',
+			call: 'debug_pruneState',
+			params: 1,
+			inputFormatter: [null],
+		}),
+		new web3._extend.Method({
+			name: 'pruneStateProgress',
+			call: 'debug_pruneStateProgress',
+			params: 0,
+		}),
+		new web3._extend.Method({
+			name: 'snapshotStatus',
+			call: 'debug_snapshotStatus',
+			params: 0,
+		}),
+		new web3._extend.Method({
+			name: 'snapshotPause',
+			call: 'debug_snapshotPause',
+			params: 0,
+		}),
+		new web3._extend.Method({
+			name: 'snapshotResume',
+			call: 'debug_snapshotResume',
+			params: 0,
+		}),
+		new web3._extend.Method({
+			name: 'snapshotRegenerate',
+			call: 'debug_snapshotRegenerate',
+			params: 1,
+			inputFormatter: [null],
+		}),
+		new web3._extend.Method({
+			name: 'executionWitness',
+			call: 'debug_executionWitness',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter],
+		}),
+		new web3._extend.Method({
+			name: 'executionMultiproof',
+			call: 'debug_executionMultiproof',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter],
+		}),
 	],
 	properties: []
 });
@@ -527,6 +577,12 @@ web3._extend({
 			params: 2,
 			inputFormatter: [null, function (val) { return !!val; }]
 		}),
+		new web3._extend.Method({
+			name: 'getBlockReceipts',
+			call: 'eth_getBlockReceipts',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter]
+		}),
 		new web3._extend.Method({
 			name: 'getRawTransaction',
 			call: 'eth_getRawTransactionByHash',
@@ -552,6 +608,12 @@ web3._extend({
 			params: 2,
 			inputFormatter: [null, web3._extend.formatters.inputBlockNumberFormatter],
 		}),
+		new web3._extend.Method({
+			name: 'simulateV1',
+			call: 'eth_simulateV1',
+			params: 2,
+			inputFormatter: [null, web3._extend.formatters.inputBlockNumberFormatter],
+		}),
 		new web3._extend.Method({
 			name: 'feeHistory',
 			call: 'eth_feeHistory',
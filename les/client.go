@@ -287,6 +287,11 @@ func (s *LightDummyAPI) Mining() bool {
 func (s *lightClasszz) APIs() []rpc.API {
 	apis := czzapi.GetAPIs(s.ApiBackend)
 	apis = append(apis, s.engine.APIs(s.BlockChain().HeaderChain())...)
+
+	logLimits := filters.Limits{
+		MaxBlockRange: s.config.RPCLogsMaxBlockRange,
+		MaxResults:    s.config.RPCLogsMaxResults,
+	}
 	return append(apis, []rpc.API{
 		{
 			Namespace: "czz",
@@ -301,7 +306,12 @@ func (s *lightClasszz) APIs() []rpc.API {
 		}, {
 			Namespace: "czz",
 			Version:   "1.0",
-			Service:   filters.NewPublicFilterAPI(s.ApiBackend, true, 5*time.Minute),
+			Service:   filters.NewPublicFilterAPI(s.ApiBackend, true, 5*time.Minute, logLimits),
+			Public:    true,
+		}, {
+			Namespace: "czz",
+			Version:   "1.0",
+			Service:   filters.NewPublicLogsAPI(s.ApiBackend, logLimits),
 			Public:    true,
 		}, {
 			Namespace: "net",
@@ -69,6 +69,11 @@ type Config struct {
 	// in memory.
 	DataDir string
 
+	// DBEngine is the database engine (leveldb or pebble) used for newly created
+	// databases. Pre-existing databases are always opened with the engine they
+	// were created with.
+	DBEngine string `toml:",omitempty"`
+
 	// Configuration of peer-to-peer networking.
 	P2P p2p.Config
 
@@ -141,6 +146,12 @@ type Config struct {
 	// HTTPPathPrefix specifies a path prefix on which http-rpc is to be served.
 	HTTPPathPrefix string `toml:",omitempty"`
 
+	// HTTPJWTSecret is the path of the file holding the hex encoded secret used to
+	// authenticate HTTP requests with HS256 bearer tokens. Relative paths are
+	// resolved in the instance directory, and the file is created if missing.
+	// Authentication is disabled if empty.
+	HTTPJWTSecret string `toml:",omitempty"`
+
 	// WSHost is the host interface on which to start the websocket RPC server. If
 	// this field is empty, no websocket API endpoint will be started.
 	WSHost string
@@ -153,6 +164,10 @@ type Config struct {
 	// WSPathPrefix specifies a path prefix on which ws-rpc is to be served.
 	WSPathPrefix string `toml:",omitempty"`
 
+	// WSJWTSecret is the path of the file holding the secret used to authenticate
+	// websocket connections, see HTTPJWTSecret.
+	WSJWTSecret string `toml:",omitempty"`
+
 	// WSOrigins is the list of domain to accept websocket requests from. Please be
 	// aware that the server can only act upon the HTTP request the client sends and
 	// cannot verify the validity of the request header.
@@ -170,6 +185,12 @@ type Config struct {
 	// private APIs to untrusted users is a major security risk.
 	WSExposeAll bool `toml:",omitempty"`
 
+	// RPCLimits restricts the resources each client of the HTTP and websocket
+	// RPC endpoints may consume, across both endpoints. Clients are identified by
+	// the subject claim of their JWT token, or their IP address. Nothing is
+	// limited by default.
+	RPCLimits rpc.Limits `toml:",omitempty"`
+
 	// GraphQLCors is the Cross-Origin Resource Sharing header to send to requesting
 	// clients. Please be aware that CORS is a browser enforced security, it's fully
 	// useless for custom HTTP clients.
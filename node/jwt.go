@@ -0,0 +1,186 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/common/hexutil"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/rpc"
+)
+
+const (
+	jwtSecretLength = 32               // Length of the HS256 secret in bytes
+	jwtIssuedAtSkew = 60 * time.Second // Maximum clock difference allowed for "iat"
+)
+
+var (
+	errMissingToken     = errors.New("missing bearer token")
+	errMalformedToken   = errors.New("malformed token")
+	errUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	errInvalidSignature = errors.New("invalid token signature")
+	errMissingIssuedAt  = errors.New("missing issued-at claim")
+	errStaleToken       = errors.New("token issued-at outside of allowed window")
+	errExpiredToken     = errors.New("token expired")
+)
+
+// jwtClaims are the token claims understood by the node. Namespaces, if present,
+// restricts the RPC namespaces the bearer may call. Subject, if present,
+// identifies the bearer for rate limiting.
+type jwtClaims struct {
+	IssuedAt   *int64   `json:"iat"`
+	Expiry     *int64   `json:"exp,omitempty"`
+	Subject    string   `json:"sub,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// ObtainJWTSecret loads the hex encoded HS256 secret from the given file. If the
+// file doesn't exist, a random secret is generated and stored in it.
+func ObtainJWTSecret(path string) ([]byte, error) {
+	if data, err := ioutil.ReadFile(path); err == nil {
+		secret := common.FromHex(strings.TrimSpace(string(data)))
+		if len(secret) != jwtSecretLength {
+			return nil, fmt.Errorf("invalid JWT secret in %s: need %d hex encoded bytes", path, jwtSecretLength)
+		}
+		log.Info("Loaded JWT secret file", "path", path)
+		return secret, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	secret := make([]byte, jwtSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, []byte(hexutil.Encode(secret)), 0600); err != nil {
+		return nil, err
+	}
+	log.Info("Generated JWT secret", "path", path)
+	return secret, nil
+}
+
+// jwtHandler authenticates requests carrying an HS256 signed bearer token.
+type jwtHandler struct {
+	secret []byte
+	next   http.Handler
+}
+
+// newJWTHandler wraps next to only serve requests authenticated with secret. A
+// nil secret disables authentication.
+func newJWTHandler(secret []byte, next http.Handler) http.Handler {
+	if secret == nil {
+		return next
+	}
+	return &jwtHandler{secret: secret, next: next}
+}
+
+func (h *jwtHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// CORS preflight requests don't carry credentials.
+	if r.Method == http.MethodOptions {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	var token string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	claims, err := verifyJWT(h.secret, token, time.Now())
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if claims.Namespaces != nil {
+		r = r.WithContext(rpc.WithNamespaces(r.Context(), claims.Namespaces))
+	}
+	if claims.Subject != "" {
+		r = r.WithContext(rpc.WithSubject(r.Context(), claims.Subject))
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// verifyJWT checks the signature and timestamps of an HS256 token, returning its
+// claims.
+func verifyJWT(secret []byte, token string, now time.Time) (*jwtClaims, error) {
+	if token == "" {
+		return nil, errMissingToken
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "HS256" {
+		return nil, errUnsupportedAlg
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errInvalidSignature
+	}
+	claims := new(jwtClaims)
+	if err := decodeJWTPart(parts[1], claims); err != nil {
+		return nil, err
+	}
+	if claims.IssuedAt == nil {
+		return nil, errMissingIssuedAt
+	}
+	if diff := now.Sub(time.Unix(*claims.IssuedAt, 0)); diff > jwtIssuedAtSkew || diff < -jwtIssuedAtSkew {
+		return nil, errStaleToken
+	}
+	if claims.Expiry != nil && now.Unix() >= *claims.Expiry {
+		return nil, errExpiredToken
+	}
+	return claims, nil
+}
+
+// decodeJWTPart decodes a base64url encoded JSON token segment.
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errMalformedToken
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errMalformedToken
+	}
+	return nil
+}
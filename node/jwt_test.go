@@ -0,0 +1,144 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/internal/testlog"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/rpc"
+	"github.com/gorilla/websocket"
+)
+
+var jwtTestSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// makeJWT signs the given claims with secret using alg in the header.
+func makeJWT(secret []byte, alg string, claims interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	token := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(token))
+	return token + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyJWT(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		token string
+		err   error
+	}{
+		{"", errMissingToken},
+		{"abc.def", errMalformedToken},
+		{makeJWT(jwtTestSecret, "HS256", map[string]int64{"iat": now.Unix()}), nil},
+		{makeJWT(jwtTestSecret, "HS256", map[string]int64{"iat": now.Unix() - 30}), nil},
+		{makeJWT(jwtTestSecret, "HS256", map[string]int64{"iat": now.Unix() + 30}), nil},
+		{makeJWT(jwtTestSecret, "none", map[string]int64{"iat": now.Unix()}), errUnsupportedAlg},
+		{makeJWT([]byte("wrong"), "HS256", map[string]int64{"iat": now.Unix()}), errInvalidSignature},
+		{makeJWT(jwtTestSecret, "HS256", map[string]int64{}), errMissingIssuedAt},
+		{makeJWT(jwtTestSecret, "HS256", map[string]int64{"iat": now.Unix() - 61}), errStaleToken},
+		{makeJWT(jwtTestSecret, "HS256", map[string]int64{"iat": now.Unix() + 61}), errStaleToken},
+		{makeJWT(jwtTestSecret, "HS256", map[string]int64{"iat": now.Unix(), "exp": now.Unix()}), errExpiredToken},
+	}
+	for i, tt := range tests {
+		if _, err := verifyJWT(jwtTestSecret, tt.token, now); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+type jwtTestService struct{}
+
+func (jwtTestService) Echo(s string) string { return s }
+
+// Tests that authenticated endpoints reject unauthenticated requests and only
+// serve the namespaces granted by the token.
+func TestJWTAuthentication(t *testing.T) {
+	apis := []rpc.API{{Namespace: "test", Version: "1.0", Service: jwtTestService{}, Public: true}}
+	srv := newHTTPServer(testlog.Logger(t, log.LvlDebug), rpc.DefaultHTTPTimeouts)
+	if err := srv.enableRPC(apis, httpConfig{jwtSecret: jwtTestSecret}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.enableWS(apis, wsConfig{Origins: []string{"*"}, jwtSecret: jwtTestSecret}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.setListenAddr("localhost", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.stop()
+
+	httpURL := "http://" + srv.listenAddr()
+	if resp := rpcRequest(t, httpURL); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated request: have status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if err := wsRequest(t, "ws://"+srv.listenAddr(), ""); err != websocket.ErrBadHandshake {
+		t.Fatalf("unauthenticated websocket: have %v, want %v", err, websocket.ErrBadHandshake)
+	}
+
+	call := func(claims map[string]interface{}, method string) error {
+		client, err := rpc.DialHTTP(httpURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		client.SetHeader("Authorization", "Bearer "+makeJWT(jwtTestSecret, "HS256", claims))
+
+		var result string
+		return client.Call(&result, method, "hello")
+	}
+	iat := time.Now().Unix()
+	if err := call(map[string]interface{}{"iat": iat}, "test_echo"); err != nil {
+		t.Fatalf("unscoped token rejected: %v", err)
+	}
+	if err := call(map[string]interface{}{"iat": iat, "namespaces": []string{"test"}}, "test_echo"); err != nil {
+		t.Fatalf("scoped token rejected: %v", err)
+	}
+	if err := call(map[string]interface{}{"iat": iat, "namespaces": []string{"admin"}}, "test_echo"); err == nil {
+		t.Fatalf("call outside of token scope accepted")
+	}
+
+	// Scopes also apply to websocket connections.
+	header := http.Header{"Authorization": {"Bearer " + makeJWT(jwtTestSecret, "HS256", map[string]interface{}{"iat": iat, "namespaces": []string{"admin"}})}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.listenAddr(), header)
+	if err != nil {
+		t.Fatalf("websocket with token rejected: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "test_echo", "params": []string{"hello"}}); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Error *struct{ Code int } `json:"error"`
+	}
+	if err := conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil || resp.Error.Code != -32601 {
+		t.Fatalf("websocket call outside of token scope: have error %v, want method not found", resp.Error)
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/classzz/go-classzz-v2/accounts"
 	"github.com/classzz/go-classzz-v2/core/rawdb"
 	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/czzdb/remotedb"
 	"github.com/classzz/go-classzz-v2/event"
 	"github.com/classzz/go-classzz-v2/log"
 	"github.com/classzz/go-classzz-v2/p2p"
@@ -50,12 +51,13 @@ type Node struct {
 	state         int               // Tracks state of node lifecycle
 
 	lock          sync.Mutex
-	lifecycles    []Lifecycle // All registered backends, services, and auxiliary services that have a lifecycle
-	rpcAPIs       []rpc.API   // List of APIs currently provided by the node
-	http          *httpServer //
-	ws            *httpServer //
-	ipc           *ipcServer  // Stores information about the ipc http server
-	inprocHandler *rpc.Server // In-process RPC request handler to process the API requests
+	lifecycles    []Lifecycle  // All registered backends, services, and auxiliary services that have a lifecycle
+	rpcAPIs       []rpc.API    // List of APIs currently provided by the node
+	http          *httpServer  //
+	ws            *httpServer  //
+	ipc           *ipcServer   // Stores information about the ipc http server
+	inprocHandler *rpc.Server  // In-process RPC request handler to process the API requests
+	rpcLimiter    *rpc.Limiter // Client limits shared by the HTTP and WebSocket endpoints
 
 	databases map[*closeTrackingDB]struct{} // All open databases
 }
@@ -144,6 +146,7 @@ func New(conf *Config) (*Node, error) {
 	}
 
 	// Configure RPC servers.
+	node.rpcLimiter = rpc.NewLimiter(conf.RPCLimits)
 	node.http = newHTTPServer(node.log, conf.HTTPTimeouts)
 	node.ws = newHTTPServer(node.log, rpc.DefaultHTTPTimeouts)
 	node.ipc = newIPCServer(node.log, conf.IPCEndpoint())
@@ -355,6 +358,14 @@ func (n *Node) startRPC() error {
 			Vhosts:             n.config.HTTPVirtualHosts,
 			Modules:            n.config.HTTPModules,
 			prefix:             n.config.HTTPPathPrefix,
+			limiter:            n.rpcLimiter,
+		}
+		if n.config.HTTPJWTSecret != "" {
+			secret, err := ObtainJWTSecret(n.config.ResolvePath(n.config.HTTPJWTSecret))
+			if err != nil {
+				return err
+			}
+			config.jwtSecret = secret
 		}
 		if err := n.http.setListenAddr(n.config.HTTPHost, n.config.HTTPPort); err != nil {
 			return err
@@ -371,6 +382,14 @@ func (n *Node) startRPC() error {
 			Modules: n.config.WSModules,
 			Origins: n.config.WSOrigins,
 			prefix:  n.config.WSPathPrefix,
+			limiter: n.rpcLimiter,
+		}
+		if n.config.WSJWTSecret != "" {
+			secret, err := ObtainJWTSecret(n.config.ResolvePath(n.config.WSJWTSecret))
+			if err != nil {
+				return err
+			}
+			config.jwtSecret = secret
 		}
 		if err := server.setListenAddr(n.config.WSHost, n.config.WSPort); err != nil {
 			return err
@@ -472,6 +491,25 @@ func (n *Node) RegisterHandler(name, path string, handler http.Handler) {
 	n.http.handlerNames[path] = name
 }
 
+// RegisterWSHandler mounts a handler on the given path of the server serving
+// JSON-RPC over WebSocket. WebSocket upgrade requests for the path are routed
+// to the handler after checking their origin against the allowed WebSocket
+// origins. The handler is only reachable while WebSocket RPC is enabled.
+func (n *Node) RegisterWSHandler(name, path string, handler http.Handler) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.state != initializingState {
+		panic("can't register WebSocket handler on running/stopped node")
+	}
+	// The WebSocket endpoint shares the HTTP server if both use the same port,
+	// which is only known once RPC starts.
+	for _, server := range []*httpServer{n.http, n.ws} {
+		server.wsMux.Handle(path, handler)
+		server.wsHandlerNames[path] = name
+	}
+}
+
 // Attach creates an RPC client attached to an in-process API handler.
 func (n *Node) Attach() (*rpc.Client, error) {
 	return rpc.DialInProc(n.inprocHandler), nil
@@ -559,7 +597,14 @@ func (n *Node) OpenDatabase(name string, cache, handles int, namespace string, r
 	if n.config.DataDir == "" {
 		db = rawdb.NewMemoryDatabase()
 	} else {
-		db, err = rawdb.NewLevelDBDatabase(n.ResolvePath(name), cache, handles, namespace, readonly)
+		var engine string
+		if engine, err = n.dbEngine(n.ResolvePath(name)); err == nil {
+			if engine == rawdb.DBPebble {
+				db, err = rawdb.NewPebbleDBDatabase(n.ResolvePath(name), cache, handles, namespace, readonly)
+			} else {
+				db, err = rawdb.NewLevelDBDatabase(n.ResolvePath(name), cache, handles, namespace, readonly)
+			}
+		}
 	}
 
 	if err == nil {
@@ -589,10 +634,19 @@ func (n *Node) OpenDatabaseWithFreezer(name string, cache, handles int, freezer,
 		switch {
 		case freezer == "":
 			freezer = filepath.Join(root, "ancient")
+		case remotedb.IsRemote(freezer):
+			// Ancient store served by another process, nothing to resolve
 		case !filepath.IsAbs(freezer):
 			freezer = n.ResolvePath(freezer)
 		}
-		db, err = rawdb.NewLevelDBDatabaseWithFreezer(root, cache, handles, freezer, namespace, readonly)
+		var engine string
+		if engine, err = n.dbEngine(root); err == nil {
+			if engine == rawdb.DBPebble {
+				db, err = rawdb.NewPebbleDBDatabaseWithFreezer(root, cache, handles, freezer, namespace, readonly)
+			} else {
+				db, err = rawdb.NewLevelDBDatabaseWithFreezer(root, cache, handles, freezer, namespace, readonly)
+			}
+		}
 	}
 
 	if err == nil {
@@ -601,6 +655,25 @@ func (n *Node) OpenDatabaseWithFreezer(name string, cache, handles int, freezer,
 	return db, err
 }
 
+// dbEngine returns the database engine to open the key-value store at the given
+// path with. Pre-existing databases keep the engine they were created with, new
+// ones use the configured engine, falling back to leveldb.
+func (n *Node) dbEngine(path string) (string, error) {
+	existing := rawdb.PreexistingDatabase(path)
+	if existing != "" && n.config.DBEngine != "" && n.config.DBEngine != existing {
+		return "", fmt.Errorf("db.engine choice was %v but found pre-existing %v database in specified data directory", n.config.DBEngine, existing)
+	}
+	switch {
+	case existing != "":
+		n.log.Info("Using pre-existing database engine", "engine", existing, "path", path)
+		return existing, nil
+	case n.config.DBEngine != "":
+		return n.config.DBEngine, nil
+	default:
+		return rawdb.DBLeveldb, nil
+	}
+}
+
 // ResolvePath returns the absolute path of a resource in the instance directory.
 func (n *Node) ResolvePath(x string) string {
 	return n.config.ResolvePath(x)
@@ -24,15 +24,18 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/classzz/go-classzz-v2/core/rawdb"
 	"github.com/classzz/go-classzz-v2/crypto"
 	"github.com/classzz/go-classzz-v2/czzdb"
 	"github.com/classzz/go-classzz-v2/p2p"
 	"github.com/classzz/go-classzz-v2/rpc"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -172,6 +175,49 @@ func TestNodeCloseClosesDB(t *testing.T) {
 	}
 }
 
+// This test checks that pre-existing databases are reopened with the engine they
+// were created with, regardless of the engine configured for new ones.
+func TestNodeOpenDatabaseEngine(t *testing.T) {
+	if !rawdb.PebbleEnabled {
+		t.Skip("pebble not supported on this platform")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	open := func(engine string) error {
+		config := testNodeConfig()
+		config.DataDir = dir
+		config.DBEngine = engine
+
+		stack, err := New(config)
+		if err != nil {
+			t.Fatalf("failed to create protocol stack: %v", err)
+		}
+		defer stack.Close()
+
+		db, err := stack.OpenDatabase("mydb", 0, 0, "", false)
+		if err != nil {
+			return err
+		}
+		return db.Put([]byte("key"), []byte("value"))
+	}
+	if err := open(rawdb.DBPebble); err != nil {
+		t.Fatalf("failed to create pebble database: %v", err)
+	}
+	if engine := rawdb.PreexistingDatabase(filepath.Join(dir, "test node", "mydb")); engine != rawdb.DBPebble {
+		t.Fatalf("database engine mismatch: have %q, want %q", engine, rawdb.DBPebble)
+	}
+	if err := open(""); err != nil {
+		t.Fatalf("failed to reopen pebble database: %v", err)
+	}
+	if err := open(rawdb.DBLeveldb); err == nil {
+		t.Fatalf("reopened pebble database with leveldb")
+	}
+}
+
 // This test checks that OpenDatabase can be used from within a Lifecycle Start method.
 func TestNodeOpenDatabaseFromLifecycleStart(t *testing.T) {
 	stack, _ := New(testNodeConfig())
@@ -436,6 +482,50 @@ func TestRegisterHandler_Unsuccessful(t *testing.T) {
 	node.RegisterHandler("test", "/test", handler)
 }
 
+// Tests that WebSocket handlers mounted on the node are served on the WebSocket
+// endpoint and subject to its origin policy.
+func TestRegisterWSHandler(t *testing.T) {
+	conf := &Config{
+		HTTPHost:  "127.0.0.1",
+		WSHost:    "127.0.0.1",
+		WSOrigins: []string{"http://test"},
+	}
+	node, err := New(conf)
+	if err != nil {
+		t.Fatalf("could not create a new node: %v", err)
+	}
+	defer node.Close()
+
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	node.RegisterWSHandler("test", "/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte("success"))
+		conn.Close()
+	}))
+	if err := node.Start(); err != nil {
+		t.Fatalf("could not start node: %v", err)
+	}
+	url := node.WSEndpoint() + "/test"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://test"}})
+	if err != nil {
+		t.Fatalf("could not dial handler: %v", err)
+	}
+	defer conn.Close()
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("could not read message: %v", err)
+	}
+	assert.Equal(t, "success", string(msg))
+
+	if err := wsRequest(t, url, "http://evil"); err == nil {
+		t.Fatalf("request with disallowed origin accepted")
+	}
+}
+
 // Tests whether websocket requests can be handled on the same port as a regular http server.
 func TestWebsocketHTTPOnSamePort_WebsocketRequest(t *testing.T) {
 	node := startHTTP(t, 0, 0)
@@ -39,19 +39,24 @@ type httpConfig struct {
 	Modules            []string
 	CorsAllowedOrigins []string
 	Vhosts             []string
-	prefix             string // path prefix on which to mount http handler
+	prefix             string       // path prefix on which to mount http handler
+	jwtSecret          []byte       // HS256 secret authenticating requests, nil if disabled
+	limiter            *rpc.Limiter // limits of the clients, shared with the other endpoints
 }
 
 // wsConfig is the JSON-RPC/Websocket configuration
 type wsConfig struct {
-	Origins []string
-	Modules []string
-	prefix  string // path prefix on which to mount ws handler
+	Origins   []string
+	Modules   []string
+	prefix    string       // path prefix on which to mount ws handler
+	jwtSecret []byte       // HS256 secret authenticating requests, nil if disabled
+	limiter   *rpc.Limiter // limits of the clients, shared with the other endpoints
 }
 
 type rpcHandler struct {
 	http.Handler
-	server *rpc.Server
+	server      *rpc.Server
+	checkOrigin func(*http.Request) bool // origin policy of the WebSocket handler
 }
 
 type httpServer struct {
@@ -70,18 +75,20 @@ type httpServer struct {
 
 	// WebSocket handler things.
 	wsConfig  wsConfig
-	wsHandler atomic.Value // *rpcHandler
+	wsHandler atomic.Value  // *rpcHandler
+	wsMux     http.ServeMux // WebSocket handlers registered via Node.RegisterWSHandler
 
 	// These are set by setListenAddr.
 	endpoint string
 	host     string
 	port     int
 
-	handlerNames map[string]string
+	handlerNames   map[string]string
+	wsHandlerNames map[string]string
 }
 
 func newHTTPServer(log log.Logger, timeouts rpc.HTTPTimeouts) *httpServer {
-	h := &httpServer{log: log, timeouts: timeouts, handlerNames: make(map[string]string)}
+	h := &httpServer{log: log, timeouts: timeouts, handlerNames: make(map[string]string), wsHandlerNames: make(map[string]string)}
 
 	h.httpHandler.Store((*rpcHandler)(nil))
 	h.wsHandler.Store((*rpcHandler)(nil))
@@ -149,7 +156,15 @@ func (h *httpServer) start() error {
 		if h.wsConfig.prefix != "" {
 			url += h.wsConfig.prefix
 		}
-		h.log.Info("WebSocket enabled", "url", url)
+		h.log.Info("WebSocket enabled", "url", url, "auth", h.wsConfig.jwtSecret != nil)
+
+		logged := make(map[string]bool, len(h.wsHandlerNames))
+		for path, name := range h.wsHandlerNames {
+			if !logged[name] {
+				h.log.Info(name+" enabled", "url", fmt.Sprintf("ws://%v%s", listener.Addr(), path))
+				logged[name] = true
+			}
+		}
 	}
 	// if server is websocket only, return after logging
 	if !h.rpcAllowed() {
@@ -161,6 +176,7 @@ func (h *httpServer) start() error {
 		"prefix", h.httpConfig.prefix,
 		"cors", strings.Join(h.httpConfig.CorsAllowedOrigins, ","),
 		"vhosts", strings.Join(h.httpConfig.Vhosts, ","),
+		"auth", h.httpConfig.jwtSecret != nil,
 	)
 
 	// Log all handlers mounted on server.
@@ -184,6 +200,16 @@ func (h *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// check if ws request and serve if ws enabled
 	ws := h.wsHandler.Load().(*rpcHandler)
 	if ws != nil && isWebsocket(r) {
+		// WebSocket services mounted via the node take precedence over RPC,
+		// and are subject to the same origin policy.
+		if handler, pattern := h.wsMux.Handler(r); pattern != "" {
+			if !ws.checkOrigin(r) {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			newJWTHandler(h.wsConfig.jwtSecret, handler).ServeHTTP(w, r)
+			return
+		}
 		if checkPath(r, h.wsConfig.prefix) {
 			ws.ServeHTTP(w, r)
 		}
@@ -198,7 +224,7 @@ func (h *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// These are made available when RPC is enabled.
 		muxHandler, pattern := h.mux.Handler(r)
 		if pattern != "" {
-			muxHandler.ServeHTTP(w, r)
+			newJWTHandler(h.httpConfig.jwtSecret, muxHandler).ServeHTTP(w, r)
 			return
 		}
 
@@ -280,12 +306,13 @@ func (h *httpServer) enableRPC(apis []rpc.API, config httpConfig) error {
 
 	// Create RPC server and handler.
 	srv := rpc.NewServer()
+	srv.SetLimiter(config.limiter)
 	if err := RegisterApis(apis, config.Modules, srv, false); err != nil {
 		return err
 	}
 	h.httpConfig = config
 	h.httpHandler.Store(&rpcHandler{
-		Handler: NewHTTPHandlerStack(srv, config.CorsAllowedOrigins, config.Vhosts),
+		Handler: NewHTTPHandlerStack(newJWTHandler(config.jwtSecret, srv), config.CorsAllowedOrigins, config.Vhosts),
 		server:  srv,
 	})
 	return nil
@@ -312,13 +339,15 @@ func (h *httpServer) enableWS(apis []rpc.API, config wsConfig) error {
 
 	// Create RPC server and handler.
 	srv := rpc.NewServer()
+	srv.SetLimiter(config.limiter)
 	if err := RegisterApis(apis, config.Modules, srv, false); err != nil {
 		return err
 	}
 	h.wsConfig = config
 	h.wsHandler.Store(&rpcHandler{
-		Handler: srv.WebsocketHandler(config.Origins),
-		server:  srv,
+		Handler:     newJWTHandler(config.jwtSecret, srv.WebsocketHandler(config.Origins)),
+		server:      srv,
+		checkOrigin: rpc.WebsocketOriginValidator(config.Origins),
 	})
 	return nil
 }
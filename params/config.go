@@ -144,6 +144,42 @@ type CheckpointOracleConfig struct {
 	Threshold uint64           `json:"threshold"`
 }
 
+// GuardianConfig represents the M-of-N key set controlling the TeWaka circuit
+// breaker. An action takes effect once Threshold distinct guardians approved it.
+type GuardianConfig struct {
+	Guardians []common.Address `json:"guardians"`
+	Threshold uint64           `json:"threshold"`
+}
+
+// IsGuardian reports whether addr is part of the guardian set.
+func (c *GuardianConfig) IsGuardian(addr common.Address) bool {
+	for _, guardian := range c.Guardians {
+		if guardian == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// GovernorConfig represents the M-of-N key set governing the TeWaka parameter
+// store. A parameter change is scheduled once Threshold distinct governors
+// approved it and can be enacted Delay blocks later.
+type GovernorConfig struct {
+	Governors []common.Address `json:"governors"`
+	Threshold uint64           `json:"threshold"`
+	Delay     uint64           `json:"delay"`
+}
+
+// IsGovernor reports whether addr is part of the governor set.
+func (c *GovernorConfig) IsGovernor(addr common.Address) bool {
+	for _, governor := range c.Governors {
+		if governor == addr {
+			return true
+		}
+	}
+	return false
+}
+
 // ChainConfig is the core config which determines the blockchain settings.
 //
 // ChainConfig is stored in the database on a per block basis. This means
@@ -166,6 +202,11 @@ type ChainConfig struct {
 	VerifySwitch bool                    `json:"verify_switch"`
 	SideClients  map[uint8][]*rpc.Client `json:"side_clients"`
 
+	TeWakaGuardians       *GuardianConfig `json:"tewakaGuardians,omitempty"`       // Guardians allowed to trip the TeWaka circuit breaker (nil = disabled)
+	TeWakaGuardianBlock   *big.Int        `json:"tewakaGuardianBlock,omitempty"`   // TeWaka circuit breaker switch block (nil = no fork, 0 = already activated)
+	TeWakaGovernors       *GovernorConfig `json:"tewakaGovernors,omitempty"`       // Governors allowed to change the TeWaka parameters (nil = disabled)
+	TeWakaGovernanceBlock *big.Int        `json:"tewakaGovernanceBlock,omitempty"` // TeWaka parameter governance switch block (nil = no fork, 0 = already activated)
+
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
@@ -251,6 +292,20 @@ func (c *ChainConfig) IsCIP5(num *big.Int) bool {
 	return isForked(c.CIP_5, num)
 }
 
+// IsTeWakaGuardians returns whether num is either equal to the TeWaka circuit
+// breaker fork block or greater, with a guardian set configured. Before it the
+// networks can't be paused and their volume isn't limited.
+func (c *ChainConfig) IsTeWakaGuardians(num *big.Int) bool {
+	return c.TeWakaGuardians != nil && isForked(c.TeWakaGuardianBlock, num)
+}
+
+// IsTeWakaGovernance returns whether num is either equal to the TeWaka governance
+// fork block or greater, with a governor set configured. Before it the TeWaka
+// parameters keep their original values.
+func (c *ChainConfig) IsTeWakaGovernance(num *big.Int) bool {
+	return c.TeWakaGovernors != nil && isForked(c.TeWakaGovernanceBlock, num)
+}
+
 // IsEWASM returns whether num represents a block number after the EWASM fork
 func (c *ChainConfig) IsEWASM(num *big.Int) bool {
 	return isForked(c.EWASMBlock, num)
@@ -278,6 +333,12 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.EWASMBlock, newcfg.EWASMBlock, head) {
 		return newCompatError("ewasm fork block", c.EWASMBlock, newcfg.EWASMBlock)
 	}
+	if isForkIncompatible(c.TeWakaGuardianBlock, newcfg.TeWakaGuardianBlock, head) {
+		return newCompatError("TeWaka circuit breaker fork block", c.TeWakaGuardianBlock, newcfg.TeWakaGuardianBlock)
+	}
+	if isForkIncompatible(c.TeWakaGovernanceBlock, newcfg.TeWakaGovernanceBlock, head) {
+		return newCompatError("TeWaka governance fork block", c.TeWakaGovernanceBlock, newcfg.TeWakaGovernanceBlock)
+	}
 	return nil
 }
 
@@ -54,6 +54,7 @@ var (
 	_ Error = new(invalidRequestError)
 	_ Error = new(invalidMessageError)
 	_ Error = new(invalidParamsError)
+	_ Error = new(limitExceededError)
 )
 
 const defaultErrorCode = -32000
@@ -101,3 +102,16 @@ type invalidParamsError struct{ message string }
 func (e *invalidParamsError) ErrorCode() int { return -32602 }
 
 func (e *invalidParamsError) Error() string { return e.message }
+
+// limitExceededError is returned when a request exceeds the limits of the server.
+type limitExceededError struct{ message string }
+
+func (e *limitExceededError) ErrorCode() int { return -32005 }
+
+func (e *limitExceededError) Error() string { return e.message }
+
+var (
+	errRateLimited      = &limitExceededError{"rate limit exceeded"}
+	errBatchTooLarge    = &limitExceededError{"batch too large"}
+	errResponseTooLarge = &limitExceededError{"response too large"}
+)
@@ -61,6 +61,8 @@ type handler struct {
 	conn           jsonWriter                     // where responses will be sent
 	log            log.Logger
 	allowSubscribe bool
+	limiter        *Limiter // limits of the serving server, nil if unlimited
+	client         string   // client identity for rate limiting
 
 	subLock    sync.Mutex
 	serverSubs map[ID]*Subscription
@@ -84,7 +86,9 @@ func newHandler(connCtx context.Context, conn jsonWriter, idgen func() ID, reg *
 		allowSubscribe: true,
 		serverSubs:     make(map[ID]*Subscription),
 		log:            log.Root(),
+		limiter:        limiterFromContext(connCtx),
 	}
+	h.client = clientID(connCtx, conn.remoteAddr())
 	if conn.remoteAddr() != "" {
 		h.log = h.log.New("conn", conn.remoteAddr())
 	}
@@ -101,6 +105,13 @@ func (h *handler) handleBatch(msgs []*jsonrpcMessage) {
 		})
 		return
 	}
+	if h.limiter.batchTooLarge(len(msgs)) {
+		batchLimitedMeter.Mark(1)
+		h.startCallProc(func(cp *callProc) {
+			h.conn.writeJSON(cp.ctx, errorMessage(errBatchTooLarge))
+		})
+		return
+	}
 
 	// Handle non-call messages first:
 	calls := make([]*jsonrpcMessage, 0, len(msgs))
@@ -114,9 +125,24 @@ func (h *handler) handleBatch(msgs []*jsonrpcMessage) {
 	}
 	// Process calls on a goroutine because they may block indefinitely:
 	h.startCallProc(func(cp *callProc) {
-		answers := make([]*jsonrpcMessage, 0, len(msgs))
+		var (
+			answers = make([]*jsonrpcMessage, 0, len(msgs))
+			size    int
+		)
 		for _, msg := range calls {
+			// Once the response limit is hit, the remaining calls are refused
+			// rather than executed.
+			if h.limiter.responseTooLarge(size) {
+				if msg.isCall() {
+					answers = append(answers, msg.errorResponse(errResponseTooLarge))
+				}
+				continue
+			}
 			if answer := h.handleCallMsg(cp, msg); answer != nil {
+				if size += len(answer.Result); h.limiter.responseTooLarge(size) {
+					responseLimitedMeter.Mark(1)
+					answer = msg.errorResponse(errResponseTooLarge)
+				}
 				answers = append(answers, answer)
 			}
 		}
@@ -137,6 +163,10 @@ func (h *handler) handleMsg(msg *jsonrpcMessage) {
 	}
 	h.startCallProc(func(cp *callProc) {
 		answer := h.handleCallMsg(cp, msg)
+		if answer != nil && h.limiter.responseTooLarge(len(answer.Result)) {
+			responseLimitedMeter.Mark(1)
+			answer = msg.errorResponse(errResponseTooLarge)
+		}
 		h.addSubscriptions(cp.notifiers)
 		if answer != nil {
 			h.conn.writeJSON(cp.ctx, answer)
@@ -317,6 +347,13 @@ func (h *handler) handleCallMsg(ctx *callProc, msg *jsonrpcMessage) *jsonrpcMess
 
 // handleCall processes method calls.
 func (h *handler) handleCall(cp *callProc, msg *jsonrpcMessage) *jsonrpcMessage {
+	if !namespaceAllowed(h.rootCtx, msg.namespace()) {
+		return msg.errorResponse(&methodNotFoundError{method: msg.Method})
+	}
+	if !h.limiter.allow(h.client, msg.Method) {
+		rateLimitedMeter.Mark(1)
+		return msg.errorResponse(errRateLimited)
+	}
 	if msg.isSubscribe() {
 		return h.handleSubscribe(cp, msg)
 	}
@@ -0,0 +1,143 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/time/rate"
+)
+
+// maxRateLimitedClients is the number of client token buckets tracked by a
+// server. The least recently seen clients are forgotten first.
+const maxRateLimitedClients = 16384
+
+// DefaultMethodCosts are the tokens charged for expensive methods. Methods not
+// listed cost a single token.
+var DefaultMethodCosts = map[string]int{
+	"eth_call":                       2,
+	"eth_estimateGas":                2,
+	"eth_simulateV1":                 20,
+	"eth_getLogs":                    10,
+	"eth_getBlockReceipts":           5,
+	"eth_getFilterLogs":              10,
+	"czz_getLogsPaged":               10,
+	"debug_traceTransaction":         20,
+	"debug_traceCall":                20,
+	"debug_traceCallMany":            50,
+	"debug_traceBlock":               50,
+	"debug_traceBlockByNumber":       50,
+	"debug_traceBlockByHash":         50,
+	"debug_standardTraceBlockToFile": 50,
+	"trace_transaction":              20,
+	"trace_replayTransaction":        20,
+	"trace_block":                    50,
+	"trace_replayBlockTransactions":  50,
+	"trace_filter":                   100,
+}
+
+// Limits configures the resources a single client may consume. Zero values
+// disable the respective limit, nothing is limited by default.
+type Limits struct {
+	BatchItems    int            // Maximum number of calls in a batch request
+	ResponseBytes int            // Maximum size of the results returned for a request
+	RateLimit     float64        // Tokens granted to each client per second
+	RateBurst     int            // Token bucket size of each client
+	MethodCosts   map[string]int // Tokens charged per method, DefaultMethodCosts if nil
+}
+
+// Limiter enforces Limits for the clients of one or more servers. Clients are
+// identified by the subject they authenticated as, or their IP address, so the
+// servers sharing a limiter share the request budget of each client.
+type Limiter struct {
+	Limits
+	buckets *lru.Cache // client -> *rate.Limiter
+}
+
+// NewLimiter creates a limiter enforcing the given limits.
+func NewLimiter(limits Limits) *Limiter {
+	if limits.MethodCosts == nil {
+		limits.MethodCosts = DefaultMethodCosts
+	}
+	l := &Limiter{Limits: limits}
+	if limits.RateLimit > 0 {
+		l.buckets, _ = lru.New(maxRateLimitedClients)
+	}
+	return l
+}
+
+// allow charges the cost of a method call to the client, reporting whether it
+// had sufficient tokens.
+func (l *Limiter) allow(client, method string) bool {
+	if l == nil || l.buckets == nil || client == "" {
+		return true
+	}
+	burst := l.RateBurst
+	if burst <= 0 {
+		burst = 1
+	}
+	var bucket *rate.Limiter
+	if cached, ok := l.buckets.Get(client); ok {
+		bucket = cached.(*rate.Limiter)
+	} else {
+		bucket = rate.NewLimiter(rate.Limit(l.RateLimit), burst)
+		if prev, ok, _ := l.buckets.PeekOrAdd(client, bucket); ok {
+			bucket = prev.(*rate.Limiter)
+		}
+	}
+	cost, ok := l.MethodCosts[method]
+	if !ok || cost < 1 {
+		cost = 1
+	}
+	if cost > burst {
+		cost = burst
+	}
+	return bucket.AllowN(time.Now(), cost)
+}
+
+// batchTooLarge reports whether a batch exceeds the item limit.
+func (l *Limiter) batchTooLarge(items int) bool {
+	return l != nil && l.BatchItems > 0 && items > l.BatchItems
+}
+
+// responseTooLarge reports whether a response of the given size exceeds the
+// response limit.
+func (l *Limiter) responseTooLarge(size int) bool {
+	return l != nil && l.ResponseBytes > 0 && size > l.ResponseBytes
+}
+
+type limiterKey struct{}
+
+// limiterFromContext returns the limiter of the server handling a connection.
+func limiterFromContext(ctx context.Context) *Limiter {
+	l, _ := ctx.Value(limiterKey{}).(*Limiter)
+	return l
+}
+
+// clientID identifies the client of a connection for rate limiting.
+func clientID(ctx context.Context, remoteAddr string) string {
+	if subject, ok := ctx.Value(subjectKey{}).(string); ok && subject != "" {
+		return "sub:" + subject
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// dialLimited starts an HTTP server enforcing limits and connects to it.
+func dialLimited(t *testing.T, limits Limits) (*Client, func()) {
+	server := newTestServer()
+	server.SetLimits(limits)
+	ts := httptest.NewServer(server)
+
+	client, err := DialHTTP(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, func() {
+		client.Close()
+		ts.Close()
+		server.Stop()
+	}
+}
+
+func checkLimitError(t *testing.T, err error, message string) {
+	t.Helper()
+
+	var rpcErr Error
+	if !errors.As(err, &rpcErr) || rpcErr.ErrorCode() != -32005 || rpcErr.Error() != message {
+		t.Fatalf("error mismatch: have %v, want %q", err, message)
+	}
+}
+
+func TestBatchItemLimit(t *testing.T) {
+	client, stop := dialLimited(t, Limits{BatchItems: 2})
+	defer stop()
+
+	batch := make([]BatchElem, 2)
+	for i := range batch {
+		batch[i] = BatchElem{Method: "test_echo", Args: []interface{}{"x", 1}, Result: new(echoResult)}
+	}
+	if err := client.BatchCall(batch); err != nil {
+		t.Fatal(err)
+	}
+	for i, elem := range batch {
+		if elem.Error != nil {
+			t.Fatalf("call %d failed: %v", i, elem.Error)
+		}
+	}
+	batch = append(batch, BatchElem{Method: "test_echo", Args: []interface{}{"x", 1}, Result: new(echoResult)})
+	if err := client.BatchCall(batch); err == nil {
+		t.Fatal("oversized batch accepted")
+	}
+}
+
+func TestResponseSizeLimit(t *testing.T) {
+	client, stop := dialLimited(t, Limits{ResponseBytes: 64})
+	defer stop()
+
+	var result echoResult
+	if err := client.Call(&result, "test_echo", "x", 1); err != nil {
+		t.Fatal(err)
+	}
+	err := client.Call(&result, "test_echo", strings.Repeat("x", 64), 1)
+	checkLimitError(t, err, "response too large")
+
+	// The limit applies to the responses of a batch combined.
+	batch := make([]BatchElem, 4)
+	for i := range batch {
+		batch[i] = BatchElem{Method: "test_echo", Args: []interface{}{"xxxxxxxxxx", 1}, Result: new(echoResult)}
+	}
+	if err := client.BatchCall(batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].Error != nil {
+		t.Fatalf("first call failed: %v", batch[0].Error)
+	}
+	checkLimitError(t, batch[3].Error, "response too large")
+}
+
+func TestRateLimit(t *testing.T) {
+	client, stop := dialLimited(t, Limits{RateLimit: 0.001, RateBurst: 3, MethodCosts: map[string]int{"test_echo": 2}})
+	defer stop()
+
+	var result echoResult
+	if err := client.Call(&result, "test_echo", "x", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call(nil, "test_noArgsRets"); err != nil {
+		t.Fatal(err)
+	}
+	checkLimitError(t, client.Call(nil, "test_noArgsRets"), "rate limit exceeded")
+}
+
+// Tests that servers sharing a limiter charge the same budget of a client.
+func TestSharedRateLimit(t *testing.T) {
+	limiter := NewLimiter(Limits{RateLimit: 0.001, RateBurst: 2})
+
+	var clients []*Client
+	for i := 0; i < 2; i++ {
+		server := newTestServer()
+		server.SetLimiter(limiter)
+		ts := httptest.NewServer(server)
+		defer ts.Close()
+		defer server.Stop()
+
+		client, err := DialHTTP(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+	for _, client := range clients {
+		if err := client.Call(nil, "test_noArgsRets"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, client := range clients {
+		checkLimitError(t, client.Call(nil, "test_noArgsRets"), "rate limit exceeded")
+	}
+}
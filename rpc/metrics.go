@@ -27,6 +27,10 @@ var (
 	successfulRequestGauge = metrics.NewRegisteredGauge("rpc/success", nil)
 	failedReqeustGauge     = metrics.NewRegisteredGauge("rpc/failure", nil)
 	rpcServingTimer        = metrics.NewRegisteredTimer("rpc/duration/all", nil)
+
+	rateLimitedMeter     = metrics.NewRegisteredMeter("rpc/limits/rate", nil)
+	batchLimitedMeter    = metrics.NewRegisteredMeter("rpc/limits/batch", nil)
+	responseLimitedMeter = metrics.NewRegisteredMeter("rpc/limits/response", nil)
 )
 
 func newRPCServingTimer(method string, valid bool) metrics.Timer {
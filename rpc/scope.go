@@ -0,0 +1,62 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import "context"
+
+type (
+	namespacesKey struct{}
+	subjectKey    struct{}
+)
+
+// WithNamespaces returns a copy of ctx which restricts the requests served with
+// it to the given API namespaces. It is used by HTTP middleware to scope the
+// calls an authenticated client may make. The "rpc" namespace describing the
+// server is always available.
+func WithNamespaces(ctx context.Context, namespaces []string) context.Context {
+	allowed := make(map[string]bool, len(namespaces)+1)
+	for _, ns := range namespaces {
+		allowed[ns] = true
+	}
+	allowed[MetadataApi] = true
+	return context.WithValue(ctx, namespacesKey{}, allowed)
+}
+
+// WithSubject returns a copy of ctx which identifies the client of the requests
+// served with it by the given authenticated subject rather than its address.
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// namespaceAllowed reports whether calls to the namespace may be served in ctx.
+func namespaceAllowed(ctx context.Context, namespace string) bool {
+	allowed, ok := ctx.Value(namespacesKey{}).(map[string]bool)
+	return !ok || allowed[namespace]
+}
+
+// scopeFromRequest carries the namespace restriction and subject of ctx, if
+// any, over to a fresh context.
+func scopeFromRequest(ctx context.Context) context.Context {
+	out := context.Background()
+	if allowed, ok := ctx.Value(namespacesKey{}).(map[string]bool); ok {
+		out = context.WithValue(out, namespacesKey{}, allowed)
+	}
+	if subject, ok := ctx.Value(subjectKey{}).(string); ok {
+		out = context.WithValue(out, subjectKey{}, subject)
+	}
+	return out
+}
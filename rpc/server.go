@@ -46,6 +46,7 @@ type Server struct {
 	idgen    func() ID
 	run      int32
 	codecs   mapset.Set
+	limiter  *Limiter
 }
 
 // NewServer creates a new server instance with no registered handlers.
@@ -58,6 +59,19 @@ func NewServer() *Server {
 	return server
 }
 
+// SetLimits configures the limits applied to the clients of the server. It must
+// be called before the server starts serving requests.
+func (s *Server) SetLimits(limits Limits) {
+	s.SetLimiter(NewLimiter(limits))
+}
+
+// SetLimiter configures the limiter applied to the clients of the server, which
+// may be shared with other servers. It must be called before the server starts
+// serving requests.
+func (s *Server) SetLimiter(limiter *Limiter) {
+	s.limiter = limiter
+}
+
 // RegisterName creates a service for the given receiver type under the given name. When no
 // methods on the given receiver match the criteria to be either a RPC method or a
 // subscription an error is returned. Otherwise a new service is created and added to the
@@ -72,6 +86,12 @@ func (s *Server) RegisterName(name string, receiver interface{}) error {
 //
 // Note that codec options are no longer supported.
 func (s *Server) ServeCodec(codec ServerCodec, options CodecOption) {
+	s.serveCodec(context.Background(), codec)
+}
+
+// serveCodec is ServeCodec with a base context for the connection, which carries
+// the restrictions of the request that opened it.
+func (s *Server) serveCodec(ctx context.Context, codec ServerCodec) {
 	defer codec.close()
 
 	// Don't serve if server is stopped.
@@ -83,7 +103,7 @@ func (s *Server) ServeCodec(codec ServerCodec, options CodecOption) {
 	s.codecs.Add(codec)
 	defer s.codecs.Remove(codec)
 
-	c := initClient(codec, s.idgen, &s.services)
+	c := initClient(s.connContext(ctx), codec, s.idgen, &s.services)
 	<-codec.closed()
 	c.Close()
 }
@@ -97,7 +117,7 @@ func (s *Server) serveSingleRequest(ctx context.Context, codec ServerCodec) {
 		return
 	}
 
-	h := newHandler(ctx, codec, s.idgen, &s.services)
+	h := newHandler(s.connContext(ctx), codec, s.idgen, &s.services)
 	h.allowSubscribe = false
 	defer h.close(io.EOF, nil)
 
@@ -115,6 +135,14 @@ func (s *Server) serveSingleRequest(ctx context.Context, codec ServerCodec) {
 	}
 }
 
+// connContext attaches the limits of the server to a connection context.
+func (s *Server) connContext(ctx context.Context) context.Context {
+	if s.limiter == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, limiterKey{}, s.limiter)
+}
+
 // Stop stops reading new requests, waits for stopPendingRequestTimeout to allow pending
 // requests to finish, then closes all codecs which will cancel pending requests and
 // subscriptions.
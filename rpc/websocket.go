@@ -60,10 +60,16 @@ func (s *Server) WebsocketHandler(allowedOrigins []string) http.Handler {
 			return
 		}
 		codec := newWebsocketCodec(conn)
-		s.ServeCodec(codec, 0)
+		s.serveCodec(scopeFromRequest(r.Context()), codec)
 	})
 }
 
+// WebsocketOriginValidator returns the origin check WebsocketHandler performs
+// during the upgrade, for WebSocket services served next to the RPC handler.
+func WebsocketOriginValidator(allowedOrigins []string) func(*http.Request) bool {
+	return wsHandshakeValidator(allowedOrigins)
+}
+
 // wsHandshakeValidator returns a handler that verifies the origin during the
 // websocket upgrade process. When a '*' is specified as an allowed origins all
 // connections are accepted.
@@ -246,6 +252,9 @@ func newWebsocketCodec(conn *websocket.Conn) ServerCodec {
 		conn:      conn,
 		pingReset: make(chan struct{}, 1),
 	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		wc.jsonCodec.remote = addr.String()
+	}
 	wc.wg.Add(1)
 	go wc.pingLoop()
 	return wc
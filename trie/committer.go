@@ -23,6 +23,7 @@ import (
 
 	"github.com/classzz/go-classzz-v2/common"
 	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/rlp"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -49,6 +50,8 @@ type committer struct {
 
 	onleaf LeafCallback
 	leafCh chan *leaf
+
+	nodes map[string]*pathNode // Committed nodes keyed by path, nil unless using the path scheme
 }
 
 // committers live in a global sync.Pool
@@ -69,6 +72,7 @@ func newCommitter() *committer {
 func returnCommitterToPool(h *committer) {
 	h.onleaf = nil
 	h.leafCh = nil
+	h.nodes = nil
 	committerPool.Put(h)
 }
 
@@ -77,7 +81,7 @@ func (c *committer) Commit(n node, db *Database) (hashNode, error) {
 	if db == nil {
 		return nil, errors.New("no db provided")
 	}
-	h, err := c.commit(n, db)
+	h, err := c.commit(nil, n, db)
 	if err != nil {
 		return nil, err
 	}
@@ -85,7 +89,7 @@ func (c *committer) Commit(n node, db *Database) (hashNode, error) {
 }
 
 // commit collapses a node down into a hash node and inserts it into the database
-func (c *committer) commit(n node, db *Database) (node, error) {
+func (c *committer) commit(path []byte, n node, db *Database) (node, error) {
 	// if this path is clean, use available cached data
 	hash, dirty := n.cache()
 	if hash != nil && !dirty {
@@ -100,7 +104,7 @@ func (c *committer) commit(n node, db *Database) (node, error) {
 		// If the child is fullnode, recursively commit.
 		// Otherwise it can only be hashNode or valueNode.
 		if _, ok := cn.Val.(*fullNode); ok {
-			childV, err := c.commit(cn.Val, db)
+			childV, err := c.commit(append(path, cn.Key...), cn.Val, db)
 			if err != nil {
 				return nil, err
 			}
@@ -108,20 +112,20 @@ func (c *committer) commit(n node, db *Database) (node, error) {
 		}
 		// The key needs to be copied, since we're delivering it to database
 		collapsed.Key = hexToCompact(cn.Key)
-		hashedNode := c.store(collapsed, db)
+		hashedNode := c.store(path, collapsed, db)
 		if hn, ok := hashedNode.(hashNode); ok {
 			return hn, nil
 		}
 		return collapsed, nil
 	case *fullNode:
-		hashedKids, err := c.commitChildren(cn, db)
+		hashedKids, err := c.commitChildren(path, cn, db)
 		if err != nil {
 			return nil, err
 		}
 		collapsed := cn.copy()
 		collapsed.Children = hashedKids
 
-		hashedNode := c.store(collapsed, db)
+		hashedNode := c.store(path, collapsed, db)
 		if hn, ok := hashedNode.(hashNode); ok {
 			return hn, nil
 		}
@@ -135,7 +139,7 @@ func (c *committer) commit(n node, db *Database) (node, error) {
 }
 
 // commitChildren commits the children of the given fullnode
-func (c *committer) commitChildren(n *fullNode, db *Database) ([17]node, error) {
+func (c *committer) commitChildren(path []byte, n *fullNode, db *Database) ([17]node, error) {
 	var children [17]node
 	for i := 0; i < 16; i++ {
 		child := n.Children[i]
@@ -152,7 +156,7 @@ func (c *committer) commitChildren(n *fullNode, db *Database) ([17]node, error)
 		// Commit the child recursively and store the "hashed" value.
 		// Note the returned node can be some embedded nodes, so it's
 		// possible the type is not hashnode.
-		hashed, err := c.commit(child, db)
+		hashed, err := c.commit(append(path, byte(i)), child, db)
 		if err != nil {
 			return children, err
 		}
@@ -168,7 +172,7 @@ func (c *committer) commitChildren(n *fullNode, db *Database) ([17]node, error)
 // store hashes the node n and if we have a storage layer specified, it writes
 // the key/value pair to it and tracks any node->child references as well as any
 // node->external trie references.
-func (c *committer) store(n node, db *Database) node {
+func (c *committer) store(path []byte, n node, db *Database) node {
 	// Larger nodes are replaced by their hash and stored in the database.
 	var (
 		hash, _ = n.cache()
@@ -179,12 +183,26 @@ func (c *committer) store(n node, db *Database) node {
 		// In theory we should apply the leafCall here if it's not nil(embedded
 		// node usually contains value). But small value(less than 32bytes) is
 		// not our target.
+		//
+		// Under the path scheme, a standalone node previously stored at the
+		// same path must be deleted as it's embedded in the parent now.
+		if c.nodes != nil {
+			c.nodes[string(path)] = &pathNode{}
+		}
 		return n
 	} else {
 		// We have the hash already, estimate the RLP encoding-size of the node.
 		// The size is used for mem tracking, does not need to be exact
 		size = estimateSize(n)
 	}
+	// Under the path scheme, track the node by its position
+	if c.nodes != nil {
+		blob, err := rlp.EncodeToBytes(simplifyNode(n))
+		if err != nil {
+			panic(err)
+		}
+		c.nodes[string(path)] = &pathNode{hash: common.BytesToHash(hash), blob: blob}
+	}
 	// If we're using channel-based leaf-reporting, send to channel.
 	// The leaf channel will be active only when there an active leaf-callback
 	if c.leafCh != nil {
@@ -193,7 +211,7 @@ func (c *committer) store(n node, db *Database) node {
 			hash: common.BytesToHash(hash),
 			node: n,
 		}
-	} else if db != nil {
+	} else if db != nil && c.nodes == nil {
 		// No leaf-callback used, but there's still a database. Do serial
 		// insertion
 		db.lock.Lock()
@@ -211,10 +229,13 @@ func (c *committer) commitLoop(db *Database) {
 			size = item.size
 			n    = item.node
 		)
-		// We are pooling the trie nodes into an intermediate memory cache
-		db.lock.Lock()
-		db.insert(hash, size, n)
-		db.lock.Unlock()
+		// We are pooling the trie nodes into an intermediate memory cache,
+		// unless they are tracked by position under the path scheme
+		if db.path == nil {
+			db.lock.Lock()
+			db.insert(hash, size, n)
+			db.lock.Unlock()
+		}
 
 		if c.onleaf != nil {
 			switch n := n.(type) {
@@ -88,6 +88,8 @@ type Database struct {
 	childrenSize  common.StorageSize // Storage size of the external children tracking
 	preimagesSize common.StorageSize // Storage size of the preimages cache
 
+	path *pathDB // Path based node storage, nil if nodes are keyed by hash
+
 	lock sync.RWMutex
 }
 
@@ -274,9 +276,11 @@ func expandNode(hash hashNode, n node) node {
 
 // Config defines all necessary options for database.
 type Config struct {
-	Cache     int    // Memory allowance (MB) to use for caching trie nodes in memory
-	Journal   string // Journal of clean cache to survive node restarts
-	Preimages bool   // Flag whether the preimage of trie key is recorded
+	Cache        int    // Memory allowance (MB) to use for caching trie nodes in memory
+	Journal      string // Journal of clean cache to survive node restarts
+	Preimages    bool   // Flag whether the preimage of trie key is recorded
+	Scheme       string // Node storage scheme, the hash scheme if empty
+	ReverseDiffs uint64 // Number of reverse state diffs to retain (path scheme only)
 }
 
 // NewDatabase creates a new trie database to store ephemeral trie content before
@@ -308,9 +312,24 @@ func NewDatabaseWithConfig(diskdb czzdb.KeyValueStore, config *Config) *Database
 	if config == nil || config.Preimages { // TODO(karalabe): Flip to default off in the future
 		db.preimages = make(map[common.Hash][]byte)
 	}
+	if config != nil && config.Scheme == rawdb.PathScheme {
+		history := uint64(DefaultReverseDiffs)
+		if config.ReverseDiffs > 0 {
+			history = config.ReverseDiffs
+		}
+		db.path = newPathDB(diskdb, history)
+	}
 	return db
 }
 
+// Scheme returns the node storage scheme used by the database.
+func (db *Database) Scheme() string {
+	if db.path != nil {
+		return rawdb.PathScheme
+	}
+	return rawdb.HashScheme
+}
+
 // DiskDB retrieves the persistent storage backing the trie database.
 func (db *Database) DiskDB() czzdb.KeyValueStore {
 	return db.diskdb
@@ -403,8 +422,57 @@ func (db *Database) node(hash common.Hash) node {
 	return mustDecodeNode(hash[:], enc)
 }
 
+// nodeAt retrieves a trie node at the given position of the given trie from
+// memory or disk, or returns nil if none can be found. The position is only
+// relevant for the path scheme, otherwise the node is looked up by hash.
+func (db *Database) nodeAt(owner common.Hash, path []byte, hash common.Hash) node {
+	if db.path == nil {
+		return db.node(hash)
+	}
+	enc := db.nodeBlobAt(owner, path, hash)
+	if enc == nil {
+		return nil
+	}
+	return mustDecodeNode(hash[:], enc)
+}
+
+// nodeBlobAt retrieves the encoded trie node at the given position of the given
+// trie from memory or disk. The position is only relevant for the path scheme,
+// otherwise the node is looked up by hash.
+func (db *Database) nodeBlobAt(owner common.Hash, path []byte, hash common.Hash) []byte {
+	if db.path == nil {
+		enc, _ := db.Node(hash)
+		return enc
+	}
+	// Nodes are content addressed, so the clean cache is position independent
+	if db.cleans != nil {
+		if enc := db.cleans.Get(nil, hash[:]); enc != nil {
+			memcacheCleanHitMeter.Mark(1)
+			memcacheCleanReadMeter.Mark(int64(len(enc)))
+			return enc
+		}
+	}
+	enc, disk := db.path.node(owner, path, hash)
+	if enc == nil {
+		memcacheDirtyMissMeter.Mark(1)
+		return nil
+	}
+	if !disk {
+		memcacheDirtyHitMeter.Mark(1)
+		memcacheDirtyReadMeter.Mark(int64(len(enc)))
+	} else if db.cleans != nil {
+		db.cleans.Set(hash[:], enc)
+		memcacheCleanMissMeter.Mark(1)
+		memcacheCleanWriteMeter.Mark(int64(len(enc)))
+	}
+	return enc
+}
+
 // Node retrieves an encoded cached trie node from memory. If it cannot be found
 // cached, the method queries the persistent database for the content.
+//
+// Under the path scheme nodes cannot be looked up by hash alone, only the clean
+// cache is consulted.
 func (db *Database) Node(hash common.Hash) ([]byte, error) {
 	// It doesn't make sense to retrieve the metaroot
 	if hash == (common.Hash{}) {
@@ -418,6 +486,9 @@ func (db *Database) Node(hash common.Hash) ([]byte, error) {
 			return enc, nil
 		}
 	}
+	if db.path != nil {
+		return nil, errPathUnsupported
+	}
 	// Retrieve the node from the dirty cache if available
 	db.lock.RLock()
 	dirty := db.dirties[hash]
@@ -710,6 +781,15 @@ func (db *Database) Commit(node common.Hash, report bool, callback func(common.H
 		}
 		batch.Reset()
 	}
+	// Under the path scheme, flatten all the layers leading up to the state
+	if db.path != nil {
+		if db.preimages != nil {
+			db.lock.Lock()
+			db.preimages, db.preimagesSize = make(map[common.Hash][]byte), 0
+			db.lock.Unlock()
+		}
+		return db.path.persist(node)
+	}
 	// Move the trie itself into the batch, flushing if enough data is accumulated
 	nodes, storage := len(db.dirties), db.dirtiesSize
 
@@ -837,6 +917,12 @@ func (c *cleaner) Delete(key []byte) error {
 // Size returns the current storage size of the memory cache in front of the
 // persistent database layer.
 func (db *Database) Size() (common.StorageSize, common.StorageSize) {
+	if db.path != nil {
+		db.lock.RLock()
+		preimagesSize := db.preimagesSize
+		db.lock.RUnlock()
+		return db.path.memory(), preimagesSize
+	}
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
@@ -200,13 +200,19 @@ func checkIteratorOrder(want []kvs, it *Iterator) error {
 }
 
 func TestDifferenceIterator(t *testing.T) {
-	triea := newEmpty()
+	for _, scheme := range testSchemes {
+		testDifferenceIterator(t, scheme)
+	}
+}
+
+func testDifferenceIterator(t *testing.T, scheme string) {
+	triea := newEmptyWithScheme(scheme)
 	for _, val := range testdata1 {
 		triea.Update([]byte(val.k), []byte(val.v))
 	}
 	triea.Commit(nil)
 
-	trieb := newEmpty()
+	trieb := newEmptyWithScheme(scheme)
 	for _, val := range testdata2 {
 		trieb.Update([]byte(val.k), []byte(val.v))
 	}
@@ -236,13 +242,19 @@ func TestDifferenceIterator(t *testing.T) {
 }
 
 func TestUnionIterator(t *testing.T) {
-	triea := newEmpty()
+	for _, scheme := range testSchemes {
+		testUnionIterator(t, scheme)
+	}
+}
+
+func testUnionIterator(t *testing.T, scheme string) {
+	triea := newEmptyWithScheme(scheme)
 	for _, val := range testdata1 {
 		triea.Update([]byte(val.k), []byte(val.v))
 	}
 	triea.Commit(nil)
 
-	trieb := newEmpty()
+	trieb := newEmptyWithScheme(scheme)
 	for _, val := range testdata2 {
 		trieb.Update([]byte(val.k), []byte(val.v))
 	}
@@ -513,6 +525,7 @@ func TestNodeIteratorLargeTrie(t *testing.T) {
 	// Create some arbitrary test trie to iterate
 	db, trie, logDb := makeLargeTestTrie()
 	db.Cap(0) // flush everything
+
 	// Do a seek operation
 	trie.NodeIterator(common.FromHex("0x77667766776677766778855885885885"))
 	// master: 24 get operations
@@ -0,0 +1,241 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/rlp"
+)
+
+// EncodeMultiProof packs a set of trie nodes, possibly belonging to several tries,
+// into a compact multiproof. Instead of listing the nodes one by one, every trie
+// is encoded as a single partial trie starting from its root, with the child
+// nodes present in the set embedded in place of their hash references. The hashes
+// are recomputed when decoding, which saves their space and deduplicates the
+// nodes shared by several proofs.
+func EncodeMultiProof(nodes [][]byte) ([]byte, error) {
+	set := make(map[common.Hash][]byte)
+	for _, blob := range nodes {
+		set[crypto.Keccak256Hash(blob)] = blob
+	}
+	// The roots of the partial tries are the nodes not referenced by any other
+	referenced := make(map[common.Hash]bool)
+	for hash, blob := range set {
+		children, err := nodeChildren(blob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node %x: %v", hash, err)
+		}
+		for _, child := range children {
+			referenced[child] = true
+		}
+	}
+	var roots []common.Hash
+	for hash := range set {
+		if !referenced[hash] {
+			roots = append(roots, hash)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool { return bytes.Compare(roots[i][:], roots[j][:]) < 0 })
+
+	var (
+		done  = make(map[common.Hash]bool)
+		tries = make([]rlp.RawValue, 0, len(roots))
+	)
+	for _, root := range roots {
+		done[root] = true
+		enc, err := packNode(set[root], set, done)
+		if err != nil {
+			return nil, err
+		}
+		tries = append(tries, enc)
+	}
+	return rlp.EncodeToBytes(tries)
+}
+
+// DecodeMultiProof unpacks a compact multiproof into the trie nodes it contains,
+// ordered by their hashes.
+func DecodeMultiProof(proof []byte) ([][]byte, error) {
+	var tries []rlp.RawValue
+	if err := rlp.DecodeBytes(proof, &tries); err != nil {
+		return nil, err
+	}
+	set := make(map[common.Hash][]byte)
+	for i, enc := range tries {
+		blob, err := unpackNode(enc, set)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trie %d: %v", i, err)
+		}
+		set[crypto.Keccak256Hash(blob)] = blob // Roots are stored by hash whatever their size
+	}
+	hashes := make([]common.Hash, 0, len(set))
+	for hash, blob := range set {
+		if _, err := decodeNode(hash[:], blob); err != nil {
+			return nil, fmt.Errorf("invalid node %x: %v", hash, err)
+		}
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+
+	nodes := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		nodes[i] = set[hash]
+	}
+	return nodes, nil
+}
+
+// splitNode splits the encoding of a trie node into the raw encodings of its
+// elements: a key and a child or value for short nodes, 16 children and a value
+// for full nodes. It also reports whether a short node holds a value.
+func splitNode(blob []byte) ([][]byte, bool, error) {
+	elems, _, err := rlp.SplitList(blob)
+	if err != nil {
+		return nil, false, err
+	}
+	var raws [][]byte
+	for rest := elems; len(rest) > 0; {
+		_, _, tail, err := rlp.Split(rest)
+		if err != nil {
+			return nil, false, err
+		}
+		raws, rest = append(raws, rest[:len(rest)-len(tail)]), tail
+	}
+	switch len(raws) {
+	case 2:
+		key, _, err := rlp.SplitString(raws[0])
+		if err != nil {
+			return nil, false, err
+		}
+		return raws, hasTerm(compactToHex(key)), nil
+	case 17:
+		return raws, true, nil
+	default:
+		return nil, false, fmt.Errorf("invalid number of list elements: %v", len(raws))
+	}
+}
+
+// childRefs returns the indexes of the elements of a split node which reference
+// child nodes.
+func childRefs(raws [][]byte, value bool) []int {
+	if len(raws) == 2 {
+		if value {
+			return nil
+		}
+		return []int{1}
+	}
+	refs := make([]int, 16)
+	for i := range refs {
+		refs[i] = i
+	}
+	return refs
+}
+
+// nodeChildren returns the hashes of the child nodes a trie node references.
+func nodeChildren(blob []byte) ([]common.Hash, error) {
+	raws, value, err := splitNode(blob)
+	if err != nil {
+		return nil, err
+	}
+	var children []common.Hash
+	for _, i := range childRefs(raws, value) {
+		if kind, val, _, err := rlp.Split(raws[i]); err == nil && kind == rlp.String && len(val) == common.HashLength {
+			children = append(children, common.BytesToHash(val))
+		}
+	}
+	return children, nil
+}
+
+// packNode replaces the hash references of a trie node with the encodings of
+// the referenced nodes present in the set and not yet embedded elsewhere,
+// recursively.
+func packNode(blob []byte, set map[common.Hash][]byte, done map[common.Hash]bool) ([]byte, error) {
+	raws, value, err := splitNode(blob)
+	if err != nil {
+		return nil, err
+	}
+	expanded := false
+	for _, i := range childRefs(raws, value) {
+		kind, val, _, err := rlp.Split(raws[i])
+		if err != nil || kind != rlp.String || len(val) != common.HashLength {
+			continue // Empty or embedded child
+		}
+		hash := common.BytesToHash(val)
+		child, ok := set[hash]
+		if !ok || done[hash] {
+			continue
+		}
+		done[hash] = true
+		if raws[i], err = packNode(child, set, done); err != nil {
+			return nil, err
+		}
+		expanded = true
+	}
+	if !expanded {
+		return blob, nil
+	}
+	return encodeRaws(raws)
+}
+
+// unpackNode reverses packNode, replacing the embedded child nodes which are
+// too large to be embedded in their canonical form with their hash references.
+// The collapsed child nodes are added to the set.
+func unpackNode(enc []byte, set map[common.Hash][]byte) ([]byte, error) {
+	raws, value, err := splitNode(enc)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range childRefs(raws, value) {
+		kind, val, _, err := rlp.Split(raws[i])
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case kind == rlp.List:
+			child, err := unpackNode(raws[i], set)
+			if err != nil {
+				return nil, err
+			}
+			if len(child) < common.HashLength {
+				raws[i] = child // Small enough to be embedded canonically
+				continue
+			}
+			hash := crypto.Keccak256Hash(child)
+			set[hash] = child
+			if raws[i], err = rlp.EncodeToBytes(hash[:]); err != nil {
+				return nil, err
+			}
+		case kind == rlp.String && (len(val) == 0 || len(val) == common.HashLength):
+			// Empty child or reference to a node outside the proof
+		default:
+			return nil, fmt.Errorf("invalid RLP string size %d (want 0 or 32)", len(val))
+		}
+	}
+	return encodeRaws(raws)
+}
+
+// encodeRaws encodes the raw element encodings of a trie node as an RLP list.
+func encodeRaws(raws [][]byte) ([]byte, error) {
+	list := make([]rlp.RawValue, len(raws))
+	for i, raw := range raws {
+		list[i] = raw
+	}
+	return rlp.EncodeToBytes(list)
+}
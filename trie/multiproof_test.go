@@ -0,0 +1,101 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/czzdb/memorydb"
+)
+
+// Tests that the proofs of many keys in several tries can be packed into a
+// multiproof, and verified after unpacking it.
+func TestMultiProof(t *testing.T) {
+	var (
+		tries  []*Trie
+		keys   [][][]byte
+		proofs = memorydb.New()
+		size   int
+	)
+	for i := 0; i < 3; i++ {
+		trie, vals := randomTrie(500)
+		var trieKeys [][]byte
+		for _, kv := range vals {
+			if len(trieKeys) == 50 {
+				break
+			}
+			trieKeys = append(trieKeys, kv.k)
+			if err := trie.Prove(kv.k, 0, proofs); err != nil {
+				t.Fatalf("failed to prove key %x: %v", kv.k, err)
+			}
+		}
+		tries, keys = append(tries, trie), append(keys, trieKeys)
+	}
+	var nodes [][]byte
+	it := proofs.NewIterator(nil, nil)
+	for it.Next() {
+		nodes = append(nodes, common.CopyBytes(it.Value()))
+		size += len(it.Value())
+	}
+	it.Release()
+
+	proof, err := EncodeMultiProof(nodes)
+	if err != nil {
+		t.Fatalf("failed to encode multiproof: %v", err)
+	}
+	if len(proof) >= size {
+		t.Fatalf("multiproof not compact: %d bytes, nodes %d bytes", len(proof), size)
+	}
+	decoded, err := DecodeMultiProof(proof)
+	if err != nil {
+		t.Fatalf("failed to decode multiproof: %v", err)
+	}
+	if len(decoded) != len(nodes) {
+		t.Fatalf("node count mismatch: have %d, want %d", len(decoded), len(nodes))
+	}
+	db := memorydb.New()
+	for _, blob := range decoded {
+		if have, _ := proofs.Get(hashBlob(blob)); !bytes.Equal(have, blob) {
+			t.Fatalf("unexpected node decoded: %x", blob)
+		}
+		db.Put(hashBlob(blob), blob)
+	}
+	for i, trie := range tries {
+		for _, key := range keys[i] {
+			val, err := VerifyProof(trie.Hash(), key, db)
+			if err != nil {
+				t.Fatalf("trie %d: failed to verify key %x: %v", i, key, err)
+			}
+			if want := trie.Get(key); !bytes.Equal(val, want) {
+				t.Fatalf("trie %d: value mismatch for key %x: have %x, want %x", i, key, val, want)
+			}
+		}
+	}
+	// Corrupt proofs are rejected
+	if _, err := DecodeMultiProof(proof[:len(proof)-1]); err == nil {
+		t.Fatalf("truncated multiproof decoded")
+	}
+	if _, err := EncodeMultiProof([][]byte{{0x01, 0x02}}); err == nil {
+		t.Fatalf("invalid node encoded")
+	}
+}
+
+func hashBlob(blob []byte) []byte {
+	return newHasher(false).hashData(blob)
+}
@@ -0,0 +1,579 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/log"
+	"github.com/classzz/go-classzz-v2/metrics"
+	"github.com/classzz/go-classzz-v2/rlp"
+)
+
+var (
+	pathdbFlattenTimeTimer  = metrics.NewRegisteredResettingTimer("trie/pathdb/flatten/time", nil)
+	pathdbFlattenNodesMeter = metrics.NewRegisteredMeter("trie/pathdb/flatten/nodes", nil)
+	pathdbRecoverTimeTimer  = metrics.NewRegisteredResettingTimer("trie/pathdb/recover/time", nil)
+)
+
+const (
+	// pathLayerLimit is the number of state transitions kept in memory on top of
+	// the persisted state, before the oldest gets flattened into the disk. It
+	// mirrors the number of recent states a hash based full node keeps around.
+	pathLayerLimit = 128
+
+	// DefaultReverseDiffs is the number of reverse state diffs retained on disk
+	// for rewinding the persisted state if no explicit limit is configured.
+	DefaultReverseDiffs = 128
+)
+
+var (
+	// errPathUnsupported is returned by operations that are meaningless for the
+	// path based storage scheme.
+	errPathUnsupported = errors.New("not supported by the path scheme")
+
+	// errStateUnrecoverable is returned if a state to rewind to is neither live,
+	// nor reachable by the retained reverse diffs.
+	errStateUnrecoverable = errors.New("state is not recoverable")
+)
+
+// nodeKey identifies a trie node under the path scheme by the owner of the trie
+// (zero for the account trie, the account hash for storage tries) and the hex
+// path of the node inside it.
+type nodeKey struct {
+	owner common.Hash
+	path  string
+}
+
+// pathNode is a trie node change tracked under the path scheme. A nil blob
+// marks a node that got deleted.
+type pathNode struct {
+	hash common.Hash
+	blob []byte
+}
+
+// pathLayer is a set of trie node changes on top of a parent state, transitioning
+// it into a child state.
+type pathLayer struct {
+	root   common.Hash              // Root hash of the state this layer produces
+	parent common.Hash              // Root hash of the state this layer is applied on
+	nodes  map[nodeKey]*pathNode    // Trie nodes changed by the state transition
+	wipes  map[common.Hash]struct{} // Storage tries dropped before applying the nodes
+	size   common.StorageSize       // Approximate memory used by the layer
+}
+
+// indexedNode is a trie node blob referenced by one or more in-memory layers.
+type indexedNode struct {
+	blob []byte
+	refs int
+}
+
+// reverseDiff is the set of disk changes needed to undo the flattening of a
+// single layer, rewinding the persisted state from Root back to Parent.
+type reverseDiff struct {
+	Parent  common.Hash
+	Root    common.Hash
+	Entries []reverseDiffEntry
+}
+
+// reverseDiffEntry is the original content of a single trie node overwritten
+// by a flattened layer. An empty value means the node did not exist.
+type reverseDiffEntry struct {
+	Owner common.Hash
+	Path  []byte
+	Prev  []byte
+}
+
+// pathDB is the path based storage scheme of the trie database. The latest
+// persisted state is kept on disk with nodes keyed by their owner and path, so
+// every write overwrites the previous version of the node in place and stale
+// nodes never accumulate. Recent state transitions are kept in memory as layers
+// on top, flattened into the disk as they age; the flattening records a bounded
+// number of reverse diffs to allow rewinding the disk state on deep reorgs.
+type pathDB struct {
+	diskdb  czzdb.KeyValueStore // Persistent storage for the flattened state
+	limit   int                 // Number of layers to keep in memory
+	history uint64              // Number of reverse diffs to retain on disk
+
+	diskRoot common.Hash                              // Root hash of the persisted state
+	diffHead uint64                                   // Id of the latest stored reverse diff
+	layers   map[common.Hash]*pathLayer               // In-memory state transitions keyed by produced root
+	index    map[nodeKey]map[common.Hash]*indexedNode // Live node blobs of all the layers
+	size     common.StorageSize                       // Memory used by all the layers
+
+	pending      map[nodeKey]*pathNode    // Node changes committed, but not yet sealed into a layer
+	pendingWipes map[common.Hash]struct{} // Storage wipes committed, but not yet sealed into a layer
+
+	commitLock sync.Mutex // Lock serializing state commits, which share the pending changes
+	lock       sync.RWMutex
+}
+
+// newPathDB creates a path scheme database on top of the given disk store,
+// picking up the persisted state it contains.
+func newPathDB(diskdb czzdb.KeyValueStore, history uint64) *pathDB {
+	db := &pathDB{
+		diskdb:   diskdb,
+		limit:    pathLayerLimit,
+		history:  history,
+		diskRoot: diskStateRoot(diskdb),
+		diffHead: rawdb.ReadReverseDiffHead(diskdb),
+	}
+	db.reset()
+	return db
+}
+
+// diskStateRoot derives the root hash of the persisted state from its account
+// trie root node.
+func diskStateRoot(diskdb czzdb.KeyValueReader) common.Hash {
+	blob := rawdb.ReadAccountTrieNode(diskdb, nil)
+	if len(blob) == 0 {
+		return emptyRoot
+	}
+	return crypto.Keccak256Hash(blob)
+}
+
+// reset drops all in-memory layers and pending changes.
+func (db *pathDB) reset() {
+	db.layers = make(map[common.Hash]*pathLayer)
+	db.index = make(map[nodeKey]map[common.Hash]*indexedNode)
+	db.size = 0
+	db.pending = make(map[nodeKey]*pathNode)
+	db.pendingWipes = make(map[common.Hash]struct{})
+}
+
+// node retrieves the blob of the trie node with the given hash at the given
+// position, from the in-memory layers or the disk, also reporting whether it
+// was loaded from disk. Nil is returned if no such node is available.
+func (db *pathDB) node(owner common.Hash, path []byte, hash common.Hash) ([]byte, bool) {
+	key := nodeKey{owner: owner, path: string(path)}
+
+	db.lock.RLock()
+	if item := db.index[key][hash]; item != nil {
+		db.lock.RUnlock()
+		return item.blob, false
+	}
+	db.lock.RUnlock()
+
+	var blob []byte
+	if owner == (common.Hash{}) {
+		blob = rawdb.ReadAccountTrieNode(db.diskdb, path)
+	} else {
+		blob = rawdb.ReadStorageTrieNode(db.diskdb, owner, path)
+	}
+	if len(blob) == 0 || crypto.Keccak256Hash(blob) != hash {
+		return nil, false
+	}
+	return blob, true
+}
+
+// commit queues the node changes of a single trie for sealing into the next layer.
+func (db *pathDB) commit(owner common.Hash, nodes map[string]*pathNode) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for path, n := range nodes {
+		db.pending[nodeKey{owner: owner, path: path}] = n
+	}
+}
+
+// wipe queues the removal of an entire storage trie for sealing into the next
+// layer. It must be called before the new storage trie of the same account is
+// committed, as the wipe is applied before the node changes.
+func (db *pathDB) wipe(owner common.Hash) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.pendingWipes[owner] = struct{}{}
+}
+
+// update seals all pending changes into a new layer transitioning the parent
+// state into root, flattening the oldest layers into disk if too many are kept.
+func (db *pathDB) update(root, parent common.Hash) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	nodes, wipes := db.pending, db.pendingWipes
+	db.pending, db.pendingWipes = make(map[nodeKey]*pathNode), make(map[common.Hash]struct{})
+
+	// If the state is already known, the changes are a duplicate of it
+	if root == parent || root == db.diskRoot || db.layers[root] != nil {
+		return nil
+	}
+	if parent != db.diskRoot && db.layers[parent] == nil {
+		return fmt.Errorf("parent state %x unknown", parent)
+	}
+	layer := &pathLayer{
+		root:   root,
+		parent: parent,
+		nodes:  nodes,
+		wipes:  wipes,
+	}
+	for key, n := range nodes {
+		layer.size += common.StorageSize(common.HashLength + len(key.path) + len(n.blob))
+		if n.blob == nil {
+			continue
+		}
+		hashes := db.index[key]
+		if hashes == nil {
+			hashes = make(map[common.Hash]*indexedNode)
+			db.index[key] = hashes
+		}
+		if item := hashes[n.hash]; item != nil {
+			item.refs++
+		} else {
+			hashes[n.hash] = &indexedNode{blob: n.blob, refs: 1}
+		}
+	}
+	db.layers[root] = layer
+	db.size += layer.size
+
+	return db.cap(root, db.limit)
+}
+
+// cap flattens the oldest layers below the given state into the disk until no
+// more than limit layers are left on top of it.
+//
+// Note, this method assumes that the database's lock is held!
+func (db *pathDB) cap(root common.Hash, limit int) error {
+	var chain []*pathLayer
+	for layer := db.layers[root]; layer != nil; layer = db.layers[layer.parent] {
+		chain = append(chain, layer)
+	}
+	for len(chain) > limit {
+		if err := db.flatten(chain[len(chain)-1]); err != nil {
+			return err
+		}
+		chain = chain[:len(chain)-1]
+	}
+	return nil
+}
+
+// flatten writes a bottom-most layer into the disk, recording the reverse diff
+// needed to undo it. Any other layer built on the old disk state is discarded.
+//
+// Note, this method assumes that the database's lock is held!
+func (db *pathDB) flatten(layer *pathLayer) error {
+	if layer.parent != db.diskRoot {
+		return fmt.Errorf("layer %x not on disk state %x", layer.root, db.diskRoot)
+	}
+	start := time.Now()
+	batch := db.diskdb.NewBatch()
+	diff := &reverseDiff{Parent: layer.parent, Root: layer.root}
+
+	// Drop any wiped storage tries first, the node changes are applied on top.
+	// Both are iterated in a sorted order to keep the reverse diffs stable.
+	owners := make([]common.Hash, 0, len(layer.wipes))
+	for owner := range layer.wipes {
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool { return bytes.Compare(owners[i][:], owners[j][:]) < 0 })
+	for _, owner := range owners {
+		it := rawdb.IterateStorageTrieNodes(db.diskdb, owner)
+		for it.Next() {
+			path := common.CopyBytes(it.Key())
+			diff.Entries = append(diff.Entries, reverseDiffEntry{Owner: owner, Path: path, Prev: common.CopyBytes(it.Value())})
+			rawdb.DeleteStorageTrieNode(batch, owner, path)
+		}
+		it.Release()
+	}
+	keys := make([]nodeKey, 0, len(layer.nodes))
+	for key := range layer.nodes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].owner != keys[j].owner {
+			return bytes.Compare(keys[i].owner[:], keys[j].owner[:]) < 0
+		}
+		return keys[i].path < keys[j].path
+	})
+	for _, key := range keys {
+		n, path := layer.nodes[key], []byte(key.path)
+
+		var prev []byte
+		if key.owner == (common.Hash{}) {
+			prev = rawdb.ReadAccountTrieNode(db.diskdb, path)
+		} else {
+			prev = rawdb.ReadStorageTrieNode(db.diskdb, key.owner, path)
+		}
+		if len(prev) == 0 && n.blob == nil {
+			continue
+		}
+		diff.Entries = append(diff.Entries, reverseDiffEntry{Owner: key.owner, Path: path, Prev: prev})
+
+		switch {
+		case n.blob == nil && key.owner == (common.Hash{}):
+			rawdb.DeleteAccountTrieNode(batch, path)
+		case n.blob == nil:
+			rawdb.DeleteStorageTrieNode(batch, key.owner, path)
+		case key.owner == (common.Hash{}):
+			rawdb.WriteAccountTrieNode(batch, path, n.blob)
+		default:
+			rawdb.WriteStorageTrieNode(batch, key.owner, path, n.blob)
+		}
+	}
+	// Record the reverse diff and drop the ones beyond the retention limit
+	if db.history > 0 {
+		blob, err := rlp.EncodeToBytes(diff)
+		if err != nil {
+			return err
+		}
+		id := db.diffHead + 1
+		rawdb.WriteReverseDiff(batch, id, blob)
+		if id > db.history {
+			rawdb.DeleteReverseDiff(batch, id-db.history)
+		}
+		rawdb.WriteReverseDiffHead(batch, id)
+		db.diffHead = id
+	}
+	if err := batch.Write(); err != nil {
+		log.Error("Failed to flatten state layer", "root", layer.root, "err", err)
+		return err
+	}
+	// Layer persisted, move the disk state forward and discard the stale forks
+	old := db.diskRoot
+	db.diskRoot = layer.root
+	db.release(layer)
+
+	for _, sibling := range db.layers {
+		if sibling.parent == old {
+			db.discard(sibling)
+		}
+	}
+	pathdbFlattenTimeTimer.UpdateSince(start)
+	pathdbFlattenNodesMeter.Mark(int64(len(layer.nodes)))
+
+	log.Debug("Flattened state layer into disk", "root", layer.root, "nodes", len(layer.nodes), "wipes", len(layer.wipes),
+		"size", layer.size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// release removes a single layer from memory, dereferencing its nodes.
+//
+// Note, this method assumes that the database's lock is held!
+func (db *pathDB) release(layer *pathLayer) {
+	for key, n := range layer.nodes {
+		if n.blob == nil {
+			continue
+		}
+		hashes := db.index[key]
+		if item := hashes[n.hash]; item != nil {
+			if item.refs--; item.refs == 0 {
+				delete(hashes, n.hash)
+			}
+		}
+		if len(hashes) == 0 {
+			delete(db.index, key)
+		}
+	}
+	delete(db.layers, layer.root)
+	db.size -= layer.size
+}
+
+// discard removes a layer along with all the layers built on top of it.
+//
+// Note, this method assumes that the database's lock is held!
+func (db *pathDB) discard(layer *pathLayer) {
+	db.release(layer)
+	for _, child := range db.layers {
+		if child.parent == layer.root {
+			db.discard(child)
+		}
+	}
+}
+
+// persist flattens all the layers leading up to the given state into the disk.
+func (db *pathDB) persist(root common.Hash) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if root == db.diskRoot {
+		return nil
+	}
+	if db.layers[root] == nil {
+		return fmt.Errorf("state %x unknown", root)
+	}
+	return db.cap(root, 0)
+}
+
+// available reports whether the given state is live, either in memory or on disk.
+func (db *pathDB) available(root common.Hash) bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return root == db.diskRoot || db.layers[root] != nil
+}
+
+// readDiff retrieves and decodes the reverse diff with the given id.
+func (db *pathDB) readDiff(id uint64) (*reverseDiff, error) {
+	blob := rawdb.ReadReverseDiff(db.diskdb, id)
+	if len(blob) == 0 {
+		return nil, fmt.Errorf("reverse diff #%d missing", id)
+	}
+	diff := new(reverseDiff)
+	if err := rlp.DecodeBytes(blob, diff); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// recoverable reports whether the given state can be rewound to using the
+// retained reverse diffs.
+//
+// Note, this method assumes that the database's lock is held!
+func (db *pathDB) recoverable(root common.Hash) bool {
+	current := db.diskRoot
+	for id := db.diffHead; id > 0; id-- {
+		diff, err := db.readDiff(id)
+		if err != nil || diff.Root != current {
+			return false
+		}
+		if diff.Parent == root {
+			return true
+		}
+		current = diff.Parent
+	}
+	return false
+}
+
+// recover rewinds the persisted state to the given one by applying the reverse
+// diffs, dropping all in-memory layers. It's a no-op if the state is live.
+func (db *pathDB) recover(root common.Hash) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if root == db.diskRoot || db.layers[root] != nil {
+		return nil
+	}
+	if !db.recoverable(root) {
+		return errStateUnrecoverable
+	}
+	start := time.Now()
+	db.reset()
+
+	for db.diskRoot != root {
+		diff, err := db.readDiff(db.diffHead)
+		if err != nil {
+			return err
+		}
+		batch := db.diskdb.NewBatch()
+		for i := len(diff.Entries) - 1; i >= 0; i-- {
+			entry := diff.Entries[i]
+			switch {
+			case len(entry.Prev) == 0 && entry.Owner == (common.Hash{}):
+				rawdb.DeleteAccountTrieNode(batch, entry.Path)
+			case len(entry.Prev) == 0:
+				rawdb.DeleteStorageTrieNode(batch, entry.Owner, entry.Path)
+			case entry.Owner == (common.Hash{}):
+				rawdb.WriteAccountTrieNode(batch, entry.Path, entry.Prev)
+			default:
+				rawdb.WriteStorageTrieNode(batch, entry.Owner, entry.Path, entry.Prev)
+			}
+		}
+		rawdb.DeleteReverseDiff(batch, db.diffHead)
+		rawdb.WriteReverseDiffHead(batch, db.diffHead-1)
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		db.diskRoot, db.diffHead = diff.Parent, db.diffHead-1
+	}
+	pathdbRecoverTimeTimer.UpdateSince(start)
+	log.Info("Rewound persisted state", "root", root, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// memory returns the memory used by the in-memory layers.
+func (db *pathDB) memory() common.StorageSize {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return db.size
+}
+
+// WipeStorage schedules the removal of the entire storage trie of the given
+// account with the next committed state, needed when the account is deleted or
+// recreated. It must be called before the new storage trie of the account gets
+// committed. The method is a no-op under the hash scheme, where unreferenced
+// nodes are garbage collected instead.
+func (db *Database) WipeStorage(owner common.Hash) {
+	if db.path == nil {
+		return
+	}
+	db.path.wipe(owner)
+}
+
+// LockStateCommit serializes the commits of entire states under the path scheme,
+// where the node changes of the storage tries are gathered until the account
+// trie commit seals them into a new state. It must be held while committing the
+// tries of a state, the returned function releases it, discarding the changes
+// of an incomplete commit. The method is a no-op under the hash scheme.
+func (db *Database) LockStateCommit() func() {
+	if db == nil || db.path == nil {
+		return func() {}
+	}
+	db.path.commitLock.Lock()
+	return func() {
+		db.path.lock.Lock()
+		db.path.pending, db.path.pendingWipes = make(map[nodeKey]*pathNode), make(map[common.Hash]struct{})
+		db.path.lock.Unlock()
+
+		db.path.commitLock.Unlock()
+	}
+}
+
+// Recoverable reports whether the state with the given root can be restored by
+// Recover. It's always false under the hash scheme.
+func (db *Database) Recoverable(root common.Hash) bool {
+	if db.path == nil {
+		return false
+	}
+	if db.path.available(root) {
+		return true
+	}
+	db.path.lock.RLock()
+	defer db.path.lock.RUnlock()
+
+	return db.path.recoverable(root)
+}
+
+// Recover rewinds the persisted state to the one with the given root using the
+// retained reverse diffs, discarding all the states in memory. It's a no-op if
+// the state is still available and an error under the hash scheme.
+func (db *Database) Recover(root common.Hash) error {
+	if db.path == nil {
+		return errPathUnsupported
+	}
+	return db.path.recover(root)
+}
+
+// commitPath hands the node changes of a trie commit over to the path scheme,
+// sealing them into a new state layer if the trie is the account trie.
+func (db *Database) commitPath(owner common.Hash, nodes map[string]*pathNode, root, parent common.Hash) error {
+	db.path.commit(owner, nodes)
+	if owner != (common.Hash{}) {
+		return nil
+	}
+	return db.path.update(root, parent)
+}
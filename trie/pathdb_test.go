@@ -0,0 +1,360 @@
+// Copyright 2021 The go-classzz-v2 Authors
+// This file is part of the go-classzz-v2 library.
+//
+// The go-classzz-v2 library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-classzz-v2 library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-classzz-v2 library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
+	"github.com/classzz/go-classzz-v2/crypto"
+	"github.com/classzz/go-classzz-v2/czzdb"
+	"github.com/classzz/go-classzz-v2/czzdb/memorydb"
+)
+
+// checkPathNodes verifies that the disk holds exactly the standalone nodes of
+// the given trie under the path scheme, without any stale leftovers.
+func checkPathNodes(diskdb czzdb.Iteratee, tr *Trie, prefix []byte) error {
+	want := make(map[string]common.Hash)
+	it := tr.NodeIterator(nil)
+	for it.Next(true) {
+		if it.Hash() != (common.Hash{}) {
+			want[string(it.Path())] = it.Hash()
+		}
+	}
+	if it.Error() != nil {
+		return it.Error()
+	}
+	iter := diskdb.NewIterator(prefix, nil)
+	defer iter.Release()
+
+	have := 0
+	for iter.Next() {
+		path := string(iter.Key()[len(prefix):])
+		hash, ok := want[path]
+		if !ok {
+			return fmt.Errorf("stale node at path %x", path)
+		}
+		if crypto.Keccak256Hash(iter.Value()) != hash {
+			return fmt.Errorf("node mismatch at path %x", path)
+		}
+		have++
+	}
+	if have != len(want) {
+		return fmt.Errorf("node count mismatch: have %d, want %d", have, len(want))
+	}
+	return nil
+}
+
+// accountNodePrefix is the database prefix of the account trie nodes stored
+// under the path scheme.
+var accountNodePrefix = []byte("A")
+
+// storageNodePrefix returns the database prefix of the storage trie nodes of
+// the given account stored under the path scheme.
+func storageNodePrefix(owner common.Hash) []byte {
+	return append([]byte("O"), owner.Bytes()...)
+}
+
+// pathTestKey returns a deterministic trie key for the given index.
+func pathTestKey(i int) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(i))
+	return crypto.Keccak256(buf[:])
+}
+
+// Tests that the path scheme keeps the persisted state at a constant size, with
+// overwritten and deleted nodes removed from the disk.
+func TestPathNoStaleNodes(t *testing.T) {
+	var (
+		diskdb = memorydb.New()
+		triedb = newTestDatabase(diskdb, rawdb.PathScheme)
+		tr, _  = New(common.Hash{}, triedb)
+		rnd    = rand.New(rand.NewSource(1))
+	)
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 200; i++ {
+			key := pathTestKey(rnd.Intn(1000))
+			if rnd.Intn(3) == 0 {
+				tr.Delete(key)
+			} else {
+				tr.Update(key, pathTestKey(rnd.Int()))
+			}
+		}
+		root, err := tr.Commit(nil)
+		if err != nil {
+			t.Fatalf("round %d: failed to commit trie: %v", round, err)
+		}
+		if err := triedb.Commit(root, false, nil); err != nil {
+			t.Fatalf("round %d: failed to persist trie: %v", round, err)
+		}
+		if err := checkPathNodes(diskdb, tr, accountNodePrefix); err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+	}
+	// Delete everything and ensure the disk is emptied too
+	it := NewIterator(tr.NodeIterator(nil))
+	var keys [][]byte
+	for it.Next() {
+		keys = append(keys, common.CopyBytes(it.Key))
+	}
+	for _, key := range keys {
+		tr.Delete(key)
+	}
+	root, _ := tr.Commit(nil)
+	if root != emptyRoot {
+		t.Fatalf("root mismatch: have %x, want %x", root, emptyRoot)
+	}
+	if err := triedb.Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to persist empty trie: %v", err)
+	}
+	if err := checkPathNodes(diskdb, tr, accountNodePrefix); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Tests that only a limited number of states are kept in memory, the older ones
+// being flattened into the disk, and that forks of flattened states are dropped.
+func TestPathLayerFlattening(t *testing.T) {
+	var (
+		diskdb = memorydb.New()
+		triedb = newTestDatabase(diskdb, rawdb.PathScheme)
+		tr, _  = New(common.Hash{}, triedb)
+		roots  []common.Hash
+	)
+	tr.Update(pathTestKey(0), []byte{0x1})
+	root, _ := tr.Commit(nil)
+	roots = append(roots, root)
+
+	// Create a fork on top of the first state
+	fork, _ := New(root, triedb)
+	fork.Update(pathTestKey(1), []byte{0xff})
+	forkRoot, _ := fork.Commit(nil)
+
+	for i := 1; i <= pathLayerLimit+10; i++ {
+		tr.Update(pathTestKey(i), []byte{byte(i)})
+		root, err := tr.Commit(nil)
+		if err != nil {
+			t.Fatalf("state %d: failed to commit: %v", i, err)
+		}
+		roots = append(roots, root)
+	}
+	// The oldest states should be on disk, the recent ones in memory
+	if have, want := triedb.path.diskRoot, roots[10]; have != want {
+		t.Fatalf("disk root mismatch: have %x, want %x", have, want)
+	}
+	if have := len(triedb.path.layers); have != pathLayerLimit {
+		t.Fatalf("layer count mismatch: have %d, want %d", have, pathLayerLimit)
+	}
+	for i, root := range roots {
+		_, err := New(root, triedb)
+		if i < 10 && err == nil {
+			t.Errorf("state %d: flattened state still accessible", i)
+		}
+		if i >= 10 && err != nil {
+			t.Errorf("state %d: live state inaccessible: %v", i, err)
+		}
+	}
+	if _, err := New(forkRoot, triedb); err == nil {
+		t.Errorf("dropped fork still accessible")
+	}
+	// Reopen the database and ensure the persisted state is picked up
+	triedb = newTestDatabase(diskdb, rawdb.PathScheme)
+	tr, err := New(roots[10], triedb)
+	if err != nil {
+		t.Fatalf("failed to open persisted state: %v", err)
+	}
+	for i := 0; i <= 10; i++ {
+		if val, _ := tr.TryGet(pathTestKey(i)); !bytes.Equal(val, []byte{byte(i)}) && i > 0 {
+			t.Errorf("item %d: value mismatch: have %x", i, val)
+		}
+	}
+}
+
+// Tests that the persisted state can be rewound using the reverse diffs, but
+// only as deep as they are retained.
+func TestPathRecover(t *testing.T) {
+	var (
+		diskdb = memorydb.New()
+		triedb = NewDatabaseWithConfig(diskdb, &Config{Scheme: rawdb.PathScheme, ReverseDiffs: 16})
+		tr, _  = New(common.Hash{}, triedb)
+		roots  []common.Hash
+	)
+	for i := 0; i < 32; i++ {
+		tr.Update(pathTestKey(i%8), pathTestKey(i))
+		if i%5 == 0 {
+			tr.Delete(pathTestKey((i + 3) % 8))
+		}
+		root, err := tr.Commit(nil)
+		if err != nil {
+			t.Fatalf("state %d: failed to commit: %v", i, err)
+		}
+		if err := triedb.Commit(root, false, nil); err != nil {
+			t.Fatalf("state %d: failed to persist: %v", i, err)
+		}
+		roots = append(roots, root)
+	}
+	if triedb.Recoverable(roots[14]) {
+		t.Fatalf("state beyond the retained diffs reported recoverable")
+	}
+	if err := triedb.Recover(roots[14]); err == nil {
+		t.Fatalf("state beyond the retained diffs recovered")
+	}
+	for i := len(roots) - 2; i >= 15; i -= 4 {
+		if !triedb.Recoverable(roots[i]) {
+			t.Fatalf("state %d: not recoverable", i)
+		}
+		if err := triedb.Recover(roots[i]); err != nil {
+			t.Fatalf("state %d: failed to recover: %v", i, err)
+		}
+		// Reopen the database to ensure the disk holds the state
+		triedb = NewDatabaseWithConfig(diskdb, &Config{Scheme: rawdb.PathScheme, ReverseDiffs: 16})
+		tr, err := New(roots[i], triedb)
+		if err != nil {
+			t.Fatalf("state %d: failed to open: %v", i, err)
+		}
+		if err := checkPathNodes(diskdb, tr, accountNodePrefix); err != nil {
+			t.Fatalf("state %d: %v", i, err)
+		}
+		if _, err := New(roots[i+1], triedb); err == nil {
+			t.Fatalf("state %d: rewound state still accessible", i+1)
+		}
+	}
+}
+
+// Tests that wiping a storage trie drops all its nodes from the disk, and that
+// rewinding the state restores them.
+func TestPathWipeStorage(t *testing.T) {
+	var (
+		diskdb = memorydb.New()
+		triedb = newTestDatabase(diskdb, rawdb.PathScheme)
+		owner  = common.HexToHash("0x01")
+		acc, _ = New(common.Hash{}, triedb)
+		st, _  = NewWithOwner(owner, common.Hash{}, triedb)
+	)
+	for i := 0; i < 100; i++ {
+		st.Update(pathTestKey(i), pathTestKey(i))
+	}
+	stRoot, _ := st.Commit(nil)
+	acc.Update(owner.Bytes(), stRoot.Bytes())
+	root, _ := acc.Commit(nil)
+	if err := triedb.Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to persist state: %v", err)
+	}
+	if err := checkPathNodes(diskdb, st, storageNodePrefix(owner)); err != nil {
+		t.Fatal(err)
+	}
+	// Delete the account along with its storage
+	triedb.WipeStorage(owner)
+	acc.Delete(owner.Bytes())
+	acc.Update(common.HexToHash("0x02").Bytes(), []byte{0x1})
+	wiped, _ := acc.Commit(nil)
+	if err := triedb.Commit(wiped, false, nil); err != nil {
+		t.Fatalf("failed to persist state: %v", err)
+	}
+	it := diskdb.NewIterator(storageNodePrefix(owner), nil)
+	if it.Next() {
+		t.Fatalf("storage node left after wipe: %x", it.Key())
+	}
+	it.Release()
+
+	// Rewind the state and ensure the storage is restored
+	if err := triedb.Recover(root); err != nil {
+		t.Fatalf("failed to recover state: %v", err)
+	}
+	triedb = newTestDatabase(diskdb, rawdb.PathScheme)
+	st, err := NewWithOwner(owner, stRoot, triedb)
+	if err != nil {
+		t.Fatalf("failed to open restored storage: %v", err)
+	}
+	if err := checkPathNodes(diskdb, st, storageNodePrefix(owner)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Tests that concurrent state commits don't mix up their node changes, with each
+// state sealing exactly its own storage tries.
+func TestPathConcurrentCommits(t *testing.T) {
+	var (
+		diskdb = memorydb.New()
+		triedb = newTestDatabase(diskdb, rawdb.PathScheme)
+		owners = []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02")}
+		roots  = make([]common.Hash, len(owners))
+		stores = make([]*Trie, len(owners))
+		errs   = make(chan error, len(owners))
+	)
+	for i, owner := range owners {
+		go func(i int, owner common.Hash) {
+			var stRoot common.Hash
+			for n := 0; n < 10; n++ {
+				release := triedb.LockStateCommit()
+				st, err := NewWithOwner(owner, stRoot, triedb)
+				if err != nil {
+					release()
+					errs <- err
+					return
+				}
+				acc, err := New(roots[i], triedb)
+				if err != nil {
+					release()
+					errs <- err
+					return
+				}
+				for j := 0; j < 20; j++ {
+					st.Update(pathTestKey(n*20+j), pathTestKey(i))
+				}
+				stRoot, _ = st.Commit(nil)
+				acc.Update(owner.Bytes(), stRoot.Bytes())
+				roots[i], _ = acc.Commit(nil)
+				stores[i] = st
+				release()
+			}
+			errs <- nil
+		}(i, owner)
+	}
+	for range owners {
+		if err := <-errs; err != nil {
+			t.Fatalf("failed to commit state: %v", err)
+		}
+	}
+	if err := triedb.Commit(roots[0], false, nil); err != nil {
+		t.Fatalf("failed to persist state: %v", err)
+	}
+	if err := checkPathNodes(diskdb, stores[0], storageNodePrefix(owners[0])); err != nil {
+		t.Fatal(err)
+	}
+	it := diskdb.NewIterator(storageNodePrefix(owners[1]), nil)
+	if it.Next() {
+		t.Fatalf("storage node of another state persisted: %x", it.Key())
+	}
+	it.Release()
+
+	// Ensure the persisted storage is complete from a fresh database
+	st, err := NewWithOwner(owners[0], stores[0].Hash(), newTestDatabase(diskdb, rawdb.PathScheme))
+	if err != nil {
+		t.Fatalf("failed to open persisted storage: %v", err)
+	}
+	for n := 0; n < 200; n++ {
+		if have := st.Get(pathTestKey(n)); !bytes.Equal(have, pathTestKey(0)) {
+			t.Fatalf("storage slot %d mismatch: have %x", n, have)
+		}
+	}
+}
@@ -52,10 +52,31 @@ type SecureTrie struct {
 // A new cache generation is created by each call to Commit.
 // cachelimit sets the number of past cache generations to keep.
 func NewSecure(root common.Hash, db *Database) (*SecureTrie, error) {
+	return NewSecureWithOwner(common.Hash{}, root, db)
+}
+
+// NewSecureWithOwner creates a secure trie with an existing root node from a
+// backing database, owned by the given account. The owner is only relevant for
+// the path based storage scheme, see NewWithOwner.
+func NewSecureWithOwner(owner common.Hash, root common.Hash, db *Database) (*SecureTrie, error) {
+	if db == nil {
+		panic("trie.NewSecure called without a database")
+	}
+	trie, err := NewWithOwner(owner, root, db)
+	if err != nil {
+		return nil, err
+	}
+	return &SecureTrie{trie: *trie}, nil
+}
+
+// NewSecureWithRecorder creates a secure trie with an existing root node from a
+// backing database, owned by the given account, notifying the recorder of every
+// node resolved from the database, see NewWithRecorder.
+func NewSecureWithRecorder(owner common.Hash, root common.Hash, db *Database, recorder NodeRecorder) (*SecureTrie, error) {
 	if db == nil {
 		panic("trie.NewSecure called without a database")
 	}
-	trie, err := New(root, db)
+	trie, err := NewWithRecorder(owner, root, db, recorder)
 	if err != nil {
 		return nil, err
 	}
@@ -169,6 +190,7 @@ func (t *SecureTrie) Hash() common.Hash {
 // Copy returns a copy of SecureTrie.
 func (t *SecureTrie) Copy() *SecureTrie {
 	cpy := *t
+	cpy.trie = t.trie.copy()
 	return &cpy
 }
 
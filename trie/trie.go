@@ -64,6 +64,22 @@ type Trie struct {
 	// hashing operation. This number will not directly map to the number of
 	// actually unhashed nodes
 	unhashed int
+
+	// Fields used by the path based storage scheme only, identifying the trie
+	// nodes by their position instead of their hash.
+	owner      common.Hash         // Account hash owning a storage trie, zero for the account trie
+	originRoot common.Hash         // Root hash of the trie at the last commit
+	deletes    map[string]struct{} // Paths of the nodes removed since the last commit
+
+	recorder NodeRecorder // Optional recorder notified of the nodes resolved from the database
+}
+
+// NodeRecorder is notified of every trie node resolved from the database, which
+// allows collecting the witness of the accesses made to a trie.
+type NodeRecorder interface {
+	// RecordNode is called with the hash and the encoding of a resolved node. It
+	// may be invoked concurrently by copies of the same trie.
+	RecordNode(hash common.Hash, blob []byte)
 }
 
 // newFlag returns the cache flag value for a newly created node.
@@ -78,13 +94,35 @@ func (t *Trie) newFlag() nodeFlag {
 // New will panic if db is nil and returns a MissingNodeError if root does
 // not exist in the database. Accessing the trie loads nodes from db on demand.
 func New(root common.Hash, db *Database) (*Trie, error) {
+	return NewWithOwner(common.Hash{}, root, db)
+}
+
+// NewWithOwner creates a trie with an existing root node from db, owned by the
+// given account. The owner is only relevant for the path based storage scheme,
+// where it is the account hash of storage tries and zero for the account trie.
+func NewWithOwner(owner common.Hash, root common.Hash, db *Database) (*Trie, error) {
+	return NewWithRecorder(owner, root, db, nil)
+}
+
+// NewWithRecorder creates a trie with an existing root node from db, owned by
+// the given account, notifying the recorder of every node it resolves from the
+// database, including the root.
+func NewWithRecorder(owner common.Hash, root common.Hash, db *Database, recorder NodeRecorder) (*Trie, error) {
 	if db == nil {
 		panic("trie.New called without a database")
 	}
 	trie := &Trie{
-		db: db,
+		db:         db,
+		owner:      owner,
+		originRoot: emptyRoot,
+		recorder:   recorder,
+	}
+	if db.path != nil {
+		trie.deletes = make(map[string]struct{})
 	}
 	if root != (common.Hash{}) && root != emptyRoot {
+		trie.originRoot = root
+
 		rootnode, err := trie.resolveHash(root[:], nil)
 		if err != nil {
 			return nil, err
@@ -188,8 +226,15 @@ func (t *Trie) tryGetNode(origNode node, path []byte, pos int) (item []byte, new
 		if hash == nil {
 			return nil, origNode, 0, errors.New("non-consensus node")
 		}
-		blob, err := t.db.Node(common.BytesToHash(hash))
-		return blob, origNode, 1, err
+		if t.db.path == nil {
+			blob, err := t.db.Node(common.BytesToHash(hash))
+			return blob, origNode, 1, err
+		}
+		blob := t.db.nodeBlobAt(t.owner, path, common.BytesToHash(hash))
+		if blob == nil {
+			return nil, origNode, 1, errors.New("not found")
+		}
+		return blob, origNode, 1, nil
 	}
 	// Path still needs to be traversed, descend into children
 	switch n := (origNode).(type) {
@@ -373,6 +418,7 @@ func (t *Trie) delete(n node, prefix, key []byte) (bool, node, error) {
 			return false, n, nil // don't replace n on mismatch
 		}
 		if matchlen == len(key) {
+			t.onDelete(prefix)
 			return true, nil, nil // remove n entirely for whole matches
 		}
 		// The key is longer than n.Key. Remove the remaining suffix
@@ -391,6 +437,7 @@ func (t *Trie) delete(n node, prefix, key []byte) (bool, node, error) {
 			// always creates a new slice) instead of append to
 			// avoid modifying n.Key since it might be shared with
 			// other nodes.
+			t.onDelete(append(prefix, n.Key...))
 			return true, &shortNode{concat(n.Key, child.Key...), child.Val, t.newFlag()}, nil
 		default:
 			return true, &shortNode{n.Key, child, t.newFlag()}, nil
@@ -441,11 +488,16 @@ func (t *Trie) delete(n node, prefix, key []byte) (bool, node, error) {
 				// shortNode{..., shortNode{...}}.  Since the entry
 				// might not be loaded yet, resolve it just for this
 				// check.
-				cnode, err := t.resolve(n.Children[pos], prefix)
+				cnode, err := t.resolve(n.Children[pos], append(prefix, byte(pos)))
 				if err != nil {
 					return false, nil, err
 				}
 				if cnode, ok := cnode.(*shortNode); ok {
+					// Replace the entire full node with the short node.
+					// Mark the original short node as deleted since the
+					// value is embedded into the parent now.
+					t.onDelete(append(prefix, byte(pos)))
+
 					k := append([]byte{byte(pos)}, cnode.Key...)
 					return true, &shortNode{k, cnode.Val, t.newFlag()}, nil
 				}
@@ -482,6 +534,14 @@ func (t *Trie) delete(n node, prefix, key []byte) (bool, node, error) {
 	}
 }
 
+// onDelete tracks the removal of the trie node at the given path, needed by the
+// path based storage scheme to drop it from the database on commit.
+func (t *Trie) onDelete(path []byte) {
+	if t.deletes != nil {
+		t.deletes[string(path)] = struct{}{}
+	}
+}
+
 func concat(s1 []byte, s2 ...byte) []byte {
 	r := make([]byte, len(s1)+len(s2))
 	copy(r, s1)
@@ -498,7 +558,14 @@ func (t *Trie) resolve(n node, prefix []byte) (node, error) {
 
 func (t *Trie) resolveHash(n hashNode, prefix []byte) (node, error) {
 	hash := common.BytesToHash(n)
-	if node := t.db.node(hash); node != nil {
+	if t.recorder != nil {
+		if blob := t.db.nodeBlobAt(t.owner, prefix, hash); blob != nil {
+			t.recorder.RecordNode(hash, blob)
+			return mustDecodeNode(hash[:], blob), nil
+		}
+		return nil, &MissingNodeError{NodeHash: hash, Path: prefix}
+	}
+	if node := t.db.nodeAt(t.owner, prefix, hash); node != nil {
 		return node, nil
 	}
 	return nil, &MissingNodeError{NodeHash: hash, Path: prefix}
@@ -519,7 +586,7 @@ func (t *Trie) Commit(onleaf LeafCallback) (root common.Hash, err error) {
 		panic("commit called on trie with nil database")
 	}
 	if t.root == nil {
-		return emptyRoot, nil
+		return emptyRoot, t.commitPath(nil, emptyRoot)
 	}
 	// Derive the hash for all dirty nodes first. We hold the assumption
 	// in the following procedure that all nodes are hashed.
@@ -531,7 +598,10 @@ func (t *Trie) Commit(onleaf LeafCallback) (root common.Hash, err error) {
 	// up goroutines. This can happen e.g. if we load a trie for reading storage
 	// values, but don't write to it.
 	if _, dirty := t.root.cache(); !dirty {
-		return rootHash, nil
+		return rootHash, t.commitPath(nil, rootHash)
+	}
+	if t.db.path != nil {
+		h.nodes = make(map[string]*pathNode)
 	}
 	var wg sync.WaitGroup
 	if onleaf != nil {
@@ -556,10 +626,48 @@ func (t *Trie) Commit(onleaf LeafCallback) (root common.Hash, err error) {
 	if err != nil {
 		return common.Hash{}, err
 	}
+	if err := t.commitPath(h.nodes, rootHash); err != nil {
+		return common.Hash{}, err
+	}
 	t.root = newRoot
 	return rootHash, nil
 }
 
+// commitPath hands the committed nodes along with the tracked deletions over to
+// the path based storage scheme. It's a no-op under the hash scheme.
+func (t *Trie) commitPath(nodes map[string]*pathNode, root common.Hash) error {
+	if t.db.path == nil {
+		return nil
+	}
+	if nodes == nil {
+		nodes = make(map[string]*pathNode)
+	}
+	for path := range t.deletes {
+		if _, ok := nodes[path]; !ok {
+			nodes[path] = &pathNode{}
+		}
+	}
+	t.deletes = make(map[string]struct{})
+
+	if err := t.db.commitPath(t.owner, nodes, root, t.originRoot); err != nil {
+		return err
+	}
+	t.originRoot = root
+	return nil
+}
+
+// copy returns a copy of the trie, with its own deletion tracking.
+func (t *Trie) copy() Trie {
+	cpy := *t
+	if t.deletes != nil {
+		cpy.deletes = make(map[string]struct{}, len(t.deletes))
+		for path := range t.deletes {
+			cpy.deletes[path] = struct{}{}
+		}
+	}
+	return cpy
+}
+
 // hashRoot calculates the root hash of the given trie
 func (t *Trie) hashRoot() (node, node, error) {
 	if t.root == nil {
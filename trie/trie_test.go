@@ -31,6 +31,7 @@ import (
 	"testing/quick"
 
 	"github.com/classzz/go-classzz-v2/common"
+	"github.com/classzz/go-classzz-v2/core/rawdb"
 	"github.com/classzz/go-classzz-v2/crypto"
 	"github.com/classzz/go-classzz-v2/czzdb"
 	"github.com/classzz/go-classzz-v2/czzdb/leveldb"
@@ -45,12 +46,25 @@ func init() {
 	spew.Config.DisableMethods = false
 }
 
+// testSchemes are the node storage schemes the scheme agnostic tests run with.
+var testSchemes = []string{rawdb.HashScheme, rawdb.PathScheme}
+
+// newTestDatabase creates a trie database using the given node storage scheme.
+func newTestDatabase(diskdb czzdb.KeyValueStore, scheme string) *Database {
+	return NewDatabaseWithConfig(diskdb, &Config{Preimages: true, Scheme: scheme})
+}
+
 // Used for testing
 func newEmpty() *Trie {
 	trie, _ := New(common.Hash{}, NewDatabase(memorydb.New()))
 	return trie
 }
 
+func newEmptyWithScheme(scheme string) *Trie {
+	trie, _ := New(common.Hash{}, newTestDatabase(memorydb.New(), scheme))
+	return trie
+}
+
 func TestEmptyTrie(t *testing.T) {
 	var trie Trie
 	res := trie.Hash()
@@ -182,7 +196,13 @@ func TestInsert(t *testing.T) {
 }
 
 func TestGet(t *testing.T) {
-	trie := newEmpty()
+	for _, scheme := range testSchemes {
+		testGet(t, scheme)
+	}
+}
+
+func testGet(t *testing.T, scheme string) {
+	trie := newEmptyWithScheme(scheme)
 	updateString(trie, "doe", "reindeer")
 	updateString(trie, "dog", "puppy")
 	updateString(trie, "dogglesworth", "cat")
@@ -190,12 +210,12 @@ func TestGet(t *testing.T) {
 	for i := 0; i < 2; i++ {
 		res := getString(trie, "dog")
 		if !bytes.Equal(res, []byte("puppy")) {
-			t.Errorf("expected puppy got %x", res)
+			t.Errorf("%s: expected puppy got %x", scheme, res)
 		}
 
 		unknown := getString(trie, "unknown")
 		if unknown != nil {
-			t.Errorf("expected nil got %x", unknown)
+			t.Errorf("%s: expected nil got %x", scheme, unknown)
 		}
 
 		if i == 1 {
@@ -257,7 +277,13 @@ func TestEmptyValues(t *testing.T) {
 }
 
 func TestReplication(t *testing.T) {
-	trie := newEmpty()
+	for _, scheme := range testSchemes {
+		testReplication(t, scheme)
+	}
+}
+
+func testReplication(t *testing.T, scheme string) {
+	trie := newEmptyWithScheme(scheme)
 	vals := []struct{ k, v string }{
 		{"do", "verb"},
 		{"ether", "wookiedoo"},
@@ -373,6 +399,7 @@ const (
 	opHash
 	opReset
 	opItercheckhash
+	opPersist
 	opMax // boundary value, not an actual op
 )
 
@@ -407,7 +434,17 @@ func (randTest) Generate(r *rand.Rand, size int) reflect.Value {
 }
 
 func runRandTest(rt randTest) bool {
-	triedb := NewDatabase(memorydb.New())
+	for _, scheme := range testSchemes {
+		if !runRandTestWithScheme(rt, scheme) {
+			return false
+		}
+	}
+	return true
+}
+
+func runRandTestWithScheme(rt randTest, scheme string) bool {
+	diskdb := memorydb.New()
+	triedb := newTestDatabase(diskdb, scheme)
 
 	tr, _ := New(common.Hash{}, triedb)
 	values := make(map[string]string) // tracks content of the trie
@@ -453,6 +490,27 @@ func runRandTest(rt randTest) bool {
 			if tr.Hash() != checktr.Hash() {
 				rt[i].err = fmt.Errorf("hash mismatch in opItercheckhash")
 			}
+		case opPersist:
+			// Flush the trie into disk and reload it without any memory cache
+			hash, err := tr.Commit(nil)
+			if err != nil {
+				rt[i].err = err
+				return false
+			}
+			if err := triedb.Commit(hash, false, nil); err != nil {
+				rt[i].err = err
+				return false
+			}
+			triedb = newTestDatabase(diskdb, scheme)
+			newtr, err := New(hash, triedb)
+			if err != nil {
+				rt[i].err = err
+				return false
+			}
+			tr = newtr
+			if scheme == rawdb.PathScheme {
+				rt[i].err = checkPathNodes(diskdb, tr, accountNodePrefix)
+			}
 		}
 		// Abort the test on error.
 		if rt[i].err != nil {
@@ -1091,3 +1149,56 @@ func TestDecodeNode(t *testing.T) {
 		decodeNode(hash, elems)
 	}
 }
+
+// memoryRecorder collects the recorded trie nodes into a key-value store.
+type memoryRecorder struct {
+	db *memorydb.Database
+}
+
+func (r *memoryRecorder) RecordNode(hash common.Hash, blob []byte) {
+	r.db.Put(hash[:], blob)
+}
+
+// Tests that the nodes recorded while accessing a trie are enough to repeat the
+// same accesses without the database, including the deletions collapsing nodes.
+func TestNodeRecorder(t *testing.T) {
+	triedb := NewDatabase(memorydb.New())
+	trie, _ := New(common.Hash{}, triedb)
+
+	key := func(i int) []byte { return crypto.Keccak256([]byte(fmt.Sprintf("key-%d", i))) }
+	for i := 0; i < 1000; i++ {
+		trie.Update(key(i), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	root, _ := trie.Commit(nil)
+
+	access := func(tr *Trie) common.Hash {
+		for i := 0; i < 1000; i += 97 {
+			if _, err := tr.TryGet(key(i)); err != nil {
+				t.Fatalf("failed to retrieve key %d: %v", i, err)
+			}
+		}
+		if err := tr.TryUpdate(key(5), []byte("updated")); err != nil {
+			t.Fatalf("failed to update key: %v", err)
+		}
+		for i := 1; i < 1000; i += 3 {
+			if err := tr.TryDelete(key(i)); err != nil {
+				t.Fatalf("failed to delete key %d: %v", i, err)
+			}
+		}
+		return tr.Hash()
+	}
+	recorder := &memoryRecorder{db: memorydb.New()}
+	recorded, err := NewWithRecorder(common.Hash{}, root, triedb, recorder)
+	if err != nil {
+		t.Fatalf("failed to open trie: %v", err)
+	}
+	want := access(recorded)
+
+	replayed, err := New(root, NewDatabase(recorder.db))
+	if err != nil {
+		t.Fatalf("failed to open recorded trie: %v", err)
+	}
+	if have := access(replayed); have != want {
+		t.Fatalf("root mismatch: have %x, want %x", have, want)
+	}
+}